@@ -0,0 +1,126 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// SetAcceptedHeaderFormats sets the formats of the trace headers that the
+// gRPC server interceptors using c accept.  The formats are tried in the
+// order given, and the first that is present in an incoming call is read;
+// a HeaderFormat with several formats is tried in the order of their
+// constants.  The formats replace the propagators of WithHeaderFormats and
+// WithPropagators for extraction, but not for the client interceptors.
+// Calling SetAcceptedHeaderFormats with no formats restores the default,
+// in which the interceptors' propagators are used.
+//
+// A call that carries a header in a format that this package knows but
+// that isn't accepted, for example a traceparent header sent to a server
+// that accepts only GoogleHeader, is counted in the ForeignHeaders
+// statistic.  Under SetStrictValidation, a *ForeignHeaderError is also
+// passed to the error handler for each such format, so that a client that
+// sends the wrong format shows up quickly:
+//
+//   tc.SetAcceptedHeaderFormats(trace.GoogleHeader)
+//   tc.SetStrictValidation(true)
+func (c *Client) SetAcceptedHeaderFormats(formats ...HeaderFormat) {
+	if c == nil {
+		return
+	}
+	var accepted []HeaderFormat
+	var seen HeaderFormat
+	for _, f := range formats {
+		for _, hf := range headerFormats {
+			if f&hf.format != 0 && seen&hf.format == 0 {
+				accepted = append(accepted, hf.format)
+				seen |= hf.format
+			}
+		}
+	}
+	c.acceptedFormats = accepted
+}
+
+// acceptedHeaderFormats returns the formats set by SetAcceptedHeaderFormats,
+// in order, or nil.
+func (c *Client) acceptedHeaderFormats() []HeaderFormat {
+	if c == nil {
+		return nil
+	}
+	return c.acceptedFormats
+}
+
+// acceptedPropagators returns the propagators of formats, in order.
+func acceptedPropagators(formats []HeaderFormat) []Propagator {
+	var ps []Propagator
+	for _, f := range formats {
+		for _, hf := range headerFormats {
+			if hf.format == f {
+				ps = append(ps, hf.propagator)
+			}
+		}
+	}
+	return ps
+}
+
+// A ForeignHeaderError reports an incoming call that carried a trace header
+// in a format the client doesn't accept.  See SetAcceptedHeaderFormats.
+type ForeignHeaderError struct {
+	Format HeaderFormat // the format of the header.
+	Peer   string       // the address of the caller, or "" if unknown.
+}
+
+func (e *ForeignHeaderError) Error() string {
+	from := ""
+	if e.Peer != "" {
+		from = " from " + e.Peer
+	}
+	return fmt.Sprintf("trace: call%s has a %v trace header, which is not an accepted format", from, e.Format)
+}
+
+// checkForeignHeaders counts and, in strict mode, reports the trace headers
+// in md, the metadata of an incoming call with context ctx, whose formats
+// are not in accepted.
+func (c *Client) checkForeignHeaders(ctx context.Context, md metadata.MD, accepted []HeaderFormat) {
+	var ok HeaderFormat
+	for _, f := range accepted {
+		ok |= f
+	}
+	foreign := false
+	for _, hf := range headerFormats {
+		if ok&hf.format != 0 {
+			continue
+		}
+		if _, err := hf.propagator.Extract(MetadataCarrier(md)); err == ErrNoHeader {
+			continue
+		}
+		foreign = true
+		if c.strict {
+			err := &ForeignHeaderError{Format: hf.format}
+			if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+				err.Peer = p.Addr.String()
+			}
+			c.reportError(err)
+		}
+	}
+	if foreign {
+		atomic.AddInt64(&c.stats.foreignHeaders, 1)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Trace IDs of the headers of each format, so that tests can tell which was
+// read.
+var formatTraceIDs = map[HeaderFormat]string{
+	GoogleHeader:      "11111111111111111111111111111111",
+	TraceparentHeader: "22222222222222222222222222222222",
+	B3Header:          "33333333333333333333333333333333",
+	B3SingleHeader:    "44444444444444444444444444444444",
+}
+
+// formatMetadata returns incoming metadata with a trace header in each of
+// formats.
+func formatMetadata(formats HeaderFormat) metadata.MD {
+	md := metadata.MD{}
+	for _, hf := range headerFormats {
+		if formats&hf.format != 0 {
+			hf.propagator.Inject(SpanContext{TraceID: formatTraceIDs[hf.format], SpanID: 7, Traced: true}, MetadataCarrier(md))
+		}
+	}
+	return md
+}
+
+func TestAcceptedHeaderFormats(t *testing.T) {
+	for _, tt := range []struct {
+		desc        string
+		accepted    []HeaderFormat // nil for the default.
+		sent        HeaderFormat
+		want        HeaderFormat // the format read, or 0 for none.
+		wantForeign []HeaderFormat
+	}{
+		{desc: "default", sent: GoogleHeader | TraceparentHeader, want: GoogleHeader},
+		{desc: "default ignores traceparent", sent: TraceparentHeader},
+		{desc: "google only", accepted: []HeaderFormat{GoogleHeader}, sent: GoogleHeader, want: GoogleHeader},
+		{desc: "traceparent to google only", accepted: []HeaderFormat{GoogleHeader}, sent: TraceparentHeader, wantForeign: []HeaderFormat{TraceparentHeader}},
+		{desc: "both to google only", accepted: []HeaderFormat{GoogleHeader}, sent: GoogleHeader | TraceparentHeader, want: GoogleHeader, wantForeign: []HeaderFormat{TraceparentHeader}},
+		{desc: "in order", accepted: []HeaderFormat{TraceparentHeader, GoogleHeader}, sent: GoogleHeader | TraceparentHeader, want: TraceparentHeader},
+		{desc: "falls back", accepted: []HeaderFormat{TraceparentHeader, GoogleHeader}, sent: GoogleHeader, want: GoogleHeader},
+		{desc: "combined formats", accepted: []HeaderFormat{B3SingleHeader | B3Header}, sent: B3SingleHeader | B3Header, want: B3Header},
+		{desc: "b3 to traceparent only", accepted: []HeaderFormat{TraceparentHeader}, sent: B3Header | B3SingleHeader | GoogleHeader, wantForeign: []HeaderFormat{GoogleHeader, B3Header, B3SingleHeader}},
+	} {
+		for _, strict := range []bool{false, true} {
+			tc := NewClientWithExporter(&recordingExporter{})
+			tc.SetAcceptedHeaderFormats(tt.accepted...)
+			tc.SetStrictValidation(strict)
+			var errs []error
+			tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+			ctx := metadata.NewIncomingContext(context.Background(), formatMetadata(tt.sent))
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4321}})
+			var got *Span
+			_, err := GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				got = FromContext(ctx)
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == 0 && got != nil {
+				t.Errorf("%s: read trace %s; want none", tt.desc, got.TraceID())
+			} else if tt.want != 0 && (got == nil || got.TraceID() != formatTraceIDs[tt.want]) {
+				t.Errorf("%s: read span %v; want trace %s of the %v header", tt.desc, got, formatTraceIDs[tt.want], tt.want)
+			}
+
+			wantCount := int64(0)
+			if len(tt.wantForeign) > 0 {
+				wantCount = 1
+			}
+			if got := tc.Stats().ForeignHeaders; got != wantCount {
+				t.Errorf("%s: ForeignHeaders = %d; want %d", tt.desc, got, wantCount)
+			}
+			var wantErrs []error
+			if strict {
+				for _, f := range tt.wantForeign {
+					wantErrs = append(wantErrs, &ForeignHeaderError{Format: f, Peer: "10.0.0.1:4321"})
+				}
+			}
+			if !reflect.DeepEqual(errs, wantErrs) {
+				t.Errorf("%s, strict=%t: reported %v; want %v", tt.desc, strict, errs, wantErrs)
+			}
+		}
+	}
+}
+
+func TestForeignHeaderStream(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetAcceptedHeaderFormats(GoogleHeader)
+	tc.SetStrictValidation(true)
+	var errs []error
+	tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	ctx := metadata.NewIncomingContext(context.Background(), formatMetadata(TraceparentHeader))
+	err := GRPCStreamServerInterceptor(tc)(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []error{&ForeignHeaderError{Format: TraceparentHeader}}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("reported %v; want %v", errs, want)
+	}
+	if got := errs[0].Error(); got != "trace: call has a traceparent trace header, which is not an accepted format" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestHeaderFormatString(t *testing.T) {
+	for f, want := range map[HeaderFormat]string{
+		GoogleHeader:                       "google",
+		TraceparentHeader | B3SingleHeader: "traceparent|b3single",
+		B3Header | 64:                      "b3|HeaderFormat(64)",
+		0:                                  "HeaderFormat(0)",
+	} {
+		if got := f.String(); got != want {
+			t.Errorf("HeaderFormat(%d).String() = %q; want %q", int(f), got, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+type withAlwaysTrace struct{}
+
+// AlwaysTrace returns an InterceptorOption that makes the server
+// interceptors start a new trace for incoming calls that have no trace
+// header, instead of leaving them untraced.  The root span of the new
+// trace is named after the method of the call.  It is traced unless the
+// client has a sampling policy, which then decides, as for a span created
+// with NewSpan.  Like the span read from a header, it is in the context
+// passed to the handler, so outgoing calls made with that context propagate
+// the trace.
+//
+// Without AlwaysTrace, calls without a header are not traced, and
+// FromContext returns nil in their handlers.
+func AlwaysTrace() InterceptorOption {
+	return withAlwaysTrace{}
+}
+
+func (withAlwaysTrace) configureInterceptor(c *interceptorConfig) {
+	c.alwaysTrace = true
+}
+
+// newServerRootSpan returns the root span of a new trace for an incoming
+// call, named name, that had no trace header.
+func (c *Client) newServerRootSpan(name string) *Span {
+	return c.newRootSpan(name, spanKindServer)
+}
+
+// newRootSpan returns the root span of a new trace, named name, of the
+// given kind, traced if the client's sampling policy decides so.
+func (c *Client) newRootSpan(name, kind string) *Span {
+	if c == nil {
+		return nil
+	}
+	t := &trace{
+		traceID: c.newTraceID(),
+		client:  c,
+	}
+	span := startNewChild(name, t, 0, traceOptions{global: optionTrace, local: optionTrace})
+	span.span.Kind = kind
+	span.rootSpan = true
+	c.startRoot(span, false)
+	return span
+}
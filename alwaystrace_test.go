@@ -0,0 +1,120 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestAlwaysTrace(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+
+	const method = "/test.Service/Method"
+	var span *Span
+	var outgoing []string
+	GRPCServerInterceptor(tc, AlwaysTrace())(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span = FromContext(ctx)
+		md, _ := metadata.FromOutgoingContext(OutgoingContext(ctx))
+		outgoing = md[grpcMetadataKey]
+		return nil, nil
+	})
+	if span == nil {
+		t.Fatal("handler got no span")
+	}
+	if len(outgoing) != 1 || !strings.HasPrefix(outgoing[0], span.TraceID()+"/") {
+		t.Errorf("outgoing headers = %q; want a header for trace %s", outgoing, span.TraceID())
+	}
+
+	select {
+	case <-e.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the span to be exported")
+	}
+	if len(e.spans) != 1 {
+		t.Fatalf("exported %d spans; want 1", len(e.spans))
+	}
+	s := e.spans[0]
+	if s.Name != method {
+		t.Errorf("span name = %q; want %q", s.Name, method)
+	}
+	if s.ParentSpanID != 0 {
+		t.Errorf("span parent = %d; want a root span", s.ParentSpanID)
+	}
+	if got, want := s.Kind, SpanKindServer; got != want {
+		t.Errorf("span kind = %v; want %v", got, want)
+	}
+}
+
+func TestAlwaysTraceSampling(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(neverTrace{})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	var span *Span
+	GRPCServerInterceptor(tc, AlwaysTrace())(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span = FromContext(ctx)
+		return nil, nil
+	})
+	if span == nil {
+		t.Fatal("handler got no span")
+	}
+	if span.traced() {
+		t.Errorf("span is traced, but the sampling policy declined it")
+	}
+}
+
+func TestWithoutAlwaysTrace(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	GRPCServerInterceptor(tc)(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if FromContext(ctx) != nil {
+			t.Errorf("handler got a span for a call without a trace header")
+		}
+		return nil, nil
+	})
+}
+
+func TestAlwaysTraceStream(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+
+	const method = "/test.Service/Stream"
+	ss := &fakeServerStream{ctx: context.Background()}
+	err := GRPCStreamServerInterceptor(tc, AlwaysTrace())(nil, ss, &grpc.StreamServerInfo{FullMethod: method}, func(srv interface{}, ss grpc.ServerStream) error {
+		if FromContext(ss.Context()) == nil {
+			t.Error("handler got no span")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-e.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the span to be exported")
+	}
+	if len(e.spans) != 1 || e.spans[0].Name != method {
+		t.Errorf("exported %d spans; want one span named %q", len(e.spans), method)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxAnnotations is the number of annotations a span keeps, unless
+// it is created with LongLived.
+const defaultMaxAnnotations = 128
+
+// An Annotation is a timestamped event recorded on a span by Span.Annotate.
+type Annotation struct {
+	Time    time.Time
+	Message string
+
+	// Attributes holds the key/value pairs passed to Annotate, or nil.
+	Attributes map[string]string
+}
+
+// Annotate records an event with the message msg, at the current time, on s.
+// It does nothing if s is nil or isn't traced.
+//
+// keyvals are attributes of the event, as alternating keys and values:
+//
+//   span.Annotate("cache miss", "key", key, "shard", shard)
+//
+// A key without a value gets the empty value.  If a key is repeated, the
+// last value wins.
+//
+// A span keeps its newest 128 annotations; older annotations are evicted,
+// and counted in the DroppedAnnotations field of its SpanData.  Spans
+// created with LongLived keep a different number.
+func (s *Span) Annotate(msg string, keyvals ...string) {
+	if s == nil || !s.tracing() {
+		return
+	}
+	a := Annotation{Time: time.Now(), Message: msg}
+	if len(keyvals) > 0 {
+		a.Attributes = make(map[string]string, (len(keyvals)+1)/2)
+		for i := 0; i < len(keyvals); i += 2 {
+			var v string
+			if i+1 < len(keyvals) {
+				v = keyvals[i+1]
+			}
+			a.Attributes[keyvals[i]] = v
+		}
+	}
+	if s.writes.annotate(a) {
+		return
+	}
+	s.spanMu.Lock()
+	s.annotations.add(a)
+	s.spanMu.Unlock()
+}
+
+// Annotatef is like Annotate, with a message formatted as by fmt.Sprintf.
+// The message is only formatted if s is traced.
+func (s *Span) Annotatef(format string, args ...interface{}) {
+	if s == nil || !s.tracing() {
+		return
+	}
+	s.Annotate(fmt.Sprintf(format, args...))
+}
+
+// LongLived returns a SpanOption for spans that live as long as the
+// process, such as a span covering the lifetime of a connection.  The span
+// keeps only its newest maxAnnotations annotations, instead of the default
+// 128; older annotations are evicted, and counted in the DroppedAnnotations
+// field of its SpanData.  LongLived does nothing if maxAnnotations is not
+// positive.
+func LongLived(maxAnnotations int) SpanOption {
+	return spanOption(func(c *spanConfig) {
+		c.maxAnnotations = maxAnnotations
+	})
+}
+
+// annotations holds the annotations of a span.  list is a ring buffer of at
+// most max annotations, or defaultMaxAnnotations if max is zero, whose
+// oldest annotation is at index next once it is full.
+type annotations struct {
+	list    []Annotation
+	max     int
+	next    int
+	dropped int // number of annotations evicted from the ring buffer.
+}
+
+func (a *annotations) add(an Annotation) {
+	max := a.max
+	if max <= 0 {
+		max = defaultMaxAnnotations
+	}
+	if len(a.list) == max {
+		a.list[a.next] = an
+		a.next = (a.next + 1) % max
+		a.dropped++
+		return
+	}
+	a.list = append(a.list, an)
+}
+
+// copy returns a copy of the annotations, oldest first, or nil if there are
+// none.  The attributes are copied too, so that the label filter and the
+// processors can change them in the exported copy.
+func (a *annotations) copy() []Annotation {
+	if len(a.list) == 0 {
+		return nil
+	}
+	c := make([]Annotation, 0, len(a.list))
+	c = append(c, a.list[a.next:]...)
+	c = append(c, a.list[:a.next]...)
+	for i := range c {
+		if attrs := c[i].Attributes; attrs != nil {
+			c[i].Attributes = make(map[string]string, len(attrs))
+			for k, v := range attrs {
+				c[i].Attributes[k] = v
+			}
+		}
+	}
+	return c
+}
+
+// annotationLabels returns the labels to upload to the Stackdriver Trace API,
+// which has no field for annotations, for a span with the given labels and
+// annotations.  Each annotation is encoded as two labels,
+// "annotation/<i>/time" and "annotation/<i>/message", whose message is
+// followed by the annotation's attributes as key=value pairs; the newest
+// annotations that fit within the API's limit on the number of labels are
+// kept.  labels is returned unchanged if there are no annotations to add.
+func annotationLabels(labels map[string]string, as []Annotation) map[string]string {
+	room := (maxLabels - len(labels)) / 2
+	if len(as) == 0 || room <= 0 {
+		return labels
+	}
+	if len(as) > room {
+		as = as[len(as)-room:]
+	}
+	m := make(map[string]string, len(labels)+2*len(as))
+	for k, v := range labels {
+		m[k] = v
+	}
+	for i, a := range as {
+		prefix := "annotation/" + strconv.Itoa(i) + "/"
+		m[prefix+"time"] = a.Time.UTC().Format(time.RFC3339Nano)
+		m[prefix+"message"] = truncate(annotationText(a), maxLabelValueBytes)
+	}
+	return m
+}
+
+// annotationText returns the message of a, followed by its attributes in
+// the order of their keys.
+func annotationText(a Annotation) string {
+	if len(a.Attributes) == 0 {
+		return a.Message
+	}
+	keys := make([]string, 0, len(a.Attributes))
+	for k := range a.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(a.Message)
+	for _, k := range keys {
+		b.WriteString(" " + k + "=" + a.Attributes[k])
+	}
+	return b.String()
+}
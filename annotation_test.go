@@ -0,0 +1,169 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func annotationMessages(as []Annotation) []string {
+	var msgs []string
+	for _, a := range as {
+		msgs = append(msgs, a.Message)
+	}
+	return msgs
+}
+
+func TestAnnotate(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	span := tc.NewSpan("/foo")
+	span.Annotate("one")
+	span.Annotate("two")
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	got := e.spans[0]
+	if msgs, want := annotationMessages(got.Annotations), []string{"one", "two"}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("annotations = %q; want %q", msgs, want)
+	}
+	for _, a := range got.Annotations {
+		if a.Time.Before(got.Start) || a.Time.After(got.End) {
+			t.Errorf("annotation %q at %v is outside the span, from %v to %v", a.Message, a.Time, got.Start, got.End)
+		}
+	}
+	if got.DroppedAnnotations != 0 {
+		t.Errorf("DroppedAnnotations = %d; want 0", got.DroppedAnnotations)
+	}
+
+	var nilSpan *Span
+	nilSpan.Annotate("nothing") // doesn't panic.
+}
+
+func TestAnnotateAttributes(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	span := tc.NewSpan("/foo")
+	span.Annotate("cache miss", "key", "k1", "shard", "3", "key", "k2", "odd")
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	got := e.spans[0].Annotations[0].Attributes
+	want := map[string]string{"key": "k2", "shard": "3", "odd": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("attributes = %v; want %v", got, want)
+	}
+}
+
+func TestLongLivedAnnotations(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	span := tc.NewSpanWithOptions("/conn", LongLived(3))
+	for i := 0; i < 10; i++ {
+		span.Annotate(fmt.Sprint(i))
+	}
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	got := e.spans[0]
+	if msgs, want := annotationMessages(got.Annotations), []string{"7", "8", "9"}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("annotations = %q; want %q", msgs, want)
+	}
+	if got.DroppedAnnotations != 7 {
+		t.Errorf("DroppedAnnotations = %d; want 7", got.DroppedAnnotations)
+	}
+}
+
+func TestLongLivedAnnotationsConcurrent(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	span := root.NewChild("/conn", LongLived(10))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				span.Annotate("event")
+			}
+		}()
+	}
+	wg.Wait()
+	span.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	got := e.span("/conn")
+	if len(got.Annotations) != 10 || got.DroppedAnnotations != 390 {
+		t.Errorf("got %d annotations, %d dropped; want 10, 390", len(got.Annotations), got.DroppedAnnotations)
+	}
+}
+
+func TestAnnotationsBounded(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	span := tc.NewSpan("/foo")
+	for i := 0; i < defaultMaxAnnotations+72; i++ {
+		span.Annotatef("event %d", i)
+	}
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	got := e.spans[0]
+	if len(got.Annotations) != defaultMaxAnnotations || got.DroppedAnnotations != 72 {
+		t.Fatalf("got %d annotations, %d dropped; want %d, 72", len(got.Annotations), got.DroppedAnnotations, defaultMaxAnnotations)
+	}
+	if got, want := got.Annotations[0].Message, "event 72"; got != want {
+		t.Errorf("oldest annotation = %q; want %q", got, want)
+	}
+}
+
+func TestAnnotationLabels(t *testing.T) {
+	ts := time.Date(2017, 1, 2, 3, 4, 5, 6, time.UTC)
+	labels := map[string]string{"a": "1"}
+	as := []Annotation{
+		{Time: ts, Message: "first"},
+		{Time: ts.Add(time.Second), Message: "cache miss", Attributes: map[string]string{"shard": "3", "key": "k"}},
+	}
+	got := annotationLabels(labels, as)
+	want := map[string]string{
+		"a":                    "1",
+		"annotation/0/time":    "2017-01-02T03:04:05.000000006Z",
+		"annotation/0/message": "first",
+		"annotation/1/time":    "2017-01-02T03:04:06.000000006Z",
+		"annotation/1/message": "cache miss key=k shard=3",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("annotationLabels = %v; want %v", got, want)
+	}
+	if len(labels) != 1 {
+		t.Errorf("annotationLabels modified the span's labels: %v", labels)
+	}
+
+	// Only the newest annotations that fit within the label limit are kept.
+	labels = make(map[string]string)
+	for i := 0; i < maxLabels-3; i++ {
+		labels[fmt.Sprint(i)] = ""
+	}
+	got = annotationLabels(labels, as)
+	if len(got) != maxLabels-1 || got["annotation/0/message"] != "cache miss key=k shard=3" {
+		t.Errorf("annotationLabels with room for one annotation = %v", got)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+)
+
+// anonymizedPrefix is prepended to every hashed value, so that anonymized
+// values can be told apart from original ones.
+const anonymizedPrefix = "anon-"
+
+// DefaultAnonymizedLabels are the labels whose values an AnonymizingProcessor
+// hashes unless WithHashedLabels is given.
+var DefaultAnonymizedLabels = []string{labelHost, labelURL, labelRedirectFrom, labelGRPCPeer, labelGRPCAuthority}
+
+// DefaultAnonymizedNamePatterns match the parts of span names that an
+// AnonymizingProcessor hashes unless WithAnonymizedSpanNames is given: URLs,
+// IPv4 addresses with an optional port, and the host that begins the names
+// of HTTP spans ("example.com:8080/path").
+var DefaultAnonymizedNamePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s]+`),
+	regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b`),
+	regexp.MustCompile(`^[^/\s]*[.:][^/\s]*`),
+}
+
+// An AnonymizingProcessor is a SpanProcessor that removes hostnames,
+// addresses and other identifying values from spans and their annotations,
+// for exporting traces to third-party backends.
+//
+// Values are replaced by a keyed hash, so that equal values still have equal
+// replacements and spans can be grouped by them, but the original values
+// cannot be recovered without the key.
+//
+// Add an AnonymizingProcessor to a client with AddSpanProcessor.  It is
+// always applied after all other processors.
+type AnonymizingProcessor struct {
+	key      []byte
+	hashed   map[string]bool
+	stripped map[string]bool
+	names    []*regexp.Regexp
+}
+
+// An AnonymizeOption configures an AnonymizingProcessor.
+type AnonymizeOption interface {
+	configureAnonymizer(p *AnonymizingProcessor)
+}
+
+type anonymizeOption func(p *AnonymizingProcessor)
+
+func (o anonymizeOption) configureAnonymizer(p *AnonymizingProcessor) { o(p) }
+
+// WithAnonymizationKey sets the key used to hash values.  It is required.
+// Processors with the same key produce the same hashes.
+func WithAnonymizationKey(key []byte) AnonymizeOption {
+	return anonymizeOption(func(p *AnonymizingProcessor) {
+		p.key = append([]byte(nil), key...)
+	})
+}
+
+// WithHashedLabels sets the labels whose values are hashed, replacing
+// DefaultAnonymizedLabels.  Annotation attributes with these keys are hashed
+// too.
+func WithHashedLabels(keys ...string) AnonymizeOption {
+	return anonymizeOption(func(p *AnonymizingProcessor) {
+		p.hashed = stringSet(keys)
+	})
+}
+
+// WithStrippedLabels sets labels that are removed from spans entirely.
+// Annotation attributes with these keys are removed too.
+func WithStrippedLabels(keys ...string) AnonymizeOption {
+	return anonymizeOption(func(p *AnonymizingProcessor) {
+		p.stripped = stringSet(keys)
+	})
+}
+
+// WithAnonymizedSpanNames sets the patterns matching the parts of span names
+// and annotation messages that are hashed, replacing
+// DefaultAnonymizedNamePatterns.  Each match of each pattern is replaced by
+// its hash.
+func WithAnonymizedSpanNames(patterns ...*regexp.Regexp) AnonymizeOption {
+	return anonymizeOption(func(p *AnonymizingProcessor) {
+		p.names = patterns
+	})
+}
+
+// NewAnonymizingProcessor returns an AnonymizingProcessor configured by opts.
+// WithAnonymizationKey must be among them.
+func NewAnonymizingProcessor(opts ...AnonymizeOption) (*AnonymizingProcessor, error) {
+	p := &AnonymizingProcessor{
+		hashed: stringSet(DefaultAnonymizedLabels),
+		names:  DefaultAnonymizedNamePatterns,
+	}
+	for _, o := range opts {
+		o.configureAnonymizer(p)
+	}
+	if len(p.key) == 0 {
+		return nil, errors.New("trace: NewAnonymizingProcessor requires WithAnonymizationKey")
+	}
+	return p, nil
+}
+
+// ProcessSpan anonymizes the name and labels of s, and its annotations: their
+// messages like span names, and their attributes like labels.
+func (p *AnonymizingProcessor) ProcessSpan(s *SpanData) {
+	s.Name = p.anonymizeText(s.Name)
+	p.anonymizeLabels(s.Labels)
+	for i := range s.Annotations {
+		a := &s.Annotations[i]
+		a.Message = p.anonymizeText(a.Message)
+		p.anonymizeLabels(a.Attributes)
+	}
+}
+
+// anonymizeText returns text with the matches of the name patterns hashed.
+func (p *AnonymizingProcessor) anonymizeText(text string) string {
+	for _, re := range p.names {
+		text = re.ReplaceAllStringFunc(text, p.hash)
+	}
+	return text
+}
+
+// anonymizeLabels strips and hashes the labels in m.
+func (p *AnonymizingProcessor) anonymizeLabels(m map[string]string) {
+	for k, v := range m {
+		if p.stripped[k] {
+			delete(m, k)
+		} else if p.hashed[k] {
+			m[k] = p.hash(v)
+		}
+	}
+}
+
+// hash returns the keyed hash of v.
+func (p *AnonymizingProcessor) hash(v string) string {
+	m := hmac.New(sha256.New, p.key)
+	m.Write([]byte(v))
+	return anonymizedPrefix + hex.EncodeToString(m.Sum(nil)[:16])
+}
+
+func stringSet(keys []string) map[string]bool {
+	m := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		m[k] = true
+	}
+	return m
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newTestAnonymizer(t *testing.T, opts ...AnonymizeOption) *AnonymizingProcessor {
+	p, err := NewAnonymizingProcessor(append([]AnonymizeOption{WithAnonymizationKey([]byte("key"))}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAnonymizeRequiresKey(t *testing.T) {
+	if _, err := NewAnonymizingProcessor(); err == nil {
+		t.Error("NewAnonymizingProcessor without a key: got nil error")
+	}
+}
+
+func TestAnonymizeHashing(t *testing.T) {
+	p := newTestAnonymizer(t)
+	if got, want := p.hash("example.com"), p.hash("example.com"); got != want {
+		t.Errorf("hashes of equal values differ: %q, %q", got, want)
+	}
+	if p.hash("example.com") == p.hash("example.org") {
+		t.Error("hashes of different values are equal")
+	}
+	other := newTestAnonymizer(t, WithAnonymizationKey([]byte("other key")))
+	if p.hash("example.com") == other.hash("example.com") {
+		t.Error("hashes with different keys are equal")
+	}
+	again := newTestAnonymizer(t)
+	if got, want := again.hash("example.com"), p.hash("example.com"); got != want {
+		t.Errorf("hash with another processor with the same key = %q; want %q", got, want)
+	}
+}
+
+func TestAnonymizeLabels(t *testing.T) {
+	p := newTestAnonymizer(t, WithHashedLabels("peer", labelURL), WithStrippedLabels("authority"))
+	s := &SpanData{Labels: map[string]string{
+		"peer":      "10.0.0.1:443",
+		labelURL:    "https://example.com/foo",
+		"authority": "example.com",
+		"method":    "GET",
+	}}
+	p.ProcessSpan(s)
+	want := map[string]string{
+		"peer":   p.hash("10.0.0.1:443"),
+		labelURL: p.hash("https://example.com/foo"),
+		"method": "GET",
+	}
+	if len(s.Labels) != len(want) {
+		t.Errorf("labels = %v; want %v", s.Labels, want)
+	}
+	for k, v := range want {
+		if got := s.Labels[k]; got != v {
+			t.Errorf("label %q = %q; want %q", k, got, v)
+		}
+	}
+}
+
+func TestAnonymizeDefaultLabels(t *testing.T) {
+	p := newTestAnonymizer(t)
+	s := &SpanData{Labels: map[string]string{
+		labelHost:          "example.com",
+		labelURL:           "https://example.com/foo",
+		labelRedirectFrom:  "http://example.com/old",
+		labelGRPCPeer:      "10.0.0.1:4321",
+		labelGRPCAuthority: "api.example.com:443",
+		"method":           "GET",
+	}}
+	p.ProcessSpan(s)
+	for k, v := range map[string]string{
+		labelHost:          p.hash("example.com"),
+		labelURL:           p.hash("https://example.com/foo"),
+		labelRedirectFrom:  p.hash("http://example.com/old"),
+		labelGRPCPeer:      p.hash("10.0.0.1:4321"),
+		labelGRPCAuthority: p.hash("api.example.com:443"),
+		"method":           "GET",
+	} {
+		if got := s.Labels[k]; got != v {
+			t.Errorf("label %q = %q; want %q", k, got, v)
+		}
+	}
+}
+
+func TestAnonymizeAnnotations(t *testing.T) {
+	p := newTestAnonymizer(t, WithStrippedLabels("token"))
+	s := &SpanData{Annotations: []Annotation{{
+		Message:    "dialing 10.1.2.3:80",
+		Attributes: map[string]string{labelGRPCPeer: "10.1.2.3:80", "token": "secret", "attempt": "2"},
+	}}}
+	p.ProcessSpan(s)
+	a := s.Annotations[0]
+	if want := "dialing " + p.hash("10.1.2.3:80"); a.Message != want {
+		t.Errorf("annotation message = %q; want %q", a.Message, want)
+	}
+	want := map[string]string{labelGRPCPeer: p.hash("10.1.2.3:80"), "attempt": "2"}
+	if len(a.Attributes) != len(want) {
+		t.Errorf("annotation attributes = %v; want %v", a.Attributes, want)
+	}
+	for k, v := range want {
+		if got := a.Attributes[k]; got != v {
+			t.Errorf("annotation attribute %q = %q; want %q", k, got, v)
+		}
+	}
+}
+
+func TestAnonymizeSpanNames(t *testing.T) {
+	p := newTestAnonymizer(t)
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"example.com/foo", p.hash("example.com") + "/foo"},
+		{"example.com:8080/foo", p.hash("example.com:8080") + "/foo"},
+		{"localhost:8080", p.hash("localhost:8080")},
+		{"call 10.1.2.3:80 failed", "call " + p.hash("10.1.2.3:80") + " failed"},
+		{"fetch https://example.com/foo?q=1", "fetch " + p.hash("https://example.com/foo?q=1")},
+		{"/google.pubsub.v1.Publisher/Publish", "/google.pubsub.v1.Publisher/Publish"},
+		{"/foo", "/foo"},
+	} {
+		s := &SpanData{Name: tt.name}
+		p.ProcessSpan(s)
+		if s.Name != tt.want {
+			t.Errorf("span %q renamed to %q; want %q", tt.name, s.Name, tt.want)
+		}
+	}
+
+	custom := newTestAnonymizer(t, WithAnonymizedSpanNames(regexp.MustCompile(`[a-z]+\.internal`)))
+	s := &SpanData{Name: "db.internal/query"}
+	custom.ProcessSpan(s)
+	if got, want := s.Name, custom.hash("db.internal")+"/query"; got != want {
+		t.Errorf("with custom pattern: got %q; want %q", got, want)
+	}
+}
+
+// labelCopier is a SpanProcessor that copies the host label into a label
+// that isn't anonymized, to check that anonymization happens last.
+type labelCopier struct{}
+
+func (labelCopier) ProcessSpan(s *SpanData) {
+	s.Labels["copied/host"] = s.Labels[labelHost]
+	s.Name = s.Labels[labelHost] + "/proxied"
+}
+
+func TestAnonymizeRunsLast(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	p := newTestAnonymizer(t, WithHashedLabels(labelHost, labelURL, "copied/host"))
+	tc.AddSpanProcessor(p)
+	tc.AddSpanProcessor(labelCopier{})
+
+	req, err := http.NewRequest("GET", "http://example.com:8080/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(httpHeader, "0123456789abcdef0123456789abcdef/1;o=1")
+	span := tc.SpanFromRequest(req)
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.spans) != 1 {
+		t.Fatalf("got %d spans; want 1", len(e.spans))
+	}
+	s := e.spans[0]
+	for k, v := range s.Labels {
+		if strings.Contains(v, "example.com") {
+			t.Errorf("label %q = %q contains the host", k, v)
+		}
+	}
+	if got, want := s.Labels["copied/host"], p.hash("example.com:8080"); got != want {
+		t.Errorf("copied host label = %q; want %q", got, want)
+	}
+	if got, want := s.Name, p.hash("example.com:8080")+"/proxied"; got != want {
+		t.Errorf("span name = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tuneWindow is the number of uploads over which the batch autotuner
+// measures the latency of the exporter before it increases its batch size.
+const tuneWindow = 10
+
+// AutotunerStats holds the state of the batch autotuner set with
+// SetBatchAutotuning.
+type AutotunerStats struct {
+	// Threshold is the current maximum number of traces per upload, between
+	// Min and Max.
+	Threshold int
+	Min, Max  int
+
+	// TargetLatency is the latency that the 90th percentile of the uploads
+	// is kept under.
+	TargetLatency time.Duration
+
+	// P90Latency is the 90th percentile of the latencies of the uploads
+	// measured since the threshold last changed, of which there are
+	// Window.
+	P90Latency time.Duration
+	Window     int
+
+	// Increases and Decreases are the number of times the threshold was
+	// increased and decreased.
+	Increases, Decreases int64
+
+	// LastBatch describes the latest upload.
+	LastBatch BatchStats
+}
+
+// BatchStats describes the upload of a batch of spans.
+type BatchStats struct {
+	Traces  int
+	Spans   int
+	Bytes   int // size of the spans encoded as JSON, approximating their size on the wire.
+	Latency time.Duration
+}
+
+// SetBatchAutotuning makes the client adjust the size of its uploads to
+// keep the 90th percentile of their latencies under target.  The bundler
+// collects up to max traces, and uploads them in batches of at most the
+// current threshold, which starts at max and stays between min and max: it
+// is halved when the percentile over the latest uploads exceeds target, and
+// increased by one after every 10 uploads under it.
+//
+// Autotuning is disabled if target isn't positive.  The state of the tuner,
+// and the size and latency of the latest upload, are reported by Stats.
+//
+// SetBatchAutotuning should be called before any spans are created.
+func (c *Client) SetBatchAutotuning(target time.Duration, min, max int) {
+	if c == nil {
+		return
+	}
+	if target <= 0 {
+		c.tuner = nil
+		return
+	}
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	c.bundler.BundleCountThreshold = max
+	c.tuner = &batchTuner{target: target, min: min, max: max, threshold: max}
+}
+
+// A batchTuner is an AIMD controller of the number of traces per upload.
+type batchTuner struct {
+	target   time.Duration
+	min, max int
+
+	mu        sync.Mutex
+	threshold int
+	window    []time.Duration // latencies since threshold last changed.
+	increases int64
+	decreases int64
+	last      BatchStats
+}
+
+// batchSize returns the number of traces to upload in the next batch, out
+// of n.  A nil tuner uploads them all.
+func (t *batchTuner) batchSize(n int) int {
+	if t == nil {
+		return n
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.threshold < n {
+		return t.threshold
+	}
+	return n
+}
+
+// encodedSize returns the size of spans encoded as JSON, or 0 if t is nil.
+func (t *batchTuner) encodedSize(spans []*SpanData) int {
+	if t == nil {
+		return 0
+	}
+	b, err := json.Marshal(spans)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+// observe records the upload of a batch and adjusts the threshold.
+func (t *batchTuner) observe(b BatchStats) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last = b
+	t.window = append(t.window, b.Latency)
+	p90 := percentile90(t.window)
+	switch {
+	case p90 > t.target && t.threshold > t.min:
+		t.threshold /= 2
+		if t.threshold < t.min {
+			t.threshold = t.min
+		}
+		t.decreases++
+		t.window = t.window[:0]
+	case p90 <= t.target && len(t.window) >= tuneWindow:
+		if t.threshold < t.max {
+			t.threshold++
+			t.increases++
+		}
+		t.window = t.window[:0]
+	case len(t.window) >= tuneWindow:
+		t.window = t.window[1:]
+	}
+}
+
+// percentile90 returns the 90th percentile of latencies, or 0 if there are
+// none.
+func percentile90(latencies []time.Duration) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)*9-1)/10]
+}
+
+// stats returns the state of the tuner, or nil if t is nil.
+func (t *batchTuner) stats() *AutotunerStats {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &AutotunerStats{
+		Threshold:     t.threshold,
+		Min:           t.min,
+		Max:           t.max,
+		TargetLatency: t.target,
+		P90Latency:    percentile90(t.window),
+		Window:        len(t.window),
+		Increases:     t.increases,
+		Decreases:     t.decreases,
+		LastBatch:     t.last,
+	}
+}
+
+// uploadBundle uploads the traces of a bundle, in batches whose size is
+// set by the client's autotuner, if it has one.
+func (c *Client) uploadBundle(traces [][]*SpanData) {
+	for len(traces) > 0 {
+		batch := traces[:c.tuner.batchSize(len(traces))]
+		traces = traces[len(batch):]
+		var spans []*SpanData
+		for _, t := range batch {
+			spans = append(spans, t...)
+		}
+		atomic.AddInt64(&c.stats.spansBundled, -int64(len(spans)))
+		size := c.tuner.encodedSize(spans)
+		start := time.Now()
+		err := c.exportOrSpill(spans)
+		c.tuner.observe(BatchStats{Traces: len(batch), Spans: len(spans), Bytes: size, Latency: time.Since(start)})
+		if err != nil {
+			c.reportError(fmt.Errorf("failed to upload %d traces: %v", len(batch), err))
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// slowExporter is an Exporter whose latency grows with the number of spans
+// it exports.
+type slowExporter struct {
+	perSpan time.Duration
+}
+
+func (e slowExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	time.Sleep(time.Duration(len(spans)) * e.perSpan)
+	return nil
+}
+
+func TestBatchAutotuning(t *testing.T) {
+	const (
+		perSpan = 200 * time.Microsecond
+		target  = 3 * time.Millisecond // batches of up to 15 spans.
+	)
+	tc := NewClientWithExporter(slowExporter{perSpan})
+	tc.SetBatchAutotuning(target, 2, 100)
+	if got := tc.Config().BatchMaxTraces; got != 100 {
+		t.Errorf("Config().BatchMaxTraces = %d; want 100", got)
+	}
+	if got := tc.Stats().Autotuner.Threshold; got != 100 {
+		t.Errorf("initial threshold is %d; want 100", got)
+	}
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 100; i++ {
+			tc.NewSpan("/root").Finish()
+		}
+		tc.Flush(context.Background())
+	}
+
+	st := tc.Stats()
+	a := st.Autotuner
+	if a.Decreases == 0 {
+		t.Error("the threshold was never decreased")
+	}
+	if a.Threshold < 2 || time.Duration(a.Threshold)*perSpan > target {
+		t.Errorf("threshold is %d; want between 2 and %d", a.Threshold, target/perSpan)
+	}
+	if b := a.LastBatch; b.Traces != b.Spans || b.Bytes == 0 || b.Latency < time.Duration(b.Spans)*perSpan {
+		t.Errorf("LastBatch = %+v; want a batch of single-span traces with its size and latency", b)
+	}
+	var batches int64
+	for _, b := range st.SpansPerBatch {
+		batches += b.Count
+	}
+	if batches != st.UploadsSucceeded {
+		t.Errorf("SpansPerBatch counts %d batches; want %d", batches, st.UploadsSucceeded)
+	}
+}
+
+func TestBatchTunerAIMD(t *testing.T) {
+	bt := &batchTuner{target: 10 * time.Millisecond, min: 4, max: 20, threshold: 20}
+	bt.observe(BatchStats{Latency: 20 * time.Millisecond})
+	if bt.threshold != 10 {
+		t.Fatalf("threshold after a slow upload is %d; want 10", bt.threshold)
+	}
+	for i := 0; i < tuneWindow; i++ {
+		bt.observe(BatchStats{Latency: time.Millisecond})
+	}
+	if bt.threshold != 11 {
+		t.Fatalf("threshold after %d fast uploads is %d; want 11", tuneWindow, bt.threshold)
+	}
+	for i := 0; i < 3; i++ {
+		bt.observe(BatchStats{Latency: time.Second})
+	}
+	if bt.threshold != 4 {
+		t.Errorf("threshold after slow uploads is %d; want the minimum, 4", bt.threshold)
+	}
+	if got := bt.batchSize(3); got != 3 {
+		t.Errorf("batchSize(3) = %d; want 3", got)
+	}
+	if got := bt.batchSize(100); got != 4 {
+		t.Errorf("batchSize(100) = %d; want 4", got)
+	}
+	if st := bt.stats(); st.Increases != 1 || st.Decreases != 3 {
+		t.Errorf("Increases, Decreases = %d, %d; want 1, 3", st.Increases, st.Decreases)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The keys of the B3 headers of Zipkin.  See
+// https://github.com/openzipkin/b3-propagation.
+const (
+	b3Key             = "b3"
+	b3TraceIDKey      = "x-b3-traceid"
+	b3SpanIDKey       = "x-b3-spanid"
+	b3ParentSpanIDKey = "x-b3-parentspanid"
+	b3SampledKey      = "x-b3-sampled"
+	b3FlagsKey        = "x-b3-flags"
+)
+
+// B3Propagator propagates trace contexts in the X-B3-TraceId, X-B3-SpanId
+// and X-B3-Sampled headers of Zipkin.  See WithHeaderFormats for how trace
+// IDs of 64 bits are handled.
+var B3Propagator Propagator = b3Propagator{}
+
+// B3SinglePropagator propagates trace contexts in the single b3 header of
+// Zipkin.
+var B3SinglePropagator Propagator = b3SinglePropagator{}
+
+type b3Propagator struct{}
+
+func (b3Propagator) Inject(sc SpanContext, carrier Carrier) {
+	carrier.Set(b3TraceIDKey, b3TraceID(sc.TraceID))
+	carrier.Set(b3SpanIDKey, fmt.Sprintf("%016x", sc.SpanID))
+	carrier.Set(b3SampledKey, b3Sampled(sc.flags()))
+}
+
+func (b3Propagator) Extract(carrier Carrier) (SpanContext, error) {
+	h, ok := b3FromCarrier(carrier)
+	if !ok {
+		return SpanContext{}, ErrNoHeader
+	}
+	return extractB3(h)
+}
+
+type b3SinglePropagator struct{}
+
+func (b3SinglePropagator) Inject(sc SpanContext, carrier Carrier) {
+	carrier.Set(b3Key, b3Single(sc.TraceID, sc.SpanID, sc.flags()))
+}
+
+func (b3SinglePropagator) Extract(carrier Carrier) (SpanContext, error) {
+	h := carrier.Get(b3Key)
+	if h == "" || b3SamplingOnly(h) {
+		return SpanContext{}, ErrNoHeader
+	}
+	return extractB3(h)
+}
+
+// extractB3 returns the span context in h, a single B3 header.
+func extractB3(h string) (SpanContext, error) {
+	if !isB3(h) || len(h) > 200 {
+		return SpanContext{}, &HeaderError{Header: h, Err: ErrMalformedTraceID, Detail: "not a b3 header"}
+	}
+	traceID, spanID, options, err := parseB3(h)
+	if err != nil {
+		return SpanContext{}, err
+	}
+	return SpanContext{TraceID: traceID, SpanID: spanID, Traced: options&optionTrace != 0}, nil
+}
+
+// b3TraceIDPad pads a 64-bit B3 trace ID to 128 bits.
+const b3TraceIDPad = "0000000000000000"
+
+// isB3 reports whether h looks like a single B3 header, whose trace ID of 16
+// or 32 hex digits is followed by a '-', rather than a Google trace header.
+func isB3(h string) bool {
+	i := strings.IndexByte(h, '-')
+	return (i == 16 || i == 32) && !strings.Contains(h, "/")
+}
+
+// b3SamplingOnly reports whether h is a single B3 header that only holds a
+// sampling decision, such as "0", and no trace.
+func b3SamplingOnly(h string) bool {
+	return h == "0" || h == "1" || h == "d"
+}
+
+// parseB3 parses h, a single B3 header of the form
+// <trace ID>-<span ID>[-<sampled>[-<parent span ID>]].
+func parseB3(h string) (traceID string, spanID uint64, options optionFlags, err error) {
+	header := h
+	parts := strings.Split(h, "-")
+	if len(parts) > 4 {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedOptions, Detail: "too many fields in b3 header"}
+	}
+	if traceID = parts[0]; len(traceID) == 16 {
+		traceID = b3TraceIDPad + traceID
+	}
+	if !isLowerHex(traceID) || !validTraceID(traceID) {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedTraceID, Detail: fmt.Sprintf("%q", parts[0])}
+	}
+	spanstr := parts[1]
+	if len(spanstr) != 16 || !isLowerHex(spanstr) {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedSpanID, Detail: fmt.Sprintf("%q", spanstr)}
+	}
+	spanID, _ = strconv.ParseUint(spanstr, 16, 64)
+	if len(parts) > 2 {
+		switch parts[2] {
+		case "1", "d":
+			options = optionTrace
+		case "0":
+		default:
+			return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedOptions, Detail: fmt.Sprintf("%q", parts[2])}
+		}
+	}
+	if len(parts) > 3 && (len(parts[3]) != 16 || !isLowerHex(parts[3])) {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedSpanID, Detail: fmt.Sprintf("parent %q", parts[3])}
+	}
+	return traceID, spanID, options, nil
+}
+
+// b3FromCarrier returns the single B3 header for the multiple B3 headers in
+// carrier, if it has them.
+func b3FromCarrier(carrier Carrier) (string, bool) {
+	get := carrier.Get
+	traceID := get(b3TraceIDKey)
+	if traceID == "" {
+		return "", false
+	}
+	h := traceID + "-" + get(b3SpanIDKey)
+	switch sampled := get(b3SampledKey); {
+	case get(b3FlagsKey) == "1":
+		h += "-d"
+	case sampled == "1" || sampled == "true":
+		h += "-1"
+	case sampled == "0" || sampled == "false":
+		h += "-0"
+	case sampled != "":
+		h += "-" + sampled // rejected by parseB3.
+	}
+	return h, true
+}
+
+// b3TraceID returns traceID as a B3 trace ID, of 64 bits if its first 64
+// bits are zero.
+func b3TraceID(traceID string) string {
+	traceID = strings.ToLower(traceID)
+	if strings.HasPrefix(traceID, b3TraceIDPad) {
+		return traceID[len(b3TraceIDPad):]
+	}
+	return traceID
+}
+
+func b3Sampled(options optionFlags) string {
+	if options&optionTrace != 0 {
+		return "1"
+	}
+	return "0"
+}
+
+// b3Single returns the single B3 header for a span.
+func b3Single(traceID string, spanID uint64, options optionFlags) string {
+	return fmt.Sprintf("%s-%016x-%s", b3TraceID(traceID), spanID, b3Sampled(options))
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	b3TraceID64  = "a3ce929d0e0e4736"
+	b3TraceID128 = "4bf92f3577b34da6a3ce929d0e0e4736"
+	b3SpanID     = "00f067aa0ba902b7"
+)
+
+func TestParseB3(t *testing.T) {
+	for _, tt := range []struct {
+		header      string
+		wantTraceID string
+		wantOptions optionFlags
+		wantErr     error
+	}{
+		{header: b3TraceID128 + "-" + b3SpanID + "-1", wantTraceID: b3TraceID128, wantOptions: optionTrace},
+		{header: b3TraceID128 + "-" + b3SpanID + "-d-05e3ac9a4f6e3b90", wantTraceID: b3TraceID128, wantOptions: optionTrace},
+		{header: b3TraceID128 + "-" + b3SpanID + "-0", wantTraceID: b3TraceID128},
+		{header: b3TraceID128 + "-" + b3SpanID, wantTraceID: b3TraceID128},
+		{header: b3TraceID64 + "-" + b3SpanID + "-1", wantTraceID: b3TraceIDPad + b3TraceID64, wantOptions: optionTrace},
+		{header: b3TraceID128 + "-" + b3SpanID + "-x", wantErr: ErrMalformedOptions},
+		{header: b3TraceID128 + "-" + b3SpanID + "-1-05e3ac9a4f6e3b90-x", wantErr: ErrMalformedOptions},
+		{header: b3TraceID128 + "-00f067aa0ba902-1", wantErr: ErrMalformedSpanID},
+		{header: b3TraceID128 + "-" + b3SpanID + "-1-05e3", wantErr: ErrMalformedSpanID},
+		{header: "0000000000000000-" + b3SpanID + "-1", wantErr: ErrMalformedTraceID},
+		{header: "A3CE929D0E0E4736-" + b3SpanID + "-1", wantErr: ErrMalformedTraceID},
+	} {
+		traceID, spanID, options, _, err := parseHeaderErr(tt.header)
+		if tt.wantErr != nil {
+			if herr, ok := err.(*HeaderError); !ok || herr.Err != tt.wantErr {
+				t.Errorf("parseHeaderErr(%q) error = %v; want %v", tt.header, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHeaderErr(%q): %v", tt.header, err)
+			continue
+		}
+		if traceID != tt.wantTraceID || spanID != 0x00f067aa0ba902b7 || options != tt.wantOptions {
+			t.Errorf("parseHeaderErr(%q) = %q, %x, %d; want %q, %s, %d", tt.header, traceID, spanID, options, tt.wantTraceID, b3SpanID, tt.wantOptions)
+		}
+	}
+}
+
+func TestB3FromMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		md   metadata.MD
+		want string
+	}{
+		{metadata.Pairs(b3TraceIDKey, b3TraceID64, b3SpanIDKey, b3SpanID, b3SampledKey, "1"), b3TraceID64 + "-" + b3SpanID + "-1"},
+		{metadata.Pairs(b3TraceIDKey, b3TraceID64, b3SpanIDKey, b3SpanID, b3SampledKey, "true"), b3TraceID64 + "-" + b3SpanID + "-1"},
+		{metadata.Pairs(b3TraceIDKey, b3TraceID64, b3SpanIDKey, b3SpanID, b3SampledKey, "0"), b3TraceID64 + "-" + b3SpanID + "-0"},
+		{metadata.Pairs(b3TraceIDKey, b3TraceID64, b3SpanIDKey, b3SpanID, b3FlagsKey, "1"), b3TraceID64 + "-" + b3SpanID + "-d"},
+		{metadata.Pairs(b3TraceIDKey, b3TraceID64, b3SpanIDKey, b3SpanID), b3TraceID64 + "-" + b3SpanID},
+	} {
+		if got, ok := b3FromCarrier(MetadataCarrier(tt.md)); !ok || got != tt.want {
+			t.Errorf("b3FromCarrier(%v) = %q, %t; want %q, true", tt.md, got, ok, tt.want)
+		}
+	}
+	if got, ok := b3FromCarrier(MetadataCarrier(metadata.Pairs(b3SpanIDKey, b3SpanID))); ok {
+		t.Errorf("b3FromCarrier without a trace ID = %q, true; want false", got)
+	}
+}
+
+func TestB3RoundTrip(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/In"}
+	for _, tt := range []struct {
+		desc    string
+		formats HeaderFormat
+		md      metadata.MD
+	}{
+		{"multiple headers", B3Header, metadata.Pairs(b3TraceIDKey, b3TraceID64, b3SpanIDKey, b3SpanID, b3SampledKey, "1")},
+		{"single header", B3SingleHeader, metadata.Pairs(b3Key, b3TraceID64+"-"+b3SpanID+"-1")},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+		GRPCServerInterceptor(tc, WithHeaderFormats(tt.formats))(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			span := FromContext(ctx)
+			if got, want := span.TraceID(), b3TraceIDPad+b3TraceID64; got != want {
+				t.Errorf("%s: server span TraceID = %q; want %q", tt.desc, got, want)
+			}
+			if !span.traced() {
+				t.Errorf("%s: server span of a sampled call is not traced", tt.desc)
+			}
+
+			out := outgoingHeaders(ctx, WithHeaderFormats(GoogleHeader|B3Header|B3SingleHeader))
+			google := out[grpcMetadataKey]
+			if len(google) != 1 {
+				t.Fatalf("%s: outgoing Google headers = %q; want one", tt.desc, google)
+			}
+			traceID, spanID, _, _, err := parseHeaderErr(google[0])
+			if err != nil || traceID != span.TraceID() {
+				t.Errorf("%s: outgoing Google header %q; want a header for trace %s", tt.desc, google[0], span.TraceID())
+			}
+			want := metadata.MD{
+				b3TraceIDKey: {b3TraceID64},
+				b3SpanIDKey:  {fmt.Sprintf("%016x", spanID)},
+				b3SampledKey: {"1"},
+				b3Key:        {fmt.Sprintf("%s-%016x-1", b3TraceID64, spanID)},
+			}
+			for k, v := range want {
+				if got := out[k]; len(got) != 1 || got[0] != v[0] {
+					t.Errorf("%s: outgoing %s = %q; want %q", tt.desc, k, got, v)
+				}
+			}
+			return nil, nil
+		})
+	}
+}
+
+func TestB3SamplingOnly(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(b3Key, "0"))
+	GRPCServerInterceptor(tc, WithHeaderFormats(B3SingleHeader))(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/In"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if FromContext(ctx) != nil {
+			t.Errorf("handler got a span for a b3 header without a trace")
+		}
+		return nil, nil
+	})
+}
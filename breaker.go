@@ -0,0 +1,279 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrExportTimeout is returned, and reported to the client's error handler,
+// when an exporter doesn't return within the timeout set with
+// SetExportTimeout.
+var ErrExportTimeout = errors.New("trace: export timed out")
+
+// ErrCircuitOpen is returned, and reported to the client's error handler,
+// when spans are dropped because the circuit breaker of their exporter is
+// open.
+var ErrCircuitOpen = errors.New("trace: exporter circuit breaker is open; spans dropped")
+
+// A BreakerState is the state of an exporter's circuit breaker.
+type BreakerState string
+
+const (
+	// BreakerClosed is the state of a breaker that lets every export through.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen is the state of a breaker that drops every export.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen is the state of a breaker that lets a single probe
+	// export through, to decide whether to close again.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerStats holds the state of the circuit breaker of an exporter, and
+// counts of its state transitions.
+type BreakerStats struct {
+	State BreakerState
+
+	Opened     int64 // transitions to BreakerOpen.
+	HalfOpened int64 // transitions to BreakerHalfOpen.
+	Closed     int64 // transitions to BreakerClosed, after a successful probe.
+
+	// Dropped is the number of exports dropped while the breaker was open.
+	Dropped int64
+}
+
+// breakerNow returns the current time.  Tests replace it with a fake clock.
+var breakerNow = time.Now
+
+// SetExportTimeout limits the time the client waits for each call of an
+// exporter.  The context passed to the exporter is cancelled after timeout;
+// if the exporter still hasn't returned, the client stops waiting for it,
+// the export fails with ErrExportTimeout, and the timeout is counted in the
+// ExportTimeouts field of Stats.  The timeout applies separately to each
+// exporter chosen by the client's Router.  A timeout of zero, the default,
+// waits for every export.
+//
+// Like SetSamplerTimeout, this calls the exporter in a new goroutine for
+// each export while a timeout is set, and an exporter that never returns
+// leaks that goroutine.
+//
+// SetExportTimeout should be called before any spans are created.
+func (c *Client) SetExportTimeout(timeout time.Duration) {
+	if c != nil {
+		c.exportTimeout = timeout
+	}
+}
+
+// SetCircuitBreaker gives each of the client's exporters a circuit breaker.
+// After failures consecutive failed exports, including exports that timed
+// out, the breaker of that exporter opens, and the spans sent to it are
+// dropped with ErrCircuitOpen without calling it.  Once cooldown has passed,
+// the breaker becomes half-open and lets a single export through as a
+// probe: if the probe succeeds the breaker closes, and otherwise it opens
+// again for another cooldown.
+//
+// The state of each breaker is reported in the Breakers field of Stats, and
+// by HealthHandler.  A failures count of zero, the default, disables the
+// breakers.
+//
+// SetCircuitBreaker should be called before any spans are created.
+func (c *Client) SetCircuitBreaker(failures int, cooldown time.Duration) {
+	if c != nil {
+		c.breakerFailures = failures
+		c.breakerCooldown = cooldown
+	}
+}
+
+// HealthHandler returns an http.Handler that reports the health of the
+// client's exporters.  It responds with 503 Service Unavailable, naming the
+// exporters, while the circuit breaker of any exporter is open, and with
+// 200 OK otherwise.  The default exporter is named "default".
+//
+//   http.Handle("/healthz/trace", traceClient.HealthHandler())
+func (c *Client) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var open []string
+		for name, b := range c.breakerStats() {
+			if b.State == BreakerOpen {
+				open = append(open, name)
+			}
+		}
+		if len(open) > 0 {
+			sort.Strings(open)
+			http.Error(w, "circuit breaker open for exporters: "+strings.Join(open, ", "), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// exportTo sends spans to e, the exporter with the given name in the
+// client's router, or the default exporter if name is empty, applying the
+// client's export timeout, retry policy and circuit breaker.
+func (c *Client) exportTo(ctx context.Context, name string, e Exporter, spans []*SpanData) error {
+	b := c.breaker(name)
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := c.exportWithRetries(ctx, e, spans)
+	b.record(err)
+	return err
+}
+
+func (c *Client) exportWithTimeout(ctx context.Context, e Exporter, spans []*SpanData) error {
+	if c.exportTimeout <= 0 {
+		return e.ExportSpans(ctx, spans)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.exportTimeout)
+	defer cancel()
+	ch := make(chan error, 1) // buffered, so that a late export doesn't block.
+	go func() { ch <- e.ExportSpans(ctx, spans) }()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		atomic.AddInt64(&c.stats.exportTimeouts, 1)
+		return ErrExportTimeout
+	}
+}
+
+// breaker returns the circuit breaker of the named exporter, creating it if
+// needed, or nil if the client's circuit breakers are disabled.
+func (c *Client) breaker(name string) *breaker {
+	if c.breakerFailures <= 0 {
+		return nil
+	}
+	if name == "" {
+		name = "default"
+	}
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+	b := c.breakers[name]
+	if b == nil {
+		if c.breakers == nil {
+			c.breakers = make(map[string]*breaker)
+		}
+		b = &breaker{failures: c.breakerFailures, cooldown: c.breakerCooldown, state: BreakerClosed}
+		c.breakers[name] = b
+	}
+	return b
+}
+
+// breakerStats returns the stats of the client's circuit breakers, by
+// exporter name, or nil if no breaker has been used.
+func (c *Client) breakerStats() map[string]BreakerStats {
+	if c == nil {
+		return nil
+	}
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+	if len(c.breakers) == 0 {
+		return nil
+	}
+	m := make(map[string]BreakerStats, len(c.breakers))
+	for name, b := range c.breakers {
+		m[name] = b.snapshot()
+	}
+	return m
+}
+
+// A breaker is the circuit breaker of one exporter.  A nil *breaker lets
+// every export through.
+type breaker struct {
+	failures int
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failed   int       // consecutive failures, while closed.
+	openedAt time.Time // while open.
+	probing  bool      // whether the probe is in flight, while half-open.
+	stats    BreakerStats
+}
+
+// allow reports whether an export may be attempted, moving an open breaker
+// whose cooldown has passed to half-open.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && breakerNow().Sub(b.openedAt) >= b.cooldown {
+		b.state = BreakerHalfOpen
+		b.stats.HalfOpened++
+		b.probing = false
+	}
+	switch b.state {
+	case BreakerOpen:
+		b.stats.Dropped++
+		return false
+	case BreakerHalfOpen:
+		if b.probing {
+			b.stats.Dropped++
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record records the result of an export that allow let through.
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case err == nil && b.state == BreakerHalfOpen:
+		b.state = BreakerClosed
+		b.stats.Closed++
+		b.failed = 0
+	case err == nil:
+		b.failed = 0
+	case b.state == BreakerHalfOpen:
+		b.open()
+	case b.state == BreakerClosed:
+		b.failed++
+		if b.failed >= b.failures {
+			b.open()
+		}
+	}
+}
+
+func (b *breaker) open() {
+	b.state = BreakerOpen
+	b.stats.Opened++
+	b.openedAt = breakerNow()
+	b.failed = 0
+	b.probing = false
+}
+
+func (b *breaker) snapshot() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.stats
+	st.State = b.state
+	return st
+}
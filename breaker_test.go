@@ -0,0 +1,173 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// hangingExporter is an Exporter that blocks until release is closed,
+// ignoring its context.
+type hangingExporter struct {
+	release chan struct{}
+}
+
+func (e hangingExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	<-e.release
+	return nil
+}
+
+func TestExportTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	tc := NewClientWithExporter(hangingExporter{release})
+	tc.SetExportTimeout(10 * time.Millisecond)
+
+	start := time.Now()
+	if err := tc.NewSpan("/hang").FinishWait(); err != ErrExportTimeout {
+		t.Errorf("FinishWait = %v; want %v", err, ErrExportTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("FinishWait took %v; want it to stop waiting after the timeout", elapsed)
+	}
+	if got := tc.Stats().ExportTimeouts; got != 1 {
+		t.Errorf("ExportTimeouts = %d; want 1", got)
+	}
+
+	// Exports that finish within the timeout are unaffected.
+	e := &recordingExporter{}
+	tc = NewClientWithExporter(e)
+	tc.SetExportTimeout(time.Minute)
+	if err := tc.NewSpan("/fast").FinishWait(); err != nil {
+		t.Errorf("FinishWait = %v; want nil", err)
+	}
+	if len(e.spans) != 1 {
+		t.Errorf("got %d exported spans; want 1", len(e.spans))
+	}
+	if got := tc.Stats().ExportTimeouts; got != 0 {
+		t.Errorf("ExportTimeouts = %d; want 0", got)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	breakerNow = func() time.Time { return now }
+	defer func() { breakerNow = time.Now }()
+
+	e := &failingExporter{err: errors.New("export failed")}
+	tc := NewClientWithExporter(e)
+	tc.SetCircuitBreaker(2, time.Minute)
+	health := func() int {
+		rec := httptest.NewRecorder()
+		tc.HealthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		return rec.Code
+	}
+	check := func(desc string, wantErr error, wantCalls int, want BreakerStats) {
+		t.Helper()
+		if err := tc.NewSpan("/span").FinishWait(); err != wantErr {
+			t.Errorf("%s: FinishWait = %v; want %v", desc, err, wantErr)
+		}
+		if got := len(e.spans); got != wantCalls {
+			t.Errorf("%s: exporter called with %d spans; want %d", desc, got, wantCalls)
+		}
+		if got := tc.Stats().Breakers["default"]; got != want {
+			t.Errorf("%s: breaker stats = %+v; want %+v", desc, got, want)
+		}
+	}
+
+	check("first failure", e.err, 1, BreakerStats{State: BreakerClosed})
+	if got := health(); got != 200 {
+		t.Errorf("closed breaker: health = %d; want 200", got)
+	}
+	check("second failure", e.err, 2, BreakerStats{State: BreakerOpen, Opened: 1})
+	check("open", ErrCircuitOpen, 2, BreakerStats{State: BreakerOpen, Opened: 1, Dropped: 1})
+	if got := health(); got != 503 {
+		t.Errorf("open breaker: health = %d; want 503", got)
+	}
+
+	// A failed probe opens the breaker again.
+	now = now.Add(time.Minute)
+	check("failed probe", e.err, 3, BreakerStats{State: BreakerOpen, Opened: 2, HalfOpened: 1, Dropped: 1})
+	check("reopened", ErrCircuitOpen, 3, BreakerStats{State: BreakerOpen, Opened: 2, HalfOpened: 1, Dropped: 2})
+
+	// Once the exporter recovers, a probe closes the breaker.
+	e.err = nil
+	now = now.Add(time.Minute)
+	check("probe", nil, 4, BreakerStats{State: BreakerClosed, Opened: 2, HalfOpened: 2, Closed: 1, Dropped: 2})
+	check("closed", nil, 5, BreakerStats{State: BreakerClosed, Opened: 2, HalfOpened: 2, Closed: 1, Dropped: 2})
+	if got := health(); got != 200 {
+		t.Errorf("recovered breaker: health = %d; want 200", got)
+	}
+}
+
+func TestCircuitBreakerTimeouts(t *testing.T) {
+	// Timeouts count as failures, so that a hung exporter stops being
+	// called.
+	release := make(chan struct{})
+	defer close(release)
+	tc := NewClientWithExporter(hangingExporter{release})
+	tc.SetExportTimeout(10 * time.Millisecond)
+	tc.SetCircuitBreaker(3, time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := tc.NewSpan("/hang").FinishWait(); err != ErrExportTimeout {
+			t.Errorf("export %d: FinishWait = %v; want %v", i, err, ErrExportTimeout)
+		}
+	}
+	start := time.Now()
+	if err := tc.NewSpan("/hang").FinishWait(); err != ErrCircuitOpen {
+		t.Errorf("FinishWait = %v; want %v", err, ErrCircuitOpen)
+	}
+	if elapsed := time.Since(start); elapsed >= 10*time.Millisecond {
+		t.Errorf("dropped export took %v; want it to return without waiting", elapsed)
+	}
+	st := tc.Stats()
+	if st.ExportTimeouts != 3 {
+		t.Errorf("ExportTimeouts = %d; want 3", st.ExportTimeouts)
+	}
+	if b := st.Breakers["default"]; b.State != BreakerOpen || b.Dropped != 1 {
+		t.Errorf("breaker stats = %+v; want open with 1 drop", b)
+	}
+}
+
+func TestCircuitBreakerPerExporter(t *testing.T) {
+	def := &recordingExporter{}
+	bad := &failingExporter{err: errors.New("export failed")}
+	tc := NewClientWithExporter(def)
+	tc.SetCircuitBreaker(1, time.Hour)
+	tc.RegisterExporter("bad", bad)
+	tc.SetRouter(func(s *SpanData) string {
+		if s.Name == "/bad" {
+			return "bad"
+		}
+		return ""
+	})
+	for i := 0; i < 2; i++ {
+		tc.NewSpan("/bad").FinishWait()
+		if err := tc.NewSpan("/good").FinishWait(); err != nil {
+			t.Errorf("default exporter: FinishWait = %v; want nil", err)
+		}
+	}
+	if len(bad.spans) != 1 || len(def.spans) != 2 {
+		t.Errorf("got %d spans for bad and %d for default; want 1 and 2", len(bad.spans), len(def.spans))
+	}
+	st := tc.Stats().Breakers
+	if st["bad"].State != BreakerOpen || st["default"].State != BreakerClosed {
+		t.Errorf("breaker states = %+v; want bad open and default closed", st)
+	}
+}
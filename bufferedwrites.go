@@ -0,0 +1,206 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// BufferedWrites returns a SpanOption for spans that are labeled or
+// annotated by many goroutines at once.  Calls to SetLabel and Annotate on
+// such a span go to a sharded buffer instead of taking the span's lock;
+// annotations are appended to lock-free slots.  The buffered writes are
+// applied to the span when it finishes: a label written more than once gets
+// its last value, as it would without the option, and annotations keep the
+// order they were made in.
+//
+// Buffered labels and annotations aren't visible until the span finishes:
+// a Snapshot of the span, for example, doesn't include them.  Writes that
+// race with Finish may be lost.
+func BufferedWrites() SpanOption {
+	return spanOption(func(c *spanConfig) {
+		c.bufferedWrites = true
+	})
+}
+
+const (
+	writeShards     = 8  // shards of a writeBuffer; a power of two.
+	writeShardSlots = 32 // lock-free annotation slots per shard.
+)
+
+// A labelWrite is the last value written to a label of a span with
+// BufferedWrites.
+type labelWrite struct {
+	seq   uint64 // order of the label's first write among the span's writes.
+	value string
+	auto  bool // set by the package, so not subject to WithLabelCap.
+}
+
+// A labelShard holds the buffered labels whose keys hash to it.  Since all
+// writes of a key go to the same shard, the map keeps the last of them.
+type labelShard struct {
+	mu     sync.Mutex
+	labels map[string]labelWrite
+}
+
+// An annotationWrite is an annotation buffered by a span with
+// BufferedWrites.
+type annotationWrite struct {
+	seq        uint64 // order of the write among the span's writes.
+	ready      uint32 // set atomically to 1 once annotation is written.
+	annotation Annotation
+}
+
+// An annotationShard holds some of the buffered annotations of a span.
+// Writers reserve one of slots with an atomic increment of n; when the
+// slots are used up, annotations are appended to overflow under mu.
+type annotationShard struct {
+	n        uint32
+	slots    [writeShardSlots]annotationWrite
+	mu       sync.Mutex
+	overflow []annotationWrite
+}
+
+// A writeBuffer holds the writes to a span created with BufferedWrites
+// until it finishes.  Labels are sharded by key, and annotations by their
+// sequence number, so concurrent writers rarely touch the same shard.
+type writeBuffer struct {
+	seq         uint64 // atomic; the number of annotations and new label keys so far.
+	merged      uint32 // atomic; set to 1 once the writes were applied to the span.
+	labels      [writeShards]labelShard
+	annotations [writeShards]annotationShard
+}
+
+// setLabel buffers a label, and reports whether it did; it doesn't once the
+// buffer was merged, or if b is nil.
+func (b *writeBuffer) setLabel(key, value string, auto bool) bool {
+	if b == nil || atomic.LoadUint32(&b.merged) != 0 {
+		return false
+	}
+	sh := &b.labels[keyShard(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	w, ok := sh.labels[key]
+	if !ok {
+		if sh.labels == nil {
+			sh.labels = make(map[string]labelWrite)
+		}
+		w.seq = atomic.AddUint64(&b.seq, 1)
+	}
+	w.value, w.auto = value, auto
+	sh.labels[key] = w
+	return true
+}
+
+// annotate buffers an annotation, and reports whether it did; it doesn't
+// once the buffer was merged, or if b is nil.
+func (b *writeBuffer) annotate(a Annotation) bool {
+	if b == nil || atomic.LoadUint32(&b.merged) != 0 {
+		return false
+	}
+	seq := atomic.AddUint64(&b.seq, 1)
+	sh := &b.annotations[seq%writeShards]
+	if i := atomic.AddUint32(&sh.n, 1) - 1; i < writeShardSlots {
+		slot := &sh.slots[i]
+		slot.seq, slot.annotation = seq, a
+		atomic.StoreUint32(&slot.ready, 1)
+		return true
+	}
+	sh.mu.Lock()
+	sh.overflow = append(sh.overflow, annotationWrite{seq: seq, annotation: a})
+	sh.mu.Unlock()
+	return true
+}
+
+// keyShard returns the index of the label shard of key, using the FNV-1a
+// hash.
+func keyShard(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % writeShards)
+}
+
+// bufferedLabel is a labelWrite with its key.
+type bufferedLabel struct {
+	key string
+	labelWrite
+}
+
+// drain marks b merged, and returns its labels and its annotations in the
+// order they were first written.  Annotations still being stored are
+// skipped.
+func (b *writeBuffer) drain() ([]bufferedLabel, []annotationWrite) {
+	if !atomic.CompareAndSwapUint32(&b.merged, 0, 1) {
+		return nil, nil
+	}
+	var labels []bufferedLabel
+	for i := range b.labels {
+		sh := &b.labels[i]
+		sh.mu.Lock()
+		for k, w := range sh.labels {
+			labels = append(labels, bufferedLabel{k, w})
+		}
+		sh.labels = nil
+		sh.mu.Unlock()
+	}
+	var annotations []annotationWrite
+	for i := range b.annotations {
+		sh := &b.annotations[i]
+		n := atomic.LoadUint32(&sh.n)
+		if n > writeShardSlots {
+			n = writeShardSlots
+		}
+		for j := uint32(0); j < n; j++ {
+			if slot := &sh.slots[j]; atomic.LoadUint32(&slot.ready) != 0 {
+				annotations = append(annotations, *slot)
+			}
+		}
+		sh.mu.Lock()
+		annotations = append(annotations, sh.overflow...)
+		sh.overflow = nil
+		sh.mu.Unlock()
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].seq < labels[j].seq })
+	sort.Slice(annotations, func(i, j int) bool { return annotations[i].seq < annotations[j].seq })
+	return labels, annotations
+}
+
+// mergeWrites applies the buffered writes of s, if it has any, to the span.
+func (s *Span) mergeWrites() {
+	if s.writes == nil {
+		return
+	}
+	labels, annotations := s.writes.drain()
+	for _, l := range labels {
+		if l.auto {
+			s.setLabel(l.key, l.value)
+		} else {
+			s.setCappedLabel(l.key, l.value)
+		}
+	}
+	if len(annotations) == 0 {
+		return
+	}
+	s.spanMu.Lock()
+	for _, a := range annotations {
+		s.annotations.add(a.annotation)
+	}
+	s.spanMu.Unlock()
+}
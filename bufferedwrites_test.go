@@ -0,0 +1,170 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestBufferedWritesMergeOrder(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	// LongLived keeps every annotation, so that their order can be checked.
+	span := root.NewChild("buffered", BufferedWrites(), LongLived(3*writeShardSlots*writeShards))
+	if span.writes == nil {
+		t.Fatal("span has no write buffer")
+	}
+	span.SetLabel("a", "1")
+	span.SetLabel("b", "1")
+	span.SetLabel("a", "2") // the last write wins.
+	span.SetLabel("b", "")  // deleting a label is a write too.
+	span.SetLabel("c", "")
+	span.SetLabel("c", "1")
+	for i := 0; i < 3*writeShardSlots*writeShards; i++ {
+		span.SetLabel("n", fmt.Sprint(i))
+	}
+	for i := 0; i < 3*writeShardSlots*writeShards; i++ { // overflow every shard.
+		span.Annotate(fmt.Sprint(i))
+	}
+	if _, ok := spanLabel(span, "a"); ok {
+		t.Error("buffered label is stored before Finish")
+	}
+
+	span.Finish()
+	want := map[string]string{"a": "2", "c": "1", "n": fmt.Sprint(3*writeShardSlots*writeShards - 1)}
+	for k, v := range want {
+		if got, _ := spanLabel(span, k); got != v {
+			t.Errorf("label %q = %q; want %q", k, got, v)
+		}
+	}
+	if _, ok := spanLabel(span, "b"); ok {
+		t.Error("deleted label b is stored")
+	}
+	span.spanMu.Lock()
+	annotations := span.annotations.copy()
+	span.spanMu.Unlock()
+	if len(annotations) != 3*writeShardSlots*writeShards {
+		t.Fatalf("got %d annotations; want %d", len(annotations), 3*writeShardSlots*writeShards)
+	}
+	for i, a := range annotations {
+		if a.Message != fmt.Sprint(i) {
+			t.Fatalf("annotation %d is %q; want annotations in the order they were made", i, a.Message)
+		}
+	}
+
+	// Writes after the merge are stored directly.
+	span.SetLabel("late", "1")
+	if got, _ := spanLabel(span, "late"); got != "1" {
+		t.Errorf("label set after Finish = %q; want %q", got, "1")
+	}
+}
+
+func TestBufferedWritesAutomaticLabels(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpanWithOptions("/root", BufferedWrites())
+	span.SetLabel(labelHost, "user")
+	span.setLabel(labelHost, "auto")
+	span.Finish()
+	if got, _ := spanLabel(span, labelHost); got != "auto" {
+		t.Errorf("label = %q; want the automatic value %q", got, "auto")
+	}
+}
+
+func TestBufferedWritesConcurrent(t *testing.T) {
+	const (
+		goroutines = 16
+		perRoutine = 200
+	)
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpanWithOptions("/root", BufferedWrites(), LongLived(goroutines*perRoutine))
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprint("g", i)
+			for j := 0; j < perRoutine; j++ {
+				span.SetLabel(key, fmt.Sprint(j))
+				span.Annotate(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	span.Finish()
+	for i := 0; i < goroutines; i++ {
+		key := fmt.Sprint("g", i)
+		if got, want := storedLabel(span, key), fmt.Sprint(perRoutine-1); got != want {
+			t.Errorf("label %q = %q; want %q", key, got, want)
+		}
+	}
+	span.spanMu.Lock()
+	n := len(span.annotations.list)
+	span.spanMu.Unlock()
+	if want := goroutines * perRoutine; n != want {
+		t.Errorf("got %d annotations; want %d", n, want)
+	}
+}
+
+func storedLabel(s *Span, key string) string {
+	v, _ := spanLabel(s, key)
+	return v
+}
+
+func benchmarkContendedLabels(b *testing.B, opts ...SpanOption) {
+	const goroutines = 16
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpanWithOptions("/root", opts...)
+	var keys [goroutines]string
+	for i := range keys {
+		keys[i] = fmt.Sprint("g", i)
+	}
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for j := 0; j < b.N/goroutines; j++ {
+				span.SetLabel(key, "v")
+			}
+		}(keys[i])
+	}
+	wg.Wait()
+	span.Finish()
+}
+
+func BenchmarkSetLabelContended(b *testing.B) { benchmarkContendedLabels(b) }
+
+func BenchmarkSetLabelContendedBuffered(b *testing.B) {
+	benchmarkContendedLabels(b, BufferedWrites())
+}
+
+func TestBufferedWritesLabelCap(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpanWithOptions("/root", BufferedWrites())
+	span.trace.labelCap = 2
+	for _, k := range []string{"a", "b", "c"} {
+		span.SetLabel(k, "v")
+	}
+	span.Finish()
+	if _, ok := spanLabel(span, "c"); ok {
+		t.Error("label over the cap is stored")
+	}
+	if got := span.labelsDropped; got != 1 {
+		t.Errorf("labelsDropped = %d; want 1", got)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "fmt"
+
+// SetBundling sets the thresholds of the bundler that collects finished
+// traces into uploads.  The sizes of the bundler are in spans: a trace counts
+// as one more than its number of spans.
+//
+//   - DelayThreshold is how long a trace waits before it is uploaded, if no
+//     other threshold is reached; the default is 2s.  Low-traffic programs
+//     whose traces should show up quickly want a short delay, and high-QPS
+//     services that want fewer, bigger uploads a longer one.
+//   - BundleCountThreshold is the number of traces that starts an upload;
+//     the default is 100.
+//   - BundleByteThreshold is the number of spans that starts an upload, and
+//     BundleByteLimit the maximum number of spans of an upload; both default
+//     to 1000.  A trace bigger than BundleByteLimit is uploaded by itself.
+//   - BufferedByteLimit is the maximum number of spans waiting to be
+//     uploaded; the default is 10000.  Once it is reached, newly finished
+//     traces are discarded, and counted in Stats.SpansDropped, or saved to
+//     the spill directory, if there is one; the traces already waiting are
+//     kept.
+//   - HandlerLimit is the number of uploads that may run at once; the
+//     default is 1.
+//
+// Zero fields keep their current values.  SetBundling returns an error,
+// and changes nothing, if a field is negative, BundleByteThreshold is over
+// BundleByteLimit, or BundleByteLimit is over BufferedByteLimit.
+//
+// SetBundling should be called before any spans are created, and before
+// SetBatchAutotuning, which sets BundleCountThreshold.
+func (c *Client) SetBundling(cfg BundlerConfig) error {
+	if c == nil {
+		return nil
+	}
+	if cfg.DelayThreshold < 0 || cfg.BundleCountThreshold < 0 || cfg.BundleByteThreshold < 0 ||
+		cfg.BundleByteLimit < 0 || cfg.BufferedByteLimit < 0 || cfg.HandlerLimit < 0 {
+		return fmt.Errorf("trace: negative bundler threshold in %+v", cfg)
+	}
+	b := c.bundler
+	next := BundlerConfig{
+		DelayThreshold:       b.DelayThreshold,
+		BundleCountThreshold: b.BundleCountThreshold,
+		BundleByteThreshold:  b.BundleByteThreshold,
+		BundleByteLimit:      b.BundleByteLimit,
+		BufferedByteLimit:    b.BufferedByteLimit,
+		HandlerLimit:         b.HandlerLimit,
+	}
+	if cfg.DelayThreshold != 0 {
+		next.DelayThreshold = cfg.DelayThreshold
+	}
+	if cfg.BundleCountThreshold != 0 {
+		next.BundleCountThreshold = cfg.BundleCountThreshold
+	}
+	if cfg.BundleByteThreshold != 0 {
+		next.BundleByteThreshold = cfg.BundleByteThreshold
+	}
+	if cfg.BundleByteLimit != 0 {
+		next.BundleByteLimit = cfg.BundleByteLimit
+	}
+	if cfg.BufferedByteLimit != 0 {
+		next.BufferedByteLimit = cfg.BufferedByteLimit
+	}
+	if cfg.HandlerLimit != 0 {
+		next.HandlerLimit = cfg.HandlerLimit
+	}
+	if next.BundleByteThreshold > next.BundleByteLimit {
+		return fmt.Errorf("trace: bundle threshold of %d spans is over the bundle limit of %d", next.BundleByteThreshold, next.BundleByteLimit)
+	}
+	if next.BundleByteLimit > next.BufferedByteLimit {
+		return fmt.Errorf("trace: bundle limit of %d spans is over the buffer limit of %d", next.BundleByteLimit, next.BufferedByteLimit)
+	}
+	b.DelayThreshold = next.DelayThreshold
+	b.BundleCountThreshold = next.BundleCountThreshold
+	b.BundleByteThreshold = next.BundleByteThreshold
+	b.BundleByteLimit = next.BundleByteLimit
+	b.BufferedByteLimit = next.BufferedByteLimit
+	b.HandlerLimit = next.HandlerLimit
+	return nil
+}
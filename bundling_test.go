@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetBundling(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	if err := tc.SetBundling(BundlerConfig{DelayThreshold: 10 * time.Millisecond, BundleByteLimit: 2000, HandlerLimit: 4}); err != nil {
+		t.Fatal(err)
+	}
+	want := BundlerConfig{
+		DelayThreshold:       10 * time.Millisecond,
+		BundleCountThreshold: 100,
+		BundleByteThreshold:  1000,
+		BundleByteLimit:      2000,
+		BufferedByteLimit:    10000,
+		HandlerLimit:         4,
+	}
+	if got := tc.Config().Bundler; got != want {
+		t.Fatalf("Bundler = %+v; want %+v", got, want)
+	}
+	for _, bad := range []BundlerConfig{
+		{DelayThreshold: -time.Second},
+		{HandlerLimit: -1},
+		{BundleByteThreshold: 3000},
+		{BufferedByteLimit: 1000},
+	} {
+		if err := tc.SetBundling(bad); err == nil {
+			t.Errorf("SetBundling(%+v) succeeded; want an error", bad)
+		}
+	}
+	if got := tc.Config().Bundler; got != want {
+		t.Errorf("Bundler after invalid settings = %+v; want %+v", got, want)
+	}
+}
+
+func TestSetBundlingOverflow(t *testing.T) {
+	e := &blockingExporter{started: make(chan struct{}, 1), release: make(chan struct{})}
+	tc := NewClientWithExporter(e)
+	tc.SetErrorHandler(func(error) {})
+	if err := tc.SetBundling(BundlerConfig{DelayThreshold: time.Hour, BundleByteThreshold: 4, BundleByteLimit: 4, BufferedByteLimit: 4}); err != nil {
+		t.Fatal(err)
+	}
+	// A trace of one span counts as two bytes, so the first 2 traces fill the
+	// buffer, and their upload blocks; the others are discarded.
+	for i := 0; i < 10; i++ {
+		tc.NewSpan("/root").Finish()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for tc.Stats().SpansDropped != 8 {
+		if time.Now().After(deadline) {
+			t.Fatalf("SpansDropped = %d; want 8", tc.Stats().SpansDropped)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(e.release)
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if got := len(e.spans); got != 2 {
+		t.Errorf("exported %d spans; want 2", got)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type callLabelsKey struct{}
+
+// callLabels are labels for the span of the next call made with a context.
+type callLabels struct {
+	claimed int32 // accessed atomically; nonzero once a call has used labels.
+	labels  map[string]string
+}
+
+// claim reports whether the caller is the first to use cl.
+func (cl *callLabels) claim() bool {
+	return atomic.CompareAndSwapInt32(&cl.claimed, 0, 1)
+}
+
+// LabelNextCall returns a copy of ctx that makes the next gRPC call made with
+// it, through GRPCClientInterceptor or GRPCStreamClientInterceptor, add the
+// label key with the given value to the call's span:
+//
+//   ctx = trace.LabelNextCall(ctx, "shard", "7")
+//   resp, err := client.Get(ctx, req)
+//
+// The labels are used once.  If the context is reused for more calls, as
+// when the same outgoing context is passed to several sequential or
+// concurrent calls, only the call that claims the labels first gets them;
+// the spans of the other calls are otherwise unaffected.  Labels from
+// earlier calls to LabelNextCall on ctx that no call has used yet are kept.
+func LabelNextCall(ctx context.Context, key, value string) context.Context {
+	labels := map[string]string{key: value}
+	if prev, ok := ctx.Value(callLabelsKey{}).(*callLabels); ok && prev.claim() {
+		// Take over the unused labels, so that calls made with the parent
+		// context don't use them too.
+		for k, v := range prev.labels {
+			if _, ok := labels[k]; !ok {
+				labels[k] = v
+			}
+		}
+	}
+	return context.WithValue(ctx, callLabelsKey{}, &callLabels{labels: labels})
+}
+
+// applyCallLabels sets the labels from LabelNextCall in ctx on span, if no
+// other call has used them.
+func applyCallLabels(ctx context.Context, span *Span) {
+	cl, ok := ctx.Value(callLabelsKey{}).(*callLabels)
+	if !ok || !cl.claim() {
+		return
+	}
+	for k, v := range cl.labels {
+		span.SetLabel(k, v)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestLabelNextCallReusedContext(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	ctx = LabelNextCall(ctx, "shard", "7")
+	ctx = LabelNextCall(ctx, "attempt", "1")
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	intercept := GRPCClientInterceptor()
+	for i := 0; i < 3; i++ {
+		if err := intercept(ctx, fmt.Sprintf("/call%d", i), nil, nil, nil, invoker); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"shard": "7", "attempt": "1"}
+	if got := withoutCallLabels(e.span("/call0").Labels); !reflect.DeepEqual(got, want) {
+		t.Errorf("first call labels = %v; want %v", got, want)
+	}
+	for _, name := range []string{"/call1", "/call2"} {
+		if got := withoutCallLabels(e.span(name).Labels); len(got) != 0 {
+			t.Errorf("%s labels = %v; want none", name, got)
+		}
+	}
+}
+
+func TestLabelNextCallClaimedOnce(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	ctx := LabelNextCall(NewContext(context.Background(), root), "k", "v")
+
+	var children []*Span
+	for i := 0; i < 10; i++ {
+		children = append(children, root.NewChild("/call"))
+	}
+	var wg sync.WaitGroup
+	for _, child := range children {
+		wg.Add(1)
+		go func(child *Span) {
+			defer wg.Done()
+			applyCallLabels(ctx, child)
+		}(child)
+	}
+	wg.Wait()
+
+	n := 0
+	for _, child := range children {
+		if child.span.Labels["k"] == "v" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("labels were applied to %d spans; want 1", n)
+	}
+}
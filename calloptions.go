@@ -0,0 +1,89 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+
+	"google.golang.org/grpc"
+)
+
+// A CallOptionLabel is a label that the client interceptors can set from the
+// grpc.CallOptions of a call; see WithCallOptionLabels.
+type CallOptionLabel string
+
+const (
+	// WaitForReadyLabel is set to "true" or "false" when the call has a
+	// grpc.WaitForReady option.
+	WaitForReadyLabel CallOptionLabel = `grpc/wait_for_ready`
+
+	// MaxRecvBytesLabel is set to the limit of a grpc.MaxCallRecvMsgSize
+	// option of the call.
+	MaxRecvBytesLabel CallOptionLabel = `grpc/max_recv_bytes`
+)
+
+// callOptionMask is a set of CallOptionLabels.
+type callOptionMask uint8
+
+const (
+	callOptionWaitForReady callOptionMask = 1 << iota
+	callOptionMaxRecvBytes
+)
+
+type withCallOptionLabels []CallOptionLabel
+
+// WithCallOptionLabels returns an InterceptorOption that makes the client
+// interceptors label the span of each call with the given labels, from the
+// grpc.CallOptions of the call.  The options include the defaults set on the
+// grpc.ClientConn with grpc.WithDefaultCallOptions, which gRPC merges with
+// the options of each call, so the labels show the behavior of the call
+// wherever it was configured:
+//
+//   conn, err := grpc.Dial(target,
+//     grpc.WithDefaultCallOptions(grpc.WaitForReady(true)),
+//     grpc.WithUnaryInterceptor(trace.GRPCClientInterceptor(trace.WithCallOptionLabels(trace.WaitForReadyLabel))))
+//
+// A label is only set if the call has the corresponding option.
+func WithCallOptionLabels(labels ...CallOptionLabel) InterceptorOption {
+	return withCallOptionLabels(labels)
+}
+
+func (o withCallOptionLabels) configureInterceptor(c *interceptorConfig) {
+	for _, l := range o {
+		switch l {
+		case WaitForReadyLabel:
+			c.callOptionLabels |= callOptionWaitForReady
+		case MaxRecvBytesLabel:
+			c.callOptionLabels |= callOptionMaxRecvBytes
+		}
+	}
+}
+
+// setCallOptionLabels labels span with the options in opts that are in want.
+// Later options override earlier ones, as they do in gRPC.
+func setCallOptionLabels(span *Span, opts []grpc.CallOption, want callOptionMask) {
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.FailFastCallOption:
+			if want&callOptionWaitForReady != 0 {
+				span.setLabel(string(WaitForReadyLabel), strconv.FormatBool(!o.FailFast))
+			}
+		case grpc.MaxRecvMsgSizeCallOption:
+			if want&callOptionMaxRecvBytes != 0 {
+				span.setLabel(string(MaxRecvBytesLabel), strconv.Itoa(o.MaxRecvMsgSize))
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCallOptionLabels(t *testing.T) {
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	both := WithCallOptionLabels(WaitForReadyLabel, MaxRecvBytesLabel)
+	for _, tt := range []struct {
+		desc  string
+		iopts []InterceptorOption
+		copts []grpc.CallOption
+		want  map[string]string
+	}{
+		{
+			desc:  "both",
+			iopts: []InterceptorOption{both},
+			copts: []grpc.CallOption{grpc.WaitForReady(true), grpc.MaxCallRecvMsgSize(1024)},
+			want:  map[string]string{"grpc/wait_for_ready": "true", "grpc/max_recv_bytes": "1024"},
+		},
+		{
+			desc:  "last option wins",
+			iopts: []InterceptorOption{both},
+			copts: []grpc.CallOption{grpc.WaitForReady(true), grpc.WaitForReady(false)},
+			want:  map[string]string{"grpc/wait_for_ready": "false"},
+		},
+		{
+			desc:  "not opted in",
+			iopts: []InterceptorOption{WithCallOptionLabels(MaxRecvBytesLabel)},
+			copts: []grpc.CallOption{grpc.WaitForReady(true)},
+		},
+		{
+			desc:  "no options",
+			iopts: []InterceptorOption{both},
+		},
+		{
+			desc:  "no labels",
+			copts: []grpc.CallOption{grpc.WaitForReady(true), grpc.MaxCallRecvMsgSize(1024)},
+		},
+	} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		root := tc.NewSpan("/root")
+		GRPCClientInterceptor(tt.iopts...)(NewContext(context.Background(), root), "/call", nil, nil, nil, invoker, tt.copts...)
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		if got := withoutCallLabels(e.span("/call").Labels); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: labels = %v; want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestCallOptionLabelsConnDefaults(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	r := &relay{md: make(chan metadata.MD, 1)}
+	lis, stop := startRelay(r)
+	defer stop()
+	conn := dialEcho(t, lis,
+		option.WithGRPCDialOption(grpc.WithDefaultCallOptions(grpc.WaitForReady(true))),
+		option.WithGRPCDialOption(grpc.WithUnaryInterceptor(GRPCClientInterceptor(WithCallOptionLabels(WaitForReadyLabel)))))
+	defer conn.Close()
+
+	root := tc.NewSpan("/root")
+	if err := conn.Invoke(NewContext(context.Background(), root), "/test.Relay/Call", &empty.Empty{}, &empty.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.span("/test.Relay/Call").Labels["grpc/wait_for_ready"]; got != "true" {
+		t.Errorf("grpc/wait_for_ready = %q; want %q", got, "true")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+const (
+	// cardinalityBits is the size of the bitmap used to estimate the number
+	// of distinct values of each label key.  Estimates are accurate to a few
+	// percent up to about this many values.
+	cardinalityBits = 1 << 14
+
+	// maxMonitoredKeys bounds the number of label keys whose values are
+	// counted, and so the memory used by the monitor.
+	maxMonitoredKeys = 1000
+)
+
+// A CardinalityError reports a label key with more distinct values than the
+// limit set by Client.SetLabelCardinalityLimit.  Such labels, like user IDs,
+// make the traces hard to query.
+type CardinalityError struct {
+	Key      string
+	Estimate int // approximate number of distinct values seen.
+	Limit    int
+}
+
+func (e *CardinalityError) Error() string {
+	return fmt.Sprintf("trace: label %q has about %d distinct values, more than the limit of %d", e.Key, e.Estimate, e.Limit)
+}
+
+// SetLabelCardinalityLimit makes the client estimate the number of distinct
+// values of each label key in the spans it exports, and report a
+// *CardinalityError to the error handler the first time a key has more than
+// limit values.  Labels are not modified or dropped.
+//
+// The values of at most 1000 keys are counted, and estimates are only
+// meaningful up to about 16000 values.  A limit of zero, the default,
+// disables counting.
+//
+// SetLabelCardinalityLimit should be called before any spans are created.
+func (c *Client) SetLabelCardinalityLimit(limit int) {
+	if c == nil {
+		return
+	}
+	c.cardinality = nil
+	if limit > 0 {
+		c.cardinality = &cardinalityMonitor{
+			limit:    limit,
+			counters: make(map[string]*distinctCounter),
+		}
+	}
+}
+
+type cardinalityMonitor struct {
+	limit int
+
+	mu       sync.Mutex
+	counters map[string]*distinctCounter // nil after the key is reported.
+}
+
+// observe counts the label values of spans, and returns errors for the keys
+// that exceeded the limit for the first time.
+func (m *cardinalityMonitor) observe(spans []*SpanData) []error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var errs []error
+	for _, s := range spans {
+		for k, v := range s.Labels {
+			dc, ok := m.counters[k]
+			if !ok {
+				if len(m.counters) >= maxMonitoredKeys {
+					continue
+				}
+				dc = &distinctCounter{}
+				m.counters[k] = dc
+			}
+			if dc == nil {
+				continue // already reported.
+			}
+			if dc.add(v) && dc.estimate() > m.limit {
+				errs = append(errs, &CardinalityError{Key: k, Estimate: dc.estimate(), Limit: m.limit})
+				// Keep the key, so it isn't counted again, but free its bitmap.
+				m.counters[k] = nil
+			}
+		}
+	}
+	return errs
+}
+
+// distinctCounter estimates the number of distinct values added to it, using
+// linear counting: each value sets one bit of a bitmap, chosen by its hash,
+// and the number of values is estimated from the fraction of unset bits.
+type distinctCounter struct {
+	bits [cardinalityBits / 64]uint64
+	set  int // number of set bits.
+}
+
+// add adds v, and reports whether it set a new bit.
+func (dc *distinctCounter) add(v string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(v))
+	i := h.Sum64() % cardinalityBits
+	word, bit := i/64, uint64(1)<<(i%64)
+	if dc.bits[word]&bit != 0 {
+		return false
+	}
+	dc.bits[word] |= bit
+	dc.set++
+	return true
+}
+
+func (dc *distinctCounter) estimate() int {
+	unset := cardinalityBits - dc.set
+	if unset == 0 {
+		unset = 1 // saturated; the estimate is a lower bound.
+	}
+	return int(-cardinalityBits * math.Log(float64(unset)/cardinalityBits))
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestLabelCardinalityLimit(t *testing.T) {
+	e := &recordingExporter{}
+	c := NewClientWithExporter(e)
+	var errs []error
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	c.SetLabelCardinalityLimit(100)
+
+	for i := 0; i < 2000; i++ {
+		s := validSpanData()
+		s.SpanID = uint64(i + 1)
+		s.Labels = map[string]string{
+			"user":   fmt.Sprintf("user-%d", i),
+			"query":  fmt.Sprintf("q%d", i%500),
+			"method": []string{"GET", "PUT", "POST"}[i%3],
+			"shard":  fmt.Sprint(i % 50),
+		}
+		if err := c.export([]*SpanData{s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(e.spans); got != 2000 {
+		t.Errorf("got %d exported spans; want 2000", got)
+	}
+	for _, s := range e.spans {
+		if len(s.Labels) != 4 {
+			t.Fatalf("exported labels %v; want all 4 labels", s.Labels)
+		}
+	}
+
+	var keys []string
+	for _, err := range errs {
+		ce, ok := err.(*CardinalityError)
+		if !ok {
+			t.Fatalf("got error %v; want a *CardinalityError", err)
+		}
+		if ce.Estimate <= ce.Limit {
+			t.Errorf("%s: estimate %d not over the limit %d", ce.Key, ce.Estimate, ce.Limit)
+		}
+		keys = append(keys, ce.Key)
+	}
+	sort.Strings(keys)
+	if got, want := fmt.Sprint(keys), "[query user]"; got != want {
+		t.Errorf("reported keys %s; want %s", got, want)
+	}
+}
+
+func TestLabelCardinalityLimitDisabled(t *testing.T) {
+	c := NewClientWithExporter(&recordingExporter{})
+	var errs []error
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	c.SetLabelCardinalityLimit(10)
+	c.SetLabelCardinalityLimit(0)
+	for i := 0; i < 100; i++ {
+		s := validSpanData()
+		s.Labels = map[string]string{"user": fmt.Sprint(i)}
+		c.export([]*SpanData{s})
+	}
+	if len(errs) != 0 {
+		t.Errorf("got errors %v; want none", errs)
+	}
+}
+
+func TestDistinctCounter(t *testing.T) {
+	for _, n := range []int{10, 100, 1000, 10000} {
+		var dc distinctCounter
+		for i := 0; i < n; i++ {
+			dc.add(fmt.Sprint(i))
+			dc.add(fmt.Sprint(i)) // duplicates aren't counted.
+		}
+		if got := dc.estimate(); got < n*95/100 || got > n*105/100 {
+			t.Errorf("estimate of %d values = %d; want within 5%%", n, got)
+		}
+	}
+}
+
+func TestCardinalityMonitorKeyLimit(t *testing.T) {
+	m := &cardinalityMonitor{limit: 1, counters: make(map[string]*distinctCounter)}
+	s := validSpanData()
+	s.Labels = map[string]string{}
+	for i := 0; i < maxMonitoredKeys+10; i++ {
+		s.Labels[fmt.Sprintf("key%d", i)] = "v"
+	}
+	m.observe([]*SpanData{s})
+	if got := len(m.counters); got != maxMonitoredKeys {
+		t.Errorf("monitoring %d keys; want %d", got, maxMonitoredKeys)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "context"
+
+const labelCancelCause = `cancel/cause`
+
+// setCancelCause labels span with the cause of the cancellation of ctx, if
+// ctx is done and its cause is more specific than ctx.Err(), as when ctx was
+// cancelled by a load shedder or by a parent call.
+func setCancelCause(span *Span, ctx context.Context) {
+	err := ctx.Err()
+	if err == nil {
+		return
+	}
+	if cause := contextCause(ctx); cause != nil && cause != err {
+		span.setLabel(labelCancelCause, cause.Error())
+	}
+}
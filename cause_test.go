@@ -0,0 +1,109 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.20
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var errLoadShed = errors.New("load shed")
+
+// cancelledWithCause returns a cancelled child of parent, with cause as the
+// cause of its cancellation if it is non-nil.
+func cancelledWithCause(parent context.Context, cause error) context.Context {
+	ctx, cancel := context.WithCancelCause(parent)
+	cancel(cause)
+	return ctx
+}
+
+// newCauseClient returns a client that exports each trace as soon as it
+// finishes, and a function that waits for the next export and returns the
+// cancel/cause label of its span named name.
+func newCauseClient() (*Client, func(name string) string) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+	return tc, func(name string) string {
+		<-e.exported
+		return e.span(name).Labels[labelCancelCause]
+	}
+}
+
+func TestCancelCauseUnary(t *testing.T) {
+	for _, tt := range []struct {
+		cause error
+		want  string
+	}{
+		{errLoadShed, "load shed"},
+		{nil, ""}, // plain cancellation
+	} {
+		tc, exported := newCauseClient()
+
+		// Client side.
+		root := tc.NewSpan("/root")
+		ctx := cancelledWithCause(NewContext(context.Background(), root), tt.cause)
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return ctx.Err()
+		}
+		GRPCClientInterceptor()(ctx, "/client", nil, nil, nil, invoker)
+		root.Finish()
+		if got := exported("/client"); got != tt.want {
+			t.Errorf("client span %s = %q; want %q", labelCancelCause, got, tt.want)
+		}
+
+		// Server side.
+		md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+		ctx = cancelledWithCause(metadata.NewIncomingContext(context.Background(), md), tt.cause)
+		GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/server"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, ctx.Err()
+		})
+		if got := exported(""); got != tt.want {
+			t.Errorf("server span %s = %q; want %q", labelCancelCause, got, tt.want)
+		}
+	}
+}
+
+func TestCancelCauseStream(t *testing.T) {
+	tc, exported := newCauseClient()
+	ss := newTracedStream(0)
+	ss.ctx = cancelledWithCause(ss.ctx, errLoadShed)
+	GRPCStreamServerInterceptor(tc)(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		return ss.Context().Err()
+	})
+	if got := exported(""); got != "load shed" {
+		t.Errorf("stream span %s = %q; want %q", labelCancelCause, got, "load shed")
+	}
+}
+
+func TestCancelCauseHTTPHandler(t *testing.T) {
+	tc, exported := newCauseClient()
+	h := tc.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Set(httpHeader, "0123456789abcdef0123456789abcdef/1;o=1")
+	req = req.WithContext(cancelledWithCause(req.Context(), errLoadShed))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got := exported("example.com/foo"); got != "load shed" {
+		t.Errorf("handler span %s = %q; want %q", labelCancelCause, got, "load shed")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// checkpointAt makes the checkpoints of s be at the given offsets from base.
+func checkpointAt(s *Span, base time.Time, offsets ...time.Duration) {
+	for i, d := range offsets {
+		s.checkpoints[i].time = base.Add(d)
+	}
+}
+
+func TestCheckpointLabels(t *testing.T) {
+	var nilSpan *Span
+	nilSpan.Checkpoint("nil")
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	base := time.Now()
+	root.SetStartTime(base)
+	for _, name := range []string{"parse", "plan", "execute", "plan"} {
+		root.Checkpoint(name)
+	}
+	checkpointAt(root, base, 5*time.Millisecond, 25*time.Millisecond, 55*time.Millisecond, 155*time.Millisecond)
+	root.FinishAt(base.Add(160 * time.Millisecond))
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	s := e.span("/root")
+	if s == nil {
+		t.Fatalf("exported spans %v; want /root", names(e.spans))
+	}
+	for k, want := range map[string]string{
+		"phase/parse_ms":   "20",
+		"phase/plan_ms":    "35",
+		"phase/execute_ms": "100",
+	} {
+		if got := s.Labels[k]; got != want {
+			t.Errorf("label %q = %q; want %q", k, got, want)
+		}
+	}
+	if len(e.spans) != 1 {
+		t.Errorf("exported spans %v; want only /root", names(e.spans))
+	}
+}
+
+func TestCheckpointSpans(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	base := time.Now()
+	child := root.NewChild("compute", CheckpointSpans())
+	child.SetStartTime(base)
+	for _, name := range []string{"parse", "plan", "execute"} {
+		child.Checkpoint(name)
+	}
+	checkpointAt(child, base, 0, 10*time.Millisecond, 40*time.Millisecond)
+	child.FinishAt(base.Add(100 * time.Millisecond))
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := e.span("compute")
+	if parent == nil {
+		t.Fatalf("exported spans %v; want compute", names(e.spans))
+	}
+	for _, tt := range []struct {
+		name       string
+		start, end time.Duration
+	}{
+		{"parse", 0, 10 * time.Millisecond},
+		{"plan", 10 * time.Millisecond, 40 * time.Millisecond},
+		{"execute", 40 * time.Millisecond, 100 * time.Millisecond},
+	} {
+		s := e.span(tt.name)
+		if s == nil {
+			t.Errorf("exported spans %v; want %s", names(e.spans), tt.name)
+			continue
+		}
+		if s.ParentSpanID != parent.SpanID {
+			t.Errorf("phase %s has parent %d; want compute, %d", tt.name, s.ParentSpanID, parent.SpanID)
+		}
+		if !s.Start.Equal(base.Add(tt.start)) || !s.End.Equal(base.Add(tt.end)) {
+			t.Errorf("phase %s is from %v to %v; want %v to %v", tt.name, s.Start.Sub(base), s.End.Sub(base), tt.start, tt.end)
+		}
+	}
+	if _, ok := parent.Labels["phase/parse_ms"]; ok {
+		t.Error("span with CheckpointSpans has phase labels")
+	}
+}
+
+func TestCheckpointUntraced(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(neverTrace{})
+	span := tc.NewSpan("/root")
+	span.Checkpoint("phase")
+	if span.checkpoints != nil {
+		t.Error("untraced span recorded a checkpoint")
+	}
+	span.Finish()
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "context"
+
+// WithRootSpans returns an InterceptorOption that makes the client
+// interceptors start a new trace, with tc, for outgoing calls whose context
+// has no span, instead of leaving them untraced.  It's useful in processes
+// that start work themselves, such as cron jobs and queue consumers, whose
+// calls aren't made on behalf of a traced request.  The root span of the
+// new trace is the span of the call, named after its method.  It is traced
+// unless tc has a sampling policy, which then decides, as for a span
+// created with NewSpan, and its trace is uploaded when the call finishes.
+// The trace header sent with a call that isn't traced asks the callee not
+// to trace it either.
+//
+// Calls with a span in their context are traced as children of that span,
+// as without the option.  A nil tc disables the option.
+func WithRootSpans(tc *Client) InterceptorOption {
+	return withRootSpans{tc}
+}
+
+type withRootSpans struct {
+	client *Client
+}
+
+func (o withRootSpans) configureInterceptor(c *interceptorConfig) {
+	c.rootClient = o.client
+}
+
+// clientSpan returns the span of an outgoing call of method: a child of the
+// span in ctx, or a new root span under WithRootSpans.
+func (c *interceptorConfig) clientSpan(ctx context.Context, method string) *Span {
+	parent := FromContext(ctx)
+	if parent == nil && c.rootClient != nil {
+		span := c.rootClient.newRootSpan(method, spanKindClient)
+		if !span.tracing() {
+			// Don't ask the callee to trace a call the policy didn't sample.
+			span.options.global &^= optionTrace
+		}
+		return span
+	}
+	// Set the kind directly rather than with WithSpanKind, whose option
+	// would be allocated for every call.
+	span := parent.NewChild(method)
+	if span != nil && span.tracing() {
+		span.span.Kind = spanKindClient
+	}
+	return span
+}
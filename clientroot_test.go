@@ -0,0 +1,94 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWithRootSpans(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		opts   func(tc *Client) []InterceptorOption
+		policy SamplingPolicy
+		traced bool
+	}{
+		{desc: "no option", opts: func(*Client) []InterceptorOption { return nil }},
+		{desc: "option", opts: func(tc *Client) []InterceptorOption { return []InterceptorOption{WithRootSpans(tc)} }, traced: true},
+		{desc: "nil client", opts: func(*Client) []InterceptorOption { return []InterceptorOption{WithRootSpans(nil)} }},
+		{desc: "not sampled", opts: func(tc *Client) []InterceptorOption { return []InterceptorOption{WithRootSpans(tc)} }, policy: neverTrace{}},
+	} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		if tt.policy != nil {
+			tc.SetSamplingPolicy(tt.policy)
+		}
+		var headers []string
+		record := func(ctx context.Context) {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			headers = append(headers, md.Get(grpcMetadataKey)...)
+		}
+		GRPCClientInterceptor(tt.opts(tc)...)(context.Background(), "/unary", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			record(ctx)
+			return nil
+		})
+		cs, err := GRPCStreamClientInterceptor(tt.opts(tc)...)(context.Background(), &grpc.StreamDesc{}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			record(ctx)
+			return &fakeClientStream{ctx: ctx}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, ok := cs.(*ClientStreamWrapper); ok {
+			w.finish(nil)
+		}
+		if err := tc.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if !tt.traced {
+			if len(e.spans) != 0 {
+				t.Errorf("%s: exported spans %v; want none", tt.desc, names(e.spans))
+			}
+			for _, h := range headers {
+				if h[len(h)-4:] != ";o=0" {
+					t.Errorf("%s: sent header %q; want none, or an untraced one", tt.desc, h)
+				}
+			}
+			continue
+		}
+		if len(headers) != 2 {
+			t.Fatalf("%s: sent headers %q; want one for each call", tt.desc, headers)
+		}
+		for i, name := range []string{"/unary", "/stream"} {
+			s := e.span(name)
+			if s == nil {
+				t.Errorf("%s: exported spans %v; want %s", tt.desc, names(e.spans), name)
+				continue
+			}
+			if s.ParentSpanID != 0 || s.Kind != SpanKindClient {
+				t.Errorf("%s: span %s has parent %d and kind %v; want a client root span", tt.desc, name, s.ParentSpanID, s.Kind)
+			}
+			if want := fmt.Sprintf("%s/%d;o=1", s.TraceID, s.SpanID); headers[i] != want {
+				t.Errorf("%s: call %s sent header %q; want %q", tt.desc, name, headers[i], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// labelClockSkew is the label of spans that started before the time at which
+// their callers sent the request, by more than the skew tolerance.
+const labelClockSkew = `clock/skew_ms`
+
+// defaultSendTimeKey is the metadata key of WithClockSkewDetection if it is
+// given none.
+const defaultSendTimeKey = "x-cloud-trace-send-time"
+
+type withClockSkewDetection struct {
+	key       string
+	tolerance time.Duration
+}
+
+// WithClockSkewDetection returns an InterceptorOption that detects skewed
+// clocks between clients and servers.  The client interceptors record in the
+// metadata key of each outgoing traced call the time at which it was sent,
+// in nanoseconds since the Unix epoch; the server interceptors compare it
+// with the start of the span of the incoming call, and if the span seems to
+// have started more than tolerance before the call was sent, label it
+// "clock/skew_ms" with the difference, a negative number of milliseconds.
+//
+// The key is "x-cloud-trace-send-time" if empty, and the tolerance is 100ms
+// if it isn't positive.  ConsumerSpanFromCarrier labels the spans of
+// messages recorded by InjectWithTimestamp the same way.
+func WithClockSkewDetection(key string, tolerance time.Duration) InterceptorOption {
+	if key == "" {
+		key = defaultSendTimeKey
+	}
+	if tolerance <= 0 {
+		tolerance = queueTimeSkewTolerance
+	}
+	return withClockSkewDetection{key: strings.ToLower(key), tolerance: tolerance}
+}
+
+func (o withClockSkewDetection) configureInterceptor(c *interceptorConfig) {
+	c.sendTimeKey, c.skewTolerance = o.key, o.tolerance
+}
+
+// withSendTime returns ctx with the current time in its outgoing metadata,
+// if the interceptors detect clock skew.
+func (c *interceptorConfig) withSendTime(ctx context.Context) context.Context {
+	if c.sendTimeKey == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, c.sendTimeKey, strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// setSendTimeSkewLabel labels span, the span of an incoming call with the
+// metadata md, with the skew of the clock of the server relative to the
+// client's, if the interceptors detect clock skew.
+func (c *interceptorConfig) setSendTimeSkewLabel(span *Span, md metadata.MD) {
+	if c.sendTimeKey == "" || !span.traced() {
+		return
+	}
+	vs := md[c.sendTimeKey]
+	if len(vs) == 0 {
+		return
+	}
+	sent, err := strconv.ParseInt(vs[0], 10, 64)
+	if err != nil {
+		return
+	}
+	setClockSkewLabel(span, time.Unix(0, sent), c.skewTolerance)
+}
+
+// setClockSkewLabel labels span with the time from sent, when the request or
+// message that span handles was sent, to the start of span, if that is less
+// than -tolerance.  It returns the time from sent to the start.
+func setClockSkewLabel(span *Span, sent time.Time, tolerance time.Duration) time.Duration {
+	d := span.start.Sub(sent)
+	if d < -tolerance {
+		span.setLabel(labelClockSkew, strconv.FormatInt(int64(d/time.Millisecond), 10))
+	}
+	return d
+}
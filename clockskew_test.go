@@ -0,0 +1,156 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestClockSkewDetection(t *testing.T) {
+	const header = "0123456789ABCDEF0123456789ABCDEF/1;o=1"
+	for _, tt := range []struct {
+		desc     string
+		md       metadata.MD
+		opt      InterceptorOption
+		wantSkew int64 // 0 for no label.
+	}{
+		{desc: "no option", md: metadata.Pairs(defaultSendTimeKey, sendTime(2*time.Second))},
+		{desc: "no send time", opt: WithClockSkewDetection("", 0)},
+		{desc: "invalid send time", md: metadata.Pairs(defaultSendTimeKey, "now"), opt: WithClockSkewDetection("", 0)},
+		{desc: "client behind", md: metadata.Pairs(defaultSendTimeKey, sendTime(-2*time.Second)), opt: WithClockSkewDetection("", 0)},
+		{desc: "within tolerance", md: metadata.Pairs(defaultSendTimeKey, sendTime(50*time.Millisecond)), opt: WithClockSkewDetection("", 0)},
+		{desc: "beyond tolerance", md: metadata.Pairs(defaultSendTimeKey, sendTime(2*time.Second)), opt: WithClockSkewDetection("", 0), wantSkew: -2000},
+		{desc: "custom key", md: metadata.Pairs("x-sent", sendTime(2*time.Second)), opt: WithClockSkewDetection("X-Sent", 0), wantSkew: -2000},
+		{desc: "custom tolerance", md: metadata.Pairs(defaultSendTimeKey, sendTime(2*time.Second)), opt: WithClockSkewDetection("", 5*time.Second)},
+	} {
+		tc := NewClientWithExporter(&recordingExporter{})
+		var opts []InterceptorOption
+		if tt.opt != nil {
+			opts = append(opts, tt.opt)
+		}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Join(tt.md, metadata.Pairs(grpcMetadataKey, header)))
+
+		var spans []*Span
+		GRPCServerInterceptor(tc, opts...)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			spans = append(spans, FromContext(ctx))
+			return nil, nil
+		})
+		err := GRPCStreamServerInterceptor(tc, opts...)(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+			spans = append(spans, FromContext(ss.Context()))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, span := range spans {
+			v, ok := spanLabel(span, labelClockSkew)
+			if tt.wantSkew == 0 {
+				if ok {
+					t.Errorf("%s: %s = %q; want none", tt.desc, labelClockSkew, v)
+				}
+				continue
+			}
+			got, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || got > tt.wantSkew+100 || got < tt.wantSkew-100 {
+				t.Errorf("%s: %s = %q; want about %d", tt.desc, labelClockSkew, v, tt.wantSkew)
+			}
+		}
+	}
+}
+
+// sendTime returns the metadata value of a send time d after now.
+func sendTime(d time.Duration) string {
+	return strconv.FormatInt(time.Now().Add(d).UnixNano(), 10)
+}
+
+func TestClockSkewDetectionSendTime(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	for _, tt := range []struct {
+		opts    []InterceptorOption
+		key     string
+		wantKey bool
+	}{
+		{key: defaultSendTimeKey},
+		{opts: []InterceptorOption{WithClockSkewDetection("", 0)}, key: defaultSendTimeKey, wantKey: true},
+		{opts: []InterceptorOption{WithClockSkewDetection("X-Sent", 0)}, key: "x-sent", wantKey: true},
+	} {
+		var sent []string
+		record := func(ctx context.Context) {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			sent = append(sent, md[tt.key]...)
+		}
+		before := time.Now()
+		ctx := NewContext(context.Background(), tc.NewSpan("/root"))
+		GRPCClientInterceptor(tt.opts...)(ctx, "/unary", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			record(ctx)
+			return nil
+		})
+		_, err := GRPCStreamClientInterceptor(tt.opts...)(ctx, &grpc.StreamDesc{}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			record(ctx)
+			return &fakeClientStream{ctx: ctx}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		after := time.Now()
+
+		if !tt.wantKey {
+			if len(sent) != 0 {
+				t.Errorf("without WithClockSkewDetection: sent %s = %q; want none", tt.key, sent)
+			}
+			continue
+		}
+		if len(sent) != 2 {
+			t.Fatalf("sent %s = %q; want one value per call", tt.key, sent)
+		}
+		for _, v := range sent {
+			ns, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || ns < before.UnixNano() || ns > after.UnixNano() {
+				t.Errorf("sent %s = %q; want a time between %d and %d", tt.key, v, before.UnixNano(), after.UnixNano())
+			}
+		}
+	}
+}
+
+func TestConsumerClockSkew(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	for _, tt := range []struct {
+		desc string
+		skew time.Duration // how far ahead of the consumer's the producer's clock is.
+		want bool
+	}{
+		{desc: "within tolerance", skew: 50 * time.Millisecond},
+		{desc: "beyond tolerance", skew: 2 * time.Second, want: true},
+	} {
+		carrier := MapCarrier{}
+		InjectWithTimestamp(tc.NewSpan("/producer"), carrier)
+		carrier[enqueueTimeKey] = sendTime(tt.skew)
+		consumer := tc.ConsumerSpanFromCarrier("/consumer", carrier)
+		v, ok := spanLabel(consumer, labelClockSkew)
+		if ok != tt.want {
+			t.Errorf("%s: %s = %q, %t; want label %t", tt.desc, labelClockSkew, v, ok, tt.want)
+			continue
+		}
+		if ms, err := strconv.ParseInt(v, 10, 64); ok && (err != nil || ms > -1900 || ms < -2100) {
+			t.Errorf("%s: %s = %q; want about -2000", tt.desc, labelClockSkew, v)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	labelSuppressedByDuration = `trace/suppressed_by_duration`
+	labelDroppedByCap         = `trace/dropped_by_cap`
+	labelDroppedByMemory      = `trace/dropped_by_memory`
+)
+
+// SetMinSpanDuration makes the client discard child spans that finish less
+// than d after they start.  Root spans are always uploaded.  The number of
+// spans discarded from a trace is recorded on its root span, in the label
+// "trace/suppressed_by_duration".  A d of zero, the default, keeps every
+// span.
+//
+// SetMinSpanDuration should be called before any spans are created.
+func (c *Client) SetMinSpanDuration(d time.Duration) {
+	if c != nil {
+		c.minSpanDuration = d
+	}
+}
+
+// SetTraceSlowSpans sets whether the client uploads the spans that take at
+// least the duration set with SetMinSpanDuration even when the sampling
+// policy doesn't choose their traces, so that the rare slow requests are
+// kept whatever the sampling rate.  It has no effect unless a minimum
+// duration is set.
+//
+// When it is enabled, a trace the sampling policy doesn't choose is still
+// recorded in this process, but only its spans that take the minimum
+// duration or longer are uploaded, and its root span is discarded too if it
+// is faster.  The spans that are uploaded keep the IDs of their parents,
+// whether or not the parents are, so the trace shows the slow spans in
+// place, with gaps.  Such traces don't change the options propagated to
+// the services they call, which decide for themselves whether to trace
+// their parts.  ForceTrace uploads the whole of such a trace.
+//
+// Recording the traces that aren't sampled costs as much as tracing every
+// request, less the uploads.
+//
+// SetTraceSlowSpans should be called before any spans are created.
+func (c *Client) SetTraceSlowSpans(enabled bool) {
+	if c != nil {
+		c.traceSlowSpans = enabled
+	}
+}
+
+// SetMaxSpansPerTrace limits the number of child spans the client uploads
+// for each trace to n.  Children that finish once a trace has n are
+// discarded, and counted on the root span in the label
+// "trace/dropped_by_cap".  An n of zero, the default, sets no limit.
+//
+// SetMaxSpansPerTrace should be called before any spans are created.
+func (c *Client) SetMaxSpansPerTrace(n int) {
+	if c != nil {
+		c.maxTraceSpans = int32(n)
+	}
+}
+
+// SetMaxBufferedSpans limits the number of finished child spans the client
+// holds, across all traces, while they wait for the root spans of their
+// traces to finish.  Children that finish while the limit is reached are
+// discarded, and counted on the root span in the label
+// "trace/dropped_by_memory".  An n of zero, the default, sets no limit.
+//
+// The children of a trace whose root span never finishes count towards the
+// limit for the life of the client, so set a limit only if every root span
+// is finished.
+//
+// SetMaxBufferedSpans should be called before any spans are created.
+func (c *Client) SetMaxBufferedSpans(n int) {
+	if c != nil {
+		c.maxBufferedSpans = int64(n)
+	}
+}
+
+// A suppression counts the child spans of a trace that weren't uploaded,
+// so that the root span can say whether its trace is complete.  Its fields
+// are updated atomically.
+type suppression struct {
+	byDuration int32 // discarded because of SetMinSpanDuration.
+	byCap      int32 // discarded because of SetMaxSpansPerTrace.
+	byMemory   int32 // discarded because of SetMaxBufferedSpans.
+	kept       int32 // children admitted, for SetMaxSpansPerTrace.
+	drained    int32 // 1 once the root span has taken the finished spans.
+}
+
+// admit reports whether the finished child span s, which took d, should be
+// uploaded, counting it in t.suppressed if not.
+func (t *trace) admit(s *Span, d time.Duration) bool {
+	c := t.client
+	if c.minSpanDuration > 0 && d < c.minSpanDuration {
+		atomic.AddInt32(&t.suppressed.byDuration, 1)
+		return false
+	}
+	if c.maxBufferedSpans > 0 {
+		if atomic.AddInt64(&c.stats.bufferedSpans, 1) > c.maxBufferedSpans {
+			atomic.AddInt64(&c.stats.bufferedSpans, -1)
+			atomic.AddInt32(&t.suppressed.byMemory, 1)
+			return false
+		}
+		s.buffered = 1
+	}
+	if c.maxTraceSpans > 0 && atomic.AddInt32(&t.suppressed.kept, 1) > c.maxTraceSpans {
+		t.release(s)
+		atomic.AddInt32(&t.suppressed.byCap, 1)
+		return false
+	}
+	return true
+}
+
+// keepRoot reports whether the finished root span of t, which took d,
+// should be uploaded.  Only a trace recorded for its slow spans discards its
+// root, counting it in t.suppressed.
+func (t *trace) keepRoot(d time.Duration) bool {
+	if atomic.LoadInt32(&t.slowOnly) == 0 || d >= t.client.minSpanDuration {
+		return true
+	}
+	atomic.AddInt32(&t.suppressed.byDuration, 1)
+	return false
+}
+
+// release stops counting s towards the client's SetMaxBufferedSpans limit.
+// It is called both by the root span, for the spans it takes, and by
+// children that finish after the root, so only the first call counts.
+func (t *trace) release(s *Span) {
+	if atomic.CompareAndSwapInt32(&s.buffered, 1, 0) {
+		atomic.AddInt64(&t.client.stats.bufferedSpans, -1)
+	}
+}
+
+// pushed is called after the child span s has been pushed onto the finished
+// spans of t.  If the root span has already taken them, s will never be
+// uploaded, and is released.
+func (t *trace) pushed(s *Span) {
+	if t.client.maxBufferedSpans > 0 && atomic.LoadInt32(&t.suppressed.drained) != 0 {
+		t.release(s)
+	}
+}
+
+// drain takes the finished spans of t for the root span, releasing them,
+// and labels root with the number of children that weren't uploaded.
+func (t *trace) drain(root *Span) []*Span {
+	atomic.StoreInt32(&t.suppressed.drained, 1)
+	spans := t.finished.drain()
+	if t.client.maxBufferedSpans > 0 {
+		for _, s := range spans {
+			t.release(s)
+		}
+	}
+	for _, l := range [...]struct {
+		key string
+		n   *int32
+	}{
+		{labelSuppressedByDuration, &t.suppressed.byDuration},
+		{labelDroppedByCap, &t.suppressed.byCap},
+		{labelDroppedByMemory, &t.suppressed.byMemory},
+	} {
+		if n := atomic.LoadInt32(l.n); n > 0 {
+			root.setLabel(l.key, strconv.Itoa(int(n)))
+		}
+	}
+	return spans
+}
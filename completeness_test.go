@@ -0,0 +1,150 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSuppressionLabels(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetMinSpanDuration(time.Second)
+	tc.SetMaxSpansPerTrace(2)
+	tc.SetMaxBufferedSpans(3)
+
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	finishChild := func(parent *Span, name string, d time.Duration) {
+		child := parent.NewChild(name)
+		child.SetStartTime(start)
+		child.FinishAt(start.Add(d))
+	}
+
+	// /a holds two of the three buffered spans while /b's children finish.
+	a := tc.NewSpan("/a")
+	finishChild(a, "a.1", 2*time.Second)
+	finishChild(a, "a.2", 2*time.Second)
+	b := tc.NewSpan("/b")
+	finishChild(b, "b.short", time.Millisecond) // suppressed by duration
+	finishChild(b, "b.1", 2*time.Second)
+	finishChild(b, "b.memory1", 2*time.Second) // dropped by memory
+	finishChild(b, "b.memory2", 2*time.Second) // dropped by memory
+	if err := a.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	finishChild(b, "b.2", 2*time.Second)
+	finishChild(b, "b.cap", 2*time.Second) // dropped by cap
+	if err := b.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	finishChild(b, "b.late", 2*time.Second)
+
+	got := names(e.spans)
+	sort.Strings(got)
+	if want := []string{"/a", "/b", "a.1", "a.2", "b.1", "b.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exported spans %v; want %v", got, want)
+	}
+	for _, k := range []string{labelSuppressedByDuration, labelDroppedByCap, labelDroppedByMemory} {
+		if v, ok := e.span("/a").Labels[k]; ok {
+			t.Errorf("complete trace has label %s=%q", k, v)
+		}
+	}
+	for k, want := range map[string]string{
+		labelSuppressedByDuration: "1",
+		labelDroppedByCap:         "1",
+		labelDroppedByMemory:      "2",
+	} {
+		if got := e.span("/b").Labels[k]; got != want {
+			t.Errorf("root label %s = %q; want %q", k, got, want)
+		}
+	}
+	if n := atomic.LoadInt64(&tc.stats.bufferedSpans); n != 0 {
+		t.Errorf("%d spans still buffered after their roots finished; want 0", n)
+	}
+}
+
+func TestSuppressionLabelsRemoteRoot(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetMinSpanDuration(time.Second)
+
+	s := tc.SpanFromHeader("/server", "0123456789abcdef0123456789abcdef/1;o=1")
+	s.NewChild("quick").Finish()
+	s.NewChild("quick").Finish()
+	if err := s.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(e.spans), []string{"/server"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exported spans %v; want %v", got, want)
+	}
+	if got, want := e.span("/server").Labels[labelSuppressedByDuration], "2"; got != want {
+		t.Errorf("local root label %s = %q; want %q", labelSuppressedByDuration, got, want)
+	}
+}
+
+func TestTraceSlowSpans(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetSamplingPolicy(neverTrace{})
+	tc.SetMinSpanDuration(time.Second)
+	tc.SetTraceSlowSpans(true)
+
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := tc.NewSpan("/root")
+	parent := root.NewChild("parent")
+	parent.SetStartTime(start)
+	slow := parent.NewChild("slow")
+	slow.SetStartTime(start)
+	parent.NewChild("quick").Finish()
+	slow.FinishAt(start.Add(2 * time.Second))
+	parent.FinishAt(start.Add(time.Millisecond))
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(e.spans), []string{"slow"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("exported spans %v; want %v", got, want)
+	}
+	if got, want := e.span("slow").ParentSpanID, parent.SpanID(); got != want {
+		t.Errorf("ParentSpanID of slow child = %x; want %x, the ID of its discarded parent", got, want)
+	}
+
+	e.spans = nil
+	quick := tc.NewSpan("/quick")
+	quick.NewChild("quick").Finish()
+	if err := quick.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.spans) != 0 {
+		t.Errorf("exported spans %v of a fast trace; want none", names(e.spans))
+	}
+
+	forced := tc.NewSpan("/forced")
+	if !forced.ForceTrace() {
+		t.Fatal("ForceTrace() = false; want true")
+	}
+	if err := forced.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(e.spans), []string{"/forced"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("exported spans %v; want %v", got, want)
+	}
+	if got := e.span("/forced").Labels[labelSamplingPolicy]; got != "forced" {
+		t.Errorf("sampling policy label = %q; want forced", got)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// A Describer describes itself in a short string.  SamplingPolicies and
+// Exporters can implement it to say how they are configured in the Config
+// of a client.  Descriptions are served by DebugHandler and logged, so they
+// must not contain secrets such as credentials.
+type Describer interface {
+	Describe() string
+}
+
+// describe returns the description of v if it is a Describer, and otherwise
+// its type.
+func describe(v interface{}) string {
+	if d, ok := v.(Describer); ok {
+		return d.Describe()
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// Config describes the effective configuration of a Client, for debugging.
+// Policies and exporters are described by their Describe methods, if they
+// have them, and otherwise by their types.
+type Config struct {
+	// SamplingPolicy describes the client's sampling policy, or is empty
+	// if it has none.
+	SamplingPolicy string `json:",omitempty"`
+
+	// AcceptedHeaderFormats are the names of the header formats set with
+	// SetAcceptedHeaderFormats, or nil if all formats are accepted.
+	AcceptedHeaderFormats []string `json:",omitempty"`
+
+	// RequestHeaders are the headers SpanFromRequest reads, in order of
+	// preference.
+	RequestHeaders []string
+
+	// Exporters describes the exporters of the client, by name.  The
+	// default exporter is named "default".
+	Exporters map[string]string
+
+	// Bundler holds the thresholds of the bundler that batches traces for
+	// the default exporter.
+	Bundler BundlerConfig
+
+	// UploadRetries is the policy set with SetUploadRetryPolicy.
+	UploadRetries UploadRetryPolicy
+
+	ChildDurationRollup     int           // SetChildDurationRollup
+	LabelCardinalityLimit   int           // SetLabelCardinalityLimit
+	MinSpanDuration         time.Duration // SetMinSpanDuration
+	TraceSlowSpans          bool          // SetTraceSlowSpans
+	MaxSpansPerTrace        int           // SetMaxSpansPerTrace
+	MaxBufferedSpans        int           // SetMaxBufferedSpans
+	MaxLabels               int           // SetLabelLimits
+	MaxLabelValueBytes      int           // SetLabelLimits
+	StackTraces             int           // SetStackTraces
+	BatchTargetLatency      time.Duration // SetBatchAutotuning
+	BatchMinTraces          int           // SetBatchAutotuning
+	BatchMaxTraces          int           // SetBatchAutotuning
+	ExportTimeout           time.Duration // SetExportTimeout
+	BreakerFailures         int           // SetCircuitBreaker
+	BreakerCooldown         time.Duration // SetCircuitBreaker
+	SamplerLatencyThreshold time.Duration // SetSamplerLatencyThreshold
+	SamplerTimeout          time.Duration // SetSamplerTimeout
+	SamplerFallback         bool          // SetSamplerTimeout
+	SummaryInterval         time.Duration // SetSummaryTraces
+	SpillDirectory          string        // SetSpillDirectory
+	SpillMaxBytes           int64         // SetSpillDirectory
+	StrictValidation        bool          // SetStrictValidation
+	LegacyErrorLabels       bool          // SetLegacyErrorLabels
+	Processors              int           // number of processors added with AddSpanProcessor
+	DefaultLabels           int           // number of labels set with SetDefaultLabels
+
+	// The remaining fields report whether the client has the setting.
+	ErrorHandler      bool // SetErrorHandler
+	Logger            bool // SetLogger
+	Router            bool // SetRouter
+	LabelEncoder      bool // SetLabelEncoder
+	RuntimeTraceTasks bool // SetRuntimeTraceTasks
+	IDGenerator       bool // SetIDGenerator
+	OutcomeSink       bool // SetOutcomeSink
+	LabelFilter       bool // SetLabelFilter
+}
+
+// BundlerConfig holds the thresholds of a client's bundler.  Traces and
+// spans count as one byte each.  See SetBundling.
+type BundlerConfig struct {
+	DelayThreshold       time.Duration
+	BundleCountThreshold int
+	BundleByteThreshold  int
+	BundleByteLimit      int
+	BufferedByteLimit    int
+	HandlerLimit         int
+}
+
+// Config returns the effective configuration of the client.  DebugHandler
+// serves it with the client's statistics, and if the client has a logger,
+// it is logged as a debugging message when the first root span is created.
+func (c *Client) Config() Config {
+	var cfg Config
+	if c == nil {
+		return cfg
+	}
+	if p := c.samplingPolicy(); p != nil {
+		cfg.SamplingPolicy = describe(p)
+	}
+	for _, f := range c.acceptedFormats {
+		cfg.AcceptedHeaderFormats = append(cfg.AcceptedHeaderFormats, f.String())
+	}
+	cfg.RequestHeaders = c.requestHeaders
+	if cfg.RequestHeaders == nil {
+		cfg.RequestHeaders = defaultRequestHeaders
+	}
+	cfg.RequestHeaders = append([]string(nil), cfg.RequestHeaders...)
+	cfg.Exporters = map[string]string{"default": describe(c.exporter)}
+	for name, e := range c.exporters {
+		cfg.Exporters[name] = describe(e)
+	}
+	cfg.Bundler = BundlerConfig{
+		DelayThreshold:       c.bundler.DelayThreshold,
+		BundleCountThreshold: c.bundler.BundleCountThreshold,
+		BundleByteThreshold:  c.bundler.BundleByteThreshold,
+		BundleByteLimit:      c.bundler.BundleByteLimit,
+		BufferedByteLimit:    c.bundler.BufferedByteLimit,
+		HandlerLimit:         c.bundler.HandlerLimit,
+	}
+	cfg.UploadRetries = c.retryPolicy
+	cfg.ChildDurationRollup = c.childRollup
+	if c.cardinality != nil {
+		cfg.LabelCardinalityLimit = c.cardinality.limit
+	}
+	cfg.MinSpanDuration = c.minSpanDuration
+	cfg.TraceSlowSpans = c.traceSlowSpans
+	cfg.MaxSpansPerTrace = int(c.maxTraceSpans)
+	cfg.MaxBufferedSpans = int(c.maxBufferedSpans)
+	cfg.MaxLabels, cfg.MaxLabelValueBytes = c.labelLimits()
+	cfg.StackTraces = c.stackFrames
+	if t := c.tuner; t != nil {
+		cfg.BatchTargetLatency = t.target
+		cfg.BatchMinTraces, cfg.BatchMaxTraces = t.min, t.max
+	}
+	cfg.ExportTimeout = c.exportTimeout
+	cfg.BreakerFailures = c.breakerFailures
+	cfg.BreakerCooldown = c.breakerCooldown
+	cfg.SamplerLatencyThreshold = c.samplerThreshold
+	cfg.SamplerTimeout = c.samplerTimeout
+	cfg.SamplerFallback = c.samplerFallback
+	if c.summary != nil {
+		cfg.SummaryInterval = c.summary.interval
+	}
+	if c.spill != nil {
+		cfg.SpillDirectory = c.spill.dir
+		cfg.SpillMaxBytes = c.spill.maxBytes
+	}
+	cfg.StrictValidation = c.strict
+	cfg.LegacyErrorLabels = c.legacyErrorLabels
+	cfg.Processors = len(c.processors) + len(c.lastProcessors)
+	cfg.DefaultLabels = len(c.loadDefaultLabels())
+	cfg.ErrorHandler = c.onError != nil
+	cfg.Logger = c.logger != nil
+	cfg.Router = c.router != nil
+	cfg.LabelEncoder = c.labelEncoder != nil
+	cfg.RuntimeTraceTasks = c.runtimeTasks
+	cfg.IDGenerator = c.ids != nil
+	cfg.OutcomeSink = c.outcomes != nil
+	cfg.LabelFilter = c.labelFilter != nil
+	return cfg
+}
+
+// logConfig logs the configuration of the client to its logger.
+func (c *Client) logConfig() {
+	b, err := json.Marshal(c.Config())
+	if err != nil {
+		c.debugf("trace: client configuration: %v", err)
+		return
+	}
+	c.debugf("trace: client configuration: %s", b)
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	cfg := tc.Config()
+	if cfg.SamplingPolicy != "" || cfg.AcceptedHeaderFormats != nil || cfg.StrictValidation {
+		t.Errorf("default config %+v has non-default settings", cfg)
+	}
+	if !reflect.DeepEqual(cfg.RequestHeaders, defaultRequestHeaders) {
+		t.Errorf("RequestHeaders = %q; want %q", cfg.RequestHeaders, defaultRequestHeaders)
+	}
+	if got, want := cfg.Exporters, map[string]string{"default": "*trace.recordingExporter"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Exporters = %v; want %v", got, want)
+	}
+	if got, want := cfg.Bundler.DelayThreshold, 2*time.Second; got != want {
+		t.Errorf("Bundler.DelayThreshold = %v; want %v", got, want)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	tc := NewClientWithExporter(NewJSONExporter(nil))
+	p, err := NewLimitedSampler(0.5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.SetSamplingPolicy(p)
+	tc.SetAcceptedHeaderFormats(TraceparentHeader)
+	tc.SetRequestHeaders("X-Trace")
+	tc.RegisterExporter("audit", &recordingExporter{})
+	tc.SetChildDurationRollup(4)
+	tc.SetMaxSpansPerTrace(100)
+	tc.SetLabelLimits(10, 0)
+	tc.SetExportTimeout(time.Second)
+	tc.SetCircuitBreaker(3, time.Minute)
+	tc.SetStrictValidation(true)
+	tc.SetErrorHandler(func(error) {})
+	tc.AddSpanProcessor(&AnonymizingProcessor{})
+
+	got := tc.Config()
+	want := Config{
+		SamplingPolicy:        "limited sampler: fraction 0.5, at most 10 qps",
+		AcceptedHeaderFormats: []string{TraceparentHeader.String()},
+		RequestHeaders:        []string{"X-Trace"},
+		Exporters: map[string]string{
+			"default": "JSON exporter",
+			"audit":   "*trace.recordingExporter",
+		},
+		Bundler:             tc.Config().Bundler,
+		ChildDurationRollup: 4,
+		MaxSpansPerTrace:    100,
+		MaxLabels:           10,
+		MaxLabelValueBytes:  maxLabelValueBytes,
+		ExportTimeout:       time.Second,
+		BreakerFailures:     3,
+		BreakerCooldown:     time.Minute,
+		StrictValidation:    true,
+		Processors:          1,
+		ErrorHandler:        true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Config() = %+v; want %+v", got, want)
+	}
+}
+
+func TestDebugHandlerConfig(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetChildDurationRollup(4)
+
+	rec := httptest.NewRecorder()
+	tc.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/trace", nil))
+	var got struct{ Config Config }
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	if want := tc.Config(); !reflect.DeepEqual(got.Config, want) {
+		t.Errorf("handler served config %+v; want %+v", got.Config, want)
+	}
+}
+
+func TestConfigLogged(t *testing.T) {
+	l := &recordingLogger{}
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetLogger(l)
+	tc.SetMaxBufferedSpans(50)
+	tc.NewSpan("/a").Finish()
+	tc.NewSpan("/b").Finish()
+
+	var logged []string
+	for _, m := range l.debugs {
+		if strings.HasPrefix(m, "trace: client configuration: ") {
+			logged = append(logged, m)
+		}
+	}
+	if len(logged) != 1 {
+		t.Fatalf("configuration logged %d times; want once", len(logged))
+	}
+	if !strings.Contains(logged[0], `"MaxBufferedSpans":50`) {
+		t.Errorf("logged %q; want it to contain the MaxBufferedSpans setting", logged[0])
+	}
+}
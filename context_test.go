@@ -0,0 +1,115 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	xcontext "golang.org/x/net/context"
+)
+
+// The package's signatures use the standard library's context, which
+// golang.org/x/net/context aliases.  Code built against older versions of
+// this package, which used golang.org/x/net/context, passes contexts and
+// functions of the same types, and stores spans under the same key.
+var (
+	_ func(xcontext.Context, *Span) xcontext.Context = NewContext
+	_ func(xcontext.Context) *Span                   = FromContext
+	_ Exporter                                       = xcontextExporter{}
+)
+
+// xcontextExporter is an Exporter written against golang.org/x/net/context.
+type xcontextExporter struct{}
+
+func (xcontextExporter) ExportSpans(ctx xcontext.Context, spans []*SpanData) error { return nil }
+
+func TestContextCompatibility(t *testing.T) {
+	span := NewClientWithExporter(&recordingExporter{}).NewSpan("/root")
+	for _, tt := range []struct {
+		desc  string
+		store func() context.Context
+	}{
+		{"NewContext with a standard context", func() context.Context {
+			return NewContext(context.Background(), span)
+		}},
+		{"NewContext with an x/net context", func() context.Context {
+			return NewContext(xcontext.Background(), span)
+		}},
+		{"old-style key with a standard context", func() context.Context {
+			return context.WithValue(context.Background(), contextKey{}, span)
+		}},
+		{"old-style key with an x/net context", func() context.Context {
+			return xcontext.WithValue(xcontext.TODO(), contextKey{}, span)
+		}},
+	} {
+		ctx := tt.store()
+		if got := FromContext(ctx); got != span {
+			t.Errorf("%s: FromContext = %v; want the stored span", tt.desc, got)
+		}
+		// Descendant contexts of either package still carry the span.
+		xctx, cancel := xcontext.WithCancel(ctx)
+		if got := FromContext(xctx); got != span {
+			t.Errorf("%s: FromContext of an x/net child context = %v; want the stored span", tt.desc, got)
+		}
+		cancel()
+		if got, _ := ctx.Value(contextKey{}).(*Span); got != span {
+			t.Errorf("%s: the span is not stored under contextKey{}", tt.desc)
+		}
+	}
+}
+
+func TestNewContextReplacement(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetStrictValidation(true)
+	var errs []error
+	tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	if !HasSpan(ctx) {
+		t.Fatal("HasSpan = false for a context with a span")
+	}
+	if HasSpan(context.Background()) {
+		t.Error("HasSpan = true for a context without a span")
+	}
+
+	// Replacing a span with one of the same trace is the normal nesting of
+	// child spans.
+	child := root.NewChild("child")
+	if got := FromContext(NewContext(ctx, child)); got != child {
+		t.Errorf("FromContext = %v; want the child span", got)
+	}
+	if len(errs) != 0 || tc.Stats().ReplacedSpans != 0 {
+		t.Errorf("same-trace replacement: got errors %v and %d replacements; want none", errs, tc.Stats().ReplacedSpans)
+	}
+
+	other := tc.NewSpan("/other")
+	if got := FromContext(NewContext(ctx, other)); got != other {
+		t.Errorf("FromContext = %v; want the replacing span", got)
+	}
+	if got := tc.Stats().ReplacedSpans; got != 1 {
+		t.Errorf("ReplacedSpans = %d; want 1", got)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("cross-trace replacement: got errors %v; want 1", errs)
+	}
+	for _, id := range []string{root.TraceID(), other.TraceID()} {
+		if !strings.Contains(errs[0].Error(), id) {
+			t.Errorf("error %q doesn't name trace %s", errs[0], id)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// InstrumentDBPool registers the connection pool db with c, under name.
+// Server spans created by interceptors and handlers configured with
+// WithDBPoolLabels record how much pressure each pool was under while they
+// ran, as the change between the start and the end of the span in the
+// pool's statistics:
+//
+//   db/<name>/wait_count  connections waited for
+//   db/<name>/wait_ms     total time waited for connections, in milliseconds
+//   db/<name>/in_use      change in the number of connections in use
+//
+// The statistics are those of the whole pool, so they include the waits
+// of concurrent requests.  Registering another pool with the same name
+// replaces it; a nil db unregisters the name.
+func (c *Client) InstrumentDBPool(db *sql.DB, name string) {
+	if c == nil {
+		return
+	}
+	c.dbPools.mu.Lock()
+	defer c.dbPools.mu.Unlock()
+	pools := c.dbPools.pools[:0:0]
+	for _, p := range c.dbPools.pools {
+		if p.name != name {
+			pools = append(pools, p)
+		}
+	}
+	if db != nil {
+		pools = append(pools, dbPool{name: name, db: db, prefix: "db/" + name + "/"})
+	}
+	c.dbPools.pools = pools
+}
+
+// WithDBPoolLabels returns an InterceptorOption that labels each server
+// span with the statistics of the connection pools registered with the
+// Client's InstrumentDBPool.
+//
+// It applies to the server interceptors and to HTTPHandler.
+func WithDBPoolLabels() InterceptorOption {
+	return withDBPoolLabels{}
+}
+
+type withDBPoolLabels struct{}
+
+func (withDBPoolLabels) configureInterceptor(c *interceptorConfig) {
+	c.dbPoolLabels = true
+}
+
+// dbPools holds the pools registered with InstrumentDBPool.  pools is
+// replaced, not modified, so a copy of it can be read without mu.
+type dbPools struct {
+	mu    sync.Mutex
+	pools []dbPool
+}
+
+type dbPool struct {
+	name   string
+	db     *sql.DB
+	prefix string // prefix of the pool's label keys.
+}
+
+// dbPoolSnapshot holds the statistics of the registered pools at the start
+// of a span.
+type dbPoolSnapshot struct {
+	pools []dbPool
+	stats []sql.DBStats
+}
+
+// startDBPoolLabels returns the statistics of the pools registered with c,
+// if config enables WithDBPoolLabels and span is traced, or nil.
+func (c *Client) startDBPoolLabels(span *Span, config *interceptorConfig) *dbPoolSnapshot {
+	if c == nil || !config.dbPoolLabels || !span.traced() {
+		return nil
+	}
+	c.dbPools.mu.Lock()
+	pools := c.dbPools.pools
+	c.dbPools.mu.Unlock()
+	if len(pools) == 0 {
+		return nil
+	}
+	snap := &dbPoolSnapshot{pools: pools, stats: make([]sql.DBStats, len(pools))}
+	for i, p := range pools {
+		snap.stats[i] = p.db.Stats()
+	}
+	return snap
+}
+
+// setLabels labels span with the change in the statistics of the pools
+// since snap was taken.  It does nothing if snap is nil.
+func (snap *dbPoolSnapshot) setLabels(span *Span) {
+	if snap == nil {
+		return
+	}
+	for i, p := range snap.pools {
+		start, end := snap.stats[i], p.db.Stats()
+		span.setLabel(p.prefix+"wait_count", strconv.FormatInt(end.WaitCount-start.WaitCount, 10))
+		span.setLabel(p.prefix+"wait_ms", strconv.FormatInt(int64((end.WaitDuration-start.WaitDuration)/time.Millisecond), 10))
+		span.setLabel(p.prefix+"in_use", strconv.Itoa(end.InUse-start.InUse))
+	}
+}
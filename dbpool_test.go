@@ -0,0 +1,158 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.11
+
+package trace
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// poolDriver is a database/sql driver whose connections can only be pinged.
+type poolDriver struct{}
+
+func (poolDriver) Open(string) (driver.Conn, error) { return poolConn{}, nil }
+
+type poolConn struct{}
+
+func (poolConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (poolConn) Close() error                        { return nil }
+func (poolConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func init() {
+	sql.Register("tracepool", poolDriver{})
+}
+
+// newBusyPool returns a pool of one connection, which is held until
+// release is called.
+func newBusyPool(t *testing.T) (db *sql.DB, release func()) {
+	db, err := sql.Open("tracepool", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() { conn.Close() }
+}
+
+// waitForConn releases the busy connection of db after a delay, and waits
+// for it.
+func waitForConn(t *testing.T, db *sql.DB, release func()) {
+	time.AfterFunc(20*time.Millisecond, release)
+	if err := db.PingContext(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func checkPoolLabels(t *testing.T, span *Span, name string, wantWait bool) {
+	t.Helper()
+	count, _ := spanLabel(span, "db/"+name+"/wait_count")
+	ms, _ := spanLabel(span, "db/"+name+"/wait_ms")
+	inUse, _ := spanLabel(span, "db/"+name+"/in_use")
+	if !wantWait {
+		if count != "0" || ms != "0" || inUse != "0" {
+			t.Errorf("pool %s: wait_count %q, wait_ms %q, in_use %q; want all 0", name, count, ms, inUse)
+		}
+		return
+	}
+	if count != "1" {
+		t.Errorf("pool %s: wait_count = %q; want 1", name, count)
+	}
+	if n, err := strconv.Atoi(ms); err != nil || n < 10 {
+		t.Errorf("pool %s: wait_ms = %q; want at least 10", name, ms)
+	}
+	if inUse != "-1" {
+		t.Errorf("pool %s: in_use = %q; want -1, for the connection released during the span", name, inUse)
+	}
+}
+
+func TestDBPoolLabelsHTTPHandler(t *testing.T) {
+	busy, release := newBusyPool(t)
+	idle, _ := newBusyPool(t)
+	defer busy.Close()
+	defer idle.Close()
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.InstrumentDBPool(busy, "busy")
+	tc.InstrumentDBPool(idle, "idle")
+
+	var span *Span
+	h := tc.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span = FromContext(r.Context())
+		waitForConn(t, busy, release)
+	}), WithDBPoolLabels())
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set(httpHeader, "0123456789abcdef0123456789abcdef/1;o=1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	checkPoolLabels(t, span, "busy", true)
+	checkPoolLabels(t, span, "idle", false)
+}
+
+func TestDBPoolLabelsGRPC(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		db, release := newBusyPool(t)
+		tc := NewClientWithExporter(&recordingExporter{})
+		tc.InstrumentDBPool(db, "main")
+		var opts []InterceptorOption
+		if enabled {
+			opts = append(opts, WithDBPoolLabels())
+		}
+		var span *Span
+		md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		GRPCServerInterceptor(tc, opts...)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			span = FromContext(ctx)
+			waitForConn(t, db, release)
+			return nil, nil
+		})
+		if enabled {
+			checkPoolLabels(t, span, "main", true)
+		} else if _, ok := spanLabel(span, "db/main/wait_count"); ok {
+			t.Error("span has pool labels without WithDBPoolLabels")
+		}
+		db.Close()
+	}
+}
+
+func TestInstrumentDBPoolReplace(t *testing.T) {
+	a, _ := newBusyPool(t)
+	b, _ := newBusyPool(t)
+	defer a.Close()
+	defer b.Close()
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.InstrumentDBPool(a, "x")
+	tc.InstrumentDBPool(a, "y")
+	tc.InstrumentDBPool(b, "x")
+	if got := tc.dbPools.pools; len(got) != 2 || got[0].name != "y" || got[1].db != b {
+		t.Errorf("pools = %v; want y and the new x", got)
+	}
+	tc.InstrumentDBPool(nil, "y")
+	if got := tc.dbPools.pools; len(got) != 1 || got[0].name != "x" {
+		t.Errorf("pools = %v; want only x", got)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+const (
+	labelGRPCDeadlineMs           = `grpc/deadline_ms`
+	labelGRPCDeadlineUsedFraction = `grpc/deadline_used_fraction`
+	labelGRPCDeadlineNearMiss     = `grpc/deadline_near_miss`
+	labelGRPCErrorSource          = `grpc/error_source`
+)
+
+// deadlineNearMiss is the fraction of its deadline budget above which a call
+// is labeled as a near miss, whether or not it succeeded.
+const deadlineNearMiss = 0.9
+
+// deadlineNow returns the current time.  Tests replace it with a fake clock.
+var deadlineNow = time.Now
+
+// A deadlineBudget records the deadline of an outgoing call, for labeling
+// the call's span with the fraction of the budget it used.
+type deadlineBudget struct {
+	start    time.Time
+	deadline time.Time
+}
+
+// startDeadlineBudget annotates span with the deadline of ctx, labels it
+// with the time left until the deadline, as setDeadlineLabel does, and
+// returns the budget of the call.  It returns nil if ctx has no deadline or
+// span is not traced.
+func startDeadlineBudget(ctx context.Context, span *Span) *deadlineBudget {
+	deadline, ok := ctx.Deadline()
+	if !ok || !span.tracing() {
+		return nil
+	}
+	span.Annotate("deadline " + deadline.UTC().Format(time.RFC3339Nano))
+	b := &deadlineBudget{start: deadlineNow(), deadline: deadline}
+	setRemainingLabel(span, b.deadline.Sub(b.start))
+	return b
+}
+
+// setDeadlineLabel labels span with the time left until the deadline of
+// ctx, in milliseconds, as "grpc/deadline_ms"; the servers use it for the
+// deadline of an incoming call.  The label is omitted if ctx has no
+// deadline.
+func setDeadlineLabel(span *Span, ctx context.Context) {
+	if deadline, ok := ctx.Deadline(); ok && span.traced() {
+		setRemainingLabel(span, deadline.Sub(deadlineNow()))
+	}
+}
+
+// setRemainingLabel labels span with the time left until a deadline, d, in
+// milliseconds; zero if the deadline has passed.
+func setRemainingLabel(span *Span, d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	span.setLabel(labelGRPCDeadlineMs, strconv.FormatInt(int64(d/time.Millisecond), 10))
+}
+
+// setErrorSource labels span, the span of an outgoing call that failed with
+// err, with the source of the failure, as "grpc/error_source": "context" if
+// ctx, the caller's context, is done, in which case span is also labeled
+// "grpc/context_done" with "cancelled" or "deadline_exceeded", and "status"
+// if the call failed with a status of its own, such as one returned by the
+// server.  It does nothing if err is nil.
+func setErrorSource(span *Span, ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	if ctx.Err() != nil {
+		span.setLabel(labelGRPCErrorSource, "context")
+		setContextDoneLabel(span, ctx)
+		return
+	}
+	span.setLabel(labelGRPCErrorSource, "status")
+}
+
+// finish labels span with the fraction of the budget used so far, as
+// "grpc/deadline_used_fraction", and with "grpc/deadline_near_miss" if more
+// than 90% of it was used.  Calls whose deadline had already passed when they
+// started have no budget, and get only the near miss label.
+func (b *deadlineBudget) finish(span *Span) {
+	if b == nil {
+		return
+	}
+	budget := b.deadline.Sub(b.start)
+	if budget <= 0 {
+		span.setLabel(labelGRPCDeadlineNearMiss, "true")
+		return
+	}
+	used := float64(deadlineNow().Sub(b.start)) / float64(budget)
+	span.setLabel(labelGRPCDeadlineUsedFraction, strconv.FormatFloat(used, 'f', 3, 64))
+	if used > deadlineNearMiss {
+		span.setLabel(labelGRPCDeadlineNearMiss, "true")
+	}
+}
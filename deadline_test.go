@@ -0,0 +1,193 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDeadlineClock makes deadlineNow return start, advanced by elapsed after
+// the first call.  It returns a func that restores the real clock.
+func fakeDeadlineClock(start time.Time, elapsed time.Duration) func() {
+	calls := 0
+	deadlineNow = func() time.Time {
+		calls++
+		if calls == 1 {
+			return start
+		}
+		return start.Add(elapsed)
+	}
+	return func() { deadlineNow = time.Now }
+}
+
+type fakeClientStream struct {
+	grpc.ClientStream
+	ctx context.Context
+}
+
+func (s *fakeClientStream) Context() context.Context { return s.ctx }
+func (s *fakeClientStream) CloseSend() error         { return nil }
+
+var deadlineTests = []struct {
+	desc         string
+	budget       time.Duration // zero for no deadline.
+	elapsed      time.Duration
+	wantMs       string
+	wantFraction string
+	wantNearMiss string
+}{
+	{desc: "no deadline", elapsed: time.Second},
+	{desc: "quick", budget: time.Second, elapsed: 250 * time.Millisecond, wantMs: "1000", wantFraction: "0.250"},
+	{desc: "at the threshold", budget: time.Second, elapsed: 900 * time.Millisecond, wantMs: "1000", wantFraction: "0.900"},
+	{desc: "near miss", budget: time.Second, elapsed: 950 * time.Millisecond, wantMs: "1000", wantFraction: "0.950", wantNearMiss: "true"},
+	{desc: "exceeded", budget: time.Second, elapsed: 2 * time.Second, wantMs: "1000", wantFraction: "2.000", wantNearMiss: "true"},
+	{desc: "already expired", budget: -time.Second, wantMs: "0", wantNearMiss: "true"},
+}
+
+// deadlineContext returns a context with the span root, and a deadline budget
+// after start if budget is nonzero.
+func deadlineContext(root *Span, start time.Time, budget time.Duration) (context.Context, context.CancelFunc) {
+	ctx := NewContext(context.Background(), root)
+	if budget == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, start.Add(budget))
+}
+
+func checkDeadlineLabels(t *testing.T, desc string, s *SpanData, wantMs, wantFraction, wantNearMiss string) {
+	if got, ok := s.Labels[labelGRPCDeadlineMs]; got != wantMs || ok != (wantMs != "") {
+		t.Errorf("%s: %s = %q (present %t); want %q", desc, labelGRPCDeadlineMs, got, ok, wantMs)
+	}
+	if got := s.Labels[labelGRPCDeadlineUsedFraction]; got != wantFraction {
+		t.Errorf("%s: %s = %q; want %q", desc, labelGRPCDeadlineUsedFraction, got, wantFraction)
+	}
+	if got := s.Labels[labelGRPCDeadlineNearMiss]; got != wantNearMiss {
+		t.Errorf("%s: %s = %q; want %q", desc, labelGRPCDeadlineNearMiss, got, wantNearMiss)
+	}
+	if gotAnnotated, want := len(s.Annotations) == 1, wantFraction != "" || wantNearMiss != ""; gotAnnotated != want {
+		t.Errorf("%s: annotations = %v; want deadline annotation %t", desc, s.Annotations, want)
+	}
+}
+
+func TestDeadlineUnary(t *testing.T) {
+	// The fake clock starts in the future, so that none of the deadlines,
+	// even those before start, expire during the test.
+	start := time.Now().Add(time.Hour)
+	for _, tt := range deadlineTests {
+		restore := fakeDeadlineClock(start, tt.elapsed)
+		e := &recordingExporter{}
+		root := NewClientWithExporter(e).NewSpan("/root")
+		ctx, cancel := deadlineContext(root, start, tt.budget)
+		GRPCClientInterceptor()(ctx, "/call", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return nil
+		})
+		cancel()
+		restore()
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		checkDeadlineLabels(t, tt.desc, e.span("/call"), tt.wantMs, tt.wantFraction, tt.wantNearMiss)
+	}
+}
+
+func TestDeadlineStream(t *testing.T) {
+	start := time.Now().Add(time.Hour)
+	for _, tt := range deadlineTests {
+		restore := fakeDeadlineClock(start, tt.elapsed)
+		e := &recordingExporter{}
+		root := NewClientWithExporter(e).NewSpan("/root")
+		ctx, cancel := deadlineContext(root, start, tt.budget)
+		cs, err := GRPCStreamClientInterceptor()(ctx, &grpc.StreamDesc{}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &fakeClientStream{ctx: ctx}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs.CloseSend()
+		cancel()
+		restore()
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		checkDeadlineLabels(t, tt.desc, e.span("/stream"), tt.wantMs, tt.wantFraction, tt.wantNearMiss)
+	}
+}
+
+func TestDeadlineServer(t *testing.T) {
+	start := time.Now().Add(time.Hour)
+	for _, tt := range deadlineTests {
+		restore := fakeDeadlineClock(start, tt.elapsed)
+		e := &recordingExporter{exported: make(chan struct{}, 1)}
+		tc := NewClientWithExporter(e)
+		tc.bundler.BundleCountThreshold = 1
+		md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+		ctx, cancel := metadata.NewIncomingContext(context.Background(), md), func() {}
+		if tt.budget != 0 {
+			ctx, cancel = context.WithDeadline(ctx, start.Add(tt.budget))
+		}
+		GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/server"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		cancel()
+		restore()
+		<-e.exported
+		if got, ok := e.spans[0].Labels[labelGRPCDeadlineMs]; got != tt.wantMs || ok != (tt.wantMs != "") {
+			t.Errorf("%s: %s = %q (present %t); want %q", tt.desc, labelGRPCDeadlineMs, got, ok, tt.wantMs)
+		}
+	}
+}
+
+func TestErrorSource(t *testing.T) {
+	callErr := status.Error(codes.Unavailable, "no backends")
+	for _, tt := range []struct {
+		desc       string
+		cancel     bool // cancel the caller's context before the call returns.
+		err        error
+		wantSource string
+		wantDone   string
+	}{
+		{desc: "ok"},
+		{desc: "status", err: callErr, wantSource: "status"},
+		{desc: "cancelled", cancel: true, err: status.Error(codes.Canceled, "context canceled"), wantSource: "context", wantDone: "cancelled"},
+	} {
+		e := &recordingExporter{}
+		root := NewClientWithExporter(e).NewSpan("/root")
+		ctx, cancel := context.WithCancel(NewContext(context.Background(), root))
+		GRPCClientInterceptor()(ctx, "/call", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			if tt.cancel {
+				cancel()
+			}
+			return tt.err
+		})
+		cancel()
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		s := e.span("/call")
+		if got, ok := s.Labels[labelGRPCErrorSource]; got != tt.wantSource || ok != (tt.wantSource != "") {
+			t.Errorf("%s: %s = %q (present %t); want %q", tt.desc, labelGRPCErrorSource, got, ok, tt.wantSource)
+		}
+		if got := s.Labels[labelGRPCContextDone]; got != tt.wantDone {
+			t.Errorf("%s: %s = %q; want %q", tt.desc, labelGRPCContextDone, got, tt.wantDone)
+		}
+	}
+}
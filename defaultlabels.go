@@ -0,0 +1,57 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+// SetDefaultLabels sets labels that every span of the client carries when
+// it is exported, whichever way it was created, such as the name, version
+// and region of the service.  A label that a span sets itself wins over the
+// default label with the same key.  A nil or empty map removes the default
+// labels.
+//
+// SetDefaultLabels copies labels, so the caller may modify the map after
+// the call.  It may be called while spans are in use: each trace is
+// exported with either the old or the new default labels on all its spans.
+func (c *Client) SetDefaultLabels(labels map[string]string) {
+	if c == nil {
+		return
+	}
+	m := make(map[string]string, len(labels))
+	for k, v := range labels {
+		m[k] = v
+	}
+	c.defaultLabels.Store(m)
+}
+
+// loadDefaultLabels returns the labels set with SetDefaultLabels, which must
+// not be modified, or nil.
+func (c *Client) loadDefaultLabels() map[string]string {
+	m, _ := c.defaultLabels.Load().(map[string]string)
+	return m
+}
+
+// addDefaultLabels adds the labels of defaults that d doesn't have to d.
+func addDefaultLabels(d *SpanData, defaults map[string]string) {
+	if len(defaults) == 0 {
+		return
+	}
+	if d.Labels == nil {
+		d.Labels = make(map[string]string, len(defaults))
+	}
+	for k, v := range defaults {
+		if _, ok := d.Labels[k]; !ok {
+			d.Labels[k] = v
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestDefaultLabels(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetSamplingPolicy(alwaysTrace{})
+	defaults := map[string]string{"service": "front", "version": "1.2", "region": "eu"}
+	tc.SetDefaultLabels(defaults)
+	defaults["pod"] = "added after the call"
+
+	root := tc.NewSpan("/root")
+	root.SetLabel("region", "us")
+	root.NewChild("/child").Finish()
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	GRPCClientInterceptor()(NewContext(context.Background(), root), "/client", nil, nil, nil, invoker)
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SpanFromHeader("/from header", "0123456789abcdef0123456789abcdef/1;o=1").FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/from/request", nil)
+	if err := tc.SpanFromRequest(req).FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	GRPCServerInterceptor(tc, AlwaysTrace(), SynchronousFinish(0))(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/server"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	if len(e.spans) != 6 {
+		t.Fatalf("exported %d spans; want 6", len(e.spans))
+	}
+	for _, s := range e.spans {
+		wantRegion := "eu"
+		if s.Name == "/root" {
+			// The span's own label wins.
+			wantRegion = "us"
+		}
+		if s.Labels["service"] != "front" || s.Labels["version"] != "1.2" || s.Labels["region"] != wantRegion {
+			t.Errorf("span %q has labels %v; want the default labels, with region %s", s.Name, s.Labels, wantRegion)
+		}
+		if _, ok := s.Labels["pod"]; ok {
+			t.Errorf("span %q has the label added to the map after SetDefaultLabels", s.Name)
+		}
+	}
+	if got := tc.Config().DefaultLabels; got != 3 {
+		t.Errorf("Config().DefaultLabels = %d; want 3", got)
+	}
+
+	// Removing the default labels.
+	tc.SetDefaultLabels(nil)
+	if err := tc.NewSpan("/after").FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := e.span("/after").Labels["service"]; ok {
+		t.Errorf("after SetDefaultLabels(nil), label service = %q; want none", v)
+	}
+}
+
+func TestDefaultLabelsConcurrent(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetSamplingPolicy(alwaysTrace{})
+	sets := []map[string]string{{"version": "1"}, {"version": "2", "canary": "true"}}
+	tc.SetDefaultLabels(sets[0])
+
+	done := make(chan struct{})
+	var setter sync.WaitGroup
+	setter.Add(1)
+	go func() {
+		defer setter.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				tc.SetDefaultLabels(sets[i%2])
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				root := tc.NewSpan("/root")
+				root.NewChild("/child").Finish()
+				if err := root.FinishWait(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	setter.Wait()
+
+	versions := make(map[string]string) // by trace ID.
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.spans {
+		v := s.Labels["version"]
+		if v != "1" && v != "2" || (v == "2") != (s.Labels["canary"] == "true") {
+			t.Fatalf("span of trace %s has labels %v; want one of the sets of default labels %v", s.TraceID, s.Labels, sets)
+		}
+		if w, ok := versions[s.TraceID]; ok && w != v {
+			t.Errorf("trace %s has spans with versions %s and %s; want the same default labels on all", s.TraceID, w, v)
+		}
+		versions[s.TraceID] = v
+	}
+	for i, m := range sets {
+		if len(m) != i+1 {
+			t.Errorf("the set of default labels %v was modified", m)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"net"
+	"net/http"
+)
+
+// labelDependency is the label of the spans of outgoing HTTP requests with
+// the logical name of the service they call, set by WithDependencyNamer.
+const labelDependency = `dependency`
+
+type withDependencyNamer struct {
+	namer func(*http.Request) string
+}
+
+// WithDependencyNamer returns an InterceptorOption that labels the span of
+// each outgoing request of an HTTPClient "dependency", with the logical
+// name of the service it calls, such as "payments-api", which namer returns
+// for the request.  Unlike hosts, the names can stay the same in every
+// environment, so latency can be compared by dependency.  If namer is nil,
+// HostDependency is used; if it returns "", the span isn't labeled.
+//
+// Summary traces, enabled with SetSummaryTraces, also summarize the spans
+// labeled with each dependency.
+func WithDependencyNamer(namer func(*http.Request) string) InterceptorOption {
+	if namer == nil {
+		namer = HostDependency
+	}
+	return withDependencyNamer{namer: namer}
+}
+
+func (o withDependencyNamer) configureInterceptor(c *interceptorConfig) {
+	c.dependencyNamer = o.namer
+}
+
+type withDependencySpanNames struct{}
+
+// WithDependencySpanNames returns an InterceptorOption that, with
+// WithDependencyNamer, names the spans of the outgoing requests of an
+// HTTPClient with their dependency followed by the path of the request,
+// instead of with their host and path: "payments-api/v1/charge" rather than
+// "payments.staging.example.com:8443/v1/charge".  Spans of requests without
+// a dependency keep their names.
+func WithDependencySpanNames() InterceptorOption {
+	return withDependencySpanNames{}
+}
+
+func (withDependencySpanNames) configureInterceptor(c *interceptorConfig) {
+	c.dependencySpanNames = true
+}
+
+// HostDependency returns the host of r without its port, the default
+// dependency name of WithDependencyNamer.
+func HostDependency(r *http.Request) string {
+	host := r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// setDependency labels span, the span of the outgoing request r, with the
+// dependency that namer returns for r, and if names is true, renames it.
+func setDependency(span *Span, r *http.Request, namer func(*http.Request) string, names bool) {
+	if !span.traced() {
+		return
+	}
+	dep := namer(r)
+	if dep == "" {
+		return
+	}
+	span.setLabel(labelDependency, dep)
+	if names {
+		span.SetName(dep + r.URL.Path)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDependencyNamer(t *testing.T) {
+	tick, restore := useFakeSummaryTicker()
+	defer restore()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	payments := httptest.NewServer(http.HandlerFunc(ok))
+	defer payments.Close()
+	search := httptest.NewServer(http.HandlerFunc(ok))
+	defer search.Close()
+	namer := func(r *http.Request) string {
+		switch {
+		case strings.HasPrefix(payments.URL, "http://"+r.URL.Host):
+			return "payments-api"
+		case strings.HasPrefix(search.URL, "http://"+r.URL.Host):
+			return "search-api"
+		}
+		return ""
+	}
+
+	e := &recordingExporter{exported: make(chan struct{})}
+	tc := NewClientWithExporter(e)
+	tc.SetSummaryTraces(time.Minute)
+	defer tc.Close()
+	renaming := tc.NewHTTPClient(nil, WithDependencyNamer(namer), WithDependencySpanNames())
+	labeling := tc.NewHTTPClient(nil, WithDependencyNamer(namer))
+
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	for _, tt := range []struct {
+		client *HTTPClient
+		url    string
+	}{
+		{renaming, payments.URL + "/charge"},
+		{renaming, payments.URL + "/refund"},
+		{labeling, search.URL + "/query"},
+		{renaming, search.URL + "/query"},
+	} {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		resp, err := tt.client.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	errc := make(chan error)
+	go func() { errc <- root.FinishWait() }()
+	<-e.exported
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	searchName := strings.TrimPrefix(search.URL, "http://") + "/query"
+	for _, want := range []struct {
+		name, dependency string
+	}{
+		{"payments-api/charge", "payments-api"},
+		{"payments-api/refund", "payments-api"},
+		{searchName, "search-api"},
+		{"search-api/query", "search-api"},
+	} {
+		s := e.span(want.name)
+		if s == nil {
+			t.Errorf("no span named %q in %v", want.name, names(e.spans))
+			continue
+		}
+		if got := s.Labels[labelDependency]; got != want.dependency {
+			t.Errorf("span %q: %s = %q; want %q", want.name, labelDependency, got, want.dependency)
+		}
+	}
+
+	// The summary trace counts the spans of each dependency.
+	e.spans = nil
+	tick <- time.Now()
+	<-e.exported
+	deps := map[string]int{}
+	for _, s := range e.spans {
+		if dep := s.Labels[labelDependency]; dep != "" {
+			if s.Name != dep {
+				t.Errorf("summary span %q is labeled with dependency %q", s.Name, dep)
+			}
+			deps[dep] += int(mustParseInt(t, s.Labels[labelSummaryCount]))
+		}
+	}
+	if deps["payments-api"] != 2 || deps["search-api"] != 2 || len(deps) != 2 {
+		t.Errorf("summarized dependencies %v; want 2 spans of payments-api and 2 of search-api", deps)
+	}
+}
+
+func mustParseInt(t *testing.T, s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestHostDependency(t *testing.T) {
+	for _, tt := range []struct {
+		url, host, want string
+	}{
+		{url: "http://payments.example.com/charge", want: "payments.example.com"},
+		{url: "https://payments.example.com:8443/charge", want: "payments.example.com"},
+		{url: "http://[::1]:8080/", want: "::1"},
+		{url: "/charge", host: "search.example.com:80", want: "search.example.com"},
+	} {
+		req, err := http.NewRequest("GET", tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = tt.host
+		if got := HostDependency(req); got != tt.want {
+			t.Errorf("HostDependency(%s, host %q) = %q; want %q", tt.url, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestDependencyNamerUntraced(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(neverTrace{})
+	root := tc.NewSpan("/root")
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	span := root.NewRemoteChild(req)
+	setDependency(span, req, HostDependency, true)
+	if got := span.span.Name; got != "/root" {
+		t.Errorf("untraced span renamed %q; want /root unchanged", got)
+	}
+}
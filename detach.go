@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "sync/atomic"
+
+// NewDetachedChild creates a new span with the given name as a child of s,
+// configured by opts, for work that outlives s, such as a cache refill
+// started by a handler that returns before it is done.  Unlike the spans
+// created by NewChild, which are uploaded with the trace when its local
+// root span finishes, and not at all if they finish later, a detached child
+// is uploaded, with its own children, when it finishes itself, however long
+// after s.  It is in the same trace as s, with s as its parent:
+//
+//   span := trace.FromContext(ctx).NewDetachedChild("refill")
+//   go func() {
+//       ctx := trace.NewContext(context.Background(), span)
+//       defer span.Finish()
+//       refill(ctx)
+//   }()
+//
+// Calls made with a context containing the span propagate the trace, like
+// calls made with any other span.  s may have finished, and its trace been
+// uploaded, before NewDetachedChild is called.  The trace is traced only if
+// s is; Shutdown waits for detached children to finish, like it waits for
+// root spans.
+//
+// If s is nil, does nothing and returns nil.
+func (s *Span) NewDetachedChild(name string, opts ...SpanOption) *Span {
+	if s == nil {
+		return nil
+	}
+	if !s.tracing() {
+		return s.untracedChild()
+	}
+	p := s.trace
+	t := &trace{
+		client:       p.client,
+		traceID:      p.traceID,
+		extraOptions: p.extraOptions,
+		forced:       p.forced,
+		projectID:    p.projectID,
+		labelCap:     p.labelCap,
+		slowOnly:     atomic.LoadInt32(&p.slowOnly),
+	}
+	if labels, ok := p.inherited.Load().(map[string]string); ok {
+		t.inherited.Store(labels)
+	}
+	span := startNewChild(name, t, s.span.SpanId, s.loadOptions())
+	span.rootSpan = true
+	span.detached = true
+	span.inherit()
+	span.applyOptions(opts)
+	if c := t.client; c.beginRoot(span) {
+		c.admitRoot(span)
+	}
+	return span
+}
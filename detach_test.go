@@ -0,0 +1,97 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestDetachedChild(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	root.SetInheritedLabel("tenant", "t1")
+
+	// The detached child is created after its parent has been uploaded.
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	detached := root.NewDetachedChild("/refill")
+	if got, want := detached.TraceID(), root.TraceID(); got != want {
+		t.Errorf("TraceID = %q; want %q", got, want)
+	}
+	if got, want := detached.ParentSpanID(), root.SpanID(); got != want {
+		t.Errorf("ParentSpanID = %x; want %x", got, want)
+	}
+
+	// An outgoing call made with the detached span propagates its trace.
+	ctx := NewContext(context.Background(), detached)
+	var header []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		header = md[grpcMetadataKey]
+		return nil
+	}
+	if err := GRPCClientInterceptor()(ctx, "/test.Service/Write", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if len(header) != 1 {
+		t.Fatalf("trace headers %q; want one", header)
+	}
+	traceID, spanID, _, _, err := parseHeaderErr(header[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if traceID != root.TraceID() {
+		t.Errorf("propagated trace %q; want %q", traceID, root.TraceID())
+	}
+
+	if err := detached.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if got, want := names(e.spans), []string{"/root", "/refill", "/test.Service/Write"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("exported spans %q; want %q", got, want)
+	}
+	refill, call := e.spans[1], e.spans[2]
+	if refill.TraceID != root.TraceID() || refill.ParentSpanID != root.SpanID() {
+		t.Errorf("detached span in trace %q with parent %x; want trace %q with parent %x", refill.TraceID, refill.ParentSpanID, root.TraceID(), root.SpanID())
+	}
+	if got := refill.Labels["tenant"]; got != "t1" {
+		t.Errorf("inherited label = %q; want %q", got, "t1")
+	}
+	if call.ParentSpanID != refill.SpanID || spanID != refill.SpanID {
+		t.Errorf("call span with parent %x, propagated as %x; want a child of %x", call.ParentSpanID, spanID, refill.SpanID)
+	}
+}
+
+func TestDetachedChildUntraced(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(neverTrace{})
+	root := tc.NewSpan("/root")
+	if got := root.NewDetachedChild("/refill"); got.tracing() {
+		t.Error("detached child of an untraced span is traced")
+	}
+	var nilSpan *Span
+	if got := nilSpan.NewDetachedChild("/refill"); got != nil {
+		t.Errorf("NewDetachedChild of nil span = %v; want nil", got)
+	}
+}
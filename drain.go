@@ -0,0 +1,184 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// DrainStats holds the counts of the root spans that Shutdown waited for.
+type DrainStats struct {
+	// Drained is the number of root spans that finished while Shutdown was
+	// waiting, and whose traces were uploaded.
+	Drained int
+
+	// Abandoned is the number of root spans that were still unfinished
+	// when the context passed to Shutdown was done.  Their traces are not
+	// uploaded by Shutdown.
+	Abandoned int
+}
+
+// Shutdown is like Close, but first drains the client's in-flight traces,
+// to keep the spans of the requests served during shutdown.  From the call
+// on, the client starts no new traces: the root spans it creates, including
+// those of the HTTP handler and the gRPC server interceptors, are not
+// traced, although they still propagate the trace context of incoming
+// requests.  Shutdown then waits until every traced root span created
+// before the call has finished, or until ctx is done, and closes the
+// client.
+//
+// A trace is uploaded when its root span finishes, so Shutdown waits for
+// root spans; the count of root spans it is waiting for is in the
+// SpansInFlight field of Stats.  If ctx is done first, Shutdown returns
+// ctx.Err() along with the counts of drained and abandoned spans; the
+// traces of the abandoned spans are rejected, as after Close, if they
+// finish later.
+func (c *Client) Shutdown(ctx context.Context) (DrainStats, error) {
+	if c == nil {
+		return DrainStats{}, nil
+	}
+	idle := c.drain.start()
+	var err error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	st := c.drain.stats()
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return st, err
+}
+
+// startRoot decides whether span, a new root span, is traced: it is not if
+// the client is shutting down, and otherwise the client's sampling policy
+// decides.  ok reports whether the span's trace was read from a header.
+func (c *Client) startRoot(span *Span, ok bool) {
+	if !c.beginRoot(span) {
+		return
+	}
+	configureSpanFromPolicy(span, c.samplingPolicy(), ok)
+	c.admitRoot(span)
+}
+
+// beginRoot reports whether the sampling policy should decide whether span,
+// a new root span, is traced.  If the client is shutting down, it makes
+// span untraced and returns false.
+func (c *Client) beginRoot(span *Span) bool {
+	if c.logger != nil {
+		c.configLog.Do(c.logConfig)
+	}
+	if c.drain.refusing() {
+		span.options.local = 0
+		atomic.AddInt64(&c.stats.spansSampledOut, 1)
+		return false
+	}
+	return true
+}
+
+// admitRoot counts span, a new root span whose sampling decision has been
+// made, as in flight if it is traced.
+func (c *Client) admitRoot(span *Span) {
+	if !span.tracing() {
+		atomic.AddInt64(&c.stats.spansSampledOut, 1)
+		return
+	}
+	if !c.drain.add() {
+		// Shutdown was called while the policy decided.
+		span.options.local = 0
+		atomic.AddInt64(&c.stats.spansSampledOut, 1)
+		return
+	}
+	span.inFlight = 1
+	c.stats.spansStarted.add(span.span.SpanId, 1)
+	span.startTask()
+}
+
+// endRoot records that the trace of span, a root span being finished, has
+// been uploaded or handed to the bundler.
+func (c *Client) endRoot(span *Span) {
+	if atomic.CompareAndSwapInt32(&span.inFlight, 1, 0) {
+		c.drain.done()
+	}
+}
+
+// A drainer counts the traced root spans of a client that haven't finished,
+// for Shutdown.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	drained  int
+	idle     chan struct{} // closed when inFlight is zero while draining.
+}
+
+// start starts draining, and returns a channel that is closed once there
+// are no root spans in flight.
+func (d *drainer) start() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.draining {
+		d.draining = true
+		d.idle = make(chan struct{})
+		if d.inFlight == 0 {
+			close(d.idle)
+		}
+	}
+	return d.idle
+}
+
+func (d *drainer) refusing() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// add records a new root span in flight, unless the client is draining.
+func (d *drainer) add() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+func (d *drainer) done() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.draining {
+		d.drained++
+		if d.inFlight == 0 {
+			close(d.idle)
+		}
+	}
+}
+
+func (d *drainer) stats() DrainStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DrainStats{Drained: d.drained, Abandoned: d.inFlight}
+}
+
+func (d *drainer) count() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return int64(d.inFlight)
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestShutdownDrainsSlowHandler(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+
+	const method = "/test.Service/Slow"
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		GRPCServerInterceptor(tc, AlwaysTrace())(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+	}()
+	<-started
+	if got := tc.Stats().SpansInFlight; got != 1 {
+		t.Errorf("SpansInFlight = %d; want 1", got)
+	}
+
+	type result struct {
+		st  DrainStats
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		st, err := tc.Shutdown(ctx)
+		done <- result{st, err}
+	}()
+
+	// New root spans are refused while draining.
+	for !tc.drain.refusing() {
+		time.Sleep(time.Millisecond)
+	}
+	if span := tc.NewSpan("/late"); span.tracing() {
+		t.Error("root span created during Shutdown is traced")
+	}
+	select {
+	case r := <-done:
+		t.Fatalf("Shutdown returned %+v, %v before the handler finished", r.st, r.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-handled
+	r := <-done
+	if r.err != nil {
+		t.Errorf("Shutdown error = %v; want nil", r.err)
+	}
+	if want := (DrainStats{Drained: 1}); r.st != want {
+		t.Errorf("Shutdown stats = %+v; want %+v", r.st, want)
+	}
+	if e.span(method) == nil {
+		t.Errorf("span of the slow handler wasn't exported; got %d spans", len(e.spans))
+	}
+	if got := tc.Stats().SpansInFlight; got != 0 {
+		t.Errorf("SpansInFlight = %d; want 0", got)
+	}
+}
+
+func TestShutdownAbandons(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.NewSpan("/never-finished")
+	finished := tc.NewSpan("/finished")
+	if err := finished.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	st, err := tc.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Shutdown error = %v; want %v", err, context.DeadlineExceeded)
+	}
+	if want := (DrainStats{Abandoned: 1}); st != want {
+		t.Errorf("Shutdown stats = %+v; want %+v", st, want)
+	}
+}
+
+func TestShutdownHTTPHandler(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	if _, err := tc.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var traced bool
+	handler := tc.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traced = FromContext(r.Context()).tracing()
+	}))
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set(httpHeader, "0123456789abcdef0123456789abcdef/1;o=1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if traced {
+		t.Error("request served after Shutdown is traced")
+	}
+	if got := tc.Stats().SpansInFlight; got != 0 {
+		t.Errorf("SpansInFlight = %d; want 0", got)
+	}
+}
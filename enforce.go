@@ -0,0 +1,121 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	labelLateFinishAttempted = `trace/late_finish_attempted`
+	labelHandlerMs           = `trace/handler_ms`
+)
+
+type withEnforcedFinish struct{}
+
+// WithEnforcedFinish returns an InterceptorOption that makes the server
+// interceptors own the end of the span of each traced call.  Calling Finish
+// on the span, from the handler or from a goroutine it started, no longer
+// uploads it: the interceptors finish the span when the handler returns,
+// at the time of the handler's first Finish call if it made one, and so
+// the trace shows the latency of the call as its client saw it.
+//
+// Finish calls made after the handler returned have no effect, besides
+// labeling the span "trace/late_finish_attempted", which is seen by
+// Snapshot, but not by exporters, since the span has already been
+// uploaded.  With strict validation enabled, such calls are also reported
+// to the error handler.  If the handler finished the span before returning,
+// the span is labeled with the wall time of the handler, in
+// milliseconds, as "trace/handler_ms".
+func WithEnforcedFinish() InterceptorOption {
+	return withEnforcedFinish{}
+}
+
+func (withEnforcedFinish) configureInterceptor(c *interceptorConfig) {
+	c.enforcedFinish = true
+}
+
+// An enforcedFinish holds the state of a span whose end is owned by a server
+// interceptor.
+type enforcedFinish struct {
+	start time.Time // when the handler was called.
+
+	mu       sync.Mutex
+	end      time.Time // when the handler first called Finish, or zero.
+	opts     []FinishOption
+	returned bool
+}
+
+// enforceFinish makes the interceptor that calls it responsible for
+// finishing span, the span of an incoming call, with finishAfterHandler.
+func enforceFinish(span *Span) {
+	if span == nil || !span.tracing() {
+		return
+	}
+	span.enforced = &enforcedFinish{start: time.Now()}
+}
+
+// deferFinish records a call of Finish on s, ending at end, whose end is
+// owned by an interceptor.
+func (s *Span) deferFinish(end time.Time, opts []FinishOption) {
+	e := s.enforced
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.returned {
+		s.setLabel(labelLateFinishAttempted, "true")
+		if c := s.trace.client; c.strict {
+			s.spanMu.Lock()
+			name := s.span.Name
+			s.spanMu.Unlock()
+			c.reportError(fmt.Errorf("trace: span %q finished after its handler returned", name))
+		}
+		return
+	}
+	if e.end.IsZero() {
+		e.end, e.opts = end, opts
+	}
+}
+
+// finishAfterHandler finishes s, the span of an incoming call, once its
+// handler has returned.  If wait is set, it waits for the upload of the
+// trace, like FinishWait.
+func (s *Span) finishAfterHandler(wait bool) error {
+	if s == nil || s.enforced == nil {
+		if wait {
+			return s.FinishWait()
+		}
+		s.Finish()
+		return nil
+	}
+	e := s.enforced
+	now := time.Now()
+	e.mu.Lock()
+	if e.returned {
+		e.mu.Unlock()
+		return nil
+	}
+	e.returned = true
+	end, opts := e.end, e.opts
+	e.mu.Unlock()
+	if end.IsZero() {
+		end = now
+	} else {
+		s.setLabel(labelHandlerMs, strconv.FormatInt(int64(now.Sub(e.start)/time.Millisecond), 10))
+	}
+	return s.trace.finish(s, end, wait, opts...)
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// callEnforced makes a traced unary call, with WithEnforcedFinish, to
+// handler, and returns the time at which the handler returned.
+func callEnforced(tc *Client, handler grpc.UnaryHandler) time.Time {
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	GRPCServerInterceptor(tc, WithEnforcedFinish())(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, handler)
+	return time.Now()
+}
+
+func TestEnforcedFinishLate(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+	tc.SetStrictValidation(true)
+	errs := make(chan error, 1)
+	tc.SetErrorHandler(func(err error) { errs <- err })
+
+	late := make(chan *Span)
+	var before time.Time
+	returned := callEnforced(tc, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span := FromContext(ctx)
+		// The handler stashes the span, and finishes it long after the
+		// call returned.
+		go func() {
+			span := <-late
+			time.Sleep(50 * time.Millisecond)
+			span.Finish()
+			late <- span
+		}()
+		before = time.Now()
+		late <- span
+		return nil, nil
+	})
+
+	select {
+	case <-e.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the span to be exported")
+	}
+	if len(e.spans) != 1 {
+		t.Fatalf("exported %d spans; want 1", len(e.spans))
+	}
+	s := e.spans[0]
+	if s.End.Before(before) || s.End.After(returned) {
+		t.Errorf("span ended at %v; want between %v and %v, when the handler returned", s.End, before, returned)
+	}
+	if _, ok := s.Labels["trace/handler_ms"]; ok {
+		t.Errorf("span is labeled with the handler time, but the handler didn't finish it")
+	}
+
+	span := <-late
+	if got := span.Snapshot("trace/late_finish_attempted").Labels["trace/late_finish_attempted"]; got != "true" {
+		t.Errorf("trace/late_finish_attempted = %q; want true", got)
+	}
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Error("the late Finish was not reported")
+	}
+	select {
+	case <-e.exported:
+		t.Error("the late Finish uploaded the span again")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEnforcedFinishEarly(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+
+	var finished time.Time
+	callEnforced(tc, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span := FromContext(ctx)
+		span.Finish()
+		finished = time.Now()
+		span.Finish()
+		time.Sleep(20 * time.Millisecond)
+		return nil, nil
+	})
+
+	select {
+	case <-e.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the span to be exported")
+	}
+	if len(e.spans) != 1 {
+		t.Fatalf("exported %d spans; want 1", len(e.spans))
+	}
+	s := e.spans[0]
+	if s.End.After(finished) {
+		t.Errorf("span ended at %v; want it to end at the handler's first Finish, before %v", s.End, finished)
+	}
+	if got := s.Labels["trace/handler_ms"]; got == "" || got == "0" {
+		t.Errorf("trace/handler_ms = %q; want the handler's wall time", got)
+	}
+	if _, ok := s.Labels["trace/late_finish_attempted"]; ok {
+		t.Errorf("span finished during the call is labeled as a late finish")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"time"
+)
+
+// An Exporter uploads finished spans to a tracing backend.
+//
+// A Client created by NewClient uses an Exporter for the Google Stackdriver
+// Trace API.  Use NewClientWithExporter to send spans elsewhere.
+type Exporter interface {
+	// ExportSpans uploads a batch of finished spans.  The batch may contain
+	// spans from several traces; the spans of each trace are together, and
+	// sorted by start time and then by span ID, so the order doesn't depend
+	// on the scheduling of the goroutines that finished them.  The root span
+	// of a trace usually comes first.
+	//
+	// ExportSpans may be called concurrently from multiple goroutines.
+	ExportSpans(ctx context.Context, spans []*SpanData) error
+}
+
+// SpanKind describes the relationship between a span and its remote
+// counterpart, if any.
+//
+// The client span of a call made through the gRPC interceptors and the
+// server span of its callee are siblings rather than parent and child: the
+// trace header sent with the call carries the ID of the span in the call's
+// context, as it always has, and as returned by Span.Header, not the ID of
+// the client span, which is created by the interceptor.  The two spans are
+// paired by their trace ID, their parent span ID, and their "grpc/service"
+// and "grpc/method" labels.  NewRemoteChild, in contrast, sends the ID of
+// the client span it returns, which is then the parent of the server span.
+type SpanKind int
+
+const (
+	// SpanKindUnspecified is the kind of spans that don't represent either
+	// side of a remote call.
+	SpanKindUnspecified SpanKind = iota
+
+	// SpanKindClient is the kind of spans that represent an outgoing request.
+	SpanKindClient
+
+	// SpanKindServer is the kind of spans that represent the handling of an
+	// incoming request.
+	SpanKindServer
+)
+
+// SpanData is the exported form of a finished span.
+type SpanData struct {
+	TraceID      string // hex-encoded trace ID.
+	ProjectID    string // project that owns the trace; empty for the exporter's own project.
+	SpanID       uint64
+	ParentSpanID uint64 // zero if the span has no parent.
+	Name         string
+	Kind         SpanKind
+	Start        time.Time
+	End          time.Time
+	Labels       map[string]string // owned by the Exporter.
+	Annotations  []Annotation      // oldest first.
+
+	// DroppedAnnotations is the number of annotations that were evicted
+	// from a span created with LongLived to make room for newer ones.
+	DroppedAnnotations int
+
+	// MessageEvents holds the messages sent and received on the stream of
+	// the span, if it was traced by an interceptor created with
+	// WithMessageEvents, in the order they were recorded.
+	MessageEvents []MessageEvent
+
+	// DroppedMessageEvents is the number of message events that didn't fit
+	// the per-span limit.
+	DroppedMessageEvents int
+
+	// Links holds the spans of other traces that the span follows, such as
+	// those returned by servers under WithResponseTraceLinks.
+	Links []Link
+
+	// NameTruncatedBytes is the number of bytes that were removed from the
+	// end of Name to fit the limits of the trace API, or zero.
+	NameTruncatedBytes int
+
+	// LabelTruncatedBytes maps the keys of labels whose values were
+	// truncated to fit the limits of the trace API, or those set with
+	// SetLabelLimits, to the number of bytes removed from the end of each
+	// value, which were replaced by "...(truncated)".  It is nil if no
+	// value was truncated.
+	LabelTruncatedBytes map[string]int
+}
+
+func (k SpanKind) apiKind() string {
+	switch k {
+	case SpanKindClient:
+		return spanKindClient
+	case SpanKindServer:
+		return spanKindServer
+	}
+	return spanKindUnspecified
+}
+
+func kindFromAPI(kind string) SpanKind {
+	switch kind {
+	case spanKindClient:
+		return SpanKindClient
+	case spanKindServer:
+		return SpanKindServer
+	}
+	return SpanKindUnspecified
+}
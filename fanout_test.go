@@ -0,0 +1,94 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestConcurrentNewChild creates the children of one span from many
+// goroutines at once, for the race detector, and checks that their IDs are
+// distinct and that they inherit labels.
+func TestConcurrentNewChild(t *testing.T) {
+	goroutines, children := 1000, 100
+	if testing.Short() {
+		goroutines = 100
+	}
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	root.SetInheritedLabel("tenant", "a")
+	ids := make([][]uint64, goroutines)
+	var wg sync.WaitGroup
+	// Change an inherited label while the children are created.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < children; j++ {
+			root.SetInheritedLabel("tenant", "b")
+		}
+	}()
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < children; j++ {
+				child := root.NewChild("/child")
+				if child == root || child.span.ParentSpanId != root.span.SpanId || !child.tracing() {
+					t.Errorf("child %v of %v: parent %d, traced %t", child, root, child.span.ParentSpanId, child.tracing())
+					return
+				}
+				if v, ok := spanLabel(child, "tenant"); !ok || v != "a" && v != "b" {
+					t.Errorf("child tenant label %q; want a or b", v)
+					return
+				}
+				ids[i] = append(ids[i], child.span.SpanId)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool, goroutines*children)
+	for _, ids := range ids {
+		for _, id := range ids {
+			if id == 0 || seen[id] {
+				t.Fatalf("span ID %d is zero or used twice", id)
+			}
+			seen[id] = true
+		}
+	}
+	if got, want := tc.Stats().SpansStarted, int64(goroutines*children+1); got != want {
+		t.Errorf("SpansStarted = %d; want %d", got, want)
+	}
+}
+
+func benchmarkNewChildContended(b *testing.B, inherited bool) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	if inherited {
+		root.SetInheritedLabel("tenant", "a")
+	}
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			root.NewChild("/child")
+		}
+	})
+}
+
+func BenchmarkNewChildContended(b *testing.B) { benchmarkNewChildContended(b, false) }
+
+func BenchmarkNewChildContendedInherited(b *testing.B) { benchmarkNewChildContended(b, true) }
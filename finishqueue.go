@@ -0,0 +1,62 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// A finishQueue holds the finished spans of a trace.  It is a lock-free
+// queue with many producers, the goroutines finishing spans, and one
+// consumer, the root span, which drains it when it finishes.  Finish pushes
+// onto it without taking the trace's mutex, so that concurrent children of
+// one trace don't contend with each other.
+//
+// The zero finishQueue is empty and ready to use.
+type finishQueue struct {
+	head unsafe.Pointer // *finishedSpan; the most recently pushed span, or nil.
+}
+
+type finishedSpan struct {
+	span *Span
+	next *finishedSpan
+}
+
+// push adds s to q.
+func (q *finishQueue) push(s *Span) {
+	n := &finishedSpan{span: s}
+	for {
+		old := atomic.LoadPointer(&q.head)
+		n.next = (*finishedSpan)(old)
+		if atomic.CompareAndSwapPointer(&q.head, old, unsafe.Pointer(n)) {
+			return
+		}
+	}
+}
+
+// drain removes all the spans from q, and returns them in the order they
+// were pushed.  Every push that returned before drain was called is
+// included.
+func (q *finishQueue) drain() []*Span {
+	var spans []*Span
+	for n := (*finishedSpan)(atomic.SwapPointer(&q.head, nil)); n != nil; n = n.next {
+		spans = append(spans, n.span)
+	}
+	for i, j := 0, len(spans)-1; i < j; i, j = i+1, j-1 {
+		spans[i], spans[j] = spans[j], spans[i]
+	}
+	return spans
+}
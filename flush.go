@@ -0,0 +1,135 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned by FinishWait for the root spans finished after the
+// client was closed, whose traces are not uploaded.
+var ErrClosed = errors.New("trace: client is closed")
+
+// Flush uploads the traces that are waiting to be bundled, including those
+// of root spans finished by concurrent calls of Finish, and waits until they
+// have been exported or until ctx is done, in which case it returns
+// ctx.Err() and the upload continues in the background.  Traces finished
+// after Flush is called may or may not be uploaded by it.  Call Flush
+// before a process exits, to keep the traces finished just before:
+//
+//   defer traceClient.Flush(ctx)
+func (c *Client) Flush(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	select {
+	case <-c.uploads.idle():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	done := make(chan struct{})
+	go func() {
+		c.bundler.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeUploads makes the client reject the traces of the root spans
+// finished from now on, and waits until the traces already being handed to
+// the bundler have been.  It reports whether it was the first call.
+func (c *Client) closeUploads() bool {
+	if !c.uploads.close() {
+		return false
+	}
+	<-c.uploads.idle()
+	return true
+}
+
+// uploads counts the traces that are being handed to the client's bundler
+// by the goroutines started by Finish, so that Flush and Close can wait for
+// them.
+type uploads struct {
+	mu      sync.Mutex
+	closed  bool
+	pending int
+	done    chan struct{} // closed when pending is zero; nil if nobody waits.
+}
+
+// begin records that a trace is being handed to the bundler.  It returns
+// false, without recording it, if the client has been closed.
+func (u *uploads) begin() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return false
+	}
+	u.pending++
+	return true
+}
+
+// end records that a trace recorded by begin has been handed to the
+// bundler.
+func (u *uploads) end() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.pending--
+	if u.pending == 0 && u.done != nil {
+		close(u.done)
+		u.done = nil
+	}
+}
+
+// idle returns a channel that is closed once no traces are being handed to
+// the bundler.
+func (u *uploads) idle() <-chan struct{} {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.pending == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if u.done == nil {
+		u.done = make(chan struct{})
+	}
+	return u.done
+}
+
+// isClosed reports whether close has been called.
+func (u *uploads) isClosed() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.closed
+}
+
+// close makes begin fail from now on.  It reports whether it was the first
+// call.
+func (u *uploads) close() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return false
+	}
+	u.closed = true
+	return true
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// exportedCount returns the number of spans e has exported.
+func (e *recordingExporter) exportedCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.spans)
+}
+
+func TestFlush(t *testing.T) {
+	const (
+		goroutines = 8
+		perRoutine = 50
+	)
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.bundler.DelayThreshold = time.Hour
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perRoutine; j++ {
+				tc.NewSpan("/flush").Finish()
+				if j%10 == 0 {
+					if err := tc.Flush(context.Background()); err != nil {
+						t.Errorf("Flush = %v; want nil", err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush = %v; want nil", err)
+	}
+	if got, want := e.exportedCount(), goroutines*perRoutine; got != want {
+		t.Errorf("exported %d spans; want %d", got, want)
+	}
+}
+
+func TestFlushContextDone(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	tc := NewClientWithExporter(hangingExporter{release})
+	tc.bundler.DelayThreshold = time.Hour
+	tc.NewSpan("/hang").Finish()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tc.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Flush = %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCloseRejectsUploads(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.bundler.DelayThreshold = time.Hour
+	tc.NewSpan("/before").Finish()
+	if err := tc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if e.span("/before") == nil {
+		t.Error("span finished before Close was not exported")
+	}
+
+	if err := tc.NewSpan("/wait").FinishWait(); err != ErrClosed {
+		t.Errorf("FinishWait after Close = %v; want %v", err, ErrClosed)
+	}
+	tc.NewSpan("/after").Finish()
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Errorf("Flush after Close = %v; want nil", err)
+	}
+	if got := e.exportedCount(); got != 1 {
+		t.Errorf("exported %d spans; want only the one finished before Close", got)
+	}
+	if got := tc.Stats().RejectedTraces; got != 2 {
+		t.Errorf("RejectedTraces = %d; want 2", got)
+	}
+	if err := tc.Close(); err != nil {
+		t.Errorf("second Close = %v; want nil", err)
+	}
+}
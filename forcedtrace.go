@@ -0,0 +1,88 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "sync/atomic"
+
+// WithForcedTrace returns a SpanOption that traces a new root span created
+// by Client.NewSpanWithOptions whatever the client's sampling policy
+// decides, as the o=1 option of an incoming trace header does, and makes
+// the outgoing requests of the trace carry the o=1 option, so that the
+// services they call trace them too.  This is useful for targeted debugging.
+// The span isn't traced if the client is shutting down.  The option has no
+// effect on child spans, which are traced if their parents are.
+func WithForcedTrace() SpanOption {
+	return spanOption(func(c *spanConfig) {
+		c.forceTrace = true
+	})
+}
+
+// Traced reports whether s is traced: whether it will be uploaded when it
+// finishes, and its outgoing requests carry the option that asks the
+// services they call to trace them.  It returns false if s is nil.
+func (s *Span) Traced() bool {
+	return s.traced()
+}
+
+// ForceTrace makes s traced, if it is a local root span that isn't, such as
+// a span created by SpanFromRequest or a server interceptor for a request
+// the sampling policy didn't choose.  Call it when a request turns out to
+// be interesting while it is handled, for example because it failed or was
+// slow.  s is then uploaded when it finishes, with the sampling policy label
+// "forced", and the children created after the call are traced too, and
+// propagate the option that asks the services they call to trace them.
+//
+// The labels, annotations and children of s made before the call weren't
+// recorded, and are missing from the trace, unless s was recorded for its
+// slow spans, as SetTraceSlowSpans does.  The services that handled the
+// request before it reached this one, and those it has called, may already
+// have discarded their parts of the trace, so the trace may be incomplete.
+//
+// ForceTrace has no effect, and returns s.Traced(), if s is nil, is already
+// traced, isn't a local root span, or has finished, or if the client is
+// shutting down; otherwise it returns true.  It must be called before
+// Finish: a span that finishes while ForceTrace is called isn't uploaded.
+func (s *Span) ForceTrace() bool {
+	if s != nil && s.rootSpan && atomic.LoadInt32(&s.finished) == 0 && atomic.CompareAndSwapInt32(&s.trace.slowOnly, 1, 0) {
+		// s is recorded for its slow spans; now all of them are uploaded.
+		atomic.StoreUint32((*uint32)(&s.options.global), uint32(s.loadOptions().global|optionTrace))
+		s.setLabel(labelSamplingPolicy, "forced")
+		return true
+	}
+	if s == nil || s.tracing() || !s.rootSpan || atomic.LoadInt32(&s.finished) != 0 {
+		return s.traced()
+	}
+	c := s.trace.client
+	if !c.drain.add() {
+		return false
+	}
+	atomic.StoreInt32(&s.inFlight, 1)
+	atomic.StoreUint32((*uint32)(&s.options.global), uint32(s.loadOptions().global|optionTrace))
+	local := s.loadOptions().local
+	if local&optionTrace != 0 || !atomic.CompareAndSwapUint32((*uint32)(&s.options.local), uint32(local), uint32(local|optionTrace)) {
+		// A concurrent call made s traced, and counted it as in flight.
+		c.drain.done()
+		return true
+	}
+	atomic.AddInt64(&c.stats.spansSampledOut, -1)
+	c.stats.spansStarted.add(s.span.SpanId, 1)
+	s.setLabel(labelSamplingPolicy, "forced")
+	if atomic.LoadInt32(&s.finished) != 0 {
+		// s finished concurrently, untraced; see finishUntraced.
+		c.endRoot(s)
+		return false
+	}
+	return true
+}
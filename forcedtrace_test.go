@@ -0,0 +1,190 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestForcedTraceHeader(t *testing.T) {
+	const (
+		forced   = "0123456789abcdef0123456789abcdef/1;o=1"
+		unforced = "0123456789abcdef0123456789abcdef/1;o=0"
+	)
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(neverTrace{})
+
+	for _, tt := range []struct {
+		header string
+		want   bool
+	}{
+		{forced, true},
+		{unforced, false},
+		{"00-0123456789abcdef0123456789abcdef-0000000000000001-01", true},
+		{"", false},
+	} {
+		span := tc.SpanFromHeader("/server", tt.header)
+		if got := span.Traced(); got != tt.want {
+			t.Errorf("SpanFromHeader(%q): Traced() = %t; want %t", tt.header, got, tt.want)
+		}
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set(httpHeader, tt.header)
+		if got := tc.SpanFromRequest(req).Traced(); got != tt.want {
+			t.Errorf("SpanFromRequest with header %q: Traced() = %t; want %t", tt.header, got, tt.want)
+		}
+		if tt.want {
+			// The forced bit propagates to outgoing requests.
+			child := span.NewChild("/child")
+			if h := child.Header(); !strings.HasSuffix(h, ";o=1") {
+				t.Errorf("header %q: child header %q; want o=1", tt.header, h)
+			}
+		}
+	}
+
+	var got []bool
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		got = append(got, FromContext(ctx).Traced())
+		return nil, nil
+	}
+	for _, h := range []string{forced, unforced} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(grpcMetadataKey, h))
+		GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, handler)
+	}
+	if len(got) != 2 || !got[0] || got[1] {
+		t.Errorf("server interceptor traced %v; want [true false]", got)
+	}
+
+	p, err := NewLimitedSampler(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.SetSamplingPolicy(p)
+	spans := tc.SpansFromHeaders("/consume", []string{forced, unforced})
+	if !spans[0].Traced() || spans[1].Traced() {
+		t.Errorf("SpansFromHeaders: traced %t, %t; want true, false", spans[0].Traced(), spans[1].Traced())
+	}
+}
+
+func TestWithForcedTrace(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(neverTrace{})
+	if tc.NewSpan("/root").Traced() {
+		t.Error("NewSpan traced; want the policy to decide not to")
+	}
+	root := tc.NewSpanWithOptions("/root", WithForcedTrace())
+	if !root.Traced() {
+		t.Fatal("NewSpanWithOptions(WithForcedTrace()) not traced")
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	root.NewRemoteChild(req)
+	if h := req.Header.Get(httpHeader); !strings.HasSuffix(h, ";o=1") {
+		t.Errorf("outgoing header %q; want o=1", h)
+	}
+
+	tc.drain.start()
+	if tc.NewSpanWithOptions("/root", WithForcedTrace()).Traced() {
+		t.Error("forced span traced while shutting down")
+	}
+	if (*Span)(nil).Traced() {
+		t.Error("nil span Traced() = true")
+	}
+}
+
+func TestForceTrace(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetSamplingPolicy(neverTrace{})
+
+	root := tc.SpanFromHeader("/server", "")
+	if root.Traced() {
+		t.Fatal("root span is traced before ForceTrace")
+	}
+	if !root.ForceTrace() || !root.Traced() {
+		t.Fatal("ForceTrace did not trace the root span")
+	}
+	if !root.ForceTrace() {
+		t.Error("second ForceTrace returned false")
+	}
+	after := root.NewChild("after")
+	if !after.Traced() || !strings.HasSuffix(after.Header(), ";o=1") {
+		t.Errorf("child created after ForceTrace: Traced() = %t, header %q; want traced with o=1", after.Traced(), after.Header())
+	}
+	if got := tc.Stats().SpansInFlight; got != 1 {
+		t.Errorf("SpansInFlight = %d; want 1", got)
+	}
+	after.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	s := e.span("/server")
+	if s == nil || e.span("after") == nil || len(e.spans) != 2 {
+		t.Fatalf("exported spans %v; want /server and after", names(e.spans))
+	}
+	if got := s.Labels[labelSamplingPolicy]; got != "forced" {
+		t.Errorf("sampling policy label = %q; want %q", got, "forced")
+	}
+	if got := tc.Stats().SpansInFlight; got != 0 {
+		t.Errorf("SpansInFlight after Finish = %d; want 0", got)
+	}
+
+	// Spans that have finished, and children, aren't forced.
+	finished := tc.SpanFromHeader("/finished", "")
+	finished.Finish()
+	if finished.ForceTrace() {
+		t.Error("ForceTrace of a finished span returned true")
+	}
+	traced := tc.NewSpan("/traced")
+	if child := traced.NewChild("child"); !child.ForceTrace() {
+		t.Error("ForceTrace of a traced child returned false")
+	}
+	var nilSpan *Span
+	if nilSpan.ForceTrace() {
+		t.Error("ForceTrace of a nil span returned true")
+	}
+	if got := tc.Stats().SpansInFlight; got != 1 {
+		t.Errorf("SpansInFlight = %d; want 1, for /traced", got)
+	}
+}
+
+func TestForceTraceConcurrentFinish(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(neverTrace{})
+	for i := 0; i < 100; i++ {
+		root := tc.SpanFromHeader("/server", "")
+		done := make(chan struct{})
+		for j := 0; j < 2; j++ {
+			go func() {
+				root.ForceTrace()
+				root.NewChild("child").Finish()
+				done <- struct{}{}
+			}()
+		}
+		root.Finish()
+		<-done
+		<-done
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if st, err := tc.Shutdown(ctx); err != nil || st.Abandoned != 0 {
+		t.Errorf("Shutdown = %+v, %v; want no abandoned spans", st, err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type withForwardedMetadata []string
+
+// WithForwardedMetadata returns an InterceptorOption that makes the server
+// interceptors save the values of the given incoming metadata keys in the
+// context of each call.  The client interceptors, and OutgoingContext, add
+// the saved values to the outgoing metadata of calls made with that context,
+// so that they are passed on to downstream services along with the trace
+// header:
+//
+//   s := grpc.NewServer(trace.GRPCServerOptions(tc, trace.WithForwardedMetadata("locale"))...)
+//
+// Keys are case-insensitive.  Only the given keys are forwarded; the trace
+// header, and reserved keys starting with "grpc-" or ":", are never
+// forwarded.  If the outgoing metadata of a call already has a value for a
+// key, that value is sent instead of the forwarded one.
+func WithForwardedMetadata(keys ...string) InterceptorOption {
+	return withForwardedMetadata(keys)
+}
+
+func (o withForwardedMetadata) configureInterceptor(c *interceptorConfig) {
+	for _, k := range o {
+		k = strings.ToLower(k)
+		if k == grpcMetadataKey || strings.HasPrefix(k, "grpc-") || strings.HasPrefix(k, ":") {
+			continue
+		}
+		c.forwardedKeys = append(c.forwardedKeys, k)
+	}
+}
+
+type forwardedMetadataKey struct{}
+
+// withIncomingForwarded returns ctx with the values of keys in the incoming
+// metadata md saved for forwarding.  Values saved by an earlier call are
+// replaced.
+func withIncomingForwarded(ctx context.Context, md metadata.MD, keys []string) context.Context {
+	var fwd metadata.MD
+	for _, k := range keys {
+		if v, ok := md[k]; ok {
+			if fwd == nil {
+				fwd = metadata.MD{}
+			}
+			fwd[k] = append([]string(nil), v...)
+		}
+	}
+	return context.WithValue(ctx, forwardedMetadataKey{}, fwd)
+}
+
+// withOutgoingForwarded returns ctx with the metadata saved by
+// withIncomingForwarded added to its outgoing metadata.
+func withOutgoingForwarded(ctx context.Context) context.Context {
+	fwd, _ := ctx.Value(forwardedMetadataKey{}).(metadata.MD)
+	if len(fwd) == 0 {
+		return ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy() // metadata is immutable, copy.
+	for k, v := range fwd {
+		if _, ok := md[k]; !ok {
+			md[k] = v
+		}
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// contextServerStream is a grpc.ServerStream with a different context.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// relay is a server for relayDesc.  It records the incoming metadata of
+// each call, and if next is set, calls next with the call's context, after
+// applying modify to it.
+type relay struct {
+	md     chan metadata.MD
+	next   *grpc.ClientConn
+	modify func(context.Context) context.Context
+}
+
+func (r *relay) call(ctx context.Context) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.md <- md
+	if r.next != nil {
+		if r.modify != nil {
+			ctx = r.modify(ctx)
+		}
+		if err := r.next.Invoke(ctx, "/test.Relay/Call", &empty.Empty{}, &empty.Empty{}); err != nil {
+			return nil, err
+		}
+	}
+	return &empty.Empty{}, nil
+}
+
+// relayDesc describes a unary method handled by a *relay.
+var relayDesc = grpc.ServiceDesc{
+	ServiceName: "test.Relay",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Call",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			if err := dec(&empty.Empty{}); err != nil {
+				return nil, err
+			}
+			h := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(*relay).call(ctx)
+			}
+			if interceptor == nil {
+				return h(ctx, nil)
+			}
+			return interceptor(ctx, nil, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Relay/Call"}, h)
+		},
+	}},
+}
+
+func startRelay(r *relay, opts ...grpc.ServerOption) (*bufconn.Listener, func()) {
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&relayDesc, r)
+	lis := bufconn.Listen(1 << 16)
+	go srv.Serve(lis)
+	return lis, srv.Stop
+}
+
+func TestForwardedMetadata(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+
+	// The call chain is client -> front -> back.
+	back := &relay{md: make(chan metadata.MD, 1)}
+	backLis, stop := startRelay(back, GRPCServerOptions(tc)...)
+	defer stop()
+	backConn := dialEcho(t, backLis, EnableGRPCTracingAll...)
+	defer backConn.Close()
+
+	front := &relay{
+		md:   make(chan metadata.MD, 1),
+		next: backConn,
+		modify: func(ctx context.Context) context.Context {
+			// The front server overrides the forwarded locale.
+			return metadata.AppendToOutgoingContext(ctx, "locale", "de")
+		},
+	}
+	frontLis, stop := startRelay(front, GRPCServerOptions(tc, WithForwardedMetadata("Locale", "x-subject", grpcMetadataKey))...)
+	defer stop()
+	frontConn := dialEcho(t, frontLis, EnableGRPCTracingAll...)
+	defer frontConn.Close()
+
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	ctx = metadata.AppendToOutgoingContext(ctx, "locale", "fr", "x-subject", "abc", "x-secret", "s")
+	if err := frontConn.Invoke(ctx, "/test.Relay/Call", &empty.Empty{}, &empty.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	root.Finish()
+	frontMD := <-front.md
+	backMD := <-back.md
+
+	for _, tt := range []struct {
+		key  string
+		want []string
+	}{
+		{"x-subject", []string{"abc"}},
+		{"locale", []string{"de"}},
+		{"x-secret", nil},
+	} {
+		if got := backMD[tt.key]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("back server got %s = %q; want %q", tt.key, got, tt.want)
+		}
+	}
+	if got := backMD[grpcMetadataKey]; len(got) != 1 {
+		t.Errorf("back server got trace headers %q; want one", got)
+	} else if got[0] == frontMD[grpcMetadataKey][0] {
+		t.Errorf("back server got the front server's trace header %q; want the header of the front server's call", got[0])
+	}
+}
+
+func TestForwardedMetadataStream(t *testing.T) {
+	var got metadata.MD
+	interceptor := GRPCStreamServerInterceptor(nil, WithForwardedMetadata("locale"))
+	ss := &fakeServerStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("locale", "fr", "other", "x"))}
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		got, _ = metadata.FromOutgoingContext(OutgoingContext(ss.Context()))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := metadata.Pairs("locale", "fr"); !reflect.DeepEqual(got, want) {
+		t.Errorf("outgoing metadata = %v; want %v", got, want)
+	}
+}
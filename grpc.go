@@ -15,80 +15,461 @@
 package trace
 
 import (
-	"strings"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
 
-	"golang.org/x/net/context"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
-	"log"
 )
 
 const grpcMetadataKey = "x-cloud-trace-context"
 
+// An InterceptorOption configures the gRPC interceptors returned by this
+// package, and the HTTP clients returned by Client.NewHTTPClient.
+type InterceptorOption interface {
+	configureInterceptor(c *interceptorConfig)
+}
+
+type interceptorConfig struct {
+	perMessageSpans bool
+	tlsLabels       bool
+	forwardedKeys   []string
+	methodStats     bool
+	idleTimeout     time.Duration
+	idleCancel      bool
+
+	metadataSize      bool
+	metadataThreshold int
+
+	projectHintKey string
+	labelCap       int
+
+	sendTimeKey   string // for WithClockSkewDetection, or "".
+	skewTolerance time.Duration
+
+	dependencyNamer     func(*http.Request) string // for WithDependencyNamer, or nil.
+	dependencySpanNames bool
+
+	callOptionLabels callOptionMask
+	enforcedFinish   bool
+	alwaysTrace      bool
+	propagators      []Propagator // nil for GooglePropagator.
+	messageEvents    bool
+	lazyStreamSpan   bool
+	streamBytes      bool
+
+	sendStallThreshold time.Duration // for WithSendStallDetection, or 0.
+
+	messageSpanInterval int // for WithMessageSpans, or 0.
+
+	syncFinish        bool
+	syncFinishTimeout time.Duration
+
+	responseHeader     bool
+	responseHeaderName string // "" for the trace context header.
+
+	ignoredMethods  map[string]bool // for IgnoreMethods.
+	ignoredPatterns []string
+	methodFilter    func(method string) bool
+	spanHook        func(span *Span, method string)
+
+	dbPoolLabels bool
+	observeOnly  bool
+
+	propagationKeys []string // for WithPropagationKey; the first is sent.
+
+	rootClient *Client // for WithRootSpans, or nil.
+
+	backendMetrics    bool
+	maxBackendMetrics int
+
+	responseLinkKey string // for WithResponseTraceLinks, or "".
+}
+
+func newInterceptorConfig(opts []InterceptorOption) *interceptorConfig {
+	c := &interceptorConfig{}
+	for _, o := range opts {
+		o.configureInterceptor(c)
+	}
+	return c
+}
+
+type withPerMessageSpans struct{}
+
+// WithPerMessageSpans returns an InterceptorOption that makes the stream
+// server interceptor create a child span of the stream's span, named
+// "<method>/msg", for each message received on the stream.  The span is
+// available from MessageContext after RecvMsg returns, and finishes when
+// RecvMsg is next called or the stream ends.
+//
+// Use it when handlers do work for each message, so that the work is nested
+// under the span of the message it belongs to instead of the stream's span.
+// See MessageContext for the lifetime of the contexts of the messages.
+func WithPerMessageSpans() InterceptorOption {
+	return withPerMessageSpans{}
+}
+
+func (withPerMessageSpans) configureInterceptor(c *interceptorConfig) {
+	c.perMessageSpans = true
+}
+
 // GRPCClientInterceptor returns a grpc.UnaryClientInterceptor that traces all outgoing requests from a gRPC client.
 // The calling context should already have a *trace.Span; a child span will be
 // created for the outgoing gRPC call. If the calling context doesn't have a span,
-// the call will not be traced.
+// the call will not be traced, unless WithRootSpans is given.
+//
+// A context can be used for any number of calls, for example when it is
+// built once and reused for several calls on a pooled grpc.ClientConn.  Each
+// call gets its own child of the span in the context.  Labels added with
+// LabelNextCall apply only to the first of the calls.
+//
+// The span of every call, like the spans of the other interceptors, is
+// labeled with the call's gRPC status code, even if it is OK, as
+// "grpc/status_code"; with the service and method of the call, as
+// "grpc/service" and "grpc/method"; with the peer, as "grpc/peer", which
+// is the target of the connection on the client side and the address of
+// the caller on the server side; and with the types of the request and
+// response messages, as "grpc/request_type" and "grpc/response_type".  The
+// status of an error that wraps a gRPC status error is the wrapped status.
+//
+// If the calling context has a deadline, the span is annotated with it, and
+// labeled with the time left until the deadline when the call started, in
+// milliseconds, as "grpc/deadline_ms", and with the fraction of that time
+// that the call used, as "grpc/deadline_used_fraction".  Calls that used
+// more than 90% of it are also labeled "grpc/deadline_near_miss", even if
+// they succeeded.  The server interceptors label the spans of incoming
+// calls that have a deadline with "grpc/deadline_ms" too.
+//
+// The span of a failed call is labeled with the source of the failure, as
+// "grpc/error_source": "context" if the calling context was cancelled or
+// its deadline expired, in which case the span is also labeled
+// "grpc/context_done" with "cancelled" or "deadline_exceeded", or "status"
+// if the call failed on its own, with a status returned by the server or
+// the transport.
+//
+// Of the InterceptorOptions, only WithCallOptionLabels, IgnoreMethods,
+// WithMethodFilter, WithSpanHook, WithObserveOnly, WithRootSpans,
+// WithBackendMetrics and, for streams, WithMessageEvents and
+// WithLazyStreamSpan affect the client interceptors.
 //
 // The functionality in gRPC that this feature relies on is currently experimental.
-func GRPCClientInterceptor() grpc.UnaryClientInterceptor {
-	return grpc.UnaryClientInterceptor(grpcUnaryInterceptor)
+func GRPCClientInterceptor(opts ...InterceptorOption) grpc.UnaryClientInterceptor {
+	return grpc.UnaryClientInterceptor(newInterceptorConfig(opts).unaryClient)
 }
 
-func grpcUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-	// TODO: also intercept streams.
-	span := FromContext(ctx).NewChild(method)
-	defer span.Finish()
+func (config *interceptorConfig) unaryClient(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+	if !config.observeOnly {
+		ctx = withOutgoingForwarded(ctx)
+	}
+	if config.ignored(method) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	span := config.clientSpan(ctx, method)
+	defer func() { span.FinishWithError(err) }()
+	budget := startDeadlineBudget(ctx, span)
+	defer budget.finish(span)
+	setAuthorityLabel(span, cc)
+	setClientPeerLabel(span, cc)
+	setMethodLabels(span, method)
+	setMessageTypeLabel(span, labelGRPCRequestType, req)
+	setMessageTypeLabel(span, labelGRPCResponseType, reply)
+	applyCallLabels(ctx, span)
+	if config.callOptionLabels != 0 {
+		setCallOptionLabels(span, opts, config.callOptionLabels)
+	}
+	config.runSpanHook(span, method)
 
-	if span != nil {
-		header := spanHeader(span.trace.traceID, span.span.ParentSpanId, span.trace.globalOptions)
-		md, ok := metadata.FromOutgoingContext(ctx)
-		if !ok {
-			md = metadata.Pairs(grpcMetadataKey, header)
-		} else {
-			md = md.Copy() // metadata is immutable, copy.
-			md[grpcMetadataKey] = []string{header}
+	if span != nil && !config.observeOnly {
+		ctx = config.withSendTime(config.outgoingContext(ctx, span))
+		opts = removeTraceCallOptions(opts)
+	}
+	var header, trailer *metadata.MD // allocated only for WithBackendMetrics and WithResponseTraceLinks.
+	if config.backendMetrics && span.traced() {
+		trailer = new(metadata.MD)
+		opts = append(opts[:len(opts):len(opts)], grpc.Trailer(trailer))
+	}
+	if config.responseLinkKey != "" && span.traced() {
+		if trailer == nil {
+			trailer = new(metadata.MD)
+			opts = append(opts[:len(opts):len(opts)], grpc.Trailer(trailer))
 		}
-		ctx = metadata.NewOutgoingContext(ctx, md)
+		header = new(metadata.MD)
+		opts = append(opts, grpc.Header(header))
 	}
 
-	err := invoker(ctx, method, req, reply, cc, opts...)
+	err = invoker(ctx, method, req, reply, cc, opts...)
+	if trailer != nil && config.backendMetrics {
+		setBackendMetricLabels(span, *trailer, config.maxBackendMetrics)
+	}
+	if header != nil {
+		linkResponseMetadata(span, config.responseLinkKey, *header, *trailer)
+	}
+	setOKLabel(span, err)
 	if err != nil {
-		// TODO: standardize gRPC label names?
-		span.SetLabel("error", err.Error())
+		setCancelCause(span, ctx)
+		setErrorSource(span, ctx, err)
 	}
 	return err
 }
 
+// withPropagatedHeader returns ctx with its outgoing gRPC trace header set to
+// header, the header for span, or to the header that replaces it under
+// WithoutPropagation or WithDecoyPropagation.
+func withPropagatedHeader(ctx context.Context, span *Span, header string) context.Context {
+	if header = propagationOf(ctx).header(span, header); header == "" {
+		return withoutOutgoingHeader(ctx)
+	}
+	return withOutgoingHeader(ctx, header)
+}
+
+// withOutgoingHeader returns ctx with its outgoing gRPC trace header set to
+// header, replacing any existing header.
+func withOutgoingHeader(ctx context.Context, header string) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		md = metadata.Pairs(grpcMetadataKey, header)
+	} else {
+		md = md.Copy() // metadata is immutable, copy.
+		md[grpcMetadataKey] = []string{header}
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// OutgoingContext returns a context with the trace header of the span in ctx
+// added to its outgoing gRPC metadata.  Use it to propagate the trace to
+// calls made on connections that don't use the client interceptors:
+//
+//   resp, err := client.Method(trace.OutgoingContext(ctx), req)
+//
+// If ctx contains no span, no header is added.  The client interceptors
+// replace the header added by OutgoingContext with the header of the span
+// they create, so the header is never sent twice.
+//
+// Metadata saved by server interceptors created with WithForwardedMetadata
+// is also added.
+func OutgoingContext(ctx context.Context) context.Context {
+	ctx = withOutgoingForwarded(ctx)
+	span := FromContext(ctx)
+	if span == nil {
+		return ctx
+	}
+	return withPropagatedHeader(ctx, span, span.Header())
+}
+
+// GRPCCallOption returns a grpc.CallOption that sends the trace header of the
+// span in ctx with a single call made on a connection that doesn't use the
+// client interceptors:
+//
+//   resp, err := client.Method(ctx, req, trace.GRPCCallOption(ctx))
+//
+// The header is sent as per-RPC credentials, which are added to the call's
+// metadata by the transport.  The client interceptors remove the option, and
+// send the header of the span they create instead.
+func GRPCCallOption(ctx context.Context) grpc.CallOption {
+	var header string
+	if span := FromContext(ctx); span != nil {
+		header = propagationOf(ctx).header(span, span.Header())
+	}
+	return grpc.PerRPCCredentials(traceCredentials{header: header})
+}
+
+// traceCredentials are credentials.PerRPCCredentials that send a trace
+// header.
+type traceCredentials struct {
+	header string
+}
+
+func (c traceCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if c.header == "" {
+		return nil, nil
+	}
+	return map[string]string{grpcMetadataKey: c.header}, nil
+}
+
+func (traceCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// removeTraceCallOptions returns opts without the options returned by
+// GRPCCallOption.
+func removeTraceCallOptions(opts []grpc.CallOption) []grpc.CallOption {
+	for i, o := range opts {
+		if c, ok := o.(grpc.PerRPCCredsCallOption); ok {
+			if _, ok := c.Creds.(traceCredentials); ok {
+				rest := append([]grpc.CallOption(nil), opts[:i]...)
+				return append(rest, removeTraceCallOptions(opts[i+1:])...)
+			}
+		}
+	}
+	return opts
+}
+
 // GRPCServerInterceptor returns a grpc.UnaryServerInterceptor that enables the tracing of the incoming
 // gRPC calls. Incoming call's context can be used to extract the span on servers that enabled this option:
 //
 //	span := trace.FromContext(ctx)
 //
+// The span is labeled like the spans of GRPCClientInterceptor.
+//
+// If the handler panics, the span is labeled as a call that failed with
+// codes.Internal, with the panic value as "trace/panic" and the stack of
+// the panic as "trace/panic_stack", and finished, before the panic
+// continues, unchanged, to the recovery of the server.  The same goes for
+// GRPCStreamServerInterceptor.
+//
 // The functionality in gRPC that this feature relies on is currently experimental.
-func GRPCServerInterceptor(tc *Client) grpc.UnaryServerInterceptor {
+func GRPCServerInterceptor(tc *Client, opts ...InterceptorOption) grpc.UnaryServerInterceptor {
+	config := newInterceptorConfig(opts)
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 		md, _ := metadata.FromIncomingContext(ctx)
-		if header, ok := md[grpcMetadataKey]; ok {
-			span := tc.SpanFromHeader("", strings.Join(header, ""))
-			defer span.Finish()
-			ctx = NewContext(ctx, span)
+		if len(config.forwardedKeys) > 0 {
+			ctx = withIncomingForwarded(ctx, md, config.forwardedKeys)
+		}
+		if config.ignored(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		sc, ok, err := config.extract(tc, ctx, md)
+		if !ok && !config.alwaysTrace {
+			if config.methodStats {
+				tc.recordServerCall(info.FullMethod, headerMissing, false)
+			}
+			return handler(ctx, req)
+		}
+		var span *Span
+		if ok {
+			span = tc.spanFromContextErr("", sc, err)
+			tc.reportHeaderError(err)
+			if config.methodStats {
+				tc.recordServerCall(info.FullMethod, headerStateOf(err), span.traced())
+			}
+		} else {
+			span = tc.newServerRootSpan(info.FullMethod)
+			if config.methodStats {
+				tc.recordServerCall(info.FullMethod, headerMissing, span.traced())
+			}
+		}
+		setMethodLabels(span, info.FullMethod)
+		setServerPeerLabel(span, ctx)
+		setDeadlineLabel(span, ctx)
+		setMessageTypeLabel(span, labelGRPCRequestType, req)
+		if config.tlsLabels {
+			setPeerTLSLabels(ctx, span)
+		}
+		if config.metadataSize {
+			setMetadataSizeLabels(span, md, config.metadataThreshold)
 		}
-		return handler(ctx, req)
+		if config.projectHintKey != "" {
+			setProjectHint(tc, span, md, config.projectHintKey)
+		}
+		config.setSendTimeSkewLabel(span, md)
+		if config.labelCap > 0 {
+			setLabelCap(span, config.labelCap)
+		}
+		if config.enforcedFinish {
+			enforceFinish(span)
+		}
+		config.runSpanHook(span, info.FullMethod)
+		defer finishServerSpan(span, config.syncFinish, config.syncFinishTimeout)
+		defer tc.startDBPoolLabels(span, config).setLabels(span)
+		defer func() {
+			if v := recover(); v != nil {
+				labelServerPanic(span, v)
+				panic(v)
+			}
+		}()
+		resp, err = handler(NewContext(ctx, span), req)
+		setStatusLabels(span, err)
+		if err != nil {
+			setCancelCause(span, ctx)
+		} else {
+			setMessageTypeLabel(span, labelGRPCResponseType, resp)
+		}
+		return resp, err
 	}
 }
 
-// EnableGRPCTracing automatically traces all outgoing gRPC calls from cloud.google.com/go clients.
+// reportHeaderError reports err, an error parsing the trace header of an
+// incoming call, if it is non-nil and strict validation is enabled.
+func (c *Client) reportHeaderError(err error) {
+	if err != nil && c != nil && c.strict {
+		c.reportError(err)
+	}
+}
+
+// EnableGRPCTracing automatically traces all outgoing unary gRPC calls from cloud.google.com/go clients.
+// Streaming calls are not traced.
 //
 // The functionality in gRPC that this relies on is currently experimental.
 //
-// Deprecated: Use option.WithGRPCDialOption(grpc.WithUnaryInterceptor(GRPCClientInterceptor())) instead.
-var EnableGRPCTracing option.ClientOption = option.WithGRPCDialOption(grpc.WithUnaryInterceptor(GRPCClientInterceptor()))
+// Deprecated: Use EnableGRPCTracingAll, which also traces streaming calls.
+var EnableGRPCTracing option.ClientOption = option.WithGRPCDialOption(grpc.WithUnaryInterceptor(grpcDeprecatedUnaryInterceptor))
+
+var warnDeprecatedOnce sync.Once
+
+func grpcDeprecatedUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	warnDeprecatedOnce.Do(func() {
+		var c *Client
+		if span := FromContext(ctx); span != nil {
+			c = span.trace.client
+		}
+		c.noticef("trace: EnableGRPCTracing is deprecated and does not trace streaming calls; use EnableGRPCTracingAll instead")
+	})
+	return (&interceptorConfig{}).unaryClient(ctx, method, req, reply, cc, invoker, opts...)
+}
+
+// EnableGRPCTracingAll automatically traces all outgoing gRPC calls from
+// cloud.google.com/go clients, both unary and streaming:
+//
+//   client, err := pubsub.NewClient(ctx, projectID, trace.EnableGRPCTracingAll...)
+//
+// The interceptors are chained after any interceptors installed by other
+// options.
+//
+// The functionality in gRPC that this relies on is currently experimental.
+var EnableGRPCTracingAll = []option.ClientOption{
+	option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(GRPCClientInterceptor())),
+	option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(GRPCStreamClientInterceptor())),
+}
+
+// GRPCServerOptions returns the grpc.ServerOptions that trace all incoming
+// gRPC calls, both unary and streaming, using tc:
+//
+//   s := grpc.NewServer(trace.GRPCServerOptions(tc)...)
+//
+// The interceptors are chained after any interceptors installed by other
+// options.  opts configure the interceptors.
+func GRPCServerOptions(tc *Client, opts ...InterceptorOption) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(GRPCServerInterceptor(tc, opts...)),
+		grpc.ChainStreamInterceptor(GRPCStreamServerInterceptor(tc, opts...)),
+	}
+}
 
 type ClientStreamWrapper struct {
-	stream grpc.ClientStream
-	span   *Span
+	counts streamCounts // first, for the alignment of its 64-bit counters.
+
+	stream     grpc.ClientStream
+	span       *Span
+	budget     *deadlineBudget // nil if the stream has no deadline.
+	types      messageTypeLabels
+	finishOnce sync.Once
+	finished   chan struct{}   // closed when the span finishes, if watchContext is watching.
+	ctx        context.Context // the caller's context, for setErrorSource.
+
+	messageEvents bool
+	msgSpans      *messageSpans // for WithMessageSpans, or nil.
+
+	lazy      bool      // whether the span starts at the first message.
+	beginOnce sync.Once // for begin.
+
+	backendMetrics    bool // for WithBackendMetrics.
+	maxBackendMetrics int
+
+	responseLinkKey string // for WithResponseTraceLinks, or "".
 }
 
 func (s *ClientStreamWrapper) Header() (metadata.MD, error) {
@@ -100,9 +481,8 @@ func (s *ClientStreamWrapper) Trailer() metadata.MD {
 }
 
 func (s *ClientStreamWrapper) CloseSend() error {
-	if s.span != nil {
-		s.span.Finish()
-	}
+	s.begin()
+	s.finish(nil)
 	return s.stream.CloseSend()
 }
 
@@ -111,54 +491,158 @@ func (s *ClientStreamWrapper) Context() context.Context {
 }
 
 func (s *ClientStreamWrapper) SendMsg(m interface{}) error {
+	s.begin()
+	start := s.counts.startSend()
+	msgSpan := s.msgSpans.startSend(s.span)
 	err := s.stream.SendMsg(m)
-	if err != nil && s.span != nil {
-		s.span.Finish()
+	finishMessageSpan(msgSpan, err)
+	s.counts.sendDone(s.span, start)
+	if err != nil {
+		s.finish(err)
+	} else {
+		s.counts.sent(m)
+		s.types.send(s.span, labelGRPCRequestType, m)
+		if s.messageEvents {
+			s.span.addMessageEvent(MessageSent, m)
+		}
 	}
 	return err
 }
 
 func (s *ClientStreamWrapper) RecvMsg(m interface{}) error {
+	s.begin()
+	msgSpan := s.msgSpans.startRecv(s.span)
 	err := s.stream.RecvMsg(m)
-	if err != nil && s.span != nil {
-		s.span.Finish()
+	finishMessageSpan(msgSpan, err)
+	if err != nil {
+		s.finishStream(err, true)
+	} else {
+		s.counts.received(m)
+		s.types.receive(s.span, labelGRPCResponseType, m)
+		if s.messageEvents {
+			s.span.addMessageEvent(MessageReceived, m)
+		}
 	}
 	return err
 }
 
-func GRPCStreamClientInterceptor() grpc.StreamClientInterceptor {
-	return grpc.StreamClientInterceptor(grpcStreamClientInterceptor)
+// finish finishes the span of the stream, the first time it is called.  err
+// is the error that ended the stream; io.EOF, the normal end of a stream, is
+// not labeled as a failure.
+func (s *ClientStreamWrapper) finish(err error) {
+	s.finishStream(err, false)
+}
+
+// finishStream is finish; ended is whether the stream has ended, so that its
+// trailer is available.
+func (s *ClientStreamWrapper) finishStream(err error, ended bool) {
+	if s.span == nil {
+		return
+	}
+	s.finishOnce.Do(func() {
+		if ended && s.backendMetrics {
+			setBackendMetricLabels(s.span, s.stream.Trailer(), s.maxBackendMetrics)
+		}
+		if ended && s.responseLinkKey != "" {
+			header, _ := s.stream.Header()
+			linkResponseMetadata(s.span, s.responseLinkKey, header, s.stream.Trailer())
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		setOKLabel(s.span, err)
+		if err != nil {
+			setCancelCause(s.span, s.stream.Context())
+			setErrorSource(s.span, s.ctx, err)
+		}
+		s.counts.setLabels(s.span)
+		s.budget.finish(s.span)
+		s.span.FinishWithError(err)
+		if s.finished != nil {
+			close(s.finished)
+		}
+	})
+}
+
+// GRPCStreamClientInterceptor returns a grpc.StreamClientInterceptor that
+// traces outgoing streams, like GRPCClientInterceptor does for unary calls.
+// The span of a stream finishes when CloseSend is called, or SendMsg or
+// RecvMsg returns an error.  The span of a stream that ends with io.EOF is
+// finished without error labels; other errors label it like the span of a
+// failed unary call.  If the context of the stream is cancelled or its
+// deadline expires first, the span is finished with the status of the
+// context, and labeled "grpc/context_done" with "cancelled" or
+// "deadline_exceeded".  Like the spans of the stream server interceptor, the
+// span is labeled with the numbers of messages sent and received.
+func GRPCStreamClientInterceptor(opts ...InterceptorOption) grpc.StreamClientInterceptor {
+	return grpc.StreamClientInterceptor(newInterceptorConfig(opts).streamClient)
 }
 
-func grpcStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+func (config *interceptorConfig) streamClient(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
 	streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
 
-	span := FromContext(ctx).NewChild(method)
+	if !config.observeOnly {
+		ctx = withOutgoingForwarded(ctx)
+	}
+	if config.ignored(method) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	span := config.clientSpan(ctx, method)
+	budget := startDeadlineBudget(ctx, span)
+	setAuthorityLabel(span, cc)
+	setClientPeerLabel(span, cc)
+	setMethodLabels(span, method)
+	applyCallLabels(ctx, span)
+	if config.callOptionLabels != 0 {
+		setCallOptionLabels(span, opts, config.callOptionLabels)
+	}
+	config.runSpanHook(span, method)
 
-	if span != nil {
-		header := spanHeader(span.trace.traceID, span.span.ParentSpanId, span.trace.globalOptions)
-		md, ok := metadata.FromOutgoingContext(ctx)
-		if !ok {
-			md = metadata.Pairs(grpcMetadataKey, header)
-		} else {
-			md = md.Copy() // metadata is immutable, copy.
-			md[grpcMetadataKey] = []string{header}
-		}
-		ctx = metadata.NewOutgoingContext(ctx, md)
+	if span != nil && !config.observeOnly {
+		ctx = config.withSendTime(config.outgoingContext(ctx, span))
+		opts = removeTraceCallOptions(opts)
 	}
 
 	cs, err := streamer(ctx, desc, cc, method, opts...)
 	if err != nil {
-		span.Finish()
+		budget.finish(span)
+		setErrorSource(span, ctx, err)
+		span.FinishWithError(err)
 		return nil, err
 	}
-	return &ClientStreamWrapper{stream: cs, span: span}, nil
+	w := &ClientStreamWrapper{stream: cs, span: span, budget: budget, ctx: ctx, messageEvents: config.messageEvents, msgSpans: newMessageSpans(config, method), lazy: config.lazyStreamSpan,
+		backendMetrics: config.backendMetrics, maxBackendMetrics: config.maxBackendMetrics, responseLinkKey: config.responseLinkKey}
+	w.counts.bytes = config.streamBytes
+	w.counts.stallThreshold = config.sendStallThreshold
+	w.watchContext()
+	return w, nil
 }
 
 type ServerStreamWrapper struct {
-	stream  grpc.ServerStream
-	span    *Span
-	context context.Context
+	counts streamCounts // first, for the alignment of its 64-bit counters.
+
+	stream     grpc.ServerStream
+	span       *Span
+	context    context.Context
+	method     string
+	perMessage bool
+	types      messageTypeLabels
+	finishOnce sync.Once
+
+	messageEvents bool
+	msgSpans      *messageSpans // for WithMessageSpans, or nil.
+
+	syncFinish        bool
+	syncFinishTimeout time.Duration
+
+	idleTimeout time.Duration
+	idleTimer   idleTimer          // nil if there is no idle timeout.
+	cancel      context.CancelFunc // cancels context; nil if not cancellable.
+
+	mu         sync.Mutex // guards msgSpan, msgContext and finished
+	msgSpan    *Span
+	msgContext context.Context
+	finished   bool
 }
 
 func (s *ServerStreamWrapper) SetHeader(md metadata.MD) error {
@@ -178,36 +662,200 @@ func (s *ServerStreamWrapper) Context() context.Context {
 }
 
 func (s *ServerStreamWrapper) SendMsg(m interface{}) error {
+	start := s.counts.startSend()
+	msgSpan := s.msgSpans.startSend(s.span)
 	err := s.stream.SendMsg(m)
+	finishMessageSpan(msgSpan, err)
+	s.counts.sendDone(s.span, start)
+	s.active()
 	if err != nil && s.span != nil {
-		log.Printf(" finishing trace %s", s.span.TraceID())
-		s.span.Finish()
+		setStatusLabels(s.span, err)
+		s.finish()
+	} else if err == nil {
+		s.counts.sent(m)
+		s.types.send(s.span, labelGRPCResponseType, m)
+		if s.messageEvents {
+			s.span.addMessageEvent(MessageSent, m)
+		}
 	}
 	return err
 }
 
 func (s *ServerStreamWrapper) RecvMsg(m interface{}) error {
+	s.finishMessageSpan()
+	msgSpan := s.msgSpans.startRecv(s.span)
 	err := s.stream.RecvMsg(m)
+	finishMessageSpan(msgSpan, err)
+	s.active()
+	if err == io.EOF {
+		// The client has finished sending, but the handler may still be
+		// working.  The interceptor finishes the span when it returns.
+		return err
+	}
 	if err != nil && s.span != nil {
-		log.Printf(" finishing trace %s", s.span.TraceID())
-		s.span.Finish()
+		setStatusLabels(s.span, err)
+		s.finish()
+		return err
+	}
+	if err == nil {
+		s.counts.received(m)
+		s.types.receive(s.span, labelGRPCRequestType, m)
+		if s.messageEvents {
+			s.span.addMessageEvent(MessageReceived, m)
+		}
+	}
+	if err == nil && s.perMessage {
+		s.startMessageSpan()
 	}
 	return err
 }
 
-func GRPCStreamServerInterceptor(tc *Client) grpc.StreamServerInterceptor {
-	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+// finish finishes the span of the current message, if any, and the span of
+// the stream.  Only the first call has any effect.
+func (s *ServerStreamWrapper) finish() {
+	s.finishOnce.Do(func() {
+		s.stopIdleTimer()
+		s.finishMessageSpan()
+		s.counts.setLabels(s.span)
+		if s.span != nil {
+			s.span.trace.client.debugf("trace: finishing the span of stream %s, trace %s", s.method, s.span.TraceID())
+		}
+		finishServerSpan(s.span, s.syncFinish, s.syncFinishTimeout)
+	})
+}
+
+func (s *ServerStreamWrapper) startMessageSpan() {
+	span := s.span.NewChild(s.method + "/msg")
+	ctx := NewContext(s.context, span)
+	s.mu.Lock()
+	s.msgSpan, s.msgContext = span, ctx
+	s.mu.Unlock()
+}
+
+func (s *ServerStreamWrapper) finishMessageSpan() {
+	s.mu.Lock()
+	span := s.msgSpan
+	s.msgSpan, s.msgContext = nil, nil
+	s.mu.Unlock()
+	span.Finish()
+}
+
+// MessageContext returns the context in which to handle the message most
+// recently received on ss.  If ss was wrapped by a stream server interceptor
+// created with WithPerMessageSpans, the context contains the span of that
+// message, and work started with it is nested under the message's span.
+// Otherwise MessageContext returns ss.Context().
+//
+// Call MessageContext after RecvMsg returns, and before it is called again.
+// Each message has its own context, which may be kept after RecvMsg is
+// called again, for example by a goroutine started for the message; the
+// message's span has finished by then, but work can still be nested under
+// it.
+func MessageContext(ss grpc.ServerStream) context.Context {
+	if w, ok := ss.(*ServerStreamWrapper); ok {
+		w.mu.Lock()
+		ctx := w.msgContext
+		w.mu.Unlock()
+		if ctx != nil {
+			return ctx
+		}
+	}
+	return ss.Context()
+}
+
+// GRPCStreamServerInterceptor returns a grpc.StreamServerInterceptor that
+// enables the tracing of incoming streaming gRPC calls.  The context of the
+// stream passed to the handler contains the span of the call.  The span is
+// labeled with the numbers of messages sent and received on the stream, as
+// "grpc/sent_messages" and "grpc/recv_messages"; see WithStreamByteCounts.
+func GRPCStreamServerInterceptor(tc *Client, opts ...InterceptorOption) grpc.StreamServerInterceptor {
+	config := newInterceptorConfig(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
 		md, _ := metadata.FromIncomingContext(ss.Context())
-		log.Printf("intercepting server")
-		if header, ok := md[grpcMetadataKey]; ok {
-			span := tc.SpanFromHeader("", strings.Join(header, ""))
-			log.Printf(" intercept trace %s", span.TraceID())
+		ctx := ss.Context()
+		if len(config.forwardedKeys) > 0 {
+			ctx = withIncomingForwarded(ctx, md, config.forwardedKeys)
+			ss = &contextServerStream{ServerStream: ss, ctx: ctx}
+		}
+		if config.ignored(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		sc, ok, headerErr := config.extract(tc, ctx, md)
+		if !ok && !config.alwaysTrace && config.methodStats {
+			tc.recordServerCall(info.FullMethod, headerMissing, false)
+		}
+		if ok || config.alwaysTrace {
+			var span *Span
+			if ok {
+				span = tc.spanFromContextErr("", sc, headerErr)
+				tc.reportHeaderError(headerErr)
+				if config.methodStats {
+					tc.recordServerCall(info.FullMethod, headerStateOf(headerErr), span.traced())
+				}
+			} else {
+				span = tc.newServerRootSpan(info.FullMethod)
+				if config.methodStats {
+					tc.recordServerCall(info.FullMethod, headerMissing, span.traced())
+				}
+			}
+			tc.debugf("trace: tracing stream %s, trace %s", info.FullMethod, span.TraceID())
+			setMethodLabels(span, info.FullMethod)
+			setServerPeerLabel(span, ctx)
+			setDeadlineLabel(span, ctx)
+			if config.tlsLabels {
+				setPeerTLSLabels(ctx, span)
+			}
+			if config.metadataSize {
+				setMetadataSizeLabels(span, md, config.metadataThreshold)
+			}
+			if config.projectHintKey != "" {
+				setProjectHint(tc, span, md, config.projectHintKey)
+			}
+			config.setSendTimeSkewLabel(span, md)
+			if config.labelCap > 0 {
+				setLabelCap(span, config.labelCap)
+			}
+			if config.enforcedFinish {
+				enforceFinish(span)
+			}
+			config.runSpanHook(span, info.FullMethod)
+			ctx = NewContext(ctx, span)
+			w := &ServerStreamWrapper{
+				stream:     ss,
+				span:       span,
+				context:    ctx,
+				method:     info.FullMethod,
+				perMessage: config.perMessageSpans,
+
+				messageEvents: config.messageEvents,
+				msgSpans:      newMessageSpans(config, info.FullMethod),
+
+				syncFinish:        config.syncFinish,
+				syncFinishTimeout: config.syncFinishTimeout,
+			}
+			w.counts.bytes = config.streamBytes
+			w.counts.stallThreshold = config.sendStallThreshold
+			if config.idleTimeout > 0 {
+				w.context = w.startIdleTimer(ctx, config.idleTimeout, config.idleCancel)
+			}
+			pools := tc.startDBPoolLabels(span, config)
 			defer func() {
-				log.Printf(" defer finishing trace %s", span.TraceID())
-				span.Finish()
+				v := recover()
+				if v != nil {
+					labelServerPanic(span, v)
+				} else {
+					setStatusLabels(span, err)
+					if err != nil {
+						setCancelCause(span, ctx)
+					}
+				}
+				pools.setLabels(span)
+				w.finish()
+				if v != nil {
+					panic(v)
+				}
 			}()
-			ctx := NewContext(ss.Context(), span)
-			ss = &ServerStreamWrapper{stream: ss, span: span, context: ctx}
+			ss = w
 		}
 		return handler(srv, ss)
 	}
@@ -0,0 +1,421 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeServerStream is a grpc.ServerStream that receives n messages.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	n   int
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	if s.n == 0 {
+		return io.EOF
+	}
+	s.n--
+	return nil
+}
+
+// newTracedStream returns a fakeServerStream whose incoming metadata
+// contains a trace header with tracing enabled.
+func newTracedStream(n int) *fakeServerStream {
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	return &fakeServerStream{ctx: metadata.NewIncomingContext(context.Background(), md), n: n}
+}
+
+// echoStreamDesc describes a bidirectional streaming method that echoes the
+// messages it receives, and records the trace header of each call in the
+// headers channel of the server.
+var echoStreamDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Stream",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv interface{}, ss grpc.ServerStream) error {
+			md, _ := metadata.FromIncomingContext(ss.Context())
+			srv.(chan []string) <- md[grpcMetadataKey]
+			for {
+				var m empty.Empty
+				if err := ss.RecvMsg(&m); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := ss.SendMsg(&m); err != nil {
+					return err
+				}
+			}
+		},
+	}},
+}
+
+// startEchoServer starts a server for echoStreamDesc that uses opts.  It
+// returns a listener for connecting to the server, the channel to which the
+// server sends the trace header of each call, and a function that stops the
+// server.
+func startEchoServer(opts ...grpc.ServerOption) (*bufconn.Listener, chan []string, func()) {
+	headers := make(chan []string, 1)
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&echoStreamDesc, headers)
+	lis := bufconn.Listen(1 << 16)
+	go srv.Serve(lis)
+	return lis, headers, srv.Stop
+}
+
+// dialEcho returns a connection to lis made with opts.
+func dialEcho(t *testing.T, lis *bufconn.Listener, opts ...option.ClientOption) *grpc.ClientConn {
+	opts = append([]option.ClientOption{
+		option.WithEndpoint("bufnet"),
+		option.WithGRPCDialOption(grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return lis.Dial()
+		})),
+	}, opts...)
+	conn, err := transport.DialGRPCInsecure(context.Background(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// echo makes a call on conn that sends and receives one message, and
+// returns the trace headers received by the server.
+func echo(t *testing.T, ctx context.Context, conn *grpc.ClientConn, headers chan []string, opts ...grpc.CallOption) []string {
+	stream, err := conn.NewStream(ctx, &echoStreamDesc.Streams[0], "/test.Echo/Stream", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&empty.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if err := stream.RecvMsg(&empty.Empty{}); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return <-headers
+}
+
+func TestEnableGRPCTracingAll(t *testing.T) {
+	serverExporter := &recordingExporter{exported: make(chan struct{}, 1)}
+	serverTC := NewClientWithExporter(serverExporter)
+	serverTC.bundler.BundleCountThreshold = 1
+	lis, headers, stop := startEchoServer(GRPCServerOptions(serverTC)...)
+	defer stop()
+	conn := dialEcho(t, lis, EnableGRPCTracingAll...)
+	defer conn.Close()
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+
+	const method = "/test.Echo/Stream"
+	stream, err := conn.NewStream(ctx, &echoStreamDesc.Streams[0], method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := stream.SendMsg(&empty.Empty{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.RecvMsg(&empty.Empty{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.RecvMsg(&empty.Empty{}); err != io.EOF {
+		t.Fatalf("RecvMsg: got %v; want io.EOF", err)
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	call := e.span(method)
+	if call == nil {
+		t.Fatalf("streaming call was not traced")
+	}
+	if got, want := call.ParentSpanID, root.span.SpanId; got != want {
+		t.Errorf("call span parent = %d; want %d", got, want)
+	}
+	if got, want := call.Kind, SpanKindClient; got != want {
+		t.Errorf("call span kind = %v; want %v", got, want)
+	}
+	if got := <-headers; len(got) != 1 || !strings.HasPrefix(got[0], root.TraceID()+"/") {
+		t.Errorf("server got trace header %q; want a header for trace %s", got, root.TraceID())
+	}
+
+	select {
+	case <-serverExporter.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the server's span")
+	}
+	if len(serverExporter.spans) != 1 {
+		t.Fatalf("server exported %d spans; want 1", len(serverExporter.spans))
+	}
+	if got, want := serverExporter.spans[0].TraceID, root.TraceID(); got != want {
+		t.Errorf("server span has trace ID %q; want %q", got, want)
+	}
+}
+
+func TestSpanKinds(t *testing.T) {
+	serverExporter := &recordingExporter{exported: make(chan struct{}, 1)}
+	serverTC := NewClientWithExporter(serverExporter)
+	serverTC.bundler.BundleCountThreshold = 1
+	lis, stop := startRelay(&relay{md: make(chan metadata.MD, 1)}, GRPCServerOptions(serverTC)...)
+	defer stop()
+	conn := dialEcho(t, lis, EnableGRPCTracingAll...)
+	defer conn.Close()
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	const method = "/test.Relay/Call"
+	if err := conn.Invoke(NewContext(context.Background(), root), method, &empty.Empty{}, &empty.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-serverExporter.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the server's span")
+	}
+	serverExporter.mu.Lock()
+	defer serverExporter.mu.Unlock()
+
+	call := e.span(method)
+	if call == nil {
+		t.Fatalf("call was not traced")
+	}
+	if len(serverExporter.spans) != 1 {
+		t.Fatalf("server exported %d spans; want 1", len(serverExporter.spans))
+	}
+	server := serverExporter.spans[0]
+	if call.Kind != SpanKindClient || server.Kind != SpanKindServer {
+		t.Errorf("client span kind %v, server span kind %v; want %v and %v", call.Kind, server.Kind, SpanKindClient, SpanKindServer)
+	}
+	// The client and server spans of the call are paired as siblings with
+	// the same method labels: the trace header carries the ID of the span in
+	// the call's context, root, which is the parent of both.
+	if call.ParentSpanID != root.SpanID() {
+		t.Errorf("client span parent = %x; want %x", call.ParentSpanID, root.SpanID())
+	}
+	if server.TraceID != call.TraceID || server.ParentSpanID != root.SpanID() {
+		t.Errorf("server span in trace %q with parent %x; want trace %q with parent %x", server.TraceID, server.ParentSpanID, call.TraceID, root.SpanID())
+	}
+	for _, key := range []string{labelGRPCService, labelGRPCMethod} {
+		if got, want := server.Labels[key], call.Labels[key]; got != want || want == "" {
+			t.Errorf("label %q of the server span = %q, of the client span = %q; want equal and non-empty", key, got, want)
+		}
+	}
+}
+
+func TestGRPCCallOption(t *testing.T) {
+	lis, headers, stop := startEchoServer()
+	defer stop()
+	plain := dialEcho(t, lis)
+	defer plain.Close()
+	intercepted := dialEcho(t, lis, EnableGRPCTracingAll...)
+	defer intercepted.Close()
+
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+
+	if got, want := echo(t, ctx, plain, headers, GRPCCallOption(ctx)), []string{root.Header()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without interceptors: server got headers %q; want %q", got, want)
+	}
+	if got := echo(t, ctx, intercepted, headers, GRPCCallOption(ctx)); len(got) != 1 || !strings.HasPrefix(got[0], root.TraceID()+"/") {
+		t.Errorf("with interceptors: server got headers %q; want one header for trace %s", got, root.TraceID())
+	}
+	if got := echo(t, ctx, plain, headers); len(got) != 0 {
+		t.Errorf("without the option: server got headers %q; want none", got)
+	}
+	bg := context.Background()
+	if got := echo(t, bg, plain, headers, GRPCCallOption(bg)); len(got) != 0 {
+		t.Errorf("without a span: server got headers %q; want none", got)
+	}
+}
+
+func TestOutgoingContext(t *testing.T) {
+	lis, headers, stop := startEchoServer()
+	defer stop()
+	plain := dialEcho(t, lis)
+	defer plain.Close()
+	intercepted := dialEcho(t, lis, EnableGRPCTracingAll...)
+	defer intercepted.Close()
+
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+
+	if got, want := echo(t, OutgoingContext(ctx), plain, headers), []string{root.Header()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without interceptors: server got headers %q; want %q", got, want)
+	}
+	if got := echo(t, OutgoingContext(ctx), intercepted, headers); len(got) != 1 || !strings.HasPrefix(got[0], root.TraceID()+"/") {
+		t.Errorf("with interceptors: server got headers %q; want one header for trace %s", got, root.TraceID())
+	}
+	bg := context.Background()
+	if got := OutgoingContext(bg); got != bg {
+		t.Error("OutgoingContext without a span returned a new context")
+	}
+}
+
+func TestRemoveTraceCallOptions(t *testing.T) {
+	ctx := context.Background()
+	keep := grpc.FailFast(false)
+	opts := []grpc.CallOption{GRPCCallOption(ctx), keep, GRPCCallOption(ctx)}
+	if got := removeTraceCallOptions(opts); len(got) != 1 || got[0] != keep {
+		t.Errorf("removeTraceCallOptions = %v; want [%v]", got, keep)
+	}
+	if len(opts) != 3 {
+		t.Errorf("removeTraceCallOptions modified its argument")
+	}
+}
+
+func TestServerInterceptorReportsHeaderErrors(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		tc := NewClientWithExporter(&recordingExporter{})
+		tc.SetStrictValidation(strict)
+		var errs []error
+		tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(grpcMetadataKey, "garbage"))
+		var span *Span
+		GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			span = FromContext(ctx)
+			return nil, nil
+		})
+		if span == nil {
+			t.Errorf("strict=%t: handler got no span", strict)
+		}
+		wantErrs := 0
+		if strict {
+			wantErrs = 1
+		}
+		if len(errs) != wantErrs {
+			t.Fatalf("strict=%t: got errors %v; want %d", strict, errs, wantErrs)
+		}
+		if strict && errs[0].(*HeaderError).Err != ErrMalformedTraceID {
+			t.Errorf("got error %v; want %v", errs[0], ErrMalformedTraceID)
+		}
+	}
+}
+
+func TestServerInterceptorMultipleHeaders(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	const first = "0123456789abcdef0123456789abcdef"
+	md := metadata.MD{grpcMetadataKey: {first + "/1;o=1", "fedcba9876543210fedcba9876543210/2;o=1"}}
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	var span *Span
+	GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span = FromContext(ctx)
+		return nil, nil
+	})
+	if got := span.TraceID(); got != first {
+		t.Errorf("trace ID = %q; want %q, from the first header", got, first)
+	}
+	if got := span.ParentSpanID(); got != 1 {
+		t.Errorf("parent span ID = %d; want 1", got)
+	}
+}
+
+// endingClientStream is a grpc.ClientStream whose RecvMsg returns err.
+type endingClientStream struct {
+	fakeClientStream
+	err error
+}
+
+func (s *endingClientStream) RecvMsg(m interface{}) error { return s.err }
+
+func TestStreamClientFinish(t *testing.T) {
+	for _, tt := range []struct {
+		err        error
+		wantCode   string
+		wantLegacy string
+	}{
+		{err: io.EOF, wantCode: "OK"},
+		{err: status.Error(codes.Unavailable, "down"), wantCode: "Unavailable", wantLegacy: "rpc error: code = Unavailable desc = down"},
+	} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		tc.SetLegacyErrorLabels(true)
+		root := tc.NewSpan("/root")
+		ctx := NewContext(context.Background(), root)
+		cs, err := GRPCStreamClientInterceptor()(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &endingClientStream{fakeClientStream{ctx: ctx}, tt.err}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cs.RecvMsg(&empty.Empty{}); err != tt.err {
+			t.Fatalf("RecvMsg = %v; want %v", err, tt.err)
+		}
+		// Closing the stream after it ended doesn't finish the span again.
+		cs.CloseSend()
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+
+		var spans []*SpanData
+		for _, s := range e.spans {
+			if s.Name == "/stream" {
+				spans = append(spans, s)
+			}
+		}
+		if len(spans) != 1 {
+			t.Fatalf("%v: exported %d stream spans; want 1", tt.err, len(spans))
+		}
+		if got := spans[0].Labels["grpc/status_code"]; got != tt.wantCode {
+			t.Errorf("%v: grpc/status_code = %q; want %q", tt.err, got, tt.wantCode)
+		}
+		if got := spans[0].Labels["error"]; got != tt.wantLegacy {
+			t.Errorf("%v: error = %q; want %q", tt.err, got, tt.wantLegacy)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	labelGRPCService      = `grpc/service`
+	labelGRPCMethod       = `grpc/method`
+	labelGRPCPeer         = `grpc/peer`
+	labelGRPCRequestType  = `grpc/request_type`
+	labelGRPCResponseType = `grpc/response_type`
+)
+
+// setMethodLabels labels span with the service and method of fullMethod,
+// of the form /package.Service/Method.
+func setMethodLabels(span *Span, fullMethod string) {
+	service, method := splitMethod(fullMethod)
+	span.setLabel(labelGRPCService, service)
+	span.setLabel(labelGRPCMethod, method)
+}
+
+// splitMethod returns the service and method names of fullMethod.  A name
+// that isn't of the form /service/method is returned whole as the method.
+func splitMethod(fullMethod string) (service, method string) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// setClientPeerLabel labels span with the target of cc, the connection of an
+// outgoing call.
+func setClientPeerLabel(span *Span, cc *grpc.ClientConn) {
+	if cc != nil {
+		span.setLabel(labelGRPCPeer, cc.Target())
+	}
+}
+
+// setServerPeerLabel labels span with the address of the peer of ctx, the
+// context of an incoming call.
+func setServerPeerLabel(span *Span, ctx context.Context) {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		span.setLabel(labelGRPCPeer, p.Addr.String())
+	}
+}
+
+// setMessageTypeLabel labels span with the type of message m, under key.
+// Protocol buffers are named by their full proto name, and other values by
+// their Go type.
+func setMessageTypeLabel(span *Span, key string, m interface{}) {
+	if m == nil {
+		return
+	}
+	if pm, ok := m.(proto.Message); ok {
+		if name := proto.MessageName(pm); name != "" {
+			span.setLabel(key, name)
+			return
+		}
+	}
+	span.setLabel(key, fmt.Sprintf("%T", m))
+}
+
+// messageTypeLabels labels the span of a stream with the types of the first
+// messages sent and received on it.
+type messageTypeLabels struct {
+	sent, received sync.Once
+}
+
+// send labels span with the type of m, a message sent under key.
+func (l *messageTypeLabels) send(span *Span, key string, m interface{}) {
+	l.sent.Do(func() { setMessageTypeLabel(span, key, m) })
+}
+
+// receive labels span with the type of m, a message received under key.
+func (l *messageTypeLabels) receive(span *Span, key string, m interface{}) {
+	l.received.Do(func() { setMessageTypeLabel(span, key, m) })
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// withoutCallLabels returns labels without the labels that the interceptors
+// set on the span of every call, or nil if no other labels are left.
+func withoutCallLabels(labels map[string]string) map[string]string {
+	var m map[string]string
+	for k, v := range labels {
+		switch k {
+		case labelGRPCStatusCode, labelGRPCService, labelGRPCMethod, labelGRPCPeer, labelGRPCRequestType, labelGRPCResponseType:
+		default:
+			if m == nil {
+				m = make(map[string]string)
+			}
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func TestSplitMethod(t *testing.T) {
+	for _, tt := range []struct {
+		in, service, method string
+	}{
+		{"/google.datastore.v1.Datastore/Lookup", "google.datastore.v1.Datastore", "Lookup"},
+		{"/Method", "", "Method"},
+		{"", "", ""},
+	} {
+		if service, method := splitMethod(tt.in); service != tt.service || method != tt.method {
+			t.Errorf("splitMethod(%q) = %q, %q; want %q, %q", tt.in, service, method, tt.service, tt.method)
+		}
+	}
+}
+
+func TestUnaryCallLabels(t *testing.T) {
+	wrapped := fmt.Errorf("lookup: %w", status.Error(codes.DeadlineExceeded, "too slow"))
+	for _, tt := range []struct {
+		desc     string
+		err      error
+		wantCode string
+	}{
+		{"ok", nil, "OK"},
+		{"wrapped error", wrapped, "DeadlineExceeded"},
+	} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		tc.SetLegacyErrorLabels(false)
+
+		// Client side.
+		root := tc.NewSpan("/root")
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return tt.err
+		}
+		GRPCClientInterceptor()(NewContext(context.Background(), root), "/test.Service/Method", &empty.Empty{}, &empty.Empty{}, nil, invoker)
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			labelGRPCStatusCode:   tt.wantCode,
+			labelGRPCService:      "test.Service",
+			labelGRPCMethod:       "Method",
+			labelGRPCRequestType:  "google.protobuf.Empty",
+			labelGRPCResponseType: "google.protobuf.Empty",
+		}
+		if tt.err != nil {
+			want[labelGRPCStatusMessage] = "too slow"
+			want[labelGRPCErrorSource] = "status"
+		}
+		if got := e.span("/test.Service/Method").Labels; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: client span labels = %v; want %v", tt.desc, got, want)
+		}
+
+		// Server side.
+		e.spans = nil
+		tc.bundler.BundleCountThreshold = 1
+		e.exported = make(chan struct{}, 1)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1"))
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4321}})
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			if tt.err != nil {
+				return nil, tt.err
+			}
+			return "response", nil
+		}
+		GRPCServerInterceptor(tc)(ctx, &empty.Empty{}, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, handler)
+		<-e.exported
+		want[labelGRPCPeer] = "10.0.0.1:4321"
+		// Only client spans say where their error came from.
+		delete(want, labelGRPCErrorSource)
+		if tt.err == nil {
+			want[labelGRPCResponseType] = "string"
+		} else {
+			delete(want, labelGRPCResponseType)
+		}
+		if got := e.spans[0].Labels; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: server span labels = %v; want %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestStreamServerCallLabels(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+	tc.SetLegacyErrorLabels(false)
+
+	ss := newTracedStream(1)
+	GRPCStreamServerInterceptor(tc)(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Echo/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		if err := ss.RecvMsg(&empty.Empty{}); err != nil {
+			return err
+		}
+		return status.Error(codes.ResourceExhausted, "quota")
+	})
+	<-e.exported
+	want := map[string]string{
+		labelGRPCStatusCode:    "ResourceExhausted",
+		labelGRPCStatusMessage: "quota",
+		labelGRPCService:       "test.Echo",
+		labelGRPCMethod:        "Stream",
+		labelGRPCRequestType:   "google.protobuf.Empty",
+		labelGRPCRecvMessages:  "1",
+		labelGRPCSentMessages:  "0",
+	}
+	if got := e.spans[0].Labels; !reflect.DeepEqual(got, want) {
+		t.Errorf("stream span labels = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+	grpcstats "google.golang.org/grpc/stats"
+)
+
+// NewGRPCStatsHandler returns a grpcstats.Handler that traces the incoming gRPC
+// calls of a server, as an alternative to GRPCServerInterceptor and
+// GRPCStreamServerInterceptor, for servers whose interceptors are already
+// taken or must run in a particular order:
+//
+//   s := grpc.NewServer(grpc.StatsHandler(trace.NewGRPCStatsHandler(tc)))
+//
+// The spans are made from the trace headers of the calls, named and labeled
+// like the spans of the server interceptors, and are in the contexts of the
+// calls.  Each message received or sent is annotated with its size, and the
+// span is labeled with the numbers of messages, as "grpc/recv_messages" and
+// "grpc/sent_messages", for unary calls too, since a stats handler can't
+// tell them apart from streams.  The span finishes when gRPC reports the
+// end of the call, with its status.
+//
+// Of the InterceptorOptions, WithMethodFilter, AlwaysTrace, WithMethodStats,
+// WithMessageEvents, WithStreamByteCounts, SynchronousFinish and the options
+// that choose how trace contexts are propagated apply; the others are
+// ignored.
+func NewGRPCStatsHandler(tc *Client, opts ...InterceptorOption) grpcstats.Handler {
+	return &serverStatsHandler{tc: tc, config: newInterceptorConfig(opts)}
+}
+
+// NewGRPCClientStatsHandler returns a grpcstats.Handler that traces the outgoing
+// gRPC calls of a client connection, as an alternative to
+// GRPCClientInterceptor and GRPCStreamClientInterceptor:
+//
+//   conn, err := grpc.Dial(target, grpc.WithStatsHandler(trace.NewGRPCClientStatsHandler()))
+//
+// A call made in a context containing a span gets a child span, named and
+// labeled like the spans of the client interceptors, and the trace header
+// of the child is sent with the call.  The span is annotated and labeled
+// like the spans of NewGRPCStatsHandler.
+//
+// Of the InterceptorOptions, WithMethodFilter, WithRootSpans,
+// WithObserveOnly, WithMessageEvents, WithStreamByteCounts and the options
+// that choose how trace contexts are propagated apply; the others are
+// ignored.
+func NewGRPCClientStatsHandler(opts ...InterceptorOption) grpcstats.Handler {
+	return &clientStatsHandler{config: newInterceptorConfig(opts)}
+}
+
+type statsKey struct{}
+
+// An rpcStats is the state of a call traced by a stats handler, stored in the
+// context of the call.
+type rpcStats struct {
+	span   *Span
+	ctx    context.Context // the context of the call.
+	budget *deadlineBudget // for client calls; nil if there is no deadline.
+	counts streamCounts
+
+	messageEvents bool
+}
+
+func rpcStatsFrom(ctx context.Context) *rpcStats {
+	r, _ := ctx.Value(statsKey{}).(*rpcStats)
+	return r
+}
+
+// payload records a message of the call, sent if out is true.
+func (r *rpcStats) payload(m interface{}, length, wireLength int, out bool) {
+	msg, typ := "message received", MessageReceived
+	if out {
+		msg, typ = "message sent", MessageSent
+	}
+	r.span.Annotate(msg, "bytes", strconv.Itoa(length), "wire_bytes", strconv.Itoa(wireLength))
+	if out {
+		r.counts.sent(m)
+	} else {
+		r.counts.received(m)
+	}
+	if r.messageEvents {
+		r.span.addMessageEvent(typ, m)
+	}
+}
+
+type serverStatsHandler struct {
+	tc     *Client
+	config *interceptorConfig
+}
+
+func (h *serverStatsHandler) TagRPC(ctx context.Context, info *grpcstats.RPCTagInfo) context.Context {
+	config, tc, method := h.config, h.tc, info.FullMethodName
+	if config.ignored(method) {
+		return ctx
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	sc, ok, err := config.extract(tc, ctx, md)
+	if !ok && !config.alwaysTrace {
+		if config.methodStats {
+			tc.recordServerCall(method, headerMissing, false)
+		}
+		return ctx
+	}
+	var span *Span
+	if ok {
+		span = tc.spanFromContextErr("", sc, err)
+		tc.reportHeaderError(err)
+		if config.methodStats {
+			tc.recordServerCall(method, headerStateOf(err), span.traced())
+		}
+	} else {
+		span = tc.newServerRootSpan(method)
+		if config.methodStats {
+			tc.recordServerCall(method, headerMissing, span.traced())
+		}
+	}
+	setMethodLabels(span, method)
+	setServerPeerLabel(span, ctx)
+	setDeadlineLabel(span, ctx)
+	r := &rpcStats{span: span, ctx: ctx, messageEvents: config.messageEvents}
+	r.counts.bytes = config.streamBytes
+	return context.WithValue(NewContext(ctx, span), statsKey{}, r)
+}
+
+func (h *serverStatsHandler) HandleRPC(ctx context.Context, s grpcstats.RPCStats) {
+	r := rpcStatsFrom(ctx)
+	if r == nil {
+		return
+	}
+	switch s := s.(type) {
+	case *grpcstats.InPayload:
+		r.payload(s.Payload, s.Length, s.WireLength, false)
+	case *grpcstats.OutPayload:
+		r.payload(s.Payload, s.Length, s.WireLength, true)
+	case *grpcstats.End:
+		setStatusLabels(r.span, s.Error)
+		if s.Error != nil {
+			setCancelCause(r.span, r.ctx)
+		}
+		r.counts.setLabels(r.span)
+		finishServerSpan(r.span, h.config.syncFinish, h.config.syncFinishTimeout)
+	}
+}
+
+func (h *serverStatsHandler) TagConn(ctx context.Context, info *grpcstats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *serverStatsHandler) HandleConn(ctx context.Context, s grpcstats.ConnStats) {}
+
+type clientStatsHandler struct {
+	config *interceptorConfig
+}
+
+func (h *clientStatsHandler) TagRPC(ctx context.Context, info *grpcstats.RPCTagInfo) context.Context {
+	config, method := h.config, info.FullMethodName
+	if config.ignored(method) {
+		return ctx
+	}
+	span := config.clientSpan(ctx, method)
+	if span == nil {
+		return ctx
+	}
+	r := &rpcStats{span: span, ctx: ctx, messageEvents: config.messageEvents}
+	r.counts.bytes = config.streamBytes
+	r.budget = startDeadlineBudget(ctx, span)
+	setMethodLabels(span, method)
+	applyCallLabels(ctx, span)
+	if !config.observeOnly {
+		ctx = config.outgoingContext(ctx, span)
+	}
+	return context.WithValue(ctx, statsKey{}, r)
+}
+
+func (h *clientStatsHandler) HandleRPC(ctx context.Context, s grpcstats.RPCStats) {
+	r := rpcStatsFrom(ctx)
+	if r == nil {
+		return
+	}
+	switch s := s.(type) {
+	case *grpcstats.InPayload:
+		r.payload(s.Payload, s.Length, s.WireLength, false)
+	case *grpcstats.OutPayload:
+		r.payload(s.Payload, s.Length, s.WireLength, true)
+	case *grpcstats.End:
+		setOKLabel(r.span, s.Error)
+		if s.Error != nil {
+			setCancelCause(r.span, r.ctx)
+			setErrorSource(r.span, r.ctx, s.Error)
+		}
+		r.counts.setLabels(r.span)
+		r.budget.finish(r.span)
+		r.span.FinishWithError(s.Error)
+	}
+}
+
+func (h *clientStatsHandler) TagConn(ctx context.Context, info *grpcstats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *clientStatsHandler) HandleConn(ctx context.Context, s grpcstats.ConnStats) {}
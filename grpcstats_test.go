@@ -0,0 +1,103 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstats "google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCStatsHandlers(t *testing.T) {
+	serverExporter := &recordingExporter{exported: make(chan struct{}, 1)}
+	serverTC := NewClientWithExporter(serverExporter)
+	serverTC.bundler.BundleCountThreshold = 1
+	lis, headers, stop := startEchoServer(grpc.StatsHandler(NewGRPCStatsHandler(serverTC)))
+	defer stop()
+	conn := dialEcho(t, lis, option.WithGRPCDialOption(grpc.WithStatsHandler(NewGRPCClientStatsHandler())))
+	defer conn.Close()
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	h := echo(t, NewContext(context.Background(), root), conn, headers)
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	client := e.span("/test.Echo/Stream")
+	if client == nil {
+		t.Fatalf("exported spans %v; want a client span /test.Echo/Stream", names(e.spans))
+	}
+	if client.ParentSpanID != root.span.SpanId {
+		t.Errorf("client span's parent is %d; want the root span %d", client.ParentSpanID, root.span.SpanId)
+	}
+	if len(h) != 1 || !strings.HasPrefix(h[0], root.TraceID()+"/") {
+		t.Errorf("server received trace headers %q; want a header for trace %s", h, root.TraceID())
+	}
+
+	<-serverExporter.exported
+	serverExporter.mu.Lock()
+	server := serverExporter.spans[0]
+	serverExporter.mu.Unlock()
+	if server.TraceID != root.trace.traceID {
+		t.Errorf("server span has trace ID %s; want %s", server.TraceID, root.trace.traceID)
+	}
+	for _, s := range []*SpanData{client, server} {
+		for k, want := range map[string]string{
+			labelGRPCStatusCode:   "OK",
+			labelGRPCSentMessages: "1",
+			labelGRPCRecvMessages: "1",
+			labelGRPCMethod:       "Stream",
+			labelGRPCService:      "test.Echo",
+		} {
+			if got := s.Labels[k]; got != want {
+				t.Errorf("%v span: label %s = %q; want %q", s.Kind, k, got, want)
+			}
+		}
+		if msgs := annotationMessages(s.Annotations); len(msgs) != 2 {
+			t.Errorf("%v span: annotations %q; want one for each message", s.Kind, msgs)
+		}
+	}
+}
+
+func TestGRPCStatsHandlerError(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+	h := NewGRPCStatsHandler(tc)
+
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := h.TagRPC(metadata.NewIncomingContext(context.Background(), md), &grpcstats.RPCTagInfo{FullMethodName: "/test.Service/Method"})
+	if !FromContext(ctx).Traced() {
+		t.Fatal("context of the call has no traced span")
+	}
+	h.HandleRPC(ctx, &grpcstats.End{Error: status.Error(codes.NotFound, "no such entity")})
+	<-e.exported
+	if got, want := e.spans[0].Labels[labelGRPCStatusCode], "NotFound"; got != want {
+		t.Errorf("status code label = %q; want %q", got, want)
+	}
+
+	// Calls without a trace header aren't traced.
+	if ctx := h.TagRPC(context.Background(), &grpcstats.RPCTagInfo{FullMethodName: "/test.Service/Method"}); FromContext(ctx) != nil {
+		t.Error("call without a trace header has a span")
+	}
+}
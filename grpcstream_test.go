@@ -0,0 +1,171 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+	"cloud.google.com/go/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// The tests in this file use only the exported API, and check the exported
+// spans with the tracetest matchers.
+
+// exportWait is how long the tests wait for the client to upload a trace
+// that it bundles in the background.
+const exportWait = 5 * time.Second
+
+// tracedStream is a grpc.ServerStream with a traced incoming call, that
+// receives n messages.
+type tracedStream struct {
+	grpc.ServerStream
+	n int
+}
+
+func (s *tracedStream) Context() context.Context {
+	md := metadata.Pairs("x-cloud-trace-context", "0123456789abcdef0123456789abcdef/1;o=1")
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func (s *tracedStream) RecvMsg(m interface{}) error {
+	if s.n == 0 {
+		return io.EOF
+	}
+	s.n--
+	return nil
+}
+
+func TestPerMessageSpans(t *testing.T) {
+	e := &tracetest.Exporter{}
+	tc := trace.NewClientWithExporter(e)
+
+	const method = "/test.Service/Stream"
+	interceptor := trace.GRPCStreamServerInterceptor(tc, trace.WithPerMessageSpans())
+	info := &grpc.StreamServerInfo{FullMethod: method, IsClientStream: true}
+	err := interceptor(nil, &tracedStream{n: 3}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		var wg sync.WaitGroup
+		for {
+			if err := ss.RecvMsg(nil); err == io.EOF {
+				break
+			}
+			ctx := trace.MessageContext(ss)
+			if ctx == ss.Context() {
+				t.Error("MessageContext returned the stream's context")
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				trace.FromContext(ctx).NewChild("work").Finish()
+			}()
+		}
+		wg.Wait()
+		if trace.MessageContext(ss) != ss.Context() {
+			t.Error("MessageContext after the end of the stream did not return the stream's context")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.WaitForSpans(7, exportWait)
+
+	// The stream span is named by the caller, which sent no name.
+	tracetest.AssertTree(t, e, `
+		""
+		  /test.Service/Stream/msg
+		    work
+		  /test.Service/Stream/msg
+		    work
+		  /test.Service/Stream/msg
+		    work
+	`)
+	tracetest.AssertSpan(t, e, tracetest.Name(""), tracetest.Root())
+	work := tracetest.AssertSpans(t, e, 3, tracetest.Name("work"), tracetest.ChildOf(method+"/msg"))
+	parents := map[uint64]bool{}
+	for _, s := range work {
+		parents[s.ParentSpanID] = true
+	}
+	if len(parents) != 3 {
+		t.Errorf("work spans have %d distinct parents; want 3", len(parents))
+	}
+}
+
+func TestStreamWithoutPerMessageSpans(t *testing.T) {
+	e := &tracetest.Exporter{}
+	tc := trace.NewClientWithExporter(e)
+
+	interceptor := trace.GRPCStreamServerInterceptor(tc)
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream", IsClientStream: true}
+	err := interceptor(nil, &tracedStream{n: 2}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		for ss.RecvMsg(nil) != io.EOF {
+			if trace.MessageContext(ss) != ss.Context() {
+				t.Error("MessageContext did not return the stream's context")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.WaitForSpans(1, exportWait)
+	tracetest.AssertTree(t, e, `""`)
+}
+
+func TestMessageContextKept(t *testing.T) {
+	tc := trace.NewClientWithExporter(&tracetest.Exporter{})
+	interceptor := trace.GRPCStreamServerInterceptor(tc, trace.WithPerMessageSpans())
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream", IsClientStream: true}
+	err := interceptor(nil, &tracedStream{n: 100}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		if err := ss.RecvMsg(nil); err != nil {
+			t.Fatal(err)
+		}
+		first := trace.MessageContext(ss)
+		firstSpan := trace.FromContext(first)
+
+		// A goroutine keeping the context of the first message reads it
+		// while later messages are received.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				if s := trace.FromContext(first); s != firstSpan {
+					t.Errorf("kept context has span %v; want the first message's span %v", s, firstSpan)
+					return
+				}
+			}
+		}()
+		for ss.RecvMsg(nil) != io.EOF {
+			if ctx := trace.MessageContext(ss); ctx == first || trace.FromContext(ctx) == firstSpan {
+				t.Error("a later message has the context of the first message")
+			}
+		}
+		<-done
+
+		if trace.FromContext(first) != firstSpan {
+			t.Error("after the last message, the kept context does not contain the first message's span")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "time"
+
+// SpansFromHeaders returns a new span named name for each of a batch of
+// incoming messages with the given trace context headers, such as the
+// messages of one pull from a queue.  It is like calling SpanFromHeader for
+// each header, but cheaper: the sampling policies returned by
+// NewLimitedSampler and NewAdjustableSampler decide on the whole batch at
+// once.  As with SpanFromHeader, headers with the o=1 option force tracing.
+//
+// The span of headers[i] is the i'th element of the returned slice.  Unlike
+// SpanFromHeader, which starts a new trace for a header that can't be
+// parsed, SpansFromHeaders leaves the spans of empty and malformed headers
+// nil; the nil spans can be used like any other.  If c is nil, all the spans
+// are nil.
+//
+// The spans of a batch all start at the same time.  They are allocated
+// together, so the memory of all of them is held until none is in use.
+func (c *Client) SpansFromHeaders(name string, headers []string) []*Span {
+	spans := make([]*Span, len(headers))
+	if c == nil {
+		return spans
+	}
+	bs, bulk := c.samplingPolicy().(batchSampler)
+	if c.samplerThreshold > 0 || c.samplerTimeout > 0 {
+		// The sampler is timed, one decision at a time.
+		bulk = false
+	}
+	// Allocate the spans and traces of the batch together, and start them
+	// at the same time.
+	start := time.Now()
+	spanArray, traceArray := make([]Span, len(headers)), make([]trace, len(headers))
+	var pending []*Span // the spans whose sampling decisions are made in bulk.
+	for i, h := range headers {
+		traceID, spanID, options, extra, err := parseHeaderErr(h)
+		if err != nil {
+			continue
+		}
+		sc := SpanContext{TraceID: traceID, SpanID: spanID, Traced: options&optionTrace != 0, options: options, extra: extra}
+		span := c.initRemoteRoot(&spanArray[i], &traceArray[i], name, sc, true, start)
+		spans[i] = span
+		if !bulk || span.trace.forced {
+			c.startRoot(span, true)
+		} else if c.beginRoot(span) {
+			if pending == nil {
+				pending = make([]*Span, 0, len(headers)-i)
+			}
+			pending = append(pending, span)
+		}
+	}
+	if len(pending) == 0 {
+		return spans
+	}
+	decisions := make([]Decision, len(pending))
+	bs.sampleBatch(Parameters{HasTraceHeader: true, Name: name}, pending, decisions)
+	for i, span := range pending {
+		configureSpanFromDecision(span, decisions[i], 0)
+		c.admitRoot(span)
+	}
+	return spans
+}
+
+// A batchSampler is a SamplingPolicy that can make many decisions at once
+// more cheaply than one at a time.
+type batchSampler interface {
+	// sampleBatch sets each element of ds to the decision of a call to Sample
+	// with p, with the TraceID of the trace of the corresponding element of
+	// spans.
+	sampleBatch(p Parameters, spans []*Span, ds []Decision)
+}
+
+func (s *sampler) sampleBatch(p Parameters, spans []*Span, ds []Decision) {
+	s.Lock()
+	now := s.now()
+	for i := range ds {
+		p.TraceID = spans[i].trace.traceID
+		ds[i] = s.sample(p, now, s.draw(p))
+	}
+	s.Unlock()
+}
+
+func (a *AdjustableSampler) sampleBatch(p Parameters, spans []*Span, ds []Decision) {
+	a.s.sampleBatch(p, spans, ds)
+}
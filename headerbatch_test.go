@@ -0,0 +1,172 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSpansFromHeaders(t *testing.T) {
+	const traceID = "0123456789ABCDEF0123456789ABCDEF"
+	headers := []string{
+		traceID + "/1;o=1",
+		"",
+		traceID + "/2;o=0",
+		"not a header",
+		traceID + "/x;o=1",
+		"00-0123456789abcdef0123456789abcdef-0000000000000003-01",
+	}
+	wantParents := []uint64{1, 0, 2, 0, 0, 3}
+	wantValid := []bool{true, false, true, false, false, true}
+
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(alwaysTrace{})
+	spans := tc.SpansFromHeaders("/consume", headers)
+	if len(spans) != len(headers) {
+		t.Fatalf("got %d spans; want %d", len(spans), len(headers))
+	}
+	for i, span := range spans {
+		if !wantValid[i] {
+			if span != nil {
+				t.Errorf("header %q: got span %v; want nil", headers[i], span)
+			}
+			continue
+		}
+		if span == nil {
+			t.Errorf("header %q: got nil span", headers[i])
+			continue
+		}
+		if got := span.TraceID(); got != traceID && got != "0123456789abcdef0123456789abcdef" {
+			t.Errorf("header %q: trace ID %q; want %q", headers[i], got, traceID)
+		}
+		if got := span.span.ParentSpanId; got != wantParents[i] {
+			t.Errorf("header %q: parent span ID %d; want %d", headers[i], got, wantParents[i])
+		}
+		if got := span.span.Name; got != "/consume" {
+			t.Errorf("header %q: name %q; want /consume", headers[i], got)
+		}
+		if !span.traced() {
+			t.Errorf("header %q: span not traced", headers[i])
+		}
+	}
+	if got := tc.Stats().SpansStarted; got != 3 {
+		t.Errorf("SpansStarted = %d; want 3", got)
+	}
+
+	if got := (*Client)(nil).SpansFromHeaders("/consume", headers); len(got) != len(headers) || got[0] != nil {
+		t.Errorf("nil client: got %v; want %d nil spans", got, len(headers))
+	}
+}
+
+// TestSpansFromHeadersSampling compares the decisions of a rate-limited
+// sampler on a batch with its decisions on the same headers one at a time.
+func TestSpansFromHeadersSampling(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	clock := func() time.Time { return now }
+	headers := make([]string, 20)
+	for i := range headers {
+		headers[i] = fmt.Sprintf("0123456789ABCDEF0123456789ABCDEF/%d;o=0", i+1)
+	}
+	count := func(spans []*Span) (n int) {
+		for _, s := range spans {
+			if s.traced() {
+				n++
+			}
+		}
+		return n
+	}
+
+	for _, adjustable := range []bool{false, true} {
+		newClient := func() *Client {
+			tc := NewClientWithExporter(&recordingExporter{})
+			var p SamplingPolicy
+			var err error
+			if adjustable {
+				p, err = NewAdjustableSampler(0, 5, WithClock(clock), WithRandSource(rand.NewSource(1)))
+			} else {
+				p, err = NewLimitedSampler(0, 5, WithClock(clock), WithRandSource(rand.NewSource(1)))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.SetSamplingPolicy(p)
+			return tc
+		}
+
+		tc := newClient()
+		var loop []*Span
+		for _, h := range headers {
+			loop = append(loop, tc.SpanFromHeader("/consume", h))
+		}
+		batch := newClient().SpansFromHeaders("/consume", headers)
+		// The rate limit allows a burst of 6 spans.
+		if got, want := count(batch), count(loop); got != want || got != 6 {
+			t.Errorf("adjustable %t: traced %d spans of the batch and %d one at a time; want 6", adjustable, got, want)
+		}
+		for i := range batch {
+			if batch[i].traced() != loop[i].traced() {
+				t.Errorf("adjustable %t: span %d traced %t in the batch, %t one at a time", adjustable, i, batch[i].traced(), loop[i].traced())
+			}
+		}
+	}
+
+	// Shutting down clients trace none of the batch.
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(alwaysTrace{})
+	tc.drain.start()
+	if n := count(tc.SpansFromHeaders("/consume", headers)); n != 0 {
+		t.Errorf("shutting down: traced %d spans; want none", n)
+	}
+}
+
+// benchHeaders are the headers of a large batch of messages.
+var benchHeaders = func() []string {
+	h := make([]string, 1000)
+	for i := range h {
+		h[i] = fmt.Sprintf("0123456789ABCDEF0123456789ABCDEF/%d;o=0", i+1)
+	}
+	return h
+}()
+
+func newBenchHeaderClient(b *testing.B) *Client {
+	tc := NewClientWithExporter(&recordingExporter{})
+	p, err := NewLimitedSampler(0.01, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	tc.SetSamplingPolicy(p)
+	return tc
+}
+
+func BenchmarkSpanFromHeaderLoop(b *testing.B) {
+	tc := newBenchHeaderClient(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, h := range benchHeaders {
+			tc.SpanFromHeader("/consume", h)
+		}
+	}
+}
+
+func BenchmarkSpansFromHeaders(b *testing.B) {
+	tc := newBenchHeaderClient(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tc.SpansFromHeaders("/consume", benchHeaders)
+	}
+}
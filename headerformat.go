@@ -0,0 +1,99 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A HeaderFormat is a set of trace header formats.
+type HeaderFormat int
+
+const (
+	// GoogleHeader is the x-cloud-trace-context header of Stackdriver
+	// Trace.
+	GoogleHeader HeaderFormat = 1 << iota
+	// TraceparentHeader is the traceparent header of W3C Trace Context,
+	// of the form 00-<trace ID>-<span ID>-<flags>.
+	TraceparentHeader
+	// B3Header is the set of X-B3-TraceId, X-B3-SpanId and X-B3-Sampled
+	// headers of Zipkin.
+	B3Header
+	// B3SingleHeader is the single b3 header of Zipkin, of the form
+	// <trace ID>-<span ID>-<sampled>.
+	B3SingleHeader
+)
+
+// WithHeaderFormats returns an InterceptorOption that sets the formats of
+// the trace headers used by the gRPC interceptors.  The client interceptors
+// send a header in each of the formats, and the server interceptors read the
+// first of them, in the order above, that is present in an incoming call.
+// Without the option, or if formats is empty, only GoogleHeader is used.
+// It is a shorthand for WithPropagators with the propagators of formats.
+//
+// The sampled flags of traceparent and B3 headers are the tracing option of
+// the Google header: a span read from a header with the flag set is traced,
+// and the header of a traced span has it set.  The other options of the
+// Google header are not sent in the other formats.  A trace can enter a
+// process in one format and leave it in another:
+//
+//   s := grpc.NewServer(trace.GRPCServerOptions(tc, trace.WithHeaderFormats(trace.TraceparentHeader))...)
+//   conn, err := grpc.Dial(addr, grpc.WithUnaryInterceptor(trace.GRPCClientInterceptor()))
+//
+// A 64-bit B3 trace ID is padded with leading zeros to the 128 bits of the
+// other formats, and a trace ID whose first 64 bits are zero is sent in B3
+// headers as 64 bits, so a trace that comes from a service using 64-bit
+// trace IDs keeps its ID when it goes back to one.
+//
+// The option doesn't apply to HTTP clients, which send only the Google
+// header.
+func WithHeaderFormats(formats HeaderFormat) InterceptorOption {
+	var propagators []Propagator
+	for _, f := range headerFormats {
+		if formats&f.format != 0 {
+			propagators = append(propagators, f.propagator)
+		}
+	}
+	return withPropagators{propagators: propagators}
+}
+
+// headerFormats are the formats of HeaderFormat, in order.
+var headerFormats = []struct {
+	format     HeaderFormat
+	name       string
+	propagator Propagator
+}{
+	{GoogleHeader, "google", GooglePropagator},
+	{TraceparentHeader, "traceparent", TraceparentPropagator},
+	{B3Header, "b3", B3Propagator},
+	{B3SingleHeader, "b3single", B3SinglePropagator},
+}
+
+// String returns the names of the formats of f, separated by "|", for
+// example "google|traceparent".
+func (f HeaderFormat) String() string {
+	var names []string
+	for _, hf := range headerFormats {
+		if f&hf.format != 0 {
+			names = append(names, hf.name)
+			f &^= hf.format
+		}
+	}
+	if f != 0 || len(names) == 0 {
+		names = append(names, fmt.Sprintf("HeaderFormat(%d)", int(f)))
+	}
+	return strings.Join(names, "|")
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+const (
+	labelHedgeAttempt   = `hedge/attempt`
+	labelHedgeAttempts  = `hedge/attempts`
+	labelHedgeCancelled = `hedge/cancelled`
+	labelHedgeWinner    = `hedge/winner`
+	labelHedgeWon       = `hedge/won`
+)
+
+// A HedgedGroup traces the attempts of a hedged operation: one logical
+// operation that is performed by issuing several concurrent attempts and
+// using the result of whichever finishes first.
+//
+// The group has an umbrella span covering the whole operation, and each
+// attempt has a child span of the umbrella span labeled with its attempt
+// number.
+//
+//   g := trace.NewHedgedGroup(ctx, "storage.Read")
+//   go read(g.Attempt(0))
+//   time.AfterFunc(50*time.Millisecond, func() { read(g.Attempt(1)) })
+//   ...
+//   g.Won(i) // attempt i returned first.
+type HedgedGroup struct {
+	ctx  context.Context
+	name string
+	span *Span
+
+	mu       sync.Mutex
+	attempts map[int]*Span
+	done     bool
+}
+
+// NewHedgedGroup returns a HedgedGroup whose umbrella span, named name, is a
+// child of the span in ctx.  If ctx contains no span, the group does nothing
+// and Attempt returns ctx.
+func NewHedgedGroup(ctx context.Context, name string) *HedgedGroup {
+	return &HedgedGroup{
+		ctx:      ctx,
+		name:     name,
+		span:     FromContext(ctx).NewChild(name),
+		attempts: make(map[int]*Span),
+	}
+}
+
+// Attempt returns a context for making attempt i of the operation.  The
+// context contains a child span of the umbrella span, labeled with the
+// attempt number, so RPCs made with it are nested under the attempt.
+//
+// Calling Attempt again with the same i returns a context containing the
+// same span.  Attempt must not be called after Won or Finish.
+func (g *HedgedGroup) Attempt(i int) context.Context {
+	if g.span == nil {
+		return g.ctx
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, ok := g.attempts[i]
+	if !ok && !g.done {
+		s = g.span.NewChild(g.name)
+		s.setLabel(labelHedgeAttempt, strconv.Itoa(i))
+		g.attempts[i] = s
+	}
+	return NewContext(g.ctx, s)
+}
+
+// Won declares that attempt i provides the result of the operation.  The
+// span of attempt i is labeled as the winner, the spans of all other
+// attempts are labeled as cancelled, and all the spans of the group,
+// including the umbrella span, are finished.
+//
+// Only the first call to Won or Finish has any effect.
+func (g *HedgedGroup) Won(i int) {
+	g.finish(i, true)
+}
+
+// Finish finishes the spans of the group without declaring a winner, for
+// example because all attempts failed.
+//
+// Only the first call to Won or Finish has any effect.
+func (g *HedgedGroup) Finish() {
+	g.finish(0, false)
+}
+
+func (g *HedgedGroup) finish(winner int, won bool) {
+	if g.span == nil {
+		return
+	}
+	g.mu.Lock()
+	if g.done {
+		g.mu.Unlock()
+		return
+	}
+	g.done = true
+	attempts := g.attempts
+	g.mu.Unlock()
+
+	for i, s := range attempts {
+		if won && i == winner {
+			s.setLabel(labelHedgeWon, "true")
+		} else if won {
+			s.setLabel(labelHedgeCancelled, "true")
+		}
+		s.Finish()
+	}
+	g.span.setLabel(labelHedgeAttempts, strconv.Itoa(len(attempts)))
+	if won {
+		g.span.setLabel(labelHedgeWinner, strconv.Itoa(winner))
+	}
+	g.span.Finish()
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHedgedGroup(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+
+	g := NewHedgedGroup(ctx, "storage.Read")
+	var wg sync.WaitGroup
+	results := make(chan int, 2)
+	attempt := func(i int, d time.Duration) {
+		defer wg.Done()
+		ctx := g.Attempt(i)
+		// Work done for the attempt is nested under the attempt's span.
+		s := FromContext(ctx).NewChild("rpc")
+		time.Sleep(d)
+		s.Finish()
+		results <- i
+	}
+	wg.Add(2)
+	go attempt(0, 100*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	go attempt(1, time.Millisecond)
+	g.Won(<-results)
+	wg.Wait()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	var umbrella *SpanData
+	for _, s := range e.spans {
+		if s.Name == "storage.Read" && s.Labels["hedge/attempt"] == "" {
+			umbrella = s
+		}
+	}
+	if umbrella == nil {
+		t.Fatal("no umbrella span exported")
+	}
+	if got, want := umbrella.ParentSpanID, root.span.SpanId; got != want {
+		t.Errorf("umbrella parent = %d; want root span %d", got, want)
+	}
+	if got, want := umbrella.Labels["hedge/winner"], "1"; got != want {
+		t.Errorf("umbrella hedge/winner = %q; want %q", got, want)
+	}
+	if got, want := umbrella.Labels["hedge/attempts"], "2"; got != want {
+		t.Errorf("umbrella hedge/attempts = %q; want %q", got, want)
+	}
+
+	attempts := map[string]*SpanData{}
+	for _, s := range e.spans {
+		if a, ok := s.Labels["hedge/attempt"]; ok {
+			attempts[a] = s
+			if s.ParentSpanID != umbrella.SpanID {
+				t.Errorf("attempt %s parent = %d; want umbrella %d", a, s.ParentSpanID, umbrella.SpanID)
+			}
+		}
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("got %d attempt spans; want 2", len(attempts))
+	}
+	if got := attempts["1"].Labels["hedge/won"]; got != "true" {
+		t.Errorf("attempt 1 hedge/won = %q; want true", got)
+	}
+	if _, ok := attempts["1"].Labels["hedge/cancelled"]; ok {
+		t.Errorf("winning attempt is labeled cancelled")
+	}
+	if got := attempts["0"].Labels["hedge/cancelled"]; got != "true" {
+		t.Errorf("attempt 0 hedge/cancelled = %q; want true", got)
+	}
+	for _, s := range e.spans {
+		if s.Name != "rpc" {
+			continue
+		}
+		found := false
+		for _, a := range attempts {
+			found = found || s.ParentSpanID == a.SpanID
+		}
+		if !found {
+			t.Errorf("rpc span parent %d is not an attempt span", s.ParentSpanID)
+		}
+	}
+}
+
+func TestHedgedGroupNoSpan(t *testing.T) {
+	ctx := context.Background()
+	g := NewHedgedGroup(ctx, "storage.Read")
+	if got := g.Attempt(0); got != ctx {
+		t.Errorf("Attempt returned a new context without a span in the parent")
+	}
+	g.Won(0)
+	g.Finish()
+}
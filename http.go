@@ -18,13 +18,30 @@ package trace
 
 import "net/http"
 
+const labelRedirectFrom = `http/redirect_from`
+
 type tracerTransport struct {
-	base http.RoundTripper
+	base      http.RoundTripper
+	tlsLabels bool
+
+	dependencyNamer     func(*http.Request) string // for WithDependencyNamer, or nil.
+	dependencySpanNames bool
 }
 
 func (tt *tracerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	span := FromContext(req.Context()).NewRemoteChild(req)
+	setHTTPHeader(req.Context(), req, span)
+	if req.Response != nil && req.Response.Request != nil {
+		// req follows a redirect; link it to the request that was redirected.
+		span.setLabel(labelRedirectFrom, req.Response.Request.URL.String())
+	}
+	if tt.dependencyNamer != nil {
+		setDependency(span, req, tt.dependencyNamer, tt.dependencySpanNames)
+	}
 	resp, err := tt.base.RoundTrip(req)
+	if tt.tlsLabels && resp != nil {
+		setTLSLabels(span, resp.TLS)
+	}
 
 	// TODO(jbd): Is it possible to defer the span.Finish?
 	// In cases where RoundTrip panics, we still can finish the span.
@@ -44,6 +61,15 @@ type HTTPClient struct {
 //
 // If req.Context() contains a traced *Span, the outgoing request
 // is traced with the existing span. If not, the request is not traced.
+//
+// Each request made to follow a redirect gets its own child span, with its
+// status code and an "http/redirect_from" label holding the URL of the
+// request that was redirected.  To group the requests of one call under a
+// single span, make it the span of the request's context:
+//
+//   span := trace.FromContext(ctx).NewChild("fetch")
+//   resp, err := client.Do(req.WithContext(trace.NewContext(ctx, span)))
+//   span.Finish()
 func (c *HTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return c.Client.Do(req)
 }
@@ -51,24 +77,48 @@ func (c *HTTPClient) Do(req *http.Request) (*http.Response, error) {
 // NewHTTPClient creates a new HTTPClient that will trace the outgoing
 // requests using tc. The attributes of this client are inherited from the
 // given http.Client. If orig is nil, http.DefaultClient is used.
-func (c *Client) NewHTTPClient(orig *http.Client) *HTTPClient {
-	if orig == nil {
-		orig = http.DefaultClient
+//
+// Of the InterceptorOptions, only WithTLSLabels, WithDependencyNamer and
+// WithDependencySpanNames affect the HTTP client.
+func (c *Client) NewHTTPClient(orig *http.Client, opts ...InterceptorOption) *HTTPClient {
+	return &HTTPClient{
+		Client:      *WrapHTTPClient(orig, opts...),
+		traceClient: c,
 	}
-	rt := orig.Transport
+}
+
+// WrapHTTPClient returns a copy of c whose requests are traced: requests
+// whose context contains a traced *Span get a child span and carry the
+// trace header.  The copy sends requests using the Transport of c, or
+// http.DefaultTransport if it is nil, and keeps its Jar, Timeout and
+// CheckRedirect.  c is not modified.  If c is nil, a new http.Client with
+// the default settings is wrapped.
+//
+// Of the InterceptorOptions, only WithTLSLabels, WithDependencyNamer and
+// WithDependencySpanNames affect the HTTP client.
+func WrapHTTPClient(c *http.Client, opts ...InterceptorOption) *http.Client {
+	config := newInterceptorConfig(opts)
+	if c == nil {
+		c = &http.Client{}
+	}
+	rt := c.Transport
 	if rt == nil {
 		rt = http.DefaultTransport
 	}
-	client := http.Client{
-		Transport:     &tracerTransport{base: rt},
-		CheckRedirect: orig.CheckRedirect,
-		Jar:           orig.Jar,
-		Timeout:       orig.Timeout,
-	}
-	return &HTTPClient{
-		Client:      client,
-		traceClient: c,
+	wrapped := *c
+	wrapped.Transport = &tracerTransport{
+		base:                rt,
+		tlsLabels:           config.tlsLabels,
+		dependencyNamer:     config.dependencyNamer,
+		dependencySpanNames: config.dependencySpanNames,
 	}
+	return &wrapped
+}
+
+// DefaultHTTPClient returns a new http.Client with the default settings
+// whose requests are traced.  It is equivalent to WrapHTTPClient(nil).
+func DefaultHTTPClient() *http.Client {
+	return WrapHTTPClient(nil)
 }
 
 // HTTPHandler returns a http.Handler from the given handler
@@ -78,20 +128,35 @@ func (c *Client) NewHTTPClient(orig *http.Client) *HTTPClient {
 //
 //    span := trace.FromContext(r.Context())
 //
-// The span will be auto finished by the handler.
-func (c *Client) HTTPHandler(h http.Handler) http.Handler {
-	return &handler{traceClient: c, handler: h}
+// The span will be auto finished by the handler.  It is labeled with the
+// status code of the response, which is 200 if the handler doesn't call
+// WriteHeader.  The http.ResponseWriter passed to h implements http.Flusher
+// and http.Hijacker if the server's does.
+//
+// Of the InterceptorOptions, only WithResponseTraceHeader affects the HTTP
+// handler.
+func (c *Client) HTTPHandler(h http.Handler, opts ...InterceptorOption) http.Handler {
+	return &handler{traceClient: c, handler: h, config: newInterceptorConfig(opts)}
 }
 
 type handler struct {
 	traceClient *Client
 	handler     http.Handler
+	config      *interceptorConfig
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	span := h.traceClient.SpanFromRequest(r)
 	defer span.Finish()
+	h.config.setResponseTraceHeader(w.Header(), span)
 
 	r = r.WithContext(NewContext(r.Context(), span))
+	if span.traced() || span.reportsOutcome() {
+		var sw *statusWriter
+		w, sw = wrapResponseWriter(w)
+		defer func() { span.statusCode = sw.code() }()
+	}
+	defer h.traceClient.startDBPoolLabels(span, h.config).setLabels(span)
 	h.handler.ServeHTTP(w, r)
+	setCancelCause(span, r.Context())
 }
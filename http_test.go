@@ -22,6 +22,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type noopTransport struct{}
@@ -88,6 +89,47 @@ func TestNewHTTPClient(t *testing.T) {
 	})
 }
 
+func TestWrapHTTPClient(t *testing.T) {
+	rt := &recorderTransport{ch: make(chan *http.Request, 1)}
+	checkRedirect := func(*http.Request, []*http.Request) error { return nil }
+	orig := &http.Client{
+		Transport:     rt,
+		CheckRedirect: checkRedirect,
+		Timeout:       time.Minute,
+	}
+	client := WrapHTTPClient(orig)
+	if client == orig {
+		t.Fatal("WrapHTTPClient returned its argument")
+	}
+	if orig.Transport != rt {
+		t.Errorf("original client's transport was replaced")
+	}
+	if client.Timeout != time.Minute || client.CheckRedirect == nil {
+		t.Errorf("wrapped client didn't keep Timeout and CheckRedirect")
+	}
+
+	tc := newTestClient(&noopTransport{})
+	span := tc.NewSpan("/foo")
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if _, err := client.Do(req.WithContext(NewContext(req.Context(), span))); err != nil {
+		t.Fatal(err)
+	}
+	outgoing := <-rt.ch
+	if got := outgoing.Header.Get(httpHeader); !strings.HasPrefix(got, span.TraceID()+"/") {
+		t.Errorf("got trace header = %q; want trace %s", got, span.TraceID())
+	}
+
+	for _, c := range []*http.Client{WrapHTTPClient(nil), DefaultHTTPClient()} {
+		tt, ok := c.Transport.(*tracerTransport)
+		if !ok || tt.base != http.DefaultTransport {
+			t.Errorf("got transport %#v; want a traced http.DefaultTransport", c.Transport)
+		}
+	}
+	if http.DefaultClient.Transport != nil {
+		t.Errorf("http.DefaultClient was modified")
+	}
+}
+
 func TestHTTPHandlerNoTrace(t *testing.T) {
 	tc := newTestClient(&noopTransport{})
 	client := tc.NewHTTPClient(&http.Client{})
@@ -107,3 +149,50 @@ func TestHTTPHandlerNoTrace(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestHTTPClientRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusFound))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusMovedPermanently))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) {})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	client := tc.NewHTTPClient(nil)
+	root := tc.NewSpan("/root")
+	req, _ := http.NewRequest("GET", ts.URL+"/a", nil)
+	resp, err := client.Do(req.WithContext(NewContext(req.Context(), root)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	for _, tt := range []struct {
+		path, status, from string
+	}{
+		{"/a", "302", ""},
+		{"/b", "301", ts.URL + "/a"},
+		{"/c", "200", ts.URL + "/b"},
+	} {
+		s := e.span(host + tt.path)
+		if s == nil {
+			t.Errorf("no span for %s", tt.path)
+			continue
+		}
+		if s.ParentSpanID != root.span.SpanId {
+			t.Errorf("%s: parent span ID = %d; want %d", tt.path, s.ParentSpanID, root.span.SpanId)
+		}
+		if got := s.Labels[labelStatusCode]; got != tt.status {
+			t.Errorf("%s: status code = %q; want %q", tt.path, got, tt.status)
+		}
+		if got := s.Labels[labelRedirectFrom]; got != tt.from {
+			t.Errorf("%s: %s = %q; want %q", tt.path, labelRedirectFrom, got, tt.from)
+		}
+	}
+}
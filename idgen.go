@@ -0,0 +1,94 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"sync"
+)
+
+// An IDGenerator makes the IDs of the new traces and spans of a client.  Its
+// methods may be called concurrently.
+type IDGenerator interface {
+	// NewTraceID returns a trace ID: 32 hexadecimal digits, not all zero.
+	NewTraceID() string
+
+	// NewSpanID returns a span ID, which must not be zero: a zero parent
+	// span ID means that a span has no parent.
+	NewSpanID() uint64
+}
+
+// SetIDGenerator makes the client use g to make the IDs of its new traces
+// and spans, instead of reading them from crypto/rand.  It is meant for tests
+// that need deterministic IDs.  A trace ID returned by g that isn't valid, a
+// zero span ID, and a span ID equal to the ID of the new span's parent are
+// replaced with random IDs.  Spans read from incoming requests keep the trace
+// IDs of their requests.
+//
+// SetIDGenerator should be called before any spans are created.
+func (c *Client) SetIDGenerator(g IDGenerator) {
+	if c != nil {
+		c.ids = g
+	}
+}
+
+// newTraceID returns the ID of a new trace of c.
+func (c *Client) newTraceID() string {
+	if c != nil && c.ids != nil {
+		if id := c.ids.NewTraceID(); validTraceID(id) {
+			return strings.ToLower(id)
+		}
+	}
+	return nextTraceID()
+}
+
+// newSpanID returns the ID of a new span of c.  It never returns zero.
+func (c *Client) newSpanID() uint64 {
+	if c != nil && c.ids != nil {
+		if id := c.ids.NewSpanID(); id != 0 {
+			return id
+		}
+	}
+	return nextSpanID()
+}
+
+// An idBuffer holds random bytes read from crypto/rand, so that a read
+// serves many IDs.
+type idBuffer struct {
+	b    [512]byte
+	used int
+}
+
+var idBuffers = sync.Pool{
+	New: func() interface{} { return &idBuffer{used: len(idBuffer{}.b)} },
+}
+
+// randomUint64 returns a random number read from crypto/rand.  It returns
+// false if crypto/rand failed.
+func randomUint64() (uint64, bool) {
+	buf := idBuffers.Get().(*idBuffer)
+	defer idBuffers.Put(buf)
+	if buf.used+8 > len(buf.b) {
+		if _, err := rand.Read(buf.b[:]); err != nil {
+			return 0, false
+		}
+		buf.used = 0
+	}
+	n := binary.LittleEndian.Uint64(buf.b[buf.used:])
+	buf.used += 8
+	return n, true
+}
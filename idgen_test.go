@@ -0,0 +1,165 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubIDs is an IDGenerator that returns the given IDs in turn, and then
+// zero IDs.
+type stubIDs struct {
+	mu       sync.Mutex
+	traceIDs []string
+	spanIDs  []uint64
+}
+
+func (s *stubIDs) NewTraceID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.traceIDs) == 0 {
+		return ""
+	}
+	id := s.traceIDs[0]
+	s.traceIDs = s.traceIDs[1:]
+	return id
+}
+
+func (s *stubIDs) NewSpanID() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.spanIDs) == 0 {
+		return 0
+	}
+	id := s.spanIDs[0]
+	s.spanIDs = s.spanIDs[1:]
+	return id
+}
+
+func TestSetIDGenerator(t *testing.T) {
+	const traceID = "0000000000000000000000000000000a"
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetIDGenerator(&stubIDs{
+		traceIDs: []string{"0000000000000000000000000000000A", "00000000000000000000000000000000", "not an ID"},
+		spanIDs:  []uint64{1, 2, 2},
+	})
+	if !tc.Config().IDGenerator {
+		t.Error("Config().IDGenerator = false; want true")
+	}
+
+	root := tc.NewSpan("/root")
+	if got := root.TraceID(); got != traceID {
+		t.Errorf("root trace ID %q; want %q", got, traceID)
+	}
+	child := root.NewChild("/child")
+	if root.span.SpanId != 1 || child.span.SpanId != 2 {
+		t.Errorf("span IDs %d, %d; want 1, 2", root.span.SpanId, child.span.SpanId)
+	}
+	// The next span ID is its parent's, so it is replaced.
+	if got := child.NewChild("/grandchild").span.SpanId; got == 2 || got == 0 {
+		t.Errorf("grandchild span ID %d; want a random ID", got)
+	}
+	// The generator now returns zero span IDs and invalid trace IDs.
+	for i := 0; i < 3; i++ {
+		s := tc.NewSpan("/root")
+		if id := s.TraceID(); !validTraceID(id) || id == traceID {
+			t.Errorf("trace ID %q; want a random ID", id)
+		}
+		if s.span.SpanId == 0 {
+			t.Error("span ID 0; want a random ID")
+		}
+	}
+
+	// Incoming requests keep their trace IDs.
+	const header = "0123456789abcdef0123456789abcdef/1;o=1"
+	if got := tc.SpanFromHeader("/server", header).TraceID(); got != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("server span trace ID %q; want the header's", got)
+	}
+}
+
+// constantIDs is an IDGenerator that always returns the same IDs.
+type constantIDs struct{}
+
+func (constantIDs) NewTraceID() string { return "0000000000000000000000000000000a" }
+func (constantIDs) NewSpanID() uint64  { return 7 }
+
+func TestConstantIDGenerator(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetIDGenerator(constantIDs{})
+	root := tc.NewSpan("/root")
+	done := make(chan *Span)
+	go func() { done <- root.NewChild("/child") }()
+	select {
+	case child := <-done:
+		if root.span.SpanId != 7 {
+			t.Errorf("root span ID %d; want 7", root.span.SpanId)
+		}
+		if got := child.span.SpanId; got == 7 || got == 0 {
+			t.Errorf("child span ID %d; want a random ID", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("NewChild didn't return with a generator returning its parent's ID")
+	}
+}
+
+func TestIDCollisions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates millions of IDs")
+	}
+	const goroutines, perGoroutine = 4, 500000
+	spanIDs := make([][]uint64, goroutines)
+	traceIDs := make([][]string, goroutines)
+	var wg sync.WaitGroup
+	for i := range spanIDs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				spanIDs[i] = append(spanIDs[i], nextSpanID())
+				if j%4 == 0 {
+					traceIDs[i] = append(traceIDs[i], nextTraceID())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seenSpans := make(map[uint64]bool, goroutines*perGoroutine)
+	for _, ids := range spanIDs {
+		for _, id := range ids {
+			if id == 0 {
+				t.Fatal("got span ID 0")
+			}
+			if seenSpans[id] {
+				t.Fatalf("span ID %d generated twice", id)
+			}
+			seenSpans[id] = true
+		}
+	}
+	seenTraces := make(map[string]bool, goroutines*perGoroutine/4)
+	for _, ids := range traceIDs {
+		for _, id := range ids {
+			if !validTraceID(id) {
+				t.Fatalf("invalid trace ID %q", id)
+			}
+			if seenTraces[id] {
+				t.Fatalf("trace ID %s generated twice", id)
+			}
+			seenTraces[id] = true
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"time"
+)
+
+const labelGRPCIdleTimeout = `grpc/idle_timeout`
+
+type withStreamIdleTimeout struct {
+	d      time.Duration
+	cancel bool
+}
+
+// WithStreamIdleTimeout returns an InterceptorOption that makes the stream
+// server interceptor label the span of a stream with "grpc/idle_timeout" set
+// to "true" if no call of SendMsg or RecvMsg on the stream completes for d,
+// for example because the client went away without closing the stream.
+//
+// If cancel is true, the context of the stream passed to the handler is also
+// cancelled, so that handlers that watch it can return and end the stream.
+func WithStreamIdleTimeout(d time.Duration, cancel bool) InterceptorOption {
+	return withStreamIdleTimeout{d: d, cancel: cancel}
+}
+
+func (o withStreamIdleTimeout) configureInterceptor(c *interceptorConfig) {
+	c.idleTimeout = o.d
+	c.idleCancel = o.cancel
+}
+
+// An idleTimer is the subset of *time.Timer used for idle timeouts.
+type idleTimer interface {
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// idleAfterFunc starts the idle timer of a stream.  Tests replace it with a
+// fake clock.
+var idleAfterFunc = func(d time.Duration, f func()) idleTimer {
+	return time.AfterFunc(d, f)
+}
+
+// startIdleTimer starts the idle timeout of s, and returns the context to
+// pass to the handler.
+func (s *ServerStreamWrapper) startIdleTimer(ctx context.Context, d time.Duration, cancel bool) context.Context {
+	if cancel {
+		ctx, s.cancel = context.WithCancel(ctx)
+	}
+	s.idleTimeout = d
+	s.idleTimer = idleAfterFunc(d, s.idle)
+	return ctx
+}
+
+// active records that a call on s completed, restarting its idle timeout.
+func (s *ServerStreamWrapper) active() {
+	if s.idleTimer != nil {
+		s.idleTimer.Reset(s.idleTimeout)
+	}
+}
+
+// idle is called when the idle timeout of s expires.
+func (s *ServerStreamWrapper) idle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return
+	}
+	s.span.setLabel(labelGRPCIdleTimeout, "true")
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// stopIdleTimer stops the idle timeout of s.  It is called when s finishes.
+func (s *ServerStreamWrapper) stopIdleTimer() {
+	s.mu.Lock()
+	s.finished = true
+	s.mu.Unlock()
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
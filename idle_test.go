@@ -0,0 +1,151 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// fakeIdleTimer is an idleTimer that fires when the test calls fire.
+type fakeIdleTimer struct {
+	f func()
+
+	mu      sync.Mutex
+	resets  int
+	stopped bool
+}
+
+func (t *fakeIdleTimer) Reset(time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.resets++
+	t.stopped = false
+	return true
+}
+
+func (t *fakeIdleTimer) Stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	return true
+}
+
+func (t *fakeIdleTimer) fire() {
+	t.mu.Lock()
+	stopped := t.stopped
+	t.mu.Unlock()
+	if !stopped {
+		t.f()
+	}
+}
+
+// useFakeIdleTimers makes the stream server interceptors send their idle
+// timers on the returned channel.  Call the returned function to restore the
+// real timers.
+func useFakeIdleTimers() (chan *fakeIdleTimer, func()) {
+	timers := make(chan *fakeIdleTimer, 1)
+	old := idleAfterFunc
+	idleAfterFunc = func(d time.Duration, f func()) idleTimer {
+		t := &fakeIdleTimer{f: f}
+		timers <- t
+		return t
+	}
+	return timers, func() { idleAfterFunc = old }
+}
+
+// stalledServerStream is a grpc.ServerStream whose RecvMsg blocks until
+// the stream is closed, like a stream whose client went away silently.
+type stalledServerStream struct {
+	*fakeServerStream
+	closed chan struct{}
+}
+
+func (s *stalledServerStream) RecvMsg(m interface{}) error {
+	<-s.closed
+	return context.Canceled
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	timers, restore := useFakeIdleTimers()
+	defer restore()
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+
+	interceptor := GRPCStreamServerInterceptor(tc, WithStreamIdleTimeout(time.Minute, true))
+	ss := &stalledServerStream{fakeServerStream: newTracedStream(0), closed: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() {
+		done <- interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+			go ss.RecvMsg(nil) // never returns until the stream is closed.
+			<-ss.Context().Done()
+			return ss.Context().Err()
+		})
+	}()
+	(<-timers).fire()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("handler returned %v; want %v", err, context.Canceled)
+	}
+	close(ss.closed)
+
+	select {
+	case <-e.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for export")
+	}
+	if got := e.spans[0].Labels[labelGRPCIdleTimeout]; got != "true" {
+		t.Errorf("%s label = %q; want %q", labelGRPCIdleTimeout, got, "true")
+	}
+}
+
+func TestStreamIdleTimeoutActive(t *testing.T) {
+	timers, restore := useFakeIdleTimers()
+	defer restore()
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+
+	interceptor := GRPCStreamServerInterceptor(tc, WithStreamIdleTimeout(time.Minute, false))
+	err := interceptor(nil, newTracedStream(3), &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		for i := 0; i < 3; i++ {
+			if err := ss.RecvMsg(nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	timer := <-timers
+	if timer.resets != 3 || !timer.stopped {
+		t.Errorf("timer reset %d times, stopped %t; want 3 resets and stopped", timer.resets, timer.stopped)
+	}
+	timer.f() // a timer that fires after the stream ends has no effect.
+
+	select {
+	case <-e.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for export")
+	}
+	if got, ok := e.spans[0].Labels[labelGRPCIdleTimeout]; ok {
+		t.Errorf("active stream has %s label %q", labelGRPCIdleTimeout, got)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"path"
+	"strings"
+)
+
+// IgnoreMethods returns an InterceptorOption that makes the interceptors not
+// trace the calls of some methods, such as health checks.  Each pattern is a
+// full method name, such as "/grpc.health.v1.Health/Check", or a pattern in
+// the syntax of path.Match, such as "/grpc.health.v1.Health/*" for all the
+// methods of a service.
+//
+// Calls of the ignored methods are passed to the handler or the invoker as
+// they are: no span is created, and no trace header is read or sent, even if
+// the calling context is traced.  The option applies to both the client and
+// the server interceptors.
+func IgnoreMethods(patterns ...string) InterceptorOption {
+	return ignoreMethods(patterns)
+}
+
+type ignoreMethods []string
+
+func (o ignoreMethods) configureInterceptor(c *interceptorConfig) {
+	for _, p := range o {
+		if strings.ContainsAny(p, `*?[\`) {
+			if _, err := path.Match(p, ""); err == nil {
+				c.ignoredPatterns = append(c.ignoredPatterns, p)
+				continue
+			}
+		}
+		// Malformed patterns match only themselves.
+		if c.ignoredMethods == nil {
+			c.ignoredMethods = make(map[string]bool)
+		}
+		c.ignoredMethods[p] = true
+	}
+}
+
+// WithMethodFilter returns an InterceptorOption that makes the interceptors
+// trace only the calls of the methods for which traced returns true.  traced
+// is called with the full method name of each call, such as
+// "/grpc.health.v1.Health/Check", and may be called concurrently.  The calls
+// of the other methods are passed on as IgnoreMethods passes the calls of
+// the methods it ignores.  The option applies to both the client and the
+// server interceptors, and is combined with IgnoreMethods: a call is traced
+// only if neither excludes it.
+func WithMethodFilter(traced func(method string) bool) InterceptorOption {
+	return withMethodFilter{traced}
+}
+
+type withMethodFilter struct {
+	traced func(method string) bool
+}
+
+func (o withMethodFilter) configureInterceptor(c *interceptorConfig) {
+	c.methodFilter = o.traced
+}
+
+// ignored reports whether the calls of method are not traced.
+func (c *interceptorConfig) ignored(method string) bool {
+	if c.methodFilter != nil && !c.methodFilter(method) {
+		return true
+	}
+	if c.ignoredMethods[method] {
+		return true
+	}
+	for _, p := range c.ignoredPatterns {
+		if ok, _ := path.Match(p, method); ok {
+			return true
+		}
+	}
+	return false
+}
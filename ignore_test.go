@@ -0,0 +1,142 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestIgnoreMethodsServer(t *testing.T) {
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	intercept := GRPCServerInterceptor(NewClientWithExporter(&recordingExporter{}), IgnoreMethods("/grpc.health.v1.Health/Check", "/test.Watcher/*", "/bad[/Method"))
+	for _, tt := range []struct {
+		method string
+		want   bool
+	}{
+		{"/grpc.health.v1.Health/Check", false},
+		{"/grpc.health.v1.Health/Watch", true},
+		{"/test.Watcher/Watch", false},
+		{"/test.Watcher/Sub/Watch", true},
+		{"/bad[/Method", false},
+		{"/test.Service/Method", true},
+	} {
+		var traced bool
+		intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			traced = FromContext(ctx) != nil
+			return nil, nil
+		})
+		if traced != tt.want {
+			t.Errorf("%s: traced = %v; want %v", tt.method, traced, tt.want)
+		}
+	}
+}
+
+func TestIgnoreMethodsStreamServer(t *testing.T) {
+	intercept := GRPCStreamServerInterceptor(NewClientWithExporter(&recordingExporter{}), IgnoreMethods("/test.Service/*"))
+	var traced bool
+	intercept(nil, newTracedStream(0), &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		_, traced = ss.(*ServerStreamWrapper)
+		traced = traced || FromContext(ss.Context()) != nil
+		return nil
+	})
+	if traced {
+		t.Error("ignored stream is traced")
+	}
+}
+
+func TestIgnoreMethodsClient(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	opt := IgnoreMethods("/grpc.health.v1.Health/Check")
+
+	var header []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		header = md[grpcMetadataKey]
+		return nil
+	}
+	if err := GRPCClientInterceptor(opt)(ctx, "/grpc.health.v1.Health/Check", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if header != nil {
+		t.Errorf("ignored call sent trace header %q", header)
+	}
+	cs, err := GRPCStreamClientInterceptor(opt)(ctx, &grpc.StreamDesc{}, nil, "/grpc.health.v1.Health/Check", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeClientStream{ctx: ctx}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cs.(*ClientStreamWrapper); ok {
+		t.Error("ignored stream is wrapped")
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.spans) != 1 {
+		t.Errorf("exported spans %v; want only /root", names(e.spans))
+	}
+}
+
+func TestWithMethodFilter(t *testing.T) {
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	filter := WithMethodFilter(func(method string) bool { return method != "/test.Service/Secret" })
+	intercept := GRPCServerInterceptor(NewClientWithExporter(&recordingExporter{}), filter, IgnoreMethods("/test.Service/Ignored"))
+	for _, tt := range []struct {
+		method string
+		want   bool
+	}{
+		{"/test.Service/Secret", false},
+		{"/test.Service/Ignored", false},
+		{"/test.Service/Method", true},
+	} {
+		var traced bool
+		intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			traced = FromContext(ctx) != nil
+			return nil, nil
+		})
+		if traced != tt.want {
+			t.Errorf("%s: traced = %v; want %v", tt.method, traced, tt.want)
+		}
+	}
+
+	var streamTraced bool
+	GRPCStreamServerInterceptor(NewClientWithExporter(&recordingExporter{}), filter)(nil, newTracedStream(0), &grpc.StreamServerInfo{FullMethod: "/test.Service/Secret"}, func(srv interface{}, ss grpc.ServerStream) error {
+		_, streamTraced = ss.(*ServerStreamWrapper)
+		return nil
+	})
+	if streamTraced {
+		t.Error("filtered stream is traced")
+	}
+
+	root := NewClientWithExporter(&recordingExporter{}).NewSpan("/root")
+	var header []string
+	GRPCClientInterceptor(filter)(NewContext(context.Background(), root), "/test.Service/Secret", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		header = md[grpcMetadataKey]
+		return nil
+	})
+	if header != nil {
+		t.Errorf("filtered call sent trace header %q", header)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "fmt"
+
+// maxInheritedLabels is the number of inherited labels a trace can hold.
+const maxInheritedLabels = 16
+
+// SetInheritedLabel sets a label on s, like SetLabel, and also on every span
+// of its trace that is created afterwards in this process, such as the
+// spans of database queries and downstream calls made while handling a
+// request:
+//
+//   span := trace.FromContext(ctx)
+//   span.SetInheritedLabel("tenant_id", tenant)
+//
+// The inherited labels are held by the trace, so they apply to all spans
+// created later, not only to the descendants of s.  Each span gets the
+// inherited labels at the time it is created: spans created before the call
+// don't get the label, and changing or deleting it with SetInheritedLabel,
+// by setting it to the empty value, doesn't affect spans that already have
+// it.  A child can override an inherited label with SetLabel.
+//
+// A trace holds at most 16 inherited labels; further keys are only set on
+// s, and with strict validation enabled, an error is reported to the error
+// handler.  It does nothing if s is nil or isn't traced.
+func (s *Span) SetInheritedLabel(key, value string) {
+	if s == nil || !s.tracing() {
+		return
+	}
+	s.SetLabel(key, value)
+	t := s.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old, _ := t.inherited.Load().(map[string]string)
+	_, ok := old[key]
+	if value == "" && !ok {
+		return
+	}
+	if !ok && len(old) >= maxInheritedLabels {
+		if c := t.client; c.strict {
+			c.reportError(fmt.Errorf("trace: trace %s already has %d inherited labels; label %q is not inherited", t.traceID, maxInheritedLabels, key))
+		}
+		return
+	}
+	labels := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		labels[k] = v
+	}
+	if value == "" {
+		delete(labels, key)
+	} else {
+		labels[key] = value
+	}
+	t.inherited.Store(labels)
+}
+
+// inherit sets the inherited labels of its trace on s, a new span.
+func (s *Span) inherit() {
+	labels, _ := s.trace.inherited.Load().(map[string]string)
+	for k, v := range labels {
+		s.setLabel(k, v)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// spanLabel returns the value of the label key stored on s.
+func spanLabel(s *Span, key string) (string, bool) {
+	s.spanMu.Lock()
+	defer s.spanMu.Unlock()
+	v, ok := s.span.Labels[key]
+	return v, ok
+}
+
+func TestSetInheritedLabel(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	if !root.tracing() {
+		t.Fatal("root span is not traced")
+	}
+	before := root.NewChild("before")
+	root.SetInheritedLabel("tenant", "a")
+	if got, _ := spanLabel(root, "tenant"); got != "a" {
+		t.Errorf("root label = %q; want %q", got, "a")
+	}
+	if _, ok := spanLabel(before, "tenant"); ok {
+		t.Error("child created before SetInheritedLabel has the label")
+	}
+
+	after := root.NewChild("after")
+	grandchild := after.NewChild("grandchild")
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	remote := root.NewRemoteChild(req)
+	for _, s := range []*Span{after, grandchild, remote} {
+		if got, _ := spanLabel(s, "tenant"); got != "a" {
+			t.Errorf("%s label = %q; want %q", s.span.Name, got, "a")
+		}
+	}
+
+	// Changing the label doesn't affect spans that already have it.
+	root.SetInheritedLabel("tenant", "b")
+	if got, _ := spanLabel(after, "tenant"); got != "a" {
+		t.Errorf("existing child label = %q; want %q", got, "a")
+	}
+	if got, _ := spanLabel(root.NewChild("changed"), "tenant"); got != "b" {
+		t.Errorf("new child label = %q; want %q", got, "b")
+	}
+
+	// A child can override an inherited label.
+	override := root.NewChild("override")
+	override.SetLabel("tenant", "c")
+	if got, _ := spanLabel(override, "tenant"); got != "c" {
+		t.Errorf("overridden label = %q; want %q", got, "c")
+	}
+	if got, _ := spanLabel(override.NewChild("next"), "tenant"); got != "b" {
+		t.Errorf("label after override = %q; want %q", got, "b")
+	}
+
+	// Setting the empty value stops the label being inherited.
+	root.SetInheritedLabel("tenant", "")
+	if _, ok := spanLabel(root.NewChild("deleted"), "tenant"); ok {
+		t.Error("child created after deleting the inherited label has it")
+	}
+
+	// Other traces don't inherit the label.
+	root.SetInheritedLabel("tenant", "d")
+	if _, ok := spanLabel(tc.NewSpan("/other"), "tenant"); ok {
+		t.Error("span of another trace has the inherited label")
+	}
+}
+
+func TestInheritedLabelLimit(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetStrictValidation(true)
+	var errs []error
+	tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+	root := tc.NewSpan("/root")
+	for i := 0; i < maxInheritedLabels+2; i++ {
+		root.SetInheritedLabel(fmt.Sprintf("key%d", i), "v")
+	}
+	// Existing keys can still be changed.
+	root.SetInheritedLabel("key0", "changed")
+	if len(errs) != 2 {
+		t.Errorf("got %d errors; want 2", len(errs))
+	}
+	if got := storedLabels(root); got != maxInheritedLabels+2 {
+		t.Errorf("root has %d labels; want %d", got, maxInheritedLabels+2)
+	}
+	child := root.NewChild("child")
+	if got := storedLabels(child); got != maxInheritedLabels {
+		t.Errorf("child has %d labels; want %d", got, maxInheritedLabels)
+	}
+	if got, _ := spanLabel(child, "key0"); got != "changed" {
+		t.Errorf("child key0 = %q; want %q", got, "changed")
+	}
+	if _, ok := spanLabel(child, fmt.Sprintf("key%d", maxInheritedLabels)); ok {
+		t.Error("child inherited a label over the limit")
+	}
+}
+
+func TestSetInheritedLabelUntraced(t *testing.T) {
+	var s *Span
+	s.SetInheritedLabel("key", "value") // must not panic.
+}
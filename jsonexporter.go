@@ -0,0 +1,100 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A JSONExporter is an Exporter that writes each span as a line of JSON to
+// an io.Writer, for local debugging:
+//
+//   traceClient := trace.NewClientWithExporter(trace.NewJSONExporter(os.Stderr))
+//
+// Create one with NewJSONExporter.
+type JSONExporter struct {
+	mu sync.Mutex // serializes writes, so that lines don't interleave.
+	w  io.Writer
+}
+
+// NewJSONExporter returns a JSONExporter that writes to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+// Describe implements Describer.
+func (e *JSONExporter) Describe() string {
+	return "JSON exporter"
+}
+
+// jsonSpan is the form of a span written by a JSONExporter.
+type jsonSpan struct {
+	TraceID      string            `json:"traceId"`
+	ProjectID    string            `json:"projectId,omitempty"`
+	SpanID       string            `json:"spanId"`
+	ParentSpanID string            `json:"parentSpanId,omitempty"`
+	Name         string            `json:"name"`
+	Kind         string            `json:"kind"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  []jsonAnnotation  `json:"annotations,omitempty"`
+}
+
+type jsonAnnotation struct {
+	Time       time.Time         `json:"time"`
+	Message    string            `json:"message"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// ExportSpans writes spans to the exporter's writer, one line each.  Span
+// IDs are written as decimal strings, as in trace headers, and kinds as
+// they are named by the Stackdriver Trace API.  It stops at the first
+// write error.
+func (e *JSONExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	var buf []byte
+	for _, s := range spans {
+		js := jsonSpan{
+			TraceID:   s.TraceID,
+			ProjectID: s.ProjectID,
+			SpanID:    strconv.FormatUint(s.SpanID, 10),
+			Name:      s.Name,
+			Kind:      s.Kind.apiKind(),
+			Start:     s.Start,
+			End:       s.End,
+			Labels:    s.Labels,
+		}
+		if s.ParentSpanID != 0 {
+			js.ParentSpanID = strconv.FormatUint(s.ParentSpanID, 10)
+		}
+		for _, a := range s.Annotations {
+			js.Annotations = append(js.Annotations, jsonAnnotation{Time: a.Time, Message: a.Message, Attributes: a.Attributes})
+		}
+		b, err := json.Marshal(js)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf)
+	return err
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONExporter(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	spans := []*SpanData{
+		{
+			TraceID: validationTraceID,
+			SpanID:  1,
+			Name:    "/root",
+			Kind:    SpanKindServer,
+			Start:   start,
+			End:     start.Add(time.Second),
+			Labels:  map[string]string{"b": "2", "a": "1"},
+		},
+		{
+			TraceID:      validationTraceID,
+			SpanID:       18446744073709551615,
+			ParentSpanID: 1,
+			Name:         "/child",
+			Kind:         SpanKindClient,
+			Start:        start,
+			End:          start.Add(time.Millisecond),
+			Annotations:  []Annotation{{Time: start, Message: "sent", Attributes: map[string]string{"n": "3"}}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := NewJSONExporter(&buf).ExportSpans(context.Background(), spans); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"traceId":"0123456789abcdef0123456789abcdef","spanId":"1","name":"/root","kind":"RPC_SERVER","start":"2017-01-01T00:00:00Z","end":"2017-01-01T00:00:01Z","labels":{"a":"1","b":"2"}}
+{"traceId":"0123456789abcdef0123456789abcdef","spanId":"18446744073709551615","parentSpanId":"1","name":"/child","kind":"RPC_CLIENT","start":"2017-01-01T00:00:00Z","end":"2017-01-01T00:00:00.001Z","annotations":[{"time":"2017-01-01T00:00:00Z","message":"sent","attributes":{"n":"3"}}]}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestJSONExporterClient(t *testing.T) {
+	var buf bytes.Buffer
+	tc := NewClientWithExporter(NewJSONExporter(&buf))
+	root := tc.NewSpan("/root")
+	root.NewChild("/child").Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Errorf("wrote %d lines; want 2:\n%s", lines, buf.String())
+	}
+
+	tc = NewClientWithExporter(NewJSONExporter(failingWriter{}))
+	if err := tc.NewSpan("/root").FinishWait(); err == nil || err.Error() != "disk full" {
+		t.Errorf("FinishWait = %v; want disk full", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// maxEncodedLabelBytes is the largest JSON encoding DefaultLabelEncoder
+// produces.
+const maxEncodedLabelBytes = 256
+
+// maxLabelErrorBytes is the maximum length of the placeholder SetLabelAny
+// sets when a value can't be encoded.
+const maxLabelErrorBytes = 64
+
+// A LabelEncoder encodes a value passed to Span.SetLabelAny as a label value.
+type LabelEncoder func(v interface{}) (string, error)
+
+// DefaultLabelEncoder is the LabelEncoder used unless Client.SetLabelEncoder
+// is called.  Strings, booleans and numbers are formatted with strconv,
+// time.Time values in RFC 3339 format, and time.Duration values as a number
+// of milliseconds.  Errors and fmt.Stringers are formatted with their Error
+// and String methods.  Other values, such as structs and maps, are encoded as
+// compact JSON; encodings longer than 256 bytes are an error.
+func DefaultLabelEncoder(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.FormatFloat(float64(v)/float64(time.Millisecond), 'f', -1, 64), nil
+	case error:
+		return v.Error(), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxEncodedLabelBytes {
+		return "", fmt.Errorf("encoding is %d bytes, more than %d", len(b), maxEncodedLabelBytes)
+	}
+	return string(b), nil
+}
+
+// SetLabelEncoder sets the encoder Span.SetLabelAny uses to convert values
+// to labels.  A nil encoder restores DefaultLabelEncoder.
+//
+// SetLabelEncoder should be called before any spans are created.
+func (c *Client) SetLabelEncoder(e LabelEncoder) {
+	if c != nil {
+		c.labelEncoder = e
+	}
+}
+
+// SetLabelAny sets the label for the given key to v, encoded with the
+// client's LabelEncoder.  If v can't be encoded, the label is set to a short
+// placeholder describing the error, such as "!(json: unsupported type: func())".
+// If s is nil, does nothing.
+//
+// SetLabelAny shouldn't be called after Finish or FinishWait.
+func (s *Span) SetLabelAny(key string, v interface{}) {
+	if s == nil || !s.tracing() {
+		return
+	}
+	encode := s.trace.client.labelEncoder
+	if encode == nil {
+		encode = DefaultLabelEncoder
+	}
+	value, err := encode(v)
+	if err != nil {
+		value = truncate("!("+err.Error(), maxLabelErrorBytes-1) + ")"
+	}
+	s.SetLabel(key, value)
+}
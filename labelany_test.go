@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type labelPoint struct {
+	X, Y int
+}
+
+type labelNested struct {
+	Name   string            `json:"name"`
+	Point  labelPoint        `json:"point"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Blocks []string          `json:"blocks,omitempty"`
+}
+
+func TestSetLabelAny(t *testing.T) {
+	when := time.Date(2017, 3, 4, 5, 6, 7, 8000000, time.UTC)
+	big := labelNested{Name: "big", Blocks: []string{strings.Repeat("b", maxEncodedLabelBytes)}}
+	for _, tt := range []struct {
+		desc string
+		v    interface{}
+		want string
+	}{
+		{"string", "hello", "hello"},
+		{"bool", true, "true"},
+		{"int", -42, "-42"},
+		{"int8", int8(-8), "-8"},
+		{"int64", int64(1) << 40, "1099511627776"},
+		{"uint16", uint16(65535), "65535"},
+		{"uint64", uint64(1) << 63, "9223372036854775808"},
+		{"float32", float32(0.1), "0.1"},
+		{"float64", 2.5e-7, "2.5e-07"},
+		{"time", when, "2017-03-04T05:06:07.008Z"},
+		{"duration", 1500 * time.Microsecond, "1.5"},
+		{"error", errors.New("boom"), "boom"},
+		{"stringer", net.IPv4(10, 0, 0, 1), "10.0.0.1"},
+		{"struct", labelPoint{1, 2}, `{"X":1,"Y":2}`},
+		{"map", map[string]int{"b": 2, "a": 1}, `{"a":1,"b":2}`},
+		{"slice", []int{1, 2, 3}, "[1,2,3]"},
+		{"nested", labelNested{Name: "n", Point: labelPoint{3, 4}, Tags: map[string]string{"k": "v"}}, `{"name":"n","point":{"X":3,"Y":4},"tags":{"k":"v"}}`},
+		{"pointer", &labelPoint{5, 6}, `{"X":5,"Y":6}`},
+		{"nil", nil, "null"},
+		{"over the size cap", big, fmt.Sprintf("!(encoding is %d bytes, more than %d)", len(big.Blocks[0])+len(`{"name":"big","point":{"X":0,"Y":0},"blocks":[""]}`), maxEncodedLabelBytes)},
+		{"unsupported", func() {}, "!(json: unsupported type: func())"},
+	} {
+		e := &recordingExporter{}
+		span := NewClientWithExporter(e).NewSpan("span")
+		span.SetLabelAny("key", tt.v)
+		if err := span.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		if got := e.spans[0].Labels["key"]; got != tt.want {
+			t.Errorf("%s: label = %q; want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestSetLabelAnyEncoder(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetLabelEncoder(func(v interface{}) (string, error) {
+		if _, ok := v.(int); ok {
+			return "", errors.New(strings.Repeat("x", 2*maxLabelErrorBytes))
+		}
+		return fmt.Sprintf("%v", v), nil
+	})
+	span := tc.NewSpan("span")
+	span.SetLabelAny("point", labelPoint{1, 2})
+	span.SetLabelAny("int", 1)
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	labels := e.spans[0].Labels
+	if got, want := labels["point"], "{1 2}"; got != want {
+		t.Errorf("point = %q; want %q", got, want)
+	}
+	if got, want := labels["int"], "!("+strings.Repeat("x", maxLabelErrorBytes-3)+")"; got != want {
+		t.Errorf("int = %q; want %q", got, want)
+	}
+}
+
+func TestSetLabelAnyNilAndConcurrent(t *testing.T) {
+	var nilSpan *Span
+	nilSpan.SetLabelAny("key", 1) // must not panic
+
+	e := &recordingExporter{}
+	span := NewClientWithExporter(e).NewSpan("span")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			span.SetLabelAny(fmt.Sprintf("key%d", i), labelPoint{i, i})
+		}(i)
+	}
+	wg.Wait()
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(e.spans[0].Labels); got < 8 {
+		t.Errorf("got %d labels; want at least 8", got)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// labelDroppedLabels is the number of labels SetLabel dropped from a span
+// because of the cap set with WithLabelCap.
+const labelDroppedLabels = `trace/dropped_labels`
+
+// DefaultLabelCap is the cap WithLabelCap uses when it is given a cap of
+// zero or less.
+const DefaultLabelCap = 1000
+
+type withLabelCap struct {
+	max int
+}
+
+// WithLabelCap returns an InterceptorOption that makes the server
+// interceptors cap the number of labels each span of a traced request
+// stores, at max, or DefaultLabelCap if max is zero or less.  Once a span
+// has max labels, Span.SetLabel drops further values, after a cheap check
+// and without storing them, so that a handler that sets a label per item of
+// a large result doesn't hold the memory until the span is exported.
+//
+// The labels set automatically by this package are not capped.  A span that
+// had labels dropped is labeled with their number, as
+// "trace/dropped_labels", and with strict validation enabled, the first drop
+// for each span is reported to the error handler.
+func WithLabelCap(max int) InterceptorOption {
+	if max <= 0 {
+		max = DefaultLabelCap
+	}
+	return withLabelCap{max: max}
+}
+
+func (o withLabelCap) configureInterceptor(c *interceptorConfig) {
+	c.labelCap = o.max
+}
+
+// setLabelCap caps the labels of the spans of the trace of span, which must
+// be the root span of a trace read from an incoming call.
+func setLabelCap(span *Span, max int) {
+	if span == nil {
+		return
+	}
+	span.trace.labelCap = max
+}
+
+// dropLabel records that SetLabel dropped a label of s, which has max
+// labels.
+func (s *Span) dropLabel(max int) {
+	if atomic.AddInt32(&s.labelsDropped, 1) != 1 {
+		return
+	}
+	if c := s.trace.client; c.strict {
+		s.spanMu.Lock()
+		name := s.span.Name
+		s.spanMu.Unlock()
+		c.reportError(fmt.Errorf("trace: span %q has %d labels, the cap set with WithLabelCap; dropping new labels", name, max))
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// storedLabels returns the number of labels s stores.
+func storedLabels(s *Span) int {
+	s.spanMu.Lock()
+	defer s.spanMu.Unlock()
+	return len(s.span.Labels)
+}
+
+func TestLabelCap(t *testing.T) {
+	const (
+		max        = 20 // below maxLabels, so that validation keeps all the labels.
+		goroutines = 8
+		perRoutine = 10000
+	)
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+	tc.SetStrictValidation(true)
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	tc.SetErrorHandler(func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	var stored, childStored, preset int
+	GRPCServerInterceptor(tc, WithLabelCap(max))(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span := FromContext(ctx)
+		preset = storedLabels(span)
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < perRoutine; i++ {
+					span.SetLabel(fmt.Sprintf("row/%d/%d", g, i), "v")
+				}
+			}(g)
+		}
+		wg.Wait()
+		stored = storedLabels(span)
+
+		child := span.NewChild("child")
+		for i := 0; i < 2*max; i++ {
+			child.SetLabel("row/"+strconv.Itoa(i), "v")
+		}
+		childStored = storedLabels(child)
+		child.Finish()
+		return nil, errors.New("too many rows")
+	})
+	if stored != max {
+		t.Errorf("span stored %d labels; want %d", stored, max)
+	}
+	if childStored != max {
+		t.Errorf("child span stored %d labels; want %d", childStored, max)
+	}
+
+	<-e.exported
+	var server, child *SpanData
+	for _, s := range e.spans {
+		if s.Name == "child" {
+			child = s
+		} else {
+			server = s
+		}
+	}
+	if got, want := server.Labels[labelDroppedLabels], strconv.Itoa(goroutines*perRoutine-(max-preset)); got != want {
+		t.Errorf("server span %s = %q; want %q", labelDroppedLabels, got, want)
+	}
+	if got, want := child.Labels[labelDroppedLabels], strconv.Itoa(max); got != want {
+		t.Errorf("child span %s = %q; want %q", labelDroppedLabels, got, want)
+	}
+	// The labels set by the interceptor are not capped.
+	if got := server.Labels[labelGRPCStatusCode]; got == "" {
+		t.Errorf("server span has no %s label", labelGRPCStatusCode)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 2 {
+		t.Fatalf("got errors %v; want one for each span", errs)
+	}
+	if !strings.Contains(errs[1].Error(), `span "child"`) && !strings.Contains(errs[0].Error(), `span "child"`) {
+		t.Errorf("errors %v don't name the child span", errs)
+	}
+}
+
+func TestLabelCapDefault(t *testing.T) {
+	if got := newInterceptorConfig([]InterceptorOption{WithLabelCap(0)}).labelCap; got != DefaultLabelCap {
+		t.Errorf("WithLabelCap(0) caps at %d labels; want %d", got, DefaultLabelCap)
+	}
+
+	// Without the option, labels are not capped.
+	tc := NewClientWithExporter(&recordingExporter{})
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	var stored, preset int
+	GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span := FromContext(ctx)
+		preset = storedLabels(span)
+		for i := 0; i < DefaultLabelCap+10; i++ {
+			span.SetLabel("row/"+strconv.Itoa(i), "v")
+		}
+		stored = storedLabels(span)
+		return nil, nil
+	})
+	if stored != preset+DefaultLabelCap+10 {
+		t.Errorf("span stored %d labels; want %d", stored, preset+DefaultLabelCap+10)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A LabelFilter decides what happens to a label of a span before the span
+// is exported.  It returns the key and value to export instead of key and
+// value, and whether to export the label at all.
+type LabelFilter func(key, value string) (newKey, newValue string, keep bool)
+
+// SetLabelFilter sets a filter that is applied to every label of every span
+// the client exports, whether the label was set by SetLabel or by this
+// package, such as the "error" label of the gRPC interceptors.  Labels for
+// which f returns false are dropped; the others are exported with the key
+// and value that f returns.  This keeps credentials and personal data out of
+// the trace backend with one policy for the whole process, whatever code
+// set the labels.
+//
+// The filter is applied when spans are exported, after all the processors
+// added with AddSpanProcessor, so that none of them can reintroduce the
+// labels it removes.  It may be called concurrently from multiple
+// goroutines.  The attributes of annotations are filtered like labels,
+// since some exporters upload them as labels; those of message events are
+// not filtered.  A nil filter removes the filter.
+//
+// SetLabelFilter should be called before any spans are created.
+func (c *Client) SetLabelFilter(f LabelFilter) {
+	if c != nil {
+		c.labelFilter = f
+	}
+}
+
+// DenyLabelKeys returns a LabelFilter that drops the labels whose keys match
+// any of patterns, ignoring case, and keeps the other labels unchanged.
+// The syntax of the patterns is that of path.Match, so "*" doesn't match
+// "/":
+//
+//   f, err := trace.DenyLabelKeys("http/request/header/authorization", "*email*", "db/*")
+//
+// It returns an error if a pattern is malformed.
+func DenyLabelKeys(patterns ...string) (LabelFilter, error) {
+	lower := make([]string, len(patterns))
+	for i, p := range patterns {
+		lower[i] = strings.ToLower(p)
+		if _, err := path.Match(lower[i], ""); err != nil {
+			return nil, fmt.Errorf("trace: bad label key pattern %q: %v", p, err)
+		}
+	}
+	return func(key, value string) (string, string, bool) {
+		k := strings.ToLower(key)
+		for _, p := range lower {
+			if ok, _ := path.Match(p, k); ok {
+				return key, value, false
+			}
+		}
+		return key, value, true
+	}, nil
+}
+
+// filterLabels applies the client's label filter to the labels of spans,
+// and to the attributes of their annotations.
+func (c *Client) filterLabels(spans []*SpanData) {
+	f := c.labelFilter
+	if f == nil {
+		return
+	}
+	for _, s := range spans {
+		filterMap(f, s.Labels)
+		for _, a := range s.Annotations {
+			filterMap(f, a.Attributes)
+		}
+	}
+}
+
+// filterMap applies f to the labels in m.
+func filterMap(f LabelFilter, m map[string]string) {
+	var renamed map[string]string // labels to add once the range is done.
+	for k, v := range m {
+		nk, nv, keep := f(k, v)
+		switch {
+		case !keep:
+			delete(m, k)
+		case nk != k:
+			delete(m, k)
+			if renamed == nil {
+				renamed = make(map[string]string)
+			}
+			renamed[nk] = nv
+		case nv != v:
+			m[k] = nv
+		}
+	}
+	for k, v := range renamed {
+		m[k] = v
+	}
+}
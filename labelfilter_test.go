@@ -0,0 +1,144 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// labelAdder is a SpanProcessor that sets a label on every span.
+type labelAdder struct{ key, value string }
+
+func (p labelAdder) ProcessSpan(s *SpanData) {
+	if s.Labels == nil {
+		s.Labels = make(map[string]string)
+	}
+	s.Labels[p.key] = p.value
+}
+
+func TestDenyLabelKeys(t *testing.T) {
+	f, err := DenyLabelKeys("http/request/header/authorization", "*email*", "db/*", labelLegacyError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetLegacyErrorLabels(true)
+	tc.SetLabelFilter(f)
+	// A processor can't reintroduce a denied label.
+	tc.AddSpanProcessor(labelAdder{"db/statement", "SELECT * FROM users"})
+
+	root := tc.NewSpan("/root")
+	root.SetLabel("HTTP/Request/Header/Authorization", "Bearer secret")
+	root.SetLabel("user_email", "someone@example.com")
+	root.SetLabel("db/statement", "SELECT * FROM users WHERE id = 1")
+	root.SetLabel("db/rows/returned", "1")
+	root.SetLabel("user_id", "42")
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return errors.New("bad request: password=hunter2")
+	}
+	GRPCClientInterceptor()(NewContext(context.Background(), root), "/client", nil, nil, nil, invoker)
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range e.spans {
+		for k := range s.Labels {
+			k := strings.ToLower(k)
+			if k == "http/request/header/authorization" || strings.Contains(k, "email") || k == "db/statement" || k == labelLegacyError {
+				t.Errorf("span %s: denied label %s = %q was exported", s.Name, k, s.Labels[k])
+			}
+		}
+	}
+	labels := e.span("/root").Labels
+	for _, k := range []string{"db/rows/returned", "user_id"} {
+		if _, ok := labels[k]; !ok {
+			t.Errorf("label %s was dropped; want it kept", k)
+		}
+	}
+	if got := e.span("/client").Labels[labelGRPCStatusCode]; got == "" {
+		t.Errorf("label %s was dropped; want it kept", labelGRPCStatusCode)
+	}
+
+	if _, err := DenyLabelKeys("[z-a"); err == nil {
+		t.Error("DenyLabelKeys with a bad pattern succeeded; want error")
+	}
+}
+
+func TestLabelFilterRewrites(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetLabelFilter(func(key, value string) (string, string, bool) {
+		switch key {
+		case "token":
+			return key, "redacted", true
+		case "old":
+			return "new", value, true
+		}
+		return key, value, true
+	})
+	root := tc.NewSpan("/root")
+	root.SetLabel("token", "secret")
+	root.SetLabel("old", "value")
+	root.SetLabel("other", "unchanged")
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	got := e.span("/root").Labels
+	want := map[string]string{"token": "redacted", "new": "value", "other": "unchanged"}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("label %s = %q; want %q", k, got[k], v)
+		}
+	}
+	if v, ok := got["old"]; ok {
+		t.Errorf("renamed label old = %q is still exported", v)
+	}
+	if !tc.Config().LabelFilter {
+		t.Error("Config().LabelFilter = false; want true")
+	}
+}
+
+func TestLabelFilterAnnotations(t *testing.T) {
+	f, err := DenyLabelKeys("*email*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetLabelFilter(f)
+	root := tc.NewSpan("/root")
+	root.Annotate("signed up", "user_email", "someone@example.com", "plan", "free")
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	as := e.span("/root").Annotations
+	if len(as) != 1 {
+		t.Fatalf("exported %d annotations; want 1", len(as))
+	}
+	if want := map[string]string{"plan": "free"}; !reflect.DeepEqual(as[0].Attributes, want) {
+		t.Errorf("annotation attributes = %v; want %v", as[0].Attributes, want)
+	}
+	// The span's own copy is unchanged, for later exports of the span.
+	if got := root.annotations.list[0].Attributes["user_email"]; got != "someone@example.com" {
+		t.Errorf("span's annotation attribute user_email = %q; want it unchanged", got)
+	}
+}
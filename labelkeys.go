@@ -0,0 +1,143 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// canonicalLabelKeys holds the label keys that the package sets itself.
+// They are known to satisfy checkLabelKey, so validation doesn't check
+// them again.
+var canonicalLabelKeys = map[string]bool{
+	labelCancelCause:              true,
+	labelClockSkew:                true,
+	labelDecoyHeader:              true,
+	labelDependency:               true,
+	labelDroppedByCap:             true,
+	labelDroppedByMemory:          true,
+	labelDroppedLabels:            true,
+	labelGRPCAuthority:            true,
+	labelGRPCContextDone:          true,
+	labelGRPCDeadlineMs:           true,
+	labelGRPCDeadlineNearMiss:     true,
+	labelGRPCDeadlineUsedFraction: true,
+	labelGRPCErrorSource:          true,
+	labelGRPCIdleTimeout:          true,
+	labelGRPCMetadataBytes:        true,
+	labelGRPCMetadataLargestKey:   true,
+	labelGRPCMethod:               true,
+	labelGRPCPeer:                 true,
+	labelGRPCRecvBytes:            true,
+	labelGRPCRecvMessages:         true,
+	labelGRPCRequestType:          true,
+	labelGRPCResponseType:         true,
+	labelGRPCSendStallMs:          true,
+	labelGRPCSentBytes:            true,
+	labelGRPCSentMessages:         true,
+	labelGRPCService:              true,
+	labelGRPCStalledSends:         true,
+	labelGRPCStatusCode:           true,
+	labelGRPCStatusMessage:        true,
+	labelHandlerMs:                true,
+	labelHedgeAttempt:             true,
+	labelHedgeAttempts:            true,
+	labelHedgeCancelled:           true,
+	labelHedgeWinner:              true,
+	labelHedgeWon:                 true,
+	labelHost:                     true,
+	labelInProgress:               true,
+	labelLateFinishAttempted:      true,
+	labelLegacyError:              true,
+	labelMethod:                   true,
+	labelPanic:                    true,
+	labelPeerTraceID:              true,
+	labelPanicStack:               true,
+	labelRedirectFrom:             true,
+	labelResolverAddresses:        true,
+	labelResolverTarget:           true,
+	labelResponseSize:             true,
+	labelRetryAttempt:             true,
+	labelRetryAttempts:            true,
+	labelRetryBackoffMs:           true,
+	labelRetryError:               true,
+	labelSamplerMs:                true,
+	labelSamplingPolicy:           true,
+	labelSamplingWeight:           true,
+	labelShadow:                   true,
+	labelShadowGroup:              true,
+	labelShadowIndex:              true,
+	labelStackTrace:               true,
+	labelStatusCode:               true,
+	labelSummaryBucket:            true,
+	labelSuppressedByDuration:     true,
+	labelSummaryCount:             true,
+	labelSynthetic:                true,
+	labelTLSALPN:                  true,
+	labelTLSCipher:                true,
+	labelTLSVersion:               true,
+	labelURL:                      true,
+	string(MaxRecvBytesLabel):     true,
+	string(WaitForReadyLabel):     true,
+}
+
+// labelKeyCacheSize bounds the number of dynamic label keys whose
+// validation is remembered.  The cache is emptied when it is full, so a
+// program with unbounded label keys only pays for the checks it would
+// have made anyway.
+const labelKeyCacheSize = 1024
+
+// labelKeys remembers the validation of the dynamic label keys seen by
+// validateLabels.
+var labelKeys labelKeyCache
+
+type labelKeyCache struct {
+	mu    sync.Mutex
+	valid map[string]bool
+}
+
+// validLabelKey reports whether key satisfies checkLabelKey, using the
+// canonical keys and the cache to avoid checking it again.
+func validLabelKey(key string) bool {
+	if canonicalLabelKeys[key] {
+		return true
+	}
+	return labelKeys.check(key)
+}
+
+func (c *labelKeyCache) check(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ok, found := c.valid[key]; found {
+		return ok
+	}
+	if c.valid == nil || len(c.valid) >= labelKeyCacheSize {
+		c.valid = make(map[string]bool)
+	}
+	ok := checkLabelKey(key)
+	c.valid[key] = ok
+	return ok
+}
+
+// checkLabelKey reports whether key is a label key that validation doesn't
+// need to fix: non-empty, valid UTF-8, without control characters and
+// within the API's length limit.
+func checkLabelKey(key string) bool {
+	return key != "" && len(key) <= maxLabelKeyBytes && utf8.ValidString(key) &&
+		strings.IndexFunc(key, unicode.IsControl) < 0
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalLabelKeys(t *testing.T) {
+	for key := range canonicalLabelKeys {
+		if !checkLabelKey(key) {
+			t.Errorf("canonical label key %q doesn't pass validation", key)
+		}
+	}
+}
+
+func TestLabelKeyCache(t *testing.T) {
+	var c labelKeyCache
+	long := strings.Repeat("k", maxLabelKeyBytes+1)
+	for i := 0; i < 2; i++ {
+		// The second round is answered from the cache.
+		for _, tt := range []struct {
+			key  string
+			want bool
+		}{
+			{"user", true},
+			{"", false},
+			{"a\xff", false},
+			{long, false},
+		} {
+			if got := c.check(tt.key); got != tt.want {
+				t.Errorf("round %d: check(%q) = %t; want %t", i, tt.key, got, tt.want)
+			}
+		}
+	}
+	for i := 0; i < 3*labelKeyCacheSize; i++ {
+		c.check(fmt.Sprint("key/", i))
+	}
+	if n := len(c.valid); n > labelKeyCacheSize {
+		t.Errorf("cache holds %d keys; want at most %d", n, labelKeyCacheSize)
+	}
+}
+
+func TestValidateRepeatedInvalidKey(t *testing.T) {
+	// A cached invalid key is still fixed in every span.
+	var errs []error
+	c := NewClientWithExporter(&recordingExporter{})
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	c.SetStrictValidation(true)
+	for i := 0; i < 3; i++ {
+		s := validSpanData()
+		s.Labels = map[string]string{"row\xff": "v", labelHost: "example.com"}
+		got := c.validate([]*SpanData{s})
+		if len(got) != 1 {
+			t.Fatalf("span %d was dropped", i)
+		}
+		if _, ok := got[0].Labels["row�"]; !ok || len(got[0].Labels) != 2 {
+			t.Errorf("span %d labels = %q; want the key fixed", i, got[0].Labels)
+		}
+	}
+	if len(errs) != 3 {
+		t.Errorf("got errors %v; want one for each span", errs)
+	}
+}
+
+func benchmarkValidateLabels(b *testing.B, keys []string) {
+	c := NewClientWithExporter(&recordingExporter{})
+	c.SetStrictValidation(true)
+	s := validSpanData()
+	s.Labels = make(map[string]string)
+	for _, k := range keys {
+		s.Labels[k] = "v"
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.validateLabels(s, nil)
+	}
+}
+
+func BenchmarkValidateLabels(b *testing.B) {
+	canonical := []string{labelHost, labelMethod, labelURL, labelStatusCode, labelGRPCStatusCode, labelGRPCService, labelGRPCMethod, labelGRPCPeer}
+	b.Run("canonical", func(b *testing.B) { benchmarkValidateLabels(b, canonical) })
+	var dynamic []string
+	for i := range canonical {
+		dynamic = append(dynamic, fmt.Sprint("app/request/attribute/", i))
+	}
+	b.Run("dynamic", func(b *testing.B) { benchmarkValidateLabels(b, dynamic) })
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// SetLabelInt64 sets the label for the given key to v, in decimal.
+// If s is nil, does nothing.
+func (s *Span) SetLabelInt64(key string, v int64) {
+	if s == nil || !s.tracing() {
+		return
+	}
+	s.SetLabel(key, strconv.FormatInt(v, 10))
+}
+
+// SetLabelBool sets the label for the given key to "true" or "false".
+// If s is nil, does nothing.
+func (s *Span) SetLabelBool(key string, v bool) {
+	if s == nil || !s.tracing() {
+		return
+	}
+	s.SetLabel(key, strconv.FormatBool(v))
+}
+
+// SetLabelFloat64 sets the label for the given key to v, in the shortest
+// format that represents it exactly, as DefaultLabelEncoder does.
+// If s is nil, does nothing.
+func (s *Span) SetLabelFloat64(key string, v float64) {
+	if s == nil || !s.tracing() {
+		return
+	}
+	s.SetLabel(key, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// SetLabels sets the labels in labels, as SetLabel does for each of them,
+// but taking the span's lock once: a concurrent Snapshot sees all of them or
+// none of them.  If the span's labels would exceed the cap of WithLabelCap,
+// which of labels are dropped is unspecified.
+// If s is nil, does nothing.
+//
+// SetLabels shouldn't be called after Finish or FinishWait.
+func (s *Span) SetLabels(labels map[string]string) {
+	if s == nil || !s.tracing() || len(labels) == 0 {
+		return
+	}
+	if s.writes != nil {
+		for k, v := range labels {
+			if !s.writes.setLabel(k, v, false) {
+				s.setCappedLabel(k, v)
+			}
+		}
+		return
+	}
+	max := s.trace.labelCap
+	dropped := 0
+	s.spanMu.Lock()
+	for k, v := range labels {
+		if max > 0 && v != "" && atomic.LoadInt32(&s.labelCount) >= int32(max) {
+			dropped++
+			continue
+		}
+		s.storeLabel(k, v)
+	}
+	s.spanMu.Unlock()
+	for ; dropped > 0; dropped-- {
+		s.dropLabel(max)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestTypedLabels(t *testing.T) {
+	var nilSpan *Span
+	nilSpan.SetLabelInt64("k", 1)
+	nilSpan.SetLabelBool("k", true)
+	nilSpan.SetLabelFloat64("k", 1)
+	nilSpan.SetLabels(map[string]string{"k": "v"})
+
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/root")
+	span.SetLabelInt64("int", -42)
+	span.SetLabelBool("bool", true)
+	span.SetLabelFloat64("float", 0.25)
+	span.SetLabelFloat64("inf", math.Inf(1))
+	for k, want := range map[string]string{"int": "-42", "bool": "true", "float": "0.25", "inf": "+Inf"} {
+		if got := storedLabel(span, k); got != want {
+			t.Errorf("label %q = %q; want %q", k, got, want)
+		}
+	}
+}
+
+func TestSetLabels(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/root")
+	span.SetLabel("old", "1")
+	span.SetLabels(map[string]string{"a": "1", "b": "2", "old": ""})
+	if got, want := storedLabels(span), 2; got != want {
+		t.Errorf("span has %d labels; want %d", got, want)
+	}
+	if got := storedLabel(span, "b"); got != "2" {
+		t.Errorf("label b = %q; want 2", got)
+	}
+
+	// The batch is stored under one acquisition of the lock.
+	labels := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		labels[fmt.Sprint("k", i)] = "v"
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		span.SetLabels(labels)
+	}()
+	for done := false; !done; {
+		span.spanMu.Lock()
+		n := len(span.span.Labels)
+		span.spanMu.Unlock()
+		if n != 2 && n != 22 {
+			t.Fatalf("saw %d labels; want the batch stored all at once", n)
+		}
+		done = n == 22
+	}
+	wg.Wait()
+}
+
+func TestSetLabelsCap(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/root")
+	span.trace.labelCap = 3
+	span.SetLabel("a", "1")
+	span.SetLabels(map[string]string{"b": "1", "c": "1", "d": "1", "e": "1"})
+	if got := storedLabels(span); got != 3 {
+		t.Errorf("span has %d labels; want 3", got)
+	}
+	if got := span.labelsDropped; got != 2 {
+		t.Errorf("labelsDropped = %d; want 2", got)
+	}
+
+	buffered := tc.NewSpanWithOptions("/buffered", BufferedWrites())
+	buffered.SetLabels(map[string]string{"a": "1", "b": "2"})
+	buffered.Finish()
+	if got := storedLabel(buffered, "b"); got != "2" {
+		t.Errorf("buffered label b = %q; want 2", got)
+	}
+}
+
+// benchLabels are labels a handler might set for each request.
+var benchLabels = func() map[string]string {
+	m := make(map[string]string)
+	for i := 0; i < 15; i++ {
+		m[fmt.Sprint("label", i)] = "value"
+	}
+	return m
+}()
+
+func BenchmarkSetLabelEach(b *testing.B) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/root")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for k, v := range benchLabels {
+			span.SetLabel(k, v)
+		}
+	}
+}
+
+func BenchmarkSetLabels(b *testing.B) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/root")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		span.SetLabels(benchLabels)
+	}
+}
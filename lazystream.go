@@ -0,0 +1,53 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "time"
+
+// WithLazyStreamSpan returns an InterceptorOption that makes the stream
+// client interceptor start the span of a stream at the first call of
+// SendMsg, RecvMsg or CloseSend, instead of when the stream is created.
+// Use it for streams that are opened at startup and used much later, such
+// as long-lived command channels, so that the span's duration measures the
+// use of the stream.
+//
+// The span, and its ID, are still created with the stream, and the trace
+// header is sent when the stream is created, so the server's spans are
+// children of the same span either way.  The deadline of the stream is
+// measured from its creation, as usual.
+func WithLazyStreamSpan() InterceptorOption {
+	return withLazyStreamSpan{}
+}
+
+type withLazyStreamSpan struct{}
+
+func (withLazyStreamSpan) configureInterceptor(c *interceptorConfig) {
+	c.lazyStreamSpan = true
+}
+
+// begin starts the span of a stream created with WithLazyStreamSpan, the
+// first time it is called.
+func (s *ClientStreamWrapper) begin() {
+	// The span of a stream whose context isn't traced is the span of the
+	// context itself, which must not be restarted.
+	if !s.lazy || s.span == nil || !s.span.tracing() {
+		return
+	}
+	s.beginOnce.Do(func() {
+		s.span.spanMu.Lock()
+		s.span.start = time.Now()
+		s.span.spanMu.Unlock()
+	})
+}
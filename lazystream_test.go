@@ -0,0 +1,97 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLazyStreamSpan(t *testing.T) {
+	const idle = 50 * time.Millisecond
+	for _, lazy := range []bool{false, true} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		root := tc.NewSpan("/root")
+		ctx := NewContext(context.Background(), root)
+		var opts []InterceptorOption
+		if lazy {
+			opts = append(opts, WithLazyStreamSpan())
+		}
+
+		created := time.Now()
+		var header string
+		cs, err := GRPCStreamClientInterceptor(opts...)(ctx, &grpc.StreamDesc{ClientStreams: true}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if v := md[grpcMetadataKey]; len(v) > 0 {
+				header = v[0]
+			}
+			return &endingClientStream{fakeClientStream{ctx: ctx}, nil}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header == "" {
+			t.Errorf("lazy=%v: no trace header sent when the stream was created", lazy)
+		}
+		id := cs.(*ClientStreamWrapper).span.span.SpanId
+
+		time.Sleep(idle)
+		used := time.Now()
+		cs.RecvMsg(nil)
+		cs.CloseSend()
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+
+		s := e.span("/stream")
+		if s == nil {
+			t.Fatalf("lazy=%v: stream span not exported", lazy)
+		}
+		if s.SpanID != id {
+			t.Errorf("lazy=%v: span ID = %d; want %d, the ID it had when the stream was created", lazy, s.SpanID, id)
+		}
+		if lazy {
+			if s.Start.Before(used) {
+				t.Errorf("lazy=%v: span started %v before the first message", lazy, used.Sub(s.Start))
+			}
+		} else if s.Start.After(created.Add(idle / 2)) {
+			t.Errorf("lazy=%v: span started %v after the stream was created", lazy, s.Start.Sub(created))
+		}
+	}
+}
+
+func TestLazyStreamSpanUntraced(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	root.options.local = 0
+	start := root.start
+	ctx := NewContext(context.Background(), root)
+	cs, err := GRPCStreamClientInterceptor(WithLazyStreamSpan())(ctx, &grpc.StreamDesc{}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &endingClientStream{fakeClientStream{ctx: ctx}, nil}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	cs.RecvMsg(nil)
+	if !root.start.Equal(start) {
+		t.Error("first message of a stream of an untraced request restarted the request's span")
+	}
+}
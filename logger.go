@@ -0,0 +1,60 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "log"
+
+// A Logger receives the log messages of a Client.  Its methods may be called
+// concurrently from multiple goroutines.
+type Logger interface {
+	// Debugf logs a message about the normal operation of the client, such
+	// as the start and end of the spans of traced streams.
+	Debugf(format string, args ...interface{})
+
+	// Errorf logs an error that occurred in the background, such as a failed
+	// upload, for which no handler is set with SetErrorHandler, and notices
+	// of the use of deprecated features.
+	Errorf(format string, args ...interface{})
+}
+
+// SetLogger sets the logger of the client.  Without a logger, the default,
+// the client logs no debugging messages, and logs background errors for
+// which no handler is set with SetErrorHandler using the standard log
+// package.
+//
+// SetLogger should be called before any spans are created.
+func (c *Client) SetLogger(l Logger) {
+	if c != nil {
+		c.logger = l
+	}
+}
+
+// noticef logs a notice, such as of the use of a deprecated feature, to the
+// client's logger, or with the standard log package if c is nil or has no
+// logger.
+func (c *Client) noticef(format string, args ...interface{}) {
+	if c != nil && c.logger != nil {
+		c.logger.Errorf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// debugf sends a debugging message to the client's logger, if it has one.
+func (c *Client) debugf(format string, args ...interface{}) {
+	if c != nil && c.logger != nil {
+		c.logger.Debugf(format, args...)
+	}
+}
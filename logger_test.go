@@ -0,0 +1,118 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// recordingLogger is a Logger that records its messages.
+type recordingLogger struct {
+	mu     sync.Mutex
+	debugs []string
+	errors []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debugs = append(l.debugs, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+// captureLog redirects the standard logger to a buffer, and returns it with
+// a func that restores the logger's output.
+func captureLog() (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	return &buf, func() { log.SetOutput(defaultLogOutput) }
+}
+
+var defaultLogOutput = log.Writer()
+
+func TestStreamServerInterceptorSilent(t *testing.T) {
+	buf, restore := captureLog()
+	defer restore()
+	tc := NewClientWithExporter(&recordingExporter{})
+	GRPCStreamServerInterceptor(tc)(nil, newTracedStream(2), &grpc.StreamServerInfo{FullMethod: "/test.Echo/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		for ss.RecvMsg(nil) == nil {
+		}
+		return nil
+	})
+	if buf.Len() != 0 {
+		t.Errorf("stream interceptor logged %q; want nothing", buf.String())
+	}
+}
+
+func TestLogger(t *testing.T) {
+	buf, restore := captureLog()
+	defer restore()
+	l := &recordingLogger{}
+	tc := NewClientWithExporter(&failingExporter{err: errors.New("upload failed")})
+	tc.SetLogger(l)
+	GRPCStreamServerInterceptor(tc)(nil, newTracedStream(1), &grpc.StreamServerInfo{FullMethod: "/test.Echo/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		return nil
+	})
+	// Shutdown waits for the trace to be bundled before flushing it.
+	tc.Shutdown(context.Background())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// The first message is the client's configuration, logged with its
+	// first root span.
+	if len(l.debugs) != 3 || !strings.HasPrefix(l.debugs[0], "trace: client configuration: ") || !strings.Contains(l.debugs[1], "/test.Echo/Stream") {
+		t.Errorf("debug messages = %q; want the configuration, then 2 naming the stream", l.debugs)
+	}
+	if len(l.errors) != 1 || !strings.Contains(l.errors[0], "upload failed") {
+		t.Errorf("error messages = %q; want the upload failure", l.errors)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("standard logger got %q; want nothing", buf.String())
+	}
+}
+
+func TestDeprecationNoticeLogger(t *testing.T) {
+	buf, restore := captureLog()
+	defer restore()
+	l := &recordingLogger{}
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetLogger(l)
+	span := tc.NewSpan("/foo")
+	setStatusLabels(span, errors.New("failed"))
+	span.Finish()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.errors) != 1 || !strings.Contains(l.errors[0], `the "error" label of gRPC spans is deprecated`) {
+		t.Errorf("error messages = %q; want the deprecation notice", l.errors)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("standard logger got %q; want nothing", buf.String())
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"time"
+)
+
+// maxMessageEvents is the number of message events kept per span, the
+// per-span limit of the Cloud Trace v2 API.
+const maxMessageEvents = 128
+
+// A MessageEventType tells whether a message event is for a sent or a
+// received message.
+type MessageEventType int
+
+const (
+	// MessageSent is the type of events for messages sent on a stream.
+	MessageSent MessageEventType = iota + 1
+	// MessageReceived is the type of events for messages received on a
+	// stream.
+	MessageReceived
+)
+
+func (t MessageEventType) String() string {
+	switch t {
+	case MessageSent:
+		return "SENT"
+	case MessageReceived:
+		return "RECEIVED"
+	}
+	return "UNSPECIFIED"
+}
+
+// A MessageEvent records a message sent or received on a gRPC stream traced
+// by an interceptor created with WithMessageEvents.
+type MessageEvent struct {
+	Time time.Time
+	Type MessageEventType
+
+	// ID is the sequence number of the message among the messages of the
+	// same type on the stream, starting at 1.
+	ID int64
+
+	// UncompressedSize is the size of the encoded message in bytes, if it
+	// is a protocol buffer, or zero.
+	UncompressedSize int
+}
+
+// WithMessageEvents returns an InterceptorOption that records a MessageEvent
+// on the span of a stream for each message sent or received on it, in the
+// MessageEvents field of its SpanData.  The first 128 events of each span are
+// kept, and the others are counted in DroppedMessageEvents.
+//
+// It applies to the stream interceptors, on both the client and the server
+// side.
+func WithMessageEvents() InterceptorOption {
+	return withMessageEvents{}
+}
+
+type withMessageEvents struct{}
+
+func (withMessageEvents) configureInterceptor(c *interceptorConfig) {
+	c.messageEvents = true
+}
+
+// messageEvents holds the message events of a span.
+type messageEvents struct {
+	list     []MessageEvent
+	sent     int64 // messages sent, including dropped events.
+	received int64
+	dropped  int
+}
+
+// addMessageEvent records that m was sent or received on the stream of s.
+func (s *Span) addMessageEvent(typ MessageEventType, m interface{}) {
+	if s == nil || !s.tracing() {
+		return
+	}
+	size := messageSize(m)
+	now := time.Now()
+	s.spanMu.Lock()
+	defer s.spanMu.Unlock()
+	e := &s.messages
+	id := &e.sent
+	if typ == MessageReceived {
+		id = &e.received
+	}
+	*id++
+	if len(e.list) == maxMessageEvents {
+		e.dropped++
+		return
+	}
+	e.list = append(e.list, MessageEvent{Time: now, Type: typ, ID: *id, UncompressedSize: size})
+}
+
+// copy returns a copy of the events, or nil if there are none.
+func (e *messageEvents) copy() []MessageEvent {
+	if len(e.list) == 0 {
+		return nil
+	}
+	return append([]MessageEvent(nil), e.list...)
+}
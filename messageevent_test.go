@@ -0,0 +1,99 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+)
+
+// sendingServerStream is a fakeServerStream whose SendMsg succeeds.
+type sendingServerStream struct {
+	*fakeServerStream
+}
+
+func (s sendingServerStream) SendMsg(m interface{}) error { return nil }
+
+func TestMessageEvents(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+
+	const sends = maxMessageEvents + 1
+	ss := sendingServerStream{newTracedStream(1)}
+	GRPCStreamServerInterceptor(tc, WithMessageEvents())(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Echo/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		if err := ss.RecvMsg(&wrappers.StringValue{}); err != nil {
+			return err
+		}
+		for i := 0; i < sends; i++ {
+			if err := ss.SendMsg(&wrappers.StringValue{Value: "hello"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	<-e.exported
+	got := e.spans[0]
+	if len(got.MessageEvents) != maxMessageEvents || got.DroppedMessageEvents != sends+1-maxMessageEvents {
+		t.Fatalf("got %d message events, %d dropped; want %d, %d", len(got.MessageEvents), got.DroppedMessageEvents, maxMessageEvents, sends+1-maxMessageEvents)
+	}
+	if ev := got.MessageEvents[0]; ev.Type != MessageReceived || ev.ID != 1 || ev.UncompressedSize != 0 {
+		t.Errorf("first event = %+v; want message 1 received, of 0 bytes", ev)
+	}
+	for i, ev := range got.MessageEvents[1:] {
+		// "hello" is encoded in 7 bytes: a tag, a length and the string.
+		if ev.Type != MessageSent || ev.ID != int64(i+1) || ev.UncompressedSize != 7 {
+			t.Errorf("event %d = %+v; want message %d sent, of 7 bytes", i+1, ev, i+1)
+			break
+		}
+		if ev.Time.Before(got.Start) || ev.Time.After(got.End) {
+			t.Errorf("event %d at %v is outside the span, from %v to %v", i+1, ev.Time, got.Start, got.End)
+		}
+	}
+}
+
+func TestMessageEventsClientStream(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	for _, opts := range [][]InterceptorOption{nil, {WithMessageEvents()}} {
+		cs, err := GRPCStreamClientInterceptor(opts...)(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &endingClientStream{fakeClientStream{ctx: ctx}, nil}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs.RecvMsg(&wrappers.StringValue{})
+		cs.RecvMsg(&wrappers.StringValue{})
+		cs.CloseSend()
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	var counts []int
+	for _, s := range e.spans {
+		if s.Name == "/stream" {
+			counts = append(counts, len(s.MessageEvents))
+		}
+	}
+	if want := []int{0, 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("stream spans have %v message events; want 0 without the option and 2 with it", counts)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"io"
+	"strconv"
+	"sync/atomic"
+)
+
+const labelGRPCMessageIndex = `grpc/message_index`
+
+type withMessageSpans int
+
+// WithMessageSpans returns an InterceptorOption that makes the stream
+// interceptors, on both the client and the server side, give messages spans
+// of their own, children of the span of the stream, so that a long-lived
+// stream shows when its messages were exchanged instead of a single long
+// span.  A span named "<method>/send" covers a call to SendMsg, and one named
+// "<method>/recv" covers a call to RecvMsg, until its message is delivered.
+// Each span is labeled with the index of its message among the messages sent,
+// or received, on the stream, from 0, as "grpc/message_index".
+//
+// Since a stream may carry any number of messages, only one message in every
+// n in each direction has a span, starting with the first; if n is less than
+// 1, every message does.  Messages have spans only if the stream is traced.
+//
+// Unlike the spans of WithPerMessageSpans, which cover the handling of the
+// messages a server receives, these spans cover the transfer of the
+// messages; the two options can be used together.
+func WithMessageSpans(n int) InterceptorOption {
+	if n < 1 {
+		n = 1
+	}
+	return withMessageSpans(n)
+}
+
+func (o withMessageSpans) configureInterceptor(c *interceptorConfig) {
+	c.messageSpanInterval = int(o)
+}
+
+// messageSpans makes the spans of WithMessageSpans for the messages of a
+// stream.  A nil *messageSpans makes none.
+type messageSpans struct {
+	sent, received int64 // calls of SendMsg and RecvMsg, updated atomically.
+
+	send, recv string // the names of the spans.
+	interval   int64
+}
+
+// newMessageSpans returns the messageSpans for a stream of method, or nil if
+// config doesn't give messages spans.
+func newMessageSpans(config *interceptorConfig, method string) *messageSpans {
+	if config.messageSpanInterval == 0 {
+		return nil
+	}
+	return &messageSpans{send: method + "/send", recv: method + "/recv", interval: int64(config.messageSpanInterval)}
+}
+
+// startSend returns the span of the message being sent on the stream whose
+// span is stream, or nil if the message has none.
+func (m *messageSpans) startSend(stream *Span) *Span {
+	if m == nil {
+		return nil
+	}
+	return m.start(stream, m.send, &m.sent)
+}
+
+// startRecv is startSend, for the message being received.
+func (m *messageSpans) startRecv(stream *Span) *Span {
+	if m == nil {
+		return nil
+	}
+	return m.start(stream, m.recv, &m.received)
+}
+
+// start returns a child of stream named name for the next message counted
+// by n, or nil if the message has no span.
+func (m *messageSpans) start(stream *Span, name string, n *int64) *Span {
+	i := atomic.AddInt64(n, 1) - 1
+	if i%m.interval != 0 || !stream.traced() {
+		return nil
+	}
+	span := stream.NewChild(name)
+	span.setLabel(labelGRPCMessageIndex, strconv.FormatInt(i, 10))
+	return span
+}
+
+// finishMessageSpan finishes span, a span returned by startSend or
+// startRecv, whose call returned err.  io.EOF, the end of the messages of a
+// stream, isn't a failure.
+func finishMessageSpan(span *Span, err error) {
+	if err == io.EOF {
+		err = nil
+	}
+	span.FinishWithError(err)
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+func TestMessageSpans(t *testing.T) {
+	// The server exports its spans one at a time; the span of the stream is
+	// the last to finish.
+	serverExporter := &recordingExporter{exported: make(chan struct{}, 5)}
+	serverTC := NewClientWithExporter(serverExporter)
+	serverTC.bundler.BundleCountThreshold = 1
+	lis, headers, stop := startEchoServer(grpc.StreamInterceptor(GRPCStreamServerInterceptor(serverTC, WithMessageSpans(2))))
+	defer stop()
+	conn := dialEcho(t, lis, option.WithGRPCDialOption(grpc.WithStreamInterceptor(GRPCStreamClientInterceptor(WithMessageSpans(2)))))
+	defer conn.Close()
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	const method = "/test.Echo/Stream"
+	stream, err := conn.NewStream(NewContext(context.Background(), root), &echoStreamDesc.Streams[0], method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := stream.SendMsg(&empty.Empty{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.RecvMsg(&empty.Empty{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.RecvMsg(&empty.Empty{}); err != io.EOF {
+		t.Fatalf("RecvMsg: got %v; want io.EOF", err)
+	}
+	<-headers
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	for serverExporter.span("") == nil {
+		select {
+		case <-serverExporter.exported:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the server's spans")
+		}
+	}
+	serverExporter.mu.Lock()
+	defer serverExporter.mu.Unlock()
+
+	// Of the three messages sent each way, and the io.EOF received at the
+	// end, the first and the third have spans.
+	for _, side := range []struct {
+		name   string
+		spans  []*SpanData
+		stream string // the name of the stream's span.
+	}{
+		{"client", e.spans, method},
+		{"server", serverExporter.spans, ""},
+	} {
+		var parent uint64
+		for _, s := range side.spans {
+			if s.Name == side.stream {
+				parent = s.SpanID
+			}
+		}
+		if parent == 0 {
+			t.Fatalf("%s: exported spans %v; want a span %q for the stream", side.name, names(side.spans), side.stream)
+		}
+		var got []string
+		for _, s := range side.spans {
+			if s.Name != method+"/send" && s.Name != method+"/recv" {
+				continue
+			}
+			if s.ParentSpanID != parent {
+				t.Errorf("%s: %s span's parent is %d; want the stream's span %d", side.name, s.Name, s.ParentSpanID, parent)
+			}
+			got = append(got, s.Name+" "+s.Labels[labelGRPCMessageIndex])
+		}
+		sort.Strings(got)
+		want := []string{method + "/recv 0", method + "/recv 2", method + "/send 0", method + "/send 2"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: message spans %q; want %q", side.name, got, want)
+		}
+	}
+}
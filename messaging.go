@@ -0,0 +1,136 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// enqueueTimeKey is the header in which InjectWithTimestamp records when a
+// message was sent, in nanoseconds since the Unix epoch.
+const enqueueTimeKey = "x-cloud-trace-enqueue-time"
+
+const (
+	labelQueueTimeMs = `messaging/queue_time_ms`
+	labelClockSkewMs = `messaging/clock_skew_ms`
+)
+
+// queueTimeSkewTolerance is how far a message may seem to have been sent in
+// the future, because the clocks of its producer and consumer differ, before
+// ConsumerSpanFromCarrier labels the skew.
+const queueTimeSkewTolerance = 100 * time.Millisecond
+
+// MapCarrier is a Carrier for the string attributes of a message, such as
+// the attributes of a Pub/Sub or SQS message.  Keys are lowercased, like the
+// keys of gRPC metadata.
+type MapCarrier map[string]string
+
+func (c MapCarrier) Get(key string) string {
+	return c[strings.ToLower(key)]
+}
+
+func (c MapCarrier) Set(key, value string) {
+	c[strings.ToLower(key)] = value
+}
+
+// Inject sets the trace header of span in carrier, the attributes of a
+// message, under the key and in the format the gRPC interceptors use:
+//
+//   msg := &pubsub.Message{Data: data, Attributes: map[string]string{}}
+//   trace.Inject(span, msg.Attributes)
+//
+// It does nothing if span is nil.
+func Inject(span *Span, carrier map[string]string) {
+	if header := span.Header(); header != "" {
+		carrier[grpcMetadataKey] = header
+	}
+}
+
+// Extract returns a new span named name for the consumer of a message whose
+// attributes are carrier, like SpanFromHeader does for the trace header that
+// Inject sets in carrier, and so by the sampling options of the header:
+//
+//   span, _ := tc.Extract("/orders/consume", msg.Attributes)
+//   defer span.Finish()
+//   ctx = trace.NewContext(ctx, span)
+//
+// Unlike SpanFromHeader, it returns a nil span, which is safe to use, if
+// carrier has no trace header, or if the header is malformed, in which case
+// it also returns the *HeaderError.  It returns nil, nil if c is nil.
+func (c *Client) Extract(name string, carrier map[string]string) (*Span, error) {
+	header := MapCarrier(carrier).Get(grpcMetadataKey)
+	if c == nil || header == "" {
+		return nil, nil
+	}
+	if _, _, _, _, err := parseHeaderErr(header); err != nil {
+		return nil, err
+	}
+	return c.SpanFromHeader(name, header), nil
+}
+
+// InjectWithTimestamp sets the headers in carrier that propagate span to the
+// consumer of a message, and records in them the time at which the message
+// is sent, so that ConsumerSpanFromCarrier can measure how long it waited in
+// a queue:
+//
+//   msg := &pubsub.Message{Data: data, Attributes: map[string]string{}}
+//   trace.InjectWithTimestamp(span, trace.MapCarrier(msg.Attributes))
+//
+// The trace header is the one Header returns.  If span is nil, only the
+// time is recorded.
+func InjectWithTimestamp(span *Span, carrier Carrier) {
+	if header := span.Header(); header != "" {
+		carrier.Set(httpHeader, header)
+	}
+	carrier.Set(enqueueTimeKey, strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// ConsumerSpanFromCarrier returns a new span named name for the consumer of
+// a message whose headers are in carrier, like SpanFromHeader does for the
+// trace header in carrier.  If the headers record when the message was
+// sent, as InjectWithTimestamp does, the span is labeled with the time
+// from then until now, in milliseconds, as "messaging/queue_time_ms".
+//
+// A message that seems to have been sent after it was received, because
+// the clock of its producer is ahead of the consumer's, has a queue time of
+// zero.  If the difference is more than 100ms, it is also recorded, in
+// milliseconds, as "messaging/clock_skew_ms", and as a negative number as
+// "clock/skew_ms", like WithClockSkewDetection does for gRPC calls.
+//
+// It returns nil if c is nil.
+func (c *Client) ConsumerSpanFromCarrier(name string, carrier Carrier) *Span {
+	span := c.SpanFromHeader(name, carrier.Get(httpHeader))
+	if span == nil {
+		return nil
+	}
+	if !span.tracing() {
+		return span
+	}
+	sent, err := strconv.ParseInt(carrier.Get(enqueueTimeKey), 10, 64)
+	if err != nil {
+		return span
+	}
+	wait := setClockSkewLabel(span, time.Unix(0, sent), queueTimeSkewTolerance)
+	if wait < -queueTimeSkewTolerance {
+		span.setLabel(labelClockSkewMs, strconv.FormatInt(int64(-wait/time.Millisecond), 10))
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	span.setLabel(labelQueueTimeMs, strconv.FormatInt(int64(wait/time.Millisecond), 10))
+	return span
+}
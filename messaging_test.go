@@ -0,0 +1,172 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInjectWithTimestamp(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/producer")
+	for _, carrier := range []Carrier{MapCarrier{}, MetadataCarrier(metadata.MD{}), HeaderCarrier(http.Header{})} {
+		before := time.Now()
+		InjectWithTimestamp(span, carrier)
+		if got, want := carrier.Get(httpHeader), span.Header(); got != want {
+			t.Errorf("%T: trace header %q; want %q", carrier, got, want)
+		}
+		sent, err := strconv.ParseInt(carrier.Get(enqueueTimeKey), 10, 64)
+		if err != nil {
+			t.Fatalf("%T: enqueue time: %v", carrier, err)
+		}
+		if at := time.Unix(0, sent); at.Before(before) || at.After(time.Now()) {
+			t.Errorf("%T: enqueue time %v; want after %v and before now", carrier, at, before)
+		}
+
+		consumer := tc.ConsumerSpanFromCarrier("/consumer", carrier)
+		if got, want := consumer.TraceID(), span.TraceID(); got != want {
+			t.Errorf("%T: consumer TraceID() = %q; want %q", carrier, got, want)
+		}
+		if _, ok := spanLabel(consumer, labelQueueTimeMs); !ok {
+			t.Errorf("%T: consumer has no %s label", carrier, labelQueueTimeMs)
+		}
+	}
+
+	carrier := MapCarrier{}
+	InjectWithTimestamp(nil, carrier)
+	if _, ok := carrier[grpcMetadataKey]; ok {
+		t.Errorf("nil span: carrier %v has a trace header", carrier)
+	}
+	if _, ok := carrier[enqueueTimeKey]; !ok {
+		t.Errorf("nil span: carrier %v has no enqueue time", carrier)
+	}
+}
+
+func TestConsumerQueueTime(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	// millis returns the label of consumer with the given key, in
+	// milliseconds, or -1 if it has none.
+	millis := func(consumer *Span, key string) int64 {
+		v, ok := spanLabel(consumer, key)
+		if !ok {
+			return -1
+		}
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			t.Fatalf("%s = %q: %v", key, v, err)
+		}
+		return ms
+	}
+	// near reports whether got is within the time the test takes of want.
+	near := func(got, want int64) bool {
+		return got >= want-100 && got <= want+100
+	}
+	for _, tt := range []struct {
+		desc      string
+		delay     time.Duration // how long before the consumer starts the producer's clock says the message was sent.
+		wantQueue int64
+		wantSkew  int64 // -1 for no label.
+	}{
+		{desc: "delayed", delay: 1500 * time.Millisecond, wantQueue: 1500, wantSkew: -1},
+		{desc: "producer slightly ahead", delay: -50 * time.Millisecond, wantQueue: 0, wantSkew: -1},
+		{desc: "producer far ahead", delay: -2 * time.Second, wantQueue: 0, wantSkew: 2000},
+	} {
+		producer := tc.NewSpan("/producer")
+		carrier := MapCarrier{}
+		InjectWithTimestamp(producer, carrier)
+		// Simulate the delay, or the skew of the producer's clock.
+		carrier[enqueueTimeKey] = strconv.FormatInt(time.Now().Add(-tt.delay).UnixNano(), 10)
+		consumer := tc.ConsumerSpanFromCarrier("/consumer", carrier)
+
+		if got := millis(consumer, labelQueueTimeMs); !near(got, tt.wantQueue) || got < 0 {
+			t.Errorf("%s: %s = %d; want about %d", tt.desc, labelQueueTimeMs, got, tt.wantQueue)
+		}
+		if got := millis(consumer, labelClockSkewMs); tt.wantSkew < 0 && got != -1 || tt.wantSkew >= 0 && !near(got, tt.wantSkew) {
+			t.Errorf("%s: %s = %d; want about %d", tt.desc, labelClockSkewMs, got, tt.wantSkew)
+		}
+	}
+
+	// Messages without a valid enqueue time get no queue time.
+	for _, carrier := range []MapCarrier{{}, {enqueueTimeKey: "yesterday"}} {
+		consumer := tc.ConsumerSpanFromCarrier("/consumer", carrier)
+		if got, ok := spanLabel(consumer, labelQueueTimeMs); ok {
+			t.Errorf("carrier %v: %s = %q; want none", carrier, labelQueueTimeMs, got)
+		}
+	}
+	if got := (*Client)(nil).ConsumerSpanFromCarrier("/consumer", MapCarrier{}); got != nil {
+		t.Errorf("nil client: ConsumerSpanFromCarrier = %v; want nil", got)
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	for _, traced := range []bool{true, false} {
+		producer := tc.NewSpan("/producer")
+		if !traced {
+			producer = tc.SpanFromHeader("/producer", "0123456789ABCDEF0123456789ABCDEF/7;o=0")
+		}
+		attrs := map[string]string{"other": "v"}
+		Inject(producer, attrs)
+		// The attributes carry what the gRPC interceptors send.
+		if got, want := attrs[grpcMetadataKey], producer.Header(); got != want {
+			t.Errorf("traced=%t: attribute %s = %q; want %q", traced, grpcMetadataKey, got, want)
+		}
+
+		consumer, err := tc.Extract("/consumer", attrs)
+		if err != nil {
+			t.Fatalf("traced=%t: Extract: %v", traced, err)
+		}
+		if got, want := consumer.TraceID(), producer.TraceID(); got != want {
+			t.Errorf("traced=%t: consumer TraceID() = %q; want %q", traced, got, want)
+		}
+		if got := consumer.traced(); got != traced {
+			t.Errorf("traced=%t: consumer traced() = %t", traced, got)
+		}
+		if traced {
+			if got, want := consumer.span.ParentSpanId, producer.span.SpanId; got != want {
+				t.Errorf("consumer parent span ID = %d; want %d", got, want)
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		attrs   map[string]string
+		wantErr bool
+	}{
+		{desc: "nil attributes"},
+		{desc: "no header", attrs: map[string]string{"other": "v"}},
+		{desc: "empty header", attrs: map[string]string{grpcMetadataKey: ""}},
+		{desc: "malformed header", attrs: map[string]string{grpcMetadataKey: "not a header"}, wantErr: true},
+	} {
+		span, err := tc.Extract("/consumer", tt.attrs)
+		if span != nil {
+			t.Errorf("%s: Extract returned span %v; want nil", tt.desc, span)
+		}
+		if _, ok := err.(*HeaderError); ok != tt.wantErr || !tt.wantErr && err != nil {
+			t.Errorf("%s: Extract error %v; want a *HeaderError: %t", tt.desc, err, tt.wantErr)
+		}
+	}
+	Inject(nil, map[string]string{})
+	if span, err := (*Client)(nil).Extract("/consumer", map[string]string{grpcMetadataKey: "0123456789ABCDEF0123456789ABCDEF/7;o=1"}); span != nil || err != nil {
+		t.Errorf("nil client: Extract = %v, %v; want nil, nil", span, err)
+	}
+}
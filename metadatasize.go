@@ -0,0 +1,71 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	labelGRPCMetadataBytes      = `grpc/metadata_bytes`
+	labelGRPCMetadataLargestKey = `grpc/metadata_largest_key`
+)
+
+type withMetadataSize struct {
+	threshold int
+}
+
+// WithMetadataSize returns an InterceptorOption that makes the server
+// interceptors label the span of each call with the size of its incoming
+// metadata, as "grpc/metadata_bytes".  The size is the sum of the lengths of
+// the keys and values, counting the key once for each of its values; the
+// values of binary "-bin" keys count with their decoded length.  If the size
+// is more than threshold bytes, the key whose values take the most bytes is
+// also recorded, as "grpc/metadata_largest_key".
+//
+// Use it to find the keys responsible when calls are rejected for oversized
+// metadata.
+func WithMetadataSize(threshold int) InterceptorOption {
+	return withMetadataSize{threshold: threshold}
+}
+
+func (o withMetadataSize) configureInterceptor(c *interceptorConfig) {
+	c.metadataSize = true
+	c.metadataThreshold = o.threshold
+}
+
+// setMetadataSizeLabels labels span with the size of md.
+func setMetadataSizeLabels(span *Span, md metadata.MD, threshold int) {
+	if !span.tracing() {
+		return
+	}
+	total, largest, largestSize := 0, "", 0
+	for k, vs := range md {
+		size := 0
+		for _, v := range vs {
+			size += len(k) + len(v)
+		}
+		total += size
+		if size > largestSize || (size == largestSize && k < largest) {
+			largest, largestSize = k, size
+		}
+	}
+	span.setLabel(labelGRPCMetadataBytes, strconv.Itoa(total))
+	if total > threshold {
+		span.setLabel(labelGRPCMetadataLargestKey, largest)
+	}
+}
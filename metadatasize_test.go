@@ -0,0 +1,73 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestMetadataSizeLabels(t *testing.T) {
+	const header = "0123456789ABCDEF0123456789ABCDEF/1;o=1"
+	headerSize := len(grpcMetadataKey) + len(header)
+	for _, tt := range []struct {
+		desc        string
+		md          metadata.MD
+		wantBytes   int
+		wantLargest string // "" if the label should be absent.
+	}{
+		{
+			desc:      "small",
+			md:        metadata.Pairs("locale", "en"),
+			wantBytes: headerSize + len("locale") + len("en"),
+		},
+		{
+			desc: "bloated",
+			md: metadata.Pairs(
+				"locale", "en",
+				"cookie", strings.Repeat("c", 600),
+				"cookie", strings.Repeat("c", 600),
+				"blob-bin", strings.Repeat("\x00", 1000),
+			),
+			wantBytes:   headerSize + 8 + 2*(6+600) + 8 + 1000,
+			wantLargest: "cookie",
+		},
+	} {
+		tc := NewClientWithExporter(&recordingExporter{})
+		md := metadata.Join(tt.md, metadata.Pairs(grpcMetadataKey, header))
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		// The labels are read in the handler, before the span is finished
+		// and exported.
+		var labels map[string]string
+		GRPCServerInterceptor(tc, WithMetadataSize(1024))(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			labels = FromContext(ctx).Snapshot(labelGRPCMetadataBytes, labelGRPCMetadataLargestKey).Labels
+			return nil, nil
+		})
+		if got, want := labels[labelGRPCMetadataBytes], strconv.Itoa(tt.wantBytes); got != want {
+			t.Errorf("%s: %s = %q; want %q", tt.desc, labelGRPCMetadataBytes, got, want)
+		}
+		got, ok := labels[labelGRPCMetadataLargestKey]
+		if tt.wantLargest == "" && ok {
+			t.Errorf("%s: got %s %q; want none", tt.desc, labelGRPCMetadataLargestKey, got)
+		} else if got != tt.wantLargest {
+			t.Errorf("%s: %s = %q; want %q", tt.desc, labelGRPCMetadataLargestKey, got, tt.wantLargest)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// maxStatsMethods is the maximum number of methods counted by the server
+// interceptors.  When a new method is seen, the least recently used method
+// is forgotten.
+const maxStatsMethods = 500
+
+// MethodStats counts the calls of a gRPC method received by the server
+// interceptors created with WithMethodStats.
+type MethodStats struct {
+	// The number of calls with a valid trace header, with a trace header that
+	// couldn't be parsed, and with no trace header.
+	ValidHeader, MalformedHeader, NoHeader int64
+
+	// The number of calls that were traced, and that were not.
+	Sampled, Unsampled int64
+}
+
+type withMethodStats struct{}
+
+// WithMethodStats returns an InterceptorOption that makes the server
+// interceptors count, for each method, the calls with and without trace
+// headers, and the calls that were traced.  Unlike traces, the counts
+// include every call, whether or not it was sampled.  They are reported in
+// the Methods field of Client.Stats.
+func WithMethodStats() InterceptorOption {
+	return withMethodStats{}
+}
+
+func (withMethodStats) configureInterceptor(c *interceptorConfig) {
+	c.methodStats = true
+}
+
+type headerState int
+
+const (
+	headerValid headerState = iota
+	headerMalformed
+	headerMissing
+)
+
+// headerStateOf returns the state of a trace header that was parsed with
+// error err.
+func headerStateOf(err error) headerState {
+	if err != nil {
+		return headerMalformed
+	}
+	return headerValid
+}
+
+// methodCounters holds the counts of a method.  They are updated atomically.
+type methodCounters struct {
+	headers   [3]int64 // indexed by headerState.
+	sampled   int64
+	unsampled int64
+	lastUsed  int64 // the value of methodStats.generation when last used.
+}
+
+// methodStats holds the counters of up to maxStatsMethods methods.
+type methodStats struct {
+	// generation is incremented when a method is added.  Methods used since
+	// then have it as their lastUsed, which is enough to find the least
+	// recently used method without updating a shared counter on each call.
+	generation int64
+
+	mu      sync.RWMutex
+	methods map[string]*methodCounters
+}
+
+func (m *methodStats) record(method string, h headerState, sampled bool) {
+	m.mu.RLock()
+	mc := m.methods[method]
+	m.mu.RUnlock()
+	if mc == nil {
+		mc = m.add(method)
+	}
+	atomic.AddInt64(&mc.headers[h], 1)
+	if sampled {
+		atomic.AddInt64(&mc.sampled, 1)
+	} else {
+		atomic.AddInt64(&mc.unsampled, 1)
+	}
+	if gen := atomic.LoadInt64(&m.generation); atomic.LoadInt64(&mc.lastUsed) != gen {
+		atomic.StoreInt64(&mc.lastUsed, gen)
+	}
+}
+
+// add returns the counters of method, adding them if necessary.
+func (m *methodStats) add(method string) *methodCounters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mc := m.methods[method]; mc != nil {
+		return mc
+	}
+	if m.methods == nil {
+		m.methods = make(map[string]*methodCounters)
+	}
+	if len(m.methods) >= maxStatsMethods {
+		var lru string
+		oldest := int64(-1)
+		for name, mc := range m.methods {
+			if used := atomic.LoadInt64(&mc.lastUsed); oldest < 0 || used < oldest {
+				lru, oldest = name, used
+			}
+		}
+		delete(m.methods, lru)
+	}
+	mc := &methodCounters{lastUsed: atomic.AddInt64(&m.generation, 1)}
+	m.methods[method] = mc
+	return mc
+}
+
+func (m *methodStats) snapshot() map[string]MethodStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.methods) == 0 {
+		return nil
+	}
+	s := make(map[string]MethodStats, len(m.methods))
+	for name, mc := range m.methods {
+		s[name] = MethodStats{
+			ValidHeader:     atomic.LoadInt64(&mc.headers[headerValid]),
+			MalformedHeader: atomic.LoadInt64(&mc.headers[headerMalformed]),
+			NoHeader:        atomic.LoadInt64(&mc.headers[headerMissing]),
+			Sampled:         atomic.LoadInt64(&mc.sampled),
+			Unsampled:       atomic.LoadInt64(&mc.unsampled),
+		}
+	}
+	return s
+}
+
+// traced reports whether s is non-nil and traced.
+func (s *Span) traced() bool {
+	return s != nil && s.tracing()
+}
+
+// recordServerCall counts a call of method received by a server interceptor.
+func (c *Client) recordServerCall(method string, h headerState, sampled bool) {
+	if c != nil {
+		c.stats.methods.record(method, h, sampled)
+	}
+}
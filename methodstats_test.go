@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestMethodStats(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	unary := GRPCServerInterceptor(tc, WithMethodStats())
+	stream := GRPCStreamServerInterceptor(tc, WithMethodStats())
+	handler := func(context.Context, interface{}) (interface{}, error) { return nil, nil }
+	streamHandler := func(interface{}, grpc.ServerStream) error { return nil }
+
+	headers := []string{
+		"",                                       // no header
+		"0123456789abcdef0123456789abcdef/1;o=1", // sampled
+		"0123456789abcdef0123456789abcdef/1;o=0", // unsampled
+		"0123456789abcdef0123456789abcdef/1;o=1",
+		"garbage",
+	}
+	for _, h := range headers {
+		ctx := context.Background()
+		if h != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(grpcMetadataKey, h))
+		}
+		unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Unary"}, handler)
+		stream(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, streamHandler)
+	}
+	// Calls on interceptors without the option are not counted.
+	GRPCServerInterceptor(tc)(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Other"}, handler)
+
+	want := MethodStats{ValidHeader: 3, MalformedHeader: 1, NoHeader: 1, Sampled: 2, Unsampled: 3}
+	got := tc.Stats().Methods
+	if !reflect.DeepEqual(got, map[string]MethodStats{
+		"/test.Service/Unary":  want,
+		"/test.Service/Stream": want,
+	}) {
+		t.Errorf("Methods = %+v; want %+v for each traced method", got, want)
+	}
+}
+
+func TestMethodStatsEviction(t *testing.T) {
+	var m methodStats
+	for i := 0; i < maxStatsMethods; i++ {
+		m.record(fmt.Sprint("/m", i), headerMissing, false)
+	}
+	// Use every method but /m0 again, so /m0 is the least recently used.
+	m.record("/new", headerMissing, false)
+	for i := 1; i < maxStatsMethods; i++ {
+		m.record(fmt.Sprint("/m", i), headerMissing, false)
+	}
+	m.record("/newer", headerMissing, false)
+
+	s := m.snapshot()
+	if len(s) != maxStatsMethods {
+		t.Errorf("counting %d methods; want %d", len(s), maxStatsMethods)
+	}
+	if _, ok := s["/newer"]; !ok {
+		t.Error("newest method was not counted")
+	}
+	if _, ok := s["/new"]; !ok {
+		t.Error("recently added method was evicted")
+	}
+	if got := s["/m1"].NoHeader; got != 2 {
+		t.Errorf("/m1 NoHeader = %d; want 2", got)
+	}
+}
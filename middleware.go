@@ -0,0 +1,237 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const labelPanic = `trace/panic`
+
+// A MiddlewarePosition is a position in the chains built by a
+// ServerMiddleware, for interceptors added with WithInterceptor.
+type MiddlewarePosition int
+
+const (
+	// BeforeTracing is outside the tracing interceptor, just inside the
+	// recovery: the context has no span of the call yet.
+	BeforeTracing MiddlewarePosition = iota
+
+	// BeforeAuth is inside the tracing interceptor, before the auth
+	// labeler.
+	BeforeAuth
+
+	// AfterAuth is after the auth labeler, just outside the handler.
+	AfterAuth
+)
+
+// A ServerMiddleware builds the chains of server interceptors that trace
+// the calls of a gRPC server along with recovery from panics, labels from
+// authentication, and other interceptors, in the right order:
+//
+//   unary, stream := trace.NewServerMiddleware(tc).
+//     WithRecovery().
+//     WithAuthLabeler(principalLabels).
+//     Build()
+//   server := grpc.NewServer(grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+//
+// From the outside in, the chains are:
+//
+//   - the recovery set with WithRecovery, if any;
+//   - the interceptors added at BeforeTracing;
+//   - the tracing interceptor;
+//   - the part of the recovery that labels the span of a panicking call;
+//   - the interceptors added at BeforeAuth;
+//   - the auth labeler set with WithAuthLabeler, if any;
+//   - the interceptors added at AfterAuth;
+//   - the handler.
+//
+// Interceptors added at the same position run in the order they were added.
+type ServerMiddleware struct {
+	tc       *Client
+	opts     []InterceptorOption
+	recovery bool
+	auth     func(ctx context.Context) map[string]string
+	unary    [AfterAuth + 1][]grpc.UnaryServerInterceptor
+	stream   [AfterAuth + 1][]grpc.StreamServerInterceptor
+}
+
+// NewServerMiddleware returns a ServerMiddleware whose tracing interceptors
+// are GRPCServerInterceptor and GRPCStreamServerInterceptor, created with tc
+// and opts.
+func NewServerMiddleware(tc *Client, opts ...InterceptorOption) *ServerMiddleware {
+	return &ServerMiddleware{tc: tc, opts: opts}
+}
+
+// WithRecovery makes the chains recover from panics in the handler and the
+// other interceptors, and fail the call with codes.Internal instead.  The
+// span of a call whose handler panicked is labeled with the panic value, as
+// "trace/panic", with the stack of the panic, as "trace/panic_stack", and
+// with the Internal status code.
+func (m *ServerMiddleware) WithRecovery() *ServerMiddleware {
+	m.recovery = true
+	return m
+}
+
+// WithAuthLabeler makes the chains set the labels returned by fn on the span
+// of each call.  fn is called in the context of the call, inside the
+// tracing interceptor, once interceptors added at BeforeAuth, which can
+// authenticate the call, have run.
+func (m *ServerMiddleware) WithAuthLabeler(fn func(ctx context.Context) map[string]string) *ServerMiddleware {
+	m.auth = fn
+	return m
+}
+
+// WithInterceptor adds interceptors to the chains at pos.  Either may be
+// nil, to add an interceptor to one chain only.
+func (m *ServerMiddleware) WithInterceptor(pos MiddlewarePosition, unary grpc.UnaryServerInterceptor, stream grpc.StreamServerInterceptor) *ServerMiddleware {
+	if pos < BeforeTracing || pos > AfterAuth {
+		panic(fmt.Sprintf("trace: invalid MiddlewarePosition %d", pos))
+	}
+	if unary != nil {
+		m.unary[pos] = append(m.unary[pos], unary)
+	}
+	if stream != nil {
+		m.stream[pos] = append(m.stream[pos], stream)
+	}
+	return m
+}
+
+// Build returns the chains of unary and stream server interceptors.
+func (m *ServerMiddleware) Build() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	if m.recovery {
+		unary = append(unary, recoverUnary)
+		stream = append(stream, recoverStream)
+	}
+	unary = append(unary, m.unary[BeforeTracing]...)
+	stream = append(stream, m.stream[BeforeTracing]...)
+	unary = append(unary, GRPCServerInterceptor(m.tc, m.opts...))
+	stream = append(stream, GRPCStreamServerInterceptor(m.tc, m.opts...))
+	if m.recovery {
+		unary = append(unary, labelPanicUnary)
+		stream = append(stream, labelPanicStream)
+	}
+	unary = append(unary, m.unary[BeforeAuth]...)
+	stream = append(stream, m.stream[BeforeAuth]...)
+	if auth := m.auth; auth != nil {
+		unary = append(unary, func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			setAuthLabels(ctx, auth)
+			return handler(ctx, req)
+		})
+		stream = append(stream, func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			setAuthLabels(ss.Context(), auth)
+			return handler(srv, ss)
+		})
+	}
+	unary = append(unary, m.unary[AfterAuth]...)
+	stream = append(stream, m.stream[AfterAuth]...)
+	return chainUnary(unary), chainStream(stream)
+}
+
+// setAuthLabels sets the labels returned by fn on the span of ctx.
+func setAuthLabels(ctx context.Context, fn func(ctx context.Context) map[string]string) {
+	span := FromContext(ctx)
+	for k, v := range fn(ctx) {
+		span.SetLabel(k, v)
+	}
+}
+
+// panicError returns the error of a call whose handler panicked with v.
+func panicError(v interface{}) error {
+	return status.Errorf(codes.Internal, "panic: %v", v)
+}
+
+// recoverUnary is the outermost interceptor of WithRecovery.  It recovers
+// from panics of the interceptors that labelPanicUnary doesn't cover.
+func recoverUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			resp, err = nil, panicError(v)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func recoverStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = panicError(v)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+// labelPanicUnary runs inside the tracing interceptor, so that the span of
+// a call that panics gets the panic label and the status of the error
+// before it is finished.
+func labelPanicUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			setPanicLabels(FromContext(ctx), v)
+			resp, err = nil, panicError(v)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func labelPanicStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			setPanicLabels(FromContext(ss.Context()), v)
+			err = panicError(v)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+// chainUnary returns an interceptor that calls interceptors in order, the
+// first outermost.
+func chainUnary(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var next func(i int, ctx context.Context, req interface{}) (interface{}, error)
+		next = func(i int, ctx context.Context, req interface{}) (interface{}, error) {
+			if i == len(interceptors) {
+				return handler(ctx, req)
+			}
+			return interceptors[i](ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return next(i+1, ctx, req)
+			})
+		}
+		return next(0, ctx, req)
+	}
+}
+
+// chainStream is chainUnary for stream interceptors.
+func chainStream(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		var next func(i int, srv interface{}, ss grpc.ServerStream) error
+		next = func(i int, srv interface{}, ss grpc.ServerStream) error {
+			if i == len(interceptors) {
+				return handler(srv, ss)
+			}
+			return interceptors[i](srv, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+				return next(i+1, srv, ss)
+			})
+		}
+		return next(0, srv, ss)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// principalLabels is an auth labeler that labels spans with the principal
+// set in the context by an authenticating interceptor.
+func principalLabels(ctx context.Context) map[string]string {
+	p, _ := ctx.Value(principalKey{}).(string)
+	return map[string]string{"auth/principal": p}
+}
+
+type principalKey struct{}
+
+func authenticateUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(context.WithValue(ctx, principalKey{}, "alice"), req)
+}
+
+func authenticateStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextServerStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), principalKey{}, "alice")})
+}
+
+func TestServerMiddlewarePanic(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	unary, stream := NewServerMiddleware(tc, AlwaysTrace()).
+		WithRecovery().
+		WithAuthLabeler(principalLabels).
+		WithInterceptor(BeforeAuth, authenticateUnary, authenticateStream).
+		Build()
+
+	_, err := unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Unary"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("unary call error = %v; want code Internal", err)
+	}
+	err = stream(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("stream error = %v; want code Internal", err)
+	}
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.spans) != 2 {
+		t.Fatalf("exported spans %v; want one for each call", names(e.spans))
+	}
+	for _, s := range e.spans {
+		for key, want := range map[string]string{
+			labelPanic:          "boom",
+			"auth/principal":    "alice",
+			labelGRPCStatusCode: "Internal",
+		} {
+			if got := s.Labels[key]; got != want {
+				t.Errorf("%s: label %s = %q; want %q", s.Name, key, got, want)
+			}
+		}
+	}
+}
+
+func TestServerMiddlewareOrder(t *testing.T) {
+	var order []string
+	record := func(name string) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				order = append(order, name)
+				if FromContext(ctx) != nil {
+					order = append(order, "traced")
+				}
+				return handler(ctx, req)
+			}, func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				order = append(order, name)
+				if FromContext(ss.Context()) != nil {
+					order = append(order, "traced")
+				}
+				return handler(srv, ss)
+			}
+	}
+	before1, stream1 := record("before tracing 1")
+	before2, stream2 := record("before tracing 2")
+	beforeAuth, stream3 := record("before auth")
+	afterAuth, stream4 := record("after auth")
+	m := NewServerMiddleware(NewClientWithExporter(&recordingExporter{})).
+		WithRecovery().
+		WithInterceptor(AfterAuth, afterAuth, stream4).
+		WithInterceptor(BeforeAuth, beforeAuth, stream3).
+		WithInterceptor(BeforeTracing, before1, stream1).
+		WithInterceptor(BeforeTracing, before2, stream2).
+		WithAuthLabeler(func(ctx context.Context) map[string]string {
+			order = append(order, "auth")
+			return nil
+		})
+	unary, stream := m.Build()
+	want := []string{"before tracing 1", "before tracing 2", "before auth", "traced", "auth", "after auth", "traced", "handler"}
+
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Unary"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		order = append(order, "handler")
+		return nil, nil
+	})
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("unary order = %q; want %q", order, want)
+	}
+
+	order = nil
+	stream(nil, newTracedStream(0), &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		order = append(order, "handler")
+		return nil
+	})
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("stream order = %q; want %q", order, want)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+// WithObserveOnly returns an InterceptorOption that makes the client
+// interceptors trace outgoing calls without changing them.  The span of
+// each call is created, as a child of the span in the call's context, and
+// uploaded as usual, but no trace header is added to the call's metadata,
+// and metadata saved by WithForwardedMetadata isn't added either.  Metadata
+// already in the outgoing context, including a trace header copied from
+// the incoming call by a pass-through proxy, is sent unchanged, as are the
+// call options, including those returned by GRPCCallOption.
+//
+// The callee doesn't learn the ID of the call's span, so the spans it
+// records aren't children of it.
+//
+// It applies to the client interceptors.
+func WithObserveOnly() InterceptorOption {
+	return withObserveOnly{}
+}
+
+type withObserveOnly struct{}
+
+func (withObserveOnly) configureInterceptor(c *interceptorConfig) {
+	c.observeOnly = true
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestObserveOnly(t *testing.T) {
+	// A header copied verbatim from an incoming call, in a form the package
+	// wouldn't produce itself.
+	const incoming = "0123456789ABCDEF0123456789abcdef/0042;o=1;x=y"
+	for _, tt := range []struct {
+		desc string
+		md   metadata.MD // outgoing metadata; nil for none.
+	}{
+		{desc: "no metadata"},
+		{desc: "forwarded header", md: metadata.Pairs(grpcMetadataKey, incoming, "other", "v")},
+		{desc: "other metadata", md: metadata.Pairs("other", "v")},
+	} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		root := tc.NewSpan("/root")
+		ctx := context.Background()
+		if tt.md != nil {
+			ctx = metadata.NewOutgoingContext(ctx, tt.md)
+		}
+		ctx = NewContext(ctx, root)
+		var sent []metadata.MD
+		record := func(ctx context.Context) {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			sent = append(sent, md)
+		}
+
+		err := GRPCClientInterceptor(WithObserveOnly())(ctx, "/unary", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			record(ctx)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err := GRPCStreamClientInterceptor(WithObserveOnly())(ctx, &grpc.StreamDesc{}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			record(ctx)
+			return &fakeClientStream{ctx: ctx}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs.(*ClientStreamWrapper).finish(nil)
+
+		for i, md := range sent {
+			if !reflect.DeepEqual(md, tt.md) {
+				t.Errorf("%s: call %d sent metadata %v; want %v unchanged", tt.desc, i, md, tt.md)
+			}
+		}
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		rootData := e.span("/root")
+		for _, name := range []string{"/unary", "/stream"} {
+			s := e.span(name)
+			if s == nil {
+				t.Errorf("%s: exported spans %v; want %s", tt.desc, names(e.spans), name)
+				continue
+			}
+			if s.ParentSpanID != rootData.SpanID || s.TraceID != rootData.TraceID {
+				t.Errorf("%s: span %s has parent %s/%d; want the root span %s/%d", tt.desc, name, s.TraceID, s.ParentSpanID, rootData.TraceID, rootData.SpanID)
+			}
+		}
+	}
+}
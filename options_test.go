@@ -0,0 +1,88 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestHeaderOptionsRoundTrip(t *testing.T) {
+	const traceID = "0123456789abcdef0123456789abcdef"
+	for _, o := range []uint32{0, 1, 2, 3, 4, 5, 0x0f, 0x81, 255, 1 << 20} {
+		for _, policy := range []SamplingPolicy{nil, alwaysTrace{}, neverTrace{}} {
+			tc := NewClientWithExporter(&recordingExporter{})
+			tc.SetSamplingPolicy(policy)
+			header := fmt.Sprintf("%s/42;o=%d", traceID, o)
+			span := tc.SpanFromHeader("/foo", header)
+
+			want := o
+			if span.tracing() {
+				want |= uint32(optionTrace)
+			}
+			sc := span.SpanContext()
+			if got := sc.Options(); got != want {
+				t.Errorf("%s, %T: SpanContext().Options() = %d; want %d", header, policy, got, want)
+			}
+			if got, want := sc.StackTraceRequested(), o&2 != 0; got != want {
+				t.Errorf("%s, %T: StackTraceRequested() = %v; want %v", header, policy, got, want)
+			}
+			if got, want := sc.Sampled(), want&1 != 0; got != want {
+				t.Errorf("%s, %T: Sampled() = %v; want %v", header, policy, got, want)
+			}
+			if got := span.RemoteParent().StackTraceRequested(); got != (o&2 != 0) {
+				t.Errorf("%s, %T: RemoteParent().StackTraceRequested() = %v; want %v", header, policy, got, o&2 != 0)
+			}
+
+			req, _ := http.NewRequest("GET", "http://example.com/bar", nil)
+			span.NewRemoteChild(req)
+			_, _, got, _, ok := parseHeader(req.Header.Get(httpHeader))
+			if !ok || uint32(got) != want {
+				t.Errorf("%s, %T: outgoing header %q; want o=%d", header, policy, req.Header.Get(httpHeader), want)
+			}
+		}
+	}
+}
+
+func TestStackTraceRequested(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	for _, o := range []int{1, 3} {
+		span := tc.SpanFromHeader(fmt.Sprintf("/o=%d", o), fmt.Sprintf("0123456789abcdef0123456789abcdef/42;o=%d", o))
+		if err := span.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		_, got := e.span(fmt.Sprintf("/o=%d", o)).Labels[labelStackTrace]
+		if want := o == 3; got != want {
+			t.Errorf("o=%d: span has a stack trace: %v; want %v", o, got, want)
+		}
+	}
+}
+
+func TestSpanContextOptionsEncoding(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.SpanFromHeader("/foo", "0123456789abcdef0123456789abcdef/42;o=11")
+	sc, err := UnmarshalSpanContext(MarshalSpanContext(span.SpanContext()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sc.Options(), uint32(11); got != want {
+		t.Errorf("decoded Options() = %d; want %d", got, want)
+	}
+	if !sc.StackTraceRequested() || !sc.Sampled() {
+		t.Errorf("decoded span context %+v doesn't request stack traces and tracing", sc)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// orcaTrailerKey is the trailer in which backends report their load, as an
+// ORCA OrcaLoadReport protocol buffer.
+const orcaTrailerKey = "endpoint-load-metrics-bin"
+
+const (
+	labelORCACPU     = `orca/cpu_utilization`
+	labelORCAMemory  = `orca/mem_utilization`
+	labelORCADropped = `orca/dropped_metrics`
+
+	// Prefixes of the labels of the named metrics of a report.
+	labelORCARequestCost = `orca/request_cost/`
+	labelORCAUtilization = `orca/utilization/`
+	labelORCANamed       = `orca/named_metrics/`
+)
+
+// WithBackendMetrics returns an InterceptorOption that makes the client
+// interceptors label the span of each call with the load the backend
+// reported for it in an ORCA per-call load report, which backends send in
+// the endpoint-load-metrics-bin trailer.  The CPU and memory utilization
+// are recorded as "orca/cpu_utilization" and "orca/mem_utilization", and
+// the named request costs, utilizations and metrics of the report as
+// "orca/request_cost/<name>", "orca/utilization/<name>" and
+// "orca/named_metrics/<name>".
+//
+// At most maxMetrics named values are recorded for each call, or all of them
+// if maxMetrics is negative, in order of
+// their labels; the number of the others is recorded as
+// "orca/dropped_metrics".  Calls without a report, or with a malformed one,
+// get no labels.  The report of a stream is read when RecvMsg returns an
+// error, so a stream whose span finishes at CloseSend has no report.
+//
+// It applies to the client interceptors.
+func WithBackendMetrics(maxMetrics int) InterceptorOption {
+	return withBackendMetrics{maxMetrics}
+}
+
+type withBackendMetrics struct {
+	max int
+}
+
+func (o withBackendMetrics) configureInterceptor(c *interceptorConfig) {
+	c.backendMetrics = true
+	c.maxBackendMetrics = o.max
+}
+
+// setBackendMetricLabels labels span with the ORCA load report in trailer,
+// if it has a well-formed one.
+func setBackendMetricLabels(span *Span, trailer metadata.MD, maxMetrics int) {
+	if !span.traced() {
+		return
+	}
+	v := trailer[orcaTrailerKey]
+	if len(v) == 0 {
+		return
+	}
+	r, err := parseORCAReport([]byte(v[0]))
+	if err != nil {
+		return
+	}
+	if r.hasCPU {
+		span.setLabel(labelORCACPU, strconv.FormatFloat(r.cpu, 'g', -1, 64))
+	}
+	if r.hasMemory {
+		span.setLabel(labelORCAMemory, strconv.FormatFloat(r.memory, 'g', -1, 64))
+	}
+	keys := make([]string, 0, len(r.metrics))
+	for k := range r.metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	dropped := 0
+	for i, k := range keys {
+		if maxMetrics >= 0 && i >= maxMetrics {
+			dropped = len(keys) - i
+			break
+		}
+		span.setLabel(k, strconv.FormatFloat(r.metrics[k], 'g', -1, 64))
+	}
+	if dropped > 0 {
+		span.setLabel(labelORCADropped, strconv.Itoa(dropped))
+	}
+}
+
+// An orcaReport holds the fields of an ORCA OrcaLoadReport that are
+// recorded in labels.  metrics is keyed by label.
+type orcaReport struct {
+	cpu, memory       float64
+	hasCPU, hasMemory bool
+	metrics           map[string]float64
+}
+
+var errMalformedORCA = errors.New("trace: malformed ORCA load report")
+
+// Protocol buffer wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// parseORCAReport decodes b, an encoded xds.data.orca.v3.OrcaLoadReport.
+func parseORCAReport(b []byte) (orcaReport, error) {
+	var r orcaReport
+	for len(b) > 0 {
+		field, wire, n := protoTag(b)
+		if n <= 0 {
+			return r, errMalformedORCA
+		}
+		b = b[n:]
+		switch {
+		case (field == 1 || field == 2) && wire == wireFixed64:
+			if len(b) < 8 {
+				return r, errMalformedORCA
+			}
+			v := math.Float64frombits(binary.LittleEndian.Uint64(b))
+			if field == 1 {
+				r.cpu, r.hasCPU = v, true
+			} else {
+				r.memory, r.hasMemory = v, true
+			}
+			b = b[8:]
+		case (field == 4 || field == 5 || field == 8) && wire == wireBytes:
+			entry, rest, ok := protoBytes(b)
+			if !ok {
+				return r, errMalformedORCA
+			}
+			name, v, ok := parseORCAEntry(entry)
+			if !ok {
+				return r, errMalformedORCA
+			}
+			prefix := labelORCARequestCost
+			if field == 5 {
+				prefix = labelORCAUtilization
+			} else if field == 8 {
+				prefix = labelORCANamed
+			}
+			if r.metrics == nil {
+				r.metrics = make(map[string]float64)
+			}
+			r.metrics[prefix+name] = v
+			b = rest
+		default:
+			n := skipProtoField(b, wire)
+			if n < 0 {
+				return r, errMalformedORCA
+			}
+			b = b[n:]
+		}
+	}
+	return r, nil
+}
+
+// parseORCAEntry decodes an entry of a map<string, double> field.
+func parseORCAEntry(b []byte) (key string, value float64, ok bool) {
+	for len(b) > 0 {
+		field, wire, n := protoTag(b)
+		if n <= 0 {
+			return "", 0, false
+		}
+		b = b[n:]
+		switch {
+		case field == 1 && wire == wireBytes:
+			k, rest, ok := protoBytes(b)
+			if !ok {
+				return "", 0, false
+			}
+			key, b = string(k), rest
+		case field == 2 && wire == wireFixed64:
+			if len(b) < 8 {
+				return "", 0, false
+			}
+			value, b = math.Float64frombits(binary.LittleEndian.Uint64(b)), b[8:]
+		default:
+			n := skipProtoField(b, wire)
+			if n < 0 {
+				return "", 0, false
+			}
+			b = b[n:]
+		}
+	}
+	return key, value, true
+}
+
+// protoTag decodes the tag at the start of b, and returns its field number,
+// its wire type and its length, which is not positive if b doesn't start
+// with a tag.
+func protoTag(b []byte) (field uint64, wire int, n int) {
+	tag, n := binary.Uvarint(b)
+	if n <= 0 || tag>>3 == 0 {
+		return 0, 0, -1
+	}
+	return tag >> 3, int(tag & 7), n
+}
+
+// protoBytes decodes the length-delimited value at the start of b.
+func protoBytes(b []byte) (value, rest []byte, ok bool) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || l > uint64(len(b)-n) {
+		return nil, nil, false
+	}
+	return b[n : n+int(l)], b[n+int(l):], true
+}
+
+// skipProtoField returns the length of the value of wire type wire at the
+// start of b, or -1 if it's malformed.
+func skipProtoField(b []byte, wire int) int {
+	switch wire {
+	case wireVarint:
+		if _, n := binary.Uvarint(b); n > 0 {
+			return n
+		}
+	case wireFixed64:
+		if len(b) >= 8 {
+			return 8
+		}
+	case wireFixed32:
+		if len(b) >= 4 {
+			return 4
+		}
+	case wireBytes:
+		if _, rest, ok := protoBytes(b); ok {
+			return len(b) - len(rest)
+		}
+	}
+	return -1
+}
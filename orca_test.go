@@ -0,0 +1,184 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// orcaReportBuilder encodes an OrcaLoadReport.
+type orcaReportBuilder []byte
+
+func (b orcaReportBuilder) tag(field, wire int) orcaReportBuilder {
+	return b.varint(uint64(field<<3 | wire))
+}
+
+func (b orcaReportBuilder) varint(v uint64) orcaReportBuilder {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (b orcaReportBuilder) double(field int, v float64) orcaReportBuilder {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b.tag(field, wireFixed64), buf[:]...)
+}
+
+func (b orcaReportBuilder) bytes(field int, v []byte) orcaReportBuilder {
+	return append(b.tag(field, wireBytes).varint(uint64(len(v))), v...)
+}
+
+func (b orcaReportBuilder) entry(field int, key string, v float64) orcaReportBuilder {
+	return b.bytes(field, orcaReportBuilder(nil).bytes(1, []byte(key)).double(2, v))
+}
+
+// testORCAReport is a report with every kind of field, including an rps
+// and an unknown field that aren't recorded.
+var testORCAReport = orcaReportBuilder(nil).
+	double(1, 0.5).
+	double(2, 0.25).
+	tag(3, wireVarint).varint(1000).
+	entry(4, "db", 3).
+	entry(5, "disk", 0.75).
+	entry(8, "queue", 12).
+	tag(99, wireFixed32).varint(0).varint(0).varint(0).varint(0)
+
+var testORCALabels = map[string]string{
+	"orca/cpu_utilization":     "0.5",
+	"orca/mem_utilization":     "0.25",
+	"orca/request_cost/db":     "3",
+	"orca/utilization/disk":    "0.75",
+	"orca/named_metrics/queue": "12",
+}
+
+// orcaLabels returns the labels of s that record backend metrics.
+func orcaLabels(s *SpanData) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range s.Labels {
+		if len(k) > 5 && k[:5] == "orca/" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// orcaTrailerStream is a client stream that ends with trailer.
+type orcaTrailerStream struct {
+	fakeClientStream
+	trailer metadata.MD
+}
+
+func (s *orcaTrailerStream) RecvMsg(m interface{}) error { return io.EOF }
+func (s *orcaTrailerStream) Trailer() metadata.MD        { return s.trailer }
+
+// backendMetricCalls makes a unary call and a stream call whose trailers
+// are trailer, with the client interceptors given opts, and returns the
+// spans of the calls.
+func backendMetricCalls(t *testing.T, trailer metadata.MD, opts ...InterceptorOption) (unary, stream *SpanData) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+
+	err := GRPCClientInterceptor(opts...)(ctx, "/unary", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		for _, o := range opts {
+			if o, ok := o.(grpc.TrailerCallOption); ok {
+				*o.TrailerAddr = trailer
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := GRPCStreamClientInterceptor(opts...)(ctx, &grpc.StreamDesc{}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &orcaTrailerStream{fakeClientStream: fakeClientStream{ctx: ctx}, trailer: trailer}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.RecvMsg(nil); err != io.EOF {
+		t.Fatalf("RecvMsg: got %v, want io.EOF", err)
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	return e.span("/unary"), e.span("/stream")
+}
+
+func TestBackendMetrics(t *testing.T) {
+	trailer := metadata.Pairs(orcaTrailerKey, string(testORCAReport))
+	unary, stream := backendMetricCalls(t, trailer, WithBackendMetrics(10))
+	for _, s := range []*SpanData{unary, stream} {
+		if got := orcaLabels(s); !reflect.DeepEqual(got, testORCALabels) {
+			t.Errorf("%s: backend metric labels %v; want %v", s.Name, got, testORCALabels)
+		}
+	}
+
+	// Without the option, reports are not recorded.
+	unary, stream = backendMetricCalls(t, trailer)
+	for _, s := range []*SpanData{unary, stream} {
+		if got := orcaLabels(s); len(got) != 0 {
+			t.Errorf("%s without WithBackendMetrics: backend metric labels %v; want none", s.Name, got)
+		}
+	}
+}
+
+func TestBackendMetricsCap(t *testing.T) {
+	trailer := metadata.Pairs(orcaTrailerKey, string(testORCAReport))
+	unary, stream := backendMetricCalls(t, trailer, WithBackendMetrics(2))
+	want := map[string]string{
+		"orca/cpu_utilization":     "0.5",
+		"orca/mem_utilization":     "0.25",
+		"orca/named_metrics/queue": "12",
+		"orca/request_cost/db":     "3",
+		"orca/dropped_metrics":     "1",
+	}
+	for _, s := range []*SpanData{unary, stream} {
+		if got := orcaLabels(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: backend metric labels %v; want %v", s.Name, got, want)
+		}
+	}
+}
+
+func TestBackendMetricsIgnored(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		trailer metadata.MD
+	}{
+		{desc: "no trailer"},
+		{desc: "no report", trailer: metadata.Pairs("other", "v")},
+		{desc: "truncated", trailer: metadata.Pairs(orcaTrailerKey, string(testORCAReport[:len(testORCAReport)-3]))},
+		{desc: "truncated double", trailer: metadata.Pairs(orcaTrailerKey, string(testORCAReport[:5]))},
+		{desc: "bad entry length", trailer: metadata.Pairs(orcaTrailerKey, string(orcaReportBuilder(nil).tag(4, wireBytes).varint(50)))},
+		{desc: "bad wire type", trailer: metadata.Pairs(orcaTrailerKey, string(orcaReportBuilder(nil).tag(1, 7)))},
+		{desc: "field zero", trailer: metadata.Pairs(orcaTrailerKey, "\x00\x00")},
+	} {
+		unary, stream := backendMetricCalls(t, tt.trailer, WithBackendMetrics(10))
+		for _, s := range []*SpanData{unary, stream} {
+			if got := orcaLabels(s); len(got) != 0 {
+				t.Errorf("%s: %s: backend metric labels %v; want none", tt.desc, s.Name, got)
+			}
+		}
+	}
+}
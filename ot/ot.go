@@ -0,0 +1,345 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ot adapts package trace to the OpenTracing API, so that libraries
+// instrumented with opentracing-go add their spans to the traces of a
+// *trace.Client:
+//
+//   opentracing.SetGlobalTracer(ot.NewTracer(traceClient))
+//
+// A span started as a child of the context of a span of package trace, from
+// ContextWithSpan, or of a span context extracted from a request, is in the
+// same trace as its parent.  Spans started without a parent are the root
+// spans of new traces.
+//
+// It is a separate package so that programs that use package trace don't
+// depend on opentracing-go.
+package ot // import "cloud.google.com/go/trace/ot"
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/trace"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	// httpHeaderKey is the key of the trace header in HTTP headers.
+	httpHeaderKey = "X-Cloud-Trace-Context"
+	// textMapKey is the key of the trace header in text maps, the key of
+	// gRPC metadata.
+	textMapKey = "x-cloud-trace-context"
+)
+
+// A Tracer is an opentracing.Tracer whose spans are spans of a
+// *trace.Client.
+//
+// StartSpan starts a child of the first ChildOf or FollowsFrom reference to
+// a span context of the Tracer.  A FollowsFrom reference is treated as a
+// ChildOf reference, so the new span is nested under the span it follows;
+// package trace has no other kind of relationship between spans.  References
+// to span contexts of other tracers are ignored.  The span of a reference to
+// a local span is started with its NewChild method, and the span of a
+// reference to an extracted span context is started like the span of a
+// traced request, with the extracted span as its parent.  The sampling
+// decision of a new span is made as it is for requests.
+//
+// The tags of a span set its labels, with the values encoded by
+// Span.SetLabelAny, and its logs are annotations, with the value of its
+// "event" or "message" field, or "log", as their message, and the other
+// fields as attributes.  Annotations have the time at which they are
+// logged, not the timestamps of log records.  Baggage items are kept by the
+// span contexts of the Tracer, and inherited by child spans, but aren't
+// propagated to other processes.
+//
+// Inject and Extract support the opentracing.HTTPHeaders and
+// opentracing.TextMap formats, in which the span context is the
+// X-Cloud-Trace-Context header used by package trace.  The header of an
+// injected span is the one Header returns.
+type Tracer struct {
+	client *trace.Client
+}
+
+// NewTracer returns a Tracer that makes spans with tc.
+func NewTracer(tc *trace.Client) *Tracer {
+	return &Tracer{client: tc}
+}
+
+var _ opentracing.Tracer = (*Tracer)(nil)
+
+// ContextWithSpan returns the span context of span, a span of package trace,
+// for use in ChildOf references:
+//
+//   child := tracer.StartSpan("cache.Get", opentracing.ChildOf(ot.ContextWithSpan(span)))
+//
+// A nil span has a span context that starts new traces.
+func ContextWithSpan(span *trace.Span) opentracing.SpanContext {
+	return spanContext{span: span}
+}
+
+// SpanOf returns the span of package trace that backs span, a span of a
+// Tracer, or nil if span is nil or isn't a span of a Tracer.
+func SpanOf(span opentracing.Span) *trace.Span {
+	if s, ok := span.(*otSpan); ok {
+		return s.span
+	}
+	return nil
+}
+
+// A spanContext is the span context of a local span, or of a span extracted
+// from a request.
+type spanContext struct {
+	span    *trace.Span       // the local span, or nil.
+	remote  trace.SpanContext // the extracted span, if span is nil.
+	baggage map[string]string // never modified.
+}
+
+func (c spanContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	for k, v := range c.baggage {
+		if !handler(k, v) {
+			return
+		}
+	}
+}
+
+// StartSpan starts a span named operationName.
+func (t *Tracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var so opentracing.StartSpanOptions
+	for _, o := range opts {
+		o.Apply(&so)
+	}
+	var parent *spanContext
+	for _, ref := range so.References {
+		if ref.Type != opentracing.ChildOfRef && ref.Type != opentracing.FollowsFromRef {
+			continue
+		}
+		if c, ok := ref.ReferencedContext.(spanContext); ok {
+			parent = &c
+			break
+		}
+	}
+
+	s := &otSpan{tracer: t, owned: true}
+	switch {
+	case parent == nil:
+		s.span = t.client.NewSpan(operationName)
+	case parent.span != nil:
+		s.span = parent.span.NewChild(operationName)
+		// NewChild returns the parent itself if it isn't traced, and the
+		// parent isn't ours to finish.
+		s.owned = s.span != parent.span
+		s.baggage = parent.baggage
+	case parent.remote.TraceID != "":
+		s.span = t.client.SpanFromSpanContext(operationName, parent.remote)
+		s.baggage = parent.baggage
+	default:
+		// A span context from ContextWithSpan(nil).
+		s.span = t.client.NewSpan(operationName)
+		s.baggage = parent.baggage
+	}
+	if s.owned && !so.StartTime.IsZero() {
+		s.span.SetStartTime(so.StartTime)
+	}
+	for k, v := range so.Tags {
+		s.SetTag(k, v)
+	}
+	return s
+}
+
+// Inject writes the header of the span context sm to carrier, an
+// opentracing.TextMapWriter, for the HTTPHeaders and TextMap formats.
+func (t *Tracer) Inject(sm opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	c, ok := sm.(spanContext)
+	if !ok {
+		return opentracing.ErrInvalidSpanContext
+	}
+	key, err := formatKey(format)
+	if err != nil {
+		return err
+	}
+	w, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	var header string
+	if c.span != nil {
+		header = c.span.Header()
+	} else if c.remote.TraceID != "" {
+		m := trace.MapCarrier{}
+		trace.GooglePropagator.Inject(c.remote, m)
+		header = m.Get(httpHeaderKey)
+	}
+	if header != "" {
+		w.Set(key, header)
+	}
+	return nil
+}
+
+// Extract reads the span context in the header in carrier, an
+// opentracing.TextMapReader, for the HTTPHeaders and TextMap formats.  The
+// header is found whatever the case of its key.
+func (t *Tracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	if _, err := formatKey(format); err != nil {
+		return nil, err
+	}
+	r, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return nil, opentracing.ErrInvalidCarrier
+	}
+	var header string
+	err := r.ForeachKey(func(k, v string) error {
+		if strings.EqualFold(k, httpHeaderKey) {
+			header = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if header == "" {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	sc, err := trace.GooglePropagator.Extract(trace.MapCarrier{textMapKey: header})
+	if err != nil {
+		return nil, opentracing.ErrSpanContextCorrupted
+	}
+	return spanContext{remote: sc}, nil
+}
+
+// formatKey returns the key of the trace header in carriers of format.
+func formatKey(format interface{}) (string, error) {
+	switch format {
+	case opentracing.HTTPHeaders:
+		return httpHeaderKey, nil
+	case opentracing.TextMap:
+		return textMapKey, nil
+	}
+	return "", opentracing.ErrUnsupportedFormat
+}
+
+// An otSpan is a span of a Tracer.
+type otSpan struct {
+	tracer *Tracer
+	span   *trace.Span
+	owned  bool // whether span was started by the Tracer, to be finished.
+
+	mu      sync.Mutex
+	baggage map[string]string // copied on write.
+}
+
+func (s *otSpan) Finish() {
+	if s.owned {
+		s.span.Finish()
+	}
+}
+
+func (s *otSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	for _, r := range opts.LogRecords {
+		s.LogFields(r.Fields...)
+	}
+	for _, d := range opts.BulkLogData {
+		r := d.ToLogRecord()
+		s.LogFields(r.Fields...)
+	}
+	if !s.owned {
+		return
+	}
+	if opts.FinishTime.IsZero() {
+		s.span.Finish()
+	} else {
+		s.span.FinishAt(opts.FinishTime)
+	}
+}
+
+func (s *otSpan) Context() opentracing.SpanContext {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return spanContext{span: s.span, baggage: s.baggage}
+}
+
+func (s *otSpan) SetOperationName(operationName string) opentracing.Span {
+	if s.owned {
+		s.span.SetName(operationName)
+	}
+	return s
+}
+
+func (s *otSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.span.SetLabelAny(key, value)
+	return s
+}
+
+func (s *otSpan) LogFields(fields ...log.Field) {
+	msg := ""
+	var keyvals []string
+	for _, f := range fields {
+		v := fmt.Sprint(f.Value())
+		if k := f.Key(); msg == "" && (k == "event" || k == "message") {
+			msg = v
+		} else {
+			keyvals = append(keyvals, k, v)
+		}
+	}
+	if msg == "" {
+		msg = "log"
+	}
+	s.span.Annotate(msg, keyvals...)
+}
+
+func (s *otSpan) LogKV(alternatingKeyValues ...interface{}) {
+	fields, err := log.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		s.LogFields(log.Error(err), log.String("function", "LogKV"))
+		return
+	}
+	s.LogFields(fields...)
+}
+
+func (s *otSpan) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	baggage := make(map[string]string, len(s.baggage)+1)
+	for k, v := range s.baggage {
+		baggage[k] = v
+	}
+	baggage[restrictedKey] = value
+	s.baggage = baggage
+	return s
+}
+
+func (s *otSpan) BaggageItem(restrictedKey string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baggage[restrictedKey]
+}
+
+func (s *otSpan) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+func (s *otSpan) LogEvent(event string) {
+	s.LogFields(log.String("event", event))
+}
+
+func (s *otSpan) LogEventWithPayload(event string, payload interface{}) {
+	s.LogFields(log.String("event", event), log.Object("payload", payload))
+}
+
+func (s *otSpan) Log(data opentracing.LogData) {
+	r := data.ToLogRecord()
+	s.LogFields(r.Fields...)
+}
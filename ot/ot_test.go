@@ -0,0 +1,230 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ot
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+	"cloud.google.com/go/trace/tracetest"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+func TestStartSpan(t *testing.T) {
+	e := &tracetest.Exporter{}
+	tc := trace.NewClientWithExporter(e)
+	tracer := NewTracer(tc)
+	root := tc.NewSpan("/root")
+
+	child := tracer.StartSpan("child", opentracing.ChildOf(ContextWithSpan(root)), opentracing.Tag{Key: "k", Value: 7})
+	child.SetTag("ok", true)
+	child.SetBaggageItem("user", "alice")
+	child.LogKV("event", "cache miss", "key", "a")
+	start := time.Now().Add(-time.Second)
+	grandchild := tracer.StartSpan("grandchild", opentracing.FollowsFrom(child.Context()), opentracing.StartTime(start))
+	grandchild.SetOperationName("renamed")
+	if got := grandchild.BaggageItem("user"); got != "alice" {
+		t.Errorf("grandchild BaggageItem(user) = %q; want alice", got)
+	}
+	grandchild.Finish()
+	child.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := e.TraceByID(root.TraceID())
+	if len(nodes) != 1 || nodes[0].Span.Name != "/root" || len(nodes[0].Children) != 1 {
+		t.Fatalf("trace %v; want /root with one child", nodes)
+	}
+	c := nodes[0].Children[0]
+	if c.Span.Name != "child" || len(c.Children) != 1 || c.Children[0].Span.Name != "renamed" {
+		t.Fatalf("child %v; want child with the child renamed", c.Span)
+	}
+	if got, want := c.Span.Labels, map[string]string{"k": "7", "ok": "true"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("child labels %v; want %v", got, want)
+	}
+	if got := c.Span.Annotations; len(got) != 1 || got[0].Message != "cache miss" || !reflect.DeepEqual(got[0].Attributes, map[string]string{"key": "a"}) {
+		t.Errorf("child annotations %v; want a cache miss of key a", got)
+	}
+	if got := c.Children[0].Span.Start; !got.Equal(start) {
+		t.Errorf("grandchild start %v; want %v", got, start)
+	}
+	if got := SpanOf(child); got == nil || got.TraceID() != root.TraceID() {
+		t.Errorf("SpanOf(child) = %v; want a span of trace %s", got, root.TraceID())
+	}
+}
+
+func TestStartRootSpan(t *testing.T) {
+	e := &tracetest.Exporter{}
+	tc := trace.NewClientWithExporter(e)
+	tracer := NewTracer(tc)
+	for _, opts := range [][]opentracing.StartSpanOption{
+		nil,
+		{opentracing.ChildOf(ContextWithSpan(nil))},
+		{opentracing.ChildOf(foreignContext{})},
+	} {
+		e.Reset()
+		span := tracer.StartSpan("root", opts...)
+		if s := SpanOf(span); s == nil {
+			t.Fatalf("%v: SpanOf = nil", opts)
+		}
+		span.Finish()
+		if got := flush(t, tc, e); len(got) != 1 || got[0].ParentSpanID != 0 {
+			t.Errorf("%v: exported %v; want a root span", opts, got)
+		}
+	}
+}
+
+func TestUntracedParent(t *testing.T) {
+	tc := trace.NewClientWithExporter(&tracetest.Exporter{})
+	parent := tc.SpanFromHeader("/parent", "0123456789abcdef0123456789abcdef/7;o=0")
+	child := NewTracer(tc).StartSpan("child", opentracing.ChildOf(ContextWithSpan(parent)))
+	if SpanOf(child) != parent {
+		t.Fatalf("child of an untraced span is %v; want the parent", SpanOf(child))
+	}
+	// Finishing the child doesn't finish the parent, which can still start
+	// children that propagate the trace.
+	child.Finish()
+	if got, want := parent.NewChild("/next").TraceID(), parent.TraceID(); got != want {
+		t.Errorf("trace of the parent after the child finished = %q; want %q", got, want)
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	e := &tracetest.Exporter{}
+	tc := trace.NewClientWithExporter(e)
+	tracer := NewTracer(tc)
+	root := tc.NewSpan("/root")
+	client := tracer.StartSpan("client", opentracing.ChildOf(ContextWithSpan(root)))
+
+	for _, tt := range []struct {
+		format  interface{}
+		carrier interface {
+			opentracing.TextMapWriter
+			opentracing.TextMapReader
+		}
+		key string
+	}{
+		{opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(http.Header{}), "X-Cloud-Trace-Context"},
+		{opentracing.TextMap, opentracing.TextMapCarrier{}, "x-cloud-trace-context"},
+	} {
+		if err := tracer.Inject(client.Context(), tt.format, tt.carrier); err != nil {
+			t.Fatalf("%v: Inject: %v", tt.format, err)
+		}
+		var header string
+		tt.carrier.ForeachKey(func(k, v string) error {
+			if k == tt.key {
+				header = v
+			}
+			return nil
+		})
+		if want := SpanOf(client).Header(); header != want {
+			t.Errorf("%v: injected header %q; want %q", tt.format, header, want)
+		}
+
+		sc, err := tracer.Extract(tt.format, tt.carrier)
+		if err != nil {
+			t.Fatalf("%v: Extract: %v", tt.format, err)
+		}
+		// An extracted span context can be injected again.
+		out := opentracing.TextMapCarrier{}
+		if err := tracer.Inject(sc, opentracing.TextMap, out); err != nil {
+			t.Fatal(err)
+		}
+		if got := out["x-cloud-trace-context"]; got != header {
+			t.Errorf("%v: reinjected header %q; want %q", tt.format, got, header)
+		}
+
+		// The server span has the client span as its parent, as it would if
+		// the server interceptors had created it.
+		e.Reset()
+		tracer.StartSpan("server", opentracing.ChildOf(sc)).Finish()
+		got := flush(t, tc, e)
+		if len(got) != 1 || got[0].TraceID != root.TraceID() || got[0].ParentSpanID != SpanOf(client).SpanContext().SpanID {
+			t.Errorf("%v: exported server spans %v; want the child of the client span", tt.format, got)
+		}
+	}
+}
+
+func TestInjectExtractErrors(t *testing.T) {
+	tracer := NewTracer(trace.NewClientWithExporter(&tracetest.Exporter{}))
+	span := tracer.StartSpan("span")
+	if err := tracer.Inject(span.Context(), opentracing.Binary, &struct{}{}); err != opentracing.ErrUnsupportedFormat {
+		t.Errorf("Inject Binary: %v; want %v", err, opentracing.ErrUnsupportedFormat)
+	}
+	if err := tracer.Inject(span.Context(), opentracing.TextMap, "carrier"); err != opentracing.ErrInvalidCarrier {
+		t.Errorf("Inject to a string: %v; want %v", err, opentracing.ErrInvalidCarrier)
+	}
+	if err := tracer.Inject(foreignContext{}, opentracing.TextMap, opentracing.TextMapCarrier{}); err != opentracing.ErrInvalidSpanContext {
+		t.Errorf("Inject a foreign span context: %v; want %v", err, opentracing.ErrInvalidSpanContext)
+	}
+	for _, tt := range []struct {
+		format  interface{}
+		carrier interface{}
+		want    error
+	}{
+		{opentracing.Binary, &struct{}{}, opentracing.ErrUnsupportedFormat},
+		{opentracing.TextMap, "carrier", opentracing.ErrInvalidCarrier},
+		{opentracing.TextMap, opentracing.TextMapCarrier{"other": "v"}, opentracing.ErrSpanContextNotFound},
+		{opentracing.TextMap, opentracing.TextMapCarrier{"x-cloud-trace-context": "bogus"}, opentracing.ErrSpanContextCorrupted},
+	} {
+		if _, err := tracer.Extract(tt.format, tt.carrier); err != tt.want {
+			t.Errorf("Extract(%v, %v): %v; want %v", tt.format, tt.carrier, err, tt.want)
+		}
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	e := &tracetest.Exporter{}
+	tc := trace.NewClientWithExporter(e)
+	span := NewTracer(tc).StartSpan("span")
+	span.LogFields(log.String("message", "started"), log.Int("n", 3))
+	span.LogFields(log.Bool("retry", true))
+	span.LogEvent("event")
+	span.Finish()
+	got := flush(t, tc, e)
+	if len(got) != 1 {
+		t.Fatalf("exported %v; want one span", got)
+	}
+	var msgs []string
+	for _, a := range got[0].Annotations {
+		msgs = append(msgs, a.Message)
+	}
+	if want := []string{"started", "log", "event"}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("annotations %v; want %v", msgs, want)
+	}
+	if got, want := got[0].Annotations[0].Attributes, map[string]string{"n": "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("attributes %v; want %v", got, want)
+	}
+}
+
+// flush uploads the finished traces of tc, and returns the spans exported
+// to e.
+func flush(t *testing.T, tc *trace.Client, e *tracetest.Exporter) []*trace.SpanData {
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return e.Spans()
+}
+
+// foreignContext is a span context of another tracer.
+type foreignContext struct{}
+
+func (foreignContext) ForeachBaggageItem(func(k, v string) bool) {}
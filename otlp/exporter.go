@@ -0,0 +1,426 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otlp provides a trace.Exporter that sends spans to an OpenTelemetry
+// collector using OTLP over gRPC.
+//
+// This package is still experimental and subject to change.
+//
+//   exp, err := otlp.NewExporter(ctx, otlp.WithEndpoint("collector:4317"))
+//   if err != nil {
+//     // TODO: Handle error.
+//   }
+//   defer exp.Close()
+//   traceClient := trace.NewClientWithExporter(exp)
+//
+// Failed exports are retried with exponential backoff as described in the
+// OTLP specification.  If the collector throttles the exporter by returning
+// a RetryInfo detail, the delay it asks for is used instead.
+package otlp // import "cloud.google.com/go/trace/otlp"
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/trace"
+	"github.com/golang/protobuf/ptypes"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultEndpoint is the address of a collector running on the local host.
+	DefaultEndpoint = "localhost:4317"
+
+	scopeName = "cloud.google.com/go/trace"
+)
+
+// RetrySettings controls how failed exports are retried.
+type RetrySettings struct {
+	// Initial is the delay before the first retry.  The delay doubles after
+	// each attempt, up to Max.  A random jitter is applied to each delay.
+	Initial time.Duration
+	Max     time.Duration
+
+	// MaxElapsed bounds the total time spent retrying one batch.  If it is
+	// zero, failed exports are not retried.
+	MaxElapsed time.Duration
+}
+
+// DefaultRetrySettings are the retry settings used unless WithRetry is given.
+var DefaultRetrySettings = RetrySettings{
+	Initial:    5 * time.Second,
+	Max:        30 * time.Second,
+	MaxElapsed: time.Minute,
+}
+
+type settings struct {
+	endpoint string
+	insecure bool
+	creds    credentials.TransportCredentials
+	headers  map[string]string
+	resource map[string]string
+	dialOpts []grpc.DialOption
+	retry    RetrySettings
+	timeout  time.Duration
+}
+
+// An Option configures an Exporter.
+type Option func(*settings)
+
+// WithEndpoint sets the address of the collector.  The default is
+// DefaultEndpoint.
+func WithEndpoint(addr string) Option {
+	return func(s *settings) { s.endpoint = addr }
+}
+
+// WithInsecure disables transport security for the connection to the
+// collector.
+func WithInsecure() Option {
+	return func(s *settings) { s.insecure = true }
+}
+
+// WithTLSCredentials sets the transport credentials used to connect to the
+// collector.  By default the system's root certificates are used.
+func WithTLSCredentials(creds credentials.TransportCredentials) Option {
+	return func(s *settings) { s.creds = creds }
+}
+
+// WithHeaders sets metadata sent with every export request, for example an
+// API key required by the collector.
+func WithHeaders(headers map[string]string) Option {
+	return func(s *settings) { s.headers = headers }
+}
+
+// WithResource sets the attributes of the resource that produced the spans,
+// such as "service.name".
+func WithResource(attrs map[string]string) Option {
+	return func(s *settings) { s.resource = attrs }
+}
+
+// WithDialOptions adds options used when dialing the collector.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(s *settings) { s.dialOpts = append(s.dialOpts, opts...) }
+}
+
+// WithRetry sets the retry settings for failed exports.
+func WithRetry(r RetrySettings) Option {
+	return func(s *settings) { s.retry = r }
+}
+
+// WithTimeout sets the deadline for each export attempt.  The default is ten
+// seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(s *settings) { s.timeout = d }
+}
+
+// Exporter sends spans to an OpenTelemetry collector.  It implements
+// trace.Exporter.
+type Exporter struct {
+	conn     *grpc.ClientConn
+	client   coltracepb.TraceServiceClient
+	md       metadata.MD
+	resource *resourcepb.Resource
+	retry    RetrySettings
+	timeout  time.Duration
+}
+
+// NewExporter returns an Exporter connected to a collector.
+func NewExporter(ctx context.Context, opts ...Option) (*Exporter, error) {
+	s := settings{
+		endpoint: DefaultEndpoint,
+		retry:    DefaultRetrySettings,
+		timeout:  10 * time.Second,
+	}
+	for _, o := range opts {
+		o(&s)
+	}
+	var dialOpts []grpc.DialOption
+	switch {
+	case s.insecure:
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	case s.creds != nil:
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(s.creds))
+	default:
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")))
+	}
+	dialOpts = append(dialOpts, s.dialOpts...)
+	conn, err := grpc.DialContext(ctx, s.endpoint, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("otlp: dialing collector %q: %v", s.endpoint, err)
+	}
+	e := &Exporter{
+		conn:     conn,
+		client:   coltracepb.NewTraceServiceClient(conn),
+		resource: &resourcepb.Resource{Attributes: attributes(s.resource)},
+		retry:    s.retry,
+		timeout:  s.timeout,
+	}
+	if len(s.headers) > 0 {
+		e.md = metadata.New(s.headers)
+	}
+	return e, nil
+}
+
+// Close closes the connection to the collector.
+func (e *Exporter) Close() error {
+	return e.conn.Close()
+}
+
+// ExportSpans sends spans to the collector.  It implements trace.Exporter.
+//
+// Spans whose trace ID is not a 32-digit hex string can't be represented in
+// OTLP; they are dropped, and an error reports how many.
+func (e *Exporter) ExportSpans(ctx context.Context, spans []*trace.SpanData) error {
+	ss := &tracepb.ScopeSpans{Scope: &commonpb.InstrumentationScope{Name: scopeName}}
+	dropped := 0
+	for _, s := range spans {
+		if ps, ok := spanProto(s); ok {
+			ss.Spans = append(ss.Spans, ps)
+		} else {
+			dropped++
+		}
+	}
+	if len(ss.Spans) > 0 {
+		req := &coltracepb.ExportTraceServiceRequest{
+			ResourceSpans: []*tracepb.ResourceSpans{{
+				Resource:   e.resource,
+				ScopeSpans: []*tracepb.ScopeSpans{ss},
+			}},
+		}
+		if err := e.send(ctx, req); err != nil {
+			return err
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("otlp: dropped %d spans with invalid trace IDs", dropped)
+	}
+	return nil
+}
+
+// send makes an export request, retrying on errors that the OTLP
+// specification marks as retryable.
+func (e *Exporter) send(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) error {
+	if e.md != nil {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		ctx = metadata.NewOutgoingContext(ctx, metadata.Join(md, e.md))
+	}
+	giveUp := time.Now().Add(e.retry.MaxElapsed)
+	backoff := e.retry.Initial
+	for {
+		resp, err := e.export(ctx, req)
+		if err == nil {
+			return partialSuccessError(resp.GetPartialSuccess())
+		}
+		delay, ok := retryDelay(err)
+		if !ok || e.retry.MaxElapsed <= 0 {
+			return err
+		}
+		if delay == 0 {
+			delay = jitter(backoff)
+			if backoff *= 2; backoff > e.retry.Max {
+				backoff = e.retry.Max
+			}
+		}
+		if time.Now().Add(delay).After(giveUp) {
+			return err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (e *Exporter) export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	return e.client.Export(ctx, req)
+}
+
+// retryDelay reports whether err is retryable, and how long the server asked
+// the client to wait before retrying, if it did.
+func retryDelay(err error) (time.Duration, bool) {
+	s, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	var (
+		delay     time.Duration
+		throttled bool
+	)
+	for _, d := range s.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			throttled = true
+			if dd, err := ptypes.Duration(ri.RetryDelay); err == nil {
+				delay = dd
+			}
+		}
+	}
+	switch s.Code() {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return delay, true
+	case codes.ResourceExhausted:
+		// The server can only recover from resource exhaustion if it says so.
+		return delay, throttled
+	}
+	return 0, false
+}
+
+func partialSuccessError(ps *coltracepb.ExportTracePartialSuccess) error {
+	if ps.GetRejectedSpans() == 0 {
+		return nil
+	}
+	return fmt.Errorf("otlp: collector rejected %d spans: %s", ps.GetRejectedSpans(), ps.GetErrorMessage())
+}
+
+// jitter returns a random duration in [d/2, d].
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func spanProto(s *trace.SpanData) (*tracepb.Span, bool) {
+	traceID, err := hex.DecodeString(s.TraceID)
+	if err != nil || len(traceID) != 16 {
+		return nil, false
+	}
+	ps := &tracepb.Span{
+		TraceId:           traceID,
+		SpanId:            spanID(s.SpanID),
+		Name:              s.Name,
+		Kind:              spanKind(s.Kind),
+		StartTimeUnixNano: uint64(s.Start.UnixNano()),
+		EndTimeUnixNano:   uint64(s.End.UnixNano()),
+		Attributes:        attributes(s.Labels),
+		Status:            &tracepb.Status{},
+	}
+	if s.ParentSpanID != 0 {
+		ps.ParentSpanId = spanID(s.ParentSpanID)
+	}
+	ps.Events = events(s)
+	ps.Links = links(s)
+	ps.DroppedEventsCount = uint32(s.DroppedAnnotations + s.DroppedMessageEvents)
+	if code, ok := s.Labels["grpc/status_code"]; ok && code != "OK" {
+		ps.Status.Code = tracepb.Status_STATUS_CODE_ERROR
+		ps.Status.Message = s.Labels["grpc/status_message"]
+	} else if msg, ok := s.Labels["error"]; ok {
+		ps.Status.Code = tracepb.Status_STATUS_CODE_ERROR
+		ps.Status.Message = msg
+	}
+	return ps, true
+}
+
+func spanID(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+func spanKind(k trace.SpanKind) tracepb.Span_SpanKind {
+	switch k {
+	case trace.SpanKindClient:
+		return tracepb.Span_SPAN_KIND_CLIENT
+	case trace.SpanKindServer:
+		return tracepb.Span_SPAN_KIND_SERVER
+	}
+	// Spans that aren't one side of a remote call are internal operations.
+	return tracepb.Span_SPAN_KIND_INTERNAL
+}
+
+// events converts the annotations and message events of s to OTLP events,
+// sorted by time.  Message events follow the OpenTelemetry conventions for
+// RPC spans: they are named "message", with the attributes "message.type",
+// "message.id" and "message.uncompressed_size".
+func events(s *trace.SpanData) []*tracepb.Span_Event {
+	if len(s.Annotations)+len(s.MessageEvents) == 0 {
+		return nil
+	}
+	evs := make([]*tracepb.Span_Event, 0, len(s.Annotations)+len(s.MessageEvents))
+	for _, a := range s.Annotations {
+		evs = append(evs, &tracepb.Span_Event{
+			TimeUnixNano: uint64(a.Time.UnixNano()),
+			Name:         a.Message,
+			Attributes:   attributes(a.Attributes),
+		})
+	}
+	for _, m := range s.MessageEvents {
+		evs = append(evs, &tracepb.Span_Event{
+			TimeUnixNano: uint64(m.Time.UnixNano()),
+			Name:         "message",
+			Attributes: []*commonpb.KeyValue{
+				{Key: "message.type", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: m.Type.String()}}},
+				{Key: "message.id", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: m.ID}}},
+				{Key: "message.uncompressed_size", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(m.UncompressedSize)}}},
+			},
+		})
+	}
+	sort.SliceStable(evs, func(i, j int) bool { return evs[i].TimeUnixNano < evs[j].TimeUnixNano })
+	return evs
+}
+
+// links converts the links of s to OTLP links.  Links with malformed trace
+// IDs are skipped.
+func links(s *trace.SpanData) []*tracepb.Span_Link {
+	var ls []*tracepb.Span_Link
+	for _, l := range s.Links {
+		traceID, err := hex.DecodeString(l.TraceID)
+		if err != nil || len(traceID) != 16 {
+			continue
+		}
+		ls = append(ls, &tracepb.Span_Link{TraceId: traceID, SpanId: spanID(l.SpanID)})
+	}
+	return ls
+}
+
+// attributes converts labels to OTLP attributes, sorted by key.
+func attributes(labels map[string]string) []*commonpb.KeyValue {
+	if len(labels) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]*commonpb.KeyValue, len(keys))
+	for i, k := range keys {
+		attrs[i] = &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: labels[k]}},
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,414 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeCollector struct {
+	coltracepb.UnimplementedTraceServiceServer
+
+	mu   sync.Mutex
+	reqs []*coltracepb.ExportTraceServiceRequest
+	mds  []metadata.MD
+
+	// errs are returned, in order, by the first calls to Export.
+	errs []error
+	// resp is returned once errs is exhausted.
+	resp *coltracepb.ExportTraceServiceResponse
+}
+
+func (f *fakeCollector) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.reqs = append(f.reqs, req)
+	f.mds = append(f.mds, md)
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	if f.resp != nil {
+		return f.resp, nil
+	}
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+// newTestExporter starts f on an in-process listener and returns an Exporter
+// connected to it.
+func newTestExporter(t *testing.T, f *fakeCollector, opts ...Option) *Exporter {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(srv, f)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	dialer := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	opts = append([]Option{
+		WithEndpoint("bufnet"),
+		WithInsecure(),
+		WithDialOptions(grpc.WithContextDialer(dialer)),
+		WithRetry(RetrySettings{Initial: time.Millisecond, Max: 5 * time.Millisecond, MaxElapsed: time.Second}),
+	}, opts...)
+	e, err := NewExporter(context.Background(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+var (
+	testStart = time.Unix(1500000000, 0)
+	testSpans = []*trace.SpanData{
+		{
+			TraceID:      "0123456789abcdef0123456789ABCDEF",
+			SpanID:       2,
+			ParentSpanID: 1,
+			Name:         "/google.datastore.v1.Datastore/Lookup",
+			Kind:         trace.SpanKindClient,
+			Start:        testStart.Add(time.Millisecond),
+			End:          testStart.Add(2 * time.Millisecond),
+			Labels:       map[string]string{"error": "lookup failed"},
+		},
+		{
+			TraceID: "0123456789abcdef0123456789ABCDEF",
+			SpanID:  1,
+			Name:    "/foo",
+			Kind:    trace.SpanKindServer,
+			Start:   testStart,
+			End:     testStart.Add(3 * time.Millisecond),
+			Labels: map[string]string{
+				"trace.cloud.google.com/http/method": "GET",
+				"trace.cloud.google.com/http/host":   "example.com",
+			},
+		},
+	}
+)
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+func TestExportSpans(t *testing.T) {
+	f := &fakeCollector{}
+	e := newTestExporter(t, f,
+		WithHeaders(map[string]string{"api-key": "secret"}),
+		WithResource(map[string]string{"service.name": "frontend"}))
+
+	if err := e.ExportSpans(context.Background(), testSpans); err != nil {
+		t.Fatalf("ExportSpans: %v", err)
+	}
+	if len(f.reqs) != 1 {
+		t.Fatalf("got %d requests; want 1", len(f.reqs))
+	}
+	if got := f.mds[0]["api-key"]; len(got) != 1 || got[0] != "secret" {
+		t.Errorf("api-key metadata = %q; want [secret]", got)
+	}
+	traceID := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	want := &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource: &resourcepb.Resource{
+				Attributes: []*commonpb.KeyValue{{Key: "service.name", Value: stringValue("frontend")}},
+			},
+			ScopeSpans: []*tracepb.ScopeSpans{{
+				Scope: &commonpb.InstrumentationScope{Name: "cloud.google.com/go/trace"},
+				Spans: []*tracepb.Span{
+					{
+						TraceId:           traceID,
+						SpanId:            []byte{0, 0, 0, 0, 0, 0, 0, 2},
+						ParentSpanId:      []byte{0, 0, 0, 0, 0, 0, 0, 1},
+						Name:              "/google.datastore.v1.Datastore/Lookup",
+						Kind:              tracepb.Span_SPAN_KIND_CLIENT,
+						StartTimeUnixNano: uint64(testStart.Add(time.Millisecond).UnixNano()),
+						EndTimeUnixNano:   uint64(testStart.Add(2 * time.Millisecond).UnixNano()),
+						Attributes:        []*commonpb.KeyValue{{Key: "error", Value: stringValue("lookup failed")}},
+						Status:            &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: "lookup failed"},
+					},
+					{
+						TraceId:           traceID,
+						SpanId:            []byte{0, 0, 0, 0, 0, 0, 0, 1},
+						Name:              "/foo",
+						Kind:              tracepb.Span_SPAN_KIND_SERVER,
+						StartTimeUnixNano: uint64(testStart.UnixNano()),
+						EndTimeUnixNano:   uint64(testStart.Add(3 * time.Millisecond).UnixNano()),
+						Attributes: []*commonpb.KeyValue{
+							{Key: "trace.cloud.google.com/http/host", Value: stringValue("example.com")},
+							{Key: "trace.cloud.google.com/http/method", Value: stringValue("GET")},
+						},
+						Status: &tracepb.Status{},
+					},
+				},
+			}},
+		}},
+	}
+	if got := f.reqs[0]; !proto.Equal(got, want) {
+		t.Errorf("Export request:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestSpanEvents(t *testing.T) {
+	s := &trace.SpanData{
+		TraceID: "0123456789abcdef0123456789abcdef",
+		SpanID:  1,
+		Name:    "/test.Echo/Stream",
+		Kind:    trace.SpanKindServer,
+		Start:   testStart,
+		End:     testStart.Add(3 * time.Millisecond),
+		Annotations: []trace.Annotation{
+			{Time: testStart.Add(2 * time.Millisecond), Message: "flushed", Attributes: map[string]string{"rows": "2"}},
+		},
+		DroppedAnnotations: 1,
+		MessageEvents: []trace.MessageEvent{
+			{Time: testStart.Add(time.Millisecond), Type: trace.MessageReceived, ID: 1, UncompressedSize: 12},
+			{Time: testStart.Add(3 * time.Millisecond), Type: trace.MessageSent, ID: 1},
+		},
+		DroppedMessageEvents: 2,
+	}
+	intValue := func(i int64) *commonpb.AnyValue {
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+	}
+	want := &tracepb.Span{
+		TraceId:           []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef},
+		SpanId:            []byte{0, 0, 0, 0, 0, 0, 0, 1},
+		Name:              "/test.Echo/Stream",
+		Kind:              tracepb.Span_SPAN_KIND_SERVER,
+		StartTimeUnixNano: uint64(testStart.UnixNano()),
+		EndTimeUnixNano:   uint64(testStart.Add(3 * time.Millisecond).UnixNano()),
+		Status:            &tracepb.Status{},
+		Events: []*tracepb.Span_Event{
+			{
+				TimeUnixNano: uint64(testStart.Add(time.Millisecond).UnixNano()),
+				Name:         "message",
+				Attributes: []*commonpb.KeyValue{
+					{Key: "message.type", Value: stringValue("RECEIVED")},
+					{Key: "message.id", Value: intValue(1)},
+					{Key: "message.uncompressed_size", Value: intValue(12)},
+				},
+			},
+			{
+				TimeUnixNano: uint64(testStart.Add(2 * time.Millisecond).UnixNano()),
+				Name:         "flushed",
+				Attributes:   []*commonpb.KeyValue{{Key: "rows", Value: stringValue("2")}},
+			},
+			{
+				TimeUnixNano: uint64(testStart.Add(3 * time.Millisecond).UnixNano()),
+				Name:         "message",
+				Attributes: []*commonpb.KeyValue{
+					{Key: "message.type", Value: stringValue("SENT")},
+					{Key: "message.id", Value: intValue(1)},
+					{Key: "message.uncompressed_size", Value: intValue(0)},
+				},
+			},
+		},
+		DroppedEventsCount: 3,
+	}
+	got, ok := spanProto(s)
+	if !ok {
+		t.Fatal("spanProto rejected the span")
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("spanProto:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestSpanLinks(t *testing.T) {
+	s := &trace.SpanData{
+		TraceID: "0123456789abcdef0123456789abcdef",
+		SpanID:  1,
+		Name:    "/partner",
+		Start:   testStart,
+		End:     testStart,
+		Links: []trace.Link{
+			{TraceID: "fedcba9876543210fedcba9876543210", SpanID: 2},
+			{TraceID: "not-hex", SpanID: 3},
+		},
+	}
+	got, ok := spanProto(s)
+	if !ok {
+		t.Fatal("spanProto rejected the span")
+	}
+	want := []*tracepb.Span_Link{{
+		TraceId: []byte{0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10},
+		SpanId:  []byte{0, 0, 0, 0, 0, 0, 0, 2},
+	}}
+	if len(got.Links) != len(want) || !proto.Equal(got.Links[0], want[0]) {
+		t.Errorf("links = %v; want %v", got.Links, want)
+	}
+}
+
+func TestSpanStatus(t *testing.T) {
+	for _, tt := range []struct {
+		labels map[string]string
+		want   *tracepb.Status
+	}{
+		{map[string]string{"grpc/status_code": "OK"}, &tracepb.Status{}},
+		{map[string]string{"grpc/status_code": "NotFound", "grpc/status_message": "no such entity"},
+			&tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: "no such entity"}},
+		{map[string]string{"error": "lookup failed"},
+			&tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: "lookup failed"}},
+	} {
+		s := &trace.SpanData{TraceID: "0123456789abcdef0123456789abcdef", SpanID: 1, Labels: tt.labels}
+		got, ok := spanProto(s)
+		if !ok {
+			t.Fatal("spanProto rejected the span")
+		}
+		if !proto.Equal(got.Status, tt.want) {
+			t.Errorf("labels %v: status = %v; want %v", tt.labels, got.Status, tt.want)
+		}
+	}
+}
+
+func TestExportInvalidTraceID(t *testing.T) {
+	f := &fakeCollector{}
+	e := newTestExporter(t, f)
+	bad := *testSpans[1]
+	bad.TraceID = "not-hex"
+	err := e.ExportSpans(context.Background(), []*trace.SpanData{testSpans[0], &bad})
+	if err == nil || !strings.Contains(err.Error(), "dropped 1 spans") {
+		t.Errorf("ExportSpans: got error %v; want dropped span error", err)
+	}
+	if len(f.reqs) != 1 || len(f.reqs[0].ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Errorf("got requests %v; want one request with the valid span", f.reqs)
+	}
+}
+
+func throttled(t *testing.T, delay time.Duration) error {
+	s, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: ptypes.DurationProto(delay),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Err()
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		desc     string
+		errs     []error
+		wantReqs int
+		wantCode codes.Code
+	}{
+		{
+			desc:     "unavailable then success",
+			errs:     []error{status.Error(codes.Unavailable, "down"), status.Error(codes.Unavailable, "down")},
+			wantReqs: 3,
+			wantCode: codes.OK,
+		},
+		{
+			desc:     "throttled then success",
+			errs:     []error{throttled(t, 10*time.Millisecond)},
+			wantReqs: 2,
+			wantCode: codes.OK,
+		},
+		{
+			desc:     "resource exhausted without retry info",
+			errs:     []error{status.Error(codes.ResourceExhausted, "quota")},
+			wantReqs: 1,
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			desc:     "permanent error",
+			errs:     []error{status.Error(codes.InvalidArgument, "bad span")},
+			wantReqs: 1,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			desc:     "throttle delay beyond max elapsed",
+			errs:     []error{throttled(t, time.Hour)},
+			wantReqs: 1,
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+	for _, tt := range tests {
+		f := &fakeCollector{errs: tt.errs}
+		e := newTestExporter(t, f)
+		start := time.Now()
+		err := e.ExportSpans(context.Background(), testSpans)
+		if got := status.Code(err); got != tt.wantCode {
+			t.Errorf("%s: got code %v (err %v); want %v", tt.desc, got, err, tt.wantCode)
+		}
+		if got := len(f.reqs); got != tt.wantReqs {
+			t.Errorf("%s: got %d requests; want %d", tt.desc, got, tt.wantReqs)
+		}
+		if tt.desc == "throttled then success" && time.Since(start) < 10*time.Millisecond {
+			t.Errorf("%s: retried after %v; want at least the throttle delay", tt.desc, time.Since(start))
+		}
+	}
+}
+
+func TestPartialSuccess(t *testing.T) {
+	f := &fakeCollector{resp: &coltracepb.ExportTraceServiceResponse{
+		PartialSuccess: &coltracepb.ExportTracePartialSuccess{
+			RejectedSpans: 1,
+			ErrorMessage:  "span name too long",
+		},
+	}}
+	e := newTestExporter(t, f)
+	err := e.ExportSpans(context.Background(), testSpans)
+	if err == nil || !strings.Contains(err.Error(), "rejected 1 spans: span name too long") {
+		t.Errorf("got error %v; want partial success error", err)
+	}
+	if len(f.reqs) != 1 {
+		t.Errorf("got %d requests; partial success should not be retried", len(f.reqs))
+	}
+}
+
+func TestClientExport(t *testing.T) {
+	f := &fakeCollector{}
+	e := newTestExporter(t, f)
+	tc := trace.NewClientWithExporter(e)
+	span := tc.NewSpan("/root")
+	span.NewChild("child").Finish()
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.reqs) != 1 {
+		t.Fatalf("got %d requests; want 1", len(f.reqs))
+	}
+	spans := f.reqs[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans; want 2", len(spans))
+	}
+	if got, want := spans[1].Name, "child"; got != want {
+		t.Errorf("second span name = %q; want %q", got, want)
+	}
+	if got, want := spans[1].ParentSpanId, spans[0].SpanId; !bytes.Equal(got, want) {
+		t.Errorf("child parent span ID = %x; want %x", got, want)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outcomeBuffer is the number of outcomes that wait for the sink set with
+// SetOutcomeSink before outcomes are dropped.
+const outcomeBuffer = 1024
+
+// An Outcome is the result of a local root span: a span created by the
+// client, rather than as the child of another span.  Outcomes are reported
+// to the function set with SetOutcomeSink.
+type Outcome struct {
+	Name     string
+	Duration time.Duration
+
+	// Failed is whether the request of the span failed: a gRPC interceptor
+	// recorded a status other than OK on the span, or the status code of
+	// its HTTP response is 500 or more.
+	Failed bool
+
+	// Traced is whether the span was traced, and is exported.
+	Traced bool
+
+	// Weight is the sample weight of the span, as for the sampling weight
+	// label, if its trace is in the random sample of the client's
+	// SamplingPolicy; otherwise it is zero.
+	Weight float64
+}
+
+// SetOutcomeSink sets a function that is called with the Outcome of every
+// local root span of the client when it finishes, whether or not the span
+// is traced.  This gives a low-volume stream of request outcomes, such as
+// for error budgets, that doesn't depend on sampling.  A nil function turns
+// the outcomes off.
+//
+// Finishing a span never waits for f: the outcomes are queued, and f is
+// called with them in order from a single goroutine.  If f falls behind by
+// more than 1024 outcomes, new outcomes are dropped and counted in the
+// OutcomesDropped field of Stats.  f should still return quickly, and
+// hand any slow work to goroutines of its own.  Close delivers the queued
+// outcomes, and stops calling f.
+//
+// While a sink is set, the children of untraced root spans are separate
+// spans rather than the root span itself, so that finishing them doesn't
+// finish the root.  SetOutcomeSink should be called before any spans are
+// created.
+func (c *Client) SetOutcomeSink(f func(Outcome)) {
+	if c == nil {
+		return
+	}
+	if c.outcomes != nil {
+		c.outcomes.stop()
+		c.outcomes = nil
+	}
+	if f != nil {
+		c.outcomes = startOutcomeSink(f)
+	}
+}
+
+// outcomeSink calls the function set with SetOutcomeSink with the queued
+// outcomes.
+type outcomeSink struct {
+	queue    chan Outcome
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func startOutcomeSink(f func(Outcome)) *outcomeSink {
+	s := &outcomeSink{
+		queue:   make(chan Outcome, outcomeBuffer),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go func() {
+		defer close(s.stopped)
+		for {
+			select {
+			case o := <-s.queue:
+				f(o)
+			case <-s.done:
+				// Deliver the outcomes queued before stop.
+				for {
+					select {
+					case o := <-s.queue:
+						f(o)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// stop delivers the queued outcomes and stops calling the function of s.
+// It can be called more than once.
+func (s *outcomeSink) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+	<-s.stopped
+}
+
+// reportOutcome queues the outcome of s, a local root span that finished at
+// end, for the client's outcome sink, if it has one.
+func (c *Client) reportOutcome(s *Span, end time.Time) {
+	sink := c.outcomes
+	if sink == nil {
+		return
+	}
+	s.spanMu.Lock()
+	o := Outcome{
+		Name:     s.span.Name,
+		Duration: end.Sub(s.start),
+		Failed:   atomic.LoadInt32(&s.failed) != 0 || s.statusCode >= http.StatusInternalServerError,
+		Traced:   s.tracing(),
+		Weight:   s.weight,
+	}
+	s.spanMu.Unlock()
+	select {
+	case sink.queue <- o:
+	default:
+		atomic.AddInt64(&c.stats.outcomesDropped, 1)
+	}
+}
+
+// finishUntraced finishes s, a span that isn't traced, at end.  Only the
+// local root spans need finishing: they are marked finished, for
+// ForceTrace, and their outcomes are reported to the client's outcome sink,
+// if it has one.
+func (s *Span) finishUntraced(end time.Time, opts []FinishOption) {
+	if !s.rootSpan || !atomic.CompareAndSwapInt32(&s.finished, 0, 1) {
+		return
+	}
+	if s.tracing() {
+		// ForceTrace made s traced while it finished; s isn't uploaded.
+		s.trace.client.endRoot(s)
+	}
+	if !s.reportsOutcome() {
+		return
+	}
+	for _, o := range opts {
+		o.modifySpan(s)
+	}
+	s.spanMu.Lock()
+	if end.Before(s.start) {
+		end = s.start
+	}
+	s.end = end
+	s.spanMu.Unlock()
+	s.trace.client.reportOutcome(s, end)
+}
+
+// untracedChild returns the span that NewChild and the other methods that
+// create children return for s, a span that isn't traced.  That is s itself,
+// unless s is a local root span whose outcome goes to an outcome sink: then
+// it is a separate untraced span, so that finishing the child doesn't finish
+// s.  It propagates the same trace context as s.
+func (s *Span) untracedChild() *Span {
+	if !s.reportsOutcome() {
+		return s
+	}
+	c := &Span{trace: s.trace, parent: s, options: s.loadOptions(), start: s.start}
+	c.span.SpanId, c.span.ParentSpanId, c.span.Name = s.span.SpanId, s.span.ParentSpanId, s.span.Name
+	return c
+}
+
+// reportsOutcome returns whether s is a local root span whose outcome goes
+// to an outcome sink.
+func (s *Span) reportsOutcome() bool {
+	return s != nil && s.rootSpan && !s.detached && s.trace.client.outcomes != nil
+}
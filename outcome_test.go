@@ -0,0 +1,174 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// weightedSample is a sampling policy that samples every request with a
+// fixed weight.
+type weightedSample float64
+
+func (w weightedSample) Sample(p Parameters) Decision {
+	return Decision{Trace: true, Sample: true, Policy: "test", Weight: float64(w)}
+}
+
+// outcomeClient returns a client with policy, whose outcomes are sent to
+// the returned channel.
+func outcomeClient(policy SamplingPolicy) (*Client, chan Outcome) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(policy)
+	outcomes := make(chan Outcome, 10)
+	tc.SetOutcomeSink(func(o Outcome) { outcomes <- o })
+	return tc, outcomes
+}
+
+func TestOutcomes(t *testing.T) {
+	for _, test := range []struct {
+		policy SamplingPolicy
+		traced bool
+		weight float64
+	}{
+		{weightedSample(4), true, 4},
+		{alwaysTrace{}, true, 0},
+		{neverTrace{}, false, 0},
+	} {
+		tc, outcomes := outcomeClient(test.policy)
+		root := tc.NewSpan("/root")
+		child := root.NewChild("/child")
+		grandchild := child.NewChild("/grandchild")
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		remote := root.NewRemoteChild(req)
+		time.Sleep(10 * time.Millisecond)
+		grandchild.Finish()
+		child.Finish()
+		remote.Finish()
+		root.Finish()
+		root.Finish()
+		tc.Close()
+		close(outcomes)
+
+		var got []Outcome
+		for o := range outcomes {
+			got = append(got, o)
+		}
+		if len(got) != 1 {
+			t.Fatalf("%T: got outcomes %+v; want one", test.policy, got)
+		}
+		o := got[0]
+		if o.Name != "/root" || o.Duration < 10*time.Millisecond || o.Failed || o.Traced != test.traced || o.Weight != test.weight {
+			t.Errorf("%T: got outcome %+v; want /root, at least 10ms, traced %t, weight %v", test.policy, o, test.traced, test.weight)
+		}
+	}
+}
+
+func TestOutcomeUntracedChildren(t *testing.T) {
+	tc, _ := outcomeClient(neverTrace{})
+	root := tc.SpanFromHeader("/root", "0123456789abcdef0123456789abcdef/42;o=0")
+	child := root.NewChild("/child")
+	if child == root {
+		t.Fatal("with an outcome sink, NewChild of an untraced root returned the root")
+	}
+	if child.traced() || child.Header() != root.Header() {
+		t.Errorf("child traced %t with header %q; want untraced with the root's header %q", child.traced(), child.Header(), root.Header())
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	root.NewRemoteChild(req)
+	if got, want := req.Header.Get(httpHeader), "0123456789abcdef0123456789abcdef/42;o=0"; got != want {
+		t.Errorf("header of the remote child = %q; want %q", got, want)
+	}
+
+	// Without a sink, the children of untraced spans are the spans.
+	tc = NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(neverTrace{})
+	root = tc.NewSpan("/root")
+	if child := root.NewChild("/child"); child != root {
+		t.Error("without an outcome sink, NewChild of an untraced root returned a new span")
+	}
+}
+
+// nextOutcome returns the next outcome sent to outcomes, and fails the test
+// if none is sent soon.
+func nextOutcome(t *testing.T, outcomes chan Outcome) Outcome {
+	t.Helper()
+	select {
+	case o := <-outcomes:
+		return o
+	case <-time.After(5 * time.Second):
+		t.Fatal("no outcome was reported")
+	}
+	return Outcome{}
+}
+
+func TestOutcomeFailed(t *testing.T) {
+	// The trace header lets the policy decide whether the call is traced.
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=0")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	for _, policy := range []SamplingPolicy{alwaysTrace{}, neverTrace{}} {
+		tc, outcomes := outcomeClient(policy)
+		GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Echo/Call"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, errors.New("failed")
+		})
+		if o, traced := nextOutcome(t, outcomes), policy == (alwaysTrace{}); !o.Failed || o.Traced != traced {
+			t.Errorf("%T: outcome of a failed call = %+v; want failed, traced %t", policy, o, traced)
+		}
+
+		for _, code := range []int{http.StatusOK, http.StatusNotFound, http.StatusServiceUnavailable} {
+			h := tc.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(code)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+			if o, want := nextOutcome(t, outcomes), code >= 500; o.Failed != want {
+				t.Errorf("%T: outcome of status %d = %+v; want failed %t", policy, code, o, want)
+			}
+		}
+	}
+}
+
+func TestOutcomesDropped(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(neverTrace{})
+	unblock := make(chan struct{})
+	delivered := 0
+	tc.SetOutcomeSink(func(o Outcome) {
+		<-unblock
+		delivered++
+	})
+	const roots = outcomeBuffer + 100
+	for i := 0; i < roots; i++ {
+		tc.NewSpan("/root").Finish()
+	}
+	dropped := tc.Stats().OutcomesDropped
+	if dropped < 99 {
+		t.Errorf("OutcomesDropped = %d with a blocked sink; want at least 99", dropped)
+	}
+	close(unblock)
+	tc.Close()
+	if delivered+int(dropped) != roots {
+		t.Errorf("delivered %d outcomes and dropped %d; want %d in all", delivered, dropped, roots)
+	}
+	if !tc.Config().OutcomeSink {
+		t.Error("Config().OutcomeSink = false; want true")
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+const labelPanicStack = `trace/panic_stack`
+
+// maxPanicStackBytes is the length at which the stack of a panic is
+// truncated in the "trace/panic_stack" label.
+const maxPanicStackBytes = 4096
+
+// setPanicLabels labels span with v, the value of a panic being recovered,
+// as "trace/panic", and with the stack of the panicking goroutine, which
+// includes the function that panicked while a deferred function is
+// recovering, as "trace/panic_stack".
+func setPanicLabels(span *Span, v interface{}) {
+	if !span.traced() {
+		return
+	}
+	span.setLabel(labelPanic, fmt.Sprint(v))
+	span.setLabel(labelPanicStack, truncate(string(debug.Stack()), maxPanicStackBytes))
+}
+
+// labelServerPanic labels span, the span of a server call whose handler
+// panicked with v, as a call that failed with codes.Internal, and with the
+// labels of setPanicLabels.  The server interceptors call it before they
+// finish the span and panic again with v, so that the panic reaches the
+// recovery of the server as if they weren't there.
+func labelServerPanic(span *Span, v interface{}) {
+	setPanicLabels(span, v)
+	setStatusLabels(span, panicError(v))
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// panickingHandler panics with "boom"; its name appears in the stacks of
+// the panics it causes.
+func panickingHandler() {
+	panic("boom")
+}
+
+// recoverPanic calls f, and returns the value it panicked with, or nil.
+func recoverPanic(f func()) (v interface{}) {
+	defer func() { v = recover() }()
+	f()
+	return nil
+}
+
+func TestServerInterceptorPanic(t *testing.T) {
+	for _, opts := range [][]InterceptorOption{
+		{AlwaysTrace()},
+		{AlwaysTrace(), SynchronousFinish(0)},
+	} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+
+		v := recoverPanic(func() {
+			GRPCServerInterceptor(tc, opts...)(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Unary"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				panickingHandler()
+				return nil, nil
+			})
+		})
+		if v != "boom" {
+			t.Errorf("unary: recovered %v; want the handler's panic", v)
+		}
+		v = recoverPanic(func() {
+			GRPCStreamServerInterceptor(tc, opts...)(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+				panickingHandler()
+				return nil
+			})
+		})
+		if v != "boom" {
+			t.Errorf("stream: recovered %v; want the handler's panic", v)
+		}
+		if err := tc.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(e.spans) != 2 {
+			t.Fatalf("exported spans %v; want one for each call", names(e.spans))
+		}
+		for _, s := range e.spans {
+			for key, want := range map[string]string{
+				labelPanic:             "boom",
+				labelGRPCStatusCode:    "Internal",
+				labelGRPCStatusMessage: "panic: boom",
+			} {
+				if got := s.Labels[key]; got != want {
+					t.Errorf("%s: label %s = %q; want %q", s.Name, key, got, want)
+				}
+			}
+			if stack := s.Labels[labelPanicStack]; !strings.Contains(stack, "panickingHandler") {
+				t.Errorf("%s: label %s = %q; want the stack of panickingHandler", s.Name, labelPanicStack, stack)
+			}
+		}
+	}
+}
+
+func TestPanicStackTruncated(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/deep")
+	var recurse func(n int)
+	recurse = func(n int) {
+		if n == 0 {
+			defer func() { setPanicLabels(span, recover()) }()
+			panic("deep")
+		}
+		recurse(n - 1)
+	}
+	recurse(200)
+	if got, _ := spanLabel(span, labelPanicStack); len(got) == 0 || len(got) > maxPanicStackBytes {
+		t.Errorf("stack label of %d bytes; want at most %d", len(got), maxPanicStackBytes)
+	}
+	setPanicLabels(nil, "nil span")
+}
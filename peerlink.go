@@ -0,0 +1,91 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const labelPeerTraceID = `peer/trace_id`
+
+// A Link records that a span follows a span of another trace, such as the
+// trace a server started for a call and returned in its response.
+type Link struct {
+	TraceID string // hex-encoded trace ID.
+	SpanID  uint64
+}
+
+// WithResponseTraceLinks returns an InterceptorOption that makes the client
+// interceptors read the trace context that servers that start their own
+// traces return in the response header or trailer key, or in
+// "x-cloud-trace-context" if key is empty.  The value has the format of the
+// trace header.  The span of the call gets a Link to the span in the
+// value, in SpanData.Links, and is labeled with its trace ID, as
+// "peer/trace_id".  Malformed values, and values naming the trace of the
+// call itself, are ignored.
+//
+// For HTTP requests, set the ResponseTraceHeader field of Transport.
+func WithResponseTraceLinks(key string) InterceptorOption {
+	if key == "" {
+		key = grpcMetadataKey
+	}
+	return withResponseTraceLinks{key}
+}
+
+type withResponseTraceLinks struct {
+	key string
+}
+
+func (o withResponseTraceLinks) configureInterceptor(c *interceptorConfig) {
+	c.responseLinkKey = o.key
+}
+
+// linkResponseTrace links span to the trace context in value, a trace
+// header returned by the server of a call of span, unless it is malformed
+// or names the trace of span.
+func linkResponseTrace(span *Span, value string) {
+	if value == "" || !span.traced() {
+		return
+	}
+	traceID, spanID, _, _, ok := parseHeader(value)
+	if !ok || spanID == 0 || traceID == span.trace.traceID {
+		return
+	}
+	span.spanMu.Lock()
+	span.links = append(span.links, Link{TraceID: traceID, SpanID: spanID})
+	span.spanMu.Unlock()
+	span.setLabel(labelPeerTraceID, traceID)
+}
+
+// linkResponseMetadata links span to the trace context in the first of
+// mds that has key.
+func linkResponseMetadata(span *Span, key string, mds ...metadata.MD) {
+	for _, md := range mds {
+		if v := md[key]; len(v) > 0 {
+			linkResponseTrace(span, v[0])
+			return
+		}
+	}
+}
+
+// linkResponseHeader links span to the trace context in the header name of
+// resp, if any.
+func linkResponseHeader(span *Span, resp *http.Response, name string) {
+	if name != "" && resp != nil {
+		linkResponseTrace(span, resp.Header.Get(name))
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const partnerTraceID = "fedcba9876543210fedcba9876543210"
+
+// responseMetadataStream is a client stream that ends with header and
+// trailer.
+type responseMetadataStream struct {
+	fakeClientStream
+	header, trailer metadata.MD
+}
+
+func (s *responseMetadataStream) RecvMsg(m interface{}) error  { return io.EOF }
+func (s *responseMetadataStream) Header() (metadata.MD, error) { return s.header, nil }
+func (s *responseMetadataStream) Trailer() metadata.MD         { return s.trailer }
+
+// responseLinkCalls makes a unary call and a stream call whose responses
+// have header and trailer, with the client interceptors given opts, and
+// returns the spans of the calls.
+func responseLinkCalls(t *testing.T, header, trailer metadata.MD, opts ...InterceptorOption) (unary, stream *SpanData) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+
+	err := GRPCClientInterceptor(opts...)(ctx, "/unary", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		for _, o := range opts {
+			switch o := o.(type) {
+			case grpc.HeaderCallOption:
+				*o.HeaderAddr = header
+			case grpc.TrailerCallOption:
+				*o.TrailerAddr = trailer
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := GRPCStreamClientInterceptor(opts...)(ctx, &grpc.StreamDesc{}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &responseMetadataStream{fakeClientStream: fakeClientStream{ctx: ctx}, header: header, trailer: trailer}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.RecvMsg(nil)
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	return e.span("/unary"), e.span("/stream")
+}
+
+func TestGRPCResponseTraceLinks(t *testing.T) {
+	want := []Link{{TraceID: partnerTraceID, SpanID: 42}}
+	for _, tt := range []struct {
+		desc            string
+		key             string
+		header, trailer metadata.MD
+		want            []Link
+	}{
+		{"header", "", metadata.Pairs(grpcMetadataKey, partnerTraceID+"/42;o=1"), nil, want},
+		{"trailer", "", nil, metadata.Pairs(grpcMetadataKey, partnerTraceID+"/42;o=1"), want},
+		{"custom key", "x-partner-trace", metadata.Pairs("x-partner-trace", partnerTraceID+"/42"), nil, want},
+		{"other key", "x-partner-trace", metadata.Pairs(grpcMetadataKey, partnerTraceID+"/42"), nil, nil},
+		{"garbage", "", metadata.Pairs(grpcMetadataKey, "not a trace header"), nil, nil},
+		{"no span", "", metadata.Pairs(grpcMetadataKey, partnerTraceID+"/0"), nil, nil},
+	} {
+		unary, stream := responseLinkCalls(t, tt.header, tt.trailer, WithResponseTraceLinks(tt.key))
+		for _, s := range []*SpanData{unary, stream} {
+			if !reflect.DeepEqual(s.Links, tt.want) {
+				t.Errorf("%s: %s has links %v; want %v", tt.desc, s.Name, s.Links, tt.want)
+			}
+			wantLabel := ""
+			if tt.want != nil {
+				wantLabel = partnerTraceID
+			}
+			if got := s.Labels[labelPeerTraceID]; got != wantLabel {
+				t.Errorf("%s: %s has %s = %q; want %q", tt.desc, s.Name, labelPeerTraceID, got, wantLabel)
+			}
+		}
+	}
+
+	// Without the option, responses are not inspected.
+	unary, stream := responseLinkCalls(t, metadata.Pairs(grpcMetadataKey, partnerTraceID+"/42"), nil)
+	for _, s := range []*SpanData{unary, stream} {
+		if s.Links != nil {
+			t.Errorf("%s without WithResponseTraceLinks has links %v", s.Name, s.Links)
+		}
+	}
+}
+
+func TestTransportResponseTraceHeader(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  []Link
+	}{
+		{partnerTraceID + "/42;o=1", []Link{{TraceID: partnerTraceID, SpanID: 42}}},
+		{"garbage", nil},
+		{"", nil},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.value != "" {
+				w.Header().Set("X-Partner-Trace", tt.value)
+			}
+		}))
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		root := tc.NewSpan("/root")
+		req, _ := http.NewRequest("GET", srv.URL+"/partner", nil)
+		client := &http.Client{Transport: Transport{ResponseTraceHeader: "X-Partner-Trace"}}
+		resp, err := client.Do(req.WithContext(NewContext(context.Background(), root)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Close()
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		s := e.span("GET /partner")
+		if s == nil {
+			t.Fatalf("exported spans %v; want GET /partner", names(e.spans))
+		}
+		if !reflect.DeepEqual(s.Links, tt.want) {
+			t.Errorf("header %q: links %v; want %v", tt.value, s.Links, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sync"
+	"testing"
+)
+
+// namedPolicy samples every request, with its name and weight.
+type namedPolicy struct {
+	name   string
+	weight float64
+}
+
+func (p namedPolicy) Sample(Parameters) Decision {
+	return Decision{Trace: true, Sample: true, Policy: p.name, Weight: p.weight}
+}
+
+func TestSetSamplingPolicyConcurrent(t *testing.T) {
+	const (
+		goroutines = 8
+		perRoutine = 500
+	)
+	tc := NewClientWithExporter(&recordingExporter{})
+	policies := []SamplingPolicy{namedPolicy{"old", 1}, namedPolicy{"new", 2}, nil}
+	weights := map[string]string{"old": "1", "new": "2"}
+	tc.SetSamplingPolicy(policies[0])
+
+	done := make(chan struct{})
+	var swaps sync.WaitGroup
+	swaps.Add(1)
+	go func() {
+		defer swaps.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			tc.SetSamplingPolicy(policies[i%len(policies)])
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perRoutine; j++ {
+				span := tc.NewSpan("/swap")
+				if !span.tracing() {
+					continue
+				}
+				span.spanMu.Lock()
+				policy, weight := span.span.Labels[labelSamplingPolicy], span.span.Labels[labelSamplingWeight]
+				span.spanMu.Unlock()
+				if policy == "" && weight == "" {
+					continue // the span was traced without a policy.
+				}
+				if want := weights[policy]; weight != want {
+					t.Errorf("span sampled by policy %q with weight %s; want weight %s", policy, weight, want)
+				}
+				span.NewChild("child").Finish()
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	swaps.Wait()
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+// A SpanProcessor modifies finished spans before they are exported.
+type SpanProcessor interface {
+	// ProcessSpan modifies s in place.  It may be called concurrently from
+	// multiple goroutines.
+	ProcessSpan(s *SpanData)
+}
+
+// AddSpanProcessor adds p to the processors that are applied to each span
+// before it is exported.  Processors are applied in the order they were
+// added, except that an *AnonymizingProcessor is always applied after all
+// other processors, so that none of them can reintroduce the data it
+// removes.
+//
+// AddSpanProcessor should be called before any spans are created.
+func (c *Client) AddSpanProcessor(p SpanProcessor) {
+	if c == nil {
+		return
+	}
+	if _, ok := p.(*AnonymizingProcessor); ok {
+		c.lastProcessors = append(c.lastProcessors, p)
+		return
+	}
+	c.processors = append(c.processors, p)
+}
+
+// process applies the client's processors to spans.
+func (c *Client) process(spans []*SpanData) {
+	if len(c.processors) == 0 && len(c.lastProcessors) == 0 {
+		return
+	}
+	for _, s := range spans {
+		for _, p := range c.processors {
+			p.ProcessSpan(s)
+		}
+		for _, p := range c.lastProcessors {
+			p.ProcessSpan(s)
+		}
+	}
+}
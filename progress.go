@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// labelInProgress marks the spans uploaded by UploadProgress.
+const labelInProgress = `trace/in_progress`
+
+// UploadProgress uploads the current state of s, which hasn't finished, so
+// that long-running work, such as a batch job or a stream open for hours,
+// shows up in its trace while it is in progress instead of only once it
+// finishes.  The span is uploaded with its name, start time and labels so
+// far, with the current time as its end time, and labeled
+// "trace/in_progress" with "true".  The children of the trace that have
+// finished since the last upload are uploaded with it, instead of waiting
+// for the local root span, so that the trace fills in as the work goes on:
+//
+//   for _, batch := range batches {
+//       process(ctx, batch)
+//       span.UploadProgress()
+//   }
+//   span.Finish()
+//
+// When s finishes, it is uploaded again, with the same IDs, replacing the
+// provisional record, and labeled "trace/in_progress" with "false" for
+// backends that merge the labels of the records of a span.
+//
+// UploadProgress exports the spans before it returns, and returns the
+// error of the export, or ErrClosed if the client is closed.  Flush and
+// Close wait for it.  Under SetTraceSlowSpans, s is only uploaded once it
+// has run as long as it would need to be uploaded when it finishes.  It
+// must not be called concurrently with Finish.  It does nothing if s is
+// nil, isn't traced, or has finished.
+func (s *Span) UploadProgress() error {
+	if s == nil || !s.tracing() || atomic.LoadInt32(&s.finished) != 0 {
+		return nil
+	}
+	t := s.trace
+	c := t.client
+	if !c.uploads.begin() {
+		return ErrClosed
+	}
+	defer c.uploads.end()
+	now := time.Now()
+	spans := t.finished.drain()
+	if c.maxBufferedSpans > 0 {
+		for _, sp := range spans {
+			t.release(sp)
+		}
+	}
+	s.spanMu.Lock()
+	elapsed := now.Sub(s.start)
+	s.spanMu.Unlock()
+	n := newSpans(spans)
+	if t.keptInProgress(s, elapsed) {
+		if atomic.SwapInt32(&s.progressUploaded, 1) == 0 {
+			n++
+		}
+		spans = append(spans, s)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	t.recordFlush(n)
+	data := t.constructTrace(spans)
+	for _, d := range data {
+		if d.SpanID != s.span.SpanId {
+			continue
+		}
+		d.End = now
+		if d.Labels == nil {
+			d.Labels = make(map[string]string, 1)
+		}
+		d.Labels[labelInProgress] = "true"
+	}
+	return c.export(data)
+}
+
+// keptInProgress reports whether s, which hasn't finished after running for
+// d, would be uploaded if it finished now: like Finish, a trace recorded
+// for its slow spans discards a fast root, and every trace discards the
+// children faster than the client's minimum span duration.
+func (t *trace) keptInProgress(s *Span, d time.Duration) bool {
+	min := t.client.minSpanDuration
+	if s.rootSpan && atomic.LoadInt32(&t.slowOnly) == 0 {
+		return true
+	}
+	return min <= 0 || d >= min
+}
+
+// newSpans returns the number of spans that UploadProgress hasn't already
+// uploaded, and counted in the client's statistics.
+func newSpans(spans []*Span) int {
+	n := 0
+	for _, s := range spans {
+		if atomic.LoadInt32(&s.progressUploaded) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// finishProgress labels s, a span being finished, as no longer in progress
+// if UploadProgress uploaded it.
+func (s *Span) finishProgress() {
+	if atomic.LoadInt32(&s.progressUploaded) != 0 {
+		s.setLabel(labelInProgress, "false")
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUploadProgress(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/job")
+	root.SetLabel("batch", "1")
+	root.NewChild("/step1").Finish()
+	open := root.NewChild("/step2")
+
+	if err := root.UploadProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(e.spans), []string{"/job", "/step1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("progress uploaded spans %q; want %q", got, want)
+	}
+	provisional := e.spans[0]
+	if got := provisional.Labels[labelInProgress]; got != "true" {
+		t.Errorf("provisional %s = %q; want %q", labelInProgress, got, "true")
+	}
+	if provisional.Labels["batch"] != "1" || provisional.End.Before(provisional.Start) {
+		t.Errorf("provisional span has labels %v, start %v and end %v; want the labels so far and an end after the start", provisional.Labels, provisional.Start, provisional.End)
+	}
+
+	// Only the spans that finished since the progress upload are uploaded
+	// with the finished root, which replaces the provisional one.
+	open.Finish()
+	e.spans = nil
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(e.spans), []string{"/job", "/step2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("finished trace uploaded spans %q; want %q", got, want)
+	}
+	final := e.spans[0]
+	if final.SpanID != provisional.SpanID || final.TraceID != provisional.TraceID {
+		t.Errorf("final span %s/%x; want the IDs of the provisional span, %s/%x", final.TraceID, final.SpanID, provisional.TraceID, provisional.SpanID)
+	}
+	if got := final.Labels[labelInProgress]; got != "false" {
+		t.Errorf("final %s = %q; want %q", labelInProgress, got, "false")
+	}
+
+	// Finished spans are not uploaded again.
+	e.spans = nil
+	if err := root.UploadProgress(); err != nil || len(e.spans) != 0 {
+		t.Errorf("UploadProgress of a finished span = %v, uploaded %q; want nil and no spans", err, names(e.spans))
+	}
+}
+
+func TestUploadProgressUntraced(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetSamplingPolicy(neverTrace{})
+	if err := tc.NewSpan("/job").UploadProgress(); err != nil || len(e.spans) != 0 {
+		t.Errorf("UploadProgress of an untraced span = %v, uploaded %q; want nil and no spans", err, names(e.spans))
+	}
+	var nilSpan *Span
+	if err := nilSpan.UploadProgress(); err != nil {
+		t.Errorf("UploadProgress of a nil span = %v; want nil", err)
+	}
+}
+
+func TestUploadProgressStats(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/job")
+	root.NewChild("/step").Finish()
+	if err := root.UploadProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.UploadProgress(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		root.NewChild("/step").Finish()
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	// The trace of 7 spans, uploaded in three pieces, is counted once.
+	want := []Bucket{
+		{Min: 1, Max: 1},
+		{Min: 2, Max: 5},
+		{Min: 6, Max: 20, Count: 1},
+		{Min: 21, Max: 100},
+		{Min: 101},
+	}
+	if got := tc.Stats().SpansPerTrace; !reflect.DeepEqual(got, want) {
+		t.Errorf("SpansPerTrace = %+v; want %+v", got, want)
+	}
+}
+
+func TestUploadProgressSlowSpans(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetSamplingPolicy(neverTrace{})
+	tc.SetMinSpanDuration(time.Second)
+	tc.SetTraceSlowSpans(true)
+	root := tc.NewSpan("/job")
+	if err := root.UploadProgress(); err != nil || len(e.spans) != 0 {
+		t.Errorf("UploadProgress of a fast root of a slow-only trace = %v, uploaded %q; want nil and no spans", err, names(e.spans))
+	}
+	root.SetStartTime(time.Now().Add(-2 * time.Second))
+	if err := root.UploadProgress(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(e.spans), []string{"/job"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UploadProgress of a slow root uploaded %q; want %q", got, want)
+	}
+}
+
+func TestUploadProgressFlushAndClose(t *testing.T) {
+	e := &blockingExporter{started: make(chan struct{}), release: make(chan struct{})}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/job")
+	errc := make(chan error)
+	go func() { errc <- root.UploadProgress() }()
+	<-e.started
+
+	// Flush waits for the upload in progress.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tc.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Flush during UploadProgress = %v; want %v", err, context.DeadlineExceeded)
+	}
+	close(e.release)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.UploadProgress(); err != ErrClosed {
+		t.Errorf("UploadProgress after Close = %v; want %v", err, ErrClosed)
+	}
+}
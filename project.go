@@ -0,0 +1,69 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// projectIDPattern matches project IDs: 6 to 30 lowercase letters, digits
+// and hyphens, starting with a letter and not ending with a hyphen,
+// optionally prefixed by a domain for domain-scoped projects.
+var projectIDPattern = regexp.MustCompile(`^([a-z0-9][-a-z0-9.]*[a-z0-9]:)?[a-z][-a-z0-9]{4,28}[a-z0-9]$`)
+
+type withProjectHint struct {
+	key string
+}
+
+// WithProjectHint returns an InterceptorOption that makes the server
+// interceptors read the project that owns the trace of an incoming call from
+// the metadata key, such as "x-origin-project".  The spans of the trace are
+// then exported to that project, so that they appear in Stackdriver alongside
+// the spans of the calling project.  Calls without the key, or with a value
+// that is not a valid project ID, are exported to the client's project.
+// Invalid values are reported to the error handler when strict validation
+// is enabled.
+//
+// Only the exporter used by NewClient routes spans by project; other
+// exporters see the project in SpanData.ProjectID.
+func WithProjectHint(key string) InterceptorOption {
+	return withProjectHint{key: strings.ToLower(key)}
+}
+
+func (o withProjectHint) configureInterceptor(c *interceptorConfig) {
+	c.projectHintKey = o.key
+}
+
+// setProjectHint routes the trace of span to the project named by the key
+// in md, if it is present and valid.  span must be the root span of a trace
+// read from an incoming call.
+func setProjectHint(tc *Client, span *Span, md metadata.MD, key string) {
+	if !span.tracing() {
+		return
+	}
+	vs := md[key]
+	if len(vs) == 0 {
+		return
+	}
+	if !projectIDPattern.MatchString(vs[0]) {
+		tc.reportHeaderError(fmt.Errorf("trace: ignoring invalid project %q in metadata key %q", vs[0], key))
+		return
+	}
+	span.trace.projectID = vs[0]
+}
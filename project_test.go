@@ -0,0 +1,110 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	api "google.golang.org/api/cloudtrace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestProjectHint(t *testing.T) {
+	const header = "0123456789ABCDEF0123456789ABCDEF/1;o=1"
+	for _, tt := range []struct {
+		desc    string
+		md      metadata.MD
+		want    string
+		wantErr bool
+	}{
+		{desc: "no hint"},
+		{desc: "hint", md: metadata.Pairs("x-origin-project", "partner-project"), want: "partner-project"},
+		{desc: "domain-scoped", md: metadata.Pairs("x-origin-project", "example.com:partner-project"), want: "example.com:partner-project"},
+		{desc: "too short", md: metadata.Pairs("x-origin-project", "p"), wantErr: true},
+		{desc: "uppercase", md: metadata.Pairs("x-origin-project", "Partner-Project"), wantErr: true},
+		{desc: "path", md: metadata.Pairs("x-origin-project", "../partner-project"), wantErr: true},
+		{desc: "trailing hyphen", md: metadata.Pairs("x-origin-project", "partner-project-"), wantErr: true},
+	} {
+		e := &recordingExporter{exported: make(chan struct{}, 1)}
+		tc := NewClientWithExporter(e)
+		tc.bundler.BundleCountThreshold = 1
+		tc.SetStrictValidation(true)
+		var errs []error
+		tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+		md := metadata.Join(tt.md, metadata.Pairs(grpcMetadataKey, header))
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		GRPCServerInterceptor(tc, WithProjectHint("X-Origin-Project"))(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			FromContext(ctx).NewChild("child").Finish()
+			return nil, nil
+		})
+		<-e.exported
+		if len(e.spans) != 2 {
+			t.Fatalf("%s: got %d spans; want the call and its child", tt.desc, len(e.spans))
+		}
+		for _, s := range e.spans {
+			if s.ProjectID != tt.want {
+				t.Errorf("%s: span %q has project %q; want %q", tt.desc, s.Name, s.ProjectID, tt.want)
+			}
+		}
+		if got := len(errs) > 0; got != tt.wantErr {
+			t.Errorf("%s: got errors %v; want error %t", tt.desc, errs, tt.wantErr)
+		}
+	}
+}
+
+func TestAPIExporterProjects(t *testing.T) {
+	rt := newFakeRoundTripper()
+	e := newTestClient(rt).exporter
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	span := func(project string, id uint64) *SpanData {
+		return &SpanData{TraceID: validationTraceID, ProjectID: project, SpanID: id, Name: "span", Start: start, End: start}
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- e.ExportSpans(context.Background(), []*SpanData{span("", 1), span("partner-project", 2), span("", 3)})
+	}()
+	for _, want := range []struct {
+		project string
+		spans   int
+	}{
+		{testProjectID, 2},
+		{"partner-project", 1},
+	} {
+		req := <-rt.reqc
+		if got, wantPath := req.URL.Path, "/v1/projects/"+want.project+"/traces"; got != wantPath {
+			t.Errorf("request path = %q; want %q", got, wantPath)
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var traces api.Traces
+		if err := json.Unmarshal(body, &traces); err != nil {
+			t.Fatal(err)
+		}
+		if len(traces.Traces) != 1 || traces.Traces[0].ProjectId != want.project || len(traces.Traces[0].Spans) != want.spans {
+			t.Errorf("project %s: got traces %+v; want one trace with %d spans", want.project, traces.Traces, want.spans)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
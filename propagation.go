@@ -0,0 +1,141 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// labelDecoyHeader is the decoy trace header sent in place of the real
+// one by an outgoing request made with a context from WithDecoyPropagation.
+const labelDecoyHeader = `trace/decoy_header`
+
+// A propagation says what trace header outgoing requests send.
+type propagation int
+
+const (
+	propagateTrace propagation = iota // the header of the trace.
+	propagateNone                     // no header.
+	propagateDecoy                    // a header for an unrelated trace.
+)
+
+type propagationKey struct{}
+
+// WithoutPropagation returns a copy of ctx under which outgoing requests
+// don't send trace headers, for example for calls to a third party that
+// must not learn the IDs of internal traces.  The spans of the requests are
+// still recorded and exported as part of the trace.  It applies to the HTTP
+// clients and gRPC client interceptors of this package, and to
+// OutgoingContext and GRPCCallOption, for ctx and the contexts derived from
+// it.
+func WithoutPropagation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, propagationKey{}, propagateNone)
+}
+
+// WithDecoyPropagation is like WithoutPropagation, but outgoing requests send
+// a trace header for a new, random trace ID instead of the header of the
+// trace, so that the third party can still correlate its records of each
+// request.  The decoy header is recorded on the span of the request, as
+// "trace/decoy_header", but is not linked to the trace in any other way.
+func WithDecoyPropagation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, propagationKey{}, propagateDecoy)
+}
+
+func propagationOf(ctx context.Context) propagation {
+	p, _ := ctx.Value(propagationKey{}).(propagation)
+	return p
+}
+
+// header returns the trace header to send instead of header, the header for
+// span, a child span for an outgoing request.  The empty string means no
+// header.
+func (p propagation) header(span *Span, header string) string {
+	switch {
+	case header == "" || p == propagateTrace:
+		return header
+	case p == propagateNone:
+		return ""
+	}
+	decoy, ok := decoySpanContext(span)
+	if !ok {
+		return ""
+	}
+	return spanHeader(decoy.TraceID, decoy.SpanID, decoy.flags())
+}
+
+// spanContext returns the span context to send instead of sc, the span
+// context for span, a child span for an outgoing request, and false if no
+// span context should be sent.
+func (p propagation) spanContext(span *Span, sc SpanContext) (SpanContext, bool) {
+	switch p {
+	case propagateTrace:
+		return sc, true
+	case propagateNone:
+		return SpanContext{}, false
+	}
+	return decoySpanContext(span)
+}
+
+// decoySpanContext returns the span context of a new, random trace, with the
+// options of span, and labels span with its header.
+func decoySpanContext(span *Span) (SpanContext, bool) {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return SpanContext{}, false
+	}
+	var options optionFlags
+	if span != nil {
+		options = span.options.global
+	}
+	decoy := SpanContext{
+		TraceID: fmt.Sprintf("%x", b[:16]),
+		SpanID:  binary.BigEndian.Uint64(b[16:]) | 1, // never zero.
+		Traced:  options&optionTrace != 0,
+		options: options,
+	}
+	span.setLabel(labelDecoyHeader, spanHeader(decoy.TraceID, decoy.SpanID, decoy.flags()))
+	return decoy, true
+}
+
+// setHTTPHeader replaces the trace header that NewRemoteChild set in r, if
+// ctx asks for a different propagation.
+func setHTTPHeader(ctx context.Context, r *http.Request, span *Span) {
+	p := propagationOf(ctx)
+	if p == propagateTrace {
+		return
+	}
+	if h := p.header(span, r.Header.Get(httpHeader)); h != "" {
+		r.Header[httpHeader] = []string{h}
+	} else {
+		delete(r.Header, httpHeader)
+	}
+}
+
+// withoutOutgoingHeader returns ctx without an outgoing gRPC trace header.
+func withoutOutgoingHeader(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok || len(md[grpcMetadataKey]) == 0 {
+		return ctx
+	}
+	md = md.Copy() // metadata is immutable, copy.
+	delete(md, grpcMetadataKey)
+	return metadata.NewOutgoingContext(ctx, md)
+}
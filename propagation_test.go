@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var propagationTests = []struct {
+	desc   string
+	ctx    func(context.Context) context.Context
+	header string // "trace", "none" or "decoy".
+}{
+	{"default", func(ctx context.Context) context.Context { return ctx }, "trace"},
+	{"without propagation", WithoutPropagation, "none"},
+	{"decoy", WithDecoyPropagation, "decoy"},
+	{"inherited", func(ctx context.Context) context.Context {
+		ctx = WithoutPropagation(ctx)
+		return NewContext(ctx, FromContext(ctx).NewChild("/local"))
+	}, "none"},
+}
+
+// checkPropagated checks the header sent by a request for the exported span
+// named name, of the trace of root.
+func checkPropagated(t *testing.T, desc, want string, headers []string, root *Span, e *recordingExporter, name string) {
+	s := e.span(name)
+	if s == nil {
+		t.Fatalf("%s: span %q was not exported", desc, name)
+	}
+	if s.TraceID != root.TraceID() {
+		t.Errorf("%s: span %q has trace %s; want %s", desc, name, s.TraceID, root.TraceID())
+	}
+	decoy, hasDecoy := s.Labels[labelDecoyHeader]
+	switch want {
+	case "trace":
+		if len(headers) != 1 || !strings.HasPrefix(headers[0], root.TraceID()+"/") {
+			t.Errorf("%s: sent headers %q; want one for trace %s", desc, headers, root.TraceID())
+		}
+	case "none":
+		if len(headers) != 0 {
+			t.Errorf("%s: sent headers %q; want none", desc, headers)
+		}
+	case "decoy":
+		if len(headers) != 1 || strings.Contains(headers[0], root.TraceID()) {
+			t.Fatalf("%s: sent headers %q; want one decoy header", desc, headers)
+		}
+		if _, err := NewClientWithExporter(&recordingExporter{}).SpanFromHeaderErr("", headers[0]); err != nil {
+			t.Errorf("%s: decoy header %q is malformed: %v", desc, headers[0], err)
+		}
+		if decoy != headers[0] {
+			t.Errorf("%s: %s = %q; want the sent header %q", desc, labelDecoyHeader, decoy, headers[0])
+		}
+	}
+	if want != "decoy" && hasDecoy {
+		t.Errorf("%s: span has %s label %q; want none", desc, labelDecoyHeader, decoy)
+	}
+}
+
+func TestPropagationHTTP(t *testing.T) {
+	for _, tt := range propagationTests {
+		rt := &recorderTransport{ch: make(chan *http.Request, 1)}
+		client := WrapHTTPClient(&http.Client{Transport: rt})
+		e := &recordingExporter{}
+		root := NewClientWithExporter(e).NewSpan("/root")
+		ctx := tt.ctx(NewContext(context.Background(), root))
+		req, _ := http.NewRequest("GET", "http://example.com/api", nil)
+		if _, err := client.Do(req.WithContext(ctx)); err != nil {
+			t.Fatal(err)
+		}
+		outgoing := <-rt.ch
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		checkPropagated(t, tt.desc, tt.header, outgoing.Header[httpHeader], root, e, "example.com/api")
+	}
+}
+
+func TestPropagationGRPC(t *testing.T) {
+	for _, tt := range propagationTests {
+		e := &recordingExporter{}
+		root := NewClientWithExporter(e).NewSpan("/root")
+		// The header added by OutgoingContext is removed too.
+		ctx := OutgoingContext(tt.ctx(NewContext(context.Background(), root)))
+		var headers []string
+		GRPCClientInterceptor()(ctx, "/call", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			headers = md[grpcMetadataKey]
+			return nil
+		})
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		checkPropagated(t, tt.desc, tt.header, headers, root, e, "/call")
+	}
+}
+
+func TestPropagationCallOption(t *testing.T) {
+	root := NewClientWithExporter(&recordingExporter{}).NewSpan("/root")
+	ctx := WithoutPropagation(NewContext(context.Background(), root))
+	creds := GRPCCallOption(ctx).(grpc.PerRPCCredsCallOption).Creds
+	if md, err := creds.GetRequestMetadata(ctx); err != nil || len(md) != 0 {
+		t.Errorf("GetRequestMetadata = %v, %v; want no metadata", md, err)
+	}
+	if md, ok := metadata.FromOutgoingContext(OutgoingContext(ctx)); ok && len(md[grpcMetadataKey]) != 0 {
+		t.Errorf("OutgoingContext added headers %q; want none", md[grpcMetadataKey])
+	}
+}
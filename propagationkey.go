@@ -0,0 +1,81 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "strings"
+
+// WithPropagationKey returns an InterceptorOption that makes the gRPC
+// interceptors carry the Google trace header under the metadata key key,
+// instead of x-cloud-trace-context.  This is useful when a proxy on the way
+// strips x-cloud-* metadata.  The client interceptors send the header under
+// key only.  The server interceptors read it from key, and then from each
+// of alsoAccept, in order, so that a service can accept the old key while
+// its callers move to the new one:
+//
+//   opts := []trace.InterceptorOption{trace.WithPropagationKey("x-internal-trace", "x-cloud-trace-context")}
+//
+// Keys are lowercased, as gRPC requires.  The option changes the key of
+// GooglePropagator, including when it is one of several propagators given
+// to WithPropagators or WithHeaderFormats; other propagators keep their
+// headers.  It doesn't apply to HTTP handlers, whose request headers are
+// set with Client.SetRequestHeaders.
+func WithPropagationKey(key string, alsoAccept ...string) InterceptorOption {
+	keys := []string{strings.ToLower(key)}
+	for _, k := range alsoAccept {
+		keys = append(keys, strings.ToLower(k))
+	}
+	return withPropagationKey{keys}
+}
+
+type withPropagationKey struct {
+	keys []string
+}
+
+func (o withPropagationKey) configureInterceptor(c *interceptorConfig) {
+	c.propagationKeys = o.keys
+}
+
+// keyedGooglePropagator is GooglePropagator under another key.
+type keyedGooglePropagator struct {
+	key string
+}
+
+func (p keyedGooglePropagator) Inject(sc SpanContext, carrier Carrier) {
+	googlePropagator{}.Inject(sc, keyedCarrier{carrier, p.key})
+}
+
+func (p keyedGooglePropagator) Extract(carrier Carrier) (SpanContext, error) {
+	return googlePropagator{}.Extract(keyedCarrier{carrier, p.key})
+}
+
+// keyedCarrier is a Carrier whose Google trace header is under key.
+type keyedCarrier struct {
+	Carrier
+	key string
+}
+
+func (c keyedCarrier) Get(key string) string {
+	if key == httpHeader {
+		key = c.key
+	}
+	return c.Carrier.Get(key)
+}
+
+func (c keyedCarrier) Set(key, value string) {
+	if key == httpHeader {
+		key = c.key
+	}
+	c.Carrier.Set(key, value)
+}
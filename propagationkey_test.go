@@ -0,0 +1,98 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPropagationKeyClient(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	for _, tt := range []struct {
+		desc     string
+		opts     []InterceptorOption
+		wantKeys []string
+	}{
+		{desc: "key", opts: []InterceptorOption{WithPropagationKey("X-Internal-Trace", "x-cloud-trace-context")}, wantKeys: []string{"x-internal-trace"}},
+		{desc: "key and traceparent", opts: []InterceptorOption{WithPropagators(GooglePropagator, TraceparentPropagator), WithPropagationKey("x-internal-trace")}, wantKeys: []string{"x-internal-trace", traceparentKey}},
+	} {
+		// OutgoingContext adds a header under the default key, which the
+		// interceptor replaces.
+		ctx := OutgoingContext(NewContext(context.Background(), root))
+		var sent metadata.MD
+		GRPCClientInterceptor(tt.opts...)(ctx, "/call", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			sent, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+		if len(sent) != len(tt.wantKeys) {
+			t.Errorf("%s: sent metadata %v; want only the keys %v", tt.desc, sent, tt.wantKeys)
+		}
+		for _, k := range tt.wantKeys {
+			if len(sent[k]) != 1 {
+				t.Errorf("%s: sent metadata %v; want one value for %s", tt.desc, sent, k)
+			}
+		}
+		if h := sent.Get("x-internal-trace"); len(h) == 1 {
+			if !strings.HasPrefix(h[0], root.TraceID()+"/") {
+				t.Errorf("%s: sent header %q; want a header of the trace %s", tt.desc, h[0], root.TraceID())
+			}
+		}
+	}
+}
+
+func TestPropagationKeyServer(t *testing.T) {
+	const (
+		newHeader = "0123456789abcdef0123456789abcdef/11;o=1"
+		oldHeader = "fedcba9876543210fedcba9876543210/22;o=1"
+	)
+	opt := WithPropagationKey("x-internal-trace", grpcMetadataKey)
+	for _, tt := range []struct {
+		desc      string
+		md        metadata.MD
+		wantTrace string // "" for an untraced call.
+	}{
+		{desc: "new key", md: metadata.Pairs("x-internal-trace", newHeader), wantTrace: newHeader[:32]},
+		{desc: "old key", md: metadata.Pairs(grpcMetadataKey, oldHeader), wantTrace: oldHeader[:32]},
+		{desc: "both keys", md: metadata.Pairs(grpcMetadataKey, oldHeader, "x-internal-trace", newHeader), wantTrace: newHeader[:32]},
+		{desc: "other key", md: metadata.Pairs("x-other", newHeader)},
+	} {
+		tc := NewClientWithExporter(&recordingExporter{})
+		ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+		var unary, stream *Span
+		GRPCServerInterceptor(tc, opt)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			unary = FromContext(ctx)
+			return nil, nil
+		})
+		GRPCStreamServerInterceptor(tc, opt)(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+			stream = FromContext(ss.Context())
+			return nil
+		})
+		for _, s := range []*Span{unary, stream} {
+			var got string
+			if s.traced() {
+				got = s.TraceID()
+			}
+			if got != tt.wantTrace {
+				t.Errorf("%s: call traced in trace %q; want %q", tt.desc, got, tt.wantTrace)
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// A Carrier holds the headers of a request, in which a Propagator reads and
+// writes the trace context.
+type Carrier interface {
+	// Get returns the value of the header with the given key, or "" if
+	// there is none.
+	Get(key string) string
+	// Set sets the header with the given key to value, replacing any
+	// values it had.
+	Set(key, value string)
+}
+
+// MetadataCarrier is a Carrier for the metadata of a gRPC call.  Keys are
+// lowercased, as gRPC requires.  Like http.Header's Get, Get returns the
+// first value of a key: a key with several values, such as a trace header
+// added twice by proxies, would otherwise give a header that is neither.
+type MetadataCarrier metadata.MD
+
+func (c MetadataCarrier) Get(key string) string {
+	if v := c[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c MetadataCarrier) Set(key, value string) {
+	c[strings.ToLower(key)] = []string{value}
+}
+
+// HeaderCarrier is a Carrier for the headers of an HTTP request.
+type HeaderCarrier http.Header
+
+func (c HeaderCarrier) Get(key string) string {
+	return http.Header(c).Get(key)
+}
+
+func (c HeaderCarrier) Set(key, value string) {
+	http.Header(c).Set(key, value)
+}
+
+// ErrNoHeader is returned by Propagator.Extract when a carrier has no
+// header in the format of the propagator.
+var ErrNoHeader = errors.New("trace: no trace header")
+
+// A Propagator reads and writes the trace context of requests in one header
+// format.  GooglePropagator, TraceparentPropagator, B3Propagator and
+// B3SinglePropagator implement the formats this package knows; other formats
+// can be used by implementing Propagator, and passing the implementation to
+// WithPropagators.
+type Propagator interface {
+	// Inject sets the headers in carrier that propagate sc, the span context
+	// of an outgoing request.
+	Inject(sc SpanContext, carrier Carrier)
+
+	// Extract returns the span context in the headers of carrier, from an
+	// incoming request.  It returns ErrNoHeader if carrier has no header in
+	// the format of the propagator, and a *HeaderError if the header could
+	// not be parsed.
+	Extract(carrier Carrier) (SpanContext, error)
+}
+
+// GooglePropagator propagates trace contexts in the x-cloud-trace-context
+// header of Stackdriver Trace, which is what the interceptors use by
+// default.
+var GooglePropagator Propagator = googlePropagator{}
+
+type googlePropagator struct{}
+
+func (googlePropagator) Inject(sc SpanContext, carrier Carrier) {
+	h := spanHeader(sc.TraceID, sc.SpanID, sc.flags())
+	if sc.extra != "" {
+		h += ";" + sc.extra
+	}
+	carrier.Set(httpHeader, h)
+}
+
+func (googlePropagator) Extract(carrier Carrier) (SpanContext, error) {
+	h := carrier.Get(httpHeader)
+	if h == "" {
+		return SpanContext{}, ErrNoHeader
+	}
+	traceID, spanID, options, extra, err := parseHeaderErr(h)
+	if err != nil {
+		return SpanContext{}, err
+	}
+	return SpanContext{TraceID: traceID, SpanID: spanID, Traced: options&optionTrace != 0, options: options, extra: extra}, nil
+}
+
+type withPropagators struct {
+	propagators []Propagator
+}
+
+// WithPropagators returns an InterceptorOption that makes the gRPC
+// interceptors propagate trace contexts with propagators, instead of
+// GooglePropagator.  The client interceptors inject the span context of each
+// call with all of them, and the server interceptors extract the span
+// context of an incoming call with the first of them that finds a header in
+// its metadata, trying them in order.  For example, to accept and send a
+// proprietary header as well as the Google header:
+//
+//   opts := []trace.InterceptorOption{trace.WithPropagators(trace.GooglePropagator, myPropagator)}
+//
+// WithPropagators and WithHeaderFormats replace each other's settings; the
+// last one given is used.  Like WithHeaderFormats, it doesn't apply to HTTP
+// clients.
+func WithPropagators(propagators ...Propagator) InterceptorOption {
+	return withPropagators{propagators: propagators}
+}
+
+func (o withPropagators) configureInterceptor(c *interceptorConfig) {
+	c.propagators = o.propagators
+}
+
+var defaultPropagators = []Propagator{GooglePropagator}
+
+// propagatorsOrDefault returns the propagators the interceptors use.
+func (c *interceptorConfig) propagatorsOrDefault() []Propagator {
+	if len(c.propagators) == 0 {
+		return defaultPropagators
+	}
+	return c.propagators
+}
+
+// injectors returns the propagators the client interceptors inject span
+// contexts with: those of propagatorsOrDefault, with GooglePropagator using
+// the key of WithPropagationKey.
+func (c *interceptorConfig) injectors() []Propagator {
+	if len(c.propagationKeys) == 0 {
+		return c.propagatorsOrDefault()
+	}
+	var ps []Propagator
+	for _, p := range c.propagatorsOrDefault() {
+		if p == GooglePropagator {
+			p = keyedGooglePropagator{c.propagationKeys[0]}
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// extractors returns the propagators the server interceptors extract span
+// contexts with: those of propagatorsOrDefault, with GooglePropagator
+// replaced by one propagator for each key of WithPropagationKey.
+func (c *interceptorConfig) extractors() []Propagator {
+	return c.keyedExtractors(c.propagatorsOrDefault())
+}
+
+// keyedExtractors returns propagators, with GooglePropagator replaced by one
+// propagator for each key of WithPropagationKey.
+func (c *interceptorConfig) keyedExtractors(propagators []Propagator) []Propagator {
+	if len(c.propagationKeys) == 0 {
+		return propagators
+	}
+	var ps []Propagator
+	for _, p := range propagators {
+		if p != GooglePropagator {
+			ps = append(ps, p)
+			continue
+		}
+		for _, k := range c.propagationKeys {
+			ps = append(ps, keyedGooglePropagator{k})
+		}
+	}
+	return ps
+}
+
+// extract returns the span context of an incoming call to tc with context
+// ctx and metadata md, extracted by the first propagator that finds a
+// header, and the error it returned.  It returns false if no propagator
+// found a header.  The propagators are those of the formats tc accepts, if
+// SetAcceptedHeaderFormats was called.
+func (c *interceptorConfig) extract(tc *Client, ctx context.Context, md metadata.MD) (SpanContext, bool, error) {
+	extractors := c.extractors()
+	if accepted := tc.acceptedHeaderFormats(); accepted != nil {
+		tc.checkForeignHeaders(ctx, md, accepted)
+		extractors = c.keyedExtractors(acceptedPropagators(accepted))
+	}
+	for _, p := range extractors {
+		sc, err := p.Extract(MetadataCarrier(md))
+		if err != ErrNoHeader {
+			return sc, true, err
+		}
+	}
+	return SpanContext{}, false, nil
+}
+
+// outgoingContext returns ctx with the trace headers for span, which must not
+// be nil, added to its outgoing gRPC metadata by the propagators, replacing
+// any Google trace header added by OutgoingContext.
+func (c *interceptorConfig) outgoingContext(ctx context.Context, span *Span) context.Context {
+	parentID := span.span.ParentSpanId
+	if parentID == 0 {
+		// span is the root span of a call started by WithRootSpans.
+		parentID = span.span.SpanId
+	}
+	sc, ok := propagationOf(ctx).spanContext(span, span.spanContext(parentID))
+	if !ok {
+		return withoutOutgoingHeader(ctx)
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if md == nil {
+		md = metadata.MD{}
+	} else {
+		md = md.Copy() // metadata is immutable, copy.
+		delete(md, grpcMetadataKey)
+	}
+	for _, p := range c.injectors() {
+		p.Inject(sc, MetadataCarrier(md))
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// colonPropagator propagates trace contexts in a made-up header of the form
+// <trace ID>:<span ID>:<traced>.
+type colonPropagator struct{}
+
+const colonKey = "x-colon-trace"
+
+func (colonPropagator) Inject(sc SpanContext, carrier Carrier) {
+	carrier.Set(colonKey, fmt.Sprintf("%s:%d:%t", sc.TraceID, sc.SpanID, sc.Traced))
+}
+
+func (colonPropagator) Extract(carrier Carrier) (SpanContext, error) {
+	h := carrier.Get(colonKey)
+	if h == "" {
+		return SpanContext{}, ErrNoHeader
+	}
+	f := strings.Split(h, ":")
+	if len(f) != 3 {
+		return SpanContext{}, &HeaderError{Header: h, Err: ErrMalformedTraceID}
+	}
+	spanID, err := strconv.ParseUint(f[1], 10, 64)
+	if err != nil {
+		return SpanContext{}, &HeaderError{Header: h, Err: ErrMalformedSpanID}
+	}
+	return SpanContext{TraceID: f[0], SpanID: spanID, Traced: f[2] == "true"}, nil
+}
+
+func TestDefaultPropagatorHeader(t *testing.T) {
+	// The default propagator sends the same header as before propagators,
+	// including the options this package doesn't understand.
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.SpanFromHeader("/root", validationTraceID+"/42;o=1;v=2")
+	ctx := NewContext(context.Background(), root)
+	want := []string{root.header(root.span.SpanId)}
+	if got := outgoingHeaders(ctx)[grpcMetadataKey]; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("default interceptor sent %q; want %q", got, want)
+	}
+	if got := outgoingHeaders(ctx, WithPropagators(GooglePropagator))[grpcMetadataKey]; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("interceptor with GooglePropagator sent %q; want %q", got, want)
+	}
+}
+
+func TestCustomPropagator(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	out := outgoingHeaders(ctx, WithPropagators(colonPropagator{}))
+	if got := out[grpcMetadataKey]; len(got) != 0 {
+		t.Errorf("interceptor with only a custom propagator sent Google header %q", got)
+	}
+	want := fmt.Sprintf("%s:%d:true", root.TraceID(), root.span.SpanId)
+	if got := out[colonKey]; len(got) != 1 || got[0] != want {
+		t.Errorf("interceptor sent %s %q; want %q", colonKey, got, want)
+	}
+
+	// The server tries the propagators in order.
+	const otherTraceID = "fedcba9876543210fedcba9876543210"
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/In"}
+	for _, tt := range []struct {
+		desc        string
+		md          metadata.MD
+		wantTraceID string
+	}{
+		{"both headers", metadata.Pairs(colonKey, validationTraceID+":7:true", grpcMetadataKey, otherTraceID+"/7;o=1"), validationTraceID},
+		{"Google header", metadata.Pairs(grpcMetadataKey, otherTraceID+"/7;o=1"), otherTraceID},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+		GRPCServerInterceptor(tc, WithPropagators(colonPropagator{}, GooglePropagator))(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			span := FromContext(ctx)
+			if got := span.TraceID(); got != tt.wantTraceID {
+				t.Errorf("%s: server span TraceID = %q; want %q", tt.desc, got, tt.wantTraceID)
+			}
+			if got, want := span.span.ParentSpanId, uint64(7); got != want {
+				t.Errorf("%s: server span parent = %d; want %d", tt.desc, got, want)
+			}
+			return nil, nil
+		})
+	}
+}
+
+func TestCarriers(t *testing.T) {
+	sc := SpanContext{TraceID: validationTraceID, SpanID: 1, Traced: true}
+	h := http.Header{}
+	md := metadata.MD{}
+	for _, tt := range []struct {
+		desc    string
+		carrier Carrier
+		raw     func() string
+	}{
+		{"HeaderCarrier", HeaderCarrier(h), func() string { return h.Get("X-Cloud-Trace-Context") }},
+		{"MetadataCarrier", MetadataCarrier(md), func() string { return strings.Join(md["x-cloud-trace-context"], "") }},
+	} {
+		GooglePropagator.Inject(sc, tt.carrier)
+		if got, want := tt.raw(), validationTraceID+"/1;o=1"; got != want {
+			t.Errorf("%s: injected header %q; want %q", tt.desc, got, want)
+		}
+		got, err := GooglePropagator.Extract(tt.carrier)
+		if err != nil || got.TraceID != sc.TraceID || got.SpanID != sc.SpanID || !got.Traced {
+			t.Errorf("%s: Extract = %+v, %v; want %+v", tt.desc, got, err, sc)
+		}
+	}
+	if _, err := GooglePropagator.Extract(HeaderCarrier(http.Header{})); err != ErrNoHeader {
+		t.Errorf("Extract from empty headers: error = %v; want ErrNoHeader", err)
+	}
+}
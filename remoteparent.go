@@ -0,0 +1,66 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+// RemoteParent describes the remote parent of a span, as read from the trace
+// header of an incoming request.
+type RemoteParent struct {
+	TraceID string // hex-encoded trace ID.
+	SpanID  uint64 // span ID of the parent, in the calling process.
+
+	// Options is the value of the header's "o=" option.  Bit 0 enables
+	// tracing, and Traced reports whether it is set.  This is the caller's
+	// choice; the client's sampling policy may have changed it for the span.
+	Options uint32
+	Traced  bool
+
+	// UnknownOptions holds the options of the header that this package
+	// doesn't recognize, such as ones set by other tracing vendors, as
+	// returned by Span.UnknownHeaderOptions.
+	UnknownOptions map[string]string
+}
+
+// RemoteParent returns the remote parent of s, as read from the trace header
+// of the incoming request by SpanFromHeader, SpanFromRequest or the server
+// interceptors.  Handlers can use it to check the trace context they were
+// called with, such as vendor options that name the caller's environment,
+// before doing any work:
+//
+//   if p := trace.FromContext(ctx).RemoteParent(); p != nil && p.UnknownOptions["env"] != "prod" {
+//     return nil, status.Error(codes.PermissionDenied, "unexpected caller environment")
+//   }
+//
+// It returns nil if s is nil, if s was not created from a valid trace
+// header, or if s is not the span for the incoming request itself, such as
+// a child of that span.  The result is a new copy, which the caller may
+// modify.
+func (s *Span) RemoteParent() *RemoteParent {
+	if s == nil || !s.rootSpan || !s.trace.remote {
+		return nil
+	}
+	return &RemoteParent{
+		TraceID:        s.trace.traceID,
+		SpanID:         s.span.ParentSpanId,
+		Options:        uint32(s.trace.remoteOptions),
+		Traced:         s.trace.remoteOptions&optionTrace != 0,
+		UnknownOptions: s.UnknownHeaderOptions(),
+	}
+}
+
+// StackTraceRequested reports whether the header requested stack traces,
+// with bit 1 of Options.
+func (p *RemoteParent) StackTraceRequested() bool {
+	return optionFlags(p.Options)&optionStack != 0
+}
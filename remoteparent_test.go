@@ -0,0 +1,67 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRemoteParent(t *testing.T) {
+	const header = "0123456789ABCDEF0123456789ABCDEF/42;o=3;env=staging;x-vendor=a=b"
+	want := &RemoteParent{
+		TraceID:        "0123456789ABCDEF0123456789ABCDEF",
+		SpanID:         42,
+		Options:        3,
+		Traced:         true,
+		UnknownOptions: map[string]string{"env": "staging", "x-vendor": "a=b"},
+	}
+	tc := NewClientWithExporter(&recordingExporter{})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(grpcMetadataKey, header))
+	var got *RemoteParent
+	GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		got = FromContext(ctx).RemoteParent()
+		if child := FromContext(ctx).NewChild("/child"); child.RemoteParent() != nil {
+			t.Errorf("child span has a remote parent")
+		}
+		return nil, nil
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("gRPC server span: RemoteParent() = %+v; want %+v", got, want)
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Set(httpHeader, header)
+	if got := tc.SpanFromRequest(req).RemoteParent(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SpanFromRequest: RemoteParent() = %+v; want %+v", got, want)
+	}
+
+	untraced := tc.SpanFromHeader("/foo", "0123456789ABCDEF0123456789ABCDEF/42;o=0")
+	if got := untraced.RemoteParent(); got == nil || got.Traced || got.Options != 0 {
+		t.Errorf("untraced header: RemoteParent() = %+v; want an untraced parent", got)
+	}
+
+	for _, span := range []*Span{tc.NewSpan("/local"), tc.SpanFromHeader("/bad", "garbage"), nil} {
+		if got := span.RemoteParent(); got != nil {
+			t.Errorf("RemoteParent() = %+v; want nil", got)
+		}
+	}
+}
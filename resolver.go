@@ -0,0 +1,135 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	labelResolverTarget    = `grpc/resolver/target`
+	labelResolverAddresses = `grpc/resolver/addresses`
+)
+
+// WrapResolver returns a resolver.Builder that builds the resolvers of b, and
+// traces their name resolution using tc.  Each resolution attempt, from the
+// building of the resolver or a call to ResolveNow until the resolver
+// reports the resolved addresses or an error, is recorded as a span named
+// "grpc.resolve/<scheme>", labeled with the target, the number of addresses
+// and any error.  Updates that the resolver pushes without being asked are
+// recorded as spans of zero duration.
+//
+// All calls between gRPC and the resolver are passed through unchanged.
+//
+//   conn, err := grpc.Dial("dns:///service.internal:443",
+//     grpc.WithResolvers(trace.WrapResolver(resolver.Get("dns"), tc)))
+func WrapResolver(b resolver.Builder, tc *Client) resolver.Builder {
+	return &tracingResolverBuilder{Builder: b, tc: tc}
+}
+
+type tracingResolverBuilder struct {
+	resolver.Builder
+	tc *Client
+}
+
+func (b *tracingResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	tcc := &tracingClientConn{
+		ClientConn: cc,
+		tc:         b.tc,
+		name:       "grpc.resolve/" + b.Scheme(),
+		target:     target.URL.String(),
+	}
+	// Some resolvers report their first result before Build returns.
+	tcc.startAttempt()
+	r, err := b.Builder.Build(target, tcc, opts)
+	if err != nil {
+		tcc.finishAttempt(-1, err)
+		return nil, err
+	}
+	return &tracingResolver{Resolver: r, cc: tcc}, nil
+}
+
+// tracingResolver starts a resolution attempt when gRPC asks the resolver to
+// resolve the target again.
+type tracingResolver struct {
+	resolver.Resolver
+	cc *tracingClientConn
+}
+
+func (r *tracingResolver) ResolveNow(o resolver.ResolveNowOptions) {
+	r.cc.startAttempt()
+	r.Resolver.ResolveNow(o)
+}
+
+// tracingClientConn finishes the current resolution attempt when the
+// resolver reports a result.
+type tracingClientConn struct {
+	resolver.ClientConn
+	tc     *Client
+	name   string
+	target string
+
+	mu      sync.Mutex
+	attempt *Span // the current resolution attempt, or nil.
+}
+
+func (cc *tracingClientConn) UpdateState(s resolver.State) error {
+	cc.finishAttempt(len(s.Addresses), nil)
+	return cc.ClientConn.UpdateState(s)
+}
+
+func (cc *tracingClientConn) ReportError(err error) {
+	cc.finishAttempt(-1, err)
+	cc.ClientConn.ReportError(err)
+}
+
+// startAttempt starts a span for a resolution attempt, unless one is already
+// in progress.
+func (cc *tracingClientConn) startAttempt() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.attempt == nil {
+		cc.attempt = cc.newAttempt()
+	}
+}
+
+// finishAttempt finishes the span of the current resolution attempt, or of
+// a new one if none is in progress, with the number of resolved addresses,
+// if addresses is not negative, or err.
+func (cc *tracingClientConn) finishAttempt(addresses int, err error) {
+	cc.mu.Lock()
+	span := cc.attempt
+	if span == nil {
+		span = cc.newAttempt()
+	}
+	cc.attempt = nil
+	cc.mu.Unlock()
+	if addresses >= 0 {
+		span.setLabel(labelResolverAddresses, strconv.Itoa(addresses))
+	}
+	if err != nil {
+		span.setLabel("error", err.Error())
+	}
+	span.Finish()
+}
+
+func (cc *tracingClientConn) newAttempt() *Span {
+	span := cc.tc.NewSpan(cc.name)
+	span.setLabel(labelResolverTarget, cc.target)
+	return span
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// recordingClientConn is a resolver.ClientConn that records the calls made
+// to it.
+type recordingClientConn struct {
+	states []resolver.State
+	errs   []error
+
+	updateErr error // returned by UpdateState
+}
+
+func (cc *recordingClientConn) UpdateState(s resolver.State) error {
+	cc.states = append(cc.states, s)
+	return cc.updateErr
+}
+func (cc *recordingClientConn) ReportError(err error)               { cc.errs = append(cc.errs, err) }
+func (cc *recordingClientConn) NewAddress(addrs []resolver.Address) {}
+func (cc *recordingClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+// waitForSpan returns the next span exported to e.
+func waitForSpan(t *testing.T, e *recordingExporter) *SpanData {
+	select {
+	case <-e.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for export")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.spans[len(e.spans)-1]
+}
+
+func TestWrapResolver(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+
+	r := manual.NewBuilderWithScheme("test")
+	b := WrapResolver(r, tc)
+	if got, want := b.Scheme(), "test"; got != want {
+		t.Errorf("Scheme() = %q; want %q", got, want)
+	}
+	cc := &recordingClientConn{}
+	res, err := b.Build(resolver.Target{URL: url.URL{Scheme: "test", Path: "/service.internal:443"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	// First cycle: the initial resolution.
+	first := resolver.State{Addresses: []resolver.Address{{Addr: "10.0.0.1:443"}, {Addr: "10.0.0.2:443"}}}
+	r.UpdateState(first)
+	s := waitForSpan(t, e)
+	want := map[string]string{
+		labelResolverTarget:    "test:///service.internal:443",
+		labelResolverAddresses: "2",
+	}
+	if s.Name != "grpc.resolve/test" || !reflect.DeepEqual(s.Labels, want) {
+		t.Errorf("first span: got %q %v; want %q %v", s.Name, s.Labels, "grpc.resolve/test", want)
+	}
+
+	// Second cycle: gRPC asks for re-resolution.
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	second := resolver.State{Addresses: []resolver.Address{{Addr: "10.0.0.3:443"}}}
+	r.UpdateState(second)
+	s = waitForSpan(t, e)
+	if got, want := s.Labels[labelResolverAddresses], "1"; got != want {
+		t.Errorf("second span: addresses label = %q; want %q", got, want)
+	}
+
+	// A failed resolution.
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	resolveErr := errors.New("no such host")
+	r.CC().ReportError(resolveErr)
+	s = waitForSpan(t, e)
+	if got, want := s.Labels["error"], resolveErr.Error(); got != want {
+		t.Errorf("error span: error label = %q; want %q", got, want)
+	}
+	if _, ok := s.Labels[labelResolverAddresses]; ok {
+		t.Errorf("error span has an addresses label")
+	}
+
+	// An error from the ClientConn is returned to the resolver.
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	cc.updateErr = errors.New("bad service config")
+	if err := r.CC().UpdateState(second); err != cc.updateErr {
+		t.Errorf("UpdateState returned %v; want %v", err, cc.updateErr)
+	}
+	waitForSpan(t, e)
+	cc.states = cc.states[:2]
+
+	// The calls reach the original ClientConn unchanged.
+	if !reflect.DeepEqual(cc.states, []resolver.State{first, second}) {
+		t.Errorf("ClientConn got states %v; want %v", cc.states, []resolver.State{first, second})
+	}
+	if len(cc.errs) != 1 || cc.errs[0] != resolveErr {
+		t.Errorf("ClientConn got errors %v; want [%v]", cc.errs, resolveErr)
+	}
+}
+
+// failingBuilder is a resolver.Builder whose Build fails.
+type failingBuilder struct{}
+
+func (failingBuilder) Build(resolver.Target, resolver.ClientConn, resolver.BuildOptions) (resolver.Resolver, error) {
+	return nil, errors.New("bad target")
+}
+
+func (failingBuilder) Scheme() string { return "fail" }
+
+func TestWrapResolverBuildError(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+
+	if _, err := WrapResolver(failingBuilder{}, tc).Build(resolver.Target{}, &recordingClientConn{}, resolver.BuildOptions{}); err == nil {
+		t.Fatal("Build succeeded; want error")
+	}
+	if got, want := waitForSpan(t, e).Labels["error"], "bad target"; got != want {
+		t.Errorf("error label = %q; want %q", got, want)
+	}
+}
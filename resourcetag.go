@@ -0,0 +1,72 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// ResourceTagLength is the length of the tags returned by ResourceTag.
+const ResourceTagLength = 40
+
+// resourceTagPrefix starts the tags of the current encoding.  It makes tags
+// start with a letter, as the names of many resources must.
+const resourceTagPrefix = "t"
+
+// resourceTagEncoding encodes the IDs of tags in lowercase letters and
+// digits.
+var resourceTagEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+// ResourceTag returns a tag that identifies the span of sc, to put in the
+// names of the resources created for it, for example by an integration
+// test, so that leaked resources can be traced back to the span that
+// created them with FromResourceTag.  Tags are ResourceTagLength characters
+// long, lowercase letters and digits, starting with a letter.  They encode
+// the trace and span IDs in full, so distinct spans have distinct tags, and
+// the encoding won't change: tags made by any version of this package can
+// be decoded by later ones.
+//
+// A trace ID that isn't 32 hex digits is encoded as zero, and the tag
+// decodes to no span.
+func ResourceTag(sc SpanContext) string {
+	var b [24]byte
+	if id, err := hex.DecodeString(sc.TraceID); err == nil && len(id) == 16 {
+		copy(b[:16], id)
+	}
+	binary.BigEndian.PutUint64(b[16:], sc.SpanID)
+	return resourceTagPrefix + resourceTagEncoding.EncodeToString(b[:])
+}
+
+// FromResourceTag returns the span context encoded in tag by ResourceTag,
+// without its sampling decision, and whether tag is such a tag.
+func FromResourceTag(tag string) (SpanContext, bool) {
+	if len(tag) != ResourceTagLength || tag[:len(resourceTagPrefix)] != resourceTagPrefix {
+		return SpanContext{}, false
+	}
+	b, err := resourceTagEncoding.DecodeString(tag[len(resourceTagPrefix):])
+	if err != nil || len(b) != 24 {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{
+		TraceID: hex.EncodeToString(b[:16]),
+		SpanID:  binary.BigEndian.Uint64(b[16:]),
+	}
+	if !validTraceID(sc.TraceID) {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
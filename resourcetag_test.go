@@ -0,0 +1,62 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResourceTag(t *testing.T) {
+	sc := SpanContext{TraceID: "0123456789abcdef0123456789abcdef", SpanID: 1<<64 - 1, Traced: true}
+	tag := ResourceTag(sc)
+	if len(tag) != ResourceTagLength {
+		t.Errorf("tag %q is %d characters; want %d", tag, len(tag), ResourceTagLength)
+	}
+	if tag[0] < 'a' || tag[0] > 'z' {
+		t.Errorf("tag %q doesn't start with a letter", tag)
+	}
+	if i := strings.IndexFunc(tag, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	}); i >= 0 {
+		t.Errorf("tag %q has the character %q", tag, tag[i])
+	}
+	got, ok := FromResourceTag(tag)
+	if want := (SpanContext{TraceID: sc.TraceID, SpanID: sc.SpanID}); !ok || got != want {
+		t.Errorf("FromResourceTag(%q) = %+v, %t; want %+v, true", tag, got, ok, want)
+	}
+
+	// The encoding is stable: tags made by earlier versions still decode.
+	const known = "t04hkaps9lf6uu0938ljojaudts0000000000008"
+	if got := ResourceTag(SpanContext{TraceID: sc.TraceID, SpanID: 1}); got != known {
+		t.Errorf("ResourceTag = %q; want %q", got, known)
+	}
+
+	other := ResourceTag(SpanContext{TraceID: sc.TraceID, SpanID: 2})
+	if other == tag {
+		t.Errorf("spans 1 and 2 share the tag %q", tag)
+	}
+	for _, bad := range []string{
+		"",
+		tag[:ResourceTagLength-1],
+		"x" + tag[1:],
+		tag[:10] + "W" + tag[11:],
+		ResourceTag(SpanContext{TraceID: "malformed", SpanID: 1}),
+	} {
+		if sc, ok := FromResourceTag(bad); ok {
+			t.Errorf("FromResourceTag(%q) = %+v, true; want false", bad, sc)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "net/http"
+
+// WithResponseTraceHeader returns an InterceptorOption that makes the HTTP
+// handler send the trace of each traced request to the client in a
+// response header, so that browsers and API consumers can report it.  If
+// name is empty, the header is X-Cloud-Trace-Context, and holds the trace
+// context of the request's span, in the format of the request header;
+// otherwise the header is name, for example "X-Trace-Id", and holds only
+// the trace ID.
+//
+// The header is set before the wrapped handler is called, so it is sent
+// with the first write of the response, whether or not the handler calls
+// WriteHeader.  It is not sent for requests that are not traced.
+func WithResponseTraceHeader(name string) InterceptorOption {
+	return withResponseTraceHeader{name}
+}
+
+type withResponseTraceHeader struct {
+	name string
+}
+
+func (o withResponseTraceHeader) configureInterceptor(c *interceptorConfig) {
+	c.responseHeader = true
+	c.responseHeaderName = o.name
+}
+
+// setResponseTraceHeader sets the response header of span, the span of an
+// incoming HTTP request, in h.
+func (c *interceptorConfig) setResponseTraceHeader(h http.Header, span *Span) {
+	if !c.responseHeader || span == nil || !span.tracing() {
+		return
+	}
+	if c.responseHeaderName == "" {
+		h.Set(httpHeader, span.header(span.span.SpanId))
+		return
+	}
+	h.Set(c.responseHeaderName, span.trace.traceID)
+}
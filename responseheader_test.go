@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseTraceHeader(t *testing.T) {
+	const reqHeader = "0123456789abcdef0123456789abcdef/1;o=1"
+	for _, tt := range []struct {
+		desc     string
+		opts     []InterceptorOption
+		traced   bool
+		header   string // the response header to check.
+		explicit bool   // whether the handler calls WriteHeader.
+		want     string // "" for no header; "context" for the span's header.
+	}{
+		{desc: "no option", traced: true, header: httpHeader},
+		{desc: "trace context", opts: []InterceptorOption{WithResponseTraceHeader("")}, traced: true, header: httpHeader, want: "context"},
+		{desc: "trace context, WriteHeader", opts: []InterceptorOption{WithResponseTraceHeader("")}, traced: true, header: httpHeader, explicit: true, want: "context"},
+		{desc: "trace ID", opts: []InterceptorOption{WithResponseTraceHeader("X-Trace-Id")}, traced: true, header: "X-Trace-Id", want: "0123456789abcdef0123456789abcdef"},
+		{desc: "untraced", opts: []InterceptorOption{WithResponseTraceHeader("")}, header: httpHeader},
+		{desc: "untraced trace ID", opts: []InterceptorOption{WithResponseTraceHeader("X-Trace-Id")}, header: "X-Trace-Id"},
+	} {
+		tc := NewClientWithExporter(&recordingExporter{})
+		if !tt.traced {
+			tc.SetSamplingPolicy(neverTrace{})
+		}
+		var span *Span
+		h := tc.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			span = FromContext(r.Context())
+			if tt.explicit {
+				w.WriteHeader(http.StatusAccepted)
+			}
+			io.WriteString(w, "body")
+		}), tt.opts...)
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		if tt.traced {
+			req.Header.Set(httpHeader, reqHeader)
+		} else {
+			req.Header.Set(httpHeader, "0123456789abcdef0123456789abcdef/1;o=0")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		want := tt.want
+		if want == "context" {
+			want = span.header(span.span.SpanId)
+		}
+		if got := rec.Result().Header.Get(tt.header); got != want {
+			t.Errorf("%s: response header %s = %q; want %q", tt.desc, tt.header, got, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+const (
+	labelRetryAttempt   = `retry/attempt`
+	labelRetryAttempts  = `retry/attempts`
+	labelRetryBackoffMs = `retry/backoff_ms`
+	labelRetryError     = `retry/error`
+)
+
+// A RetryPolicy decides whether, and when, Retry makes another attempt.
+type RetryPolicy interface {
+	// NextDelay is called after attempt number attempt, counting from 0,
+	// failed with err.  It returns how long to wait before the next
+	// attempt, and false if no more attempts should be made.
+	NextDelay(attempt int, err error) (time.Duration, bool)
+}
+
+// Retry calls f until it succeeds or policy gives up, and returns the error
+// of the last call.  If ctx is done while waiting between attempts, Retry
+// returns the context's error.
+//
+// Retry traces the whole operation with an umbrella span, named name, that
+// is a child of the span in ctx.  Each call of f has a child span of the
+// umbrella span, in the context passed to f, labeled with the attempt
+// number, the time waited before the attempt, and the error the attempt
+// returned, so the attempts can be told apart in the trace:
+//
+//   err := trace.Retry(ctx, "s3.Put", policy, func(ctx context.Context) error {
+//       return put(ctx, obj)
+//   })
+//
+// If ctx contains no span, f is called with ctx, and nothing is traced.
+func Retry(ctx context.Context, name string, policy RetryPolicy, f func(ctx context.Context) error) error {
+	span := FromContext(ctx).NewChild(name)
+	var (
+		err     error
+		backoff time.Duration
+		attempt int
+	)
+	for ; ; attempt++ {
+		s := span.NewChild(name)
+		s.setLabel(labelRetryAttempt, strconv.Itoa(attempt))
+		if attempt > 0 {
+			s.setLabel(labelRetryBackoffMs, strconv.FormatInt(int64(backoff/time.Millisecond), 10))
+		}
+		actx := ctx
+		if s != nil {
+			actx = NewContext(ctx, s)
+		}
+		err = f(actx)
+		if err != nil {
+			s.setLabel(labelRetryError, err.Error())
+		}
+		s.Finish()
+		if err == nil {
+			break
+		}
+		var retry bool
+		if backoff, retry = policy.NextDelay(attempt, err); !retry {
+			break
+		}
+		if ctxErr := retrySleep(ctx, backoff); ctxErr != nil {
+			err = ctxErr
+			break
+		}
+	}
+	span.setLabel(labelRetryAttempts, strconv.Itoa(attempt+1))
+	if err != nil {
+		span.setLabel(labelRetryError, err.Error())
+	}
+	span.Finish()
+	return err
+}
+
+// retrySleep waits for d, or until ctx is done, in which case it returns
+// the context's error.
+func retrySleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
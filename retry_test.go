@@ -0,0 +1,179 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// constantBackoff is a RetryPolicy that waits d between at most n attempts.
+type constantBackoff struct {
+	d time.Duration
+	n int
+}
+
+func (p constantBackoff) NextDelay(attempt int, err error) (time.Duration, bool) {
+	return p.d, attempt+1 < p.n
+}
+
+func TestRetry(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+
+	calls := 0
+	err := Retry(ctx, "partner.Call", constantBackoff{2 * time.Millisecond, 5}, func(ctx context.Context) error {
+		calls++
+		// Work done in an attempt is nested under the attempt's span.
+		FromContext(ctx).NewChild("rpc").Finish()
+		if calls <= 2 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("f was called %d times; want 3", calls)
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	var umbrella *SpanData
+	attempts := map[string]*SpanData{}
+	for _, s := range e.spans {
+		if s.Name != "partner.Call" {
+			continue
+		}
+		if a, ok := s.Labels["retry/attempt"]; ok {
+			attempts[a] = s
+		} else {
+			umbrella = s
+		}
+	}
+	if umbrella == nil {
+		t.Fatal("no umbrella span exported")
+	}
+	if got, want := umbrella.ParentSpanID, root.span.SpanId; got != want {
+		t.Errorf("umbrella parent = %d; want root span %d", got, want)
+	}
+	if got, want := umbrella.Labels["retry/attempts"], "3"; got != want {
+		t.Errorf("umbrella retry/attempts = %q; want %q", got, want)
+	}
+	if _, ok := umbrella.Labels["retry/error"]; ok {
+		t.Errorf("umbrella of a successful operation is labeled with an error")
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("got %d attempt spans; want 3", len(attempts))
+	}
+	for _, tt := range []struct {
+		attempt, backoff, err string
+	}{
+		{"0", "", "unavailable"},
+		{"1", "2", "unavailable"},
+		{"2", "2", ""},
+	} {
+		s := attempts[tt.attempt]
+		if s.ParentSpanID != umbrella.SpanID {
+			t.Errorf("attempt %s parent = %d; want umbrella %d", tt.attempt, s.ParentSpanID, umbrella.SpanID)
+		}
+		if got := s.Labels["retry/backoff_ms"]; got != tt.backoff {
+			t.Errorf("attempt %s retry/backoff_ms = %q; want %q", tt.attempt, got, tt.backoff)
+		}
+		if got := s.Labels["retry/error"]; got != tt.err {
+			t.Errorf("attempt %s retry/error = %q; want %q", tt.attempt, got, tt.err)
+		}
+	}
+	for _, s := range e.spans {
+		if s.Name != "rpc" {
+			continue
+		}
+		found := false
+		for _, a := range attempts {
+			found = found || s.ParentSpanID == a.SpanID
+		}
+		if !found {
+			t.Errorf("rpc span parent %d is not an attempt span", s.ParentSpanID)
+		}
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	e := &recordingExporter{}
+	root := NewClientWithExporter(e).NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+
+	want := errors.New("still failing")
+	calls := 0
+	err := Retry(ctx, "op", constantBackoff{0, 2}, func(context.Context) error {
+		calls++
+		return want
+	})
+	if err != want {
+		t.Errorf("Retry = %v; want %v", err, want)
+	}
+	if calls != 2 {
+		t.Errorf("f was called %d times; want 2", calls)
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range e.spans {
+		if _, ok := s.Labels["retry/attempts"]; !ok {
+			continue
+		}
+		if got, want := s.Labels["retry/attempts"], "2"; got != want {
+			t.Errorf("umbrella retry/attempts = %q; want %q", got, want)
+		}
+		if got, want := s.Labels["retry/error"], "still failing"; got != want {
+			t.Errorf("umbrella retry/error = %q; want %q", got, want)
+		}
+	}
+}
+
+func TestRetryContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := Retry(ctx, "op", constantBackoff{time.Hour, 5}, func(context.Context) error {
+		calls++
+		cancel()
+		return errors.New("failed")
+	})
+	if err != context.Canceled {
+		t.Errorf("Retry = %v; want %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Errorf("f was called %d times; want 1", calls)
+	}
+}
+
+func TestRetryNoSpan(t *testing.T) {
+	ctx := context.Background()
+	err := Retry(ctx, "op", constantBackoff{0, 1}, func(got context.Context) error {
+		if got != ctx {
+			t.Errorf("f was called with a new context without a span in the parent")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Retry = %v; want nil", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// A Router chooses the exporter for a span, by returning the name of an
+// exporter registered with Client.RegisterExporter.  The empty name selects
+// the client's default exporter.
+//
+// For example, to send force-sampled debug traces to a separate sink:
+//
+//   tc.RegisterExporter("debug", debugExporter)
+//   tc.SetRouter(func(s *trace.SpanData) string {
+//       if s.Labels["debug"] != "" {
+//           return "debug"
+//       }
+//       return ""
+//   })
+type Router func(s *SpanData) string
+
+// RegisterExporter registers an exporter under a name, for use by the
+// client's Router.  Registering a nil exporter removes the name.
+//
+// RegisterExporter should be called before any spans are created.
+func (c *Client) RegisterExporter(name string, e Exporter) {
+	if c == nil {
+		return
+	}
+	if e == nil {
+		delete(c.exporters, name)
+		return
+	}
+	if c.exporters == nil {
+		c.exporters = make(map[string]Exporter)
+	}
+	c.exporters[name] = e
+}
+
+// SetRouter sets the router that chooses the exporter of each span.  Spans
+// routed to a name with no registered exporter are sent to the default
+// exporter; they are counted in Stats.UnknownRoutes, and an error is
+// reported to the error handler.  A nil router, the default, sends all spans
+// to the default exporter.
+//
+// SetRouter should be called before any spans are created.
+func (c *Client) SetRouter(r Router) {
+	if c != nil {
+		c.router = r
+	}
+}
+
+// exportRouted sends spans to the exporters chosen by the client's router.
+// The spans sent to each exporter keep their relative order.  Each exporter
+// is called even if another fails; the first error is returned.
+func (c *Client) exportRouted(ctx context.Context, spans []*SpanData) error {
+	if c.router == nil {
+		return c.exportTo(ctx, "", c.exporter, spans)
+	}
+	var order []string
+	byName := make(map[string][]*SpanData)
+	for _, s := range spans {
+		name := c.router(s)
+		if _, ok := c.exporters[name]; name != "" && !ok {
+			atomic.AddInt64(&c.stats.unknownRoutes, 1)
+			c.reportError(fmt.Errorf("trace: span %q routed to unknown exporter %q; using the default exporter", s.Name, name))
+			name = ""
+		}
+		if _, ok := byName[name]; !ok {
+			order = append(order, name)
+		}
+		byName[name] = append(byName[name], s)
+	}
+	var firstErr error
+	for _, name := range order {
+		e := c.exporter
+		if name != "" {
+			e = c.exporters[name]
+		}
+		if err := c.exportTo(ctx, name, e, byName[name]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func routeByLabel(s *SpanData) string { return s.Labels["sink"] }
+
+func names(spans []*SpanData) []string {
+	var n []string
+	for _, s := range spans {
+		n = append(n, s.Name)
+	}
+	return n
+}
+
+func TestRouter(t *testing.T) {
+	def, debug, audit := &recordingExporter{}, &recordingExporter{}, &recordingExporter{}
+	c := NewClientWithExporter(def)
+	c.RegisterExporter("debug", debug)
+	c.RegisterExporter("audit", audit)
+	c.SetRouter(routeByLabel)
+
+	root := c.NewSpan("root")
+	root.SetLabel("sink", "debug")
+	for _, sink := range []string{"", "audit", "debug"} {
+		child := root.NewChild("child-" + sink)
+		if sink != "" {
+			child.SetLabel("sink", sink)
+		}
+		child.Finish()
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		desc string
+		e    *recordingExporter
+		want []string
+	}{
+		{"default", def, []string{"child-"}},
+		{"debug", debug, []string{"root", "child-debug"}},
+		{"audit", audit, []string{"child-audit"}},
+	} {
+		got := names(tt.e.spans)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s exporter got spans %v; want %v", tt.desc, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s exporter got spans %v; want %v", tt.desc, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRouterUnknownName(t *testing.T) {
+	def := &recordingExporter{}
+	c := NewClientWithExporter(def)
+	var errs []error
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	c.SetRouter(routeByLabel)
+	c.RegisterExporter("debug", &recordingExporter{})
+	c.RegisterExporter("debug", nil)
+
+	s1, s2 := validSpanData(), validSpanData()
+	s2.SpanID = 2
+	s1.Labels = map[string]string{"sink": "debug"}
+	s2.Labels = map[string]string{"sink": "nowhere"}
+	if err := c.export([]*SpanData{s1, s2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(def.spans); got != 2 {
+		t.Errorf("default exporter got %d spans; want 2", got)
+	}
+	if len(errs) != 2 {
+		t.Errorf("got errors %v; want 2", errs)
+	}
+	if got := c.Stats().UnknownRoutes; got != 2 {
+		t.Errorf("UnknownRoutes = %d; want 2", got)
+	}
+}
+
+type failingExporter struct {
+	recordingExporter
+	err error
+}
+
+func (e *failingExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	e.recordingExporter.ExportSpans(ctx, spans)
+	return e.err
+}
+
+func TestRouterExporterError(t *testing.T) {
+	def := &recordingExporter{}
+	bad := &failingExporter{err: errors.New("export failed")}
+	c := NewClientWithExporter(def)
+	c.RegisterExporter("bad", bad)
+	c.SetRouter(routeByLabel)
+
+	s1, s2 := validSpanData(), validSpanData()
+	s2.SpanID = 2
+	s1.Labels = map[string]string{"sink": "bad"}
+	if err := c.export([]*SpanData{s1, s2}); err != bad.err {
+		t.Errorf("export returned %v; want %v", err, bad.err)
+	}
+	if len(def.spans) != 1 || len(bad.spans) != 1 {
+		t.Errorf("exporters got %d and %d spans; want 1 each", len(def.spans), len(bad.spans))
+	}
+}
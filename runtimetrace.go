@@ -0,0 +1,60 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	rtrace "runtime/trace"
+)
+
+// SetRuntimeTraceTasks makes the client create a runtime/trace task for each
+// traced span, named with the name of the span, while the runtime's
+// execution tracer is enabled, so that `go tool trace` shows the spans as
+// user tasks.  The task of a span starts when the span is created, is a
+// subtask of the task of the span's parent, if it has one, and ends when
+// the span is finished.  The ID of the span's trace is logged in the task,
+// with the category "trace_id".
+//
+// Spans created while the execution tracer isn't running have no task, and
+// cost only a check of whether it is.
+//
+// SetRuntimeTraceTasks should be called before any spans are created.
+func (c *Client) SetRuntimeTraceTasks(enable bool) {
+	if c != nil {
+		c.runtimeTasks = enable
+	}
+}
+
+// startTask starts the runtime/trace task of s, a traced span that was just
+// created, if its client was configured with SetRuntimeTraceTasks and the
+// execution tracer is running.
+func (s *Span) startTask() {
+	if !s.trace.client.runtimeTasks || !rtrace.IsEnabled() {
+		return
+	}
+	ctx := context.Background()
+	if s.parent != nil && s.parent.taskCtx != nil {
+		ctx = s.parent.taskCtx
+	}
+	s.taskCtx, s.task = rtrace.NewTask(ctx, s.span.Name)
+	rtrace.Log(s.taskCtx, "trace_id", s.trace.traceID)
+}
+
+// endTask ends the runtime/trace task of s, if it has one.
+func (s *Span) endTask() {
+	if s.task != nil {
+		s.task.End()
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bytes"
+	rtrace "runtime/trace"
+	"testing"
+)
+
+func TestRuntimeTraceTasks(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetRuntimeTraceTasks(true)
+	if s := tc.NewSpan("/untraced"); s.task != nil {
+		t.Error("span created while the execution tracer is off has a task")
+	}
+
+	var buf bytes.Buffer
+	if err := rtrace.Start(&buf); err != nil {
+		t.Skipf("can't start the execution tracer: %v", err)
+	}
+	root := tc.NewSpan("/root-task")
+	child := root.NewChild("child-task")
+	other := NewClientWithExporter(&recordingExporter{}).NewSpan("/no-task")
+	child.Finish()
+	root.Finish()
+	other.Finish()
+	rtrace.Stop()
+
+	if root.task == nil || child.task == nil {
+		t.Fatal("spans created while the execution tracer is on have no tasks")
+	}
+	if other.task != nil {
+		t.Error("span of a client without SetRuntimeTraceTasks has a task")
+	}
+	for _, s := range []string{"/root-task", "child-task", "trace_id", root.trace.traceID} {
+		if !bytes.Contains(buf.Bytes(), []byte(s)) {
+			t.Errorf("execution trace doesn't contain %q", s)
+		}
+	}
+	if bytes.Contains(buf.Bytes(), []byte("/no-task")) {
+		t.Error("execution trace contains the span of a client without SetRuntimeTraceTasks")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const labelSamplerMs = `trace/sampler_ms`
+
+// SetSamplerLatencyThreshold makes the client time each call of its
+// SamplingPolicy.  If a decision takes longer than threshold, the span it
+// was made for is labelled with the duration of the decision in
+// milliseconds, as "trace/sampler_ms", and the decision is counted in the
+// SlowSamplingDecisions field of Stats.  A threshold of zero, the default,
+// disables the timing.
+func (c *Client) SetSamplerLatencyThreshold(threshold time.Duration) {
+	if c != nil {
+		c.samplerThreshold = threshold
+	}
+}
+
+// SetSamplerTimeout limits the time the client waits for its SamplingPolicy
+// to make a decision.  If the policy hasn't decided after timeout, the span
+// is traced if fallback is true, and not traced otherwise; the late decision
+// is discarded, and the timeout is counted in the SamplingTimeouts field of
+// Stats.  A timeout of zero, the default, waits for every decision.
+//
+// To be able to stop waiting, the client calls the policy in a new goroutine
+// for each decision while a timeout is set, so only set one for policies
+// that may block, such as policies that make remote calls.
+func (c *Client) SetSamplerTimeout(timeout time.Duration, fallback bool) {
+	if c != nil {
+		c.samplerTimeout = timeout
+		c.samplerFallback = fallback
+	}
+}
+
+// sample returns the decision of p for params, and how long it took if it
+// took longer than the client's latency threshold, or zero.
+func (c *Client) sample(p SamplingPolicy, params Parameters) (Decision, time.Duration) {
+	if c.samplerThreshold <= 0 && c.samplerTimeout <= 0 {
+		return p.Sample(params), 0
+	}
+	start := time.Now()
+	var d Decision
+	if c.samplerTimeout > 0 {
+		d = c.sampleWithTimeout(p, params)
+	} else {
+		d = p.Sample(params)
+	}
+	elapsed := time.Since(start)
+	if c.samplerThreshold <= 0 || elapsed <= c.samplerThreshold {
+		return d, 0
+	}
+	atomic.AddInt64(&c.stats.slowSamples, 1)
+	return d, elapsed
+}
+
+func (c *Client) sampleWithTimeout(p SamplingPolicy, params Parameters) Decision {
+	ch := make(chan Decision, 1) // buffered, so that a late decision doesn't block.
+	go func() { ch <- p.Sample(params) }()
+	t := time.NewTimer(c.samplerTimeout)
+	defer t.Stop()
+	select {
+	case d := <-ch:
+		return d
+	case <-t.C:
+		atomic.AddInt64(&c.stats.samplerTimeouts, 1)
+		return Decision{Trace: c.samplerFallback}
+	}
+}
+
+// setSamplerLabel labels s with the duration of a slow sampling decision.
+func setSamplerLabel(s *Span, elapsed time.Duration) {
+	if elapsed > 0 {
+		s.setLabel(labelSamplerMs, strconv.FormatInt(int64(elapsed/time.Millisecond), 10))
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// slowSampler is a SamplingPolicy that traces every request, after sleeping
+// for delay, and then waiting for release to be closed if it is non-nil.
+type slowSampler struct {
+	delay   time.Duration
+	release chan struct{}
+}
+
+func (s slowSampler) Sample(p Parameters) Decision {
+	time.Sleep(s.delay)
+	if s.release != nil {
+		<-s.release
+	}
+	return Decision{Trace: true}
+}
+
+func TestSamplerLatencyThreshold(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplerLatencyThreshold(5 * time.Millisecond)
+
+	tc.SetSamplingPolicy(slowSampler{})
+	if got := tc.NewSpan("/fast").span.Labels[labelSamplerMs]; got != "" {
+		t.Errorf("fast decision: %s = %q; want none", labelSamplerMs, got)
+	}
+
+	tc.SetSamplingPolicy(slowSampler{delay: 20 * time.Millisecond})
+	span := tc.NewSpan("/slow")
+	if ms, err := strconv.Atoi(span.span.Labels[labelSamplerMs]); err != nil || ms < 20 {
+		t.Errorf("slow decision: %s = %q; want at least 20", labelSamplerMs, span.span.Labels[labelSamplerMs])
+	}
+	if got := tc.Stats().SlowSamplingDecisions; got != 1 {
+		t.Errorf("SlowSamplingDecisions = %d; want 1", got)
+	}
+}
+
+func TestSamplerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	for _, fallback := range []bool{true, false} {
+		tc := NewClientWithExporter(&recordingExporter{})
+		tc.SetSamplingPolicy(slowSampler{release: release})
+		tc.SetSamplerTimeout(10*time.Millisecond, fallback)
+		span := tc.NewSpan("/blocked")
+		if span.tracing() != fallback {
+			t.Errorf("fallback=%t: span traced = %t", fallback, span.tracing())
+		}
+		if got := tc.Stats().SamplingTimeouts; got != 1 {
+			t.Errorf("fallback=%t: SamplingTimeouts = %d; want 1", fallback, got)
+		}
+	}
+
+	// Decisions made within the timeout are used.
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSamplingPolicy(slowSampler{})
+	tc.SetSamplerTimeout(time.Minute, false)
+	if span := tc.NewSpan("/fast"); !span.tracing() {
+		t.Error("span isn't traced; want the policy's decision to trace it")
+	}
+	if got := tc.Stats().SamplingTimeouts; got != 0 {
+		t.Errorf("SamplingTimeouts = %d; want 0", got)
+	}
+}
@@ -34,7 +34,9 @@ type SamplingPolicy interface {
 
 // Parameters contains the values passed to a SamplingPolicy's Sample method.
 type Parameters struct {
-	HasTraceHeader bool // whether the incoming request has a valid X-Cloud-Trace-Context header.
+	HasTraceHeader bool   // whether the incoming request has a valid X-Cloud-Trace-Context header.
+	Name           string // the name of the span for the request.
+	TraceID        string // the ID of the trace of the span for the request.
 }
 
 // Decision is the value returned by a call to a SamplingPolicy's Sample method.
@@ -48,6 +50,9 @@ type Decision struct {
 type sampler struct {
 	fraction float64
 	skipped  float64
+	names    map[string]*nameSampling // for WithNameFractions, or nil.
+	byID     bool                     // whether x is drawn from the trace ID, for NewTraceIDRatioSampler.
+	now      func() time.Time
 	*rate.Limiter
 	*rand.Rand
 	sync.Mutex
@@ -55,16 +60,49 @@ type sampler struct {
 
 func (s *sampler) Sample(p Parameters) Decision {
 	s.Lock()
-	x := s.Float64()
-	d := s.sample(p, time.Now(), x)
+	d := s.sample(p, s.now(), s.draw(p))
 	s.Unlock()
 	return d
 }
 
+// draw returns the number in [0, 1) that s compares with its fraction to
+// decide whether to sample the request with parameters p.
+func (s *sampler) draw(p Parameters) float64 {
+	if s.byID {
+		return traceIDFraction(p.TraceID)
+	}
+	return s.Float64()
+}
+
+// Describe implements Describer.
+func (s *sampler) Describe() string {
+	s.Lock()
+	defer s.Unlock()
+	var d string
+	switch {
+	case !s.byID:
+		d = fmt.Sprintf("limited sampler: fraction %v, at most %v qps", s.fraction, float64(s.Limit()))
+	case s.Limit() == rate.Inf:
+		d = fmt.Sprintf("trace ID ratio sampler: fraction %v", s.fraction)
+	default:
+		d = fmt.Sprintf("trace ID ratio sampler: fraction %v, at most %v qps", s.fraction, float64(s.Limit()))
+	}
+	if len(s.names) > 0 {
+		d += fmt.Sprintf(", %d name overrides", len(s.names))
+	}
+	return d
+}
+
 // sample contains the a deterministic, time-independent logic of Sample.
 func (s *sampler) sample(p Parameters, now time.Time, x float64) (d Decision) {
-	d.Sample = x < s.fraction
-	d.Trace = p.HasTraceHeader || d.Sample
+	fraction, skipped := &s.fraction, &s.skipped
+	if n := s.names[p.Name]; n != nil {
+		fraction, skipped = &n.fraction, &n.skipped
+	}
+	d.Sample = x < *fraction
+	// A trace ID ratio sampler ignores the trace header, so that every
+	// service makes the same decision for a trace.
+	d.Trace = (p.HasTraceHeader && !s.byID) || d.Sample
 	if !d.Trace {
 		// We have no reason to trace this request.
 		return Decision{}
@@ -74,28 +112,166 @@ func (s *sampler) sample(p Parameters, now time.Time, x float64) (d Decision) {
 	if s.Limit() < 1e-9 || !s.AllowN(now, 1) {
 		// Rejected by the rate limit.
 		if d.Sample {
-			s.skipped++
+			*skipped++
 		}
 		return Decision{}
 	}
 	if d.Sample {
-		d.Policy, d.Weight = "default", (1.0+s.skipped)/s.fraction
-		s.skipped = 0.0
+		d.Policy, d.Weight = "default", (1.0+*skipped)/(*fraction)
+		if s.byID {
+			d.Policy = "trace-id-ratio"
+		}
+		*skipped = 0.0
 	}
 	return
 }
 
+// nameSampling is the fraction of the spans of one name that a sampler
+// samples, and the number of sampled spans of the name that were skipped
+// because of the rate limit.
+type nameSampling struct {
+	fraction float64
+	skipped  float64
+}
+
+// A SamplerOption configures a sampling policy returned by NewLimitedSampler
+// or NewTraceIDRatioSampler.
+type SamplerOption interface {
+	configureSampler(s *sampler)
+}
+
+type samplerOption func(s *sampler)
+
+func (o samplerOption) configureSampler(s *sampler) { o(s) }
+
+// WithRandSource returns a SamplerOption that makes the sampling policy use
+// src to choose which requests to sample, instead of a randomly seeded
+// source.  With a fixed seed and WithClock, the decisions of the policy are
+// deterministic, which is useful in tests.
+func WithRandSource(src rand.Source) SamplerOption {
+	return samplerOption(func(s *sampler) {
+		s.Rand = rand.New(src)
+	})
+}
+
+// WithClock returns a SamplerOption that makes the sampling policy call now
+// to get the current time when enforcing its qps limit.
+func WithClock(now func() time.Time) SamplerOption {
+	return samplerOption(func(s *sampler) {
+		s.now = now
+	})
+}
+
+// WithNameFractions returns a SamplerOption that makes the sampling policy
+// sample the given fractions of the requests whose spans have the names
+// that are the keys of fractions, instead of the fraction of the policy.
+// The requests of all names share the policy's limit on the number of
+// traces per second.  The fraction of an AdjustableSampler's UpdateLimits
+// doesn't change the fractions of the names.
+func WithNameFractions(fractions map[string]float64) SamplerOption {
+	return samplerOption(func(s *sampler) {
+		s.names = make(map[string]*nameSampling, len(fractions))
+		for name, f := range fractions {
+			s.names[name] = &nameSampling{fraction: f}
+		}
+	})
+}
+
 // NewLimitedSampler returns a sampling policy that randomly samples a given
 // fraction of requests.  It also enforces a limit on the number of traces per
 // second.  It tries to trace every request with a trace header, but will not
 // exceed the qps limit to do it.
-func NewLimitedSampler(fraction, maxqps float64) (SamplingPolicy, error) {
+func NewLimitedSampler(fraction, maxqps float64, opts ...SamplerOption) (SamplingPolicy, error) {
+	s, err := newSampler(fraction, maxqps, false, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewTraceIDRatioSampler returns a sampling policy that samples a given
+// fraction of traces, deciding from a hash of the trace ID alone, so that
+// every service that uses it with the same fraction makes the same decision
+// for a request without any coordination.  The trace header of the request
+// doesn't matter.  Like NewLimitedSampler, it traces at most maxqps of the
+// sampled requests per second; for no limit, use math.Inf(1).
+//
+// The WithRandSource option has no effect on the policy.
+func NewTraceIDRatioSampler(fraction, maxqps float64, opts ...SamplerOption) (SamplingPolicy, error) {
+	s, err := newSampler(fraction, maxqps, true, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newSampler returns a sampler for NewLimitedSampler, or for
+// NewTraceIDRatioSampler if byID is true.
+func newSampler(fraction, maxqps float64, byID bool, opts []SamplerOption) (*sampler, error) {
+	if err := checkLimits(fraction, maxqps); err != nil {
+		return nil, err
+	}
+	s := sampler{
+		byID:     byID,
+		fraction: fraction,
+		now:      time.Now,
+		Limiter:  newLimiter(maxqps),
+	}
+	for _, o := range opts {
+		o.configureSampler(&s)
+	}
+	for name, n := range s.names {
+		if !(n.fraction >= 0) {
+			return nil, fmt.Errorf("invalid fraction %f for %q", n.fraction, name)
+		}
+	}
+	if s.Rand == nil {
+		var seed int64
+		if err := binary.Read(crand.Reader, binary.LittleEndian, &seed); err != nil {
+			seed = time.Now().UnixNano()
+		}
+		s.Rand = rand.New(rand.NewSource(seed))
+	}
+	return &s, nil
+}
+
+// traceIDFraction returns a number in [0, 1) derived from a hash of the
+// trace ID id, ignoring case.  The numbers of random IDs are uniformly
+// distributed.
+func traceIDFraction(id string) float64 {
+	// FNV-1a, followed by the finalizer of SplitMix64 to spread the low
+	// entropy of similar IDs over all the bits.
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(id); i++ {
+		b := id[i]
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return float64(h>>11) / (1 << 53)
+}
+
+// checkLimits returns an error if the limits of a sampler are invalid.
+func checkLimits(fraction, maxqps float64) error {
 	if !(fraction >= 0) {
-		return nil, fmt.Errorf("invalid fraction %f", fraction)
+		return fmt.Errorf("invalid fraction %f", fraction)
 	}
 	if !(maxqps >= 0) {
-		return nil, fmt.Errorf("invalid maxqps %f", maxqps)
+		return fmt.Errorf("invalid maxqps %f", maxqps)
 	}
+	return nil
+}
+
+// newLimiter returns the rate limiter of a sampler that traces at most
+// maxqps requests per second.
+func newLimiter(maxqps float64) *rate.Limiter {
 	// Set a limit on the number of accumulated "tokens", to limit bursts of
 	// traced requests.  Use one more than a second's worth of tokens, or 100,
 	// whichever is smaller.
@@ -104,14 +280,66 @@ func NewLimitedSampler(fraction, maxqps float64) (SamplingPolicy, error) {
 	if maxqps < 99.0 {
 		maxTokens = 1 + int(maxqps)
 	}
-	var seed int64
-	if err := binary.Read(crand.Reader, binary.LittleEndian, &seed); err != nil {
-		seed = time.Now().UnixNano()
+	return rate.NewLimiter(rate.Limit(maxqps), maxTokens)
+}
+
+// An AdjustableSampler is a sampling policy like the ones returned by
+// NewLimitedSampler, whose limits can be changed while it is in use, for
+// example by a configuration push:
+//
+//   sampler, err := trace.NewAdjustableSampler(0.01, 10)
+//   ...
+//   traceClient.SetSamplingPolicy(sampler)
+//   ...
+//   err = sampler.UpdateLimits(0.001, 1) // under load
+type AdjustableSampler struct {
+	s *sampler
+}
+
+// NewAdjustableSampler returns an AdjustableSampler that randomly samples
+// the given fraction of requests, and traces at most maxqps requests per
+// second, like NewLimitedSampler.
+func NewAdjustableSampler(fraction, maxqps float64, opts ...SamplerOption) (*AdjustableSampler, error) {
+	p, err := NewLimitedSampler(fraction, maxqps, opts...)
+	if err != nil {
+		return nil, err
 	}
-	s := sampler{
-		fraction: fraction,
-		Limiter:  rate.NewLimiter(rate.Limit(maxqps), maxTokens),
-		Rand:     rand.New(rand.NewSource(seed)),
+	return &AdjustableSampler{s: p.(*sampler)}, nil
+}
+
+// Sample implements SamplingPolicy.
+func (a *AdjustableSampler) Sample(p Parameters) Decision {
+	return a.s.Sample(p)
+}
+
+// Describe implements Describer.
+func (a *AdjustableSampler) Describe() string {
+	return "adjustable " + a.s.Describe()
+}
+
+// UpdateLimits sets the fraction of requests a samples, and the maximum
+// number of requests it traces per second.  It can be called concurrently
+// with Sample: each decision uses either the old or the new limits.  When
+// maxqps changes, the budget of requests that a has accumulated is reset.
+func (a *AdjustableSampler) UpdateLimits(fraction, maxqps float64) error {
+	if err := checkLimits(fraction, maxqps); err != nil {
+		return err
 	}
-	return &s, nil
+	s := a.s
+	s.Lock()
+	defer s.Unlock()
+	s.fraction = fraction
+	if float64(s.Limit()) != maxqps {
+		s.Limiter = newLimiter(maxqps)
+	}
+	return nil
+}
+
+// Limits returns the fraction of requests a samples, and the maximum number
+// of requests it traces per second.
+func (a *AdjustableSampler) Limits() (fraction, maxqps float64) {
+	s := a.s
+	s.Lock()
+	defer s.Unlock()
+	return s.fraction, float64(s.Limit())
 }
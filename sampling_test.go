@@ -0,0 +1,215 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+	"cloud.google.com/go/trace/tracetest"
+)
+
+func TestLimitedSampler(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		fraction, maxqps float64
+		step             time.Duration // time between requests.
+		requests         int
+		params           func(int) trace.Parameters
+		want, tolerance  float64
+	}{
+		{0, 5, 25 * time.Millisecond, 79, tracetest.NoRemoteParent(), 0, 0},
+		{5, 0, 25 * time.Millisecond, 79, tracetest.NoRemoteParent(), 0, 0},
+		{0.50, 100, 25 * time.Millisecond, 1000, tracetest.NoRemoteParent("/foo", "/bar"), 0.5, 0.05},
+		// With 1 qps and a burst of 2, we will sample twice in second #1, once in the partial second #2.
+		{0.50, 1, 25 * time.Millisecond, 79, tracetest.NoRemoteParent(), 3.0 / 79, 0.5 / 79},
+		// Requests with a trace header are traced, up to the qps limit.
+		{0, 100, 25 * time.Millisecond, 79, tracetest.RemoteParent(), 1, 0},
+		// A burst of 11, then 10 per second for the remaining 999ms.
+		{0, 10, time.Millisecond, 1000, tracetest.RemoteParent(), 20.0 / 1000, 0.5 / 1000},
+		{0.25, 1000, time.Millisecond, 1000, tracetest.MixedRemoteParents(0.5), 0.625, 0.05},
+	} {
+		name := fmt.Sprintf("fraction=%v,maxqps=%v", test.fraction, test.maxqps)
+		t.Run(name, func(t *testing.T) {
+			p, err := trace.NewLimitedSampler(test.fraction, test.maxqps,
+				trace.WithRandSource(rand.NewSource(1)),
+				trace.WithClock(tracetest.SteppingClock(start, test.step)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracetest.CheckSampler(t, p, test.requests, test.want, test.tolerance, test.params)
+		})
+	}
+}
+
+func TestLimitedSamplerSeeds(t *testing.T) {
+	// The fraction of traced requests is close to the sampling fraction
+	// whatever the seed.
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for seed := int64(0); seed < 20; seed++ {
+		p, err := trace.NewLimitedSampler(0.1, 1000,
+			trace.WithRandSource(rand.NewSource(seed)),
+			trace.WithClock(tracetest.SteppingClock(start, time.Millisecond)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracetest.CheckSampler(t, p, 10000, 0.1, 0.01, tracetest.NoRemoteParent())
+	}
+}
+
+func TestAdjustableSampler(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	p, err := trace.NewAdjustableSampler(0.1, 1000,
+		trace.WithRandSource(rand.NewSource(1)),
+		trace.WithClock(tracetest.SteppingClock(start, time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracetest.CheckSampler(t, p, 10000, 0.1, 0.01, tracetest.NoRemoteParent())
+
+	if err := p.UpdateLimits(1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	tracetest.CheckSampler(t, p, 1000, 1, 0, tracetest.NoRemoteParent())
+	if err := p.UpdateLimits(0.001, 1000); err != nil {
+		t.Fatal(err)
+	}
+	tracetest.CheckSampler(t, p, 10000, 0.001, 0.001, tracetest.NoRemoteParent())
+
+	for _, limits := range [][2]float64{{-1, 10}, {0.5, -1}} {
+		if err := p.UpdateLimits(limits[0], limits[1]); err == nil {
+			t.Errorf("UpdateLimits(%v, %v) succeeded; want an error", limits[0], limits[1])
+		}
+	}
+	if fraction, maxqps := p.Limits(); fraction != 0.001 || maxqps != 1000 {
+		t.Errorf("Limits() = %v, %v after invalid updates; want 0.001, 1000", fraction, maxqps)
+	}
+}
+
+func TestNameFractions(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	p, err := trace.NewLimitedSampler(0.1, 1000,
+		trace.WithNameFractions(map[string]float64{"/hot": 0.01, "/rare": 1}),
+		trace.WithRandSource(rand.NewSource(1)),
+		trace.WithClock(tracetest.SteppingClock(start, time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracetest.CheckSampler(t, p, 10000, 0.1, 0.01, tracetest.NoRemoteParent("/other"))
+	tracetest.CheckSampler(t, p, 10000, 0.01, 0.005, tracetest.NoRemoteParent("/hot"))
+	tracetest.CheckSampler(t, p, 1000, 1, 0, tracetest.NoRemoteParent("/rare"))
+
+	if _, err := trace.NewLimitedSampler(0.1, 10, trace.WithNameFractions(map[string]float64{"/bad": -1})); err == nil {
+		t.Error("NewLimitedSampler with a negative name fraction succeeded; want an error")
+	}
+}
+
+func TestTraceIDRatioSampler(t *testing.T) {
+	// randomIDs returns parameters with random trace IDs, like those of new
+	// traces, with or without a trace header.
+	randomIDs := func(header bool) func(int) trace.Parameters {
+		rng := rand.New(rand.NewSource(1))
+		return func(int) trace.Parameters {
+			return trace.Parameters{
+				HasTraceHeader: header,
+				Name:           "/",
+				TraceID:        fmt.Sprintf("%016x%016x", rng.Uint64(), rng.Uint64()),
+			}
+		}
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		fraction, maxqps float64
+		step             time.Duration // time between requests.
+		requests         int
+		params           func(int) trace.Parameters
+		want, tolerance  float64
+	}{
+		{0, math.Inf(1), time.Millisecond, 1000, randomIDs(false), 0, 0},
+		{1, math.Inf(1), time.Millisecond, 1000, randomIDs(true), 1, 0},
+		// Four standard deviations of the fraction of 100k requests.
+		{0.01, math.Inf(1), time.Millisecond, 100000, randomIDs(false), 0.01, 0.0013},
+		{0.25, math.Inf(1), time.Millisecond, 100000, randomIDs(false), 0.25, 0.0055},
+		{0.5, math.Inf(1), time.Millisecond, 100000, tracetest.NoRemoteParent(), 0.5, 0.0064},
+		// The trace header doesn't make a request more likely to be traced.
+		{0.25, math.Inf(1), time.Millisecond, 100000, randomIDs(true), 0.25, 0.0055},
+		// A burst of 11, then 10 per second for the remaining 999ms.
+		{1, 10, time.Millisecond, 1000, randomIDs(false), 20.0 / 1000, 0.5 / 1000},
+	} {
+		name := fmt.Sprintf("fraction=%v,maxqps=%v", test.fraction, test.maxqps)
+		t.Run(name, func(t *testing.T) {
+			p, err := trace.NewTraceIDRatioSampler(test.fraction, test.maxqps,
+				trace.WithClock(tracetest.SteppingClock(start, test.step)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracetest.CheckSampler(t, p, test.requests, test.want, test.tolerance, test.params)
+		})
+	}
+
+	if _, err := trace.NewTraceIDRatioSampler(-1, 1); err == nil {
+		t.Error("NewTraceIDRatioSampler(-1, 1) succeeded; want error")
+	}
+}
+
+func TestTraceIDRatioSamplerConsistency(t *testing.T) {
+	// Two services with their own policies and differently seeded random
+	// sources make the same decision for each trace ID.
+	var policies [2]trace.SamplingPolicy
+	for i := range policies {
+		p, err := trace.NewTraceIDRatioSampler(0.3, math.Inf(1), trace.WithRandSource(rand.NewSource(int64(i))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies[i] = p
+	}
+	rng := rand.New(rand.NewSource(1))
+	headers := make([]string, 1000)
+	for i := range headers {
+		traceID := fmt.Sprintf("%016x%016x", rng.Uint64(), rng.Uint64())
+		p := trace.Parameters{Name: "/foo", TraceID: traceID}
+		q := trace.Parameters{HasTraceHeader: true, Name: "/bar", TraceID: strings.ToUpper(traceID)}
+		if d, e := policies[0].Sample(p), policies[1].Sample(q); d != e {
+			t.Errorf("trace %s: decisions %+v and %+v differ", traceID, d, e)
+		}
+		headers[i] = fmt.Sprintf("%s/%d;o=0", traceID, i+1)
+	}
+
+	// So do clients, for spans from headers one at a time and in a batch.
+	var clients [2]*trace.Client
+	for i := range clients {
+		clients[i], _ = tracetest.NewTestClient()
+		clients[i].SetSamplingPolicy(policies[i])
+	}
+	batch := clients[1].SpansFromHeaders("/consume", headers)
+	traced := 0
+	for i, h := range headers {
+		s := clients[0].SpanFromHeader("/handle", h)
+		if s.Traced() != batch[i].Traced() {
+			t.Errorf("header %s: span traced %t, span in batch traced %t", h, s.Traced(), batch[i].Traced())
+		}
+		if s.Traced() {
+			traced++
+		}
+	}
+	if traced < 200 || traced > 400 {
+		t.Errorf("traced %d spans of %d; want about 300", traced, len(headers))
+	}
+}
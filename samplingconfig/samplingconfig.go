@@ -0,0 +1,269 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package samplingconfig reloads the sampling policy of a *trace.Client
+// from a file or a URL when it changes, so that the sampling of a service
+// can be changed without redeploying it.
+//
+// The policy is described by a Spec, in JSON:
+//
+//   {
+//     "fraction": 0.01,
+//     "max_qps": 10,
+//     "overrides": {"/healthz": 0, "/checkout": 0.5}
+//   }
+//
+// A Watcher reads the spec periodically, and applies it with
+// Client.SetSamplingPolicy when it changes:
+//
+//   w, err := samplingconfig.WatchFile(traceClient, "/etc/trace/sampling.json")
+//   if err != nil {
+//     // The initial spec is missing or invalid.
+//   }
+//   defer w.Stop()
+//
+// An invalid spec is reported to the Watcher's error handler, and the
+// previous policy is kept.
+package samplingconfig // import "cloud.google.com/go/trace/samplingconfig"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/trace"
+)
+
+// DefaultInterval is how often a Watcher reads its spec, unless WithInterval
+// is given.
+const DefaultInterval = 30 * time.Second
+
+// A Spec describes a sampling policy.
+type Spec struct {
+	// Fraction is the fraction of requests without a trace header that are
+	// traced.
+	Fraction float64 `json:"fraction"`
+
+	// MaxQPS is the limit on the number of requests traced per second,
+	// including those with a trace header.
+	MaxQPS float64 `json:"max_qps"`
+
+	// Overrides holds the fractions of the requests whose spans have the
+	// names of its keys, which are traced instead of Fraction of them.
+	// They share the limit of MaxQPS.
+	Overrides map[string]float64 `json:"overrides,omitempty"`
+}
+
+// Parse parses a Spec in JSON.  Unknown fields are errors, so that a
+// misspelled field isn't silently ignored.
+func Parse(b []byte) (Spec, error) {
+	var s Spec
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&s); err != nil {
+		return Spec{}, fmt.Errorf("samplingconfig: parsing spec: %v", err)
+	}
+	if _, err := s.Policy(); err != nil {
+		return Spec{}, err
+	}
+	return s, nil
+}
+
+// Policy returns the sampling policy described by s, made with
+// trace.NewLimitedSampler and opts.
+func (s Spec) Policy(opts ...trace.SamplerOption) (trace.SamplingPolicy, error) {
+	if len(s.Overrides) > 0 {
+		opts = append(opts[:len(opts):len(opts)], trace.WithNameFractions(s.Overrides))
+	}
+	p, err := trace.NewLimitedSampler(s.Fraction, s.MaxQPS, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("samplingconfig: invalid spec: %v", err)
+	}
+	return p, nil
+}
+
+// An Option configures a Watcher.
+type Option interface {
+	configureWatcher(w *Watcher)
+}
+
+type option func(w *Watcher)
+
+func (o option) configureWatcher(w *Watcher) { o(w) }
+
+// WithInterval returns an Option that sets how often a Watcher reads its
+// spec.  The default is DefaultInterval.
+func WithInterval(d time.Duration) Option {
+	return option(func(w *Watcher) {
+		w.interval = d
+	})
+}
+
+// WithErrorHandler returns an Option that makes a Watcher call f with the
+// errors that occur when it reads or applies a spec after the first.  By
+// default, they are logged with package log.
+func WithErrorHandler(f func(error)) Option {
+	return option(func(w *Watcher) {
+		w.onError = f
+	})
+}
+
+// WithHTTPClient returns an Option that makes a Watcher created by WatchURL
+// fetch its spec with hc, instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return option(func(w *Watcher) {
+		w.httpClient = hc
+	})
+}
+
+// WithSamplerOptions returns an Option that makes a Watcher create the
+// policies of its specs with opts.
+func WithSamplerOptions(opts ...trace.SamplerOption) Option {
+	return option(func(w *Watcher) {
+		w.samplerOpts = opts
+	})
+}
+
+// A Watcher applies a spec that it reads periodically to a client.
+type Watcher struct {
+	client      *trace.Client
+	read        func(w *Watcher) ([]byte, error)
+	interval    time.Duration
+	onError     func(error)
+	httpClient  *http.Client
+	samplerOpts []trace.SamplerOption
+
+	mu      sync.Mutex // guards the fields below, and serializes reloads.
+	applied []byte     // the spec of the current policy.
+	spec    Spec
+	bad     []byte // the last invalid spec, reported once.
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{} // closed when run returns.
+}
+
+// WatchFile returns a Watcher that applies the spec in the file at path to
+// tc.  It returns an error, and doesn't watch the file, if the file can't
+// be read, or if its spec is invalid.
+func WatchFile(tc *trace.Client, path string, opts ...Option) (*Watcher, error) {
+	return watch(tc, func(*Watcher) ([]byte, error) { return ioutil.ReadFile(path) }, opts)
+}
+
+// WatchURL returns a Watcher that applies the spec served at url, with a GET
+// request, to tc.  Responses other than 200 OK are errors.  It returns an
+// error, and doesn't watch the URL, if the spec can't be fetched, or is
+// invalid.
+func WatchURL(tc *trace.Client, url string, opts ...Option) (*Watcher, error) {
+	return watch(tc, func(w *Watcher) ([]byte, error) {
+		resp, err := w.httpClient.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}, opts)
+}
+
+// watch returns a Watcher that has applied the spec returned by read, and
+// rereads it periodically.
+func watch(tc *trace.Client, read func(w *Watcher) ([]byte, error), opts []Option) (*Watcher, error) {
+	w := &Watcher{
+		client:     tc,
+		read:       read,
+		interval:   DefaultInterval,
+		httpClient: http.DefaultClient,
+		onError: func(err error) {
+			log.Print(err)
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	for _, o := range opts {
+		o.configureWatcher(w)
+	}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+			if err := w.Reload(); err != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
+
+// Reload reads the spec now, and applies it if it has changed.  It returns
+// an error if the spec can't be read or is invalid, in which case the
+// previous policy is kept.  An invalid spec is reported by Reload only the
+// first time it is read, so that the error handler isn't called for it at
+// each interval.  Call Reload to apply a spec without waiting for the
+// interval, for example on SIGHUP.
+func (w *Watcher) Reload() error {
+	b, err := w.read(w)
+	if err != nil {
+		return fmt.Errorf("samplingconfig: reading spec: %v", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.applied != nil && bytes.Equal(b, w.applied) || w.bad != nil && bytes.Equal(b, w.bad) {
+		return nil
+	}
+	spec, err := Parse(b)
+	var p trace.SamplingPolicy
+	if err == nil {
+		p, err = spec.Policy(w.samplerOpts...)
+	}
+	if err != nil {
+		w.bad = b
+		return err
+	}
+	w.client.SetSamplingPolicy(p)
+	w.applied, w.spec, w.bad = b, spec, nil
+	return nil
+}
+
+// Spec returns the spec of the policy that w applied most recently.
+func (w *Watcher) Spec() Spec {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.spec
+}
+
+// Stop stops watching the spec.  The client keeps the policy that was
+// applied last.
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
@@ -0,0 +1,229 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package samplingconfig
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+	"cloud.google.com/go/trace/tracetest"
+)
+
+func TestParse(t *testing.T) {
+	got, err := Parse([]byte(`{"fraction": 0.25, "max_qps": 10, "overrides": {"/healthz": 0}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Spec{Fraction: 0.25, MaxQPS: 10, Overrides: map[string]float64{"/healthz": 0}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse = %+v; want %+v", got, want)
+	}
+	for _, bad := range []string{
+		`{"fraction": 0.25, "max_qps": 10`,
+		`{"fraction": 0.25, "maxqps": 10}`,
+		`{"fraction": -1, "max_qps": 10}`,
+		`{"fraction": 0.25, "max_qps": -10}`,
+		`{"fraction": 0.25, "max_qps": 10, "overrides": {"/x": -1}}`,
+	} {
+		if s, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse(%s) = %+v; want an error", bad, s)
+		}
+	}
+}
+
+// tracedFraction returns the fraction of n new root spans of tc named name
+// that are traced, created by several goroutines.
+func tracedFraction(tc *trace.Client, name string, n int) float64 {
+	var traced int64
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n/4; i++ {
+				if tc.NewSpan(name).Snapshot().Sampled {
+					atomic.AddInt64(&traced, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return float64(traced) / float64(n/4*4)
+}
+
+// steppingClock returns a clock for the sampling policy that advances a
+// second each time it is read, so that a policy allowing 1e6 qps refills its
+// burst of tokens before every decision, and only its fraction matters.
+func steppingClock() func() time.Time {
+	start := time.Now()
+	var ticks int64
+	return func() time.Time {
+		return start.Add(time.Duration(atomic.AddInt64(&ticks, 1)) * time.Second)
+	}
+}
+
+func writeSpec(t *testing.T, path, spec string) {
+	if err := ioutil.WriteFile(path, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samplingconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sampling.json")
+	writeSpec(t, path, `{"fraction": 0, "max_qps": 1e6}`)
+
+	tc := tracetest.NewClient()
+	var errs []error
+	w, err := WatchFile(tc, path, WithInterval(time.Hour),
+		WithErrorHandler(func(err error) { errs = append(errs, err) }),
+		WithSamplerOptions(trace.WithRandSource(rand.NewSource(1)), trace.WithClock(steppingClock())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	if got := tracedFraction(tc, "/a", 2000); got != 0 {
+		t.Errorf("traced fraction %v with fraction 0; want 0", got)
+	}
+
+	// Swap the policy while spans are being created.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tracedFraction(tc, "/a", 20000)
+	}()
+	writeSpec(t, path, `{"fraction": 1, "max_qps": 1e6, "overrides": {"/b": 0}}`)
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if got := tracedFraction(tc, "/a", 2000); got != 1 {
+		t.Errorf("traced fraction %v with fraction 1; want 1", got)
+	}
+	if got := tracedFraction(tc, "/b", 2000); got != 0 {
+		t.Errorf("traced fraction of /b %v with its override 0; want 0", got)
+	}
+
+	// An invalid spec keeps the previous policy, and is reported once.
+	writeSpec(t, path, `{"fraction": 2, "max_qps": -1}`)
+	if err := w.Reload(); err == nil {
+		t.Error("Reload of an invalid spec succeeded; want an error")
+	}
+	if err := w.Reload(); err != nil {
+		t.Errorf("second Reload of the same invalid spec: %v; want nil", err)
+	}
+	if got := tracedFraction(tc, "/a", 2000); got != 1 {
+		t.Errorf("traced fraction %v after an invalid spec; want the previous 1", got)
+	}
+	if got, want := w.Spec(), (Spec{Fraction: 1, MaxQPS: 1e6, Overrides: map[string]float64{"/b": 0}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Spec() = %+v; want %+v", got, want)
+	}
+
+	os.Remove(path)
+	if err := w.Reload(); err == nil {
+		t.Error("Reload of a missing file succeeded; want an error")
+	}
+	if len(errs) != 0 {
+		t.Errorf("errors reported by Reload calls %v; want none", errs)
+	}
+	if _, err := WatchFile(tc, path); err == nil {
+		t.Error("WatchFile of a missing file succeeded; want an error")
+	}
+}
+
+func TestWatchURL(t *testing.T) {
+	var mu sync.Mutex
+	spec, status := `{"fraction": 0, "max_qps": 1e6}`, http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(spec))
+	}))
+	defer srv.Close()
+	set := func(s string, code int) {
+		mu.Lock()
+		spec, status = s, code
+		mu.Unlock()
+	}
+
+	tc := tracetest.NewClient()
+	errc := make(chan error, 10)
+	w, err := WatchURL(tc, srv.URL, WithInterval(10*time.Millisecond),
+		WithErrorHandler(func(err error) { errc <- err }),
+		WithSamplerOptions(trace.WithClock(steppingClock())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// The watcher applies a new spec at its next interval.
+	set(`{"fraction": 1, "max_qps": 1e6}`, http.StatusOK)
+	for deadline := time.Now().Add(5 * time.Second); w.Spec().Fraction != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the watcher didn't apply the new spec")
+		}
+	}
+	if got := tracedFraction(tc, "/a", 2000); got != 1 {
+		t.Errorf("traced fraction %v with fraction 1; want 1", got)
+	}
+
+	// Errors are reported to the handler, and keep the policy.
+	set("unavailable", http.StatusServiceUnavailable)
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("reported a nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error reported for a failed fetch")
+	}
+	if got := tracedFraction(tc, "/a", 2000); got != 1 {
+		t.Errorf("traced fraction %v after a failed fetch; want the previous 1", got)
+	}
+
+	w.Stop()
+	w.Stop()
+	set(`{"fraction": 0, "max_qps": 1e6}`, http.StatusOK)
+	time.Sleep(50 * time.Millisecond)
+	if got := w.Spec().Fraction; got != 1 {
+		t.Errorf("spec fraction %v after Stop; want 1", got)
+	}
+
+	failing := &http.Client{Transport: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("unreachable")
+	})}
+	if _, err := WatchURL(tc, srv.URL, WithHTTPClient(failing)); err == nil {
+		t.Error("WatchURL with a failing client succeeded; want an error")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
@@ -0,0 +1,109 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// maxScratchEntries is the maximum number of keys stored by Incr and Put for
+// one trace.  Further keys are ignored.
+const maxScratchEntries = 16
+
+// Incr adds delta to the counter key of the trace of the span in ctx.  When
+// the root span of the trace in this process (the span created by
+// SpanFromRequest, SpanFromHeader or NewSpan) finishes, the counter is added
+// to its labels.  This lets code in any layer of a request record facts
+// about it, like the number of database queries, without passing a struct
+// around:
+//
+//   trace.Incr(ctx, "num_db_queries", 1)
+//
+// Incr does nothing if ctx has no span, or the span isn't traced.  It is
+// safe to call concurrently.
+func Incr(ctx context.Context, key string, delta int64) {
+	if t := scratchTrace(ctx); t != nil {
+		t.scratch.update(key, func(v *scratchValue) {
+			if !v.counter {
+				*v = scratchValue{counter: true}
+			}
+			v.n += delta
+		})
+	}
+}
+
+// Put sets key to value in the trace of the span in ctx, replacing any
+// value or counter set by Put or Incr.  Like counters, the value is added
+// to the labels of the root span of the trace in this process when it
+// finishes.
+//
+// Put does nothing if ctx has no span, or the span isn't traced.
+func Put(ctx context.Context, key, value string) {
+	if t := scratchTrace(ctx); t != nil {
+		t.scratch.update(key, func(v *scratchValue) {
+			*v = scratchValue{s: value}
+		})
+	}
+}
+
+// scratchTrace returns the trace of the span in ctx, if it is traced.
+func scratchTrace(ctx context.Context) *trace {
+	s := FromContext(ctx)
+	if s == nil || !s.tracing() {
+		return nil
+	}
+	return s.trace
+}
+
+type scratchValue struct {
+	counter bool
+	n       int64  // if counter
+	s       string // if !counter
+}
+
+// scratch holds the values set by Incr and Put for a trace.
+type scratch struct {
+	mu     sync.Mutex
+	values map[string]scratchValue
+}
+
+func (sc *scratch) update(key string, f func(v *scratchValue)) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	v, ok := sc.values[key]
+	if !ok && len(sc.values) >= maxScratchEntries {
+		return
+	}
+	f(&v)
+	if sc.values == nil {
+		sc.values = make(map[string]scratchValue)
+	}
+	sc.values[key] = v
+}
+
+// flush sets the values of sc as labels of s.
+func (sc *scratch) flush(s *Span) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for k, v := range sc.values {
+		if v.counter {
+			s.SetLabel(k, strconv.FormatInt(v.n, 10))
+		} else {
+			s.SetLabel(k, v.s)
+		}
+	}
+}
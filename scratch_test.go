@@ -0,0 +1,105 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestScratch(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	child := root.NewChild("/child")
+	ctx := NewContext(context.Background(), child)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				Incr(ctx, "num_db_queries", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	Put(ctx, "cache", "miss")
+	Put(ctx, "replaced", "x")
+	Incr(ctx, "replaced", 2)
+	child.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	rootData, childData := e.span("/root"), e.span("/child")
+	for k, want := range map[string]string{"num_db_queries": "300", "cache": "miss", "replaced": "2"} {
+		if got := rootData.Labels[k]; got != want {
+			t.Errorf("root label %s = %q; want %q", k, got, want)
+		}
+		if _, ok := childData.Labels[k]; ok {
+			t.Errorf("child has label %s", k)
+		}
+	}
+}
+
+func TestScratchLimit(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	for i := 0; i < maxScratchEntries+10; i++ {
+		Incr(ctx, fmt.Sprint("key", i), 1)
+	}
+	Incr(ctx, "key0", 1) // existing keys can still be updated.
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for k := range e.spans[0].Labels {
+		if strings.HasPrefix(k, "key") {
+			n++
+		}
+	}
+	if n != maxScratchEntries {
+		t.Errorf("got %d scratch labels; want %d", n, maxScratchEntries)
+	}
+	if got := e.spans[0].Labels["key0"]; got != "2" {
+		t.Errorf("key0 = %q; want %q", got, "2")
+	}
+}
+
+func TestScratchNoSpan(t *testing.T) {
+	ctx := context.Background()
+	Incr(ctx, "n", 1)
+	Put(ctx, "k", "v")
+
+	tc := NewClientWithExporter(&recordingExporter{})
+	p, err := NewLimitedSampler(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.SetSamplingPolicy(p)
+	untraced := tc.NewSpan("/root")
+	ctx = NewContext(ctx, untraced)
+	Incr(ctx, "n", 1)
+	if untraced.trace.scratch.values != nil {
+		t.Errorf("untraced span stored scratch values %v", untraced.trace.scratch.values)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	labelGRPCSendStallMs      = `grpc/send_stall_ms`
+	labelGRPCStalledSends     = `grpc/stalled_sends`
+	defaultSendStallThreshold = 10 * time.Millisecond
+)
+
+type withSendStallDetection time.Duration
+
+// WithSendStallDetection returns an InterceptorOption that makes the stream
+// interceptors, on both the client and the server side, time each SendMsg
+// call, to show where a stream waited for flow control because the other
+// side was slow to receive.  A call that takes threshold or longer is a
+// stalled send: it is annotated on the span of the stream as "send
+// stalled", with its duration in milliseconds as the attribute "ms".  The
+// span is labeled with the total time of the stalled sends in milliseconds,
+// as "grpc/send_stall_ms", and their number, as "grpc/stalled_sends".
+//
+// If threshold is not positive, a send stalls if it takes 10ms or longer.
+func WithSendStallDetection(threshold time.Duration) InterceptorOption {
+	if threshold <= 0 {
+		threshold = defaultSendStallThreshold
+	}
+	return withSendStallDetection(threshold)
+}
+
+func (o withSendStallDetection) configureInterceptor(c *interceptorConfig) {
+	c.sendStallThreshold = time.Duration(o)
+}
+
+// startSend returns the time at which a call to SendMsg starts, or the zero
+// time if sends aren't timed.
+func (c *streamCounts) startSend() time.Time {
+	if c.stallThreshold == 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// sendDone records the end of a call to SendMsg that started at start, as
+// returned by startSend, on span, the span of the stream.
+func (c *streamCounts) sendDone(span *Span, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	d := time.Since(start)
+	if d < c.stallThreshold {
+		return
+	}
+	atomic.AddInt64(&c.stalledSends, 1)
+	atomic.AddInt64(&c.stallNanos, int64(d))
+	span.Annotate("send stalled", "ms", strconv.FormatInt(int64(d/time.Millisecond), 10))
+}
+
+// setStallLabels labels span, the span of the stream, with the stalled
+// sends, if sends are timed.
+func (c *streamCounts) setStallLabels(span *Span) {
+	if c.stallThreshold == 0 {
+		return
+	}
+	ms := atomic.LoadInt64(&c.stallNanos) / int64(time.Millisecond)
+	span.setLabel(labelGRPCSendStallMs, strconv.FormatInt(ms, 10))
+	span.setLabel(labelGRPCStalledSends, strconv.FormatInt(atomic.LoadInt64(&c.stalledSends), 10))
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// slowSendServerStream is a fakeServerStream whose SendMsg takes the next
+// of delays, as if the client were slow to receive.
+type slowSendServerStream struct {
+	*fakeServerStream
+	delays []time.Duration
+}
+
+func (s *slowSendServerStream) SendMsg(m interface{}) error {
+	time.Sleep(s.delays[0])
+	s.delays = s.delays[1:]
+	return nil
+}
+
+func TestServerSendStalls(t *testing.T) {
+	e := &recordingExporter{exported: make(chan struct{}, 1)}
+	tc := NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+	ss := &slowSendServerStream{newTracedStream(0), []time.Duration{50 * time.Millisecond, 0, 50 * time.Millisecond}}
+	GRPCStreamServerInterceptor(tc, WithSendStallDetection(40*time.Millisecond))(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Echo/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		for i := 0; i < 3; i++ {
+			if err := ss.SendMsg(&wrappers.StringValue{Value: "hello"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	<-e.exported
+	got := e.spans[0]
+	if n := got.Labels[labelGRPCStalledSends]; n != "2" {
+		t.Errorf("label %s = %q; want 2", labelGRPCStalledSends, n)
+	}
+	if ms, err := strconv.Atoi(got.Labels[labelGRPCSendStallMs]); err != nil || ms < 100 {
+		t.Errorf("label %s = %q; want at least 100", labelGRPCSendStallMs, got.Labels[labelGRPCSendStallMs])
+	}
+	if len(got.Annotations) != 2 || got.Annotations[0].Message != "send stalled" || got.Annotations[0].Attributes["ms"] == "" {
+		t.Errorf("annotations = %+v; want two stalled sends", got.Annotations)
+	}
+
+	// Without the option, sends aren't timed.
+	tc = NewClientWithExporter(e)
+	tc.bundler.BundleCountThreshold = 1
+	ss = &slowSendServerStream{newTracedStream(0), []time.Duration{50 * time.Millisecond}}
+	GRPCStreamServerInterceptor(tc)(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Echo/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		return ss.SendMsg(&wrappers.StringValue{Value: "hello"})
+	})
+	<-e.exported
+	if got, ok := e.spans[1].Labels[labelGRPCSendStallMs]; ok {
+		t.Errorf("without WithSendStallDetection, label %s = %q; want none", labelGRPCSendStallMs, got)
+	}
+}
+
+// sinkDesc describes a client streaming method whose handler waits until
+// the channel that is its server is closed before receiving any message.
+var sinkDesc = grpc.ServiceDesc{
+	ServiceName: "test.Sink",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Sink",
+		ClientStreams: true,
+		Handler: func(srv interface{}, ss grpc.ServerStream) error {
+			<-srv.(chan struct{})
+			for {
+				if err := ss.RecvMsg(&wrappers.BytesValue{}); err == io.EOF {
+					return ss.SendMsg(&empty.Empty{})
+				} else if err != nil {
+					return err
+				}
+			}
+		},
+	}},
+}
+
+func TestClientSendStalls(t *testing.T) {
+	// With fixed flow control windows, a client that sends more than the
+	// window to a server that doesn't receive blocks until it does.
+	const window = 1 << 16
+	ready := make(chan struct{})
+	srv := grpc.NewServer(grpc.InitialWindowSize(window), grpc.InitialConnWindowSize(window))
+	srv.RegisterService(&sinkDesc, ready)
+	lis := bufconn.Listen(1 << 16)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn := dialEcho(t, lis, option.WithGRPCDialOption(grpc.WithStreamInterceptor(
+		GRPCStreamClientInterceptor(WithSendStallDetection(20*time.Millisecond)))))
+	defer conn.Close()
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	stream, err := conn.NewStream(ctx, &sinkDesc.Streams[0], "/test.Sink/Sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(200*time.Millisecond, func() { close(ready) })
+	m := &wrappers.BytesValue{Value: bytes.Repeat([]byte{'x'}, window/4)}
+	for i := 0; i < 16; i++ {
+		if err := stream.SendMsg(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.RecvMsg(&empty.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	labels := e.span("/test.Sink/Sink").Labels
+	if n, err := strconv.Atoi(labels[labelGRPCStalledSends]); err != nil || n == 0 {
+		t.Errorf("label %s = %q; want a positive number", labelGRPCStalledSends, labels[labelGRPCStalledSends])
+	}
+	if ms, err := strconv.Atoi(labels[labelGRPCSendStallMs]); err != nil || ms < 100 {
+		t.Errorf("label %s = %q; want at least 100", labelGRPCSendStallMs, labels[labelGRPCSendStallMs])
+	}
+}
+
+func TestWithSendStallDetectionDefault(t *testing.T) {
+	c := newInterceptorConfig([]InterceptorOption{WithSendStallDetection(0)})
+	if c.sendStallThreshold != defaultSendStallThreshold {
+		t.Errorf("threshold = %v; want %v", c.sendStallThreshold, defaultSendStallThreshold)
+	}
+}
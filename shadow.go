@@ -0,0 +1,63 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	labelShadow      = `shadow`
+	labelShadowGroup = `shadow/group`
+	labelShadowIndex = `shadow/index`
+)
+
+// NewShadowChildren creates n child spans of s, all named name, for
+// performing one operation n times, for example against a primary backend
+// and shadow backends being evaluated, where only the result of the first,
+// the primary, is used.
+//
+// Every child is labeled with the same generated group ID, as
+// "shadow/group", and with its index, as "shadow/index".  All the children
+// but the first are labeled "shadow" = "true", so that dashboards and
+// latency objectives can exclude them.
+//
+// If s is not traced, the elements of the result are untraced, as for
+// NewChild.
+// If s is nil or n is not positive, NewShadowChildren returns nil.
+func (s *Span) NewShadowChildren(name string, n int) []*Span {
+	if s == nil || n <= 0 {
+		return nil
+	}
+	children := make([]*Span, n)
+	if !s.tracing() {
+		for i := range children {
+			children[i] = s.untracedChild()
+		}
+		return children
+	}
+	group := fmt.Sprintf("%016x", nextSpanID())
+	for i := range children {
+		c := s.NewChild(name)
+		c.setLabel(labelShadowGroup, group)
+		c.setLabel(labelShadowIndex, strconv.Itoa(i))
+		if i > 0 {
+			c.setLabel(labelShadow, "true")
+		}
+		children[i] = c
+	}
+	return children
+}
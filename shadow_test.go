@@ -0,0 +1,88 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestNewShadowChildren(t *testing.T) {
+	var nilSpan *Span
+	if got := nilSpan.NewShadowChildren("op", 2); got != nil {
+		t.Errorf("nil span returned %v; want nil", got)
+	}
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	if got := root.NewShadowChildren("op", 0); got != nil {
+		t.Errorf("NewShadowChildren(0) returned %v; want nil", got)
+	}
+	children := root.NewShadowChildren("backend.Get", 3)
+	if len(children) != 3 {
+		t.Fatalf("got %d children; want 3", len(children))
+	}
+	for _, c := range children {
+		c.Finish()
+	}
+	other := root.NewShadowChildren("backend.Get", 2)
+	for _, c := range other {
+		c.Finish()
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	byID := make(map[uint64]*SpanData)
+	for _, s := range e.spans {
+		byID[s.SpanID] = s
+	}
+	group := byID[children[0].span.SpanId].Labels[labelShadowGroup]
+	if group == "" {
+		t.Fatalf("primary has no %s label", labelShadowGroup)
+	}
+	for i, c := range children {
+		s := byID[c.span.SpanId]
+		if s.Name != "backend.Get" {
+			t.Errorf("child %d is named %q; want backend.Get", i, s.Name)
+		}
+		if got := s.Labels[labelShadowGroup]; got != group {
+			t.Errorf("child %d has group %q; want %q", i, got, group)
+		}
+		if got, want := s.Labels[labelShadowIndex], strconv.Itoa(i); got != want {
+			t.Errorf("child %d has index %q; want %q", i, got, want)
+		}
+		got, ok := s.Labels[labelShadow]
+		if i == 0 && ok {
+			t.Errorf("primary is labeled %s=%q", labelShadow, got)
+		}
+		if i > 0 && got != "true" {
+			t.Errorf("child %d is labeled %s=%q; want true", i, labelShadow, got)
+		}
+	}
+	if got := byID[other[0].span.SpanId].Labels[labelShadowGroup]; got == group {
+		t.Errorf("two groups share the ID %q", got)
+	}
+}
+
+func TestNewShadowChildrenUntraced(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	s := tc.SpanFromHeader("/untraced", "0123456789abcdef0123456789abcdef/1;o=0")
+	children := s.NewShadowChildren("op", 2)
+	if len(children) != 2 || children[0] != s || children[1] != s {
+		t.Errorf("untraced span returned %v; want two copies of the span", children)
+	}
+}
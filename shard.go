@@ -0,0 +1,198 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// shardQueueSize is the number of batches of spans that can wait to be
+	// exported to each shard of a Sharded exporter.
+	shardQueueSize = 16
+
+	// shardRingPoints is the number of points each shard has on the hash
+	// ring.  More points spread the traces more evenly.
+	shardRingPoints = 128
+)
+
+// A Sharded is an Exporter that sends all the spans of each trace to the
+// same one of several exporters, for example the shards of a self-hosted
+// collector.
+//
+// Each shard has its own queue, exported in the background, so that a shard
+// that is slow or down doesn't hold up the others.  Spans dropped because a
+// shard's queue is full, and those a shard fails to export, are only
+// counted in the shard's stats.
+type Sharded struct {
+	shards []*shard
+	ring   []ringPoint // sorted by hash.
+	hash   func(traceID string) int
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+type shard struct {
+	exporter Exporter
+	queue    chan []*SpanData // closed by Close.
+	done     chan struct{}    // closed when the exporting goroutine has stopped.
+
+	exported int64 // accessed atomically
+	dropped  int64 // accessed atomically
+	failed   int64 // accessed atomically
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+// ShardStats contains statistics about one shard of a Sharded exporter.
+type ShardStats struct {
+	// Exported is the number of spans the shard exported.
+	Exported int64
+
+	// Dropped is the number of spans that were not sent to the shard
+	// because its queue was full, or the exporter was closed.
+	Dropped int64
+
+	// Failed is the number of spans that the shard failed to export.
+	Failed int64
+}
+
+// ShardedExporter returns a Sharded exporter that routes each trace to one
+// of shards.  The shard is chosen by consistent hashing: hashFn's result for
+// the trace ID is a position on a ring on which each shard has many points,
+// and the trace goes to the shard of the next point.  Adding a shard to the
+// end of shards, or removing the last one, moves only the traces of about
+// one shard's share of the ring.  A nil hashFn uses the 64-bit FNV-1a hash of
+// the trace ID.  The results of hashFn should be spread over the whole range
+// of int.
+//
+// Call Close to stop the goroutines that export to the shards.
+func ShardedExporter(shards []Exporter, hashFn func(traceID string) int) *Sharded {
+	if hashFn == nil {
+		hashFn = fnvHash
+	}
+	x := &Sharded{hash: hashFn}
+	for i, e := range shards {
+		s := &shard{
+			exporter: e,
+			queue:    make(chan []*SpanData, shardQueueSize),
+			done:     make(chan struct{}),
+		}
+		x.shards = append(x.shards, s)
+		for j := 0; j < shardRingPoints; j++ {
+			p := fnvHash(strconv.Itoa(i) + "/" + strconv.Itoa(j))
+			x.ring = append(x.ring, ringPoint{hash: uint64(p), shard: i})
+		}
+		go s.export()
+	}
+	sort.Slice(x.ring, func(i, j int) bool { return x.ring[i].hash < x.ring[j].hash })
+	return x
+}
+
+func fnvHash(s string) int {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return int(h.Sum64())
+}
+
+// shardFor returns the index of the shard of the trace with the given ID.
+func (x *Sharded) shardFor(traceID string) int {
+	h := uint64(x.hash(traceID))
+	i := sort.Search(len(x.ring), func(i int) bool { return x.ring[i].hash >= h })
+	if i == len(x.ring) {
+		i = 0
+	}
+	return x.ring[i].shard
+}
+
+// ExportSpans queues spans for their shards, and returns without waiting for
+// them to be exported.  It returns nil even if spans were dropped.
+func (x *Sharded) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	if len(x.shards) == 0 {
+		return nil
+	}
+	byShard := make(map[int][]*SpanData)
+	for _, s := range spans {
+		i := x.shardFor(s.TraceID)
+		byShard[i] = append(byShard[i], s)
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for i, spans := range byShard {
+		s := x.shards[i]
+		queued := false
+		if !x.closed {
+			select {
+			case s.queue <- spans:
+				queued = true
+			default:
+			}
+		}
+		if !queued {
+			atomic.AddInt64(&s.dropped, int64(len(spans)))
+		}
+	}
+	return nil
+}
+
+func (s *shard) export() {
+	defer close(s.done)
+	for spans := range s.queue {
+		if err := s.exporter.ExportSpans(context.Background(), spans); err != nil {
+			atomic.AddInt64(&s.failed, int64(len(spans)))
+		} else {
+			atomic.AddInt64(&s.exported, int64(len(spans)))
+		}
+	}
+}
+
+// Stats returns a snapshot of the statistics of each shard, in the order of
+// the shards passed to ShardedExporter.
+func (x *Sharded) Stats() []ShardStats {
+	stats := make([]ShardStats, len(x.shards))
+	for i, s := range x.shards {
+		stats[i] = ShardStats{
+			Exported: atomic.LoadInt64(&s.exported),
+			Dropped:  atomic.LoadInt64(&s.dropped),
+			Failed:   atomic.LoadInt64(&s.failed),
+		}
+	}
+	return stats
+}
+
+// Close waits for the queued spans to be exported to their shards, and stops
+// the goroutines that export them.  Spans exported after Close are dropped.
+func (x *Sharded) Close() {
+	x.mu.Lock()
+	if !x.closed {
+		x.closed = true
+		for _, s := range x.shards {
+			close(s.queue)
+		}
+	}
+	x.mu.Unlock()
+	for _, s := range x.shards {
+		<-s.done
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func shardTraceID(i int) string {
+	return fmt.Sprintf("%032x", uint64(i)*0x9e3779b97f4a7c15)
+}
+
+func TestShardedRouting(t *testing.T) {
+	shards := make([]Exporter, 4)
+	recorders := make([]*recordingExporter, len(shards))
+	for i := range shards {
+		recorders[i] = &recordingExporter{}
+		shards[i] = recorders[i]
+	}
+	x := ShardedExporter(shards, nil)
+	const traces = 400
+	for round := 0; round < 2; round++ {
+		var spans []*SpanData
+		for i := 0; i < traces; i++ {
+			spans = append(spans,
+				&SpanData{TraceID: shardTraceID(i), SpanID: 1},
+				&SpanData{TraceID: shardTraceID(i), SpanID: 2})
+		}
+		x.ExportSpans(context.Background(), spans)
+	}
+	x.Close()
+
+	seen := make(map[string]int) // trace ID to shard.
+	for i, r := range recorders {
+		if len(r.spans) == 0 {
+			t.Errorf("shard %d got no spans", i)
+		}
+		for _, s := range r.spans {
+			if j, ok := seen[s.TraceID]; ok && j != i {
+				t.Errorf("trace %s went to shards %d and %d", s.TraceID, j, i)
+			}
+			seen[s.TraceID] = i
+		}
+	}
+	if len(seen) != traces {
+		t.Errorf("got %d traces; want %d", len(seen), traces)
+	}
+	for i, st := range x.Stats() {
+		if got, want := st.Exported, int64(len(recorders[i].spans)); got != want {
+			t.Errorf("shard %d: Exported = %d; want %d", i, got, want)
+		}
+	}
+}
+
+func TestShardedRebalancing(t *testing.T) {
+	const traces = 10000
+	assign := func(n int) []int {
+		x := ShardedExporter(make([]Exporter, n), nil)
+		defer x.Close()
+		a := make([]int, traces)
+		for i := range a {
+			a[i] = x.shardFor(shardTraceID(i))
+		}
+		return a
+	}
+	four, five := assign(4), assign(5)
+	moved := 0
+	for i := range four {
+		if four[i] != five[i] {
+			moved++
+			if five[i] != 4 {
+				t.Fatalf("trace %d moved from shard %d to old shard %d", i, four[i], five[i])
+			}
+		}
+	}
+	// The new shard should take about a fifth of the traces.
+	if frac := float64(moved) / traces; frac < 0.1 || frac > 0.3 {
+		t.Errorf("adding a fifth shard moved %.3f of the traces; want about 0.2", frac)
+	}
+}
+
+func TestShardedIsolation(t *testing.T) {
+	blocked := &blockingExporter{started: make(chan struct{}, shardQueueSize+1), release: make(chan struct{})}
+	failing := &failingExporter{err: errors.New("shard down")}
+	healthy := &recordingExporter{exported: make(chan struct{}, 100)}
+
+	x := ShardedExporter([]Exporter{blocked, failing, healthy}, nil)
+	var blockedID, failingID, healthyID string
+	for i := 0; blockedID == "" || failingID == "" || healthyID == ""; i++ {
+		id := shardTraceID(i)
+		switch x.shardFor(id) {
+		case 0:
+			blockedID = id
+		case 1:
+			failingID = id
+		case 2:
+			healthyID = id
+		}
+	}
+
+	// Block the shard on one batch, then fill its queue, and one more.
+	x.ExportSpans(context.Background(), []*SpanData{{TraceID: blockedID, SpanID: 1}})
+	<-blocked.started
+	for i := 0; i < shardQueueSize+1; i++ {
+		x.ExportSpans(context.Background(), []*SpanData{{TraceID: blockedID, SpanID: 1}})
+	}
+	x.ExportSpans(context.Background(), []*SpanData{{TraceID: failingID, SpanID: 1}})
+	x.ExportSpans(context.Background(), []*SpanData{{TraceID: healthyID, SpanID: 1}})
+	select {
+	case <-healthy.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the healthy shard was held up by the blocked one")
+	}
+
+	close(blocked.release)
+	x.Close()
+	stats := x.Stats()
+	// One batch was being exported and shardQueueSize were queued, so the
+	// last was dropped.
+	if got, want := stats[0], (ShardStats{Exported: shardQueueSize + 1, Dropped: 1}); got != want {
+		t.Errorf("blocked shard stats = %+v; want %+v", got, want)
+	}
+	if got, want := stats[1], (ShardStats{Failed: 1}); got != want {
+		t.Errorf("failing shard stats = %+v; want %+v", got, want)
+	}
+	if got, want := stats[2], (ShardStats{Exported: 1}); got != want {
+		t.Errorf("healthy shard stats = %+v; want %+v", got, want)
+	}
+}
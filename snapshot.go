@@ -0,0 +1,78 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "time"
+
+// A Snapshot is a copy of the state of a span at some point in its life.
+// Changes to the span do not affect it.
+type Snapshot struct {
+	// Valid is false for the Snapshot of a nil span; the other fields are
+	// then zero.
+	Valid bool
+
+	Name         string
+	TraceID      string
+	SpanID       uint64
+	ParentSpanID uint64
+	Start        time.Time
+	Elapsed      time.Duration // the span's duration, if it has finished.
+	Sampled      bool          // whether the span will be uploaded.
+
+	// Labels holds the values of the labels requested from Span.Snapshot
+	// that are set on the span.  It is nil if there are none.
+	Labels map[string]string
+}
+
+// Snapshot returns a Snapshot of s, including the labels with the given
+// keys.  It is cheap enough to call for each request, for example to add
+// span details to the access log of a request before the span finishes:
+//
+//   snap := span.Snapshot("user")
+//   log.Printf("%s trace=%s elapsed=%v user=%s", snap.Name, snap.TraceID, snap.Elapsed, snap.Labels["user"])
+//
+// If the request is not being traced, child spans are the same *Span as their
+// parent, so Snapshot returns the state of the untraced root span.
+func (s *Span) Snapshot(labelKeys ...string) Snapshot {
+	if s == nil {
+		return Snapshot{}
+	}
+	snap := Snapshot{
+		Valid:        true,
+		TraceID:      s.trace.traceID,
+		SpanID:       s.span.SpanId,
+		ParentSpanID: s.span.ParentSpanId,
+		Sampled:      s.tracing(),
+	}
+	s.spanMu.Lock()
+	snap.Name = s.span.Name
+	snap.Start = s.start
+	end := s.end
+	for _, k := range labelKeys {
+		if v, ok := s.span.Labels[k]; ok {
+			if snap.Labels == nil {
+				snap.Labels = make(map[string]string, len(labelKeys))
+			}
+			snap.Labels[k] = v
+		}
+	}
+	s.spanMu.Unlock()
+	if end.IsZero() {
+		snap.Elapsed = time.Since(snap.Start)
+	} else {
+		snap.Elapsed = end.Sub(snap.Start)
+	}
+	return snap
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	span := root.NewChild("/child")
+	span.SetLabel("user", "alice")
+	span.SetLabel("other", "x")
+
+	snap := span.Snapshot("user", "missing")
+	span.SetLabel("user", "bob")
+	span.Finish()
+
+	if !snap.Valid || !snap.Sampled {
+		t.Errorf("Valid, Sampled = %t, %t; want true, true", snap.Valid, snap.Sampled)
+	}
+	if snap.Name != "/child" || snap.TraceID != root.TraceID() {
+		t.Errorf("name, trace ID = %q, %q; want %q, %q", snap.Name, snap.TraceID, "/child", root.TraceID())
+	}
+	if snap.SpanID != span.span.SpanId || snap.ParentSpanID != root.span.SpanId {
+		t.Errorf("span ID, parent = %d, %d; want %d, %d", snap.SpanID, snap.ParentSpanID, span.span.SpanId, root.span.SpanId)
+	}
+	if !snap.Start.Equal(span.start) || snap.Elapsed <= 0 {
+		t.Errorf("start, elapsed = %v, %v", snap.Start, snap.Elapsed)
+	}
+	if want := map[string]string{"user": "alice"}; !reflect.DeepEqual(snap.Labels, want) {
+		t.Errorf("labels = %v; want %v", snap.Labels, want)
+	}
+
+	finished := span.Snapshot()
+	if finished.Labels != nil {
+		t.Errorf("labels = %v; want nil", finished.Labels)
+	}
+	if got, want := finished.Elapsed, span.Elapsed(); got != want {
+		t.Errorf("elapsed after finish = %v; want %v", got, want)
+	}
+}
+
+func TestSnapshotNil(t *testing.T) {
+	var s *Span
+	if got := s.Snapshot("user"); !reflect.DeepEqual(got, Snapshot{}) {
+		t.Errorf("Snapshot of nil span = %+v; want zero Snapshot", got)
+	}
+}
+
+func TestSnapshotConcurrentSetLabel(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/root")
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			span.SetLabel("n", fmt.Sprint(i))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			span.Snapshot("n")
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,159 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// SpanContextSize is the size of a span context encoded by
+// MarshalSpanContext.
+const SpanContextSize = 25
+
+// spanContextVersion is the version of the encoding of span contexts.
+const spanContextVersion = 0
+
+// spanContextOptions are the options that fit in an encoded span context.
+const spanContextOptions optionFlags = 0x0f
+
+// Errors returned by UnmarshalSpanContext.
+var (
+	ErrSpanContextSize    = errors.New("trace: encoded span context has the wrong size")
+	ErrSpanContextVersion = errors.New("trace: encoded span context has an unknown version")
+)
+
+// A SpanContext identifies a span to propagate to a child request, like a
+// trace header, for protocols that carry it as binary data.
+type SpanContext struct {
+	TraceID string // 32 hex digits.
+	SpanID  uint64
+	Traced  bool // whether child requests are traced, like o=1 in a header.
+
+	// The options of the Google trace header other than tracing, and the
+	// options it has that this package doesn't understand, so that
+	// GooglePropagator sends the same header as Span.Header.
+	options optionFlags
+	extra   string
+}
+
+// Sampled reports whether child requests are traced, like Traced.
+func (sc SpanContext) Sampled() bool {
+	return sc.Traced
+}
+
+// StackTraceRequested reports whether the trace header that sc propagates
+// requests stack traces, with bit 1 of its "o=" option, as in o=3.  Traced
+// spans created from such a header are labeled with the stack trace of
+// their creation.
+func (sc SpanContext) StackTraceRequested() bool {
+	return sc.options&optionStack != 0
+}
+
+// Options returns the value of the "o=" option of the trace header that sc
+// propagates, including the bits that this package doesn't understand,
+// which are sent to child requests unchanged.
+func (sc SpanContext) Options() uint32 {
+	return uint32(sc.flags())
+}
+
+// flags returns the options of the Google trace header for sc.
+func (sc SpanContext) flags() optionFlags {
+	o := sc.options &^ optionTrace
+	if sc.Traced {
+		o |= optionTrace
+	}
+	return o
+}
+
+// SpanContext returns the SpanContext that propagates s, like Header.  It
+// returns the zero SpanContext if s is nil.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.spanContext(s.span.SpanId)
+}
+
+// spanContext returns the SpanContext of s, for a child request whose parent
+// span ID is spanID.
+func (s *Span) spanContext(spanID uint64) SpanContext {
+	global := s.loadOptions().global
+	return SpanContext{
+		TraceID: s.trace.traceID,
+		SpanID:  spanID,
+		Traced:  global&optionTrace != 0,
+		options: global,
+		extra:   s.trace.extraOptions,
+	}
+}
+
+// SpanFromSpanContext returns a new span named name, like SpanFromHeader,
+// for a request that propagated sc.
+func (c *Client) SpanFromSpanContext(name string, sc SpanContext) *Span {
+	var err error
+	if !validTraceID(sc.TraceID) {
+		err = &HeaderError{Err: ErrMalformedTraceID, Detail: fmt.Sprintf("%q", sc.TraceID)}
+	}
+	return c.spanFromContextErr(name, sc, err)
+}
+
+// MarshalSpanContext returns the encoding of sc in SpanContextSize bytes,
+// for a protocol that sends it before its own data, for example at
+// connection setup:
+//
+//   conn, err := dialer.DialContext(ctx, "tcp", addr)
+//   ...
+//   _, err = conn.Write(trace.MarshalSpanContext(trace.FromContext(ctx).SpanContext()))
+//
+// The first byte holds the version of the encoding in its high four bits,
+// and the low four bits of the options of the trace, as returned by Options,
+// in the others; it is followed by the 16
+// bytes of the trace ID and the 8 bytes of the span ID, in big-endian
+// order.  A trace ID that isn't 32 hex digits is encoded as zero bytes.
+func MarshalSpanContext(sc SpanContext) []byte {
+	b := make([]byte, SpanContextSize)
+	b[0] = spanContextVersion<<4 | byte(sc.flags()&spanContextOptions)
+	if id, err := hex.DecodeString(sc.TraceID); err == nil && len(id) == 16 {
+		copy(b[1:17], id)
+	}
+	binary.BigEndian.PutUint64(b[17:], sc.SpanID)
+	return b
+}
+
+// UnmarshalSpanContext decodes a span context encoded by MarshalSpanContext.
+// It returns ErrSpanContextSize if b is not SpanContextSize bytes,
+// ErrSpanContextVersion if b was encoded by a later version of this
+// package, and ErrMalformedTraceID if its trace ID is zero.
+func UnmarshalSpanContext(b []byte) (SpanContext, error) {
+	if len(b) != SpanContextSize {
+		return SpanContext{}, ErrSpanContextSize
+	}
+	if b[0]>>4 != spanContextVersion {
+		return SpanContext{}, ErrSpanContextVersion
+	}
+	sc := SpanContext{
+		TraceID: hex.EncodeToString(b[1:17]),
+		SpanID:  binary.BigEndian.Uint64(b[17:]),
+		Traced:  b[0]&byte(optionTrace) != 0,
+		options: optionFlags(b[0]) & spanContextOptions &^ optionTrace,
+	}
+	if !validTraceID(sc.TraceID) {
+		return SpanContext{}, ErrMalformedTraceID
+	}
+	return sc, nil
+}
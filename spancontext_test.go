@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpanContextRoundTrip(t *testing.T) {
+	for _, sc := range []SpanContext{
+		{TraceID: validationTraceID, SpanID: 1, Traced: true},
+		{TraceID: validationTraceID, SpanID: 1<<64 - 1},
+	} {
+		b := MarshalSpanContext(sc)
+		if len(b) != SpanContextSize {
+			t.Errorf("MarshalSpanContext(%v) is %d bytes; want %d", sc, len(b), SpanContextSize)
+		}
+		got, err := UnmarshalSpanContext(b)
+		if err != nil {
+			t.Errorf("UnmarshalSpanContext(MarshalSpanContext(%v)): %v", sc, err)
+		} else if !reflect.DeepEqual(got, sc) {
+			t.Errorf("UnmarshalSpanContext(MarshalSpanContext(%v)) = %v", sc, got)
+		}
+	}
+}
+
+func TestUnmarshalSpanContextErrors(t *testing.T) {
+	valid := MarshalSpanContext(SpanContext{TraceID: validationTraceID, SpanID: 1, Traced: true})
+	future := append([]byte(nil), valid...)
+	future[0] |= 1 << 4
+	for _, tt := range []struct {
+		desc string
+		b    []byte
+		want error
+	}{
+		{"empty", nil, ErrSpanContextSize},
+		{"truncated", valid[:SpanContextSize-1], ErrSpanContextSize},
+		{"too long", append(append([]byte(nil), valid...), 0), ErrSpanContextSize},
+		{"wrong version", future, ErrSpanContextVersion},
+		{"zero trace ID", MarshalSpanContext(SpanContext{TraceID: "not hex", SpanID: 1}), ErrMalformedTraceID},
+	} {
+		if _, err := UnmarshalSpanContext(tt.b); err != tt.want {
+			t.Errorf("%s: UnmarshalSpanContext error = %v; want %v", tt.desc, err, tt.want)
+		}
+	}
+}
+
+func TestSpanFromSpanContext(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	parent := tc.NewSpan("/parent")
+	b := MarshalSpanContext(parent.SpanContext())
+	sc, err := UnmarshalSpanContext(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := tc.SpanFromSpanContext("/child", sc)
+	if got, want := span.TraceID(), parent.TraceID(); got != want {
+		t.Errorf("TraceID = %q; want %q", got, want)
+	}
+	if got, want := span.span.ParentSpanId, parent.span.SpanId; got != want {
+		t.Errorf("parent span ID = %d; want %d", got, want)
+	}
+	if !span.traced() {
+		t.Errorf("span from a traced span context is not traced")
+	}
+	if got := (*Span)(nil).SpanContext(); got != (SpanContext{}) {
+		t.Errorf("SpanContext of nil span = %v; want zero", got)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+
+	"cloud.google.com/go/trace"
+)
+
+// This example sends the span context of a connection before the data of a
+// binary protocol, and reads it on the other side.
+func ExampleMarshalSpanContext() {
+	dialer := &net.Dialer{}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		sc := trace.FromContext(ctx).SpanContext()
+		if _, err := conn.Write(trace.MarshalSpanContext(sc)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	_ = dial
+
+	serve := func(conn net.Conn) {
+		b := make([]byte, trace.SpanContextSize)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			log.Print(err)
+			return
+		}
+		var span *trace.Span
+		if sc, err := trace.UnmarshalSpanContext(b); err == nil {
+			span = traceClient.SpanFromSpanContext("conn", sc) // traceClient is a *Client
+		} else {
+			span = traceClient.NewSpan("conn")
+		}
+		defer span.Finish()
+		// ... serve conn with the span in the context.
+	}
+	_ = serve
+}
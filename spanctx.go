@@ -0,0 +1,33 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "context"
+
+// A spanValueContext is a context containing a span.  NewContext returns
+// one instead of calling context.WithValue, so that FromContext finds the
+// span of a context made by NewContext without calling Value, or
+// converting the span to an interface and back.
+type spanValueContext struct {
+	context.Context
+	span *Span
+}
+
+func (c *spanValueContext) Value(key interface{}) interface{} {
+	if key == (contextKey{}) {
+		return c.span
+	}
+	return c.Context.Value(key)
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+// WithSpanHook returns an InterceptorOption that calls hook with the span
+// of each traced call, and the call's full method name, once the
+// interceptor has created and labeled the span.  On the server side, hook
+// is called before the handler; on the client side, before the call is
+// sent.  It is useful for setting labels that all calls should have:
+//
+//   trace.WithSpanHook(func(span *trace.Span, method string) {
+//       span.SetLabel("region", region)
+//   })
+//
+// hook isn't called for calls that aren't traced.  It may be called
+// concurrently.  The option applies to both the client and the server
+// interceptors.
+func WithSpanHook(hook func(span *Span, method string)) InterceptorOption {
+	return withSpanHook{hook}
+}
+
+type withSpanHook struct {
+	hook func(span *Span, method string)
+}
+
+func (o withSpanHook) configureInterceptor(c *interceptorConfig) {
+	c.spanHook = o.hook
+}
+
+// runSpanHook calls the hook of WithSpanHook, if there is one and span is
+// traced.
+func (c *interceptorConfig) runSpanHook(span *Span, method string) {
+	if c.spanHook != nil && span.traced() {
+		c.spanHook(span, method)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWithSpanHook(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	var calls []string
+	hook := WithSpanHook(func(span *Span, method string) {
+		if !span.traced() {
+			t.Errorf("hook called for untraced call of %s", method)
+		}
+		span.SetLabel("hooked", method)
+		calls = append(calls, method)
+	})
+
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	var spans []*Span
+	GRPCServerInterceptor(tc, hook)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Unary"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		spans = append(spans, FromContext(ctx))
+		return nil, nil
+	})
+	GRPCStreamServerInterceptor(tc, hook)(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		spans = append(spans, FromContext(ss.Context()))
+		return nil
+	})
+
+	root := tc.NewSpan("/root")
+	out := NewContext(context.Background(), root)
+	GRPCClientInterceptor(hook)(out, "/test.Service/ClientUnary", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	})
+	cs, err := GRPCStreamClientInterceptor(hook)(out, &grpc.StreamDesc{}, nil, "/test.Service/ClientStream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeClientStream{ctx: ctx}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans = append(spans, cs.(*ClientStreamWrapper).span)
+	want := []string{"/test.Service/Unary", "/test.Service/Stream", "/test.Service/ClientUnary", "/test.Service/ClientStream"}
+	if got := storedLabel(spans[2], "hooked"); got != want[3] {
+		t.Errorf("span of %s has label %q; want %q", want[3], got, want[3])
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("hook called for %q; want %q", calls, want)
+	}
+	for i, m := range want {
+		if calls[i] != m {
+			t.Errorf("hook call %d for %q; want %q", i, calls[i], m)
+		}
+	}
+	for i, s := range spans[:2] {
+		if got := storedLabel(s, "hooked"); got != want[i] {
+			t.Errorf("span of %s has label %q; want %q", want[i], got, want[i])
+		}
+	}
+
+	// The hook isn't called for untraced calls.
+	calls = nil
+	untraced := metadata.NewIncomingContext(context.Background(), metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=0"))
+	GRPCServerInterceptor(tc, hook)(untraced, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Unary"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if len(calls) != 0 {
+		t.Errorf("hook called for untraced calls %q", calls)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "fmt"
+
+// A SpanOption configures a span created by Span.NewChild or
+// Client.NewSpanWithOptions.
+type SpanOption interface {
+	configureSpan(c *spanConfig)
+}
+
+type spanConfig struct {
+	spanID         uint64
+	hasSpanID      bool
+	maxAnnotations int
+	bufferedWrites bool
+	kind           SpanKind
+
+	checkpointSpans bool
+	forceTrace      bool
+}
+
+type spanOption func(c *spanConfig)
+
+func (o spanOption) configureSpan(c *spanConfig) { o(c) }
+
+// WithSpanID returns a SpanOption that gives the new span the ID id, instead
+// of a random one.  This is useful when reconstructing traces recorded by
+// other systems, whose references to span IDs must be kept.
+//
+// The ID is ignored, and a random ID is used, if it is zero or is already
+// used by another span of the trace created in this process; if strict
+// validation is enabled, a *ValidationError is reported to the error handler.
+func WithSpanID(id uint64) SpanOption {
+	return spanOption(func(c *spanConfig) {
+		c.spanID = id
+		c.hasSpanID = true
+	})
+}
+
+// WithSpanKind returns a SpanOption that gives the new span the kind k, for
+// spans of remote calls made or handled without the interceptors or the
+// HTTP functions of this package, which set the kind of the spans they
+// create.  Spans are otherwise of kind SpanKindUnspecified.
+func WithSpanKind(k SpanKind) SpanOption {
+	return spanOption(func(c *spanConfig) {
+		c.kind = k
+	})
+}
+
+// applyOptions applies opts to the new span s.
+func (s *Span) applyOptions(opts []SpanOption) {
+	if len(opts) == 0 {
+		return
+	}
+	var cfg spanConfig
+	for _, o := range opts {
+		o.configureSpan(&cfg)
+	}
+	if cfg.hasSpanID {
+		s.setSpanID(cfg.spanID)
+	}
+	if cfg.kind != SpanKindUnspecified {
+		s.span.Kind = cfg.kind.apiKind()
+	}
+	if cfg.maxAnnotations > 0 {
+		s.annotations.max = cfg.maxAnnotations
+	}
+	if cfg.bufferedWrites {
+		s.writes = new(writeBuffer)
+	}
+	s.checkpointSpans = cfg.checkpointSpans
+	if cfg.forceTrace && s.rootSpan {
+		s.trace.forced = true
+	}
+}
+
+// setSpanID gives s the ID id, if it is valid for the trace.
+func (s *Span) setSpanID(id uint64) {
+	t := s.trace
+	var problem string
+	t.mu.Lock()
+	switch {
+	case id == 0:
+		problem = "explicit span ID is zero"
+	case t.explicitIDs[id] || s.hasAncestorID(id):
+		problem = fmt.Sprintf("explicit span ID %d is already used in the trace", id)
+	default:
+		if t.explicitIDs == nil {
+			t.explicitIDs = make(map[uint64]bool)
+		}
+		t.explicitIDs[id] = true
+		s.span.SpanId = id
+	}
+	t.mu.Unlock()
+	if problem != "" && t.client.strict {
+		t.client.reportError(&ValidationError{
+			TraceID:  t.traceID,
+			SpanName: s.span.Name,
+			Problem:  problem,
+			Fixed:    true,
+		})
+	}
+}
+
+// hasAncestorID reports whether id is the ID of s's parent, or of one of its
+// ancestors.
+func (s *Span) hasAncestorID(id uint64) bool {
+	if id == s.span.ParentSpanId {
+		return true
+	}
+	for p := s.parent; p != nil; p = p.parent {
+		if p.span.SpanId == id {
+			return true
+		}
+	}
+	return false
+}
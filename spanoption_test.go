@@ -0,0 +1,107 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "testing"
+
+func TestWithSpanID(t *testing.T) {
+	e := &recordingExporter{}
+	c := NewClientWithExporter(e)
+
+	// Reconstruct a trace recorded elsewhere: root 100, with child 200,
+	// which has child 300.
+	root := c.NewSpanWithOptions("root", WithSpanID(100))
+	child := root.NewChild("child", WithSpanID(200))
+	grandchild := child.NewChild("grandchild", WithSpanID(300))
+	grandchild.Finish()
+	child.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][2]uint64{ // name: {span ID, parent span ID}
+		"root":       {100, 0},
+		"child":      {200, 100},
+		"grandchild": {300, 200},
+	}
+	if len(e.spans) != len(want) {
+		t.Fatalf("got %d spans; want %d", len(e.spans), len(want))
+	}
+	for _, s := range e.spans {
+		if got := [2]uint64{s.SpanID, s.ParentSpanID}; got != want[s.Name] {
+			t.Errorf("%s: got span ID and parent %v; want %v", s.Name, got, want[s.Name])
+		}
+	}
+}
+
+func TestWithSpanIDInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		strict bool
+		id     func(root, child *Span) uint64
+	}{
+		{"zero", true, func(root, child *Span) uint64 { return 0 }},
+		{"parent", true, func(root, child *Span) uint64 { return child.span.SpanId }},
+		{"ancestor", true, func(root, child *Span) uint64 { return root.span.SpanId }},
+		{"sibling", true, func(root, child *Span) uint64 { return 7 }},
+		{"not strict", false, func(root, child *Span) uint64 { return 7 }},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			c := NewClientWithExporter(&recordingExporter{})
+			var errs []error
+			c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+			c.SetStrictValidation(tt.strict)
+
+			root := c.NewSpan("root")
+			child := root.NewChild("child")
+			root.NewChild("sibling", WithSpanID(7))
+			id := tt.id(root, child)
+			s := child.NewChild("span", WithSpanID(id))
+			if s.span.SpanId == 0 || s.span.SpanId == id {
+				t.Errorf("span ID = %d; want a new random ID", s.span.SpanId)
+			}
+			wantErrs := 0
+			if tt.strict {
+				wantErrs = 1
+			}
+			if len(errs) != wantErrs {
+				t.Fatalf("got errors %v; want %d", errs, wantErrs)
+			}
+			if wantErrs > 0 && !errs[0].(*ValidationError).Fixed {
+				t.Errorf("got error %v; want a fixed span", errs[0])
+			}
+		})
+	}
+}
+
+func TestWithSpanKind(t *testing.T) {
+	c := NewClientWithExporter(&recordingExporter{})
+	root := c.NewSpan("root")
+	for _, tt := range []struct {
+		desc string
+		span *Span
+		want SpanKind
+	}{
+		{"root", root, SpanKindUnspecified},
+		{"child", root.NewChild("child"), SpanKindUnspecified},
+		{"client child", root.NewChild("client", WithSpanKind(SpanKindClient)), SpanKindClient},
+		{"server root", c.NewSpanWithOptions("server", WithSpanKind(SpanKindServer)), SpanKindServer},
+		{"nil", nil, SpanKindUnspecified},
+	} {
+		if got := tt.span.Kind(); got != tt.want {
+			t.Errorf("%s: Kind() = %v; want %v", tt.desc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// spillSuffix is the suffix of the names of spill files.  Files being
+// written have other names until they are complete.
+const spillSuffix = ".spill"
+
+// SpillStats holds the counts of the bundles handled by the spill directory
+// set with SetSpillDirectory.
+type SpillStats struct {
+	// Spilled is the number of bundles written to the directory.
+	Spilled int64
+
+	// Replayed is the number of bundles read back from the directory and
+	// exported again.
+	Replayed int64
+
+	// Evicted is the number of bundles deleted, oldest first, to keep the
+	// directory within its size limit.
+	Evicted int64
+
+	// Errors is the number of bundles that couldn't be written to the
+	// directory, and of files in it that couldn't be read or decoded and
+	// were skipped.
+	Errors int64
+}
+
+// SetSpillDirectory makes the client save to files in dir the bundles of
+// spans that it can't export: those whose export fails, including during
+// Flush and Close, and the traces that don't fit the client's buffer.  dir
+// is created if needed.  The oldest files are deleted to keep the total
+// size of the files in dir within maxBytes, if it is positive.
+//
+// The files already in dir, for example those saved before a restart by a
+// client with the same spill directory, are exported again in the
+// background, and deleted once they are exported; files whose export fails
+// again are kept for the next client.  Files that can't be read or decoded
+// are deleted and reported to the error handler.  Flush waits for them to be handed to
+// the exporter.  Since the bundles are exported again as they were, spans
+// that an exporter of a Router received before another exporter failed are
+// exported to it twice.
+//
+// SetSpillDirectory should be called after the exporters of the client are
+// configured, and before any spans are created.  Each directory should be
+// used by one client at a time.
+func (c *Client) SetSpillDirectory(dir string, maxBytes int64) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	s := &spiller{client: c, dir: dir, maxBytes: maxBytes}
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	c.spill = s
+	if len(files) > 0 && c.uploads.begin() {
+		go func() {
+			defer c.uploads.end()
+			s.replay(files)
+		}()
+	}
+	return nil
+}
+
+// A spiller saves bundles of spans to a directory, and reads them back.
+type spiller struct {
+	client   *Client
+	dir      string
+	maxBytes int64 // <= 0 for no limit.
+
+	mu  sync.Mutex // serializes writes and evictions.
+	seq int        // for unique file names.
+
+	stats SpillStats // updated atomically.
+}
+
+// A spillFile is the content of a spill file.
+type spillFile struct {
+	// Processed is whether the spans have already been handled by the
+	// client's processors and validation, so that only the export remains.
+	Processed bool
+
+	Spans []*SpanData
+}
+
+// write saves spans to a new file.  processed is whether the spans have
+// already been prepared for export.
+func (s *spiller) write(spans []*SpanData, processed bool) {
+	b, err := json.Marshal(spillFile{Processed: processed, Spans: spans})
+	if err == nil {
+		err = s.writeFile(b)
+	}
+	if err != nil {
+		atomic.AddInt64(&s.stats.Errors, 1)
+		s.client.reportError(fmt.Errorf("trace: failed to spill %d spans: %v", len(spans), err))
+		return
+	}
+	atomic.AddInt64(&s.stats.Spilled, 1)
+}
+
+func (s *spiller) writeFile(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := ioutil.TempFile(s.dir, ".partial-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		s.seq++
+		// The names sort in the order the files were written.
+		name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spillSuffix)
+		err = os.Rename(f.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	s.evict()
+	return nil
+}
+
+// evict deletes the oldest files until the files take at most maxBytes.
+func (s *spiller) evict() {
+	if s.maxBytes <= 0 {
+		return
+	}
+	files, err := s.files()
+	if err != nil {
+		return
+	}
+	var total int64
+	sizes := make([]int64, len(files))
+	for i, name := range files {
+		if fi, err := os.Stat(filepath.Join(s.dir, name)); err == nil {
+			sizes[i] = fi.Size()
+			total += sizes[i]
+		}
+	}
+	for i := 0; i < len(files) && total > s.maxBytes; i++ {
+		if err := os.Remove(filepath.Join(s.dir, files[i])); err == nil {
+			total -= sizes[i]
+			atomic.AddInt64(&s.stats.Evicted, 1)
+		}
+	}
+}
+
+// files returns the names of the spill files in the directory, oldest first.
+func (s *spiller) files() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), spillSuffix) {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// replay exports the spans of files, and deletes each file once its spans
+// are exported.  Files that fail to export again are left in the directory,
+// so that a crash or failure during the replay loses no spans.
+func (s *spiller) replay(files []string) {
+	c := s.client
+	for _, name := range files {
+		path := filepath.Join(s.dir, name)
+		f, err := s.read(path)
+		if err != nil {
+			os.Remove(path)
+			atomic.AddInt64(&s.stats.Errors, 1)
+			c.reportError(fmt.Errorf("trace: skipping spill file %s: %v", name, err))
+			continue
+		}
+		spans := f.Spans
+		if !f.Processed {
+			if spans = c.prepare(spans); len(spans) == 0 {
+				os.Remove(path)
+				continue
+			}
+		}
+		if err := c.exportRouted(context.Background(), spans); err != nil {
+			c.reportError(fmt.Errorf("trace: failed to upload %d spilled spans: %v", len(spans), err))
+			continue
+		}
+		os.Remove(path)
+		atomic.AddInt64(&s.stats.Replayed, 1)
+	}
+}
+
+// read reads the spill file at path.
+func (s *spiller) read(path string) (*spillFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f spillFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	for _, span := range f.Spans {
+		if span == nil {
+			return nil, errors.New("null span")
+		}
+	}
+	return &f, nil
+}
+
+// spillStats returns the stats of the client's spill directory, or nil if
+// it has none.
+func (c *Client) spillStats() *SpillStats {
+	if c == nil || c.spill == nil {
+		return nil
+	}
+	s := &c.spill.stats
+	return &SpillStats{
+		Spilled:  atomic.LoadInt64(&s.Spilled),
+		Replayed: atomic.LoadInt64(&s.Replayed),
+		Evicted:  atomic.LoadInt64(&s.Evicted),
+		Errors:   atomic.LoadInt64(&s.Errors),
+	}
+}
+
+// exportOrSpill exports spans like export, and saves them to the spill
+// directory, if any, if the export fails.
+func (c *Client) exportOrSpill(spans []*SpanData) error {
+	if spans = c.prepare(spans); len(spans) == 0 {
+		return nil
+	}
+	err := c.exportRouted(context.Background(), spans)
+	c.recordUpload(len(spans), err)
+	if err != nil && c.spill != nil {
+		c.spill.write(spans, true)
+	}
+	return err
+}
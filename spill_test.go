@@ -0,0 +1,192 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spillClient returns a client that exports to e and spills to dir.
+func spillClient(t *testing.T, e Exporter, dir string, maxBytes int64) *Client {
+	tc := NewClientWithExporter(e)
+	tc.bundler.DelayThreshold = time.Hour
+	tc.SetErrorHandler(func(error) {})
+	if err := tc.SetSpillDirectory(dir, maxBytes); err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+// spillFiles returns the names of the spill files in dir.
+func spillFiles(t *testing.T, dir string) []string {
+	names, err := (&spiller{dir: dir}).files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestSpillReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	down := &failingExporter{err: errors.New("backend unavailable")}
+	tc := spillClient(t, down, dir, 0)
+	root := tc.NewSpan("/root")
+	root.NewChild("child").Finish()
+	root.Finish()
+	if err := tc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tc.Stats().Spill; got == nil || got.Spilled != 1 {
+		t.Fatalf("Spill stats = %+v; want 1 spilled bundle", got)
+	}
+	if got := len(spillFiles(t, dir)); got != 1 {
+		t.Fatalf("%d spill files; want 1", got)
+	}
+
+	// A new client with the same directory exports the spilled spans.
+	e := &recordingExporter{}
+	tc = spillClient(t, e, dir, 0)
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if e.span("/root") == nil || e.span("child") == nil {
+		t.Errorf("replayed spans %v; want /root and child", names(e.spans))
+	}
+	if got, want := *tc.Stats().Spill, (SpillStats{Replayed: 1}); got != want {
+		t.Errorf("Spill stats = %+v; want %+v", got, want)
+	}
+	if files := spillFiles(t, dir); len(files) != 0 {
+		t.Errorf("spill files %v remain after the replay", files)
+	}
+}
+
+func TestSpillReplayFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &spiller{client: NewClientWithExporter(&recordingExporter{}), dir: dir}
+	s.write([]*SpanData{validSpanData()}, true)
+	files := spillFiles(t, dir)
+	before, err := ioutil.ReadFile(filepath.Join(dir, files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A client whose export fails again keeps the file as it was.
+	var errs []error
+	tc := NewClientWithExporter(&failingExporter{err: errors.New("backend unavailable")})
+	tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	if err := tc.SetSpillDirectory(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	tc.Flush(context.Background())
+	if got := spillFiles(t, dir); len(got) != 1 || got[0] != files[0] {
+		t.Fatalf("spill files after the failed replay = %v; want %v", got, files)
+	}
+	if after, err := ioutil.ReadFile(filepath.Join(dir, files[0])); err != nil || string(after) != string(before) {
+		t.Errorf("spill file changed by the failed replay: %q, %v", after, err)
+	}
+	if got, want := *tc.Stats().Spill, (SpillStats{}); got != want {
+		t.Errorf("Spill stats = %+v; want %+v", got, want)
+	}
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "trace: failed to upload 1 spilled spans") {
+		t.Errorf("got errors %v; want the failed upload", errs)
+	}
+}
+
+func TestSpillCorruptFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &spiller{client: NewClientWithExporter(&recordingExporter{}), dir: dir}
+	s.write([]*SpanData{validSpanData()}, true)
+	if err := ioutil.WriteFile(filepath.Join(dir, "0-truncated"+spillSuffix), []byte(`{"Processed":true,"Spans":[{"Trace`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &recordingExporter{}
+	var errs []error
+	tc := NewClientWithExporter(e)
+	tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	if err := tc.SetSpillDirectory(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(e.spans); got != 1 {
+		t.Errorf("replayed %d spans; want the 1 of the valid file", got)
+	}
+	if got, want := *tc.Stats().Spill, (SpillStats{Replayed: 1, Errors: 1}); got != want {
+		t.Errorf("Spill stats = %+v; want %+v", got, want)
+	}
+	if len(errs) != 1 {
+		t.Errorf("got errors %v; want one for the corrupt file", errs)
+	}
+	if files := spillFiles(t, dir); len(files) != 0 {
+		t.Errorf("spill files %v remain after the replay", files)
+	}
+}
+
+func TestSpillEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &spiller{client: NewClientWithExporter(&recordingExporter{}), dir: dir}
+	s.write([]*SpanData{validSpanData()}, true)
+	files := spillFiles(t, dir)
+	fi, err := os.Stat(filepath.Join(dir, files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for two files.
+	s.maxBytes = 2*fi.Size() + fi.Size()/2
+	for i := 0; i < 3; i++ {
+		s.write([]*SpanData{validSpanData()}, true)
+	}
+	got := spillFiles(t, dir)
+	if len(got) != 2 {
+		t.Fatalf("%d spill files; want 2", len(got))
+	}
+	for _, name := range got {
+		if name == files[0] {
+			t.Errorf("oldest file %s was kept", name)
+		}
+	}
+	if got, want := s.stats, (SpillStats{Spilled: 4, Evicted: 2}); got != want {
+		t.Errorf("Spill stats = %+v; want %+v", got, want)
+	}
+}
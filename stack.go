@@ -0,0 +1,54 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "runtime"
+
+// maxStackLabelBytes bounds the size of the stack trace label, so that a
+// deep stack with long names isn't truncated by validation into invalid
+// JSON.  Frames are dropped from the bottom of the stack to fit.
+const maxStackLabelBytes = 8 * 1024
+
+// SetStackTraces makes the client record the stack trace of the creation
+// of every traced span, in the label "trace.cloud.google.com/stacktrace",
+// as if each incoming request asked for it.  The frames of this package
+// are skipped, so the first frame is the function that created the span.
+// At most maxFrames frames are recorded, and at most 20; frames are also
+// dropped from the bottom of the stack to keep the label within 8 KiB.
+//
+// Capturing a stack trace takes time on every span, so a maxFrames of zero,
+// the default, records stack traces only for the requests that ask for them
+// in their trace headers, and for spans that call CaptureStack.
+//
+// SetStackTraces should be called before any spans are created.
+func (c *Client) SetStackTraces(maxFrames int) {
+	if c != nil {
+		c.stackFrames = maxFrames
+	}
+}
+
+// CaptureStack records the stack trace of its caller in the stack trace
+// label of s, replacing any stack recorded when s was created.  It is
+// limited like the stack traces of SetStackTraces.
+//
+// CaptureStack must be called before s is finished.  It does nothing if s
+// is nil or not traced.
+func (s *Span) CaptureStack() {
+	if s == nil || !s.tracing() {
+		return
+	}
+	s.stack = [maxStackFrames]uintptr{}
+	_ = runtime.Callers(2, s.stack[:])
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/trace"
+)
+
+type stackExporter struct {
+	mu     sync.Mutex
+	stacks map[string]string // stack trace labels, by span name
+}
+
+func (e *stackExporter) ExportSpans(ctx context.Context, spans []*trace.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range spans {
+		if v, ok := s.Labels["trace.cloud.google.com/stacktrace"]; ok {
+			e.stacks[s.Name] = v
+		}
+	}
+	return nil
+}
+
+// frames decodes the methods of the frames of a stack trace label.
+func frames(t *testing.T, label string) []string {
+	var v struct {
+		Frames []struct {
+			Method string `json:"method_name"`
+		} `json:"stack_frame"`
+	}
+	if err := json.Unmarshal([]byte(label), &v); err != nil {
+		t.Fatalf("decoding stack trace %q: %v", label, err)
+	}
+	var methods []string
+	for _, f := range v.Frames {
+		methods = append(methods, f.Method)
+	}
+	return methods
+}
+
+func newChildForStack(s *trace.Span) *trace.Span {
+	return s.NewChild("child")
+}
+
+func captureStackHere(s *trace.Span) {
+	s.CaptureStack()
+}
+
+func TestSetStackTraces(t *testing.T) {
+	e := &stackExporter{stacks: make(map[string]string)}
+	tc := trace.NewClientWithExporter(e)
+	tc.SetStackTraces(2)
+	root := tc.NewSpan("/root")
+	newChildForStack(root).Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	got := frames(t, e.stacks["child"])
+	if len(got) != 2 || !strings.HasSuffix(got[0], "trace_test.newChildForStack") {
+		t.Errorf("child stack = %q; want 2 frames starting with the caller of NewChild", got)
+	}
+	if _, ok := e.stacks["/root"]; !ok {
+		t.Error("root span has no stack trace")
+	}
+}
+
+func TestCaptureStack(t *testing.T) {
+	e := &stackExporter{stacks: make(map[string]string)}
+	tc := trace.NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	child := root.NewChild("child")
+	captureStackHere(child)
+	child.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := e.stacks["/root"]; ok {
+		t.Errorf("root span has stack trace %q without SetStackTraces or CaptureStack", v)
+	}
+	got := frames(t, e.stacks["child"])
+	if len(got) < 2 || !strings.HasSuffix(got[0], "trace_test.captureStackHere") || !strings.HasSuffix(got[1], "trace_test.TestCaptureStack") {
+		t.Errorf("child stack = %q; want it to start with the caller of CaptureStack", got)
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Stats contains statistics about the traces handled by a Client.
+type Stats struct {
+	// SpansStarted is the number of traced spans that the client created,
+	// root spans and children.
+	SpansStarted int64
+
+	// SpansSampledOut is the number of root spans that the client created
+	// but didn't trace, because of the trace header of the request or the
+	// decision of its SamplingPolicy, or because Shutdown had been called.
+	// Their children aren't counted.
+	SpansSampledOut int64
+
+	// SpansFinished is the number of traced spans that were finished.
+	SpansFinished int64
+
+	// SpansBuffered is the number of finished spans that are waiting in
+	// the client's bundler to be uploaded.
+	SpansBuffered int64
+
+	// SpansDropped is the number of finished spans that were discarded
+	// because the bundler's buffer was full.
+	SpansDropped int64
+
+	// UploadsSucceeded and UploadsFailed are the number of batches of
+	// spans whose upload succeeded and failed.
+	UploadsSucceeded int64
+	UploadsFailed    int64
+
+	// UploadRetries is the number of exports retried under the policy set
+	// with SetUploadRetryPolicy.
+	UploadRetries int64
+
+	// SpansPerTrace is a histogram of the number of spans in the traces
+	// handed to the exporter.  A trace handed over in several pieces, by
+	// Span.UploadProgress, is counted once, with the total of its pieces.
+	SpansPerTrace []Bucket
+
+	// SpansPerBatch is a histogram of the number of spans in the batches
+	// handed to the exporter.
+	SpansPerBatch []Bucket
+
+	// UnknownRoutes is the number of spans that the client's Router sent to
+	// an unregistered exporter name, and that went to the default exporter
+	// instead.
+	UnknownRoutes int64
+
+	// SlowSamplingDecisions is the number of decisions of the client's
+	// SamplingPolicy that took longer than the threshold set with
+	// SetSamplerLatencyThreshold.
+	SlowSamplingDecisions int64
+
+	// SamplingTimeouts is the number of decisions of the client's
+	// SamplingPolicy that took longer than the timeout set with
+	// SetSamplerTimeout, and were replaced by the fallback decision.
+	SamplingTimeouts int64
+
+	// ReplacedSpans is the number of times NewContext replaced a span of
+	// another trace with a span of the client.
+	ReplacedSpans int64
+
+	// SpansInFlight is the number of traced root spans that the client
+	// created and that haven't finished.  Shutdown waits for them.
+	SpansInFlight int64
+
+	// ExportTimeouts is the number of exports that took longer than the
+	// timeout set with SetExportTimeout.
+	ExportTimeouts int64
+
+	// RejectedTraces is the number of traces whose root spans finished
+	// after Close, and that were not uploaded.
+	RejectedTraces int64
+
+	// ForeignHeaders is the number of incoming calls that carried a trace
+	// header in a format this package knows, but that the client doesn't
+	// accept because of SetAcceptedHeaderFormats.
+	ForeignHeaders int64
+
+	// OutcomesDropped is the number of outcomes of root spans that were
+	// dropped because the sink set with SetOutcomeSink fell behind.
+	OutcomesDropped int64
+
+	// Breakers holds the state of the circuit breaker of each exporter, if
+	// the client has breakers set with SetCircuitBreaker.  The default
+	// exporter is named "default".
+	Breakers map[string]BreakerStats `json:",omitempty"`
+
+	// Spill holds the counts of the spill directory set with
+	// SetSpillDirectory, or is nil if the client has none.
+	Spill *SpillStats `json:",omitempty"`
+
+	// Autotuner holds the state of the batch autotuner set with
+	// SetBatchAutotuning, or is nil if the client has none.
+	Autotuner *AutotunerStats `json:",omitempty"`
+
+	// Methods holds the counts of the calls of each gRPC method, if the
+	// server interceptors were created with WithMethodStats.
+	Methods map[string]MethodStats `json:",omitempty"`
+}
+
+// A Bucket is a bucket of a histogram.  It counts the values between Min and
+// Max, inclusive.  Max is zero for the last bucket, which has no upper bound.
+type Bucket struct {
+	Min, Max int
+	Count    int64
+}
+
+// spansPerTraceBounds are the upper bounds of the buckets of the
+// spans-per-trace histogram, except for the last bucket.
+var spansPerTraceBounds = [...]int{1, 5, 20, 100}
+
+// spansPerBatchBounds are the upper bounds of the buckets of the
+// spans-per-batch histogram, except for the last bucket.
+var spansPerBatchBounds = [...]int{1, 10, 100, 1000}
+
+// stats holds the counters of a Client.  They are updated atomically.
+// The 64-bit counters must come first, for their alignment.
+type stats struct {
+	// spansStarted is striped, because every new span counts in it, even
+	// when many goroutines create the children of one span at once.
+	spansStarted stripedCounter
+
+	spansPerTrace   [len(spansPerTraceBounds) + 1]int64
+	spansPerBatch   [len(spansPerBatchBounds) + 1]int64
+	unknownRoutes   int64
+	slowSamples     int64
+	samplerTimeouts int64
+	replacedSpans   int64
+	exportTimeouts  int64
+	rejectedTraces  int64
+	foreignHeaders  int64
+	bufferedSpans   int64 // finished children waiting for their root, for SetMaxBufferedSpans.
+	outcomesDropped int64
+
+	spansSampledOut  int64
+	spansFinished    int64
+	spansBundled     int64 // spans in the bundler; a gauge.
+	spansDropped     int64
+	uploadsSucceeded int64
+	uploadsFailed    int64
+	uploadRetries    int64
+
+	methods methodStats
+}
+
+// counterStripes is the number of cells of a stripedCounter.
+const counterStripes = 16
+
+// A stripedCounter is a counter that many goroutines can add to at once
+// without all updating the same cache line: each addition goes to one of
+// its cells, chosen by a random key supplied by the caller.
+type stripedCounter struct {
+	cells [counterStripes]struct {
+		n int64
+		_ [56]byte // pads the cell to a 64-byte cache line.
+	}
+}
+
+// add adds n to the cell of c chosen by key.
+func (c *stripedCounter) add(key uint64, n int64) {
+	atomic.AddInt64(&c.cells[key%counterStripes].n, n)
+}
+
+// load returns the total of the cells of c.
+func (c *stripedCounter) load() int64 {
+	var total int64
+	for i := range c.cells {
+		total += atomic.LoadInt64(&c.cells[i].n)
+	}
+	return total
+}
+
+// recordTrace records that a trace with n spans was handed to the exporter.
+func (s *stats) recordTrace(n int) {
+	atomic.AddInt64(&s.spansPerTrace[bucketIndex(spansPerTraceBounds[:], n)], 1)
+}
+
+// recordFlush records that n more spans of t were handed to the exporter.
+// A trace handed over in several pieces, by Span.UploadProgress, is counted
+// once in the spans-per-trace histogram, with the total of its pieces so
+// far: each piece after the first moves it to the bucket of its new total.
+// The count lives as long as t, which is as long as pieces of the trace can
+// be flushed.
+func (t *trace) recordFlush(n int) {
+	t.mu.Lock()
+	prev, first := t.flushed, !t.hasFlushed
+	t.flushed += n
+	t.hasFlushed = true
+	total := t.flushed
+	t.mu.Unlock()
+	s := &t.client.stats
+	if !first {
+		atomic.AddInt64(&s.spansPerTrace[bucketIndex(spansPerTraceBounds[:], prev)], -1)
+	}
+	s.recordTrace(total)
+}
+
+// bucketIndex returns the index of the bucket of a histogram with bounds
+// that counts n.
+func bucketIndex(bounds []int, n int) int {
+	i := 0
+	for i < len(bounds) && n > bounds[i] {
+		i++
+	}
+	return i
+}
+
+// histogram returns the buckets of a histogram with bounds and counts.
+func histogram(bounds []int, counts []int64) []Bucket {
+	var h []Bucket
+	min := 1
+	for i := range counts {
+		b := Bucket{Min: min, Count: atomic.LoadInt64(&counts[i])}
+		if i < len(bounds) {
+			b.Max = bounds[i]
+			min = b.Max + 1
+		}
+		h = append(h, b)
+	}
+	return h
+}
+
+// SetUploadFailureHandler sets a function that is called, after the
+// error handler, each time the upload of a batch of spans fails, with the
+// error and the number of spans in the batch.  The function may be called
+// concurrently from multiple goroutines.
+func (c *Client) SetUploadFailureHandler(f func(err error, spans int)) {
+	if c != nil {
+		c.onUploadFailure = f
+	}
+}
+
+// recordUpload records the size and outcome of the upload of a batch of n
+// spans.
+func (c *Client) recordUpload(n int, err error) {
+	atomic.AddInt64(&c.stats.spansPerBatch[bucketIndex(spansPerBatchBounds[:], n)], 1)
+	if err == nil {
+		atomic.AddInt64(&c.stats.uploadsSucceeded, 1)
+		return
+	}
+	atomic.AddInt64(&c.stats.uploadsFailed, 1)
+	if f := c.onUploadFailure; f != nil {
+		f(err, n)
+	}
+}
+
+// Stats returns a snapshot of the client's statistics.
+func (c *Client) Stats() Stats {
+	var st Stats
+	if c == nil {
+		return st
+	}
+	st.SpansStarted = c.stats.spansStarted.load()
+	st.SpansSampledOut = atomic.LoadInt64(&c.stats.spansSampledOut)
+	st.SpansFinished = atomic.LoadInt64(&c.stats.spansFinished)
+	st.SpansBuffered = atomic.LoadInt64(&c.stats.spansBundled)
+	st.SpansDropped = atomic.LoadInt64(&c.stats.spansDropped)
+	st.UploadsSucceeded = atomic.LoadInt64(&c.stats.uploadsSucceeded)
+	st.UploadsFailed = atomic.LoadInt64(&c.stats.uploadsFailed)
+	st.UploadRetries = atomic.LoadInt64(&c.stats.uploadRetries)
+	st.UnknownRoutes = atomic.LoadInt64(&c.stats.unknownRoutes)
+	st.SlowSamplingDecisions = atomic.LoadInt64(&c.stats.slowSamples)
+	st.SamplingTimeouts = atomic.LoadInt64(&c.stats.samplerTimeouts)
+	st.ReplacedSpans = atomic.LoadInt64(&c.stats.replacedSpans)
+	st.SpansInFlight = c.drain.count()
+	st.ExportTimeouts = atomic.LoadInt64(&c.stats.exportTimeouts)
+	st.RejectedTraces = atomic.LoadInt64(&c.stats.rejectedTraces)
+	st.ForeignHeaders = atomic.LoadInt64(&c.stats.foreignHeaders)
+	st.OutcomesDropped = atomic.LoadInt64(&c.stats.outcomesDropped)
+	st.Breakers = c.breakerStats()
+	st.Spill = c.spillStats()
+	st.Methods = c.stats.methods.snapshot()
+	st.Autotuner = c.tuner.stats()
+	st.SpansPerTrace = histogram(spansPerTraceBounds[:], c.stats.spansPerTrace[:])
+	st.SpansPerBatch = histogram(spansPerBatchBounds[:], c.stats.spansPerBatch[:])
+	return st
+}
+
+// DebugHandler returns an http.Handler that serves the client's statistics
+// as JSON, with its configuration in the field "Config".  Register it on an
+// internal debugging mux:
+//
+//   http.Handle("/debug/trace", traceClient.DebugHandler())
+func (c *Client) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.MarshalIndent(struct {
+			Stats
+			Config Config
+		}{c.Stats(), c.Config()}, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// finishTrace finishes a trace of n spans.
+func finishTrace(t *testing.T, tc *Client, n int) {
+	root := tc.NewSpan("/root")
+	for i := 1; i < n; i++ {
+		root.NewChild("/child").Finish()
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpansPerTrace(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	for _, n := range []int{1, 1, 2, 5, 6, 20, 21, 100, 101, 250} {
+		finishTrace(t, tc, n)
+	}
+	// An untraced span is not handed to the exporter.
+	tc.SpanFromHeader("/untraced", "0123456789abcdef0123456789abcdef/1;o=0").Finish()
+
+	want := []Bucket{
+		{Min: 1, Max: 1, Count: 2},
+		{Min: 2, Max: 5, Count: 2},
+		{Min: 6, Max: 20, Count: 2},
+		{Min: 21, Max: 100, Count: 2},
+		{Min: 101, Count: 2},
+	}
+	if got := tc.Stats().SpansPerTrace; !reflect.DeepEqual(got, want) {
+		t.Errorf("SpansPerTrace = %+v; want %+v", got, want)
+	}
+}
+
+func TestSpanCounters(t *testing.T) {
+	e := &failingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetErrorHandler(func(error) {})
+	var failures []int
+	tc.SetUploadFailureHandler(func(err error, spans int) {
+		if err != e.err {
+			t.Errorf("upload failure handler called with %v; want %v", err, e.err)
+		}
+		failures = append(failures, spans)
+	})
+
+	finishTrace(t, tc, 3)
+	tc.SpanFromHeader("/untraced", "0123456789abcdef0123456789abcdef/1;o=0").Finish()
+	e.err = errors.New("upload failed")
+	root := tc.NewSpan("/root")
+	root.NewChild("/child").Finish()
+	if err := root.FinishWait(); err != e.err {
+		t.Errorf("FinishWait returned %v; want %v", err, e.err)
+	}
+
+	st := tc.Stats()
+	if st.SpansStarted != 5 || st.SpansSampledOut != 1 || st.SpansFinished != 5 {
+		t.Errorf("started, sampled out, finished = %d, %d, %d; want 5, 1, 5", st.SpansStarted, st.SpansSampledOut, st.SpansFinished)
+	}
+	if st.UploadsSucceeded != 1 || st.UploadsFailed != 1 {
+		t.Errorf("uploads succeeded, failed = %d, %d; want 1, 1", st.UploadsSucceeded, st.UploadsFailed)
+	}
+	if want := []int{2}; !reflect.DeepEqual(failures, want) {
+		t.Errorf("upload failure handler called for batches of %v spans; want %v", failures, want)
+	}
+}
+
+func TestBundledSpanCounters(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.bundler.DelayThreshold = time.Hour
+	tc.bundler.BundleByteLimit = 3
+	tc.bundler.BufferedByteLimit = 3
+	tc.SetErrorHandler(func(error) {})
+
+	for i := 0; i < 2; i++ {
+		root := tc.NewSpan("/root")
+		root.NewChild("/child").Finish()
+		root.Finish()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for tc.Stats().SpansBuffered+tc.Stats().SpansDropped < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// The first trace fills the bundler's buffer, so the second is dropped.
+	if st := tc.Stats(); st.SpansBuffered != 2 || st.SpansDropped != 2 {
+		t.Errorf("buffered, dropped = %d, %d; want 2, 2", st.SpansBuffered, st.SpansDropped)
+	}
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if st := tc.Stats(); st.SpansBuffered != 0 || st.UploadsSucceeded != 1 {
+		t.Errorf("after Flush, buffered, uploads = %d, %d; want 0, 1", st.SpansBuffered, st.UploadsSucceeded)
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	finishTrace(t, tc, 3)
+
+	rec := httptest.NewRecorder()
+	tc.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/trace", nil))
+	if got, want := rec.Header().Get("Content-Type"), "application/json"; got != want {
+		t.Errorf("Content-Type = %q; want %q", got, want)
+	}
+	var got Stats
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	if want := tc.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("handler served %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	labelGRPCStatusCode    = `grpc/status_code`
+	labelGRPCStatusMessage = `grpc/status_message`
+	labelLegacyError       = `error`
+)
+
+// SetLegacyErrorLabels sets whether the gRPC interceptors label the spans of
+// failed calls with the old "error" label, holding the text of the error, in
+// addition to the "grpc/status_code" and "grpc/status_message" labels.
+//
+// The old label is enabled by default for this release, so that alerts and
+// queries can move to the new labels; the default will change to disabled.
+// Until SetLegacyErrorLabels is called, a deprecation notice is logged the
+// first time the old label is used.
+func (c *Client) SetLegacyErrorLabels(enabled bool) {
+	if c != nil {
+		c.legacyErrorLabels = enabled
+		c.legacyErrorLabelsSet = true
+	}
+}
+
+// setStatusLabels labels span with the gRPC status code of err, the error
+// that ended a call, which is "OK" if err is nil, and with the other labels
+// of setErrorLabels if it isn't.
+func setStatusLabels(span *Span, err error) {
+	if err == nil {
+		span.setLabel(labelGRPCStatusCode, codes.OK.String())
+		return
+	}
+	setErrorLabels(span, err)
+}
+
+// setOKLabel labels span with the "OK" gRPC status code if err, the error
+// that ended a call, is nil.  The interceptors that finish the span of a call
+// themselves call it before FinishWithError, which labels failed calls.
+func setOKLabel(span *Span, err error) {
+	if err == nil {
+		span.setLabel(labelGRPCStatusCode, codes.OK.String())
+	}
+}
+
+// statusOf returns the gRPC status of err.  The status of an error wrapped
+// with fmt.Errorf's %w is that of the wrapped error, with its own message;
+// status.FromError would keep the code but take the message from the text of
+// the whole wrapping error.
+func statusOf(err error) *status.Status {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return se.GRPCStatus()
+	}
+	st, _ := status.FromError(err)
+	return st
+}
+
+// setErrorLabels labels span with the gRPC status of err, if it is non-nil.
+func setErrorLabels(span *Span, err error) {
+	if err == nil || span == nil {
+		return
+	}
+	atomic.StoreInt32(&span.failed, 1)
+	if !span.tracing() {
+		return
+	}
+	st := statusOf(err)
+	span.setLabel(labelGRPCStatusCode, st.Code().String())
+	span.setLabel(labelGRPCStatusMessage, st.Message())
+	c := span.trace.client
+	if c.legacyErrorLabelsSet && !c.legacyErrorLabels {
+		return
+	}
+	if !c.legacyErrorLabelsSet {
+		c.legacyErrorNotice.Do(func() {
+			c.noticef(`trace: the "error" label of gRPC spans is deprecated; use "grpc/status_code" and "grpc/status_message". Call SetLegacyErrorLabels to choose whether to keep it.`)
+		})
+	}
+	span.setLabel(labelLegacyError, err.Error())
+}
+
+// FinishWithError is like Finish, but if err is non-nil, it first records err
+// on s the way the gRPC interceptors record the errors of calls: s is labeled
+// with the gRPC status code and message of err, as "grpc/status_code" and
+// "grpc/status_message", which are "Unknown" and the text of err if err has
+// no gRPC status, and with the old "error" label unless it is disabled with
+// SetLegacyErrorLabels.  s is also reported as failed to the outcome sink,
+// and exporters with a status field, such as OTLP, mark it as an error.
+//
+// If err is nil, FinishWithError is Finish.  If s is nil, it does nothing.
+//
+//   func (db *DB) Get(ctx context.Context, key string) (v []byte, err error) {
+//     span := trace.FromContext(ctx).NewChild("db.Get")
+//     defer func() { span.FinishWithError(err) }()
+//     ...
+//   }
+func (s *Span) FinishWithError(err error, opts ...FinishOption) {
+	setErrorLabels(s, err)
+	s.Finish(opts...)
+}
+
+// FinishWaitWithError is like FinishWithError, but finishes s with
+// FinishWait, and returns its error.
+func (s *Span) FinishWaitWithError(err error, opts ...FinishOption) error {
+	setErrorLabels(s, err)
+	return s.FinishWait(opts...)
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLegacyErrorLabels(t *testing.T) {
+	callErr := status.Error(codes.NotFound, "no such entity")
+	newShape := map[string]string{
+		labelGRPCStatusCode:    "NotFound",
+		labelGRPCStatusMessage: "no such entity",
+	}
+	bothShapes := map[string]string{
+		labelGRPCStatusCode:    "NotFound",
+		labelGRPCStatusMessage: "no such entity",
+		labelLegacyError:       "rpc error: code = NotFound desc = no such entity",
+	}
+	for _, tt := range []struct {
+		desc string
+		set  func(tc *Client)
+		want map[string]string
+	}{
+		{"default", func(*Client) {}, bothShapes},
+		{"enabled", func(tc *Client) { tc.SetLegacyErrorLabels(true) }, bothShapes},
+		{"disabled", func(tc *Client) { tc.SetLegacyErrorLabels(false) }, newShape},
+	} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		tt.set(tc)
+
+		// Client side.
+		root := tc.NewSpan("/root")
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return callErr
+		}
+		GRPCClientInterceptor()(NewContext(context.Background(), root), "/client", nil, nil, nil, invoker)
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		if got := statusLabels(e.span("/client").Labels); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: client span labels = %v; want %v", tt.desc, got, tt.want)
+		}
+
+		// Server side.
+		e.spans = nil
+		tc.bundler.BundleCountThreshold = 1
+		e.exported = make(chan struct{}, 1)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(grpcMetadataKey, "0123456789ABCDEF0123456789ABCDEF/1;o=1"))
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, callErr
+		}
+		GRPCServerInterceptor(tc)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/server"}, handler)
+		<-e.exported
+		if got := statusLabels(e.spans[0].Labels); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: server span labels = %v; want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+// statusLabels returns the labels of labels that hold the status of a call.
+func TestFinishWithError(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetLegacyErrorLabels(true)
+	callErr := status.Error(codes.NotFound, "no such entity")
+
+	root := tc.NewSpan("/root")
+	root.NewChild("status").FinishWithError(callErr)
+	root.NewChild("plain").FinishWithError(errors.New("disk full"))
+	root.NewChild("ok").FinishWithError(nil)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return callErr
+	}
+	GRPCClientInterceptor()(NewContext(context.Background(), root), "/client", nil, nil, nil, invoker)
+	var nilSpan *Span
+	nilSpan.FinishWithError(callErr)
+	if err := root.FinishWaitWithError(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spans finished by hand record errors like the interceptors.
+	if got, want := statusLabels(e.span("status").Labels), statusLabels(e.span("/client").Labels); !reflect.DeepEqual(got, want) {
+		t.Errorf("span finished with a gRPC error has labels %v; want those of the interceptor, %v", got, want)
+	}
+	want := map[string]string{
+		labelGRPCStatusCode:    "Unknown",
+		labelGRPCStatusMessage: "disk full",
+		labelLegacyError:       "disk full",
+	}
+	if got := statusLabels(e.span("plain").Labels); !reflect.DeepEqual(got, want) {
+		t.Errorf("span finished with a plain error has labels %v; want %v", got, want)
+	}
+	if got := statusLabels(e.span("ok").Labels); len(got) != 0 {
+		t.Errorf("span finished with a nil error has labels %v; want none", got)
+	}
+	if got := statusLabels(e.span("/root").Labels); len(got) != 0 {
+		t.Errorf("root span finished with a nil error has labels %v; want none", got)
+	}
+}
+
+func statusLabels(labels map[string]string) map[string]string {
+	m := make(map[string]string)
+	for _, key := range []string{labelGRPCStatusCode, labelGRPCStatusMessage, labelLegacyError} {
+		if v, ok := labels[key]; ok {
+			m[key] = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.7
+
+package trace
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// A statusWriter is an http.ResponseWriter that records the status code of
+// the response, for the span of the request.
+type statusWriter struct {
+	http.ResponseWriter
+	status   int // zero until the header is written.
+	hijacked bool
+}
+
+// wrapResponseWriter returns a statusWriter for w that implements
+// http.Flusher and http.Hijacker if w does, so that handlers' type
+// assertions keep working.
+func wrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *statusWriter) {
+	sw := &statusWriter{ResponseWriter: w}
+	_, flusher := w.(http.Flusher)
+	_, hijacker := w.(http.Hijacker)
+	switch {
+	case flusher && hijacker:
+		return flushHijackWriter{sw}, sw
+	case flusher:
+		return flushWriter{sw}, sw
+	case hijacker:
+		return hijackWriter{sw}, sw
+	}
+	return sw, sw
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// code returns the status code of the response, once the handler has
+// returned.  Like net/http, it is 200 if the handler wrote nothing, and it
+// is zero if the handler hijacked the connection.
+func (w *statusWriter) code() int {
+	switch {
+	case w.hijacked:
+		return 0
+	case w.status == 0:
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *statusWriter) flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *statusWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+type flushWriter struct{ *statusWriter }
+
+func (w flushWriter) Flush() { w.flush() }
+
+type hijackWriter struct{ *statusWriter }
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+type flushHijackWriter struct{ *statusWriter }
+
+func (w flushHijackWriter) Flush() { w.flush() }
+
+func (w flushHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
@@ -0,0 +1,98 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.7
+
+package trace
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPHandlerStatusCode(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		handler func(w http.ResponseWriter)
+		want    string
+	}{
+		{"WriteHeader", func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) }, "404"},
+		{"WriteHeader twice", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusAccepted)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "202"},
+		{"Write", func(w http.ResponseWriter) { io.WriteString(w, "body") }, "200"},
+		{"Flush", func(w http.ResponseWriter) {
+			w.(http.Flusher).Flush()
+			w.WriteHeader(http.StatusTeapot)
+		}, "200"},
+		{"nothing", func(w http.ResponseWriter) {}, "200"},
+	} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		h := tc.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tt.handler(w)
+		}))
+		req := httptest.NewRequest("GET", "http://example.com/status", nil)
+		req.Header.Set(httpHeader, "0123456789abcdef0123456789abcdef/1;o=1")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		tc.Flush(context.Background())
+		if len(e.spans) != 1 {
+			t.Fatalf("%s: exported %d spans; want 1", tt.desc, len(e.spans))
+		}
+		if got := e.spans[0].Labels[labelStatusCode]; got != tt.want {
+			t.Errorf("%s: status code label = %q; want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPHandlerWriterInterfaces(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	var flusher, hijacker bool
+	h := tc.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+	}))
+	serve := func(w http.ResponseWriter) {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set(httpHeader, "0123456789abcdef0123456789abcdef/1;o=1")
+		h.ServeHTTP(w, req)
+	}
+
+	// A ResponseRecorder is a Flusher, but not a Hijacker.
+	serve(httptest.NewRecorder())
+	if !flusher || hijacker {
+		t.Errorf("with a ResponseRecorder: Flusher %v, Hijacker %v; want true, false", flusher, hijacker)
+	}
+	serve(struct{ http.ResponseWriter }{httptest.NewRecorder()})
+	if flusher || hijacker {
+		t.Errorf("with a plain ResponseWriter: Flusher %v, Hijacker %v; want false, false", flusher, hijacker)
+	}
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set(httpHeader, "0123456789abcdef0123456789abcdef/1;o=1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !flusher || !hijacker {
+		t.Errorf("with a server's ResponseWriter: Flusher %v, Hijacker %v; want true, true", flusher, hijacker)
+	}
+}
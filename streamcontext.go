@@ -0,0 +1,58 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const labelGRPCContextDone = `grpc/context_done`
+
+// watchContext finishes the span of s when the context of its stream is
+// done, unless the span was finished first, so that the span of a stream
+// abandoned by cancelling its context, with no further calls of SendMsg or
+// RecvMsg, is still uploaded.
+func (s *ClientStreamWrapper) watchContext() {
+	ctx := s.stream.Context()
+	if ctx.Done() == nil || s.span == nil || !s.span.tracing() {
+		return
+	}
+	s.finished = make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			code := codes.Canceled
+			if ctx.Err() == context.DeadlineExceeded {
+				code = codes.DeadlineExceeded
+			}
+			s.finish(status.Error(code, ctx.Err().Error()))
+		case <-s.finished:
+		}
+	}()
+}
+
+// setContextDoneLabel labels span with the reason ctx is done, if it is:
+// "cancelled" or "deadline_exceeded".
+func setContextDoneLabel(span *Span, ctx context.Context) {
+	switch ctx.Err() {
+	case context.Canceled:
+		span.setLabel(labelGRPCContextDone, "cancelled")
+	case context.DeadlineExceeded:
+		span.setLabel(labelGRPCContextDone, "deadline_exceeded")
+	}
+}
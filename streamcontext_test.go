@@ -0,0 +1,99 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// abandonedStream starts a traced stream with ctx and returns it, without
+// calling any of its methods.
+func abandonedStream(t *testing.T, ctx context.Context) *ClientStreamWrapper {
+	cs, err := GRPCStreamClientInterceptor()(ctx, &grpc.StreamDesc{}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeClientStream{ctx: ctx}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs.(*ClientStreamWrapper)
+}
+
+func TestClientStreamContextDone(t *testing.T) {
+	for _, tt := range []struct {
+		desc      string
+		cancel    bool // cancel the context instead of letting it expire.
+		wantLabel string
+		wantCode  codes.Code
+	}{
+		{"cancelled", true, "cancelled", codes.Canceled},
+		{"deadline", false, "deadline_exceeded", codes.DeadlineExceeded},
+	} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		root := tc.NewSpan("/root")
+		ctx, cancel := context.WithTimeout(NewContext(context.Background(), root), time.Millisecond)
+		if tt.cancel {
+			ctx, cancel = context.WithCancel(NewContext(context.Background(), root))
+		}
+		cs := abandonedStream(t, ctx)
+		if tt.cancel {
+			cancel()
+		}
+		select {
+		case <-cs.finished:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: span not finished after the context was done", tt.desc)
+		}
+		cancel()
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		s := e.span("/stream")
+		if s == nil {
+			t.Fatalf("%s: exported spans %v; want /stream", tt.desc, names(e.spans))
+		}
+		if got := s.Labels[labelGRPCContextDone]; got != tt.wantLabel {
+			t.Errorf("%s: %s = %q; want %q", tt.desc, labelGRPCContextDone, got, tt.wantLabel)
+		}
+		if got, want := s.Labels[labelGRPCStatusCode], tt.wantCode.String(); got != want {
+			t.Errorf("%s: %s = %q; want %q", tt.desc, labelGRPCStatusCode, got, want)
+		}
+	}
+}
+
+func TestClientStreamContextDoneAfterFinish(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx, cancel := context.WithCancel(NewContext(context.Background(), root))
+	cs := abandonedStream(t, ctx)
+	cs.CloseSend()
+	cancel()
+	<-cs.finished
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(e.spans); len(got) != 2 {
+		t.Errorf("exported spans %v; want the root and one stream span", got)
+	}
+	if v, ok := e.span("/stream").Labels[labelGRPCContextDone]; ok {
+		t.Errorf("stream closed before its context was cancelled is labeled %s=%q", labelGRPCContextDone, v)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	labelGRPCSentMessages = `grpc/sent_messages`
+	labelGRPCRecvMessages = `grpc/recv_messages`
+	labelGRPCSentBytes    = `grpc/sent_bytes`
+	labelGRPCRecvBytes    = `grpc/recv_bytes`
+)
+
+type withStreamByteCounts struct{}
+
+// WithStreamByteCounts returns an InterceptorOption that makes the stream
+// interceptors, on both the client and the server side, label the span of
+// each stream with the total size of the messages sent and received on it,
+// as "grpc/sent_bytes" and "grpc/recv_bytes".  The size of a message is
+// that of its encoding, computed with proto.Size; messages that aren't
+// protocol buffers count as zero bytes.
+//
+// The numbers of messages are always labeled, as "grpc/sent_messages" and
+// "grpc/recv_messages".
+func WithStreamByteCounts() InterceptorOption {
+	return withStreamByteCounts{}
+}
+
+func (withStreamByteCounts) configureInterceptor(c *interceptorConfig) {
+	c.streamBytes = true
+}
+
+// streamCounts counts the messages of a stream, and their bytes if bytes
+// is set.  SendMsg and RecvMsg may be called concurrently, so the counters
+// are updated atomically.
+type streamCounts struct {
+	sentMessages int64
+	recvMessages int64
+	sentBytes    int64
+	recvBytes    int64
+	stalledSends int64
+	stallNanos   int64 // the total time of the stalled sends.
+
+	bytes          bool          // for WithStreamByteCounts.
+	stallThreshold time.Duration // for WithSendStallDetection, or 0.
+}
+
+// sent counts m, a message sent on the stream.
+func (c *streamCounts) sent(m interface{}) {
+	atomic.AddInt64(&c.sentMessages, 1)
+	if c.bytes {
+		atomic.AddInt64(&c.sentBytes, int64(messageSize(m)))
+	}
+}
+
+// received counts m, a message received on the stream.
+func (c *streamCounts) received(m interface{}) {
+	atomic.AddInt64(&c.recvMessages, 1)
+	if c.bytes {
+		atomic.AddInt64(&c.recvBytes, int64(messageSize(m)))
+	}
+}
+
+// setLabels labels span, the span of the stream, with the counts.
+func (c *streamCounts) setLabels(span *Span) {
+	if !span.traced() {
+		return
+	}
+	span.setLabel(labelGRPCSentMessages, strconv.FormatInt(atomic.LoadInt64(&c.sentMessages), 10))
+	span.setLabel(labelGRPCRecvMessages, strconv.FormatInt(atomic.LoadInt64(&c.recvMessages), 10))
+	if c.bytes {
+		span.setLabel(labelGRPCSentBytes, strconv.FormatInt(atomic.LoadInt64(&c.sentBytes), 10))
+		span.setLabel(labelGRPCRecvBytes, strconv.FormatInt(atomic.LoadInt64(&c.recvBytes), 10))
+	}
+	c.setStallLabels(span)
+}
+
+// messageSize returns the size of the encoding of m, or zero if m isn't a
+// protocol buffer.
+func messageSize(m interface{}) int {
+	if pm, ok := m.(proto.Message); ok {
+		return proto.Size(pm)
+	}
+	return 0
+}
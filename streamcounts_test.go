@@ -0,0 +1,132 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+)
+
+func TestServerStreamCounts(t *testing.T) {
+	for _, tt := range []struct {
+		opts []InterceptorOption
+		want map[string]string
+	}{
+		{
+			want: map[string]string{labelGRPCSentMessages: "3", labelGRPCRecvMessages: "2"},
+		},
+		{
+			opts: []InterceptorOption{WithStreamByteCounts()},
+			want: map[string]string{
+				labelGRPCSentMessages: "3", labelGRPCRecvMessages: "2",
+				// "hello" is encoded in 7 bytes; the received messages are empty.
+				labelGRPCSentBytes: "21", labelGRPCRecvBytes: "0",
+			},
+		},
+	} {
+		e := &recordingExporter{exported: make(chan struct{}, 1)}
+		tc := NewClientWithExporter(e)
+		tc.bundler.BundleCountThreshold = 1
+		ss := sendingServerStream{newTracedStream(2)}
+		GRPCStreamServerInterceptor(tc, tt.opts...)(nil, ss, &grpc.StreamServerInfo{FullMethod: "/test.Echo/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+			// Send and receive concurrently, as bidirectional streams do.
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 3; i++ {
+					ss.SendMsg(&wrappers.StringValue{Value: "hello"})
+				}
+			}()
+			for ss.RecvMsg(&wrappers.StringValue{}) == nil {
+			}
+			wg.Wait()
+			return nil
+		})
+		<-e.exported
+		got := e.spans[0].Labels
+		for _, k := range []string{labelGRPCSentMessages, labelGRPCRecvMessages, labelGRPCSentBytes, labelGRPCRecvBytes} {
+			if got[k] != tt.want[k] {
+				t.Errorf("with %d options, label %s = %q; want %q", len(tt.opts), k, got[k], tt.want[k])
+			}
+		}
+	}
+}
+
+// recvClientStream is a fakeClientStream that sends messages, and receives
+// n messages holding "hi" before the end of the stream.
+type recvClientStream struct {
+	fakeClientStream
+	n int
+}
+
+func (s *recvClientStream) SendMsg(m interface{}) error { return nil }
+
+func (s *recvClientStream) RecvMsg(m interface{}) error {
+	if s.n == 0 {
+		return io.EOF
+	}
+	s.n--
+	m.(*wrappers.StringValue).Value = "hi"
+	return nil
+}
+
+func TestClientStreamCounts(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	ctx := NewContext(context.Background(), root)
+	cs, err := GRPCStreamClientInterceptor(WithStreamByteCounts())(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, nil, "/stream", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &recvClientStream{fakeClientStream{ctx: ctx}, 3}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2; i++ {
+			cs.SendMsg(&wrappers.StringValue{Value: "hello"})
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if err := cs.RecvMsg(&wrappers.StringValue{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if err := cs.RecvMsg(&wrappers.StringValue{}); err != io.EOF {
+		t.Fatalf("RecvMsg returned %v; want io.EOF", err)
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		labelGRPCSentMessages: "2", labelGRPCRecvMessages: "3",
+		labelGRPCSentBytes: "14", labelGRPCRecvBytes: "12",
+	}
+	got := e.span("/stream").Labels
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("label %s = %q; want %q", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	labelSynthetic     = `trace/synthetic`
+	labelSummaryCount  = `trace/summary/count`
+	labelSummaryBucket = `trace/summary/latency`
+	summaryTraceName   = `trace/summary`
+
+	// maxSummaryNames is the maximum number of span names summarized in an
+	// interval.  The durations of spans with other names are summarized
+	// under summaryOtherName.
+	maxSummaryNames  = 100
+	summaryOtherName = `other`
+)
+
+// summaryBounds are the upper bounds of the latency buckets of summary
+// traces, except for the last bucket.
+var summaryBounds = [...]time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// newSummaryTicker returns a channel that delivers the end of each summary
+// interval, and a function that stops it.  Tests replace it with a fake
+// clock.
+var newSummaryTicker = func(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// SetSummaryTraces makes the client export a synthetic trace once per
+// interval, summarizing the durations of the spans it exported in the
+// interval.  The trace has a root span named "trace/summary", and a child
+// for each span name and latency bucket that had spans, labelled with the
+// bucket, as "trace/summary/latency", and the number of spans, as
+// "trace/summary/count".  All spans of the trace are labelled
+// "trace/synthetic" so they can be filtered out.  The spans labeled
+// "dependency" by WithDependencyNamer are also summarized by dependency, in
+// children named and labeled with the dependency.
+//
+// This gives coarse latency data in the trace backend without a metrics
+// system.  An interval of zero, the default, disables the summary traces.
+// Call Close to stop them.
+func (c *Client) SetSummaryTraces(interval time.Duration) {
+	if c == nil {
+		return
+	}
+	if c.summary != nil {
+		c.summary.stop()
+		c.summary = nil
+	}
+	if interval > 0 {
+		c.summary = startSummarizer(c, interval)
+	}
+}
+
+// summaryCounts holds the number of spans in each latency bucket.
+type summaryCounts [len(summaryBounds) + 1]int64
+
+// summarizer records the durations of exported spans and exports summary
+// traces.
+type summarizer struct {
+	interval time.Duration
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.Mutex
+	counts map[string]*summaryCounts // by span name
+	deps   map[string]*summaryCounts // by dependency, for WithDependencyNamer
+}
+
+func startSummarizer(c *Client, interval time.Duration) *summarizer {
+	s := &summarizer{
+		interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	tick, stop := newSummaryTicker(interval)
+	go func() {
+		defer close(s.stopped)
+		defer stop()
+		start := time.Now()
+		for {
+			select {
+			case end := <-tick:
+				if spans := s.flush(start, end); spans != nil {
+					if err := c.export(spans); err != nil {
+						c.reportError(fmt.Errorf("failed to upload summary trace: %v", err))
+					}
+				}
+				start = end
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// stop stops the summary traces, and waits for a summary trace being
+// exported.  It can be called more than once.
+func (s *summarizer) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+	<-s.stopped
+}
+
+// record adds the durations of spans to the summary of the current interval.
+func (s *summarizer) record(spans []*SpanData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, span := range spans {
+		if span.Labels[labelSynthetic] != "" {
+			continue
+		}
+		d := span.End.Sub(span.Start)
+		i := 0
+		for i < len(summaryBounds) && d >= summaryBounds[i] {
+			i++
+		}
+		countsOf(&s.counts, span.Name)[i]++
+		if dep := span.Labels[labelDependency]; dep != "" {
+			countsOf(&s.deps, dep)[i]++
+		}
+	}
+}
+
+// countsOf returns the counts in *m for key, or if *m has the maximum
+// number of keys, for summaryOtherName, adding them to *m if needed.
+func countsOf(m *map[string]*summaryCounts, key string) *summaryCounts {
+	counts := (*m)[key]
+	if counts == nil {
+		if len(*m) >= maxSummaryNames {
+			key = summaryOtherName
+			counts = (*m)[key]
+		}
+		if counts == nil {
+			if *m == nil {
+				*m = make(map[string]*summaryCounts)
+			}
+			counts = new(summaryCounts)
+			(*m)[key] = counts
+		}
+	}
+	return counts
+}
+
+// flush returns the summary trace of the interval from start to end, and
+// starts a new interval.  It returns nil if no spans were recorded.
+func (s *summarizer) flush(start, end time.Time) []*SpanData {
+	s.mu.Lock()
+	counts, deps := s.counts, s.deps
+	s.counts, s.deps = nil, nil
+	s.mu.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	root := &SpanData{
+		TraceID: nextTraceID(),
+		SpanID:  nextSpanID(),
+		Name:    summaryTraceName,
+		Start:   start,
+		End:     end,
+		Labels:  map[string]string{labelSynthetic: "true"},
+	}
+	spans := []*SpanData{root}
+	add := func(counts map[string]*summaryCounts, labelKey string) {
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for i, n := range counts[name] {
+				if n == 0 {
+					continue
+				}
+				labels := map[string]string{
+					labelSynthetic:     "true",
+					labelSummaryBucket: summaryBucketName(i),
+					labelSummaryCount:  strconv.FormatInt(n, 10),
+				}
+				if labelKey != "" {
+					labels[labelKey] = name
+				}
+				spans = append(spans, &SpanData{
+					TraceID:      root.TraceID,
+					SpanID:       nextSpanID(),
+					ParentSpanID: root.SpanID,
+					Name:         name,
+					Start:        start,
+					End:          end,
+					Labels:       labels,
+				})
+			}
+		}
+	}
+	add(counts, "")
+	add(deps, labelDependency)
+	return spans
+}
+
+// summaryBucketName returns the label value for latency bucket i, such as
+// "10ms-100ms".
+func summaryBucketName(i int) string {
+	switch {
+	case i == 0:
+		return "<" + summaryBounds[0].String()
+	case i == len(summaryBounds):
+		return ">=" + summaryBounds[i-1].String()
+	}
+	return summaryBounds[i-1].String() + "-" + summaryBounds[i].String()
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useFakeSummaryTicker makes summary traces use the returned channel as
+// their ticker.  Call the returned function to restore the real ticker.
+func useFakeSummaryTicker() (chan time.Time, func()) {
+	tick := make(chan time.Time)
+	orig := newSummaryTicker
+	newSummaryTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return tick, func() {}
+	}
+	return tick, func() { newSummaryTicker = orig }
+}
+
+func summarySpan(name string, d time.Duration) *SpanData {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &SpanData{Name: name, Start: start, End: start.Add(d)}
+}
+
+// summaryCountsOf returns the counts of the children of a summary trace, by
+// span name and bucket.
+func summaryCountsOf(t *testing.T, spans []*SpanData) map[string]string {
+	got := map[string]string{}
+	for _, s := range spans {
+		if s.Labels[labelSynthetic] != "true" {
+			t.Errorf("span %q isn't labelled synthetic", s.Name)
+		}
+		if s.Name == summaryTraceName {
+			continue
+		}
+		got[s.Name+" "+s.Labels[labelSummaryBucket]] = s.Labels[labelSummaryCount]
+	}
+	return got
+}
+
+func TestSummaryTraces(t *testing.T) {
+	tick, restore := useFakeSummaryTicker()
+	defer restore()
+	e := &recordingExporter{exported: make(chan struct{})}
+	tc := NewClientWithExporter(e)
+	tc.SetSummaryTraces(time.Minute)
+	defer tc.Close()
+
+	tc.summary.record([]*SpanData{
+		summarySpan("/a", 500*time.Microsecond),
+		summarySpan("/a", 2*time.Millisecond),
+		summarySpan("/a", 3*time.Millisecond),
+		summarySpan("/b", time.Minute),
+	})
+	end := time.Now().Add(time.Minute)
+	tick <- end
+	<-e.exported
+
+	want := map[string]string{
+		"/a <1ms":     "1",
+		"/a 1ms-10ms": "2",
+		"/b >=10s":    "1",
+	}
+	if got := summaryCountsOf(t, e.spans); !reflect.DeepEqual(got, want) {
+		t.Errorf("summary counts = %v; want %v", got, want)
+	}
+	for _, s := range e.spans {
+		if s.TraceID != e.spans[0].TraceID || !s.End.Equal(end) {
+			t.Errorf("span %q is in trace %s ending at %v; want trace %s ending at %v", s.Name, s.TraceID, s.End, e.spans[0].TraceID, end)
+		}
+	}
+
+	// The next interval only counts its own spans, and not the synthetic
+	// spans of the summary trace.
+	e.spans = nil
+	span := tc.NewSpan("/c")
+	errc := make(chan error)
+	go func() { errc <- span.FinishWait() }()
+	<-e.exported
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	e.spans = nil
+	tick <- end.Add(time.Minute)
+	<-e.exported
+	got := summaryCountsOf(t, e.spans)
+	if len(got) != 1 {
+		t.Fatalf("summary counts = %v; want one count for /c", got)
+	}
+	for k, n := range got {
+		if k[:3] != "/c " || n != "1" {
+			t.Errorf("summary counts = %v; want one count for /c", got)
+		}
+	}
+
+	// Empty intervals export nothing.
+	tick <- end.Add(2 * time.Minute)
+	select {
+	case <-e.exported:
+		t.Errorf("empty interval exported spans %v", e.spans)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSummaryTracesClose(t *testing.T) {
+	stopped := make(chan struct{})
+	orig := newSummaryTicker
+	defer func() { newSummaryTicker = orig }()
+	newSummaryTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return nil, func() { close(stopped) }
+	}
+
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetSummaryTraces(time.Minute)
+	if err := tc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("Close didn't stop the ticker")
+	}
+	tc.Close() // Close can be called again.
+}
+
+func TestSummaryOtherName(t *testing.T) {
+	var s summarizer
+	for i := 0; i < maxSummaryNames+5; i++ {
+		s.record([]*SpanData{summarySpan(string(rune('A'+i%26))+string(rune('a'+i/26)), 0)})
+	}
+	if got, want := len(s.counts), maxSummaryNames+1; got != want {
+		t.Errorf("got %d names; want %d", got, want)
+	}
+	if got := s.counts[summaryOtherName][0]; got != 5 {
+		t.Errorf("other count = %d; want 5", got)
+	}
+}
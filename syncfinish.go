@@ -0,0 +1,81 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"time"
+)
+
+// SynchronousFinish returns an InterceptorOption that makes the server
+// interceptors upload the trace of each call before returning, like
+// FinishWait, instead of handing it to the client's bundler.  Use it in
+// short-lived programs, such as command-line tools or cloud functions,
+// that may exit before the bundler uploads the traces; long-running
+// servers should keep the default.  The option applies only to the
+// interceptors created with it.
+//
+// If timeout is positive, the interceptor waits at most timeout for the
+// upload, which then continues in the background; otherwise it waits until
+// the upload finishes, or until the timeout set with SetExportTimeout.
+// Upload errors are reported to the client's error handler.
+//
+// The spans of the client interceptors are children of the caller's span,
+// and are uploaded with its trace, so the option doesn't affect them; finish
+// the root span of a short-lived program with FinishWait instead.
+func SynchronousFinish(timeout time.Duration) InterceptorOption {
+	return synchronousFinish{timeout}
+}
+
+type synchronousFinish struct {
+	timeout time.Duration
+}
+
+func (o synchronousFinish) configureInterceptor(c *interceptorConfig) {
+	c.syncFinish = true
+	c.syncFinishTimeout = o.timeout
+}
+
+// finishServerSpan finishes span, the span of an incoming call, once its
+// handler has returned.  If sync is set, it waits for the upload of the
+// trace, for at most timeout if timeout is positive.
+func finishServerSpan(span *Span, sync bool, timeout time.Duration) {
+	if !sync || span == nil || !span.tracing() {
+		span.finishAfterHandler(false)
+		return
+	}
+	c := span.trace.client
+	upload := func() {
+		if err := span.finishAfterHandler(true); err != nil {
+			c.reportError(fmt.Errorf("error uploading trace: %v", err))
+		}
+	}
+	if timeout <= 0 {
+		upload()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		upload()
+		close(done)
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+		c.debugf("trace: stopped waiting for the upload of trace %s after %v", span.TraceID(), timeout)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestSynchronousFinishUnary(t *testing.T) {
+	const method = "/test.Service/Method"
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	for _, sync := range []bool{false, true} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		tc.bundler.DelayThreshold = time.Hour
+		var opts []InterceptorOption
+		if sync {
+			opts = append(opts, SynchronousFinish(0))
+		}
+		GRPCServerInterceptor(tc, opts...)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if got := e.exportedCount() == 1; got != sync {
+			t.Errorf("sync=%v: span exported when the interceptor returned = %v; want %v", sync, got, sync)
+		}
+	}
+}
+
+func TestSynchronousFinishStream(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.bundler.DelayThreshold = time.Hour
+	err := GRPCStreamServerInterceptor(tc, SynchronousFinish(0))(nil, newTracedStream(0), &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, func(srv interface{}, ss grpc.ServerStream) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.exportedCount(); got != 1 {
+		t.Errorf("exported %d spans when the interceptor returned; want 1", got)
+	}
+}
+
+func TestSynchronousFinishTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	tc := NewClientWithExporter(hangingExporter{release})
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	start := time.Now()
+	GRPCServerInterceptor(tc, SynchronousFinish(10*time.Millisecond))(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("interceptor took %v; want it to stop waiting after the timeout", elapsed)
+	}
+}
+
+func TestSynchronousFinishError(t *testing.T) {
+	tc := NewClientWithExporter(&failingExporter{err: errors.New("export failed")})
+	var errs []error
+	tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	md := metadata.Pairs(grpcMetadataKey, "0123456789abcdef0123456789abcdef/1;o=1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	GRPCServerInterceptor(tc, SynchronousFinish(0))(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	if len(errs) != 1 {
+		t.Errorf("got errors %v; want the upload error", errs)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"path"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+const labelGRPCAuthority = `grpc/authority`
+
+// NormalizeTarget returns a short form of the target of a grpc.ClientConn,
+// suitable for labels and dashboards that expect host:port values:
+//
+//   dns:///service.internal:443        service.internal:443
+//   dns://8.8.8.8/service.internal:443 service.internal:443
+//   passthrough:///10.0.0.1:8080       10.0.0.1:8080
+//   unix:///tmp/sockets/backend.sock   unix:backend.sock
+//   unix:backend.sock                  unix:backend.sock
+//   [::1]:8080                         [::1]:8080
+//
+// The scheme and authority of targets of the form scheme://authority/endpoint
+// are removed, leaving the endpoint.  Unix socket paths are shortened to
+// their base name.  Other targets are returned unchanged.
+//
+// The client interceptors label the spans of calls with the normalized
+// target of the connection, as "grpc/authority".
+func NormalizeTarget(target string) string {
+	scheme, rest := target, ""
+	if i := strings.Index(target, ":"); i >= 0 {
+		scheme, rest = target[:i], target[i+1:]
+	}
+	if strings.HasPrefix(rest, "//") {
+		endpoint := rest[2:]
+		if i := strings.Index(endpoint, "/"); i >= 0 {
+			endpoint = endpoint[i+1:]
+		}
+		if scheme == "unix" {
+			return "unix:" + path.Base(endpoint)
+		}
+		return endpoint
+	}
+	if scheme == "unix" && rest != "" {
+		return "unix:" + path.Base(rest)
+	}
+	return target
+}
+
+// setAuthorityLabel labels span with the normalized target of cc.
+func setAuthorityLabel(span *Span, cc *grpc.ClientConn) {
+	if cc != nil {
+		span.setLabel(labelGRPCAuthority, NormalizeTarget(cc.Target()))
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "testing"
+
+func TestNormalizeTarget(t *testing.T) {
+	for _, tt := range []struct {
+		target, want string
+	}{
+		{"service.internal:443", "service.internal:443"},
+		{"dns:///service.internal:443", "service.internal:443"},
+		{"dns://8.8.8.8/service.internal:443", "service.internal:443"},
+		{"dns:service.internal:443", "dns:service.internal:443"},
+		{"passthrough:///10.0.0.1:8080", "10.0.0.1:8080"},
+		{"passthrough:///[2001:db8::1]:8080", "[2001:db8::1]:8080"},
+		{"unix:///tmp/sockets/backend.sock", "unix:backend.sock"},
+		{"unix:/tmp/sockets/backend.sock", "unix:backend.sock"},
+		{"unix:backend.sock", "unix:backend.sock"},
+		{"[::1]:8080", "[::1]:8080"},
+		{"[2001:db8::1]:443", "[2001:db8::1]:443"},
+		{"dns:///[2001:db8::1]:443", "[2001:db8::1]:443"},
+		{"localhost", "localhost"},
+		{"", ""},
+	} {
+		if got := NormalizeTarget(tt.target); got != tt.want {
+			t.Errorf("NormalizeTarget(%q) = %q; want %q", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultTeeQueueSize is the default number of batches of spans waiting to
+// be exported to the secondary exporter of a Tee.
+const defaultTeeQueueSize = 16
+
+// A TeeOption configures a Tee.
+type TeeOption interface {
+	configureTee(c *teeConfig)
+}
+
+type teeConfig struct {
+	queueSize int
+	fraction  float64
+}
+
+type teeOption func(c *teeConfig)
+
+func (o teeOption) configureTee(c *teeConfig) { o(c) }
+
+// WithSecondaryQueueSize returns a TeeOption that sets the number of batches
+// of spans that can wait to be exported to the secondary exporter.  The
+// default is 16.
+func WithSecondaryQueueSize(n int) TeeOption {
+	return teeOption(func(c *teeConfig) {
+		if n > 0 {
+			c.queueSize = n
+		}
+	})
+}
+
+// WithSecondarySampling returns a TeeOption that sends only the given
+// fraction of traces to the secondary exporter.  Traces are chosen by their
+// trace ID, so that the secondary exporter gets all the spans of a trace or
+// none of them.  The primary exporter always gets every span.
+func WithSecondarySampling(fraction float64) TeeOption {
+	return teeOption(func(c *teeConfig) {
+		c.fraction = fraction
+	})
+}
+
+// A Tee is an Exporter that exports spans to two exporters, for example
+// while migrating from one tracing backend to another.
+//
+// The result of ExportSpans is that of the primary exporter.  The spans are
+// also queued for the secondary exporter, which exports them in the
+// background; its failures, and the spans dropped because its queue is
+// full, are only counted in the Tee's stats.
+type Tee struct {
+	primary, secondary Exporter
+	threshold          uint64        // traces with IDs whose low bits are at most threshold go to secondary.
+	done               chan struct{} // closed when the secondary exporter has stopped.
+
+	mu     sync.RWMutex
+	queue  chan []*SpanData // closed by Close.
+	closed bool
+
+	dropped int64 // accessed atomically
+	failed  int64 // accessed atomically
+}
+
+// TeeStats contains statistics about the secondary exporter of a Tee.
+type TeeStats struct {
+	// Dropped is the number of spans that were not sent to the secondary
+	// exporter because its queue was full, or the Tee was closed.
+	Dropped int64
+
+	// Failed is the number of spans that the secondary exporter failed to
+	// export.
+	Failed int64
+}
+
+// TeeExporter returns a Tee that exports spans to primary and secondary.
+// Call Close to stop the goroutine that exports to secondary.
+func TeeExporter(primary, secondary Exporter, opts ...TeeOption) *Tee {
+	cfg := teeConfig{queueSize: defaultTeeQueueSize, fraction: 1}
+	for _, o := range opts {
+		o.configureTee(&cfg)
+	}
+	t := &Tee{
+		primary:   primary,
+		secondary: secondary,
+		threshold: fractionThreshold(cfg.fraction),
+		queue:     make(chan []*SpanData, cfg.queueSize),
+		done:      make(chan struct{}),
+	}
+	go t.exportSecondary()
+	return t
+}
+
+// fractionThreshold returns the threshold of IDs, which are uniformly
+// distributed, that selects fraction of them.
+func fractionThreshold(fraction float64) uint64 {
+	switch {
+	case fraction >= 1:
+		return 1<<64 - 1
+	case fraction <= 0:
+		return 0
+	}
+	return uint64(fraction * (1 << 64))
+}
+
+// ExportSpans exports spans to the primary exporter, and queues a copy of
+// them for the secondary exporter.
+func (t *Tee) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	if copies := t.secondarySpans(spans); len(copies) > 0 {
+		t.mu.RLock()
+		queued := false
+		if !t.closed {
+			select {
+			case t.queue <- copies:
+				queued = true
+			default:
+			}
+		}
+		t.mu.RUnlock()
+		if !queued {
+			atomic.AddInt64(&t.dropped, int64(len(copies)))
+		}
+	}
+	return t.primary.ExportSpans(ctx, spans)
+}
+
+// secondarySpans returns copies of the spans of spans that are sampled for
+// the secondary exporter.  The copies are made before the primary exporter
+// gets the spans, since it owns their labels.
+func (t *Tee) secondarySpans(spans []*SpanData) []*SpanData {
+	if t.threshold == 0 {
+		return nil
+	}
+	var copies []*SpanData
+	for _, s := range spans {
+		if !t.sampled(s.TraceID) {
+			continue
+		}
+		c := *s
+		if s.Labels != nil {
+			c.Labels = make(map[string]string, len(s.Labels))
+			for k, v := range s.Labels {
+				c.Labels[k] = v
+			}
+		}
+		if s.LabelTruncatedBytes != nil {
+			c.LabelTruncatedBytes = make(map[string]int, len(s.LabelTruncatedBytes))
+			for k, v := range s.LabelTruncatedBytes {
+				c.LabelTruncatedBytes[k] = v
+			}
+		}
+		c.Annotations = append([]Annotation(nil), s.Annotations...)
+		copies = append(copies, &c)
+	}
+	return copies
+}
+
+func (t *Tee) sampled(traceID string) bool {
+	if t.threshold == 1<<64-1 || len(traceID) < 16 {
+		return true
+	}
+	id, err := strconv.ParseUint(traceID[len(traceID)-16:], 16, 64)
+	return err != nil || id <= t.threshold
+}
+
+func (t *Tee) exportSecondary() {
+	defer close(t.done)
+	for spans := range t.queue {
+		if err := t.secondary.ExportSpans(context.Background(), spans); err != nil {
+			atomic.AddInt64(&t.failed, int64(len(spans)))
+		}
+	}
+}
+
+// Stats returns a snapshot of the statistics of the secondary exporter.
+func (t *Tee) Stats() TeeStats {
+	return TeeStats{
+		Dropped: atomic.LoadInt64(&t.dropped),
+		Failed:  atomic.LoadInt64(&t.failed),
+	}
+}
+
+// Describe implements Describer.
+func (t *Tee) Describe() string {
+	return fmt.Sprintf("tee of %s and %s", describe(t.primary), describe(t.secondary))
+}
+
+// Close waits for the queued spans to be exported to the secondary exporter,
+// and stops the goroutine that exports them.  Spans exported after Close are
+// only exported to the primary exporter.
+func (t *Tee) Close() {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	<-t.done
+}
+
+// SetAdditionalExporter makes the client also export spans to e, using a Tee
+// whose primary exporter is the client's current exporter.  Exporters
+// registered for a Router are unaffected.  Close closes the Tee.
+func (c *Client) SetAdditionalExporter(e Exporter, opts ...TeeOption) {
+	if c != nil {
+		c.exporter = TeeExporter(c.exporter, e, opts...)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// blockingExporter is an Exporter whose ExportSpans signals started, then
+// blocks until release is closed.
+type blockingExporter struct {
+	recordingExporter
+	started chan struct{}
+	release chan struct{}
+}
+
+func (e *blockingExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	e.started <- struct{}{}
+	<-e.release
+	return e.recordingExporter.ExportSpans(ctx, spans)
+}
+
+func teeSpans(traceID string, n int) []*SpanData {
+	var spans []*SpanData
+	for i := 0; i < n; i++ {
+		s := validSpanData()
+		s.TraceID = traceID
+		s.Labels = map[string]string{"k": "v"}
+		spans = append(spans, s)
+	}
+	return spans
+}
+
+func TestTeeFailingSecondary(t *testing.T) {
+	primary := &recordingExporter{}
+	secondary := &failingExporter{err: errors.New("collector unavailable")}
+	tee := TeeExporter(primary, secondary)
+	for i := 0; i < 3; i++ {
+		if err := tee.ExportSpans(context.Background(), teeSpans(validationTraceID, 2)); err != nil {
+			t.Fatalf("ExportSpans: %v", err)
+		}
+	}
+	tee.Close()
+	if got := len(primary.spans); got != 6 {
+		t.Errorf("primary exported %d spans; want 6", got)
+	}
+	if got, want := tee.Stats(), (TeeStats{Failed: 6}); got != want {
+		t.Errorf("Stats() = %+v; want %+v", got, want)
+	}
+}
+
+func TestTeeSecondaryQueueFull(t *testing.T) {
+	primary := &recordingExporter{}
+	secondary := &blockingExporter{started: make(chan struct{}), release: make(chan struct{})}
+	tee := TeeExporter(primary, secondary, WithSecondaryQueueSize(1))
+
+	export := func() {
+		if err := tee.ExportSpans(context.Background(), teeSpans(validationTraceID, 2)); err != nil {
+			t.Fatalf("ExportSpans: %v", err)
+		}
+	}
+	export()
+	<-secondary.started // the first batch is being exported,
+	export()            // the second is queued,
+	export()            // and the third is dropped.
+	if got := tee.Stats().Dropped; got != 2 {
+		t.Errorf("Dropped = %d; want 2", got)
+	}
+	if got := len(primary.spans); got != 6 {
+		t.Errorf("primary exported %d spans; want 6", got)
+	}
+
+	close(secondary.release)
+	go func() {
+		for range secondary.started {
+		}
+	}()
+	tee.Close()
+	if got := len(secondary.spans); got != 4 {
+		t.Errorf("secondary exported %d spans; want 4", got)
+	}
+	export() // after Close, spans only go to the primary exporter.
+	if got := tee.Stats().Dropped; got != 4 {
+		t.Errorf("Dropped after Close = %d; want 4", got)
+	}
+	close(secondary.started)
+}
+
+func TestTeeSecondarySampling(t *testing.T) {
+	const (
+		sampledID   = "0123456789abcdef0000000000000001"
+		unsampledID = "0123456789abcdefffffffffffffffff"
+	)
+	primary, secondary := &recordingExporter{}, &recordingExporter{}
+	tee := TeeExporter(primary, secondary, WithSecondarySampling(0.5))
+	spans := append(teeSpans(sampledID, 1), teeSpans(unsampledID, 1)...)
+	if err := tee.ExportSpans(context.Background(), spans); err != nil {
+		t.Fatal(err)
+	}
+	// The primary exporter owns the spans, and may modify them.
+	spans[0].Labels["k"] = "modified"
+	tee.Close()
+
+	if got := len(primary.spans); got != 2 {
+		t.Errorf("primary exported %d spans; want 2", got)
+	}
+	if len(secondary.spans) != 1 || secondary.spans[0].TraceID != sampledID {
+		t.Fatalf("secondary exported %v; want only the span of trace %s", secondary.spans, sampledID)
+	}
+	if got := secondary.spans[0].Labels["k"]; got != "v" {
+		t.Errorf("secondary span label = %q; want the unmodified %q", got, "v")
+	}
+}
+
+func TestSetAdditionalExporter(t *testing.T) {
+	primary, secondary := &recordingExporter{}, &recordingExporter{}
+	tc := NewClientWithExporter(primary)
+	tc.SetAdditionalExporter(secondary)
+	span := tc.NewSpan("/foo")
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.spans) != 1 || len(secondary.spans) != 1 {
+		t.Errorf("exported %d spans to the primary exporter, %d to the secondary; want 1 each", len(primary.spans), len(secondary.spans))
+	}
+}
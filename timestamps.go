@@ -0,0 +1,59 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import "time"
+
+// SetStartTime changes the start time of s to start.  It's useful for
+// spans of operations measured elsewhere, such as by a device that reports
+// its measurements in batches, which started before the span was created.
+// If s is nil, does nothing.
+//
+// Spans created by the package, such as those of interceptors, may label
+// durations measured from the time they were created instead.
+// SetStartTime shouldn't be called after Finish or FinishWait.
+func (s *Span) SetStartTime(start time.Time) {
+	if s == nil || !s.tracing() {
+		return
+	}
+	s.spanMu.Lock()
+	s.start = start
+	s.spanMu.Unlock()
+}
+
+// FinishAt is like Finish, but gives s the end time end instead of the
+// current time.  If end is before the start of s, the span ends at its
+// start instead, since the Stackdriver Trace API rejects spans with a
+// negative duration; if strict validation is enabled, a *ValidationError is
+// reported to the error handler.
+//
+// As with Finish, only the first call that finishes s has an effect: the end
+// time of a span that was already finished isn't changed.
+//
+// If s is nil, FinishAt does nothing.
+func (s *Span) FinishAt(end time.Time, opts ...FinishOption) {
+	if s == nil {
+		return
+	}
+	if !s.tracing() {
+		s.finishUntraced(end, opts)
+		return
+	}
+	if s.enforced != nil {
+		s.deferFinish(end, opts)
+		return
+	}
+	s.trace.finish(s, end, false, opts...)
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetStartTimeFinishAt(t *testing.T) {
+	var nilSpan *Span
+	nilSpan.SetStartTime(time.Now())
+	nilSpan.FinishAt(time.Now())
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetChildDurationRollup(4)
+	root := tc.NewSpan("/root")
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	child := root.NewChild("device.op")
+	child.SetStartTime(start)
+	child.FinishAt(start.Add(1500 * time.Millisecond))
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := e.span("device.op")
+	if s == nil {
+		t.Fatalf("exported spans %v; want device.op", names(e.spans))
+	}
+	if !s.Start.Equal(start) || !s.End.Equal(start.Add(1500*time.Millisecond)) {
+		t.Errorf("span is from %v to %v; want %v to %v", s.Start, s.End, start, start.Add(1500*time.Millisecond))
+	}
+	if got, want := e.span("/root").Labels["child_ms/device.op"], "1500"; got != want {
+		t.Errorf("child duration label = %q; want %q", got, want)
+	}
+}
+
+func TestFinishAtBeforeStart(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetStrictValidation(true)
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	tc.SetErrorHandler(func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+	root := tc.NewSpan("/root")
+	start := root.start
+	if err := root.NewChild("child").FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	root.FinishAt(start.Add(-time.Second))
+	if err := tc.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	s := e.span("/root")
+	if s == nil {
+		t.Fatalf("exported spans %v; want /root", names(e.spans))
+	}
+	if !s.End.Equal(s.Start) {
+		t.Errorf("span ends at %v, %v after its start; want the start", s.End, s.End.Sub(s.Start))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 1 {
+		t.Fatalf("got errors %v; want one", errs)
+	}
+	if v, ok := errs[0].(*ValidationError); !ok || !v.Fixed || v.SpanName != "/root" {
+		t.Errorf("got error %#v; want a fixed *ValidationError for /root", errs[0])
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	labelTLSVersion = `tls/version`
+	labelTLSCipher  = `tls/cipher`
+	labelTLSALPN    = `tls/alpn`
+)
+
+type withTLSLabels struct{}
+
+// WithTLSLabels returns an InterceptorOption that labels spans with the TLS
+// version ("1.2", "1.3", or "none" for plaintext connections), cipher suite
+// and negotiated ALPN protocol of their connection.  It applies to the
+// server spans of the gRPC server interceptors, and to the spans of the
+// outgoing requests of an HTTPClient.
+func WithTLSLabels() InterceptorOption {
+	return withTLSLabels{}
+}
+
+func (withTLSLabels) configureInterceptor(c *interceptorConfig) {
+	c.tlsLabels = true
+}
+
+// setTLSLabels labels s with the TLS state of its connection, or as
+// plaintext if state is nil.
+func setTLSLabels(s *Span, state *tls.ConnectionState) {
+	if state == nil {
+		s.setLabel(labelTLSVersion, "none")
+		return
+	}
+	s.setLabel(labelTLSVersion, tlsVersionName(state.Version))
+	s.setLabel(labelTLSCipher, tls.CipherSuiteName(state.CipherSuite))
+	if state.NegotiatedProtocol != "" {
+		s.setLabel(labelTLSALPN, state.NegotiatedProtocol)
+	}
+}
+
+// setPeerTLSLabels labels s with the TLS state of the connection of the
+// incoming gRPC call in ctx.
+func setPeerTLSLabels(ctx context.Context, s *Span) {
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	setTLSLabels(s, state)
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
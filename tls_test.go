@@ -0,0 +1,131 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.7
+
+package trace
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// tlsLabelsOfRequest makes a traced request to ts with an HTTP client created
+// with opts, and returns the labels of the request's span.
+func tlsLabelsOfRequest(t *testing.T, ts *httptest.Server, opts ...InterceptorOption) map[string]string {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	client := tc.NewHTTPClient(ts.Client(), opts...)
+	root := tc.NewSpan("/root")
+	req, _ := http.NewRequest("GET", ts.URL+"/tls", nil)
+	req = req.WithContext(NewContext(req.Context(), root))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range e.spans {
+		if s.ParentSpanID == root.span.SpanId {
+			return s.Labels
+		}
+	}
+	t.Fatal("request was not traced")
+	return nil
+}
+
+func TestHTTPClientTLSLabels(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+
+	labels := tlsLabelsOfRequest(t, tlsServer, WithTLSLabels())
+	if got, want := labels[labelTLSVersion], "1.3"; got != want {
+		t.Errorf("%s = %q; want %q", labelTLSVersion, got, want)
+	}
+	if labels[labelTLSCipher] == "" {
+		t.Errorf("no %s label; labels are %v", labelTLSCipher, labels)
+	}
+
+	labels = tlsLabelsOfRequest(t, plainServer, WithTLSLabels())
+	if got, want := labels[labelTLSVersion], "none"; got != want {
+		t.Errorf("plaintext %s = %q; want %q", labelTLSVersion, got, want)
+	}
+	if _, ok := labels[labelTLSCipher]; ok {
+		t.Errorf("plaintext request has a %s label", labelTLSCipher)
+	}
+
+	labels = tlsLabelsOfRequest(t, tlsServer)
+	if _, ok := labels[labelTLSVersion]; ok {
+		t.Errorf("request without WithTLSLabels has a %s label", labelTLSVersion)
+	}
+}
+
+func TestGRPCServerTLSLabelsPlaintext(t *testing.T) {
+	serverExporter := &recordingExporter{exported: make(chan struct{}, 1)}
+	serverTC := NewClientWithExporter(serverExporter)
+	serverTC.bundler.BundleCountThreshold = 1
+	lis, headers, stop := startEchoServer(GRPCServerOptions(serverTC, WithTLSLabels())...)
+	defer stop()
+	conn := dialEcho(t, lis, EnableGRPCTracingAll...)
+	defer conn.Close()
+
+	tc := NewClientWithExporter(&recordingExporter{})
+	root := tc.NewSpan("/root")
+	echo(t, NewContext(context.Background(), root), conn, headers)
+	root.Finish()
+
+	select {
+	case <-serverExporter.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the server's span")
+	}
+	if got, want := serverExporter.spans[0].Labels[labelTLSVersion], "none"; got != want {
+		t.Errorf("server span %s = %q; want %q", labelTLSVersion, got, want)
+	}
+}
+
+func TestSetPeerTLSLabels(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/server")
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			Version:            tls.VersionTLS12,
+			CipherSuite:        tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			NegotiatedProtocol: "h2",
+		}},
+	})
+	setPeerTLSLabels(ctx, span)
+	want := map[string]string{
+		labelTLSVersion: "1.2",
+		labelTLSCipher:  "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		labelTLSALPN:    "h2",
+	}
+	for k, v := range want {
+		if got := span.span.Labels[k]; got != v {
+			t.Errorf("%s = %q; want %q", k, got, v)
+		}
+	}
+}
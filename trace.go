@@ -23,19 +23,23 @@
 // NewClient function. Generally you will want to do this on program
 // initialization.
 //
-//   import "cloud.google.com/go/trace"
-//   ...
-//   traceClient, err = trace.NewClient(ctx, projectID)
+//	import "cloud.google.com/go/trace"
+//	...
+//	traceClient, err = trace.NewClient(ctx, projectID)
+//
+// To upload traces to a different backend, create the client with
+// NewClientWithExporter instead.  Package cloud.google.com/go/trace/otlp
+// provides an Exporter for OpenTelemetry collectors.
 //
 // Calling SpanFromRequest will create a new trace span for an incoming HTTP
 // request.  If the request contains a trace context header, it is used to
 // determine the trace ID.  Otherwise, a new trace ID is created.
 //
-//   func handler(w http.ResponseWriter, r *http.Request) {
-//     span := traceClient.SpanFromRequest(r)
-//     defer span.Finish()
-//     ...
-//   }
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//	  span := traceClient.SpanFromRequest(r)
+//	  defer span.Finish()
+//	  ...
+//	}
 //
 // SpanFromRequest and NewSpan returns nil if the *Client is nil, so you can disable
 // tracing by not initializing your *Client variable.  All of the exported
@@ -44,8 +48,8 @@
 // If you need to start traces that don't correspond to an incoming HTTP request,
 // you can use NewSpan to create a root-level span.
 //
-//   span := traceClient.NewSpan("span name")
-//   defer span.Finish()
+//	span := traceClient.NewSpan("span name")
+//	defer span.Finish()
 //
 // Although a trace span object is created for every request, only a subset of
 // traces are uploaded to the server, for efficiency.  By default, the requests
@@ -57,23 +61,23 @@
 // limit on the number of requests traced per second.  The following example
 // traces one in every thousand requests, up to a limit of 5 per second.
 //
-//   p, err := trace.NewLimitedSampler(0.001, 5)
-//   traceClient.SetSamplingPolicy(p)
+//	p, err := trace.NewLimitedSampler(0.001, 5)
+//	traceClient.SetSamplingPolicy(p)
 //
 // You can create a new span as a child of an existing span with NewChild.
 //
-//   childSpan := span.NewChild(name)
-//   ...
-//   childSpan.Finish()
+//	childSpan := span.NewChild(name)
+//	...
+//	childSpan.Finish()
 //
 // When sending an HTTP request to another server, NewRemoteChild will create
 // a span to represent the time the current program waits for the request to
 // complete, and attach a header to the outgoing request so that the trace will
 // be propagated to the destination server.
 //
-//   childSpan := span.NewRemoteChild(&httpRequest)
-//   ...
-//   childSpan.Finish()
+//	childSpan := span.NewRemoteChild(&httpRequest)
+//	...
+//	childSpan.Finish()
 //
 // Alternatively, if you have access to the X-Cloud-Trace-Context header value
 // but not the underlying HTTP request (this can happen if you are using a
@@ -82,11 +86,11 @@
 // specify the span name explicility, since it cannot be constructed from the
 // HTTP request's URL and method.
 //
-//   func handler(r *somepkg.Request) {
-//     span := traceClient.SpanFromHeader("span name", r.TraceContext())
-//     defer span.Finish()
-//     ...
-//   }
+//	func handler(r *somepkg.Request) {
+//	  span := traceClient.SpanFromHeader("span name", r.TraceContext())
+//	  defer span.Finish()
+//	  ...
+//	}
 //
 // Spans can contain a map from keys to values that have useful information
 // about the span.  The elements of this map are called labels.  Some labels,
@@ -104,14 +108,14 @@
 // You can also set labels using SetLabel.  If a label is given a value
 // automatically and by SetLabel, the automatically-set value is used.
 //
-//   span.SetLabel(key, value)
+//	span.SetLabel(key, value)
 //
 // The WithResponse option can be used when Finish is called.
 //
-//   childSpan := span.NewRemoteChild(outgoingReq)
-//   resp, err := http.DefaultClient.Do(outgoingReq)
-//   ...
-//   childSpan.Finish(trace.WithResponse(resp))
+//	childSpan := span.NewRemoteChild(outgoingReq)
+//	resp, err := http.DefaultClient.Do(outgoingReq)
+//	...
+//	childSpan.Finish(trace.WithResponse(resp))
 //
 // When a span created by SpanFromRequest or SpamFromHeader is finished, the
 // finished spans in the corresponding trace -- the span itself and its
@@ -120,7 +124,7 @@
 // occurs asynchronously.  You can use the FinishWait function instead to wait
 // until uploading has finished.
 //
-//   err := span.FinishWait()
+//	err := span.FinishWait()
 //
 // Using contexts to pass *trace.Span objects through your program will often
 // be a better approach than passing them around explicitly.  This allows trace
@@ -131,35 +135,37 @@
 // See https://blog.golang.org/context for more discussion of contexts.
 // A derived context containing a trace span can be created using NewContext.
 //
-//   span := traceClient.SpanFromRequest(r)
-//   ctx = trace.NewContext(ctx, span)
+//	span := traceClient.SpanFromRequest(r)
+//	ctx = trace.NewContext(ctx, span)
 //
 // The span can be retrieved from a context elsewhere in the program using
 // FromContext.
 //
-//   func foo(ctx context.Context) {
-//     span := trace.FromContext(ctx).NewChild("in foo")
-//     defer span.Finish()
-//     ...
-//   }
-//
+//	func foo(ctx context.Context) {
+//	  span := trace.FromContext(ctx).NewChild("in foo")
+//	  defer span.Finish()
+//	  ...
+//	}
 package trace
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"runtime"
+	rtrace "runtime/trace"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"golang.org/x/net/context"
 	api "google.golang.org/api/cloudtrace/v1"
 	"google.golang.org/api/gensupport"
 	"google.golang.org/api/option"
@@ -169,6 +175,7 @@ import (
 
 const (
 	httpHeader          = `X-Cloud-Trace-Context`
+	legacyHTTPHeader    = `X-AppEngine-Trace-Context`
 	userAgent           = `gcloud-golang-trace/20160501`
 	cloudPlatformScope  = `https://www.googleapis.com/auth/cloud-platform`
 	spanKindClient      = `RPC_CLIENT`
@@ -182,6 +189,8 @@ const (
 	labelURL            = `trace.cloud.google.com/http/url`
 	labelSamplingPolicy = `trace.cloud.google.com/sampling_policy`
 	labelSamplingWeight = `trace.cloud.google.com/sampling_weight`
+	labelChildDuration  = `child_ms/`
+	childRollupOther    = `other`
 )
 
 const (
@@ -212,9 +221,10 @@ var (
 )
 
 func init() {
-	// Set spanIDCounter and spanIDIncrement to random values.  nextSpanID will
-	// return an arithmetic progression using these values, skipping zero.  We set
-	// the LSB of spanIDIncrement to 1, so that the cycle length is 2^64.
+	// Set spanIDCounter and spanIDIncrement to random values.  If crypto/rand
+	// fails, nextSpanID returns an arithmetic progression using these values,
+	// skipping zero.  We set the LSB of spanIDIncrement to 1, so that the cycle
+	// length is 2^64.
 	binary.Read(rand.Reader, binary.LittleEndian, &spanIDCounter)
 	binary.Read(rand.Reader, binary.LittleEndian, &spanIDIncrement)
 	spanIDIncrement |= 1
@@ -238,11 +248,14 @@ func requestHook(ctx context.Context, req *http.Request) func(resp *http.Respons
 	}
 }
 
-// nextSpanID returns a new span ID.  It will never return zero.
+// nextSpanID returns a new random span ID.  It will never return zero.
 func nextSpanID() uint64 {
 	var id uint64
 	for id == 0 {
-		id = atomic.AddUint64(&spanIDCounter, spanIDIncrement)
+		var ok bool
+		if id, ok = randomUint64(); !ok {
+			id = atomic.AddUint64(&spanIDCounter, spanIDIncrement)
+		}
 	}
 	return id
 }
@@ -256,14 +269,96 @@ func nextTraceID() string {
 
 // Client is a client for uploading traces to the Google Stackdriver Trace server.
 type Client struct {
-	service   *api.Service
-	projectID string
-	policy    SamplingPolicy
-	bundler   *bundler.Bundler
+	stats stats // first, for the alignment of its 64-bit atomic counters.
+
+	exporter    Exporter
+	policy      atomic.Value // policyValue, for SetSamplingPolicy.
+	bundler     *bundler.Bundler
+	childRollup int // maximum number of child names rolled up per span, or 0.
+	onError     func(error)
+	strict      bool
+
+	onUploadFailure func(err error, spans int) // for SetUploadFailureHandler, or nil.
+
+	labelLimit      int // for SetLabelLimits, or 0 for the API's limits.
+	labelValueLimit int // for SetLabelLimits, if labelLimit isn't 0.
+
+	minSpanDuration  time.Duration // for SetMinSpanDuration, or 0.
+	traceSlowSpans   bool          // for SetTraceSlowSpans.
+	maxTraceSpans    int32         // for SetMaxSpansPerTrace, or 0.
+	maxBufferedSpans int64         // for SetMaxBufferedSpans, or 0.
+
+	processors     []SpanProcessor
+	lastProcessors []SpanProcessor // applied after processors.
+	cardinality    *cardinalityMonitor
+
+	router    Router
+	exporters map[string]Exporter // named exporters, for router.
+
+	requestHeaders  []string       // headers read by SpanFromRequest; nil means defaultRequestHeaders.
+	acceptedFormats []HeaderFormat // for SetAcceptedHeaderFormats, or nil.
+
+	summary  *summarizer  // for SetSummaryTraces, or nil.
+	outcomes *outcomeSink // for SetOutcomeSink, or nil.
+
+	legacyErrorLabels    bool
+	legacyErrorLabelsSet bool // whether SetLegacyErrorLabels was called.
+	legacyErrorNotice    sync.Once
+
+	samplerThreshold time.Duration
+	samplerTimeout   time.Duration
+	samplerFallback  bool
+
+	labelEncoder LabelEncoder // for SetLabelAny; nil means DefaultLabelEncoder.
+	labelFilter  LabelFilter  // for SetLabelFilter, or nil.
+
+	defaultLabels atomic.Value // map[string]string, for SetDefaultLabels.
+
+	exportTimeout   time.Duration
+	retryPolicy     UploadRetryPolicy
+	breakerFailures int // consecutive failures that open a breaker, or 0.
+	breakerCooldown time.Duration
+	breakersMu      sync.Mutex
+	breakers        map[string]*breaker // by exporter name, created when first used.
+
+	drain   drainer  // for Shutdown.
+	uploads uploads  // for Flush and Close.
+	spill   *spiller // for SetSpillDirectory, or nil.
+	dbPools dbPools  // for InstrumentDBPool.
+
+	stackFrames int // for SetStackTraces, or 0.
+
+	tuner *batchTuner // for SetBatchAutotuning, or nil.
+
+	ids IDGenerator // for SetIDGenerator, or nil.
+
+	runtimeTasks bool // for SetRuntimeTraceTasks.
+
+	logger    Logger    // for SetLogger, or nil.
+	configLog sync.Once // for the message logged by startRoot.
 }
 
-// NewClient creates a new Google Stackdriver Trace client.
+// defaultRequestHeaders are the headers SpanFromRequest reads trace context
+// from, in order of preference, unless SetRequestHeaders has been called.
+var defaultRequestHeaders = []string{httpHeader, legacyHTTPHeader}
+
+// NewClient creates a new Google Stackdriver Trace client.  It is
+// equivalent to calling NewClientWithExporter with the exporter returned by
+// NewStackdriverExporter.
 func NewClient(ctx context.Context, projectID string, opts ...option.ClientOption) (*Client, error) {
+	e, err := NewStackdriverExporter(ctx, projectID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithExporter(e), nil
+}
+
+// NewStackdriverExporter creates an Exporter that uploads spans to the
+// Google Stackdriver Trace API, in the project projectID unless a span
+// names another project.  It is the exporter of the clients created by
+// NewClient; use it to combine Stackdriver with other exporters, for
+// example with SetAdditionalExporter or RegisterExporter.
+func NewStackdriverExporter(ctx context.Context, projectID string, opts ...option.ClientOption) (*StackdriverExporter, error) {
 	o := []option.ClientOption{
 		option.WithScopes(cloudPlatformScope),
 		option.WithUserAgent(userAgent),
@@ -281,16 +376,18 @@ func NewClient(ctx context.Context, projectID string, opts ...option.ClientOptio
 		// An option set a basepath, so override api.New's default.
 		apiService.BasePath = basePath
 	}
-	c := &Client{
-		service:   apiService,
-		projectID: projectID,
-	}
-	bundler := bundler.NewBundler((*api.Trace)(nil), func(bundle interface{}) {
-		traces := bundle.([]*api.Trace)
-		err := c.upload(traces)
-		if err != nil {
-			log.Printf("failed to upload %d traces to the Cloud Trace server: %v", len(traces), err)
-		}
+	return &StackdriverExporter{service: apiService, projectID: projectID}, nil
+}
+
+// NewClientWithExporter creates a new trace client that uploads finished
+// traces using e instead of the Google Stackdriver Trace API.
+//
+// Sampling and bundling work the same way as for a client created by
+// NewClient.
+func NewClientWithExporter(e Exporter) *Client {
+	c := &Client{exporter: e}
+	bundler := bundler.NewBundler(([]*SpanData)(nil), func(bundle interface{}) {
+		c.uploadBundle(bundle.([][]*SpanData))
 	})
 	bundler.DelayThreshold = 2 * time.Second
 	bundler.BundleCountThreshold = 100
@@ -299,19 +396,156 @@ func NewClient(ctx context.Context, projectID string, opts ...option.ClientOptio
 	bundler.BundleByteLimit = 1000
 	bundler.BufferedByteLimit = 10000
 	c.bundler = bundler
-	return c, nil
+	return c
 }
 
 // SetSamplingPolicy sets the SamplingPolicy that determines how often traces
-// are initiated by this client.
+// are initiated by this client.  The policy doesn't decide on requests whose
+// trace headers force tracing with the o=1 option, or on spans created with
+// WithForcedTrace: they are always traced.
+//
+// Unlike the other settings of the client, the policy can be changed at any
+// time, for example to trace more requests while debugging an incident, even
+// while spans are being created: each new root span is configured by
+// either the old or the new policy.  To change the limits of a policy in
+// place, use an AdjustableSampler.  Package samplingconfig sets the policy
+// from a spec in a file or at a URL, whenever the spec changes.
 func (c *Client) SetSamplingPolicy(p SamplingPolicy) {
 	if c != nil {
-		c.policy = p
+		c.policy.Store(policyValue{p})
+	}
+}
+
+// A policyValue holds the sampling policy of a client, which may be nil, in
+// an atomic.Value, which needs values of the same concrete type.
+type policyValue struct {
+	p SamplingPolicy
+}
+
+// samplingPolicy returns the current sampling policy of c, or nil.
+func (c *Client) samplingPolicy() SamplingPolicy {
+	v, _ := c.policy.Load().(policyValue)
+	return v.p
+}
+
+// SetChildDurationRollup makes each span record the total duration of its
+// finished child spans, grouped by child span name, in labels of the form
+// "child_ms/<name>".  Durations are in milliseconds.
+//
+// At most maxNames distinct child names are recorded for each span; the
+// durations of children with any other name are added to "child_ms/other".
+// A maxNames of zero, the default, disables the rollup.
+//
+// SetChildDurationRollup should be called before any spans are created.
+func (c *Client) SetChildDurationRollup(maxNames int) {
+	if c != nil {
+		c.childRollup = maxNames
+	}
+}
+
+// SetErrorHandler sets a function that is called with errors that occur in
+// the background, such as failed uploads and spans that violate the limits
+// of the trace API (see ValidationError).  The function may be called
+// concurrently from multiple goroutines.
+//
+// If no handler is set, errors are logged using the logger set with
+// SetLogger, or if there is none, using the standard log package.
+func (c *Client) SetErrorHandler(f func(error)) {
+	if c != nil {
+		c.onError = f
+	}
+}
+
+// SetStrictValidation enables exhaustive checks of spans before they are
+// exported, in addition to the cheap checks that are always performed.
+// Strict validation checks label contents for invalid UTF-8, checks for
+// unset timestamps and for span IDs repeated within a trace.  Violations are
+// reported to the error handler.
+func (c *Client) SetStrictValidation(strict bool) {
+	if c != nil {
+		c.strict = strict
+	}
+}
+
+// SetRequestHeaders sets the names of the HTTP headers that SpanFromRequest
+// reads trace context from.  The headers are checked in order, and the first
+// one present in the request is parsed; the others are ignored, even if the
+// first is malformed.  The default is X-Cloud-Trace-Context, followed by the
+// legacy X-AppEngine-Trace-Context header set by old App Engine routing, so a
+// request carrying both uses X-Cloud-Trace-Context.  Calling
+// SetRequestHeaders with no names restores the default.
+//
+// The headers only affect incoming requests: outgoing requests made with
+// NewHTTPClient always carry X-Cloud-Trace-Context alone.
+func (c *Client) SetRequestHeaders(names ...string) {
+	if c == nil {
+		return
+	}
+	if len(names) == 0 {
+		c.requestHeaders = nil
+		return
 	}
+	c.requestHeaders = append([]string(nil), names...)
+}
+
+// requestHeader returns the value of the first header of r that is listed
+// by SetRequestHeaders, or "".
+func (c *Client) requestHeader(r *http.Request) string {
+	names := c.requestHeaders
+	if names == nil {
+		names = defaultRequestHeaders
+	}
+	for _, name := range names {
+		if h := r.Header.Get(name); h != "" {
+			return h
+		}
+	}
+	return ""
+}
+
+// Close stops the summary traces started by SetSummaryTraces, delivers the
+// outcomes queued for the sink set with SetOutcomeSink and stops calling it,
+// uploads the traces that are waiting to be bundled, like Flush, and closes
+// the Tee added by SetAdditionalExporter, if any.  The traces of the root
+// spans finished after Close are not uploaded: FinishWait returns ErrClosed
+// for them, and those finished by Finish are counted in the RejectedTraces
+// field of Stats.  The client can still create spans after Close, and
+// calling Close again does nothing.
+func (c *Client) Close() error {
+	if c == nil || !c.closeUploads() {
+		return nil
+	}
+	if c.summary != nil {
+		c.summary.stop()
+	}
+	if c.outcomes != nil {
+		c.outcomes.stop()
+	}
+	c.bundler.Flush()
+	if t, ok := c.exporter.(*Tee); ok {
+		t.Close()
+	}
+	return nil
+}
+
+func (c *Client) reportError(err error) {
+	if c.onError != nil {
+		c.onError(err)
+		return
+	}
+	if c.logger != nil {
+		c.logger.Errorf("%v", err)
+		return
+	}
+	log.Print(err)
 }
 
 // SpanFromHeader returns a new trace span, based on a provided request header
-// value. See https://cloud.google.com/trace/docs/faq.
+// value. See https://cloud.google.com/trace/docs/faq.  The header may also be
+// a W3C traceparent header, of the form 00-<trace ID>-<span ID>-<flags>, or a
+// single B3 header, of the form <trace ID>-<span ID>-<sampled>, whose sampled
+// flag enables tracing like the o=1 option of the Google header.  Whitespace
+// around the header, and around the fields of a Google header, is ignored.
 //
 // It returns nil iff the client is nil.
 //
@@ -320,8 +554,10 @@ func (c *Client) SetSamplingPolicy(p SamplingPolicy) {
 //
 // The name of the new span is provided as an argument.
 //
-// If a non-nil sampling policy has been set in the client, it can override
-// the options set in the header and choose whether to trace the request.
+// If the header has the o=1 option, the upstream service forced tracing,
+// and the request is traced whatever the client's sampling policy decides.
+// Otherwise, if a non-nil sampling policy has been set in the client, it
+// chooses whether to trace the request.
 //
 // If the header doesn't have existing tracing information, then a *Span is
 // returned anyway, but it will not be uploaded to the server, just as when
@@ -332,23 +568,59 @@ func (c *Client) SetSamplingPolicy(p SamplingPolicy) {
 // requests. In particular, it will set various pieces of request information
 // as labels on the *Span, which is not available from the header alone.
 func (c *Client) SpanFromHeader(name string, header string) *Span {
+	span, _ := c.SpanFromHeaderErr(name, header)
+	return span
+}
+
+// SpanFromHeaderErr is like SpanFromHeader, but also returns a *HeaderError
+// if the header could not be parsed, which wraps ErrEmptyHeader if the header
+// is empty.  The returned span is the same as SpanFromHeader's: if there is
+// an error, it starts a new trace.
+func (c *Client) SpanFromHeaderErr(name string, header string) (*Span, error) {
+	if c == nil {
+		return nil, nil
+	}
+	traceID, parentSpanID, options, extra, err := parseHeaderErr(header)
+	sc := SpanContext{TraceID: traceID, SpanID: parentSpanID, Traced: options&optionTrace != 0, options: options, extra: extra}
+	return c.spanFromContextErr(name, sc, err), err
+}
+
+// spanFromContextErr returns a new span for an incoming request that
+// propagated sc, or, if err is non-nil, a span for a new trace.  It returns
+// nil if c is nil.
+func (c *Client) spanFromContextErr(name string, sc SpanContext, err error) *Span {
 	if c == nil {
 		return nil
 	}
-	traceID, parentSpanID, options, ok := traceInfoFromHeader(header)
+	ok := err == nil
 	if !ok {
-		traceID = nextTraceID()
+		sc = SpanContext{TraceID: c.newTraceID()}
 	}
-	t := &trace{
-		traceID:       traceID,
-		client:        c,
-		globalOptions: options,
-		localOptions:  options,
-	}
-	span := startNewChild(name, t, parentSpanID)
+	span := c.newRemoteRoot(name, sc, ok)
+	c.startRoot(span, ok)
+	return span
+}
+
+// newRemoteRoot returns a new root span for an incoming request that
+// propagated sc, whose sampling decision is yet to be made.  remote reports
+// whether sc was read from a header.
+func (c *Client) newRemoteRoot(name string, sc SpanContext, remote bool) *Span {
+	return c.initRemoteRoot(new(Span), new(trace), name, sc, remote, time.Now())
+}
+
+// initRemoteRoot is like newRemoteRoot, but makes the span in span and its
+// trace in t, which must be new, and starts the span at start.
+func (c *Client) initRemoteRoot(span *Span, t *trace, name string, sc SpanContext, remote bool, start time.Time) *Span {
+	options := sc.flags()
+	t.traceID = sc.TraceID
+	t.client = c
+	t.extraOptions = sc.extra
+	t.remote = remote
+	t.remoteOptions = options
+	t.forced = remote && options&optionTrace != 0
+	initChild(span, name, t, sc.SpanID, traceOptions{global: options, local: options}, start)
 	span.span.Kind = spanKindServer
 	span.rootSpan = true
-	configureSpanFromPolicy(span, c.policy, ok)
 	return span
 }
 
@@ -357,11 +629,13 @@ func (c *Client) SpanFromHeader(name string, header string) *Span {
 // It returns nil iff the client is nil.
 //
 // If the incoming HTTP request contains a trace context header, the trace ID,
-// parent span ID, and tracing options will be read from that header.
-// Otherwise, a new trace ID is made and the parent span ID is zero.
+// parent span ID, and tracing options will be read from that header.  See
+// SetRequestHeaders for the headers that are checked.  Otherwise, a new trace
+// ID is made and the parent span ID is zero.
 //
-// If a non-nil sampling policy has been set in the client, it can override the
-// options set in the header and choose whether to trace the request.
+// As with SpanFromHeader, a header with the o=1 option forces tracing;
+// otherwise, if a non-nil sampling policy has been set in the client, it
+// chooses whether to trace the request.
 //
 // If the request is not being traced, then a *Span is returned anyway, but it
 // will not be uploaded to the server -- it is only useful for propagating
@@ -373,20 +647,22 @@ func (c *Client) SpanFromRequest(r *http.Request) *Span {
 	if c == nil {
 		return nil
 	}
-	traceID, parentSpanID, options, ok := traceInfoFromHeader(r.Header.Get(httpHeader))
+	traceID, parentSpanID, options, extra, ok := parseHeader(c.requestHeader(r))
 	if !ok {
-		traceID = nextTraceID()
+		traceID = c.newTraceID()
 	}
 	t := &trace{
 		traceID:       traceID,
 		client:        c,
-		globalOptions: options,
-		localOptions:  options,
+		extraOptions:  extra,
+		remote:        ok,
+		remoteOptions: options,
+		forced:        ok && options&optionTrace != 0,
 	}
-	span := startNewChildWithRequest(r, t, parentSpanID)
+	span := startNewChildWithRequest(r, t, parentSpanID, traceOptions{global: options, local: options})
 	span.span.Kind = spanKindServer
 	span.rootSpan = true
-	configureSpanFromPolicy(span, c.policy, ok)
+	c.startRoot(span, ok)
 	return span
 }
 
@@ -395,93 +671,218 @@ func (c *Client) SpanFromRequest(r *http.Request) *Span {
 // A new trace and span ID is generated to trace the span.
 // Returned span need to be finished by calling Finish or FinishWait.
 func (c *Client) NewSpan(name string) *Span {
+	return c.NewSpanWithOptions(name)
+}
+
+// NewSpanWithOptions is like NewSpan, but configures the span with opts.
+func (c *Client) NewSpanWithOptions(name string, opts ...SpanOption) *Span {
 	if c == nil {
 		return nil
 	}
 	t := &trace{
-		traceID:       nextTraceID(),
-		client:        c,
-		localOptions:  optionTrace,
-		globalOptions: optionTrace,
+		traceID: c.newTraceID(),
+		client:  c,
 	}
-	span := startNewChild(name, t, 0)
+	span := startNewChild(name, t, 0, traceOptions{global: optionTrace, local: optionTrace})
 	span.span.Kind = spanKindUnspecified
 	span.rootSpan = true
-	configureSpanFromPolicy(span, c.policy, false)
+	span.applyOptions(opts)
+	c.startRoot(span, false)
 	return span
 }
 
 func configureSpanFromPolicy(s *Span, p SamplingPolicy, ok bool) {
-	if p == nil {
+	if p == nil || s.trace.forced {
 		return
 	}
-	d := p.Sample(Parameters{HasTraceHeader: ok})
+	d, elapsed := s.trace.client.sample(p, Parameters{HasTraceHeader: ok, Name: s.span.Name, TraceID: s.trace.traceID})
+	configureSpanFromDecision(s, d, elapsed)
+}
+
+// configureSpanFromDecision sets up span s according to the sampling
+// decision d, which took elapsed to make if that was unusually long.
+func configureSpanFromDecision(s *Span, d Decision, elapsed time.Duration) {
 	if d.Trace {
 		// Turn on tracing locally, and in child requests.
-		s.trace.localOptions |= optionTrace
-		s.trace.globalOptions |= optionTrace
+		s.options.local |= optionTrace
+		s.options.global |= optionTrace
+	} else if c := s.trace.client; c.traceSlowSpans && c.minSpanDuration > 0 {
+		// Record the trace locally, for its slow spans.
+		s.options.local |= optionTrace
+		atomic.StoreInt32(&s.trace.slowOnly, 1)
+		return
 	} else {
 		// Turn off tracing locally.
-		s.trace.localOptions = 0
+		s.options.local = 0
 		return
 	}
 	if d.Sample {
 		// This trace is in the random sample, so set the labels.
-		s.SetLabel(labelSamplingPolicy, d.Policy)
-		s.SetLabel(labelSamplingWeight, fmt.Sprint(d.Weight))
+		s.weight = d.Weight
+		s.setLabel(labelSamplingPolicy, d.Policy)
+		s.setLabel(labelSamplingWeight, fmt.Sprint(d.Weight))
 	}
+	setSamplerLabel(s, elapsed)
 }
 
 // NewContext returns a derived context containing the span.
+//
+// If ctx already contains a span of a different trace, which usually means a
+// middleware replaced the span of a request by mistake, the replacement is
+// counted in the ReplacedSpans statistic of the client of s, and with strict
+// validation enabled, reported to its error handler.  The returned context
+// contains s all the same.
 func NewContext(ctx context.Context, s *Span) context.Context {
 	if s == nil {
 		return ctx
 	}
-	return context.WithValue(ctx, contextKey{}, s)
+	if old := FromContext(ctx); old != nil && old.trace.traceID != s.trace.traceID {
+		s.trace.client.recordReplacedSpan(old, s)
+	}
+	return &spanValueContext{Context: ctx, span: s}
 }
 
 // FromContext returns the span contained in the context, or nil.
 func FromContext(ctx context.Context) *Span {
+	switch c := ctx.(type) {
+	case *spanValueContext:
+		return c.span
+	}
 	s, _ := ctx.Value(contextKey{}).(*Span)
 	return s
 }
 
+// HasSpan reports whether ctx contains a span.
+func HasSpan(ctx context.Context) bool {
+	return FromContext(ctx) != nil
+}
+
+// recordReplacedSpan records that NewContext replaced old, a span of another
+// trace, with s.
+func (c *Client) recordReplacedSpan(old, s *Span) {
+	atomic.AddInt64(&c.stats.replacedSpans, 1)
+	if c.strict {
+		c.reportError(fmt.Errorf("trace: NewContext replaced a span of trace %s with a span of trace %s", old.trace.traceID, s.trace.traceID))
+	}
+}
+
 func traceInfoFromHeader(h string) (string, uint64, optionFlags, bool) {
+	traceID, spanID, options, _, ok := parseHeader(h)
+	return traceID, spanID, options, ok
+}
+
+// parseHeader parses a trace header.  Options after the span ID other than
+// "o" are returned in extra, exactly as they appeared in the header and
+// separated by semicolons, so that they can be passed on to child requests
+// by services that don't understand them.
+func parseHeader(h string) (traceID string, spanID uint64, options optionFlags, extra string, ok bool) {
+	traceID, spanID, options, extra, err := parseHeaderErr(h)
+	if err != nil {
+		return "", 0, 0, "", false
+	}
+	return traceID, spanID, options, extra, true
+}
+
+// Errors wrapped by a *HeaderError, identifying the part of a trace header
+// that could not be parsed.
+var (
+	ErrEmptyHeader      = errors.New("trace: empty trace header")
+	ErrHeaderTooLong    = errors.New("trace: trace header too long")
+	ErrMalformedTraceID = errors.New("trace: malformed trace ID in trace header")
+	ErrMalformedSpanID  = errors.New("trace: malformed span ID in trace header")
+	ErrMalformedOptions = errors.New("trace: malformed options in trace header")
+)
+
+// A HeaderError describes a trace header that could not be parsed.
+type HeaderError struct {
+	Header string
+	Err    error  // one of the ErrXxx errors above.
+	Detail string // the part of the header that failed, or why.
+}
+
+func (e *HeaderError) Error() string {
+	if e.Detail == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Err, e.Detail)
+}
+
+// Unwrap returns e.Err.
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+// parseHeaderErr is parseHeader, but returns a *HeaderError if the header
+// could not be parsed.
+func parseHeaderErr(h string) (traceID string, spanID uint64, options optionFlags, extra string, err error) {
 	// See https://cloud.google.com/trace/docs/faq for the header format.
 	// Return if the header is empty or missing, or if the header is unreasonably
-	// large, to avoid making unnecessary copies of a large string.
-	if h == "" || len(h) > 200 {
-		return "", 0, 0, false
+	// large, to avoid making unnecessary copies of a large string.  Proxies
+	// may add whitespace around the header and its fields, which is ignored.
+	h = strings.TrimSpace(h)
+	if h == "" {
+		return "", 0, 0, "", &HeaderError{Err: ErrEmptyHeader}
+	}
+	if len(h) > 200 {
+		return "", 0, 0, "", &HeaderError{Err: ErrHeaderTooLong, Detail: fmt.Sprintf("%d bytes", len(h))}
+	}
+	header := h
+	if isTraceparent(h) {
+		traceID, spanID, options, err = parseTraceparent(h)
+		return traceID, spanID, options, "", err
+	}
+	if isB3(h) {
+		traceID, spanID, options, err = parseB3(h)
+		return traceID, spanID, options, "", err
 	}
 
 	// Parse the trace id field.
 	slash := strings.Index(h, `/`)
 	if slash == -1 {
-		return "", 0, 0, false
+		return "", 0, 0, "", &HeaderError{Header: header, Err: ErrMalformedTraceID, Detail: "no '/' after trace ID"}
+	}
+	traceID, h = strings.TrimSpace(h[:slash]), h[slash+1:]
+	if !validTraceID(traceID) {
+		return "", 0, 0, "", &HeaderError{Header: header, Err: ErrMalformedTraceID, Detail: fmt.Sprintf("%q", traceID)}
 	}
-	traceID, h := h[:slash], h[slash+1:]
 
 	// Parse the span id field.
 	spanstr := h
 	semicolon := strings.Index(h, `;`)
 	if semicolon != -1 {
 		spanstr, h = h[:semicolon], h[semicolon+1:]
+	} else {
+		h = ""
 	}
-	spanID, err := strconv.ParseUint(spanstr, 10, 64)
-	if err != nil {
-		return "", 0, 0, false
+	spanstr = strings.TrimSpace(spanstr)
+	spanID, perr := strconv.ParseUint(spanstr, 10, 64)
+	if perr != nil {
+		return "", 0, 0, "", &HeaderError{Header: header, Err: ErrMalformedSpanID, Detail: fmt.Sprintf("%q", spanstr)}
 	}
 
-	// Parse the options field, options field is optional.
-	if !strings.HasPrefix(h, "o=") {
-		return traceID, spanID, 0, true
-	}
-	o, err := strconv.ParseUint(h[2:], 10, 64)
-	if err != nil {
-		return "", 0, 0, false
+	// Parse the options, which are all optional.
+	var unknown []string
+	for h != "" {
+		opt := h
+		if semicolon := strings.IndexByte(h, ';'); semicolon != -1 {
+			opt, h = h[:semicolon], h[semicolon+1:]
+		} else {
+			h = ""
+		}
+		opt = strings.TrimSpace(opt)
+		if !strings.HasPrefix(opt, "o=") {
+			if opt != "" {
+				unknown = append(unknown, opt)
+			}
+			continue
+		}
+		o, perr := strconv.ParseUint(opt[2:], 10, 64)
+		if perr != nil {
+			return "", 0, 0, "", &HeaderError{Header: header, Err: ErrMalformedOptions, Detail: fmt.Sprintf("%q", opt)}
+		}
+		options = optionFlags(o)
 	}
-	options := optionFlags(o)
-	return traceID, spanID, options, true
+	return traceID, spanID, options, strings.Join(unknown, ";"), nil
 }
 
 type optionFlags uint32
@@ -491,104 +892,368 @@ const (
 	optionStack
 )
 
+// traceOptions are the options of a span.  Each span has its own copy,
+// which its children inherit when they are created, so that changing the
+// options of one span doesn't affect the other spans of its trace.
+type traceOptions struct {
+	global optionFlags // options that will be passed to any child requests
+	local  optionFlags // options applied in this server
+}
+
+// A trace holds the state shared by the spans of a trace in this process.
+// Its client, traceID, extraOptions, remote and remoteOptions don't change
+// after it is created.
 type trace struct {
-	mu            sync.Mutex
 	client        *Client
 	traceID       string
-	globalOptions optionFlags // options that will be passed to any child requests
-	localOptions  optionFlags // options applied in this server
-	spans         []*Span     // finished spans for this trace.
+	extraOptions  string      // unrecognized header options, passed to child requests
+	remote        bool        // whether the trace was read from a trace header.
+	remoteOptions optionFlags // the options in the trace header, if remote.
+	forced        bool        // whether the header or WithForcedTrace forced tracing.
+	projectID     string      // the project that owns the trace, if not the client's.
+	labelCap      int         // maximum labels SetLabel stores per span, or 0.
+
+	finished finishQueue // finished spans for this trace.
+
+	mu          sync.Mutex
+	explicitIDs map[uint64]bool // span IDs set with WithSpanID.
+	flushed     int             // spans handed to the exporter, under mu; see recordFlush.
+	hasFlushed  bool            // whether recordFlush has been called, under mu.
+
+	// inherited holds the map[string]string of the labels set with
+	// SetInheritedLabel.  The map is replaced under mu, never modified, so
+	// that new spans read it without locking.
+	inherited atomic.Value
+
+	scratch scratch // values set by Incr and Put.
+
+	suppressed suppression // children that weren't uploaded.
+
+	// slowOnly is 1 if the trace is recorded only for its slow spans: see
+	// SetTraceSlowSpans.  ForceTrace sets it back to 0.
+	slowOnly int32
 }
 
-// finish appends s to t.spans.  If s is the root span, uploads the trace to the
-// server.
-func (t *trace) finish(s *Span, wait bool, opts ...FinishOption) error {
+// finish adds s to the finished spans of t.  If s is the root span, uploads
+// the trace to the server.
+func (t *trace) finish(s *Span, end time.Time, wait bool, opts ...FinishOption) error {
+	if !atomic.CompareAndSwapInt32(&s.finished, 0, 1) {
+		return nil
+	}
+	s.endTask()
+	atomic.AddInt64(&t.client.stats.spansFinished, 1)
+	s.mergeWrites()
+	s.finishProgress()
 	for _, o := range opts {
 		o.modifySpan(s)
 	}
-	s.end = time.Now()
-	t.mu.Lock()
-	t.spans = append(t.spans, s)
-	spans := t.spans
-	t.mu.Unlock()
+	s.spanMu.Lock()
+	clamped := end.Before(s.start)
+	if clamped {
+		end = s.start
+	}
+	s.end = end
+	name, d := s.span.Name, end.Sub(s.start)
+	s.spanMu.Unlock()
+	if clamped && t.client.strict {
+		t.client.reportError(&ValidationError{
+			TraceID:  t.traceID,
+			SpanName: name,
+			Problem:  "end time is before start time; set to the start time",
+			Fixed:    true,
+		})
+	}
+	if s.parent != nil && t.client.childRollup > 0 {
+		s.parent.addChildDuration(name, d, t.client.childRollup)
+	}
+	if s.rootSpan && !s.detached {
+		t.client.reportOutcome(s, end)
+	}
+	if !s.rootSpan && !t.admit(s, d) {
+		return nil
+	}
+	for _, p := range s.finishCheckpoints(end) {
+		t.finished.push(p)
+	}
 	if s.rootSpan {
+		t.scratch.flush(s)
+	}
+	if !s.rootSpan || t.keepRoot(d) {
+		t.finished.push(s)
+	}
+	if !s.rootSpan {
+		t.pushed(s)
+	}
+	if s.rootSpan {
+		// Children that finished before the root are in the queue; any
+		// that finish later are not uploaded.
+		spans := t.drain(s)
+		if len(spans) == 0 {
+			// Nothing of a trace recorded for its slow spans was kept.
+			t.client.endRoot(s)
+			return nil
+		}
 		if wait {
-			return t.client.upload([]*api.Trace{t.constructTrace(spans)})
+			defer t.client.endRoot(s)
+			if t.client.uploads.isClosed() {
+				atomic.AddInt64(&t.client.stats.rejectedTraces, 1)
+				return ErrClosed
+			}
+			t.recordFlush(newSpans(spans))
+			return t.client.export(t.constructTrace(spans))
 		}
+		if !t.client.uploads.begin() {
+			atomic.AddInt64(&t.client.stats.rejectedTraces, 1)
+			t.client.endRoot(s)
+			return nil
+		}
+		t.recordFlush(newSpans(spans))
 		go func() {
+			defer t.client.uploads.end()
 			tr := t.constructTrace(spans)
 			err := t.client.bundler.Add(tr, 1+len(spans))
-			if err == bundler.ErrOversizedItem {
-				err = t.client.upload([]*api.Trace{tr})
+			switch {
+			case err == nil:
+				atomic.AddInt64(&t.client.stats.spansBundled, int64(len(tr)))
+			case err == bundler.ErrOversizedItem:
+				err = t.client.exportOrSpill(tr)
+			case err == bundler.ErrOverflow && t.client.spill != nil:
+				t.client.spill.write(tr, false)
+				err = nil
+			default:
+				atomic.AddInt64(&t.client.stats.spansDropped, int64(len(tr)))
 			}
+			t.client.endRoot(s)
 			if err != nil {
-				log.Println("error uploading trace:", err)
+				t.client.reportError(fmt.Errorf("error uploading trace: %v", err))
 			}
 		}()
 	}
 	return nil
 }
 
-func (t *trace) constructTrace(spans []*Span) *api.Trace {
-	apiSpans := make([]*api.TraceSpan, len(spans))
+// constructTrace returns the data for the finished spans of t, sorted by
+// start time and then by span ID, so that every exporter gets the spans of
+// a trace in the same order however the goroutines that finished them were
+// scheduled.
+func (t *trace) constructTrace(spans []*Span) []*SpanData {
+	data := make([]*SpanData, len(spans))
+	defaults := t.client.loadDefaultLabels()
 	for i, sp := range spans {
-		sp.span.StartTime = sp.start.In(time.UTC).Format(time.RFC3339Nano)
-		sp.span.EndTime = sp.end.In(time.UTC).Format(time.RFC3339Nano)
-		if t.localOptions&optionStack != 0 {
+		if sp.stack[0] != 0 {
 			sp.setStackLabel()
 		}
-		sp.SetLabel(labelHost, sp.host)
-		sp.SetLabel(labelURL, sp.url)
-		sp.SetLabel(labelMethod, sp.method)
+		sp.setLabel(labelHost, sp.host)
+		sp.setLabel(labelURL, sp.url)
+		sp.setLabel(labelMethod, sp.method)
 		if sp.statusCode != 0 {
-			sp.SetLabel(labelStatusCode, strconv.Itoa(sp.statusCode))
+			sp.setLabel(labelStatusCode, strconv.Itoa(sp.statusCode))
+		}
+		sp.setChildDurationLabels()
+		if n := atomic.LoadInt32(&sp.labelsDropped); n > 0 {
+			sp.setLabel(labelDroppedLabels, strconv.Itoa(int(n)))
 		}
-		apiSpans[i] = &sp.span
+		data[i] = sp.data()
+		addDefaultLabels(data[i], defaults)
 	}
+	sort.SliceStable(data, func(i, j int) bool {
+		if !data[i].Start.Equal(data[j].Start) {
+			return data[i].Start.Before(data[j].Start)
+		}
+		return data[i].SpanID < data[j].SpanID
+	})
+	return data
+}
+
+// data returns a snapshot of s for export.
+func (s *Span) data() *SpanData {
+	s.spanMu.Lock()
+	defer s.spanMu.Unlock()
+	d := &SpanData{
+		TraceID:      s.trace.traceID,
+		ProjectID:    s.trace.projectID,
+		SpanID:       s.span.SpanId,
+		ParentSpanID: s.span.ParentSpanId,
+		Name:         s.span.Name,
+		Kind:         kindFromAPI(s.span.Kind),
+		Start:        s.start,
+		End:          s.end,
+
+		NameTruncatedBytes: s.nameTruncated,
 
-	return &api.Trace{
-		ProjectId: t.client.projectID,
-		TraceId:   t.traceID,
-		Spans:     apiSpans,
+		Annotations:        s.annotations.copy(),
+		DroppedAnnotations: s.annotations.dropped,
+
+		MessageEvents:        s.messages.copy(),
+		DroppedMessageEvents: s.messages.dropped,
+
+		Links: append([]Link(nil), s.links...),
 	}
+	if len(s.span.Labels) > 0 {
+		d.Labels = make(map[string]string, len(s.span.Labels))
+		for k, v := range s.span.Labels {
+			d.Labels[k] = v
+		}
+	}
+	return d
 }
 
-func (c *Client) upload(traces []*api.Trace) error {
-	_, err := c.service.Projects.PatchTraces(c.projectID, &api.Traces{Traces: traces}).Do()
+func (c *Client) export(spans []*SpanData) error {
+	if spans = c.prepare(spans); len(spans) == 0 {
+		return nil
+	}
+	err := c.exportRouted(context.Background(), spans)
+	c.recordUpload(len(spans), err)
 	return err
 }
 
+// prepare applies the client's processors, label filter and validation to
+// spans, before they are exported, and returns the spans to export.
+func (c *Client) prepare(spans []*SpanData) []*SpanData {
+	c.process(spans)
+	c.filterLabels(spans)
+	if spans = c.validate(spans); len(spans) == 0 {
+		return nil
+	}
+	if c.cardinality != nil {
+		for _, err := range c.cardinality.observe(spans) {
+			c.reportError(err)
+		}
+	}
+	if c.summary != nil {
+		c.summary.record(spans)
+	}
+	return spans
+}
+
+// A StackdriverExporter is an Exporter that uploads spans to the Google
+// Stackdriver Trace API.  Create one with NewStackdriverExporter.
+type StackdriverExporter struct {
+	service   *api.Service
+	projectID string
+}
+
+// Describe implements Describer.
+func (e *StackdriverExporter) Describe() string {
+	return fmt.Sprintf("Stackdriver exporter for project %q", e.projectID)
+}
+
+// ExportSpans uploads spans, with one request per project.
+func (e *StackdriverExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	// Spans are uploaded with one request per project, in the order the
+	// projects first appear.
+	type traceKey struct{ project, traceID string }
+	var projects []string
+	byProject := make(map[string][]*api.Trace)
+	byID := make(map[traceKey]*api.Trace)
+	for _, s := range spans {
+		project := s.ProjectID
+		if project == "" {
+			project = e.projectID
+		}
+		key := traceKey{project, s.TraceID}
+		t := byID[key]
+		if t == nil {
+			t = &api.Trace{ProjectId: project, TraceId: s.TraceID}
+			byID[key] = t
+			if _, ok := byProject[project]; !ok {
+				projects = append(projects, project)
+			}
+			byProject[project] = append(byProject[project], t)
+		}
+		t.Spans = append(t.Spans, &api.TraceSpan{
+			Kind:         s.Kind.apiKind(),
+			Name:         s.Name,
+			SpanId:       s.SpanID,
+			ParentSpanId: s.ParentSpanID,
+			StartTime:    s.Start.In(time.UTC).Format(time.RFC3339Nano),
+			EndTime:      s.End.In(time.UTC).Format(time.RFC3339Nano),
+			Labels:       annotationLabels(s.Labels, s.Annotations),
+		})
+	}
+	var firstErr error
+	for _, project := range projects {
+		_, err := e.service.Projects.PatchTraces(project, &api.Traces{Traces: byProject[project]}).Context(ctx).Do()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 // Span contains information about one span of a trace.
 type Span struct {
 	trace *trace
 
-	spanMu sync.Mutex // guards span.Labels
+	spanMu sync.Mutex // guards span.Name, span.Labels, end, childDurations, annotations, messages, checkpoints and links
 	span   api.TraceSpan
 
-	start      time.Time
-	end        time.Time
-	rootSpan   bool
-	stack      [maxStackFrames]uintptr
-	host       string
-	method     string
-	url        string
-	statusCode int
+	parent         *Span // nil for spans created by the Client.
+	options        traceOptions
+	childDurations map[string]time.Duration
+	annotations    annotations
+	messages       messageEvents
+	labelCount     int32 // len(span.Labels), for lock-free reads by SetLabel.
+	labelsDropped  int32 // labels dropped by SetLabel because of the cap.
+	start          time.Time
+	end            time.Time
+	rootSpan       bool
+	detached       bool // created by NewDetachedChild: a local root, but not of a request.
+	stack          [maxStackFrames]uintptr
+	host           string
+	method         string
+	url            string
+	statusCode     int
+	weight         float64         // the sample weight, if the trace is in the random sample.
+	failed         int32           // 1 once an error status is recorded, for the span's Outcome.
+	enforced       *enforcedFinish // nil unless set by WithEnforcedFinish.
+	inFlight       int32           // 1 while a traced root span is counted by its client's drainer.
+	writes         *writeBuffer    // nil unless created with BufferedWrites.
+	nameTruncated  int             // bytes removed from the name given to SetName.
+
+	checkpoints     []checkpoint // phases recorded by Checkpoint.
+	checkpointSpans bool         // set by CheckpointSpans.
+	links           []Link       // set by linkResponseTrace.
+
+	buffered int32 // 1 while counted towards the client's SetMaxBufferedSpans limit.
+	finished int32 // 1 once the span has been finished; later calls of finish do nothing.
+
+	progressUploaded int32 // 1 once UploadProgress has uploaded the span.
+
+	task    *rtrace.Task    // nil unless created by startTask.
+	taskCtx context.Context // the context of task.
 }
 
 func (s *Span) tracing() bool {
-	return s.trace.localOptions&optionTrace != 0
+	return s.loadOptions().local&optionTrace != 0
+}
+
+// loadOptions returns the options of s.  They are loaded atomically, since
+// ForceTrace may change them while s is in use.
+func (s *Span) loadOptions() traceOptions {
+	return traceOptions{
+		global: optionFlags(atomic.LoadUint32((*uint32)(&s.options.global))),
+		local:  optionFlags(atomic.LoadUint32((*uint32)(&s.options.local))),
+	}
 }
 
-// NewChild creates a new span with the given name as a child of s.
+// NewChild creates a new span with the given name as a child of s, configured
+// by opts.
 // If s is nil, does nothing and returns nil.
-func (s *Span) NewChild(name string) *Span {
+func (s *Span) NewChild(name string, opts ...SpanOption) *Span {
 	if s == nil {
 		return nil
 	}
 	if !s.tracing() {
-		return s
+		return s.untracedChild()
 	}
-	return startNewChild(name, s.trace, s.span.SpanId)
+	newSpan := startNewChild(name, s.trace, s.span.SpanId, s.loadOptions())
+	s.trace.client.stats.spansStarted.add(newSpan.span.SpanId, 1)
+	newSpan.parent = s
+	newSpan.inherit()
+	newSpan.applyOptions(opts)
+	newSpan.startTask()
+	return newSpan
 }
 
 // NewRemoteChild creates a new span as a child of s.
@@ -597,11 +1262,12 @@ func (s *Span) NewChild(name string) *Span {
 //
 // A header is set in r so that the trace context is propagated to the
 // destination.  The parent span ID in that header is set as follows:
-// - If the request is being traced, then the ID of s is used.
-// - If the request is not being traced, but there was a trace context header
-//   in the incoming request for this trace (the request passed to
-//   SpanFromRequest), the parent span ID in that header is used.
-// - Otherwise, the parent span ID is zero.
+//   - If the request is being traced, then the ID of s is used.
+//   - If the request is not being traced, but there was a trace context header
+//     in the incoming request for this trace (the request passed to
+//     SpanFromRequest), the parent span ID in that header is used.
+//   - Otherwise, the parent span ID is zero.
+//
 // The tracing bit in the options is set if tracing is enabled, or if it was
 // set in the incoming request.
 //
@@ -611,31 +1277,46 @@ func (s *Span) NewRemoteChild(r *http.Request) *Span {
 		return nil
 	}
 	if !s.tracing() {
-		r.Header[httpHeader] = []string{spanHeader(s.trace.traceID, s.span.ParentSpanId, s.trace.globalOptions)}
-		return s
+		r.Header[httpHeader] = []string{s.header(s.span.ParentSpanId)}
+		return s.untracedChild()
 	}
-	newSpan := startNewChildWithRequest(r, s.trace, s.span.SpanId)
-	r.Header[httpHeader] = []string{spanHeader(s.trace.traceID, newSpan.span.SpanId, s.trace.globalOptions)}
+	newSpan := startNewChildWithRequest(r, s.trace, s.span.SpanId, s.loadOptions())
+	s.trace.client.stats.spansStarted.add(newSpan.span.SpanId, 1)
+	newSpan.parent = s
+	newSpan.inherit()
+	newSpan.startTask()
+	r.Header[httpHeader] = []string{newSpan.header(newSpan.span.SpanId)}
 	return newSpan
 }
 
 // Header returns the value of the X-Cloud-Trace-Context header that
-// should be used to propagate the span.  This is the inverse of
-// SpanFromHeader.
+// should be used to propagate the span: the header the client interceptors
+// send with calls made in a context containing the span, without the
+// changes made by WithoutPropagation and WithDecoyPropagation, which apply
+// to contexts.  Client.SpanFromHeader is its inverse: a span it returns for
+// the header is in the same trace as s, with s as its parent if s is
+// traced.  Use Header to propagate traces in other protocols, for example
+// in a message attribute or an environment variable.
 //
 // Most users should use NewRemoteChild unless they have specific
 // propagation needs or want to control the naming of their span.
-// Header() does not create a new span.
+// Header() does not create a new span.  It returns "" if s is nil.
 func (s *Span) Header() string {
 	if s == nil {
 		return ""
 	}
-	return spanHeader(s.trace.traceID, s.span.SpanId, s.trace.globalOptions)
+	if !s.tracing() && s.span.ParentSpanId != 0 {
+		// Like NewChild and the interceptors, propagate the incoming
+		// header's span ID, since s won't be uploaded.
+		return s.header(s.span.ParentSpanId)
+	}
+	return s.header(s.span.SpanId)
 }
 
-func startNewChildWithRequest(r *http.Request, trace *trace, parentSpanID uint64) *Span {
+func startNewChildWithRequest(r *http.Request, trace *trace, parentSpanID uint64, options traceOptions) *Span {
 	name := r.URL.Host + r.URL.Path // drop scheme and query params
-	newSpan := startNewChild(name, trace, parentSpanID)
+	newSpan := startNewChild(name, trace, parentSpanID, options)
+	newSpan.span.Kind = spanKindClient
 	if r.Host == "" {
 		newSpan.host = r.URL.Host
 	} else {
@@ -646,25 +1327,75 @@ func startNewChildWithRequest(r *http.Request, trace *trace, parentSpanID uint64
 	return newSpan
 }
 
-func startNewChild(name string, trace *trace, parentSpanID uint64) *Span {
-	spanID := nextSpanID()
+func startNewChild(name string, trace *trace, parentSpanID uint64, options traceOptions) *Span {
+	newSpan := new(Span)
+	initChild(newSpan, name, trace, parentSpanID, options, time.Now())
+	return newSpan
+}
+
+// initChild is like startNewChild, but makes the span in newSpan, which must
+// be new, and starts it at start.
+func initChild(newSpan *Span, name string, trace *trace, parentSpanID uint64, options traceOptions, start time.Time) {
+	spanID := trace.client.newSpanID()
 	for spanID == parentSpanID {
+		// Don't ask the client's generator again: it may return the same ID.
 		spanID = nextSpanID()
 	}
-	newSpan := &Span{
-		trace: trace,
-		span: api.TraceSpan{
-			Kind:         spanKindClient,
-			Name:         name,
-			ParentSpanId: parentSpanID,
-			SpanId:       spanID,
-		},
-		start: time.Now(),
+	newSpan.trace = trace
+	newSpan.span.Kind = spanKindUnspecified
+	newSpan.span.Name = name
+	newSpan.span.ParentSpanId = parentSpanID
+	newSpan.span.SpanId = spanID
+	newSpan.options = options
+	newSpan.start = start
+	if options.local&optionStack != 0 || trace.client.stackFrames > 0 && options.local&optionTrace != 0 {
+		// Skip runtime.Callers and initChild.
+		_ = runtime.Callers(2, newSpan.stack[:])
 	}
-	if trace.localOptions&optionStack != 0 {
-		_ = runtime.Callers(1, newSpan.stack[:])
+}
+
+// Elapsed returns the time since s started or, if s has finished, its total
+// duration.
+//
+// If the request is not being traced, child spans are the same *Span as their
+// parent, so Elapsed returns the time since the untraced root span started.
+// If s is nil, Elapsed returns zero.
+func (s *Span) Elapsed() time.Duration {
+	if s == nil {
+		return 0
+	}
+	s.spanMu.Lock()
+	start, end := s.start, s.end
+	s.spanMu.Unlock()
+	if end.IsZero() {
+		return time.Since(start)
+	}
+	return end.Sub(start)
+}
+
+// addChildDuration adds d to the total duration of s's children named name.
+func (s *Span) addChildDuration(name string, d time.Duration, maxNames int) {
+	s.spanMu.Lock()
+	defer s.spanMu.Unlock()
+	if s.childDurations == nil {
+		s.childDurations = make(map[string]time.Duration)
+	}
+	if _, ok := s.childDurations[name]; !ok && len(s.childDurations) >= maxNames {
+		name = childRollupOther
+	}
+	s.childDurations[name] += d
+}
+
+func (s *Span) setChildDurationLabels() {
+	s.spanMu.Lock()
+	labels := make(map[string]string, len(s.childDurations))
+	for name, d := range s.childDurations {
+		labels[labelChildDuration+name] = strconv.FormatInt(int64(d/time.Millisecond), 10)
+	}
+	s.spanMu.Unlock()
+	for k, v := range labels {
+		s.setLabel(k, v)
 	}
-	return newSpan
 }
 
 // TraceID returns the ID of the trace to which s belongs.
@@ -675,13 +1406,103 @@ func (s *Span) TraceID() string {
 	return s.trace.traceID
 }
 
+// SpanID returns the ID of s, the SpanID of its SpanData, or zero if s is
+// nil.  The ID doesn't change once s is created.  The children of an untraced
+// span are usually the span itself, and then have its ID.  Log entries
+// linked to s, as written by package tracelog, carry it as 16 hex digits:
+//
+//   spanID := fmt.Sprintf("%016x", span.SpanID())
+func (s *Span) SpanID() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.span.SpanId
+}
+
+// Kind returns the kind of s: SpanKindServer for the span of an incoming
+// request, SpanKindClient for the span of an outgoing one, and unless set
+// with WithSpanKind, SpanKindUnspecified for other spans, such as those
+// created by NewSpan and NewChild.  It returns SpanKindUnspecified if s is
+// nil.
+func (s *Span) Kind() SpanKind {
+	if s == nil {
+		return SpanKindUnspecified
+	}
+	return kindFromAPI(s.span.Kind)
+}
+
+// ParentSpanID returns the ID of the parent of s, the ParentSpanID of its
+// SpanData: the ID of the span that created s with NewChild, or of the span
+// of the caller, from the incoming trace header, for the span of an incoming
+// request.  It returns zero if s has no parent, or is nil.
+func (s *Span) ParentSpanID() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.span.ParentSpanId
+}
+
+// UnknownHeaderOptions returns the options of the incoming trace header of
+// s's trace that this package doesn't understand, such as "v=2" in
+// "105445aa7843bc8bf206b120001000/1;o=1;v=2", keyed by option name.  An
+// option without a value has an empty value.  These options are passed on
+// unchanged in the headers of child requests.
+//
+// It returns nil if there are no such options.
+func (s *Span) UnknownHeaderOptions() map[string]string {
+	if s == nil || s.trace.extraOptions == "" {
+		return nil
+	}
+	opts := make(map[string]string)
+	for _, opt := range strings.Split(s.trace.extraOptions, ";") {
+		if i := strings.Index(opt, "="); i != -1 {
+			opts[opt[:i]] = opt[i+1:]
+		} else {
+			opts[opt] = ""
+		}
+	}
+	return opts
+}
+
+// SetName renames s to name.  It's useful when a better name than the one
+// the span was created with, such as the gRPC method, is known only once
+// the request has been inspected.  Names longer than the span name limit
+// of the Stackdriver Trace API are truncated, and the removed bytes are
+// counted in the NameTruncatedBytes field of the span's SpanData.
+// If s is nil, does nothing.
+//
+// SetName doesn't change the sampling decision, which was made with the
+// span's original name.  It may be called concurrently with the other
+// methods of s, but shouldn't be called after Finish or FinishWait.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	if !s.tracing() {
+		return
+	}
+	short := truncate(name, maxSpanNameBytes)
+	s.spanMu.Lock()
+	s.span.Name = short
+	s.nameTruncated = len(name) - len(short)
+	s.spanMu.Unlock()
+}
+
 // SetLabel sets the label for the given key to the given value.
 // If the value is empty, the label for that key is deleted.
 // If a label is given a value automatically and by SetLabel, the
 // automatically-set value is used.
 // If s is nil, does nothing.
 //
-// SetLabel shouldn't be called after Finish or FinishWait.
+// If the span belongs to a request traced by a server interceptor created
+// with WithLabelCap, and already has the maximum number of labels, SetLabel
+// drops the value without storing it.
+//
+// The labels of a span created with BufferedWrites are stored when it
+// finishes; see BufferedWrites.
+//
+// SetLabel is safe for concurrent use, including concurrently with Finish,
+// but labels set after Finish or FinishWait may not be uploaded.
 func (s *Span) SetLabel(key, value string) {
 	if s == nil {
 		return
@@ -689,18 +1510,53 @@ func (s *Span) SetLabel(key, value string) {
 	if !s.tracing() {
 		return
 	}
+	if s.writes.setLabel(key, value, false) {
+		return
+	}
+	s.setCappedLabel(key, value)
+}
+
+// setCappedLabel is SetLabel for a traced span, without buffering.
+func (s *Span) setCappedLabel(key, value string) {
+	if max := s.trace.labelCap; max > 0 && value != "" && atomic.LoadInt32(&s.labelCount) >= int32(max) {
+		s.dropLabel(max)
+		return
+	}
+	s.setLabel(key, value)
+}
+
+// setLabel is SetLabel without the cap of WithLabelCap.  The package uses it
+// for the labels it sets automatically.
+func (s *Span) setLabel(key, value string) {
+	if s == nil {
+		return
+	}
+	if !s.tracing() {
+		return
+	}
+	if s.writes.setLabel(key, value, true) {
+		return
+	}
 	s.spanMu.Lock()
-	defer s.spanMu.Unlock()
+	s.storeLabel(key, value)
+	s.spanMu.Unlock()
+}
 
+// storeLabel stores a label of s.  s.spanMu must be held.
+func (s *Span) storeLabel(key, value string) {
 	if value == "" {
-		if s.span.Labels != nil {
+		if _, ok := s.span.Labels[key]; ok {
 			delete(s.span.Labels, key)
+			atomic.AddInt32(&s.labelCount, -1)
 		}
 		return
 	}
 	if s.span.Labels == nil {
 		s.span.Labels = make(map[string]string)
 	}
+	if _, ok := s.span.Labels[key]; !ok {
+		atomic.AddInt32(&s.labelCount, 1)
+	}
 	s.span.Labels[key] = value
 }
 
@@ -735,26 +1591,45 @@ func (u withResponse) modifySpan(s *Span) {
 // If s is a root span (one created by SpanFromRequest) then s, and all its
 // descendant spans that have finished, are uploaded to the Google Stackdriver
 // Trace server asynchronously.
+//
+// If s is the span of an incoming call traced by an interceptor created with
+// WithEnforcedFinish, the interceptor finishes s when the handler returns,
+// and Finish only records the time at which it was first called.
+//
+// Finish may be called more than once, and concurrently; only the first of
+// the calls of Finish, FinishWait and FinishAt on a span finishes it, and the
+// others do nothing.
 func (s *Span) Finish(opts ...FinishOption) {
 	if s == nil {
 		return
 	}
 	if !s.tracing() {
+		s.finishUntraced(time.Now(), opts)
 		return
 	}
-	s.trace.finish(s, false, opts...)
+	if s.enforced != nil {
+		s.deferFinish(time.Now(), opts)
+		return
+	}
+	s.trace.finish(s, time.Now(), false, opts...)
 }
 
 // FinishWait is like Finish, but if s is a root span, it waits until uploading
-// is finished, then returns an error if one occurred.
+// is finished, then returns an error if one occurred.  If s had already been
+// finished, FinishWait returns nil without waiting.
 func (s *Span) FinishWait(opts ...FinishOption) error {
 	if s == nil {
 		return nil
 	}
 	if !s.tracing() {
+		s.finishUntraced(time.Now(), opts)
+		return nil
+	}
+	if s.enforced != nil {
+		s.deferFinish(time.Now(), opts)
 		return nil
 	}
-	return s.trace.finish(s, true, opts...)
+	return s.trace.finish(s, time.Now(), true, opts...)
 }
 
 func spanHeader(traceID string, spanID uint64, options optionFlags) string {
@@ -762,11 +1637,31 @@ func spanHeader(traceID string, spanID uint64, options optionFlags) string {
 	return fmt.Sprintf("%s/%d;o=%d", traceID, spanID, options)
 }
 
+// header returns the header to send to a child request of s, with the
+// given parent span ID, including the options of the incoming header that
+// this package doesn't understand.
+func (s *Span) header(spanID uint64) string {
+	h := spanHeader(s.trace.traceID, spanID, s.loadOptions().global)
+	if s.trace.extraOptions != "" {
+		h += ";" + s.trace.extraOptions
+	}
+	return h
+}
+
+// setStackLabel sets the stack trace label of s from the stack captured
+// when s was created, or by CaptureStack.  The frames of this package at
+// the top of the stack are skipped, so the first frame is the caller's,
+// and frames are dropped from the bottom to keep the label within the
+// client's depth limit and maxStackLabelBytes.
 func (s *Span) setStackLabel() {
+	depth := s.trace.client.stackFrames
+	if depth <= 0 || depth > maxStackFrames {
+		depth = maxStackFrames
+	}
 	var stack stackLabelValue
 	lastSigPanic, inTraceLibrary := false, true
 	for _, pc := range s.stack {
-		if pc == 0 {
+		if pc == 0 || len(stack.Frames) == depth {
 			break
 		}
 		if !lastSigPanic {
@@ -784,6 +1679,10 @@ func (s *Span) setStackLabel() {
 		if inTraceLibrary && !strings.HasPrefix(name, "cloud.google.com/go/trace.") {
 			inTraceLibrary = false
 		}
+		lastSigPanic = name == "runtime.sigpanic"
+		if inTraceLibrary {
+			continue
+		}
 		var class string
 		if i := strings.Index(name, ")."); i != -1 {
 			class, name = name[:i+1], name[i+2:]
@@ -794,14 +1693,17 @@ func (s *Span) setStackLabel() {
 			Filename: file,
 			Line:     int64(line),
 		}
-		if inTraceLibrary && len(stack.Frames) == 1 {
-			stack.Frames[0] = frame
-		} else {
-			stack.Frames = append(stack.Frames, frame)
-		}
-		lastSigPanic = fn.Name() == "runtime.sigpanic"
+		stack.Frames = append(stack.Frames, frame)
 	}
-	if label, err := json.Marshal(stack); err == nil {
-		s.SetLabel(labelStackTrace, string(label))
+	for {
+		label, err := json.Marshal(stack)
+		if err != nil {
+			return
+		}
+		if len(label) <= maxStackLabelBytes || len(stack.Frames) <= 1 {
+			s.setLabel(labelStackTrace, string(label))
+			return
+		}
+		stack.Frames = stack.Frames[:len(stack.Frames)-1]
 	}
 }
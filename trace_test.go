@@ -15,6 +15,7 @@
 package trace
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -31,13 +32,13 @@ import (
 	"cloud.google.com/go/datastore"
 	"cloud.google.com/go/internal/testutil"
 	"cloud.google.com/go/storage"
-	"golang.org/x/net/context"
 	api "google.golang.org/api/cloudtrace/v1"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	dspb "google.golang.org/genproto/googleapis/datastore/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 const testProjectID = "testproject"
@@ -229,6 +230,20 @@ func TestHeader(t *testing.T) {
 			wantOpts:    0,
 			wantOK:      false,
 		},
+		{
+			header:      " 0123456789ABCDEF0123456789ABCDEF/1;o=1\t",
+			wantTraceID: "0123456789ABCDEF0123456789ABCDEF",
+			wantSpanID:  1,
+			wantOpts:    1,
+			wantOK:      true,
+		},
+		{
+			header:      "0123456789ABCDEF0123456789ABCDEF / 1 ; o=1",
+			wantTraceID: "0123456789ABCDEF0123456789ABCDEF",
+			wantSpanID:  1,
+			wantOpts:    1,
+			wantOK:      true,
+		},
 	}
 	for _, tt := range tests {
 		traceID, parentSpanID, opts, ok := traceInfoFromHeader(tt.header)
@@ -247,6 +262,269 @@ func TestHeader(t *testing.T) {
 	}
 }
 
+func TestHeaderUnknownOptions(t *testing.T) {
+	tests := []struct {
+		header    string
+		wantOpts  optionFlags
+		wantExtra string
+		wantOK    bool
+	}{
+		{"0123456789ABCDEF0123456789ABCDEF/1;o=1;v=2", 1, "v=2", true},
+		{"0123456789ABCDEF0123456789ABCDEF/1;v=2;o=1", 1, "v=2", true},
+		{"0123456789ABCDEF0123456789ABCDEF/1;o=1;v=2;x-Future=a,b", 1, "v=2;x-Future=a,b", true},
+		{"0123456789ABCDEF0123456789ABCDEF/1;v=2", 0, "v=2", true},
+		{"0123456789ABCDEF0123456789ABCDEF/1;o=1;flag", 1, "flag", true},
+		{"0123456789ABCDEF0123456789ABCDEF/1;o=1", 1, "", true},
+		{"0123456789ABCDEF0123456789ABCDEF/1;o=x;v=2", 0, "", false},
+	}
+	for _, tt := range tests {
+		_, _, opts, extra, ok := parseHeader(tt.header)
+		if opts != tt.wantOpts || extra != tt.wantExtra || ok != tt.wantOK {
+			t.Errorf("parseHeader(%q) = options %v, extra %q, ok %t; want %v, %q, %t",
+				tt.header, opts, extra, ok, tt.wantOpts, tt.wantExtra, tt.wantOK)
+		}
+	}
+}
+
+func TestSpanFromHeaderErr(t *testing.T) {
+	tc := newTestClient(nil)
+	for _, tt := range []struct {
+		header string
+		want   error // the error wrapped by the *HeaderError, or nil.
+	}{
+		{"0123456789abcdef0123456789abcdef/1;o=1", nil},
+		{"0123456789abcdef0123456789abcdef/0", nil},
+		{"", ErrEmptyHeader},
+		{strings.Repeat("0", 201), ErrHeaderTooLong},
+		{"0123456789abcdef0123456789abcdef", ErrMalformedTraceID},
+		{"0123456789abcdef/1;o=1", ErrMalformedTraceID},
+		{"0123456789abcdefg123456789abcdef/1;o=1", ErrMalformedTraceID},
+		{"00000000000000000000000000000000/1;o=1", ErrMalformedTraceID},
+		{"0123456789abcdef0123456789abcdef/", ErrMalformedSpanID},
+		{"0123456789abcdef0123456789abcdef/-1;o=1", ErrMalformedSpanID},
+		{"0123456789abcdef0123456789abcdef/x;o=1", ErrMalformedSpanID},
+		{"0123456789abcdef0123456789abcdef/1;o=yes", ErrMalformedOptions},
+		{" \t", ErrEmptyHeader},
+		{"/1;o=1", ErrMalformedTraceID},
+		{"0123456789abcdef 0123456789abcdef/1;o=1", ErrMalformedTraceID},
+		{"0123456789abcdef0123456789abcdeg/1;o=1", ErrMalformedTraceID},
+		{"0123456789abcdef0123456789abcdef/18446744073709551616", ErrMalformedSpanID},
+		{"0123456789abcdef0123456789abcdef/1/2;o=1", ErrMalformedSpanID},
+		{"0123456789abcdef0123456789abcdef/1 2;o=1", ErrMalformedSpanID},
+		{"0123456789abcdef0123456789abcdef/;o=1", ErrMalformedSpanID},
+		{"0123456789abcdef0123456789abcdef/1;o=", ErrMalformedOptions},
+		// Two headers joined into one.
+		{"0123456789abcdef0123456789abcdef/1;o=10123456789abcdef0123456789abcdef/2;o=1", ErrMalformedOptions},
+	} {
+		span, err := tc.SpanFromHeaderErr("/foo", tt.header)
+		if span == nil {
+			t.Errorf("SpanFromHeaderErr(%q) returned a nil span", tt.header)
+			continue
+		}
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("SpanFromHeaderErr(%q): got error %v; want nil", tt.header, err)
+			}
+			continue
+		}
+		herr, ok := err.(*HeaderError)
+		if !ok || herr.Err != tt.want {
+			t.Errorf("SpanFromHeaderErr(%q): got error %v; want a *HeaderError wrapping %v", tt.header, err, tt.want)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.want.Error()) {
+			t.Errorf("error message %q does not start with %q", err, tt.want)
+		}
+		if got := span.TraceID(); got == "" || strings.HasPrefix(tt.header, got) {
+			t.Errorf("SpanFromHeaderErr(%q): trace ID %q; want a new trace ID", tt.header, got)
+		}
+	}
+	var nilClient *Client
+	if span, err := nilClient.SpanFromHeaderErr("/foo", "garbage"); span != nil || err != nil {
+		t.Errorf("nil client: got %v, %v; want nil, nil", span, err)
+	}
+}
+
+func TestSpanOptionsCopyOnWrite(t *testing.T) {
+	tc := newTestClient(nil)
+	root := tc.NewSpan("/root")
+	// Propagate the trace without the trace bit, so that forcing it on for
+	// one child is visible in the headers.
+	root.options.global &^= optionTrace
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(forced bool) {
+			defer wg.Done()
+			child := root.NewChild("/child")
+			want := ";o=0"
+			if forced {
+				child.options.global |= optionTrace
+				want = ";o=1"
+			}
+			grandchild := child.NewChild("/grandchild")
+			for j := 0; j < 100; j++ {
+				for _, s := range []*Span{child, grandchild} {
+					if got := s.Header(); !strings.HasSuffix(got, want) {
+						t.Errorf("forced=%t: Header() = %q; want suffix %q", forced, got, want)
+						return
+					}
+				}
+				if !root.tracing() || !child.tracing() {
+					t.Error("tracing() = false; want true")
+					return
+				}
+			}
+		}(i == 0)
+	}
+	wg.Wait()
+	if got := root.Header(); !strings.HasSuffix(got, ";o=0") {
+		t.Errorf("root Header() = %q; want suffix %q", got, ";o=0")
+	}
+}
+
+func TestHeaderUnknownOptionsPassThrough(t *testing.T) {
+	const future = ";v=2;x-Future=a=b"
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.SpanFromHeader("/foo", "0123456789ABCDEF0123456789ABCDEF/1;o=1"+future)
+
+	if got, want := span.UnknownHeaderOptions(), map[string]string{"v": "2", "x-Future": "a=b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnknownHeaderOptions() = %v; want %v", got, want)
+	}
+	child := span.NewChild("/bar")
+	if got, want := child.Header(), fmt.Sprintf("0123456789ABCDEF0123456789ABCDEF/%d;o=1%s", child.span.SpanId, future); got != want {
+		t.Errorf("child Header() = %q; want %q", got, want)
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/bar", nil)
+	remote := span.NewRemoteChild(req)
+	if got, want := req.Header.Get(httpHeader), fmt.Sprintf("0123456789ABCDEF0123456789ABCDEF/%d;o=1%s", remote.span.SpanId, future); got != want {
+		t.Errorf("outgoing request header = %q; want %q", got, want)
+	}
+
+	plain := tc.SpanFromHeader("/foo", "0123456789ABCDEF0123456789ABCDEF/1;o=1")
+	if got := plain.UnknownHeaderOptions(); got != nil {
+		t.Errorf("UnknownHeaderOptions() without unknown options = %v; want nil", got)
+	}
+	if got := plain.Header(); strings.Count(got, ";") != 1 {
+		t.Errorf("Header() without unknown options = %q", got)
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	for _, traced := range []bool{true, false} {
+		e := &recordingExporter{}
+		tc := NewClientWithExporter(e)
+		header := "0123456789ABCDEF0123456789ABCDEF/7;o=0"
+		if traced {
+			header = "0123456789ABCDEF0123456789ABCDEF/7;o=1"
+		}
+		span := tc.SpanFromHeader("/span", header)
+
+		// Header is what the client interceptor sends for calls made with
+		// the span.
+		var sent string
+		ctx := NewContext(context.Background(), span)
+		err := GRPCClientInterceptor()(ctx, "/call", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			sent = strings.Join(md[grpcMetadataKey], ",")
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := span.Header(); got != sent {
+			t.Errorf("traced=%t: Header() = %q; interceptor sent %q", traced, got, sent)
+		}
+		if !traced {
+			if got := span.Header(); got != header {
+				t.Errorf("untraced: Header() = %q; want the incoming header %q", got, header)
+			}
+		}
+
+		remote := tc.SpanFromHeader("/remote", span.Header())
+		if got, want := remote.TraceID(), span.TraceID(); got != want {
+			t.Errorf("traced=%t: remote span TraceID() = %q; want %q", traced, got, want)
+		}
+		if got := remote.traced(); got != traced {
+			t.Errorf("traced=%t: remote span traced() = %t", traced, got)
+		}
+		child := remote.NewChild("/child")
+		child.Finish()
+		if err := remote.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		span.Finish()
+		if !traced {
+			continue
+		}
+		r, c := e.span("/remote"), e.span("/child")
+		if r == nil || c == nil {
+			t.Fatalf("exported spans %v; want /remote and /child", names(e.spans))
+		}
+		if got, want := r.TraceID, span.TraceID(); got != want {
+			t.Errorf("/remote exported in trace %q; want %q", got, want)
+		}
+		if got, want := r.ParentSpanID, span.span.SpanId; got != want {
+			t.Errorf("/remote parent span ID = %d; want %d", got, want)
+		}
+		if got, want := c.ParentSpanID, r.SpanID; got != want {
+			t.Errorf("/child parent span ID = %d; want %d", got, want)
+		}
+	}
+	if got := (*Span)(nil).Header(); got != "" {
+		t.Errorf("nil span Header() = %q; want \"\"", got)
+	}
+}
+
+func TestSpanFromRequestHeaders(t *testing.T) {
+	const (
+		canonical = "0123456789ABCDEF0123456789ABCDEF/1;o=1"
+		legacy    = "FEDCBA9876543210FEDCBA9876543210/2;o=1"
+	)
+	for _, tt := range []struct {
+		desc      string
+		names     []string // for SetRequestHeaders
+		headers   map[string]string
+		wantTrace string
+		wantSpan  uint64 // parent span ID
+	}{
+		{"canonical", nil, map[string]string{httpHeader: canonical}, "0123456789ABCDEF0123456789ABCDEF", 1},
+		{"legacy", nil, map[string]string{legacyHTTPHeader: legacy}, "FEDCBA9876543210FEDCBA9876543210", 2},
+		{"both", nil, map[string]string{httpHeader: canonical, legacyHTTPHeader: legacy}, "0123456789ABCDEF0123456789ABCDEF", 1},
+		{"legacy first", []string{legacyHTTPHeader, httpHeader}, map[string]string{httpHeader: canonical, legacyHTTPHeader: legacy}, "FEDCBA9876543210FEDCBA9876543210", 2},
+		{"legacy disabled", []string{httpHeader}, map[string]string{legacyHTTPHeader: legacy}, "", 0},
+	} {
+		tc := NewClientWithExporter(&recordingExporter{})
+		tc.SetRequestHeaders(tt.names...)
+		req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		span := tc.SpanFromRequest(req)
+		if tt.wantTrace != "" && span.TraceID() != tt.wantTrace {
+			t.Errorf("%s: trace ID = %q; want %q", tt.desc, span.TraceID(), tt.wantTrace)
+		}
+		if tt.wantTrace == "" && (span.TraceID() == "0123456789ABCDEF0123456789ABCDEF" || span.TraceID() == "FEDCBA9876543210FEDCBA9876543210") {
+			t.Errorf("%s: trace ID %q was read from a header", tt.desc, span.TraceID())
+		}
+		if got := span.span.ParentSpanId; got != tt.wantSpan {
+			t.Errorf("%s: parent span ID = %d; want %d", tt.desc, got, tt.wantSpan)
+		}
+
+		// Outgoing requests only carry the canonical header.
+		out, _ := http.NewRequest("GET", "http://example.com/bar", nil)
+		span.NewRemoteChild(out)
+		if out.Header.Get(httpHeader) == "" {
+			t.Errorf("%s: outgoing request has no %s header", tt.desc, httpHeader)
+		}
+		if h := out.Header.Get(legacyHTTPHeader); h != "" {
+			t.Errorf("%s: outgoing request has %s header %q", tt.desc, legacyHTTPHeader, h)
+		}
+	}
+}
+
 func TestOutgoingReqHeader(t *testing.T) {
 	all, _ := NewLimitedSampler(1, 1<<16) // trace every request
 
@@ -334,6 +612,11 @@ func TestNewSpan(t *testing.T) {
 			{
 				ProjectId: testProjectID,
 				Spans: []*api.TraceSpan{
+					{
+						Kind:   "SPAN_KIND_UNSPECIFIED",
+						Labels: map[string]string{},
+						Name:   "/foo",
+					},
 					{
 						Kind: "RPC_CLIENT",
 						Labels: map[string]string{
@@ -365,19 +648,33 @@ func TestNewSpan(t *testing.T) {
 						Name: "www.googleapis.com/storage/v1/b/testbucket/o",
 					},
 					&api.TraceSpan{
-						Kind:   "RPC_CLIENT",
-						Labels: nil,
-						Name:   "/google.datastore.v1.Datastore/Lookup",
+						Kind: "RPC_CLIENT",
+						Labels: map[string]string{
+							labelGRPCAuthority:    "127.0.0.1:",
+							labelGRPCPeer:         "127.0.0.1:",
+							labelGRPCService:      "google.datastore.v1.Datastore",
+							labelGRPCMethod:       "Lookup",
+							labelGRPCRequestType:  "google.datastore.v1.LookupRequest",
+							labelGRPCResponseType: "google.datastore.v1.LookupResponse",
+							labelGRPCStatusCode:   "OK",
+						},
+						Name: "/google.datastore.v1.Datastore/Lookup",
 					},
 					&api.TraceSpan{
-						Kind:   "RPC_CLIENT",
-						Labels: map[string]string{"error": "rpc error: code = Unknown desc = lookup failed"},
-						Name:   "/google.datastore.v1.Datastore/Lookup",
-					},
-					{
-						Kind:   "SPAN_KIND_UNSPECIFIED",
-						Labels: map[string]string{},
-						Name:   "/foo",
+						Kind: "RPC_CLIENT",
+						Labels: map[string]string{
+							labelGRPCAuthority:     "127.0.0.1:",
+							labelGRPCPeer:          "127.0.0.1:",
+							labelGRPCService:       "google.datastore.v1.Datastore",
+							labelGRPCMethod:        "Lookup",
+							labelGRPCRequestType:   "google.datastore.v1.LookupRequest",
+							labelGRPCResponseType:  "google.datastore.v1.LookupResponse",
+							labelGRPCStatusCode:    "Unknown",
+							labelGRPCStatusMessage: "lookup failed",
+							labelGRPCErrorSource:   "status",
+							"error":                "rpc error: code = Unknown desc = lookup failed",
+						},
+						Name: "/google.datastore.v1.Datastore/Lookup",
 					},
 				},
 				TraceId: traceID,
@@ -401,8 +698,7 @@ func TestNewSpan(t *testing.T) {
 		t.Fatalf("PatchTraces request: got %s want %s", got, want)
 	}
 
-	n := len(patch.Traces[0].Spans)
-	rootSpan := patch.Traces[0].Spans[n-1]
+	rootSpan := patch.Traces[0].Spans[0]
 	for i, s := range patch.Traces[0].Spans {
 		if a, b := s.StartTime, s.EndTime; a > b {
 			t.Errorf("span %d start time is later than its end time (%q, %q)", i, a, b)
@@ -413,7 +709,7 @@ func TestNewSpan(t *testing.T) {
 		if a, b := s.EndTime, rootSpan.EndTime; a > b {
 			t.Errorf("span %d end time is later than trace end time (%q, %q)", i, a, b)
 		}
-		if i > 1 && i < n-1 {
+		if i > 2 {
 			if a, b := patch.Traces[0].Spans[i-1].EndTime, s.StartTime; a > b {
 				t.Errorf("span %d end time is later than span %d start time (%q, %q)", i-1, i, a, b)
 			}
@@ -424,7 +720,7 @@ func TestNewSpan(t *testing.T) {
 		t.Errorf("Incorrect ParentSpanId: got %d want %d", x, 0)
 	}
 	for i, s := range patch.Traces[0].Spans {
-		if x, y := rootSpan.SpanId, s.ParentSpanId; i < n-1 && x != y {
+		if x, y := rootSpan.SpanId, s.ParentSpanId; i > 0 && x != y {
 			t.Errorf("Incorrect ParentSpanId in span %d: got %d want %d", i, y, x)
 		}
 	}
@@ -434,7 +730,7 @@ func TestNewSpan(t *testing.T) {
 		for key, value := range *labels {
 			if v, ok := s.Labels[key]; !ok {
 				t.Errorf("Span %d is missing Label %q:%q", i, key, value)
-			} else if key == "trace.cloud.google.com/http/url" {
+			} else if key == "trace.cloud.google.com/http/url" || key == labelGRPCAuthority || key == labelGRPCPeer {
 				if !strings.HasPrefix(v, value) {
 					t.Errorf("Span %d Label %q: got value %q want prefix %q", i, key, v, value)
 				}
@@ -497,6 +793,11 @@ func testTrace(t *testing.T, synchronous bool, fromRequest bool) {
 			{
 				ProjectId: testProjectID,
 				Spans: []*api.TraceSpan{
+					{
+						Kind:   "RPC_SERVER",
+						Labels: headerOrReqLabels,
+						Name:   headerOrReqName,
+					},
 					{
 						Kind: "RPC_CLIENT",
 						Labels: map[string]string{
@@ -528,19 +829,33 @@ func testTrace(t *testing.T, synchronous bool, fromRequest bool) {
 						Name: "www.googleapis.com/storage/v1/b/testbucket/o",
 					},
 					&api.TraceSpan{
-						Kind:   "RPC_CLIENT",
-						Labels: nil,
-						Name:   "/google.datastore.v1.Datastore/Lookup",
+						Kind: "RPC_CLIENT",
+						Labels: map[string]string{
+							labelGRPCAuthority:    "127.0.0.1:",
+							labelGRPCPeer:         "127.0.0.1:",
+							labelGRPCService:      "google.datastore.v1.Datastore",
+							labelGRPCMethod:       "Lookup",
+							labelGRPCRequestType:  "google.datastore.v1.LookupRequest",
+							labelGRPCResponseType: "google.datastore.v1.LookupResponse",
+							labelGRPCStatusCode:   "OK",
+						},
+						Name: "/google.datastore.v1.Datastore/Lookup",
 					},
 					&api.TraceSpan{
-						Kind:   "RPC_CLIENT",
-						Labels: map[string]string{"error": "rpc error: code = Unknown desc = lookup failed"},
-						Name:   "/google.datastore.v1.Datastore/Lookup",
-					},
-					{
-						Kind:   "RPC_SERVER",
-						Labels: headerOrReqLabels,
-						Name:   headerOrReqName,
+						Kind: "RPC_CLIENT",
+						Labels: map[string]string{
+							labelGRPCAuthority:     "127.0.0.1:",
+							labelGRPCPeer:          "127.0.0.1:",
+							labelGRPCService:       "google.datastore.v1.Datastore",
+							labelGRPCMethod:        "Lookup",
+							labelGRPCRequestType:   "google.datastore.v1.LookupRequest",
+							labelGRPCResponseType:  "google.datastore.v1.LookupResponse",
+							labelGRPCStatusCode:    "Unknown",
+							labelGRPCStatusMessage: "lookup failed",
+							labelGRPCErrorSource:   "status",
+							"error":                "rpc error: code = Unknown desc = lookup failed",
+						},
+						Name: "/google.datastore.v1.Datastore/Lookup",
 					},
 				},
 				TraceId: "0123456789ABCDEF0123456789ABCDEF",
@@ -564,8 +879,7 @@ func testTrace(t *testing.T, synchronous bool, fromRequest bool) {
 		t.Fatalf("PatchTraces request: got %s want %s", got, want)
 	}
 
-	n := len(patch.Traces[0].Spans)
-	rootSpan := patch.Traces[0].Spans[n-1]
+	rootSpan := patch.Traces[0].Spans[0]
 	for i, s := range patch.Traces[0].Spans {
 		if a, b := s.StartTime, s.EndTime; a > b {
 			t.Errorf("span %d start time is later than its end time (%q, %q)", i, a, b)
@@ -576,7 +890,7 @@ func testTrace(t *testing.T, synchronous bool, fromRequest bool) {
 		if a, b := s.EndTime, rootSpan.EndTime; a > b {
 			t.Errorf("span %d end time is later than trace end time (%q, %q)", i, a, b)
 		}
-		if i > 1 && i < n-1 {
+		if i > 2 {
 			if a, b := patch.Traces[0].Spans[i-1].EndTime, s.StartTime; a > b {
 				t.Errorf("span %d end time is later than span %d start time (%q, %q)", i-1, i, a, b)
 			}
@@ -587,7 +901,7 @@ func testTrace(t *testing.T, synchronous bool, fromRequest bool) {
 		t.Errorf("Incorrect ParentSpanId: got %d want %d", x, 42)
 	}
 	for i, s := range patch.Traces[0].Spans {
-		if x, y := rootSpan.SpanId, s.ParentSpanId; i < n-1 && x != y {
+		if x, y := rootSpan.SpanId, s.ParentSpanId; i > 0 && x != y {
 			t.Errorf("Incorrect ParentSpanId in span %d: got %d want %d", i, y, x)
 		}
 	}
@@ -597,7 +911,7 @@ func testTrace(t *testing.T, synchronous bool, fromRequest bool) {
 		for key, value := range *labels {
 			if v, ok := s.Labels[key]; !ok {
 				t.Errorf("Span %d is missing Label %q:%q", i, key, value)
-			} else if key == "trace.cloud.google.com/http/url" {
+			} else if key == "trace.cloud.google.com/http/url" || key == labelGRPCAuthority || key == labelGRPCPeer {
 				if !strings.HasPrefix(v, value) {
 					t.Errorf("Span %d Label %q: got value %q want prefix %q", i, key, v, value)
 				}
@@ -707,53 +1021,54 @@ func TestSample(t *testing.T) {
 
 func TestSampling(t *testing.T) {
 	t.Parallel()
-	// This scope tests sampling in a larger context, with real time and randomness.
-	wg := sync.WaitGroup{}
-	type testCase struct {
+	// This tests sampling in a larger context: requests are traced by
+	// SpanFromRequest according to the policy, and the traced ones uploaded.
+	// A fixed random source and a fake clock, advanced 25ms per request,
+	// make the decisions of the policy deterministic.
+	for _, test := range []struct {
 		rate          float64
 		maxqps        float64
 		expectedRange [2]int
-	}
-	for _, test := range []testCase{
+	}{
 		{0, 5, [2]int{0, 0}},
 		{5, 0, [2]int{0, 0}},
 		{0.50, 100, [2]int{20, 60}},
-		{0.50, 1, [2]int{3, 4}}, // Windows, with its less precise clock, sometimes gives 4.
+		{0.50, 1, [2]int{3, 4}},
 	} {
-		wg.Add(1)
-		go func(test testCase) {
-			rt := newFakeRoundTripper()
-			traceClient := newTestClient(rt)
-			traceClient.bundler.BundleByteLimit = 1
-			p, err := NewLimitedSampler(test.rate, test.maxqps)
+		rt := newFakeRoundTripper()
+		traceClient := newTestClient(rt)
+		traceClient.bundler.BundleByteLimit = 1
+		now := time.Unix(1500000000, 0)
+		p, err := NewLimitedSampler(test.rate, test.maxqps,
+			WithRandSource(rand.NewSource(1)),
+			WithClock(func() time.Time { return now }))
+		if err != nil {
+			t.Fatalf("NewLimitedSampler: %v", err)
+		}
+		traceClient.SetSamplingPolicy(p)
+		sampled := 0
+		for i := 0; i < 79; i++ {
+			req, err := http.NewRequest("GET", "http://example.com/foo", nil)
 			if err != nil {
-				t.Fatalf("NewLimitedSampler: %v", err)
+				t.Fatal(err)
 			}
-			traceClient.SetSamplingPolicy(p)
-			ticker := time.NewTicker(25 * time.Millisecond)
-			sampled := 0
-			for i := 0; i < 79; i++ {
-				req, err := http.NewRequest("GET", "http://example.com/foo", nil)
-				if err != nil {
-					t.Fatal(err)
-				}
-				span := traceClient.SpanFromRequest(req)
-				span.Finish()
+			span := traceClient.SpanFromRequest(req)
+			traced := span.tracing()
+			span.Finish()
+			if traced {
 				select {
 				case <-rt.reqc:
-					<-ticker.C
 					sampled++
-				case <-ticker.C:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("rate=%f, maxqps=%f: traced request wasn't uploaded", test.rate, test.maxqps)
 				}
 			}
-			ticker.Stop()
-			if test.expectedRange[0] > sampled || sampled > test.expectedRange[1] {
-				t.Errorf("rate=%f, maxqps=%f: got %d samples want ∈ %v", test.rate, test.maxqps, sampled, test.expectedRange)
-			}
-			wg.Done()
-		}(test)
+			now = now.Add(25 * time.Millisecond)
+		}
+		if test.expectedRange[0] > sampled || sampled > test.expectedRange[1] {
+			t.Errorf("rate=%f, maxqps=%f: got %d samples want ∈ %v", test.rate, test.maxqps, sampled, test.expectedRange)
+		}
 	}
-	wg.Wait()
 }
 
 func TestBundling(t *testing.T) {
@@ -926,7 +1241,8 @@ func TestPropagation(t *testing.T) {
 					t.Errorf("trace IDs should be passed to child requests")
 				}
 			}
-			trace := policy == alwaysTrace{} || policy == nil && (o1&1) != 0
+			// The o=1 option forces tracing, whatever the policy.
+			trace := policy == alwaysTrace{} || (o1&1) != 0
 			if header == "" {
 				if trace && (s2 == 0 || s3 == 0) {
 					t.Errorf("got span IDs %d %d in child requests, want nonzero", s2, s3)
@@ -952,3 +1268,323 @@ func TestPropagation(t *testing.T) {
 		}
 	}
 }
+
+// recordingExporter is an Exporter that keeps the spans it is given.
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+
+	// If exported is non-nil, ExportSpans sends on it after recording spans.
+	exported chan struct{}
+}
+
+func (e *recordingExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mu.Unlock()
+	if e.exported != nil {
+		e.exported <- struct{}{}
+	}
+	return nil
+}
+
+// span returns the exported span with the given name, or nil.
+func (e *recordingExporter) span(name string) *SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestElapsed(t *testing.T) {
+	var nilSpan *Span
+	if got := nilSpan.Elapsed(); got != 0 {
+		t.Errorf("nil span: Elapsed() = %v; want 0", got)
+	}
+
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.NewSpan("/foo")
+	span.start = span.start.Add(-time.Second)
+	if got := span.Elapsed(); got < time.Second {
+		t.Errorf("open span: Elapsed() = %v; want at least 1s", got)
+	}
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	d := span.Elapsed()
+	time.Sleep(time.Millisecond)
+	if got, want := span.Elapsed(), span.end.Sub(span.start); got != d || got != want {
+		t.Errorf("finished span: Elapsed() = %v, then %v; want %v", d, got, want)
+	}
+}
+
+func TestChildDurationRollup(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	tc.SetChildDurationRollup(2)
+
+	root := tc.NewSpan("/root")
+	var query, cache time.Duration
+	finish := func(s *Span, d time.Duration) time.Duration {
+		s.start = s.start.Add(-d)
+		s.Finish()
+		return s.end.Sub(s.start)
+	}
+	for _, d := range []time.Duration{40 * time.Millisecond, 25 * time.Millisecond} {
+		q := root.NewChild("db.query")
+		finish(q.NewChild("db.conn"), 10*time.Millisecond)
+		query += finish(q, d)
+	}
+	cache += finish(root.NewChild("cache.get"), 5*time.Millisecond)
+	other := finish(root.NewChild("rpc.a"), 7*time.Millisecond)
+	other += finish(root.NewChild("rpc.b"), 3*time.Millisecond)
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	ms := func(d time.Duration) string { return fmt.Sprint(int64(d / time.Millisecond)) }
+	labels := e.span("/root").Labels
+	for key, want := range map[string]string{
+		"child_ms/db.query":  ms(query),
+		"child_ms/cache.get": ms(cache),
+		"child_ms/other":     ms(other),
+	} {
+		if got := labels[key]; got != want {
+			t.Errorf("root label %q = %q; want %q", key, got, want)
+		}
+	}
+	for _, key := range []string{"child_ms/db.conn", "child_ms/rpc.a", "child_ms/rpc.b"} {
+		if got, ok := labels[key]; ok {
+			t.Errorf("root has label %q = %q; want none", key, got)
+		}
+	}
+	if got := e.span("db.query").Labels["child_ms/db.conn"]; got == "" {
+		t.Errorf("db.query span is missing its child_ms/db.conn label")
+	}
+}
+
+func TestChildDurationRollupDisabled(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	root.NewChild("db.query").Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	for key := range e.span("/root").Labels {
+		if strings.HasPrefix(key, "child_ms/") {
+			t.Errorf("root has label %q with rollup disabled", key)
+		}
+	}
+}
+
+func TestExportOrderDeterministic(t *testing.T) {
+	// export returns the JSON encoding of the spans of a trace, whose
+	// children finish in a random order on different goroutines.
+	export := func() string {
+		e := &recordingExporter{}
+		root := NewClientWithExporter(e).NewSpanWithOptions("/root", WithSpanID(1))
+		var children []*Span
+		for i := 0; i < 20; i++ {
+			children = append(children, root.NewChild(fmt.Sprintf("/child%d", i), WithSpanID(uint64(100+i))))
+		}
+		var wg sync.WaitGroup
+		for _, i := range rand.Perm(len(children)) {
+			wg.Add(1)
+			go func(s *Span) {
+				defer wg.Done()
+				s.Finish()
+			}(children[i])
+		}
+		wg.Wait()
+		if err := root.FinishWait(); err != nil {
+			t.Fatal(err)
+		}
+		// The IDs and times differ between runs, so only the order is
+		// compared.
+		type entry struct {
+			Name         string
+			SpanID       uint64
+			ParentSpanID uint64
+		}
+		var entries []entry
+		for _, s := range e.spans {
+			entries = append(entries, entry{s.Name, s.SpanID, s.ParentSpanID})
+		}
+		b, err := json.Marshal(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	want := export()
+	for i := 0; i < 10; i++ {
+		if got := export(); got != want {
+			t.Fatalf("run %d exported\n%s\nwant\n%s", i, got, want)
+		}
+	}
+}
+
+func TestSetName(t *testing.T) {
+	var nilSpan *Span
+	nilSpan.SetName("nil") // doesn't panic.
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	child := root.NewChild("child")
+	child.SetName("renamed")
+	long := root.NewChild("long")
+	long.SetName(strings.Repeat("é", maxSpanNameBytes)) // two bytes per rune.
+	child.Finish()
+	long.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.span("child") != nil || e.span("renamed") == nil {
+		t.Errorf("exported spans %v; want child renamed", names(e.spans))
+	}
+	want := strings.Repeat("é", maxSpanNameBytes/2)
+	s := e.span(want)
+	if s == nil {
+		t.Fatalf("exported spans %v; want the long name truncated to %d bytes", names(e.spans), maxSpanNameBytes)
+	}
+	if got, want := s.NameTruncatedBytes, maxSpanNameBytes; got != want {
+		t.Errorf("NameTruncatedBytes = %d; want %d", got, want)
+	}
+}
+
+func TestSetNameConcurrentFinish(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	tc.SetChildDurationRollup(4)
+	root := tc.NewSpan("/root")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		child := root.NewChild("child")
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			child.SetName("renamed")
+		}()
+		go func() {
+			defer wg.Done()
+			child.Finish()
+			child.Snapshot()
+		}()
+	}
+	wg.Wait()
+	root.Finish()
+}
+
+func TestConcurrentSetLabelAndFinish(t *testing.T) {
+	const n, keys = 50, 10 // keys stays below the default label limit.
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	child := root.NewChild("child")
+
+	// Each goroutine labels the child, then finishes it once all the labels
+	// are set; the labels of the root are set concurrently with the finishes.
+	var labeled, wg sync.WaitGroup
+	labeled.Add(n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			child.SetLabel(fmt.Sprintf("key%d", i%keys), "value")
+			labeled.Done()
+			labeled.Wait()
+			root.SetLabel(fmt.Sprintf("key%d", i%keys), "value")
+			child.Finish()
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			root.SetLabel(fmt.Sprintf("late%d", i%keys), "value")
+			if err := root.FinishWait(); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	tc.Flush(context.Background())
+
+	if got := len(e.spans); got != 2 {
+		t.Fatalf("exported %d spans; want 2", got)
+	}
+	if got := tc.Stats().SpansFinished; got != 2 {
+		t.Errorf("SpansFinished = %d; want 2", got)
+	}
+	c := e.span("child")
+	for i := 0; i < keys; i++ {
+		if got := c.Labels[fmt.Sprintf("key%d", i)]; got != "value" {
+			t.Errorf("child label key%d = %q; want value", i, got)
+		}
+	}
+}
+
+func TestFinishIdempotent(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	child := root.NewChild("child")
+	end := child.start.Add(time.Millisecond)
+	child.FinishAt(end)
+	child.FinishAt(end.Add(time.Second))
+	child.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Errorf("second FinishWait: %v", err)
+	}
+	root.Finish()
+	tc.Flush(context.Background())
+
+	if got := names(e.spans); len(got) != 2 {
+		t.Fatalf("exported spans %v; want each span once", got)
+	}
+	if got := e.span("child").End; !got.Equal(end) {
+		t.Errorf("child ended at %v; want the end time of the first FinishAt, %v", got, end)
+	}
+	if got := tc.Stats().SpansFinished; got != 2 {
+		t.Errorf("SpansFinished = %d; want 2", got)
+	}
+}
+
+func TestSpanIDs(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.SpanFromHeader("/root", "0123456789abcdef0123456789abcdef/42;o=1")
+	if got := root.ParentSpanID(); got != 42 {
+		t.Errorf("root.ParentSpanID() = %d; want the span ID of the header, 42", got)
+	}
+	child := root.NewChild("child")
+	if got, want := child.ParentSpanID(), root.SpanID(); got != want || got == 0 {
+		t.Errorf("child.ParentSpanID() = %d; want root.SpanID() = %d", got, want)
+	}
+	childID := child.SpanID()
+	child.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := child.SpanID(); got != childID {
+		t.Errorf("SpanID() after Finish = %d; want %d", got, childID)
+	}
+	if s := e.span("child"); s.SpanID != childID || s.ParentSpanID != root.SpanID() {
+		t.Errorf("exported child has IDs %d, parent %d; want %d, parent %d", s.SpanID, s.ParentSpanID, childID, root.SpanID())
+	}
+
+	var nilSpan *Span
+	if nilSpan.SpanID() != 0 || nilSpan.ParentSpanID() != 0 {
+		t.Error("nil span has non-zero IDs")
+	}
+}
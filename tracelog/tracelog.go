@@ -0,0 +1,193 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracelog writes log entries about spans that Cloud Logging links to
+// their traces, so that they appear in its trace-correlated view.
+//
+// Entries are written either to a *logging.Logger, or to an io.Writer, such
+// as os.Stderr, in the structured JSON format understood by the logging
+// agent:
+//
+//   l := tracelog.NewWriterLogger(os.Stderr, projectID, tracelog.WithMinSeverity(logging.Warning))
+//   ...
+//   l.Log(span, logging.Error, "cache unavailable")
+//
+// It is a separate package so that programs that use package trace don't
+// depend on package logging.
+package tracelog // import "cloud.google.com/go/trace/tracelog"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/trace"
+)
+
+// Fields of the structured JSON entries with special meaning to the logging
+// agent.
+const (
+	traceField        = "logging.googleapis.com/trace"
+	spanIDField       = "logging.googleapis.com/spanId"
+	traceSampledField = "logging.googleapis.com/trace_sampled"
+)
+
+const (
+	// rateLimitSlots is the number of counters used to limit the rate of
+	// entries per span.  Spans share a counter if their IDs collide modulo
+	// rateLimitSlots, which can only make the limit stricter.
+	rateLimitSlots = 1024
+
+	// DefaultMaxPerSpan is the default maximum number of entries written
+	// per span per second.
+	DefaultMaxPerSpan = 10
+)
+
+// A Logger writes log entries linked to spans.
+type Logger struct {
+	projectID   string
+	minSeverity logging.Severity
+	maxPerSpan  int
+	now         func() time.Time
+
+	logger *logging.Logger // nil if w is used.
+	w      io.Writer
+
+	mu      sync.Mutex // guards w, window and counts
+	window  int64      // the second that counts are for.
+	counts  [rateLimitSlots]int
+	dropped int64
+}
+
+// An Option configures a Logger.
+type Option func(*Logger)
+
+// WithMinSeverity sets the severity below which entries are not written.
+// The default is logging.Default, which writes all entries.
+func WithMinSeverity(s logging.Severity) Option {
+	return func(l *Logger) { l.minSeverity = s }
+}
+
+// WithMaxPerSpan sets the maximum number of entries written for each span
+// per second.  Entries over the limit are dropped, and counted by Dropped.
+// The default is DefaultMaxPerSpan.
+func WithMaxPerSpan(n int) Option {
+	return func(l *Logger) { l.maxPerSpan = n }
+}
+
+// withClock makes the Logger call now to get the current time.  It is used
+// by tests.
+func withClock(now func() time.Time) Option {
+	return func(l *Logger) { l.now = now }
+}
+
+func newLogger(projectID string, opts []Option) *Logger {
+	l := &Logger{
+		projectID:  projectID,
+		maxPerSpan: DefaultMaxPerSpan,
+		now:        time.Now,
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	return l
+}
+
+// NewLogger returns a Logger that writes entries to logger.  projectID is the
+// project that traces are uploaded to.
+func NewLogger(logger *logging.Logger, projectID string, opts ...Option) *Logger {
+	l := newLogger(projectID, opts)
+	l.logger = logger
+	return l
+}
+
+// NewWriterLogger returns a Logger that writes entries to w, one JSON object
+// per line, in the format understood by the logging agent.  projectID is the
+// project that traces are uploaded to.
+func NewWriterLogger(w io.Writer, projectID string, opts ...Option) *Logger {
+	l := newLogger(projectID, opts)
+	l.w = w
+	return l
+}
+
+// Dropped returns the number of entries that were not written because of
+// the per-span rate limit.
+func (l *Logger) Dropped() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped
+}
+
+// Log writes an entry with the given severity and message, linked to span.
+// The entry is not written if span is nil, the severity is below the
+// Logger's minimum, or too many entries were written for span in the last
+// second.
+func (l *Logger) Log(span *trace.Span, severity logging.Severity, msg string) {
+	if span == nil || severity < l.minSeverity {
+		return
+	}
+	snap := span.Snapshot()
+	now := l.now()
+	if !l.allow(snap.SpanID, now) {
+		return
+	}
+	resource := fmt.Sprintf("projects/%s/traces/%s", l.projectID, snap.TraceID)
+	spanID := fmt.Sprintf("%016x", snap.SpanID)
+	if l.logger != nil {
+		l.logger.Log(logging.Entry{
+			Timestamp: now,
+			Severity:  severity,
+			Payload:   msg,
+			Trace:     resource,
+			Labels:    map[string]string{"spanId": spanID},
+		})
+		return
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"time":            now.Format(time.RFC3339Nano),
+		"severity":        strings.ToUpper(severity.String()),
+		"message":         msg,
+		traceField:        resource,
+		spanIDField:       spanID,
+		traceSampledField: snap.Sampled,
+	})
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	l.w.Write(append(b, '\n'))
+	l.mu.Unlock()
+}
+
+// allow reports whether an entry for the span with the given ID can be
+// written at time now, and counts it if so.
+func (l *Logger) allow(spanID uint64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sec := now.Unix(); sec != l.window {
+		l.window = sec
+		l.counts = [rateLimitSlots]int{}
+	}
+	c := &l.counts[spanID%rateLimitSlots]
+	if *c >= l.maxPerSpan {
+		l.dropped++
+		return false
+	}
+	*c++
+	return true
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracelog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/trace"
+	"cloud.google.com/go/trace/tracetest"
+)
+
+func TestWriterLogger(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := NewWriterLogger(&buf, "proj", WithMinSeverity(logging.Warning), withClock(func() time.Time { return now }))
+	span := tracetest.NewClient().NewSpan("/root")
+
+	l.Log(span, logging.Info, "ignored")
+	l.Log(span, logging.Error, "cache unavailable")
+	l.Log(nil, logging.Error, "no span")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d entries; want 1:\n%s", len(lines), buf.String())
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"time":                                 "2017-06-01T12:00:00Z",
+		"severity":                             "ERROR",
+		"message":                              "cache unavailable",
+		"logging.googleapis.com/trace":         "projects/proj/traces/" + span.TraceID(),
+		"logging.googleapis.com/spanId":        fmt.Sprintf("%016x", span.Snapshot().SpanID),
+		"logging.googleapis.com/trace_sampled": true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entry:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := NewWriterLogger(&buf, "proj", WithMaxPerSpan(3), withClock(func() time.Time { return now }))
+	tc := tracetest.NewClient()
+	// Fixed IDs, so that the spans don't share a rate limit counter.
+	chatty := tc.NewSpanWithOptions("/chatty", trace.WithSpanID(1))
+	quiet := tc.NewSpanWithOptions("/quiet", trace.WithSpanID(2))
+
+	for i := 0; i < 5; i++ {
+		l.Log(chatty, logging.Info, "chatty")
+	}
+	l.Log(quiet, logging.Info, "quiet")
+	now = now.Add(time.Second)
+	l.Log(chatty, logging.Info, "chatty again")
+
+	if got, want := strings.Count(buf.String(), "\n"), 5; got != want {
+		t.Errorf("wrote %d entries; want %d:\n%s", got, want, buf.String())
+	}
+	if got, want := strings.Count(buf.String(), `"quiet"`), 1; got != want {
+		t.Errorf("wrote %d entries for the quiet span; want %d", got, want)
+	}
+	if got, want := l.Dropped(), int64(2); got != want {
+		t.Errorf("Dropped() = %d; want %d", got, want)
+	}
+}
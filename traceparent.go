@@ -0,0 +1,106 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// traceparentKey is the key of the W3C Trace Context header.
+const traceparentKey = "traceparent"
+
+// TraceparentVersion is the version of the W3C traceparent format of the
+// headers that TraceparentPropagator sends.  It reads headers of this and
+// later versions.
+const TraceparentVersion = "00"
+
+// TraceparentPropagator propagates trace contexts in the traceparent header
+// of W3C Trace Context.
+var TraceparentPropagator Propagator = traceparentPropagator{}
+
+type traceparentPropagator struct{}
+
+func (traceparentPropagator) Inject(sc SpanContext, carrier Carrier) {
+	carrier.Set(traceparentKey, traceparent(sc.TraceID, sc.SpanID, sc.flags()))
+}
+
+func (traceparentPropagator) Extract(carrier Carrier) (SpanContext, error) {
+	h := carrier.Get(traceparentKey)
+	if h == "" {
+		return SpanContext{}, ErrNoHeader
+	}
+	if !isTraceparent(h) || len(h) > 200 {
+		return SpanContext{}, &HeaderError{Header: h, Err: ErrMalformedOptions, Detail: "not a traceparent header"}
+	}
+	traceID, spanID, options, err := parseTraceparent(h)
+	if err != nil {
+		return SpanContext{}, err
+	}
+	return SpanContext{TraceID: traceID, SpanID: spanID, Traced: options&optionTrace != 0}, nil
+}
+
+// traceparent returns a W3C traceparent header.
+func traceparent(traceID string, spanID uint64, options optionFlags) string {
+	return fmt.Sprintf("%s-%s-%016x-%02x", TraceparentVersion, strings.ToLower(traceID), spanID, options&optionTrace)
+}
+
+// isTraceparent reports whether h looks like a traceparent header rather
+// than a Google trace header, which can't have a '-' in its third byte.
+func isTraceparent(h string) bool {
+	return len(h) > 2 && h[2] == '-'
+}
+
+// parseTraceparent parses h, a W3C traceparent header.  See
+// https://www.w3.org/TR/trace-context/#traceparent-header for the format.
+func parseTraceparent(h string) (traceID string, spanID uint64, options optionFlags, err error) {
+	header := h
+	version := h[:2]
+	if !isLowerHex(version) || version == "ff" {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedOptions, Detail: fmt.Sprintf("traceparent version %q", version)}
+	}
+	// Later versions may add fields after the flags.
+	if len(h) < 55 || (version == TraceparentVersion && len(h) != 55) || (len(h) > 55 && h[55] != '-') {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedOptions, Detail: fmt.Sprintf("traceparent of %d bytes", len(h))}
+	}
+	if traceID = h[3:35]; h[35] != '-' || !isLowerHex(traceID) || !validTraceID(traceID) {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedTraceID, Detail: fmt.Sprintf("%q", h[3:35])}
+	}
+	spanstr := h[36:52]
+	if h[52] != '-' || !isLowerHex(spanstr) {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedSpanID, Detail: fmt.Sprintf("%q", spanstr)}
+	}
+	if spanID, _ = strconv.ParseUint(spanstr, 16, 64); spanID == 0 {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedSpanID, Detail: fmt.Sprintf("%q", spanstr)}
+	}
+	flags := h[53:55]
+	if !isLowerHex(flags) {
+		return "", 0, 0, &HeaderError{Header: header, Err: ErrMalformedOptions, Detail: fmt.Sprintf("%q", flags)}
+	}
+	if f, _ := strconv.ParseUint(flags, 16, 8); f&1 != 0 {
+		options = optionTrace
+	}
+	return traceID, spanID, options, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
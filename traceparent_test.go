@@ -0,0 +1,154 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	tpTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tpSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tt := range []struct {
+		header      string
+		wantOptions optionFlags
+		wantErr     error
+	}{
+		{header: "00-" + tpTraceID + "-" + tpSpanID + "-01", wantOptions: optionTrace},
+		{header: "00-" + tpTraceID + "-" + tpSpanID + "-00"},
+		{header: "00-" + tpTraceID + "-" + tpSpanID + "-03", wantOptions: optionTrace},
+		// Later versions may have more fields.
+		{header: "01-" + tpTraceID + "-" + tpSpanID + "-01-extra", wantOptions: optionTrace},
+		{header: "00-" + tpTraceID + "-" + tpSpanID + "-01-extra", wantErr: ErrMalformedOptions},
+		{header: "ff-" + tpTraceID + "-" + tpSpanID + "-01", wantErr: ErrMalformedOptions},
+		{header: "00-" + tpTraceID + "-" + tpSpanID, wantErr: ErrMalformedOptions},
+		{header: "00-" + tpTraceID + "-" + tpSpanID + "-zz", wantErr: ErrMalformedOptions},
+		{header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + tpSpanID + "-01", wantErr: ErrMalformedTraceID},
+		{header: "00-00000000000000000000000000000000-" + tpSpanID + "-01", wantErr: ErrMalformedTraceID},
+		{header: "00-" + tpTraceID + "-0000000000000000-01", wantErr: ErrMalformedSpanID},
+		{header: "00-" + tpTraceID + "-00f067aa0ba902bx-01", wantErr: ErrMalformedSpanID},
+	} {
+		traceID, spanID, options, extra, err := parseHeaderErr(tt.header)
+		if tt.wantErr != nil {
+			if herr, ok := err.(*HeaderError); !ok || herr.Err != tt.wantErr {
+				t.Errorf("parseHeaderErr(%q) error = %v; want %v", tt.header, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHeaderErr(%q): %v", tt.header, err)
+			continue
+		}
+		if traceID != tpTraceID || spanID != 0x00f067aa0ba902b7 || options != tt.wantOptions || extra != "" {
+			t.Errorf("parseHeaderErr(%q) = %q, %x, %d, %q; want %q, %s, %d, \"\"", tt.header, traceID, spanID, options, extra, tpTraceID, tpSpanID, tt.wantOptions)
+		}
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	want := "00-" + tpTraceID + "-" + tpSpanID + "-01"
+	if got := traceparent(tpTraceID, 0x00f067aa0ba902b7, optionTrace|optionStack); got != want {
+		t.Errorf("traceparent = %q; want %q", got, want)
+	}
+}
+
+func TestSpanFromTraceparent(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	span := tc.SpanFromHeader("/foo", "00-"+tpTraceID+"-"+tpSpanID+"-01")
+	if got := span.TraceID(); got != tpTraceID {
+		t.Errorf("TraceID = %q; want %q", got, tpTraceID)
+	}
+	if got, want := span.span.ParentSpanId, uint64(0x00f067aa0ba902b7); got != want {
+		t.Errorf("parent span ID = %x; want %x", got, want)
+	}
+	if !span.traced() {
+		t.Errorf("span from a sampled traceparent header is not traced")
+	}
+	if span := tc.SpanFromHeader("/foo", "00-"+tpTraceID+"-"+tpSpanID+"-00"); span.traced() {
+		t.Errorf("span from an unsampled traceparent header is traced")
+	}
+}
+
+// outgoingHeaders makes a call with the client interceptor configured with
+// opts, in ctx, and returns the trace headers it sent.
+func outgoingHeaders(ctx context.Context, opts ...InterceptorOption) metadata.MD {
+	var md metadata.MD
+	GRPCClientInterceptor(opts...)(ctx, "/test.Service/Out", nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	})
+	return md
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	tc := NewClientWithExporter(&recordingExporter{})
+	md := metadata.Pairs(traceparentKey, "00-"+tpTraceID+"-"+tpSpanID+"-01")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/In"}
+
+	// Without the option, the traceparent header is ignored.
+	GRPCServerInterceptor(tc)(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if FromContext(ctx) != nil {
+			t.Errorf("handler got a span from a traceparent header without WithHeaderFormats")
+		}
+		return nil, nil
+	})
+
+	GRPCServerInterceptor(tc, WithHeaderFormats(TraceparentHeader))(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		span := FromContext(ctx)
+		if got := span.TraceID(); got != tpTraceID {
+			t.Errorf("server span TraceID = %q; want %q", got, tpTraceID)
+		}
+
+		out := outgoingHeaders(ctx)
+		if got := out[traceparentKey]; len(got) != 0 {
+			t.Errorf("default client interceptor sent traceparent %q", got)
+		}
+		google := out[grpcMetadataKey]
+		if len(google) != 1 {
+			t.Fatalf("outgoing Google headers = %q; want one", google)
+		}
+		traceID, spanID, options, _, err := parseHeaderErr(google[0])
+		if err != nil || traceID != tpTraceID || options&optionTrace == 0 {
+			t.Errorf("outgoing Google header %q; want a traced header for trace %s", google[0], tpTraceID)
+		}
+
+		out = outgoingHeaders(ctx, WithHeaderFormats(GoogleHeader|TraceparentHeader))
+		want := fmt.Sprintf("00-%s-%016x-01", tpTraceID, spanID)
+		if got := out[traceparentKey]; len(got) != 1 || got[0] != want {
+			t.Errorf("outgoing traceparent = %q; want %q", got, want)
+		}
+		if got := out[grpcMetadataKey]; len(got) != 1 {
+			t.Errorf("outgoing Google headers = %q; want one", got)
+		}
+
+		out = outgoingHeaders(ctx, WithHeaderFormats(TraceparentHeader))
+		if got := out[grpcMetadataKey]; len(got) != 0 {
+			t.Errorf("traceparent-only client interceptor sent Google header %q", got)
+		}
+		if got := out[traceparentKey]; len(got) != 1 {
+			t.Errorf("outgoing traceparent headers = %q; want one", got)
+		}
+		return nil, nil
+	})
+}
@@ -0,0 +1,281 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+)
+
+// A Matcher is a condition on an exported span, for AssertSpan.
+type Matcher struct {
+	desc  string
+	match func(s *trace.SpanData, spans []*trace.SpanData) bool
+}
+
+// String describes the condition, as it is written in Go.
+func (m Matcher) String() string { return m.desc }
+
+// Name matches spans with the given name.
+func Name(name string) Matcher {
+	return Matcher{
+		desc:  fmt.Sprintf("Name(%q)", name),
+		match: func(s *trace.SpanData, _ []*trace.SpanData) bool { return s.Name == name },
+	}
+}
+
+// HasLabel matches spans whose label for key has the given value.
+func HasLabel(key, value string) Matcher {
+	return Matcher{
+		desc: fmt.Sprintf("HasLabel(%q, %q)", key, value),
+		match: func(s *trace.SpanData, _ []*trace.SpanData) bool {
+			v, ok := s.Labels[key]
+			return ok && v == value
+		},
+	}
+}
+
+// ChildOf matches spans whose parent is an exported span with the given
+// name.
+func ChildOf(parentName string) Matcher {
+	return Matcher{
+		desc: fmt.Sprintf("ChildOf(%q)", parentName),
+		match: func(s *trace.SpanData, spans []*trace.SpanData) bool {
+			p := parent(s, spans)
+			return p != nil && p.Name == parentName
+		},
+	}
+}
+
+// Root matches spans whose parent was not exported, such as the root span
+// of a trace, or the span of a server call whose caller is in another
+// process.
+func Root() Matcher {
+	return Matcher{
+		desc:  "Root()",
+		match: func(s *trace.SpanData, spans []*trace.SpanData) bool { return parent(s, spans) == nil },
+	}
+}
+
+// Kind matches spans of the given kind.
+func Kind(k trace.SpanKind) Matcher {
+	return Matcher{
+		desc:  "Kind(" + kindName(k) + ")",
+		match: func(s *trace.SpanData, _ []*trace.SpanData) bool { return s.Kind == k },
+	}
+}
+
+func kindName(k trace.SpanKind) string {
+	switch k {
+	case trace.SpanKindUnspecified:
+		return "trace.SpanKindUnspecified"
+	case trace.SpanKindClient:
+		return "trace.SpanKindClient"
+	case trace.SpanKindServer:
+		return "trace.SpanKindServer"
+	}
+	return strconv.Itoa(int(k))
+}
+
+// DurationBetween matches spans that lasted at least min and at most max.
+func DurationBetween(min, max time.Duration) Matcher {
+	return Matcher{
+		desc: fmt.Sprintf("DurationBetween(%v, %v)", min, max),
+		match: func(s *trace.SpanData, _ []*trace.SpanData) bool {
+			d := s.End.Sub(s.Start)
+			return d >= min && d <= max
+		},
+	}
+}
+
+// parent returns the span in spans that is the parent of s, or nil.
+func parent(s *trace.SpanData, spans []*trace.SpanData) *trace.SpanData {
+	if s.ParentSpanID == 0 {
+		return nil
+	}
+	for _, p := range spans {
+		if p.TraceID == s.TraceID && p.SpanID == s.ParentSpanID {
+			return p
+		}
+	}
+	return nil
+}
+
+// AssertSpan reports an error to t unless exactly one of the spans exported
+// to e matches all the matchers, and returns that span.  The error shows the
+// tree of the exported spans.  If no span matches, AssertSpan returns nil.
+func AssertSpan(t testing.TB, e *Exporter, matchers ...Matcher) *trace.SpanData {
+	t.Helper()
+	spans := AssertSpans(t, e, 1, matchers...)
+	if len(spans) != 1 {
+		return nil
+	}
+	return spans[0]
+}
+
+// AssertSpans reports an error to t unless exactly n of the spans exported
+// to e match all the matchers, and returns the matching spans.  The error
+// shows the tree of the exported spans.
+func AssertSpans(t testing.TB, e *Exporter, n int, matchers ...Matcher) []*trace.SpanData {
+	t.Helper()
+	spans := e.Spans()
+	var matched []*trace.SpanData
+	for _, s := range spans {
+		if matchAll(s, spans, matchers) {
+			matched = append(matched, s)
+		}
+	}
+	if len(matched) != n {
+		desc := make([]string, len(matchers))
+		for i, m := range matchers {
+			desc[i] = m.String()
+		}
+		t.Errorf("%d spans match %s; want %d. Exported spans:\n%s", len(matched), strings.Join(desc, ", "), n, SpanTree(spans))
+	}
+	return matched
+}
+
+func matchAll(s *trace.SpanData, spans []*trace.SpanData, matchers []Matcher) bool {
+	for _, m := range matchers {
+		if !m.match(s, spans) {
+			return false
+		}
+	}
+	return true
+}
+
+// AssertTree reports an error to t unless the tree of the spans exported to
+// e, as formatted by SpanTree, is want.  Leading and trailing blank lines of
+// want, and the indentation common to all its lines, are ignored, so that
+// the tree can be written as a raw string:
+//
+//   tracetest.AssertTree(t, e, `
+//       /root
+//         /call
+//         /call
+//   `)
+//
+// The error shows both trees, and marks the lines that differ.
+func AssertTree(t testing.TB, e *Exporter, want string) {
+	t.Helper()
+	got := SpanTree(e.Spans())
+	want = dedent(want)
+	if got == want {
+		return
+	}
+	t.Errorf("span tree differs (- want, + got):\n%s", diffLines(want, got))
+}
+
+// SpanTree formats spans as a tree.  Each span is on its own line, with its
+// name quoted if it is empty or has surrounding spaces, and is indented two
+// spaces more than its parent.  Spans whose parent is not in spans are at the
+// top level.  Siblings are sorted by name, and then by start time.
+func SpanTree(spans []*trace.SpanData) string {
+	children := make(map[*trace.SpanData][]*trace.SpanData)
+	var roots []*trace.SpanData
+	for _, s := range spans {
+		if p := parent(s, spans); p != nil {
+			children[p] = append(children[p], s)
+		} else {
+			roots = append(roots, s)
+		}
+	}
+	var buf bytes.Buffer
+	var write func(s *trace.SpanData, depth int)
+	write = func(s *trace.SpanData, depth int) {
+		name := s.Name
+		if name == "" || strings.TrimSpace(name) != name {
+			name = strconv.Quote(name)
+		}
+		fmt.Fprintf(&buf, "%s%s\n", strings.Repeat("  ", depth), name)
+		for _, c := range sortSpans(children[s]) {
+			write(c, depth+1)
+		}
+	}
+	for _, s := range sortSpans(roots) {
+		write(s, 0)
+	}
+	return buf.String()
+}
+
+func sortSpans(spans []*trace.SpanData) []*trace.SpanData {
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].Name != spans[j].Name {
+			return spans[i].Name < spans[j].Name
+		}
+		return spans[i].Start.Before(spans[j].Start)
+	})
+	return spans
+}
+
+// dedent removes the leading and trailing blank lines of s, and the
+// indentation common to all its non-blank lines.  The result ends in a
+// newline, unless it is empty.
+func dedent(s string) string {
+	lines := strings.Split(s, "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	indent := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		n := len(l) - len(strings.TrimLeft(l, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if len(l) >= indent && indent > 0 {
+			l = l[indent:]
+		}
+		buf.WriteString(strings.TrimRight(l, " \t"))
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// diffLines returns want and got interleaved line by line, with the lines
+// that differ marked "-" for want and "+" for got.
+func diffLines(want, got string) string {
+	w := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	g := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	var buf bytes.Buffer
+	for i := 0; i < len(w) || i < len(g); i++ {
+		switch {
+		case i >= len(g):
+			fmt.Fprintf(&buf, "- %s\n", w[i])
+		case i >= len(w):
+			fmt.Fprintf(&buf, "+ %s\n", g[i])
+		case w[i] == g[i]:
+			fmt.Fprintf(&buf, "  %s\n", w[i])
+		default:
+			fmt.Fprintf(&buf, "- %s\n+ %s\n", w[i], g[i])
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+)
+
+// recordingT is a testing.TB that records the errors reported to it.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+// exportTestTrace exports a trace with a root span, two calls and a child of
+// the second call.
+func exportTestTrace(t *testing.T) *Exporter {
+	e := &Exporter{}
+	root := trace.NewClientWithExporter(e).NewSpan("/root")
+	root.NewChild("/call").Finish()
+	call := root.NewChild("/call")
+	call.SetLabel("attempt", "2")
+	call.NewChild("/retry").Finish()
+	call.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestAssertSpan(t *testing.T) {
+	e := exportTestTrace(t)
+	for _, tt := range []struct {
+		matchers []Matcher
+		wantErr  string // a substring of the error, or "" for none.
+	}{
+		{matchers: []Matcher{Name("/root"), Root()}},
+		{matchers: []Matcher{Name("/call"), HasLabel("attempt", "2"), ChildOf("/root")}},
+		{matchers: []Matcher{Name("/retry"), ChildOf("/call"), DurationBetween(0, time.Minute)}},
+		{matchers: []Matcher{Name("/call")}, wantErr: `2 spans match Name("/call"); want 1`},
+		{matchers: []Matcher{Name("/retry"), ChildOf("/root")}, wantErr: `0 spans match Name("/retry"), ChildOf("/root")`},
+		{matchers: []Matcher{HasLabel("attempt", "3")}, wantErr: "/root\n  /call\n  /call\n    /retry\n"},
+		{matchers: []Matcher{Name("/root"), DurationBetween(time.Hour, 2*time.Hour)}, wantErr: "DurationBetween(1h0m0s, 2h0m0s)"},
+		{matchers: []Matcher{Name("/root"), Kind(trace.SpanKindServer)}, wantErr: "Kind(trace.SpanKindServer)"},
+	} {
+		rt := &recordingT{}
+		s := AssertSpan(rt, e, tt.matchers...)
+		if tt.wantErr == "" {
+			if len(rt.errors) != 0 || s == nil {
+				t.Errorf("%v: got %v, errors %q; want a span and no errors", tt.matchers, s, rt.errors)
+			}
+			continue
+		}
+		if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], tt.wantErr) {
+			t.Errorf("%v: got errors %q; want one error containing %q", tt.matchers, rt.errors, tt.wantErr)
+		}
+		if s != nil {
+			t.Errorf("%v: returned span %q after an error", tt.matchers, s.Name)
+		}
+	}
+}
+
+func TestAssertSpans(t *testing.T) {
+	e := exportTestTrace(t)
+	rt := &recordingT{}
+	if got := AssertSpans(rt, e, 2, Name("/call"), ChildOf("/root")); len(got) != 2 || len(rt.errors) != 0 {
+		t.Errorf("got %d spans, errors %q; want 2 spans and no errors", len(got), rt.errors)
+	}
+}
+
+func TestAssertTree(t *testing.T) {
+	e := exportTestTrace(t)
+	rt := &recordingT{}
+	AssertTree(rt, e, `
+		/root
+		  /call
+		  /call
+		    /retry
+	`)
+	if len(rt.errors) != 0 {
+		t.Errorf("got errors %q; want none", rt.errors)
+	}
+
+	AssertTree(rt, e, `
+		/root
+		  /call
+		    /retry
+	`)
+	want := "span tree differs (- want, + got):\n  /root\n    /call\n-     /retry\n+   /call\n+     /retry\n"
+	if len(rt.errors) != 1 || rt.errors[0] != want {
+		t.Errorf("got errors %q; want %q", rt.errors, want)
+	}
+}
+
+func TestSpanTreeQuotesNames(t *testing.T) {
+	spans := []*trace.SpanData{
+		{TraceID: "t", SpanID: 1, Name: ""},
+		{TraceID: "t", SpanID: 2, ParentSpanID: 1, Name: " padded "},
+		{TraceID: "t", SpanID: 3, ParentSpanID: 9, Name: "orphan"},
+	}
+	if got, want := SpanTree(spans), "\"\"\n  \" padded \"\norphan\n"; got != want {
+		t.Errorf("SpanTree = %q; want %q", got, want)
+	}
+}
+
+func TestExporterWaitForSpans(t *testing.T) {
+	e := &Exporter{}
+	go e.ExportSpans(nil, []*trace.SpanData{{Name: "a"}, {Name: "b"}})
+	if got := e.WaitForSpans(2, 5*time.Second); len(got) != 2 {
+		t.Errorf("WaitForSpans returned %d spans; want 2", len(got))
+	}
+	if got := e.WaitForSpans(3, 10*time.Millisecond); len(got) != 2 {
+		t.Errorf("WaitForSpans after the timeout returned %d spans; want 2", len(got))
+	}
+	e.Reset()
+	if got := e.Spans(); len(got) != 0 {
+		t.Errorf("Spans after Reset returned %d spans; want 0", len(got))
+	}
+}
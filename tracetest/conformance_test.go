@@ -0,0 +1,214 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// This file tests a system of three tiers, each with its own client, as if
+// in its own process: an HTTP front end calls a unary method of a middle
+// tier, which calls a streaming method of a backend.  Every change to the
+// propagation of traces between processes must keep it passing.
+
+// The services of the middle tier and the backend.  middleDesc's server is
+// the connection to the backend.
+var (
+	middleDesc = grpc.ServiceDesc{
+		ServiceName: "test.Middle",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Call",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				if err := dec(&empty.Empty{}); err != nil {
+					return nil, err
+				}
+				h := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return &empty.Empty{}, callBackend(ctx, srv.(*grpc.ClientConn))
+				}
+				return interceptor(ctx, nil, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Middle/Call"}, h)
+			},
+		}},
+	}
+
+	backendDesc = grpc.ServiceDesc{
+		ServiceName: "test.Backend",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Stream",
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler: func(srv interface{}, ss grpc.ServerStream) error {
+				for {
+					if err := ss.RecvMsg(&empty.Empty{}); err == io.EOF {
+						return nil
+					} else if err != nil {
+						return err
+					}
+					if err := ss.SendMsg(&empty.Empty{}); err != nil {
+						return err
+					}
+				}
+			},
+		}},
+	}
+)
+
+// backendMessages is the number of messages the middle tier sends on its
+// stream to the backend, which echoes each of them.
+const backendMessages = 2
+
+// callBackend makes a call of the backend's streaming method on conn.
+func callBackend(ctx context.Context, conn *grpc.ClientConn) error {
+	cs, err := conn.NewStream(ctx, &backendDesc.Streams[0], "/test.Backend/Stream")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < backendMessages; i++ {
+		if err := cs.SendMsg(&empty.Empty{}); err != nil {
+			return err
+		}
+		if err := cs.RecvMsg(&empty.Empty{}); err != nil {
+			return err
+		}
+	}
+	if err := cs.CloseSend(); err != nil {
+		return err
+	}
+	if err := cs.RecvMsg(&empty.Empty{}); err != io.EOF {
+		return fmt.Errorf("backend stream ended with %v; want io.EOF", err)
+	}
+	return nil
+}
+
+// serveTier starts a gRPC server for desc, traced with tc, whose server is
+// srv, and returns a traced connection to it and a function that stops it.
+func serveTier(t *testing.T, tc *trace.Client, desc *grpc.ServiceDesc, srv interface{}) (*grpc.ClientConn, func()) {
+	s := grpc.NewServer(trace.GRPCServerOptions(tc)...)
+	s.RegisterService(desc, srv)
+	lis := bufconn.Listen(1 << 16)
+	go s.Serve(lis)
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithUnaryInterceptor(trace.GRPCClientInterceptor()),
+		grpc.WithStreamInterceptor(trace.GRPCStreamClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestConformance(t *testing.T) {
+	frontTC, front := NewTestClient()
+	middleTC, middle := NewTestClient()
+	backendTC, backend := NewTestClient()
+
+	backendConn, stop := serveTier(t, backendTC, &backendDesc, struct{}{})
+	defer stop()
+	middleConn, stop := serveTier(t, middleTC, &middleDesc, backendConn)
+	defer stop()
+	srv := httptest.NewServer(frontTC.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := middleConn.Invoke(r.Context(), "/test.Middle/Call", &empty.Empty{}, &empty.Empty{}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})))
+	defer srv.Close()
+
+	// The request comes from an edge proxy that traces it.
+	const traceID, edgeSpanID = "0123456789abcdef0123456789abcdef", 42
+	req, err := http.NewRequest("GET", srv.URL+"/front", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Cloud-Trace-Context", fmt.Sprintf("%s/%d;o=1", traceID, edgeSpanID))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("front end returned status %d", resp.StatusCode)
+	}
+
+	for tier, rec := range map[string]*Recorder{"front": front, "middle": middle, "backend": backend} {
+		spans := rec.Spans()
+		if len(spans) == 0 {
+			t.Errorf("the %s tier exported no spans", tier)
+		}
+		for _, s := range spans {
+			if s.TraceID != traceID {
+				t.Errorf("the %s tier exported span %q of trace %s; want trace %s", tier, s.Name, s.TraceID, traceID)
+			}
+		}
+	}
+
+	// The front end: the span of the HTTP request is a child of the edge's
+	// span, and the parent of the span of the call to the middle tier.
+	frontSpan := AssertSpan(t, front.Exporter(), Kind(trace.SpanKindServer),
+		HasLabel("trace.cloud.google.com/http/method", "GET"),
+		HasLabel("trace.cloud.google.com/http/status_code", "200"))
+	middleCall := AssertSpan(t, front.Exporter(), Name("/test.Middle/Call"), Kind(trace.SpanKindClient),
+		HasLabel("grpc/service", "test.Middle"), HasLabel("grpc/method", "Call"), HasLabel("grpc/status_code", "OK"))
+	if frontSpan == nil || middleCall == nil {
+		t.FailNow()
+	}
+	if frontSpan.ParentSpanID != edgeSpanID {
+		t.Errorf("the span of the front end has parent %d; want the edge's span %d", frontSpan.ParentSpanID, edgeSpanID)
+	}
+	if middleCall.ParentSpanID != frontSpan.SpanID {
+		t.Errorf("the span of the call to the middle tier has parent %d; want the front end's span %d", middleCall.ParentSpanID, frontSpan.SpanID)
+	}
+
+	// The middle tier: as Stackdriver Trace expects, the server span has the
+	// same parent as the client span of the call.  It is the parent of the
+	// span of the stream to the backend.
+	middleSpan := AssertSpan(t, middle.Exporter(), Kind(trace.SpanKindServer), Root(),
+		HasLabel("grpc/service", "test.Middle"), HasLabel("grpc/method", "Call"), HasLabel("grpc/status_code", "OK"))
+	backendCall := AssertSpan(t, middle.Exporter(), Name("/test.Backend/Stream"), Kind(trace.SpanKindClient),
+		HasLabel("grpc/service", "test.Backend"), HasLabel("grpc/method", "Stream"), HasLabel("grpc/status_code", "OK"),
+		HasLabel("grpc/sent_messages", fmt.Sprint(backendMessages)), HasLabel("grpc/recv_messages", fmt.Sprint(backendMessages)))
+	if middleSpan == nil || backendCall == nil {
+		t.FailNow()
+	}
+	if middleSpan.ParentSpanID != frontSpan.SpanID {
+		t.Errorf("the span of the middle tier has parent %d; want the front end's span %d", middleSpan.ParentSpanID, frontSpan.SpanID)
+	}
+	if backendCall.ParentSpanID != middleSpan.SpanID {
+		t.Errorf("the span of the stream to the backend has parent %d; want the middle tier's span %d", backendCall.ParentSpanID, middleSpan.SpanID)
+	}
+
+	// The backend.
+	backendSpan := AssertSpan(t, backend.Exporter(), Kind(trace.SpanKindServer), Root(),
+		HasLabel("grpc/service", "test.Backend"), HasLabel("grpc/method", "Stream"), HasLabel("grpc/status_code", "OK"),
+		HasLabel("grpc/sent_messages", fmt.Sprint(backendMessages)), HasLabel("grpc/recv_messages", fmt.Sprint(backendMessages)))
+	if backendSpan != nil && backendSpan.ParentSpanID != middleSpan.SpanID {
+		t.Errorf("the span of the backend has parent %d; want the middle tier's span %d", backendSpan.ParentSpanID, middleSpan.SpanID)
+	}
+}
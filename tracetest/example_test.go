@@ -0,0 +1,77 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest_test
+
+import (
+	"fmt"
+	"log"
+	"testing"
+
+	"cloud.google.com/go/trace"
+	"cloud.google.com/go/trace/tracetest"
+)
+
+func ExampleAssertSpan() {
+	var t *testing.T // the test's *testing.T.
+
+	e := &tracetest.Exporter{}
+	root := trace.NewClientWithExporter(e).NewSpan("/root")
+	child := root.NewChild("/lookup")
+	child.SetLabel("cache", "miss")
+	child.Finish()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	tracetest.AssertSpan(t, e,
+		tracetest.Name("/lookup"),
+		tracetest.ChildOf("/root"),
+		tracetest.HasLabel("cache", "miss"))
+}
+
+func ExampleAssertTree() {
+	var t *testing.T // the test's *testing.T.
+
+	e := &tracetest.Exporter{}
+	root := trace.NewClientWithExporter(e).NewSpan("/root")
+	for i := 0; i < 2; i++ {
+		root.NewChild("/attempt").Finish()
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	tracetest.AssertTree(t, e, `
+		/root
+		  /attempt
+		  /attempt
+	`)
+}
+
+func ExampleSpanTree() {
+	e := &tracetest.Exporter{}
+	root := trace.NewClientWithExporter(e).NewSpan("/root")
+	call := root.NewChild("/call")
+	call.NewChild("/retry").Finish()
+	call.Finish()
+	if err := root.FinishWait(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(tracetest.SpanTree(e.Spans()))
+	// Output:
+	// /root
+	//   /call
+	//     /retry
+}
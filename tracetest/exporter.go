@@ -0,0 +1,247 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/trace"
+)
+
+// Exporter is a trace.Exporter that keeps the spans it exports in memory,
+// for tests to inspect with AssertSpan and AssertTree.  The zero Exporter is
+// ready to use; its queries scan all the exported spans.  For tests that
+// export many spans, NewIndexedExporter creates an Exporter that indexes
+// them, and can bound how many it keeps.
+type Exporter struct {
+	mu      sync.Mutex
+	spans   []*trace.SpanData
+	updated chan struct{} // closed and replaced when spans are exported.
+
+	total   int   // spans exported, including evicted ones.
+	evicted int64 // spans evicted because of maxSpans.
+
+	// Set by NewIndexedExporter.
+	indexed   bool
+	maxSpans  int // 0 for no limit.
+	byName    map[string][]*trace.SpanData
+	byTrace   map[string][]*trace.SpanData
+	byLabel   map[string]map[string][]*trace.SpanData // key, then value.
+	labelKeys []string
+}
+
+// NewIndexedExporter returns an Exporter that indexes the spans it exports
+// by name, by trace ID, and by the values of the labels with the given
+// keys, so that SpansNamed, TraceByID and SpansWithLabel don't scan all the
+// spans.  If maxSpans is positive, the Exporter keeps only the newest
+// maxSpans spans; older spans are evicted, oldest first, and counted by
+// Evicted.
+func NewIndexedExporter(maxSpans int, labelKeys ...string) *Exporter {
+	e := &Exporter{indexed: true, maxSpans: maxSpans, labelKeys: labelKeys}
+	e.resetIndexes()
+	return e
+}
+
+func (e *Exporter) resetIndexes() {
+	e.byName = make(map[string][]*trace.SpanData)
+	e.byTrace = make(map[string][]*trace.SpanData)
+	e.byLabel = make(map[string]map[string][]*trace.SpanData, len(e.labelKeys))
+	for _, k := range e.labelKeys {
+		e.byLabel[k] = make(map[string][]*trace.SpanData)
+	}
+}
+
+// ExportSpans records spans.
+func (e *Exporter) ExportSpans(ctx context.Context, spans []*trace.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	e.total += len(spans)
+	if e.indexed {
+		for _, s := range spans {
+			e.byName[s.Name] = append(e.byName[s.Name], s)
+			e.byTrace[s.TraceID] = append(e.byTrace[s.TraceID], s)
+			for k, values := range e.byLabel {
+				if v, ok := s.Labels[k]; ok {
+					values[v] = append(values[v], s)
+				}
+			}
+		}
+		if e.maxSpans > 0 && len(e.spans) > e.maxSpans {
+			e.evict(len(e.spans) - e.maxSpans)
+		}
+	}
+	if e.updated != nil {
+		close(e.updated)
+		e.updated = nil
+	}
+	return nil
+}
+
+// evict removes the n oldest spans.  Since the index lists are in the order
+// spans were exported, each evicted span is the first of its lists.
+func (e *Exporter) evict(n int) {
+	for _, s := range e.spans[:n] {
+		removeFirst(e.byName, s.Name)
+		removeFirst(e.byTrace, s.TraceID)
+		for k, values := range e.byLabel {
+			if v, ok := s.Labels[k]; ok {
+				removeFirst(values, v)
+			}
+		}
+	}
+	e.spans = append([]*trace.SpanData(nil), e.spans[n:]...)
+	e.evicted += int64(n)
+}
+
+func removeFirst(index map[string][]*trace.SpanData, key string) {
+	if list := index[key]; len(list) > 1 {
+		index[key] = list[1:]
+	} else {
+		delete(index, key)
+	}
+}
+
+// Spans returns the spans exported so far, in the order they were exported.
+func (e *Exporter) Spans() []*trace.SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*trace.SpanData(nil), e.spans...)
+}
+
+// Reset discards the spans exported so far, and resets the count of evicted
+// spans.
+func (e *Exporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+	e.total, e.evicted = 0, 0
+	if e.indexed {
+		e.resetIndexes()
+	}
+}
+
+// Evicted returns the number of spans the Exporter discarded to keep the
+// maximum number of spans passed to NewIndexedExporter.  A test that finds
+// a span missing can check it to tell whether the span was evicted.
+func (e *Exporter) Evicted() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.evicted
+}
+
+// SpansNamed returns the exported spans with the given name, in the order
+// they were exported.
+func (e *Exporter) SpansNamed(name string) []*trace.SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.indexed {
+		return append([]*trace.SpanData(nil), e.byName[name]...)
+	}
+	return e.scan(func(s *trace.SpanData) bool { return s.Name == name })
+}
+
+// SpansWithLabel returns the exported spans whose label for key has the
+// given value, in the order they were exported.  The lookup uses the index
+// if key was passed to NewIndexedExporter, and scans the spans otherwise.
+func (e *Exporter) SpansWithLabel(key, value string) []*trace.SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if values, ok := e.byLabel[key]; ok {
+		return append([]*trace.SpanData(nil), values[value]...)
+	}
+	return e.scan(func(s *trace.SpanData) bool {
+		v, ok := s.Labels[key]
+		return ok && v == value
+	})
+}
+
+// scan returns the spans for which match is true.  e.mu must be held.
+func (e *Exporter) scan(match func(*trace.SpanData) bool) []*trace.SpanData {
+	var spans []*trace.SpanData
+	for _, s := range e.spans {
+		if match(s) {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// A SpanNode is an exported span and its exported children, which are
+// sorted by name and then by start time, as SpanTree sorts them.
+type SpanNode struct {
+	Span     *trace.SpanData
+	Children []*SpanNode
+}
+
+// TraceByID returns the exported spans of the trace with the given ID as a
+// tree: the returned nodes are the spans whose parent wasn't exported,
+// usually just the root span.  It returns nil if no span of the trace was
+// exported.
+func (e *Exporter) TraceByID(traceID string) []*SpanNode {
+	e.mu.Lock()
+	var spans []*trace.SpanData
+	if e.indexed {
+		spans = append(spans, e.byTrace[traceID]...)
+	} else {
+		spans = e.scan(func(s *trace.SpanData) bool { return s.TraceID == traceID })
+	}
+	e.mu.Unlock()
+
+	nodes := make(map[uint64]*SpanNode, len(spans))
+	for _, s := range sortSpans(spans) {
+		nodes[s.SpanID] = &SpanNode{Span: s}
+	}
+	var roots []*SpanNode
+	for _, s := range spans {
+		n := nodes[s.SpanID]
+		if p, ok := nodes[s.ParentSpanID]; ok && s.ParentSpanID != 0 && p != n {
+			p.Children = append(p.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+	return roots
+}
+
+// WaitForSpans waits until at least n spans have been exported, or until
+// timeout has passed, and returns the spans exported so far.  Evicted
+// spans count towards n, but aren't returned.  Use it when
+// spans are uploaded in the background, as they are when a trace is
+// finished with Finish rather than FinishWait: the client can take a few
+// seconds to upload them.
+func (e *Exporter) WaitForSpans(n int, timeout time.Duration) []*trace.SpanData {
+	deadline := time.After(timeout)
+	for {
+		e.mu.Lock()
+		if e.total >= n {
+			spans := append([]*trace.SpanData(nil), e.spans...)
+			e.mu.Unlock()
+			return spans
+		}
+		if e.updated == nil {
+			e.updated = make(chan struct{})
+		}
+		updated := e.updated
+		e.mu.Unlock()
+		select {
+		case <-updated:
+		case <-deadline:
+			return e.Spans()
+		}
+	}
+}
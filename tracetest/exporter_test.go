@@ -0,0 +1,121 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/trace"
+)
+
+// exportSpans exports n spans, in traces of ten: each trace has a root
+// span and nine children, labeled with their index.
+func exportSpans(e *Exporter, n int) {
+	spans := make([]*trace.SpanData, n)
+	for i := range spans {
+		s := &trace.SpanData{
+			TraceID: fmt.Sprintf("%032x", i/10),
+			SpanID:  uint64(i + 1),
+			Name:    fmt.Sprint("span", i%10),
+			Labels:  map[string]string{"index": fmt.Sprint(i), "parity": fmt.Sprint(i % 2)},
+		}
+		if i%10 != 0 {
+			s.ParentSpanID = uint64(i/10*10 + 1)
+		}
+		spans[i] = s
+	}
+	e.ExportSpans(context.Background(), spans)
+}
+
+func TestExporterQueries(t *testing.T) {
+	for _, e := range []*Exporter{{}, NewIndexedExporter(0, "index")} {
+		exportSpans(e, 100)
+		if got := e.SpansNamed("span3"); len(got) != 10 || got[0].SpanID != 4 {
+			t.Errorf("indexed %v: SpansNamed returned %d spans; want 10, starting with span ID 4", e.indexed, len(got))
+		}
+		for _, key := range []string{"index", "parity"} { // indexed and not indexed.
+			if got := e.SpansWithLabel(key, "1"); len(got) == 0 || got[0].SpanID != 2 {
+				t.Errorf("indexed %v: SpansWithLabel(%q, 1) = %d spans; want span ID 2 first", e.indexed, key, len(got))
+			}
+		}
+		if got := e.SpansWithLabel("index", "none"); len(got) != 0 {
+			t.Errorf("indexed %v: SpansWithLabel for a missing value returned %d spans", e.indexed, len(got))
+		}
+
+		roots := e.TraceByID(fmt.Sprintf("%032x", 2))
+		if len(roots) != 1 || roots[0].Span.Name != "span0" || len(roots[0].Children) != 9 {
+			t.Fatalf("indexed %v: TraceByID returned %d roots; want span0 with 9 children", e.indexed, len(roots))
+		}
+		for i, c := range roots[0].Children {
+			if want := fmt.Sprint("span", i+1); c.Span.Name != want {
+				t.Errorf("indexed %v: child %d is %s; want %s", e.indexed, i, c.Span.Name, want)
+			}
+		}
+		if got := e.TraceByID("missing"); got != nil {
+			t.Errorf("indexed %v: TraceByID of a missing trace = %v; want nil", e.indexed, got)
+		}
+
+		e.Reset()
+		if got := e.SpansNamed("span3"); len(got) != 0 {
+			t.Errorf("indexed %v: SpansNamed after Reset returned %d spans", e.indexed, len(got))
+		}
+	}
+}
+
+func TestExporterEviction(t *testing.T) {
+	e := NewIndexedExporter(25, "index")
+	exportSpans(e, 100)
+	if got := len(e.Spans()); got != 25 {
+		t.Errorf("kept %d spans; want 25", got)
+	}
+	if got := e.Evicted(); got != 75 {
+		t.Errorf("Evicted() = %d; want 75", got)
+	}
+	if got := e.SpansWithLabel("index", "10"); len(got) != 0 {
+		t.Error("evicted span is still indexed")
+	}
+	if got := e.SpansWithLabel("index", "99"); len(got) != 1 {
+		t.Errorf("SpansWithLabel of a kept span returned %d spans; want 1", len(got))
+	}
+	if got := e.SpansNamed("span0"); len(got) != 2 {
+		t.Errorf("SpansNamed(span0) returned %d spans; want 2", len(got))
+	}
+
+	// Children of an evicted root are roots of their trace.
+	if got := e.TraceByID(fmt.Sprintf("%032x", 7)); len(got) != 5 {
+		t.Errorf("TraceByID of a partly evicted trace returned %d roots; want 5", len(got))
+	}
+	if got := len(e.WaitForSpans(100, 0)); got != 25 {
+		t.Errorf("WaitForSpans(100) returned %d spans; want the 25 kept", got)
+	}
+}
+
+func benchmarkSpansWithLabel(b *testing.B, e *Exporter) {
+	exportSpans(e, 100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if got := e.SpansWithLabel("index", "54321"); len(got) != 1 {
+			b.Fatalf("got %d spans; want 1", len(got))
+		}
+	}
+}
+
+func BenchmarkSpansWithLabelScan(b *testing.B) { benchmarkSpansWithLabel(b, &Exporter{}) }
+
+func BenchmarkSpansWithLabelIndexed(b *testing.B) {
+	benchmarkSpansWithLabel(b, NewIndexedExporter(0, "index"))
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// SequentialIDs is a trace.IDGenerator that makes deterministic IDs, for
+// tests that compare spans with golden files:
+//
+//   tc, rec := tracetest.NewTestClient()
+//   tc.SetIDGenerator(&tracetest.SequentialIDs{})
+//
+// The n'th trace ID it returns is n in hexadecimal, padded to 32 digits, and
+// the n'th span ID is n.  The zero SequentialIDs starts at 1.
+type SequentialIDs struct {
+	traces uint64
+	spans  uint64
+}
+
+// NewTraceID implements trace.IDGenerator.
+func (s *SequentialIDs) NewTraceID() string {
+	return fmt.Sprintf("%032x", atomic.AddUint64(&s.traces, 1))
+}
+
+// NewSpanID implements trace.IDGenerator.
+func (s *SequentialIDs) NewSpanID() uint64 {
+	return atomic.AddUint64(&s.spans, 1)
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"context"
+
+	"cloud.google.com/go/trace"
+)
+
+// A Recorder records the spans of a client returned by NewTestClient in
+// memory.  As with any client, the spans of a trace are recorded when its
+// root span finishes; the methods of a Recorder first wait until the
+// traces finished so far are recorded, so a test can call them right after
+// finishing its spans, or after a call traced by a server interceptor
+// returns.
+type Recorder struct {
+	tc *trace.Client
+	e  *Exporter
+}
+
+// NewTestClient returns a trace client for unit tests, which needs no
+// credentials or project and never uses the network: it traces every span,
+// including those of incoming requests whose trace headers don't ask for
+// tracing, and records them with the returned Recorder.  Its IDs are
+// random; set a SequentialIDs with SetIDGenerator for deterministic IDs.
+//
+// The client works with all the interceptors of the trace package, so a
+// test can check how calls to a test server are traced:
+//
+//   tc, rec := tracetest.NewTestClient()
+//   srv := grpc.NewServer(trace.GRPCServerOptions(tc)...)
+//   ...
+//   conn, err := grpc.Dial(target, grpc.WithUnaryInterceptor(trace.GRPCClientInterceptor()), ...)
+//   ...
+//   root := tc.NewSpan("/test")
+//   err = conn.Invoke(trace.NewContext(ctx, root), "/test.Service/Method", req, reply)
+//   root.Finish()
+//   tracetest.AssertTree(t, rec.Exporter(), ...)
+//
+// As in production, the span of a traced server call has the same parent
+// as the span of the client call, which Stackdriver Trace displays as one
+// span: in the example, both are children of the "/test" span.
+func NewTestClient() (*trace.Client, *Recorder) {
+	e := &Exporter{}
+	tc := trace.NewClientWithExporter(e)
+	tc.SetSamplingPolicy(alwaysSample{})
+	return tc, &Recorder{tc: tc, e: e}
+}
+
+type alwaysSample struct{}
+
+func (alwaysSample) Sample(trace.Parameters) trace.Decision {
+	return trace.Decision{Trace: true}
+}
+
+// flush waits until the traces finished so far are recorded.
+func (r *Recorder) flush() {
+	r.tc.Flush(context.Background())
+}
+
+// Spans returns the recorded spans, with their names, IDs, parents, labels
+// and start and end times, in the order they were recorded.
+func (r *Recorder) Spans() []*trace.SpanData {
+	r.flush()
+	return r.e.Spans()
+}
+
+// SpansByName returns the recorded spans with the given name, in the order
+// they were recorded.
+func (r *Recorder) SpansByName(name string) []*trace.SpanData {
+	r.flush()
+	return r.e.SpansNamed(name)
+}
+
+// Reset discards the spans recorded so far.
+func (r *Recorder) Reset() {
+	r.flush()
+	r.e.Reset()
+}
+
+// Exporter returns the Exporter in which r records spans, for AssertSpan,
+// AssertTree and the other queries of an Exporter.
+func (r *Recorder) Exporter() *Exporter {
+	r.flush()
+	return r.e
+}
@@ -0,0 +1,164 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// echoDesc describes a service with a unary and a streaming method, both of
+// which reply with an empty message.
+var echoDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Unary",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			if err := dec(&empty.Empty{}); err != nil {
+				return nil, err
+			}
+			h := func(ctx context.Context, req interface{}) (interface{}, error) {
+				trace.FromContext(ctx).NewChild("/handler").Finish()
+				return &empty.Empty{}, nil
+			}
+			return interceptor(ctx, nil, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Echo/Unary"}, h)
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Stream",
+		Handler: func(srv interface{}, ss grpc.ServerStream) error {
+			if err := ss.RecvMsg(&empty.Empty{}); err != nil {
+				return err
+			}
+			return ss.SendMsg(&empty.Empty{})
+		},
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+}
+
+func TestNewTestClient(t *testing.T) {
+	tc, rec := NewTestClient()
+	srv := grpc.NewServer(trace.GRPCServerOptions(tc)...)
+	srv.RegisterService(&echoDesc, struct{}{})
+	lis := bufconn.Listen(1 << 16)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithUnaryInterceptor(trace.GRPCClientInterceptor()),
+		grpc.WithStreamInterceptor(trace.GRPCStreamClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	root := tc.NewSpan("/test")
+	ctx := trace.NewContext(context.Background(), root)
+	if err := conn.Invoke(ctx, "/test.Echo/Unary", &empty.Empty{}, &empty.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := conn.NewStream(ctx, &echoDesc.Streams[0], "/test.Echo/Stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.SendMsg(&empty.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		err = cs.RecvMsg(&empty.Empty{})
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	root.Finish()
+
+	if got := len(rec.SpansByName("/test")); got != 1 {
+		t.Fatalf("recorded %d root spans; want 1", got)
+	}
+	// As Stackdriver Trace expects, the spans of both sides of each call have
+	// the same parent, the span of the caller.  The server spans are unnamed.
+	parent := AssertSpan(t, rec.Exporter(), Name("/test"))
+	for _, method := range []string{"/test.Echo/Unary", "/test.Echo/Stream"} {
+		AssertSpan(t, rec.Exporter(), Name(method), Kind(trace.SpanKindClient), ChildOf("/test"))
+	}
+	for _, s := range AssertSpans(t, rec.Exporter(), 2, Kind(trace.SpanKindServer)) {
+		if s.TraceID != parent.TraceID || s.ParentSpanID != parent.SpanID {
+			t.Errorf("server span %s/%d has parent %d; want a child of %s/%d", s.TraceID, s.SpanID, s.ParentSpanID, parent.TraceID, parent.SpanID)
+		}
+		if s.Start.IsZero() || s.End.Before(s.Start) {
+			t.Errorf("server span %d from %v to %v", s.SpanID, s.Start, s.End)
+		}
+	}
+	AssertSpan(t, rec.Exporter(), Name("/handler"), ChildOf(""))
+
+	rec.Reset()
+	if spans := rec.Spans(); len(spans) != 0 {
+		t.Errorf("after Reset, recorded %d spans; want none", len(spans))
+	}
+}
+
+func TestNewTestClientSamplesEverything(t *testing.T) {
+	tc, rec := NewTestClient()
+	tc.SpanFromHeader("/unsampled", "0123456789abcdef0123456789abcdef/1;o=0").Finish()
+	tc.SpanFromHeader("/no header", "").Finish()
+	for _, name := range []string{"/unsampled", "/no header"} {
+		if got := len(rec.SpansByName(name)); got != 1 {
+			t.Errorf("recorded %d spans named %q; want 1", got, name)
+		}
+	}
+}
+
+func TestSequentialIDs(t *testing.T) {
+	tc, rec := NewTestClient()
+	tc.SetIDGenerator(&SequentialIDs{})
+	for i := 0; i < 2; i++ {
+		root := tc.NewSpan("/root")
+		root.NewChild("/child").Finish()
+		root.Finish()
+	}
+	spans := rec.Spans()
+	if len(spans) != 4 {
+		t.Fatalf("recorded %d spans; want 4", len(spans))
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].SpanID < spans[j].SpanID })
+	for i, want := range []struct {
+		traceID      string
+		spanID       uint64
+		parentSpanID uint64
+	}{
+		{"00000000000000000000000000000001", 1, 0},
+		{"00000000000000000000000000000001", 2, 1},
+		{"00000000000000000000000000000002", 3, 0},
+		{"00000000000000000000000000000002", 4, 3},
+	} {
+		if s := spans[i]; s.TraceID != want.traceID || s.SpanID != want.spanID || s.ParentSpanID != want.parentSpanID {
+			t.Errorf("span %d: %s/%d, parent %d; want %s/%d, parent %d", i, s.TraceID, s.SpanID, s.ParentSpanID, want.traceID, want.spanID, want.parentSpanID)
+		}
+	}
+}
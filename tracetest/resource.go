@@ -0,0 +1,36 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"context"
+
+	"cloud.google.com/go/trace"
+)
+
+// ResourceTag returns the tag of the span in ctx, made by trace.ResourceTag,
+// to put in the names of the resources that a test creates, so that leaked
+// resources can be traced back to the test's trace with
+// trace.FromResourceTag.  The span doesn't need to be traced.  ResourceTag
+// returns "" if ctx has no span.
+//
+//   bucket := "test-" + tracetest.ResourceTag(ctx)
+func ResourceTag(ctx context.Context) string {
+	s := trace.FromContext(ctx)
+	if s == nil {
+		return ""
+	}
+	return trace.ResourceTag(s.SpanContext())
+}
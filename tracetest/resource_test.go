@@ -0,0 +1,34 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/trace"
+)
+
+func TestResourceTag(t *testing.T) {
+	if got := ResourceTag(context.Background()); got != "" {
+		t.Errorf("ResourceTag of a context without a span = %q; want \"\"", got)
+	}
+	span := NewClient().NewSpan("/test")
+	tag := ResourceTag(trace.NewContext(context.Background(), span))
+	sc, ok := trace.FromResourceTag(tag)
+	if !ok || sc.TraceID != span.TraceID() || sc.SpanID != span.SpanContext().SpanID {
+		t.Errorf("FromResourceTag(%q) = %+v, %t; want the span's trace %s", tag, sc, ok, span.TraceID())
+	}
+}
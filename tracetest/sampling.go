@@ -0,0 +1,95 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+)
+
+// CheckSampler calls policy's Sample method for requests requests, passing
+// it params(i) for the i'th request, and reports an error to t unless the
+// fraction of requests that policy decides to trace is within tolerance of
+// wantFraction.
+//
+// For a check that never fails spuriously, make the policy deterministic;
+// for the policies returned by trace.NewLimitedSampler, use the
+// trace.WithRandSource and trace.WithClock options.
+func CheckSampler(t testing.TB, policy trace.SamplingPolicy, requests int, wantFraction, tolerance float64, params func(i int) trace.Parameters) {
+	t.Helper()
+	traced := 0
+	for i := 0; i < requests; i++ {
+		if policy.Sample(params(i)).Trace {
+			traced++
+		}
+	}
+	got := float64(traced) / float64(requests)
+	if math.Abs(got-wantFraction) > tolerance {
+		t.Errorf("traced %d of %d requests (%.4f); want %.4f ± %.4f", traced, requests, got, wantFraction, tolerance)
+	}
+}
+
+// NoRemoteParent returns a function for CheckSampler that returns the
+// parameters of requests without a trace header.  The names of the requests
+// cycle through names; if names is empty, all requests are named "/".
+func NoRemoteParent(names ...string) func(i int) trace.Parameters {
+	return MixedRemoteParents(0, names...)
+}
+
+// RemoteParent returns a function for CheckSampler that returns the
+// parameters of requests with a trace header.  The names of the requests
+// cycle through names; if names is empty, all requests are named "/".
+func RemoteParent(names ...string) func(i int) trace.Parameters {
+	return MixedRemoteParents(1, names...)
+}
+
+// MixedRemoteParents returns a function for CheckSampler that returns the
+// parameters of requests of which the given fraction have a trace header,
+// evenly spread over the requests.  The names of the requests cycle through
+// names; if names is empty, all requests are named "/".  Each request has a
+// distinct trace ID.
+func MixedRemoteParents(fraction float64, names ...string) func(i int) trace.Parameters {
+	if len(names) == 0 {
+		names = []string{"/"}
+	}
+	return func(i int) trace.Parameters {
+		return trace.Parameters{
+			HasTraceHeader: math.Floor(float64(i+1)*fraction) > math.Floor(float64(i)*fraction),
+			Name:           names[i%len(names)],
+			TraceID:        fmt.Sprintf("%032x", i+1),
+		}
+	}
+}
+
+// SteppingClock returns a clock for trace.WithClock that returns start the
+// first time it is called, and advances by step on each call after that.
+// It is safe for concurrent use.
+func SteppingClock(start time.Time, step time.Duration) func() time.Time {
+	var (
+		mu  sync.Mutex
+		now = start.Add(-step)
+	)
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(step)
+		return now
+	}
+}
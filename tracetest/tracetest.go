@@ -0,0 +1,258 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracetest provides benchmarks and helpers for measuring the
+// overhead that the trace package adds to instrumented code, and helpers
+// for testing instrumentation.
+//
+// To run the benchmarks as part of your own benchmarks:
+//
+//   func BenchmarkTraceOverhead(b *testing.B) {
+//     for _, bm := range tracetest.BenchmarkSuite {
+//       b.Run(bm.Name, bm.Run)
+//     }
+//   }
+//
+// Each benchmark has an allocation budget.  The budgets are checked by the
+// tests of this package, so raising one is an explicit change to the
+// budget's constant.
+//
+// To check the spans that instrumented code creates, export them to an
+// Exporter and assert their structure:
+//
+//   e := &tracetest.Exporter{}
+//   tc := trace.NewClientWithExporter(e)
+//   ...
+//   tracetest.AssertSpan(t, e, tracetest.Name("/call"), tracetest.ChildOf("/root"),
+//       tracetest.HasLabel("shard", "7"))
+//
+// NewTestClient returns a client that traces everything and records the
+// spans in memory, for unit tests of traced code.
+package tracetest // import "cloud.google.com/go/trace/tracetest"
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Allocation budgets of the benchmarks in BenchmarkSuite, in allocations per
+// operation.  Each is slightly above the measured cost when it was last
+// changed.
+const (
+	// The unsampled client still propagates the trace header, so it pays
+	// for the outgoing metadata.
+	BudgetUnaryClientSampled   = 14
+	BudgetUnaryClientUnsampled = 9
+
+	// Part of the cost of a traced server call is paid by the goroutine
+	// that uploads the trace, so the measurement varies.
+	BudgetServerWithHeader    = 16
+	BudgetServerWithoutHeader = 1
+
+	BudgetChildWithLabels = 10
+	BudgetHeaderParse     = 3
+	BudgetHeaderFormat    = 4
+
+	BudgetFromContext = 0
+	BudgetNewContext  = 1
+)
+
+// rootSpanOps is the number of operations performed under each root span by
+// the benchmarks that create child spans, so that the spans of a trace don't
+// accumulate without bound.
+const rootSpanOps = 1000
+
+// A Benchmark measures the overhead of one instrumented operation.
+type Benchmark struct {
+	Name string
+
+	// MaxAllocs is the allocation budget of the operation.
+	MaxAllocs float64
+
+	// setup returns the operation to measure.
+	setup func() func()
+}
+
+// Run runs the benchmark.
+func (bm Benchmark) Run(b *testing.B) {
+	op := bm.setup()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		op()
+	}
+}
+
+// Measure measures the overhead of the benchmark's operation.
+func (bm Benchmark) Measure() Overhead {
+	return MeasureOverhead(bm.setup())
+}
+
+// Overhead is the cost of an operation.
+type Overhead struct {
+	NsPerOp     int64
+	AllocsPerOp float64
+}
+
+// MeasureOverhead returns the cost of calling op.  It runs op many times.
+func MeasureOverhead(op func()) Overhead {
+	r := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			op()
+		}
+	})
+	return Overhead{
+		NsPerOp:     r.NsPerOp(),
+		AllocsPerOp: testing.AllocsPerRun(100, op),
+	}
+}
+
+// DiscardExporter is a trace.Exporter that drops all spans.  Use it to
+// measure the overhead of tracing without the cost of uploading traces.
+type DiscardExporter struct{}
+
+// ExportSpans implements trace.Exporter.
+func (DiscardExporter) ExportSpans(ctx context.Context, spans []*trace.SpanData) error {
+	return nil
+}
+
+// NewClient returns a trace client that traces every request it can and
+// discards the traces.
+func NewClient() *trace.Client {
+	tc := trace.NewClientWithExporter(DiscardExporter{})
+	// The benchmarks finish traces faster than the client uploads them.
+	tc.SetErrorHandler(func(error) {})
+	return tc
+}
+
+type neverSample struct{}
+
+func (neverSample) Sample(trace.Parameters) trace.Decision {
+	return trace.Decision{}
+}
+
+// rootContexts returns a function that returns a context containing a root
+// span of tc, replacing the span with a new one every rootSpanOps calls.
+func rootContexts(tc *trace.Client) func() context.Context {
+	var (
+		n    int
+		span *trace.Span
+		ctx  context.Context
+	)
+	return func() context.Context {
+		if n%rootSpanOps == 0 {
+			span.Finish()
+			span = tc.NewSpan("/root")
+			ctx = trace.NewContext(context.Background(), span)
+		}
+		n++
+		return ctx
+	}
+}
+
+const testHeader = "0123456789abcdef0123456789abcdef/1;o=1"
+
+func unaryClient(sampled bool) func() func() {
+	return func() func() {
+		tc := NewClient()
+		if !sampled {
+			tc.SetSamplingPolicy(neverSample{})
+		}
+		interceptor := trace.GRPCClientInterceptor()
+		invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+			return nil
+		}
+		root := rootContexts(tc)
+		return func() {
+			interceptor(root(), "/test.Service/Method", nil, nil, nil, invoker)
+		}
+	}
+}
+
+func server(withHeader bool, opts ...trace.InterceptorOption) func() func() {
+	return func() func() {
+		interceptor := trace.GRPCServerInterceptor(NewClient(), opts...)
+		ctx := context.Background()
+		if withHeader {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-cloud-trace-context", testHeader))
+		}
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		handler := func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		}
+		return func() {
+			interceptor(ctx, nil, info, handler)
+		}
+	}
+}
+
+func childWithLabels() func() {
+	root := rootContexts(NewClient())
+	return func() {
+		s := trace.FromContext(root()).NewChild("child")
+		s.SetLabel("label1", "value1")
+		s.SetLabel("label2", "value2")
+		s.SetLabel("label3", "value3")
+		s.SetLabel("label4", "value4")
+		s.SetLabel("label5", "value5")
+		s.Finish()
+	}
+}
+
+func headerParse() func() {
+	tc := NewClient()
+	return func() {
+		tc.SpanFromHeader("/span", testHeader)
+	}
+}
+
+func headerFormat() func() {
+	span := NewClient().SpanFromHeader("/span", testHeader)
+	return func() {
+		span.Header()
+	}
+}
+
+func fromContext() func() {
+	ctx := trace.NewContext(context.Background(), NewClient().NewSpan("/root"))
+	return func() {
+		trace.FromContext(ctx)
+	}
+}
+
+func newContext() func() {
+	span := NewClient().NewSpan("/root")
+	return func() {
+		trace.NewContext(context.Background(), span)
+	}
+}
+
+// BenchmarkSuite is the list of benchmarks of the package's hot paths.
+var BenchmarkSuite = []Benchmark{
+	{"UnaryClientInterceptor/sampled", BudgetUnaryClientSampled, unaryClient(true)},
+	{"UnaryClientInterceptor/unsampled", BudgetUnaryClientUnsampled, unaryClient(false)},
+	{"ServerInterceptor/header", BudgetServerWithHeader, server(true)},
+	{"ServerInterceptor/noheader", BudgetServerWithoutHeader, server(false)},
+	{"ServerInterceptor/header/methodstats", BudgetServerWithHeader, server(true, trace.WithMethodStats())},
+	{"ServerInterceptor/noheader/methodstats", BudgetServerWithoutHeader, server(false, trace.WithMethodStats())},
+	{"NewChildFinish/5labels", BudgetChildWithLabels, childWithLabels},
+	{"Header/parse", BudgetHeaderParse, headerParse},
+	{"Header/format", BudgetHeaderFormat, headerFormat},
+	{"FromContext", BudgetFromContext, fromContext},
+	{"NewContext", BudgetNewContext, newContext},
+}
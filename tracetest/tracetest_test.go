@@ -0,0 +1,156 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracetest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/trace"
+)
+
+// raceEnabled is set when the race detector is on.  It makes sync.Pool drop
+// items at random, so allocation counts are unreliable.
+var raceEnabled = false
+
+func TestAllocationBudgets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping allocation budgets in short mode")
+	}
+	if raceEnabled {
+		t.Skip("skipping allocation budgets with the race detector")
+	}
+	for _, bm := range BenchmarkSuite {
+		if got := minAllocsPerRun(bm.setup()); got > bm.MaxAllocs {
+			t.Errorf("%s: %.1f allocations per op; budget is %.0f", bm.Name, got, bm.MaxAllocs)
+		} else {
+			t.Logf("%s: %.1f allocations per op; budget is %.0f", bm.Name, got, bm.MaxAllocs)
+		}
+	}
+}
+
+// minAllocsPerRun returns the smallest of a few measurements of the
+// allocations made by op.  Uploads by the clients of earlier benchmarks may
+// still be running in the background, and their allocations are counted too.
+func minAllocsPerRun(op func()) float64 {
+	min := testing.AllocsPerRun(1000, op)
+	for i := 0; i < 4 && min > 0; i++ {
+		if n := testing.AllocsPerRun(1000, op); n < min {
+			min = n
+		}
+	}
+	return min
+}
+
+func BenchmarkSuiteOverhead(b *testing.B) {
+	for _, bm := range BenchmarkSuite {
+		b.Run(bm.Name, bm.Run)
+	}
+}
+
+var sink []byte
+
+func TestMeasureOverhead(t *testing.T) {
+	o := MeasureOverhead(func() { sink = make([]byte, 64) })
+	if o.AllocsPerOp != 1 {
+		t.Errorf("AllocsPerOp = %v; want 1", o.AllocsPerOp)
+	}
+	if o.NsPerOp <= 0 {
+		t.Errorf("NsPerOp = %d; want > 0", o.NsPerOp)
+	}
+}
+
+// fakeT records the errors reported by CheckSampler.
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+// everyNth traces every n'th request.
+type everyNth struct {
+	n, i int
+}
+
+func (s *everyNth) Sample(trace.Parameters) trace.Decision {
+	s.i++
+	return trace.Decision{Trace: s.i%s.n == 0}
+}
+
+// headerOnly traces the requests with a trace header.
+type headerOnly struct{}
+
+func (headerOnly) Sample(p trace.Parameters) trace.Decision {
+	return trace.Decision{Trace: p.HasTraceHeader}
+}
+
+func TestCheckSampler(t *testing.T) {
+	for _, test := range []struct {
+		policy          trace.SamplingPolicy
+		params          func(int) trace.Parameters
+		want, tolerance float64
+		wantErr         bool
+	}{
+		{&everyNth{n: 4}, NoRemoteParent(), 0.25, 0, false},
+		{&everyNth{n: 4}, NoRemoteParent(), 0.2, 0.04, true},
+		{&everyNth{n: 4}, NoRemoteParent(), 0.2, 0.06, false},
+		{headerOnly{}, RemoteParent(), 1, 0, false},
+		{headerOnly{}, NoRemoteParent(), 0, 0, false},
+		{headerOnly{}, MixedRemoteParents(0.3), 0.3, 0, false},
+	} {
+		ft := &fakeT{}
+		CheckSampler(ft, test.policy, 1000, test.want, test.tolerance, test.params)
+		if gotErr := len(ft.errors) > 0; gotErr != test.wantErr {
+			t.Errorf("%T, want %v ± %v: got errors %q; want errors: %t", test.policy, test.want, test.tolerance, ft.errors, test.wantErr)
+		}
+	}
+}
+
+func TestParams(t *testing.T) {
+	params := MixedRemoteParents(0.5, "/a", "/b", "/c")
+	var got []trace.Parameters
+	for i := 0; i < 4; i++ {
+		got = append(got, params(i))
+	}
+	want := []trace.Parameters{
+		{HasTraceHeader: false, Name: "/a", TraceID: "00000000000000000000000000000001"},
+		{HasTraceHeader: true, Name: "/b", TraceID: "00000000000000000000000000000002"},
+		{HasTraceHeader: false, Name: "/c", TraceID: "00000000000000000000000000000003"},
+		{HasTraceHeader: true, Name: "/a", TraceID: "00000000000000000000000000000004"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	if got := NoRemoteParent()(7); got.HasTraceHeader || got.Name != "/" {
+		t.Errorf("NoRemoteParent()(7) = %+v", got)
+	}
+}
+
+func TestSteppingClock(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := SteppingClock(start, time.Second)
+	for i := 0; i < 3; i++ {
+		if got, want := clock(), start.Add(time.Duration(i)*time.Second); !got.Equal(want) {
+			t.Errorf("call %d: got %v; want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.7
+
+package trace
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const labelResponseSize = `trace.cloud.google.com/http/response/size`
+
+// Transport is an http.RoundTripper that traces outgoing requests, like the
+// clients returned by WrapHTTPClient:
+//
+//   client := &http.Client{Transport: trace.Transport{Base: http.DefaultTransport}}
+//
+// A request whose context contains a traced *Span gets a child span, named
+// after the method and path of its URL, for example "GET /v1/items", and
+// carries the trace header.  The span is labeled with the URL, host and
+// method of the request, the status code of the response and, as
+// "trace.cloud.google.com/http/response/size", the number of bytes read
+// from the response body.  It finishes when the body has been read to the
+// end or is closed, rather than when RoundTrip returns, so that its duration
+// includes the transfer of streaming responses; if RoundTrip fails, it
+// finishes immediately.
+//
+// The request passed to RoundTrip is not modified: the header is set on a
+// copy.
+type Transport struct {
+	// Base is the RoundTripper that sends the requests.  If it is nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// ResponseTraceHeader is the name of a response header in which
+	// servers that start their own traces return their trace context, in
+	// the format of the trace header.  If it is set, the span of a request
+	// whose response has the header gets a Link to the span in it, and is
+	// labeled with its trace ID, as "peer/trace_id".  Malformed values are
+	// ignored.
+	ResponseTraceHeader string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	parent := FromContext(req.Context())
+	if parent == nil {
+		return base.RoundTrip(req)
+	}
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	span := parent.NewRemoteChild(r)
+	if span != parent {
+		span.span.Name = r.Method + " " + r.URL.Path
+	}
+	setHTTPHeader(r.Context(), r, span)
+
+	resp, err := base.RoundTrip(r)
+	linkResponseHeader(span, resp, t.ResponseTraceHeader)
+	if err != nil || resp == nil || resp.Body == nil {
+		span.Finish(WithResponse(resp))
+		return resp, err
+	}
+	resp.Body = &tracedBody{ReadCloser: resp.Body, span: span, resp: resp}
+	return resp, nil
+}
+
+// A tracedBody is the body of a response to a request traced by Transport.
+// It finishes the span of the request when it has been read to the end or
+// closed.
+type tracedBody struct {
+	io.ReadCloser
+	span *Span
+	resp *http.Response
+	n    int64 // bytes read, updated atomically.
+	once sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.n, int64(n))
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+func (b *tracedBody) finish() {
+	b.once.Do(func() {
+		if b.span.tracing() {
+			b.span.setLabel(labelResponseSize, strconv.FormatInt(atomic.LoadInt64(&b.n), 10))
+		}
+		b.span.Finish(WithResponse(b.resp))
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.7
+
+package trace
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingTransport is an http.RoundTripper whose requests fail.
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestTransport(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	headers := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Get(httpHeader)
+		io.WriteString(w, "hello, ")
+		w.(http.Flusher).Flush()
+		time.Sleep(delay)
+		io.WriteString(w, "world")
+	}))
+	defer ts.Close()
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	req, _ := http.NewRequest("GET", ts.URL+"/stream?q=1", nil)
+	req = req.WithContext(NewContext(req.Context(), root))
+	client := &http.Client{Transport: Transport{}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := <-headers; !strings.HasPrefix(h, root.TraceID()+"/") {
+		t.Errorf("trace header = %q; want one for trace %s", h, root.TraceID())
+	}
+	if h := req.Header.Get(httpHeader); h != "" {
+		t.Errorf("request passed to the transport has trace header %q; want it unmodified", h)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := e.span("GET /stream")
+	if s == nil {
+		t.Fatal("no span named \"GET /stream\" exported")
+	}
+	if d := s.End.Sub(s.Start); d < delay {
+		t.Errorf("span lasted %v; want at least %v, the time to read the body", d, delay)
+	}
+	host := strings.TrimPrefix(ts.URL, "http://")
+	for key, want := range map[string]string{
+		labelStatusCode:   "200",
+		labelHost:         host,
+		labelResponseSize: "12",
+	} {
+		if got := s.Labels[key]; got != want {
+			t.Errorf("label %s = %q; want %q", key, got, want)
+		}
+	}
+	if string(body) != "hello, world" {
+		t.Errorf("body = %q; want %q", body, "hello, world")
+	}
+}
+
+func TestTransportClose(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "not found")
+	}))
+	defer ts.Close()
+
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	req, _ := http.NewRequest("GET", ts.URL+"/missing", nil)
+	resp, err := Transport{Base: http.DefaultTransport}.RoundTrip(req.WithContext(NewContext(req.Context(), root)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close() // without reading the body.
+	resp.Body.Close() // the span is finished once.
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	s := e.span("GET /missing")
+	if s == nil {
+		t.Fatal("no span named \"GET /missing\" exported")
+	}
+	if got, want := s.Labels[labelStatusCode], "404"; got != want {
+		t.Errorf("status code label = %q; want %q", got, want)
+	}
+	if got, want := s.Labels[labelResponseSize], "0"; got != want {
+		t.Errorf("response size label = %q; want %q", got, want)
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	e := &recordingExporter{}
+	tc := NewClientWithExporter(e)
+	root := tc.NewSpan("/root")
+	req, _ := http.NewRequest("GET", "http://example.com/fail", nil)
+	_, err := Transport{Base: failingTransport{}}.RoundTrip(req.WithContext(NewContext(req.Context(), root)))
+	if err == nil {
+		t.Fatal("RoundTrip succeeded; want the error of the base transport")
+	}
+	if err := root.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if e.span("GET /fail") == nil {
+		t.Error("span of a failed request was not finished")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+)
+
+// Default backoffs of an UploadRetryPolicy.
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+// An UploadRetryPolicy configures the retries of the exports of a client
+// that fail with transient errors.  The zero UploadRetryPolicy doesn't
+// retry.
+type UploadRetryPolicy struct {
+	// MaxAttempts is the maximum number of calls of an exporter for each
+	// batch of spans.  A value of 1 or less disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry, 100ms if zero.
+	// Each delay doubles the previous one, up to MaxBackoff, 5s if zero;
+	// the actual delays are chosen at random between half of those and
+	// those, so that clients throttled together don't retry together.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Deadline limits the time spent exporting a batch, over all attempts
+	// and delays, if positive.  The context passed to the exporter expires
+	// at the deadline.
+	Deadline time.Duration
+}
+
+// SetUploadRetryPolicy makes the client retry the exports that fail with a
+// transient error: an HTTP status of 429 Too Many Requests or 5xx from the
+// Stackdriver Trace API, a gRPC status of Unavailable, ResourceExhausted or
+// DeadlineExceeded, ErrExportTimeout, or an error with a Temporary method
+// that returns true.  Other errors aren't retried.
+//
+// Retries happen on the goroutine that uploads the bundles of the client,
+// so Finish doesn't wait for them, but FinishWait and Flush do.  While a
+// bundle is retried its spans still count towards the bundler's
+// BufferedByteLimit, so during a long outage the spans finished after the
+// buffer is full are dropped, and counted in the SpansDropped field of
+// Stats, instead of accumulating in memory.  Each retry is counted in the
+// UploadRetries field of Stats.  The error of the last attempt is
+// reported to the error handler, and counted like any failed upload.
+//
+// With a circuit breaker set by SetCircuitBreaker, the retries of a batch
+// count as one export for the breaker.
+//
+// SetUploadRetryPolicy should be called before any spans are created.
+func (c *Client) SetUploadRetryPolicy(p UploadRetryPolicy) {
+	if c != nil {
+		c.retryPolicy = p
+	}
+}
+
+// exportWithRetries calls e, retrying transient failures according to the
+// client's retry policy.
+func (c *Client) exportWithRetries(ctx context.Context, e Exporter, spans []*SpanData) error {
+	p := c.retryPolicy
+	if p.MaxAttempts <= 1 {
+		return c.exportWithTimeout(ctx, e, spans)
+	}
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+	backoff, maxBackoff := p.InitialBackoff, p.MaxBackoff
+	if backoff <= 0 {
+		backoff = defaultInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.exportWithTimeout(ctx, e, spans)
+		if err == nil || attempt >= p.MaxAttempts || !retryableUploadError(err) {
+			return err
+		}
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		t := time.NewTimer(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		atomic.AddInt64(&c.stats.uploadRetries, 1)
+		backoff *= 2
+	}
+}
+
+// retryableUploadError reports whether err, returned by an exporter, is
+// transient.
+func retryableUploadError(err error) bool {
+	if err == ErrExportTimeout {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) {
+		return temporary.Temporary()
+	}
+	switch statusOf(err).Code() {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
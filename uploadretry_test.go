@@ -0,0 +1,134 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyExporter is a recordingExporter whose first failures calls fail with
+// err.
+type flakyExporter struct {
+	recordingExporter
+	err error
+
+	mu       sync.Mutex
+	failures int
+	calls    int
+}
+
+func (e *flakyExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	e.calls++
+	fail := e.failures != 0
+	if e.failures > 0 {
+		e.failures--
+	}
+	e.mu.Unlock()
+	if fail {
+		return e.err
+	}
+	return e.recordingExporter.ExportSpans(ctx, spans)
+}
+
+func TestUploadRetries(t *testing.T) {
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	for _, tt := range []struct {
+		desc      string
+		err       error
+		failures  int // -1 for every call.
+		wantCalls int
+		wantErr   bool
+	}{
+		{desc: "transient", err: unavailable, failures: 2, wantCalls: 3},
+		{desc: "quota", err: &googleapi.Error{Code: http.StatusTooManyRequests}, failures: 1, wantCalls: 2},
+		{desc: "gRPC", err: status.Error(codes.Unavailable, "down"), failures: 1, wantCalls: 2},
+		{desc: "permanent", err: &googleapi.Error{Code: http.StatusBadRequest}, failures: 1, wantCalls: 1, wantErr: true},
+		{desc: "exhausted", err: unavailable, failures: -1, wantCalls: 4, wantErr: true},
+	} {
+		e := &flakyExporter{err: tt.err, failures: tt.failures}
+		tc := NewClientWithExporter(e)
+		var errs []error
+		tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+		tc.SetUploadRetryPolicy(UploadRetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond})
+		tc.NewSpan("/root").Finish()
+		tc.Flush(context.Background())
+
+		if e.calls != tt.wantCalls {
+			t.Errorf("%s: exporter called %d times; want %d", tt.desc, e.calls, tt.wantCalls)
+		}
+		st := tc.Stats()
+		if st.UploadRetries != int64(tt.wantCalls-1) {
+			t.Errorf("%s: UploadRetries = %d; want %d", tt.desc, st.UploadRetries, tt.wantCalls-1)
+		}
+		if tt.wantErr {
+			if len(errs) != 1 || st.UploadsFailed != 1 {
+				t.Errorf("%s: got errors %v and %d failed uploads; want 1 of each", tt.desc, errs, st.UploadsFailed)
+			}
+		} else if len(errs) != 0 || len(e.spans) != 1 {
+			t.Errorf("%s: got errors %v and %d exported spans; want none and 1", tt.desc, errs, len(e.spans))
+		}
+	}
+}
+
+func TestUploadRetryDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	tc := NewClientWithExporter(hangingExporter{release})
+	tc.SetExportTimeout(5 * time.Millisecond) // timeouts are retried.
+	var errs []error
+	tc.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	tc.SetUploadRetryPolicy(UploadRetryPolicy{MaxAttempts: 100, InitialBackoff: time.Millisecond, Deadline: 20 * time.Millisecond})
+	start := time.Now()
+	tc.NewSpan("/root").Finish()
+	tc.Flush(context.Background())
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("retries took %v; want them to stop at the 20ms deadline", d)
+	}
+	if len(errs) != 1 {
+		t.Errorf("got errors %v; want 1", errs)
+	}
+}
+
+func TestUploadRetryBuffer(t *testing.T) {
+	// During an outage, the bundles being retried fill the buffer, and the
+	// spans finished then are dropped.
+	e := &flakyExporter{err: &googleapi.Error{Code: http.StatusServiceUnavailable}, failures: -1}
+	tc := NewClientWithExporter(e)
+	tc.SetErrorHandler(func(error) {})
+	tc.SetUploadRetryPolicy(UploadRetryPolicy{MaxAttempts: 1000, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Deadline: 50 * time.Millisecond})
+	tc.bundler.BundleCountThreshold = 1
+	tc.bundler.BufferedByteLimit = 10 // five traces of one span.
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		tc.NewSpan("/root").Finish()
+	}
+	finishing := time.Since(start)
+	tc.Flush(context.Background())
+	if finishing > 20*time.Millisecond {
+		t.Errorf("Finish calls took %v during the outage; want them not to wait for retries", finishing)
+	}
+	if st := tc.Stats(); st.SpansDropped == 0 {
+		t.Errorf("no spans were dropped during the outage; stats %+v", st)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits of the Google Stackdriver Trace API that are checked before spans
+// are exported.
+const (
+	maxSpanNameBytes   = 128
+	maxLabels          = 32
+	maxLabelKeyBytes   = 128
+	maxLabelValueBytes = 16 * 1024
+)
+
+// labelTruncationSuffix ends the label values that validation truncates.
+const labelTruncationSuffix = "...(truncated)"
+
+// SetLabelLimits lowers the limits on the labels of the spans the client
+// exports: spans keep at most labels labels, the first by key, and label
+// values longer than valueBytes bytes are truncated, ending with
+// "...(truncated)".  A limit that isn't positive, or is above the limit of
+// the trace API (32 labels, and 16 KiB per value), is the API's limit, which
+// is always enforced so that the API doesn't reject the whole batch the span
+// is uploaded in.  Violations are reported to the error handler as
+// ValidationErrors.
+//
+// SetLabelLimits should be called before any spans are created.
+func (c *Client) SetLabelLimits(labels, valueBytes int) {
+	if c == nil {
+		return
+	}
+	if labels <= 0 || labels > maxLabels {
+		labels = maxLabels
+	}
+	if valueBytes <= 0 || valueBytes > maxLabelValueBytes {
+		valueBytes = maxLabelValueBytes
+	}
+	c.labelLimit, c.labelValueLimit = labels, valueBytes
+}
+
+// labelLimits returns the maximum number of labels of a span, and the
+// maximum length of their values.
+func (c *Client) labelLimits() (labels, valueBytes int) {
+	if c.labelLimit == 0 {
+		return maxLabels, maxLabelValueBytes
+	}
+	return c.labelLimit, c.labelValueLimit
+}
+
+// A ValidationError describes a span that violated a limit of the trace API.
+// ValidationErrors are passed to the client's error handler; see
+// Client.SetErrorHandler.
+type ValidationError struct {
+	TraceID  string
+	SpanName string // the name of the span before any fix-up.
+	Problem  string
+
+	// Fixed reports whether the span was repaired and exported.  If false,
+	// the span was dropped.
+	Fixed bool
+}
+
+func (e *ValidationError) Error() string {
+	action := "dropped"
+	if e.Fixed {
+		action = "fixed"
+	}
+	return fmt.Sprintf("trace: span %q in trace %s: %s (%s)", e.SpanName, e.TraceID, e.Problem, action)
+}
+
+// validate checks spans against the limits of the trace API before they are
+// exported.  Spans are repaired in place where that is safe, and dropped
+// otherwise; each violation is reported to the client's error handler.  The
+// returned slice contains the spans that should be exported.
+//
+// Cheap checks are always performed.  If strict validation is enabled,
+// label and annotation contents are also checked for invalid UTF-8,
+// timestamps are checked for zero values, and span IDs are checked for
+// duplicates.
+func (c *Client) validate(spans []*SpanData) []*SpanData {
+	type spanKey struct {
+		traceID string
+		spanID  uint64
+	}
+	var seen map[spanKey]bool
+	if c.strict {
+		seen = make(map[spanKey]bool, len(spans))
+	}
+	valid := spans[:0]
+	for _, s := range spans {
+		name := s.Name
+		report := func(fixed bool, format string, args ...interface{}) {
+			c.reportError(&ValidationError{
+				TraceID:  s.TraceID,
+				SpanName: name,
+				Problem:  fmt.Sprintf(format, args...),
+				Fixed:    fixed,
+			})
+		}
+		if !validTraceID(s.TraceID) {
+			report(false, "malformed trace ID %q", s.TraceID)
+			continue
+		}
+		if s.SpanID == 0 {
+			report(false, "span ID is zero")
+			continue
+		}
+		if c.strict {
+			k := spanKey{s.TraceID, s.SpanID}
+			if seen[k] {
+				report(false, "duplicate span ID %d", s.SpanID)
+				continue
+			}
+			seen[k] = true
+			if s.Start.IsZero() || s.End.IsZero() {
+				report(false, "start or end time is unset")
+				continue
+			}
+			if !utf8.ValidString(s.Name) {
+				s.Name = toValidUTF8(s.Name)
+				report(true, "span name is not valid UTF-8")
+			}
+		}
+		if len(s.Name) > maxSpanNameBytes {
+			report(true, "span name is %d bytes; truncated to %d", len(s.Name), maxSpanNameBytes)
+			short := truncate(s.Name, maxSpanNameBytes)
+			s.NameTruncatedBytes += len(s.Name) - len(short)
+			s.Name = short
+		}
+		if s.End.Before(s.Start) {
+			report(true, "end time %v is before start time %v", s.End, s.Start)
+			s.End = s.Start
+		}
+		c.validateLabels(s, report)
+		c.validateAnnotations(s, report)
+		valid = append(valid, s)
+	}
+	return valid
+}
+
+func (c *Client) validateLabels(s *SpanData, report func(bool, string, ...interface{})) {
+	if len(s.Labels) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labelLimit, valueLimit := c.labelLimits()
+	if len(keys) > labelLimit {
+		for _, k := range keys[labelLimit:] {
+			delete(s.Labels, k)
+		}
+		report(true, "span has %d labels; dropped all but the first %d by key", len(keys), labelLimit)
+		keys = keys[:labelLimit]
+	}
+	for _, k := range keys {
+		v := s.Labels[k]
+		// Most keys are valid, and are the same from span to span, so the
+		// checks of the key are skipped when it is known to be valid.
+		keyOK := validLabelKey(k)
+		if c.strict {
+			if !keyOK && k == "" {
+				delete(s.Labels, k)
+				report(true, "dropped label with empty key")
+				continue
+			}
+			if (!keyOK && !utf8.ValidString(k)) || !utf8.ValidString(v) {
+				delete(s.Labels, k)
+				k, v = toValidUTF8(k), toValidUTF8(v)
+				s.Labels[k] = v
+				report(true, "label %q is not valid UTF-8", k)
+			}
+		}
+		if len(v) > valueLimit {
+			report(true, "value of label %q is %d bytes; truncated to %d", k, len(v), valueLimit)
+			short, removed := truncateValue(v, valueLimit)
+			if s.LabelTruncatedBytes == nil {
+				s.LabelTruncatedBytes = make(map[string]int)
+			}
+			s.LabelTruncatedBytes[k] += removed
+			v = short
+			s.Labels[k] = v
+		}
+		if keyOK {
+			continue
+		}
+		short := k
+		control := strings.IndexFunc(k, unicode.IsControl) >= 0
+		if control {
+			short = strings.Map(replaceControl, k)
+		}
+		long := len(short) > maxLabelKeyBytes
+		short = truncate(short, maxLabelKeyBytes)
+		if short == k {
+			continue
+		}
+		delete(s.Labels, k)
+		n, valueTruncated := s.LabelTruncatedBytes[k]
+		delete(s.LabelTruncatedBytes, k)
+		if _, ok := s.Labels[short]; ok {
+			if long {
+				report(false, "label key %q is %d bytes, and its truncation is already in use; label dropped", short, len(k))
+			} else {
+				report(false, "label key %q has control characters, and its fix %q is already in use; label dropped", k, short)
+			}
+			continue
+		}
+		if control {
+			report(true, "label key %q has control characters; replaced them with underscores", k)
+		}
+		if long {
+			report(true, "label key %q is %d bytes; truncated to %d", short, len(k), maxLabelKeyBytes)
+		}
+		s.Labels[short] = v
+		if valueTruncated {
+			s.LabelTruncatedBytes[short] = n
+		}
+	}
+}
+
+// validateAnnotations checks the annotations of s, which some exporters
+// upload as labels: messages and attribute values longer than the label
+// value limit are truncated, and under strict validation, invalid UTF-8 is
+// replaced.
+func (c *Client) validateAnnotations(s *SpanData, report func(bool, string, ...interface{})) {
+	_, valueLimit := c.labelLimits()
+	for i := range s.Annotations {
+		a := &s.Annotations[i]
+		if c.strict && !utf8.ValidString(a.Message) {
+			a.Message = toValidUTF8(a.Message)
+			report(true, "annotation %d message is not valid UTF-8", i)
+		}
+		if len(a.Message) > valueLimit {
+			report(true, "annotation %d message is %d bytes; truncated to %d", i, len(a.Message), valueLimit)
+			a.Message, _ = truncateValue(a.Message, valueLimit)
+		}
+		for k, v := range a.Attributes {
+			if c.strict && (!utf8.ValidString(k) || !utf8.ValidString(v)) {
+				delete(a.Attributes, k)
+				k, v = toValidUTF8(k), toValidUTF8(v)
+				a.Attributes[k] = v
+				report(true, "annotation %d attribute %q is not valid UTF-8", i, k)
+			}
+			if len(v) > valueLimit {
+				report(true, "value of annotation %d attribute %q is %d bytes; truncated to %d", i, k, len(v), valueLimit)
+				a.Attributes[k], _ = truncateValue(v, valueLimit)
+			}
+		}
+	}
+}
+
+// validTraceID reports whether id is 32 hex digits, not all zero.
+func validTraceID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	nonZero := false
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case c == '0':
+		case '1' <= c && c <= '9', 'a' <= c && c <= 'f', 'A' <= c && c <= 'F':
+			nonZero = true
+		default:
+			return false
+		}
+	}
+	return nonZero
+}
+
+// truncate returns the longest prefix of s that is at most n bytes long and
+// does not split a UTF-8 encoded rune.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// truncateValue returns a label value of at most n bytes for v, a longer
+// value: a prefix of v followed by labelTruncationSuffix, if n leaves room
+// for it.  It also returns the number of bytes of v that were removed.
+func truncateValue(v string, n int) (string, int) {
+	if n <= len(labelTruncationSuffix) {
+		short := truncate(v, n)
+		return short, len(v) - len(short)
+	}
+	short := truncate(v, n-len(labelTruncationSuffix))
+	return short + labelTruncationSuffix, len(v) - len(short)
+}
+
+// replaceControl maps the control characters, which the trace API rejects
+// in label keys, to underscores.
+func replaceControl(r rune) rune {
+	if unicode.IsControl(r) {
+		return '_'
+	}
+	return r
+}
+
+// toValidUTF8 returns s with each run of invalid UTF-8 bytes replaced by the
+// Unicode replacement character.
+func toValidUTF8(s string) string {
+	b := make([]byte, 0, len(s))
+	invalid := false
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			if !invalid {
+				b = append(b, string(utf8.RuneError)...)
+			}
+			invalid = true
+		} else {
+			b = append(b, s[i:i+size]...)
+			invalid = false
+		}
+		i += size
+	}
+	return string(b)
+}
@@ -0,0 +1,420 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+const validationTraceID = "0123456789abcdef0123456789abcdef"
+
+func validSpanData() *SpanData {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &SpanData{
+		TraceID: validationTraceID,
+		SpanID:  1,
+		Name:    "span",
+		Start:   start,
+		End:     start.Add(time.Second),
+		Labels:  map[string]string{"key": "value"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	manyLabels := map[string]string{}
+	for i := 0; i < maxLabels+3; i++ {
+		manyLabels[fmt.Sprintf("key%02d", i)] = "v"
+	}
+	longKey := strings.Repeat("k", maxLabelKeyBytes+10)
+	for _, tt := range []struct {
+		desc   string
+		strict bool
+		modify func(s *SpanData)
+		// check is called with the exported span, or nil if it was dropped.
+		check     func(t *testing.T, s *SpanData)
+		wantErrs  int // number of errors reported, if not 1.
+		wantFixed bool
+	}{
+		{
+			desc:   "name too long",
+			modify: func(s *SpanData) { s.Name = strings.Repeat("x", maxSpanNameBytes-1) + "é" },
+			check: func(t *testing.T, s *SpanData) {
+				if got, want := s.Name, strings.Repeat("x", maxSpanNameBytes-1); got != want {
+					t.Errorf("name = %q; want %q", got, want)
+				}
+				// The two-byte "é" doesn't fit, and is removed whole.
+				if got, want := s.NameTruncatedBytes, 2; got != want {
+					t.Errorf("NameTruncatedBytes = %d; want %d", got, want)
+				}
+			},
+			wantFixed: true,
+		},
+		{
+			desc:   "too many labels",
+			modify: func(s *SpanData) { s.Labels = manyLabels },
+			check: func(t *testing.T, s *SpanData) {
+				if got := len(s.Labels); got != maxLabels {
+					t.Errorf("got %d labels; want %d", got, maxLabels)
+				}
+				if _, ok := s.Labels[fmt.Sprintf("key%02d", maxLabels)]; ok {
+					t.Errorf("label beyond the limit was kept")
+				}
+			},
+			wantFixed: true,
+		},
+		{
+			desc:   "label key too long",
+			modify: func(s *SpanData) { s.Labels = map[string]string{longKey: "v"} },
+			check: func(t *testing.T, s *SpanData) {
+				want := map[string]string{longKey[:maxLabelKeyBytes]: "v"}
+				if !reflect.DeepEqual(s.Labels, want) {
+					t.Errorf("labels = %v; want %v", s.Labels, want)
+				}
+			},
+			wantFixed: true,
+		},
+		{
+			desc:   "label value too long",
+			modify: func(s *SpanData) { s.Labels["key"] = strings.Repeat("v", maxLabelValueBytes+1) },
+			check: func(t *testing.T, s *SpanData) {
+				if got := len(s.Labels["key"]); got != maxLabelValueBytes {
+					t.Errorf("value is %d bytes; want %d", got, maxLabelValueBytes)
+				}
+				if !strings.HasSuffix(s.Labels["key"], labelTruncationSuffix) {
+					t.Errorf("truncated value doesn't end with %q", labelTruncationSuffix)
+				}
+				if got, want := s.LabelTruncatedBytes, map[string]int{"key": 1 + len(labelTruncationSuffix)}; !reflect.DeepEqual(got, want) {
+					t.Errorf("LabelTruncatedBytes = %v; want %v", got, want)
+				}
+			},
+			wantFixed: true,
+		},
+		{
+			desc: "label key and value too long",
+			modify: func(s *SpanData) {
+				s.Labels = map[string]string{longKey: strings.Repeat("v", maxLabelValueBytes+3)}
+			},
+			check: func(t *testing.T, s *SpanData) {
+				want := map[string]int{longKey[:maxLabelKeyBytes]: 3 + len(labelTruncationSuffix)}
+				if !reflect.DeepEqual(s.LabelTruncatedBytes, want) {
+					t.Errorf("LabelTruncatedBytes = %v; want %v", s.LabelTruncatedBytes, want)
+				}
+			},
+			wantErrs:  2,
+			wantFixed: true,
+		},
+		{
+			desc:   "label key with control characters",
+			modify: func(s *SpanData) { s.Labels = map[string]string{"a\nb\x00": "v"} },
+			check: func(t *testing.T, s *SpanData) {
+				if want := map[string]string{"a_b_": "v"}; !reflect.DeepEqual(s.Labels, want) {
+					t.Errorf("labels = %v; want %v", s.Labels, want)
+				}
+			},
+			wantFixed: true,
+		},
+		{
+			desc: "fixed label key already in use",
+			modify: func(s *SpanData) {
+				s.Labels = map[string]string{"a\tb": "v", "a_b": "w"}
+			},
+			check: func(t *testing.T, s *SpanData) {
+				if want := map[string]string{"a_b": "w"}; !reflect.DeepEqual(s.Labels, want) {
+					t.Errorf("labels = %v; want %v", s.Labels, want)
+				}
+			},
+		},
+		{
+			desc:   "end before start",
+			modify: func(s *SpanData) { s.End = s.Start.Add(-time.Second) },
+			check: func(t *testing.T, s *SpanData) {
+				if !s.End.Equal(s.Start) {
+					t.Errorf("end = %v; want start %v", s.End, s.Start)
+				}
+			},
+			wantFixed: true,
+		},
+		{
+			desc:   "zero span ID",
+			modify: func(s *SpanData) { s.SpanID = 0 },
+			check:  wantDropped,
+		},
+		{
+			desc:   "short trace ID",
+			modify: func(s *SpanData) { s.TraceID = "0123" },
+			check:  wantDropped,
+		},
+		{
+			desc:   "non-hex trace ID",
+			modify: func(s *SpanData) { s.TraceID = strings.Repeat("g", 32) },
+			check:  wantDropped,
+		},
+		{
+			desc:   "zero trace ID",
+			modify: func(s *SpanData) { s.TraceID = strings.Repeat("0", 32) },
+			check:  wantDropped,
+		},
+		{
+			desc:   "invalid UTF-8 in name",
+			strict: true,
+			modify: func(s *SpanData) { s.Name = "a\xff\xfeb" },
+			check: func(t *testing.T, s *SpanData) {
+				if got, want := s.Name, "a�b"; got != want {
+					t.Errorf("name = %q; want %q", got, want)
+				}
+			},
+			wantFixed: true,
+		},
+		{
+			desc:   "invalid UTF-8 in label",
+			strict: true,
+			modify: func(s *SpanData) { s.Labels = map[string]string{"k\xff": "v\xff"} },
+			check: func(t *testing.T, s *SpanData) {
+				want := map[string]string{"k�": "v�"}
+				if !reflect.DeepEqual(s.Labels, want) {
+					t.Errorf("labels = %q; want %q", s.Labels, want)
+				}
+			},
+			wantFixed: true,
+		},
+		{
+			desc:   "empty label key",
+			strict: true,
+			modify: func(s *SpanData) { s.Labels = map[string]string{"": "v"} },
+			check: func(t *testing.T, s *SpanData) {
+				if len(s.Labels) != 0 {
+					t.Errorf("labels = %v; want none", s.Labels)
+				}
+			},
+			wantFixed: true,
+		},
+		{
+			desc:   "unset end time",
+			strict: true,
+			modify: func(s *SpanData) { s.End = time.Time{} },
+			check:  wantDropped,
+		},
+	} {
+		tt := tt
+		t.Run(tt.desc, func(t *testing.T) {
+			var errs []error
+			c := NewClientWithExporter(&recordingExporter{})
+			c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+			c.SetStrictValidation(tt.strict)
+
+			s := validSpanData()
+			s.Name = "span-" + tt.desc
+			tt.modify(s)
+			name := s.Name
+			got := c.validate([]*SpanData{s})
+
+			if len(got) > 1 {
+				t.Fatalf("validate returned %d spans", len(got))
+			}
+			var exported *SpanData
+			if len(got) == 1 {
+				exported = got[0]
+			}
+			tt.check(t, exported)
+			wantErrs := tt.wantErrs
+			if wantErrs == 0 {
+				wantErrs = 1
+			}
+			if len(errs) != wantErrs {
+				t.Fatalf("got %d errors %v; want %d", len(errs), errs, wantErrs)
+			}
+			verr, ok := errs[0].(*ValidationError)
+			if !ok {
+				t.Fatalf("got error of type %T; want *ValidationError", errs[0])
+			}
+			if verr.SpanName != name {
+				t.Errorf("error names span %q; want %q", verr.SpanName, name)
+			}
+			if verr.Fixed != tt.wantFixed {
+				t.Errorf("Fixed = %t; want %t", verr.Fixed, tt.wantFixed)
+			}
+		})
+	}
+}
+
+func wantDropped(t *testing.T, s *SpanData) {
+	if s != nil {
+		t.Errorf("span %q was exported; want it dropped", s.Name)
+	}
+}
+
+func TestValidateStrictOnly(t *testing.T) {
+	// Without strict validation, the exhaustive checks are skipped.
+	var errs []error
+	c := NewClientWithExporter(&recordingExporter{})
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	s := validSpanData()
+	s.Name = "a\xff"
+	s.Labels = map[string]string{"": "v"}
+	if got := c.validate([]*SpanData{s}); len(got) != 1 || got[0].Name != "a\xff" {
+		t.Errorf("span was modified without strict validation")
+	}
+	if len(errs) != 0 {
+		t.Errorf("got errors %v; want none", errs)
+	}
+}
+
+func TestValidateDuplicateSpanID(t *testing.T) {
+	var errs []error
+	c := NewClientWithExporter(&recordingExporter{})
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	c.SetStrictValidation(true)
+	a, b := validSpanData(), validSpanData()
+	a.Name, b.Name = "a", "b"
+	got := c.validate([]*SpanData{a, b})
+	if len(got) != 1 || got[0] != a {
+		t.Errorf("got %d spans; want only the first span", len(got))
+	}
+	if len(errs) != 1 || errs[0].(*ValidationError).SpanName != "b" {
+		t.Errorf("got errors %v; want one error for span b", errs)
+	}
+}
+
+func TestValidateBeforeExport(t *testing.T) {
+	e := &recordingExporter{}
+	c := NewClientWithExporter(e)
+	var errs []error
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+	span := c.NewSpan(strings.Repeat("x", maxSpanNameBytes+1))
+	span.SetLabel("key", strings.Repeat("v", maxLabelValueBytes+1))
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.spans) != 1 {
+		t.Fatalf("got %d exported spans; want 1", len(e.spans))
+	}
+	if got := len(e.spans[0].Name); got != maxSpanNameBytes {
+		t.Errorf("exported name is %d bytes; want %d", got, maxSpanNameBytes)
+	}
+	if got := len(e.spans[0].Labels["key"]); got != maxLabelValueBytes {
+		t.Errorf("exported label value is %d bytes; want %d", got, maxLabelValueBytes)
+	}
+	if len(errs) != 2 {
+		t.Errorf("got errors %v; want 2", errs)
+	}
+}
+
+func TestValidateAnnotations(t *testing.T) {
+	e := &recordingExporter{}
+	c := NewClientWithExporter(e)
+	c.SetStrictValidation(true)
+	c.SetLabelLimits(0, 64)
+	var errs []error
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+
+	span := c.NewSpan("/span")
+	span.Annotate(strings.Repeat("m", 100), "key", strings.Repeat("v", 100), "bad", "\xff")
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	a := e.span("/span").Annotations[0]
+	if got := len(a.Message); got != 64 {
+		t.Errorf("exported annotation message is %d bytes; want 64", got)
+	}
+	if got := len(a.Attributes["key"]); got != 64 {
+		t.Errorf("exported annotation attribute is %d bytes; want 64", got)
+	}
+	if got := a.Attributes["bad"]; !utf8.ValidString(got) {
+		t.Errorf("exported annotation attribute %q is not valid UTF-8", got)
+	}
+	if len(errs) != 3 {
+		t.Errorf("got errors %v; want 3", errs)
+	}
+}
+
+// apiExporter is an Exporter that rejects whole batches, like the trace API,
+// if a span in them violates the API's label limits.
+type apiExporter struct {
+	recordingExporter
+}
+
+func (e *apiExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	for _, s := range spans {
+		if len(s.Labels) > maxLabels {
+			return fmt.Errorf("span %q has %d labels", s.Name, len(s.Labels))
+		}
+		for k, v := range s.Labels {
+			if len(v) > maxLabelValueBytes || !checkLabelKey(k) {
+				return fmt.Errorf("span %q has an invalid label %q", s.Name, k)
+			}
+		}
+	}
+	return e.recordingExporter.ExportSpans(ctx, spans)
+}
+
+func TestOversizedLabelDoesNotFailBatch(t *testing.T) {
+	e := &apiExporter{}
+	c := NewClientWithExporter(e)
+	c.SetErrorHandler(func(error) {})
+	for i := 0; i < 10; i++ {
+		c.NewSpan(fmt.Sprintf("/good%d", i)).Finish()
+	}
+	bad := c.NewSpan("/bad")
+	bad.SetLabel("huge", strings.Repeat("v", 1<<20))
+	for i := 0; i < 40; i++ {
+		bad.SetLabel(fmt.Sprintf("key%02d", i), "v")
+	}
+	bad.SetLabel("new\nline", "v")
+	bad.Finish()
+	c.Flush(context.Background())
+
+	if st := c.Stats(); st.UploadsFailed != 0 {
+		t.Errorf("%d uploads failed; want 0", st.UploadsFailed)
+	}
+	if got := len(e.spans); got != 11 {
+		t.Fatalf("exported %d spans; want 11", got)
+	}
+	if v := e.span("/bad").Labels["huge"]; len(v) > maxLabelValueBytes || !strings.HasSuffix(v, labelTruncationSuffix) {
+		t.Errorf("oversized label is %d bytes; want at most %d, ending with %q", len(v), maxLabelValueBytes, labelTruncationSuffix)
+	}
+}
+
+func TestSetLabelLimits(t *testing.T) {
+	e := &recordingExporter{}
+	c := NewClientWithExporter(e)
+	var errs []error
+	c.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	c.SetLabelLimits(2, 20)
+
+	span := c.NewSpan("/span")
+	span.SetLabel("a", "short")
+	span.SetLabel("b", strings.Repeat("x", 30))
+	span.SetLabel("c", "dropped")
+	if err := span.FinishWait(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "short", "b": "xxxxxx" + labelTruncationSuffix}
+	if got := e.spans[0].Labels; !reflect.DeepEqual(got, want) {
+		t.Errorf("labels = %v; want %v", got, want)
+	}
+	if got, want := e.spans[0].LabelTruncatedBytes["b"], 24; got != want {
+		t.Errorf("LabelTruncatedBytes[b] = %d; want %d", got, want)
+	}
+	if len(errs) != 2 {
+		t.Errorf("got errors %v; want 2", errs)
+	}
+}